@@ -0,0 +1,253 @@
+// Package client is a Go client for the Trove HTTP API.
+//
+// It authenticates with either a personal API token or a session obtained
+// through Login, and wraps the versioned JSON API (/api/v1), the resumable
+// chunked upload API (/api/uploads) and file downloads.
+//
+//	c := client.New("https://trove.example.com")
+//	c.Token = os.Getenv("TROVE_TOKEN")
+//	listing, err := c.ListFiles(ctx, "/docs", nil)
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SessionCookieName is the cookie the server uses for login sessions.
+const SessionCookieName = "session_token"
+
+// Client is a Trove API client. Set Token or Session (or call Login) before
+// making requests. A Client is safe for concurrent use once configured.
+type Client struct {
+	// BaseURL is the server root, e.g. "https://trove.example.com".
+	BaseURL string
+	// Token is a personal API token, sent as a bearer token.
+	Token string
+	// Session is a login session cookie value, used when Token is empty.
+	Session string
+	// HTTPClient performs requests. It must not follow redirects, because the
+	// server answers unauthenticated requests with a redirect to /login.
+	HTTPClient *http.Client
+	// UserAgent is sent with every request.
+	UserAgent string
+}
+
+// New returns a client for the server at baseURL.
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		HTTPClient: &http.Client{
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		UserAgent: "trove-client",
+	}
+}
+
+// Error is returned for any non-2xx response.
+type Error struct {
+	StatusCode int
+	Code       string // Machine-readable code from /api/v1 responses, empty otherwise
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("trove: %s (%d %s)", e.Message, e.StatusCode, e.Code)
+	}
+	return fmt.Sprintf("trove: %s (%d)", e.Message, e.StatusCode)
+}
+
+// IsNotFound reports whether err is a 404 from the server.
+func IsNotFound(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// IsConflict reports whether err is a 409 from the server.
+func IsConflict(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict
+}
+
+// Login authenticates with a username and password and stores the resulting
+// session in c.Session.
+func (c *Client) Login(ctx context.Context, username, password string) error {
+	body, _ := json.Marshal(map[string]string{"username": username, "password": password})
+	req, err := c.newRequest(ctx, http.MethodPost, "/login", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == SessionCookieName && cookie.Value != "" {
+			c.Session = cookie.Value
+			return nil
+		}
+	}
+	return errors.New("trove: login succeeded but no session cookie was returned")
+}
+
+// newRequest builds an authenticated request for a path relative to BaseURL.
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+	switch {
+	case c.Token != "":
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	case c.Session != "":
+		req.AddCookie(&http.Cookie{Name: SessionCookieName, Value: c.Session})
+	}
+	return req, nil
+}
+
+// doJSON sends a request with an optional JSON body and decodes a JSON
+// response into out (when out is non-nil).
+func (c *Client) doJSON(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		payload, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(payload)
+	}
+	req, err := c.newRequest(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return responseError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("trove: decoding %s %s response: %w", method, path, err)
+	}
+	return nil
+}
+
+// responseError converts a non-2xx response into an *Error, using the
+// /api/v1 error body when there is one and the plain-text body otherwise.
+func responseError(resp *http.Response) error {
+	apiErr := &Error{StatusCode: resp.StatusCode}
+	if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		apiErr.StatusCode = http.StatusUnauthorized
+		apiErr.Message = "authentication required"
+		return apiErr
+	}
+
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var body struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(data, &body) == nil && body.Error.Message != "" {
+		apiErr.Code = body.Error.Code
+		apiErr.Message = body.Error.Message
+		return apiErr
+	}
+	apiErr.Message = strings.TrimSpace(string(data))
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+	return apiErr
+}
+
+// File is a stored file as returned by the API.
+type File struct {
+	ID               uint              `json:"id"`
+	Filename         string            `json:"filename"`
+	OriginalFilename string            `json:"original_filename"`
+	Folder           string            `json:"folder"`
+	Size             int64             `json:"size"`
+	MimeType         string            `json:"mime_type"`
+	Hash             string            `json:"hash"`
+	UploadStatus     string            `json:"upload_status"`
+	ErrorMessage     string            `json:"error_message,omitempty"`
+	TranscodeStatus  string            `json:"transcode_status"`
+	Tags             []string          `json:"tags"`
+	Metadata         map[string]string `json:"metadata"`
+	CreatedAt        time.Time         `json:"created_at"`
+	UpdatedAt        time.Time         `json:"updated_at"`
+	DeletedAt        *time.Time        `json:"deleted_at,omitempty"`
+	OriginalFolder   string            `json:"original_folder,omitempty"`
+}
+
+// Pagination describes one page of a list response.
+type Pagination struct {
+	Page       int   `json:"page"`
+	PerPage    int   `json:"per_page"`
+	Total      int64 `json:"total"`
+	TotalPages int   `json:"total_pages"`
+}
+
+// FolderListing is the content of one folder.
+type FolderListing struct {
+	Folder     string     `json:"folder"`
+	Folders    []string   `json:"folders"` // Immediate subfolder names; not paginated
+	Files      []File     `json:"files"`
+	Pagination Pagination `json:"pagination"`
+}
+
+// Folder is an explicitly created folder.
+type Folder struct {
+	Path      string    `json:"path"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ShareLink is a public link to a file or folder.
+type ShareLink struct {
+	Token             string     `json:"token"`
+	URL               string     `json:"url"` // Path relative to the server root
+	FileID            uint       `json:"file_id,omitempty"`
+	Folder            string     `json:"folder,omitempty"`
+	ExpiresAt         *time.Time `json:"expires_at,omitempty"`
+	MaxUses           *int       `json:"max_uses,omitempty"`
+	Uses              int        `json:"uses"`
+	PasswordProtected bool       `json:"password_protected"`
+	CreatedAt         time.Time  `json:"created_at"`
+}
+
+// Quota is the caller's storage usage in bytes.
+type Quota struct {
+	Used      int64 `json:"used"`
+	Quota     int64 `json:"quota"`
+	Available int64 `json:"available"`
+}
@@ -0,0 +1,341 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/go-chi/chi/v5"
+	"gorm.io/datatypes"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/agjmills/trove/client"
+	"github.com/agjmills/trove/internal/auth"
+	"github.com/agjmills/trove/internal/config"
+	"github.com/agjmills/trove/internal/database/models"
+	"github.com/agjmills/trove/internal/routes"
+	"github.com/agjmills/trove/internal/storage"
+)
+
+// newTestServer runs the full router against an in-memory database and
+// storage, with one user who owns an all-scopes token.
+func newTestServer(t *testing.T) (*httptest.Server, string) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	err = db.AutoMigrate(&models.User{}, &models.File{}, &models.FileVersion{}, &models.Blob{}, &models.Folder{}, &models.ShareLink{},
+		&models.FolderShareLink{}, &models.UploadSession{}, &models.APIToken{})
+	if err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	cfg := &config.Config{
+		BcryptCost:           4,
+		DefaultUserQuota:     100 * 1024 * 1024,
+		MaxUploadSize:        10 * 1024 * 1024,
+		SessionSecret:        "test-secret-key-32-bytes-long!!",
+		Env:                  "test",
+		TempDir:              t.TempDir(),
+		UploadSessionTimeout: time.Hour,
+	}
+	sessionManager := scs.New()
+	sessionManager.Lifetime = time.Hour
+	sessionManager.Cookie.Name = client.SessionCookieName
+
+	router := chi.NewRouter()
+	fileHandler, deletedHandler := routes.Setup(router, db, cfg, storage.NewMemoryBackend(), sessionManager, nil, "test")
+	srv := httptest.NewServer(router)
+	t.Cleanup(func() {
+		srv.Close()
+		fileHandler.Shutdown()
+		deletedHandler.Shutdown()
+	})
+
+	hash, err := auth.HashPassword("password123", cfg.BcryptCost)
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	user := &models.User{Username: "alice", Email: "alice@example.com", PasswordHash: hash, StorageQuota: cfg.DefaultUserQuota}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	plaintext, prefix, tokenHash, err := auth.GenerateAPIToken()
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	token := &models.APIToken{
+		UserID:      user.ID,
+		Name:        "test",
+		TokenHash:   tokenHash,
+		TokenPrefix: prefix,
+		Scopes:      datatypes.NewJSONType([]string{auth.ScopeFilesRead, auth.ScopeFilesWrite, auth.ScopeSharesManage}),
+	}
+	if err := db.Create(token).Error; err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+	return srv, plaintext
+}
+
+func TestLogin(t *testing.T) {
+	srv, _ := newTestServer(t)
+	ctx := context.Background()
+
+	c := client.New(srv.URL)
+	if _, err := c.Quota(ctx); err == nil {
+		t.Fatal("Expected unauthenticated request to fail")
+	}
+
+	if err := c.Login(ctx, "alice", "password123"); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if c.Session == "" {
+		t.Fatal("Expected a session cookie to be stored")
+	}
+	if _, err := c.Quota(ctx); err != nil {
+		t.Fatalf("Quota with session failed: %v", err)
+	}
+}
+
+func TestUploadResume(t *testing.T) {
+	srv, token := newTestServer(t)
+	c := client.New(srv.URL)
+	c.Token = token
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 256) // 4 KiB, four 1 KiB chunks
+
+	// Interrupt the upload while the second chunk is being sent
+	ctx, cancel := context.WithCancel(context.Background())
+	var uploadID string
+	_, err := c.Upload(ctx, bytes.NewReader(data), int64(len(data)), client.UploadOptions{
+		Filename:  "data.bin",
+		ChunkSize: 1024,
+		OnSession: func(id string) { uploadID = id },
+		Progress: func(done, _ int64) {
+			if done > 1024 {
+				cancel()
+			}
+		},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the upload to be canceled, got %v", err)
+	}
+	if uploadID == "" {
+		t.Fatal("Expected OnSession to report the upload ID")
+	}
+
+	var resumedFrom int64 = -1
+	result, err := c.Upload(context.Background(), bytes.NewReader(data), int64(len(data)), client.UploadOptions{
+		Filename:  "data.bin",
+		ChunkSize: 1024,
+		ResumeID:  uploadID,
+		OnSession: func(id string) {
+			if id != uploadID {
+				t.Errorf("Expected the upload to resume session %s, got %s", uploadID, id)
+			}
+		},
+		Progress: func(done, _ int64) {
+			if resumedFrom < 0 {
+				resumedFrom = done
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("Resumed upload failed: %v", err)
+	}
+	if resumedFrom < 1024 {
+		t.Errorf("Expected the first chunk to be skipped, resumed from %d", resumedFrom)
+	}
+	if result.Size != int64(len(data)) {
+		t.Errorf("Expected size %d, got %d", len(data), result.Size)
+	}
+
+	// A finished session cannot be resumed, so a stale ID starts over
+	again, err := c.Upload(context.Background(), bytes.NewReader(data), int64(len(data)), client.UploadOptions{
+		Filename: "copy.bin",
+		ResumeID: uploadID,
+	})
+	if err != nil {
+		t.Fatalf("Upload with stale resume ID failed: %v", err)
+	}
+	if again.FileID == result.FileID {
+		t.Error("Expected a new file for the stale resume ID")
+	}
+}
+
+func TestUploadConflict(t *testing.T) {
+	srv, token := newTestServer(t)
+	ctx := context.Background()
+	c := client.New(srv.URL)
+	c.Token = token
+
+	upload := func(content, conflict string) *client.UploadResult {
+		t.Helper()
+		result, err := c.Upload(ctx, strings.NewReader(content), int64(len(content)), client.UploadOptions{
+			Filename: "notes.txt",
+			Conflict: conflict,
+		})
+		if err != nil {
+			t.Fatalf("Upload with conflict %q failed: %v", conflict, err)
+		}
+		return result
+	}
+
+	first := upload("first", "")
+	if first.Action != "created" {
+		t.Fatalf("Expected the first upload to be created, got %q", first.Action)
+	}
+	if got := upload("second", client.ConflictKeepBoth); got.Action != "renamed" || got.Filename != "notes (1).txt" {
+		t.Errorf("Expected keep both to rename the upload, got %q as %q", got.Action, got.Filename)
+	}
+	if got := upload("third", client.ConflictSkip); got.Action != "skipped" || got.FileID != first.FileID {
+		t.Errorf("Expected the upload to be skipped for file %d, got %q for file %d", first.FileID, got.Action, got.FileID)
+	}
+	replaced := upload("fourth", client.ConflictReplace)
+	if replaced.Action != "replaced" || replaced.Filename != "notes.txt" {
+		t.Errorf("Expected replace to keep the name, got %q as %q", replaced.Action, replaced.Filename)
+	}
+	if _, err := c.GetFile(ctx, first.FileID); !client.IsNotFound(err) {
+		t.Errorf("Expected the replaced file to be trashed, got %v", err)
+	}
+}
+
+func TestFileOperations(t *testing.T) {
+	srv, token := newTestServer(t)
+	ctx := context.Background()
+	c := client.New(srv.URL)
+	c.Token = token
+
+	if _, err := c.CreateFolder(ctx, "/docs"); err != nil {
+		t.Fatalf("CreateFolder failed: %v", err)
+	}
+	if _, err := c.CreateFolder(ctx, "/docs"); !client.IsConflict(err) {
+		t.Fatalf("Expected a conflict creating an existing folder, got %v", err)
+	}
+
+	dir := t.TempDir()
+	local := filepath.Join(dir, "notes.txt")
+	content := bytes.Repeat([]byte("trove "), 1000)
+	if err := os.WriteFile(local, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	uploaded, err := c.UploadFile(ctx, local, client.UploadOptions{Folder: "/docs", ChunkSize: 1000})
+	if err != nil {
+		t.Fatalf("UploadFile failed: %v", err)
+	}
+	empty := filepath.Join(dir, "empty.txt")
+	if err := os.WriteFile(empty, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.UploadFile(ctx, empty, client.UploadOptions{Folder: "/docs"}); err != nil {
+		t.Fatalf("Uploading an empty file failed: %v", err)
+	}
+
+	listing, err := c.ListFiles(ctx, "/docs", &client.ListOptions{Sort: "filename"})
+	if err != nil {
+		t.Fatalf("ListFiles failed: %v", err)
+	}
+	if len(listing.Files) != 2 || listing.Files[0].Filename != "empty.txt" || listing.Files[1].Filename != "notes.txt" {
+		t.Fatalf("Unexpected listing: %+v", listing.Files)
+	}
+
+	// Leave part of the file behind as if a download had been interrupted
+	dest := filepath.Join(dir, "download.txt")
+	if err := os.WriteFile(dest+".part", content[:1500], 0644); err != nil {
+		t.Fatal(err)
+	}
+	var startedAt int64 = -1
+	err = c.DownloadFile(ctx, uploaded.FileID, dest, func(done, _ int64) {
+		if startedAt < 0 {
+			startedAt = done
+		}
+	})
+	if err != nil {
+		t.Fatalf("DownloadFile failed: %v", err)
+	}
+	if startedAt != 1500 {
+		t.Errorf("Expected the download to resume at 1500, started at %d", startedAt)
+	}
+	got, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("Downloaded content does not match")
+	}
+	if _, err := os.Stat(dest + ".part"); !os.IsNotExist(err) {
+		t.Error("Expected the partial file to be renamed")
+	}
+
+	moved, err := c.MoveFile(ctx, uploaded.FileID, "renamed.txt", "/")
+	if err != nil {
+		t.Fatalf("MoveFile failed: %v", err)
+	}
+	if moved.Filename != "renamed.txt" || moved.Folder != "/" {
+		t.Errorf("Unexpected file after move: %+v", moved)
+	}
+
+	link, err := c.CreateShare(ctx, uploaded.FileID, client.ShareOptions{})
+	if err != nil {
+		t.Fatalf("CreateShare failed: %v", err)
+	}
+	if link.URL == "" {
+		t.Error("Expected a share URL")
+	}
+
+	quota, err := c.Quota(ctx)
+	if err != nil {
+		t.Fatalf("Quota failed: %v", err)
+	}
+	if quota.Used != int64(len(content)) {
+		t.Errorf("Expected %d bytes used, got %d", len(content), quota.Used)
+	}
+
+	if err := c.DeleteFile(ctx, uploaded.FileID); err != nil {
+		t.Fatalf("DeleteFile failed: %v", err)
+	}
+	if _, err := c.GetFile(ctx, uploaded.FileID); !client.IsNotFound(err) {
+		t.Errorf("Expected deleted file to be not found, got %v", err)
+	}
+}
+
+func TestDownloadFollowsStorageRedirect(t *testing.T) {
+	content := "presigned content"
+	store := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			http.Error(w, "credentials sent to storage", http.StatusBadRequest)
+			return
+		}
+		http.ServeContent(w, r, "file.txt", time.Time{}, strings.NewReader(content))
+	}))
+	defer store.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, store.URL+"/bucket/key?X-Amz-Signature=abc", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	c := client.New(srv.URL)
+	c.Token = "token"
+	stream, err := c.Download(context.Background(), 1, 10)
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	defer stream.Body.Close() //nolint:errcheck
+	got, _ := io.ReadAll(stream.Body)
+	if stream.Offset != 10 || stream.Size != int64(len(content)) || string(got) != content[10:] {
+		t.Errorf("Unexpected stream at %d of %d: %q", stream.Offset, stream.Size, got)
+	}
+}
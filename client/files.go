@@ -0,0 +1,121 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// ListOptions controls paging and ordering of ListFiles.
+type ListOptions struct {
+	Page    int    // From 1; 0 means the first page
+	PerPage int    // 0 means the server default (50), max 200
+	Sort    string // "filename", "size" or "created_at"
+	Order   string // "asc" or "desc"
+}
+
+// ListFiles lists the subfolders and one page of files in folder.
+func (c *Client) ListFiles(ctx context.Context, folder string, opts *ListOptions) (*FolderListing, error) {
+	q := url.Values{}
+	if folder != "" {
+		q.Set("folder", folder)
+	}
+	if opts != nil {
+		if opts.Page > 0 {
+			q.Set("page", strconv.Itoa(opts.Page))
+		}
+		if opts.PerPage > 0 {
+			q.Set("per_page", strconv.Itoa(opts.PerPage))
+		}
+		if opts.Sort != "" {
+			q.Set("sort", opts.Sort)
+		}
+		if opts.Order != "" {
+			q.Set("order", opts.Order)
+		}
+	}
+	var listing FolderListing
+	if err := c.doJSON(ctx, http.MethodGet, "/api/v1/files?"+q.Encode(), nil, &listing); err != nil {
+		return nil, err
+	}
+	return &listing, nil
+}
+
+// GetFile returns a file's metadata.
+func (c *Client) GetFile(ctx context.Context, id uint) (*File, error) {
+	var file File
+	if err := c.doJSON(ctx, http.MethodGet, fmt.Sprintf("/api/v1/files/%d", id), nil, &file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// MoveFile renames and/or moves a file. Empty arguments are left unchanged.
+func (c *Client) MoveFile(ctx context.Context, id uint, name, folder string) (*File, error) {
+	req := map[string]string{}
+	if name != "" {
+		req["name"] = name
+	}
+	if folder != "" {
+		req["folder"] = folder
+	}
+	var file File
+	if err := c.doJSON(ctx, http.MethodPatch, fmt.Sprintf("/api/v1/files/%d", id), req, &file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// DeleteFile moves a file to deleted items.
+func (c *Client) DeleteFile(ctx context.Context, id uint) error {
+	return c.doJSON(ctx, http.MethodDelete, fmt.Sprintf("/api/v1/files/%d", id), nil, nil)
+}
+
+// CreateFolder creates a folder; its parent must already exist.
+func (c *Client) CreateFolder(ctx context.Context, path string) (*Folder, error) {
+	var folder Folder
+	if err := c.doJSON(ctx, http.MethodPost, "/api/v1/folders", map[string]string{"path": path}, &folder); err != nil {
+		return nil, err
+	}
+	return &folder, nil
+}
+
+// ShareOptions restricts a share link. The zero value creates an unrestricted link.
+type ShareOptions struct {
+	ExpiresAt string `json:"expires_at,omitempty"` // YYYY-MM-DD, expires at the end of that day (UTC)
+	MaxUses   *int   `json:"max_uses,omitempty"`
+	Password  string `json:"password,omitempty"`
+}
+
+// CreateShare creates a public share link for a file.
+func (c *Client) CreateShare(ctx context.Context, fileID uint, opts ShareOptions) (*ShareLink, error) {
+	var link ShareLink
+	if err := c.doJSON(ctx, http.MethodPost, fmt.Sprintf("/api/v1/files/%d/shares", fileID), opts, &link); err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// CreateFolderShare creates a public share link for a folder.
+func (c *Client) CreateFolderShare(ctx context.Context, folder string, opts ShareOptions) (*ShareLink, error) {
+	req := struct {
+		Folder string `json:"folder"`
+		ShareOptions
+	}{folder, opts}
+	var link ShareLink
+	if err := c.doJSON(ctx, http.MethodPost, "/api/v1/folder-shares", req, &link); err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// Quota returns the caller's storage usage.
+func (c *Client) Quota(ctx context.Context) (*Quota, error) {
+	var quota Quota
+	if err := c.doJSON(ctx, http.MethodGet, "/api/v1/quota", nil, &quota); err != nil {
+		return nil, err
+	}
+	return &quota, nil
+}
@@ -0,0 +1,405 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultChunkSize is the chunk size used by Upload when none is given.
+const DefaultChunkSize = 8 << 20
+
+// chunkAttempts is how many times a failed chunk is sent before giving up.
+const chunkAttempts = 3
+
+// ProgressFunc is called as data is transferred with the bytes done so far
+// and the total. Resumed transfers start from the bytes already on the
+// other side.
+type ProgressFunc func(done, total int64)
+
+// UploadOptions configures Upload.
+type UploadOptions struct {
+	Folder    string   // Destination folder, default "/"
+	Filename  string   // Name to store the file under (required for Upload)
+	MimeType  string   // Content type, guessed from Filename when empty
+	Tags      []string // Tags to apply to the new file
+	ChunkSize int64    // Bytes per chunk, default DefaultChunkSize
+
+	// Conflict is what to do when the folder already has a file named
+	// Filename: ConflictReplace, ConflictKeepBoth or ConflictSkip. By default
+	// the upload becomes a new version of that file if the user has versioning
+	// enabled, and is stored under a new name otherwise.
+	Conflict string
+
+	// ResumeID continues an earlier upload session, skipping chunks the
+	// server already has. When the session no longer exists (it completed,
+	// was canceled or expired) a new one is started.
+	ResumeID string
+	// OnSession is called with the upload session ID as soon as it is known,
+	// so callers can persist it and pass it back as ResumeID after an
+	// interruption.
+	OnSession func(uploadID string)
+	Progress  ProgressFunc
+}
+
+// Conflict policies for UploadOptions.Conflict.
+const (
+	ConflictReplace  = "replace"   // Move the existing file to deleted items (or add a version)
+	ConflictKeepBoth = "keep_both" // Store the upload as "name (1).ext"
+	ConflictSkip     = "skip"      // Keep the existing file and send nothing
+)
+
+// UploadResult describes a completed upload. For a skipped upload it
+// describes the existing file.
+type UploadResult struct {
+	FileID   uint   `json:"file_id"`
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+	Hash     string `json:"hash"`
+	Version  int    `json:"version"`
+	// Action is what the upload did: "created", "renamed", "replaced",
+	// "versioned", "unchanged" or "skipped".
+	Action string `json:"action"`
+}
+
+type uploadStatus struct {
+	UploadID       string `json:"upload_id"`
+	Status         string `json:"status"`
+	TotalChunks    int    `json:"total_chunks"`
+	ChunksReceived []int  `json:"chunks_received"`
+}
+
+// UploadFile uploads a local file. opts.Filename defaults to the file's base name.
+func (c *Client) UploadFile(ctx context.Context, path string, opts UploadOptions) (*UploadResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() //nolint:errcheck
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Filename == "" {
+		opts.Filename = filepath.Base(path)
+	}
+	return c.Upload(ctx, f, info.Size(), opts)
+}
+
+// Upload sends size bytes from r through the resumable chunked upload API.
+// The content is hashed first so the server can verify the assembled file.
+func (c *Client) Upload(ctx context.Context, r io.ReaderAt, size int64, opts UploadOptions) (*UploadResult, error) {
+	if opts.Filename == "" {
+		return nil, errors.New("trove: upload filename is required")
+	}
+	if opts.MimeType == "" {
+		opts.MimeType = mime.TypeByExtension(filepath.Ext(opts.Filename))
+		if opts.MimeType == "" {
+			opts.MimeType = "application/octet-stream"
+		}
+	}
+	chunkSize := opts.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	totalChunks := int((size + chunkSize - 1) / chunkSize)
+	if totalChunks == 0 {
+		totalChunks = 1 // An empty file is a single empty chunk
+	}
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, io.NewSectionReader(r, 0, size)); err != nil {
+		return nil, fmt.Errorf("trove: hashing upload: %w", err)
+	}
+	hash := hex.EncodeToString(hasher.Sum(nil))
+
+	received := map[int]bool{}
+	uploadID := ""
+	if opts.ResumeID != "" {
+		var status uploadStatus
+		err := c.doJSON(ctx, http.MethodGet, "/api/uploads/"+opts.ResumeID+"/status", nil, &status)
+		switch {
+		case err == nil && status.Status == "active" && status.TotalChunks == totalChunks:
+			uploadID = status.UploadID
+			for _, n := range status.ChunksReceived {
+				received[n] = true
+			}
+		case err != nil && !IsNotFound(err):
+			return nil, err
+		}
+	}
+
+	if uploadID == "" {
+		init := map[string]any{
+			"filename":     opts.Filename,
+			"total_size":   size,
+			"chunk_size":   chunkSize,
+			"total_chunks": totalChunks,
+			"logical_path": opts.Folder,
+			"mime_type":    opts.MimeType,
+			"hash":         hash,
+			"tags":         opts.Tags,
+		}
+		if opts.Conflict != "" {
+			init["on_conflict"] = opts.Conflict
+		}
+		var resp struct {
+			UploadID string `json:"upload_id"`
+			UploadResult
+		}
+		if err := c.doJSON(ctx, http.MethodPost, "/api/uploads/init", init, &resp); err != nil {
+			return nil, err
+		}
+		// A skipped upload has no session; nothing is sent
+		if resp.UploadID == "" && resp.Action == "skipped" {
+			return &resp.UploadResult, nil
+		}
+		uploadID = resp.UploadID
+	}
+	if opts.OnSession != nil {
+		opts.OnSession(uploadID)
+	}
+
+	var done int64
+	for i := range totalChunks {
+		if received[i] {
+			done += min(chunkSize, size-int64(i)*chunkSize)
+		}
+	}
+	if opts.Progress != nil {
+		opts.Progress(done, size)
+	}
+
+	for i := range totalChunks {
+		if received[i] {
+			continue
+		}
+		offset := int64(i) * chunkSize
+		length := min(chunkSize, size-offset)
+		if err := c.sendChunk(ctx, uploadID, i, io.NewSectionReader(r, offset, length), done, size, opts.Progress); err != nil {
+			return nil, err
+		}
+		done += length
+	}
+
+	var result UploadResult
+	if err := c.doJSON(ctx, http.MethodPost, "/api/uploads/"+uploadID+"/complete", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// sendChunk uploads one chunk, retrying transient failures.
+func (c *Client) sendChunk(ctx context.Context, uploadID string, n int, chunk *io.SectionReader, done, total int64, progress ProgressFunc) error {
+	var lastErr error
+	for attempt := range chunkAttempts {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(attempt) * time.Second):
+			}
+		}
+		if _, err := chunk.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		var body io.Reader = chunk
+		if progress != nil {
+			body = &progressReader{r: chunk, done: done, total: total, progress: progress}
+		}
+		req, err := c.newRequest(ctx, http.MethodPost, fmt.Sprintf("/api/uploads/%s/chunk?chunk=%d", uploadID, n), body)
+		if err != nil {
+			return err
+		}
+		req.ContentLength = chunk.Size()
+		req.Header.Set("Content-Type", "application/octet-stream")
+
+		resp, err := c.HTTPClient.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			lastErr = err
+			continue
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+		lastErr = responseError(resp)
+		if resp.StatusCode < 500 {
+			return lastErr
+		}
+	}
+	return fmt.Errorf("trove: uploading chunk %d: %w", n, lastErr)
+}
+
+// DownloadStream is an open download. Body yields the file from Offset to the end.
+type DownloadStream struct {
+	Body   io.ReadCloser
+	Offset int64 // Where Body starts; 0 if the server sent the whole file
+	Size   int64 // Full size of the file
+}
+
+// Download opens a file's content starting at offset, so an interrupted
+// download can be continued. Check Offset on the result: it is 0 if the
+// server sent the whole file instead of the requested range.
+func (c *Client) Download(ctx context.Context, id uint, offset int64) (*DownloadStream, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, fmt.Sprintf("/download/%d", id), nil)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if location := storageRedirect(resp); location != "" {
+		// The server hands the download to its storage service. The URL
+		// carries its own authorization, so credentials are not sent on.
+		_ = resp.Body.Close()
+		if req, err = http.NewRequestWithContext(ctx, http.MethodGet, location, nil); err != nil {
+			return nil, err
+		}
+		if c.UserAgent != "" {
+			req.Header.Set("User-Agent", c.UserAgent)
+		}
+		if offset > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
+		if resp, err = c.HTTPClient.Do(req); err != nil {
+			return nil, err
+		}
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return &DownloadStream{Body: resp.Body, Size: resp.ContentLength}, nil
+	case http.StatusPartialContent:
+		stream := &DownloadStream{Body: resp.Body, Offset: offset, Size: -1}
+		if _, total, ok := strings.Cut(resp.Header.Get("Content-Range"), "/"); ok {
+			if n, err := strconv.ParseInt(total, 10, 64); err == nil {
+				stream.Size = n
+			}
+		}
+		return stream, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		// Nothing left to send: the offset is already at the end of the file
+		_ = resp.Body.Close()
+		return &DownloadStream{Body: io.NopCloser(strings.NewReader("")), Offset: offset, Size: offset}, nil
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	return nil, responseError(resp)
+}
+
+// storageRedirect returns the URL a download response redirects to when the
+// server uses presigned downloads, or "". Those redirects are absolute,
+// unlike the redirect to the login page.
+func storageRedirect(resp *http.Response) string {
+	if resp.StatusCode != http.StatusFound {
+		return ""
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || !location.IsAbs() {
+		return ""
+	}
+	return location.String()
+}
+
+// DownloadFile saves a file to dest. Data is written to dest + ".part" and
+// renamed once complete and verified against the file's SHA-256 hash; if a
+// .part file is left by an interrupted download, it is resumed.
+func (c *Client) DownloadFile(ctx context.Context, id uint, dest string, progress ProgressFunc) error {
+	meta, err := c.GetFile(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	partPath := dest + ".part"
+	part, err := os.OpenFile(partPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer part.Close() //nolint:errcheck
+
+	offset, err := part.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if offset > meta.Size {
+		offset = 0 // Stale partial file from something else; start over
+	}
+
+	stream, err := c.Download(ctx, id, offset)
+	if err != nil {
+		return err
+	}
+	defer stream.Body.Close() //nolint:errcheck
+
+	if err := part.Truncate(stream.Offset); err != nil {
+		return err
+	}
+	if _, err := part.Seek(stream.Offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	var body io.Reader = stream.Body
+	if progress != nil {
+		progress(stream.Offset, meta.Size)
+		body = &progressReader{r: stream.Body, done: stream.Offset, total: meta.Size, progress: progress}
+	}
+	if _, err := io.Copy(part, body); err != nil {
+		return err
+	}
+
+	if meta.Hash != "" {
+		if _, err := part.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		hasher := sha256.New()
+		if _, err := io.Copy(hasher, part); err != nil {
+			return err
+		}
+		if got := hex.EncodeToString(hasher.Sum(nil)); got != meta.Hash {
+			_ = os.Remove(partPath)
+			return fmt.Errorf("trove: downloaded data does not match the file hash (got %s, want %s)", got, meta.Hash)
+		}
+	}
+	if err := part.Close(); err != nil {
+		return err
+	}
+	return os.Rename(partPath, dest)
+}
+
+// progressReader reports bytes read through a ProgressFunc.
+type progressReader struct {
+	r        io.Reader
+	done     int64
+	total    int64
+	progress ProgressFunc
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.done += int64(n)
+		p.progress(p.done, p.total)
+	}
+	return n, err
+}
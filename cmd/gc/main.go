@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/agjmills/trove/internal/config"
+	"github.com/agjmills/trove/internal/database"
+	"github.com/agjmills/trove/internal/gc"
+	"github.com/agjmills/trove/internal/logger"
+	"github.com/agjmills/trove/internal/storage"
+	"github.com/agjmills/trove/internal/templateutil"
+)
+
+// The gc command deletes orphaned objects from the configured storage
+// backend: objects no file references, left behind when a delete failed.
+// Objects modified within the grace period (GC_GRACE_PERIOD) are kept, as
+// they may belong to an upload still being processed. The server runs the
+// same collection every GC_INTERVAL; this command is for running it by
+// hand, or for checking what it would delete first.
+//
+// Usage:
+//
+//	trove-gc -n   # list orphaned objects without deleting them
+//	trove-gc      # delete orphaned objects
+var (
+	version = "dev"
+	commit  = "none"
+	date    = "unknown"
+)
+
+func main() {
+	dryRun := flag.Bool("n", false, "list orphaned objects without deleting them")
+	grace := flag.Duration("grace", 0, "keep unreferenced objects modified more recently than this (default GC_GRACE_PERIOD)")
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	logger.Init(cfg.Env)
+
+	if *grace <= 0 {
+		*grace = cfg.GCGracePeriod
+	}
+
+	db, err := database.Connect(cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	if err := database.Migrate(db); err != nil {
+		log.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	storageService, err := storage.NewBackendFromConfig(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize storage backend: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger.Info("garbage collection starting",
+		"version", fmt.Sprintf("%s (commit: %s, built: %s)", version, commit, date),
+		"backend", cfg.StorageBackend,
+		"grace_period", grace.String(),
+		"dry_run", *dryRun,
+	)
+
+	report, err := gc.New(db, storageService, *grace).Run(ctx, *dryRun)
+	if errors.Is(err, storage.ErrListUnsupported) {
+		log.Fatal("The storage backend cannot list its objects")
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *dryRun {
+		for _, orphan := range report.Orphans {
+			fmt.Printf("%s\t%s\t%s\n", orphan.ModTime.Format("2006-01-02 15:04"), templateutil.FormatBytes(orphan.Size), orphan.Path)
+		}
+	}
+	fmt.Printf("Objects in storage:      %d (%s)\n", report.Scanned, templateutil.FormatBytes(report.ScannedBytes))
+	fmt.Printf("Orphaned:                %d (%s)\n", len(report.Orphans), templateutil.FormatBytes(report.OrphanBytes))
+	fmt.Printf("Orphaned, within grace:  %d\n", report.Recent)
+	if !*dryRun {
+		fmt.Printf("Deleted:                 %d (%s)\n", report.Deleted, templateutil.FormatBytes(report.DeletedBytes))
+		fmt.Printf("Stale uploads aborted:   %d\n", report.AbortedUploads)
+		if report.Failed > 0 {
+			log.Fatalf("%d objects could not be deleted; see the errors above", report.Failed)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/agjmills/trove/internal/config"
+	"github.com/agjmills/trove/internal/database"
+	"github.com/agjmills/trove/internal/logger"
+	"github.com/agjmills/trove/internal/migrate"
+	"github.com/agjmills/trove/internal/storage"
+	"github.com/agjmills/trove/internal/templateutil"
+)
+
+// The migrate command copies every stored object from the configured
+// storage backend (STORAGE_BACKEND and friends) to another one, verifying
+// each copy by SHA-256. Copies are recorded in a state file, so an
+// interrupted run resumes where it stopped. Copying can run while Trove is
+// serving; stop Trove for -finalize, which copies anything uploaded since
+// and points the database at the copies.
+//
+// Usage:
+//
+//	trove-migrate -to s3 -to-bucket trove -n        # summarize what would be copied
+//	trove-migrate -to s3 -to-bucket trove           # copy objects (resumable)
+//	trove-migrate -to s3 -to-bucket trove -finalize # copy the rest and rewrite paths
+var (
+	version = "dev"
+	commit  = "none"
+	date    = "unknown"
+)
+
+func main() {
+	to := flag.String("to", "", "destination backend: disk or s3")
+	toPath := flag.String("to-path", "", "destination directory (disk)")
+	toBucket := flag.String("to-bucket", "", "destination bucket (s3)")
+	toPathStyle := flag.Bool("to-path-style", false, "use path-style addressing for the destination (s3)")
+	workers := flag.Int("workers", 4, "objects to copy in parallel")
+	statePath := flag.String("state", "trove-migrate.state", "file recording copied objects, used to resume")
+	dryRun := flag.Bool("n", false, "print a summary of objects and bytes to copy, then exit")
+	finalize := flag.Bool("finalize", false, "after copying, point the database at the copies (stop Trove first)")
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	logger.Init(cfg.Env)
+
+	// The destination shares everything but its location with the source,
+	// including encryption keys
+	dstCfg := *cfg
+	switch *to {
+	case "disk":
+		if *toPath == "" {
+			log.Fatal("-to-path is required for a disk destination")
+		}
+		dstCfg.StorageBackend, dstCfg.StoragePath = "disk", *toPath
+	case "s3":
+		if *toBucket == "" {
+			log.Fatal("-to-bucket is required for an s3 destination")
+		}
+		dstCfg.StorageBackend, dstCfg.S3Bucket, dstCfg.S3UsePathStyle = "s3", *toBucket, *toPathStyle
+	default:
+		log.Fatal("-to must be disk or s3")
+	}
+	if dstCfg.StorageBackend == cfg.StorageBackend && dstCfg.StoragePath == cfg.StoragePath && dstCfg.S3Bucket == cfg.S3Bucket {
+		log.Fatal("Source and destination are the same")
+	}
+
+	db, err := database.Connect(cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	if err := database.Migrate(db); err != nil {
+		log.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	src, err := storage.NewBackendFromConfig(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize source storage backend: %v", err)
+	}
+	dst, err := storage.NewBackendFromConfig(&dstCfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize destination storage backend: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if !*dryRun {
+		if err := src.ValidateAccess(ctx); err != nil {
+			log.Fatalf("Source storage backend failed access validation: %v", err)
+		}
+		if err := dst.ValidateAccess(ctx); err != nil {
+			log.Fatalf("Destination storage backend failed access validation: %v", err)
+		}
+	}
+
+	state, err := migrate.OpenState(*statePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer state.Close() //nolint:errcheck
+
+	m := migrate.New(db, src, dst, state, *workers)
+
+	if *dryRun {
+		summary, _, err := m.Plan()
+		if err != nil {
+			log.Fatal(err)
+		}
+		objects, bytes := summary.Remaining()
+		fmt.Printf("Objects referenced: %d (%s)\n", summary.Objects, templateutil.FormatBytes(summary.Bytes))
+		fmt.Printf("Already copied:     %d (%s)\n", summary.Done, templateutil.FormatBytes(summary.DoneBytes))
+		fmt.Printf("To copy:            %d (%s)\n", objects, templateutil.FormatBytes(bytes))
+		return
+	}
+
+	logger.Info("migration starting",
+		"version", fmt.Sprintf("%s (commit: %s, built: %s)", version, commit, date),
+		"from", cfg.StorageBackend,
+		"to", dstCfg.StorageBackend,
+		"workers", *workers,
+	)
+
+	summary, err := m.Copy(ctx)
+	if err != nil {
+		logger.Error("migration interrupted", "error", err)
+		os.Exit(1)
+	}
+	objects, bytes := summary.Remaining()
+	logger.Info("copy complete",
+		"copied", summary.Copied,
+		"copied_bytes", summary.CopiedBytes,
+		"already_copied", summary.Done,
+		"failed", summary.Failed,
+		"remaining_objects", objects,
+		"remaining_bytes", bytes,
+	)
+	if summary.Failed > 0 {
+		log.Fatalf("%d objects failed to copy; fix the errors above and run again", summary.Failed)
+	}
+
+	if *finalize {
+		count, err := m.Rewrite()
+		if err != nil {
+			log.Fatalf("Failed to rewrite storage paths: %v", err)
+		}
+		logger.Info("storage paths rewritten", "objects", count)
+		fmt.Printf("Done. Set STORAGE_BACKEND=%s and start Trove.\n", dstCfg.StorageBackend)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"gorm.io/gorm"
+
+	"github.com/agjmills/trove/internal/config"
+	"github.com/agjmills/trove/internal/database"
+	"github.com/agjmills/trove/internal/logger"
+	"github.com/agjmills/trove/internal/storage"
+)
+
+// The rewrap command brings every stored object onto the active encryption
+// key (ENCRYPTION_ACTIVE_KEY). Objects wrapped with an older key get their
+// data key re-wrapped without re-encrypting the content, and objects stored
+// before encryption was enabled are encrypted. Once it finishes without
+// errors, old keys can be removed from ENCRYPTION_KEYS.
+//
+// Usage:
+//
+//	trove-rewrap      # rewrap all objects
+//	trove-rewrap -n   # only report how many objects need rewrapping
+var (
+	version = "dev"
+	commit  = "none"
+	date    = "unknown"
+)
+
+func main() {
+	dryRun := flag.Bool("n", false, "report objects that need rewrapping without changing them")
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	logger.Init(cfg.Env)
+
+	if cfg.EncryptionKeys == "" {
+		log.Fatal("ENCRYPTION_KEYS is not set; there is nothing to rewrap")
+	}
+
+	db, err := database.Connect(cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	if err := database.Migrate(db); err != nil {
+		log.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	storageService, err := storage.NewBackendFromConfig(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize storage backend: %v", err)
+	}
+	encrypted, ok := storageService.(*storage.EncryptedBackend)
+	if !ok {
+		log.Fatal("Storage backend is not encrypted")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger.Info("rewrap starting",
+		"version", fmt.Sprintf("%s (commit: %s, built: %s)", version, commit, date),
+		"active_key", encrypted.Keys().ActiveKeyID(),
+		"dry_run", *dryRun,
+	)
+
+	objects, err := database.StoredObjects(db)
+	if err != nil {
+		log.Fatalf("Failed to list stored objects: %v", err)
+	}
+
+	var rewrapped, current, failed int
+	for _, object := range objects {
+		if ctx.Err() != nil {
+			break
+		}
+		path := object.Path
+		if *dryRun {
+			keyID, err := encrypted.KeyID(ctx, path)
+			if err != nil {
+				logger.Error("failed to read object", "path", path, "error", err)
+				failed++
+			} else if keyID == encrypted.Keys().ActiveKeyID() {
+				current++
+			} else {
+				logger.Info("needs rewrap", "path", path, "key", keyID)
+				rewrapped++
+			}
+			continue
+		}
+
+		newPath, err := encrypted.Rewrap(ctx, path)
+		if err != nil {
+			logger.Error("failed to rewrap object", "path", path, "error", err)
+			failed++
+			continue
+		}
+		if newPath == path {
+			current++
+			continue
+		}
+		if err := repoint(ctx, db, encrypted, path, newPath); err != nil {
+			logger.Error("failed to update references", "path", path, "new_path", newPath, "error", err)
+			failed++
+			continue
+		}
+		rewrapped++
+	}
+
+	logger.Info("rewrap complete", "rewrapped", rewrapped, "already_current", current, "failed", failed, "dry_run", *dryRun)
+	if failed > 0 || ctx.Err() != nil {
+		os.Exit(1)
+	}
+}
+
+// repoint moves every reference from oldPath to newPath and deletes the old
+// object. An upload deduplicated against oldPath while the object was being
+// copied would still reference it, so references are moved again after the
+// delete; such a file is unreadable only for that short window.
+func repoint(ctx context.Context, db *gorm.DB, backend storage.StorageBackend, oldPath, newPath string) error {
+	if err := database.ReplaceStoragePath(db, oldPath, newPath); err != nil {
+		// Keep the old object and drop the copy
+		_ = backend.Delete(ctx, newPath)
+		return err
+	}
+	if err := backend.Delete(ctx, oldPath); err != nil {
+		logger.Warn("failed to delete old object", "path", oldPath, "error", err)
+	}
+	return database.ReplaceStoragePath(db, oldPath, newPath)
+}
@@ -16,12 +16,17 @@ import (
 	"github.com/agjmills/trove/internal/auth"
 	"github.com/agjmills/trove/internal/config"
 	"github.com/agjmills/trove/internal/database"
+	"github.com/agjmills/trove/internal/diskspace"
+	"github.com/agjmills/trove/internal/gc"
 	"github.com/agjmills/trove/internal/handlers"
 	"github.com/agjmills/trove/internal/logger"
 	internalMiddleware "github.com/agjmills/trove/internal/middleware"
+	"github.com/agjmills/trove/internal/mirror"
 	"github.com/agjmills/trove/internal/oidc"
 	"github.com/agjmills/trove/internal/routes"
+	"github.com/agjmills/trove/internal/scrub"
 	"github.com/agjmills/trove/internal/storage"
+	"github.com/agjmills/trove/internal/tiering"
 )
 
 var (
@@ -30,6 +35,10 @@ var (
 	date    = "unknown"
 )
 
+// writeTimeout is the longest a response, such as a large download, may
+// take to write.
+const writeTimeout = 10 * time.Minute
+
 func main() {
 	cfg, err := config.Load()
 	if err != nil {
@@ -67,6 +76,33 @@ func main() {
 		log.Fatalf("Failed to initialize storage backend: %v", err)
 	}
 
+	// Record which members of mirrored storage are missing objects
+	mirrored, isMirrored := storage.AsMirrored(storageService)
+	if isMirrored {
+		mirrored.SetReplicaLog(mirror.NewLog(db))
+	}
+
+	// Move objects stored in an earlier storage layout; they stay readable
+	// meanwhile, and a failure is retried on the next start
+	if moved, err := storage.MigrateLayout(context.Background(), storageService); err != nil {
+		logger.Error("failed to migrate storage layout", "error", err, "moved", moved)
+	} else if moved > 0 {
+		logger.Info("migrated storage layout", "objects", moved)
+	}
+
+	// Share objects stored before blobs were tracked; a failure is
+	// retried on the next start
+	if backfill, err := database.BackfillBlobs(context.Background(), db, storageService, cfg.EnableFileDeduplication); err != nil {
+		logger.Error("failed to backfill deduplication blobs", "error", err)
+	} else if backfill.Blobs > 0 {
+		logger.Info("backfilled deduplication blobs",
+			"blobs", backfill.Blobs,
+			"collapsed", backfill.Collapsed,
+			"deleted", backfill.Deleted,
+			"reclaimed_bytes", backfill.ReclaimedBytes,
+		)
+	}
+
 	sessionManager, err := auth.NewSessionManager(db, cfg)
 	if err != nil {
 		log.Fatalf("Failed to initialize session manager: %v", err)
@@ -92,6 +128,11 @@ func main() {
 	versionInfo := fmt.Sprintf("%s (commit: %s, built: %s)", version, commit, date)
 	fileHandler, deletedHandler := routes.Setup(r, db, cfg, storageService, sessionManager, oidcProvider, versionInfo)
 
+	// Pick up archive extractions interrupted by the last shutdown
+	if err := fileHandler.ResumeExtractions(); err != nil {
+		logger.Error("failed to resume archive extractions", "error", err)
+	}
+
 	// Start upload session cleanup worker
 	uploadCleanupTicker := time.NewTicker(1 * time.Hour)
 	uploadCleanupDone := make(chan struct{})
@@ -116,6 +157,71 @@ func main() {
 		}
 	}()
 
+	// Start storage integrity scrub worker (scheduled and admin-triggered runs)
+	scrubCtx, stopScrub := context.WithCancel(context.Background())
+	scrubDone := make(chan struct{})
+	scrubWorker := scrub.NewWorker(db, storageService, cfg.ScrubInterval, cfg.ScrubRateLimit)
+	go func() {
+		defer close(scrubDone)
+		scrubWorker.Run(scrubCtx, false)
+	}()
+
+	// Start orphaned object garbage collection
+	gcCtx, stopGC := context.WithCancel(context.Background())
+	gcDone := make(chan struct{})
+	go func() {
+		defer close(gcDone)
+		if cfg.GCInterval <= 0 {
+			return
+		}
+		if _, ok := storageService.(storage.Lister); !ok {
+			logger.Warn("storage backend cannot list objects, garbage collection disabled")
+			return
+		}
+		gc.New(db, storageService, cfg.GCGracePeriod).Schedule(gcCtx, cfg.GCInterval)
+	}()
+
+	// Start mirror repair, which copies objects to members missing them
+	mirrorCtx, stopMirror := context.WithCancel(context.Background())
+	mirrorDone := make(chan struct{})
+	go func() {
+		defer close(mirrorDone)
+		if !isMirrored || cfg.MirrorRepairInterval <= 0 {
+			return
+		}
+		mirror.NewRepairer(db, mirrored).Schedule(mirrorCtx, cfg.MirrorRepairInterval)
+	}()
+
+	// Start storage tiering, which moves objects that are not read to the
+	// cold storage policy
+	tieringCtx, stopTiering := context.WithCancel(context.Background())
+	tieringDone := make(chan struct{})
+	go func() {
+		defer close(tieringDone)
+		if cfg.TieringColdPolicy == "" || cfg.TieringInterval <= 0 {
+			return
+		}
+		tierer, err := tiering.New(db, storageService, tiering.Config{
+			Hot:     cfg.TieringHotPolicy,
+			Cold:    cfg.TieringColdPolicy,
+			After:   time.Duration(cfg.TieringAfterDays) * 24 * time.Hour,
+			Promote: cfg.TieringPromoteOnAccess,
+			// Keep moved objects for downloads and presigned URLs
+			// handed out before the move
+			DrainDelay: max(writeTimeout, cfg.S3PresignExpiry),
+		})
+		if err != nil {
+			logger.Error("storage tiering disabled", "error", err)
+			return
+		}
+		tierer.Schedule(tieringCtx, cfg.TieringInterval)
+	}()
+
+	// Record free space on the volumes uploads are written to, warning when
+	// one falls below its minimum
+	diskCtx, stopDiskWatch := context.WithCancel(context.Background())
+	go diskspace.Watch(diskCtx, diskspace.Volumes(cfg), time.Minute)
+
 	addr := fmt.Sprintf("%s:%s", cfg.Host, cfg.Port)
 	logger.Info("starting trove server",
 		"address", addr,
@@ -131,7 +237,7 @@ func main() {
 		Addr:              addr,
 		Handler:           r,
 		ReadHeaderTimeout: 10 * time.Second,
-		WriteTimeout:      10 * time.Minute, // Set a reasonable write timeout to prevent slow-read attacks
+		WriteTimeout:      writeTimeout, // Set a reasonable write timeout to prevent slow-read attacks
 		IdleTimeout:       120 * time.Second,
 	}
 
@@ -152,6 +258,26 @@ func main() {
 		// Stop upload cleanup worker
 		close(uploadCleanupDone)
 
+		// Stop scrub worker; an interrupted run resumes on next start
+		stopScrub()
+		<-scrubDone
+
+		// Stop garbage collection
+		stopGC()
+		<-gcDone
+
+		// Stop mirror repair
+		stopMirror()
+		<-mirrorDone
+
+		// Stop storage tiering; objects moved but not yet deleted are
+		// left to garbage collection
+		stopTiering()
+		<-tieringDone
+
+		// Stop recording free disk space
+		stopDiskWatch()
+
 		// Shutdown HTTP server
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/agjmills/trove/client"
+	"github.com/agjmills/trove/internal/templateutil"
+)
+
+func runLogin(ctx context.Context, _ *app, args []string) error {
+	flags := newFlagSet("login")
+	server := flags.String("server", "", "server URL, e.g. https://trove.example.com")
+	token := flags.String("token", "", "personal API token (create one under Settings → API Tokens)")
+	username := flags.String("username", "", "log in with a username and password instead of a token")
+	passwordStdin := flags.Bool("password-stdin", false, "read the password from standard input")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if *server != "" {
+		cfg.Server = strings.TrimRight(*server, "/")
+	}
+	if cfg.Server == "" {
+		return errors.New("-server is required")
+	}
+	if (*token == "") == (*username == "") {
+		return errors.New("give exactly one of -token or -username")
+	}
+
+	c := client.New(cfg.Server)
+	if *token != "" {
+		c.Token = *token
+	} else {
+		password, err := readPassword(*passwordStdin)
+		if err != nil {
+			return err
+		}
+		if err := c.Login(ctx, *username, password); err != nil {
+			return err
+		}
+	}
+
+	// Check the credentials actually work before saving them
+	quota, err := c.Quota(ctx)
+	if err != nil {
+		return err
+	}
+
+	cfg.Token, cfg.Session = c.Token, c.Session
+	if err := saveConfig(cfg); err != nil {
+		return err
+	}
+	fmt.Printf("Logged in to %s (%s of %s used)\n", cfg.Server,
+		templateutil.FormatBytes(quota.Used), templateutil.FormatBytes(quota.Quota))
+	return nil
+}
+
+// readPassword reads one line from stdin, hiding the input with stty when
+// prompting on a terminal.
+func readPassword(fromStdin bool) (string, error) {
+	if !fromStdin {
+		fmt.Fprint(os.Stderr, "Password: ")
+		if stty := exec.Command("stty", "-echo"); stty != nil {
+			stty.Stdin = os.Stdin
+			if stty.Run() == nil {
+				defer func() {
+					restore := exec.Command("stty", "echo")
+					restore.Stdin = os.Stdin
+					_ = restore.Run()
+					fmt.Fprintln(os.Stderr)
+				}()
+			}
+		}
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("reading password: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func runLogout(_ context.Context, _ *app, args []string) error {
+	if err := newFlagSet("logout").Parse(args); err != nil {
+		return err
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	cfg.Token, cfg.Session = "", ""
+	return saveConfig(cfg)
+}
+
+func runList(ctx context.Context, a *app, args []string) error {
+	flags := newFlagSet("ls")
+	asJSON := flags.Bool("json", false, "print the listing as JSON")
+	sort := flags.String("sort", "filename", "sort files by filename, size or created_at")
+	desc := flags.Bool("desc", false, "sort in descending order")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	c, err := a.connect()
+	if err != nil {
+		return err
+	}
+
+	folder := "/"
+	if flags.NArg() > 0 {
+		folder = flags.Arg(0)
+	}
+	opts := &client.ListOptions{PerPage: 200, Sort: *sort, Order: "asc"}
+	if *desc {
+		opts.Order = "desc"
+	}
+
+	var listing *client.FolderListing
+	for page := 1; ; page++ {
+		opts.Page = page
+		next, err := c.ListFiles(ctx, folder, opts)
+		if err != nil {
+			return err
+		}
+		if listing == nil {
+			listing = next
+		} else {
+			listing.Files = append(listing.Files, next.Files...)
+		}
+		if page >= next.Pagination.TotalPages {
+			break
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(listing)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, name := range listing.Folders {
+		fmt.Fprintf(tw, "-\t-\t\t%s/\n", name)
+	}
+	for _, f := range listing.Files {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", f.ID, templateutil.FormatBytes(f.Size), f.CreatedAt.Local().Format("2006-01-02 15:04"), f.Filename)
+	}
+	return tw.Flush()
+}
+
+func runUpload(ctx context.Context, a *app, args []string) error {
+	flags := newFlagSet("upload")
+	folder := flags.String("folder", "/", "destination folder")
+	tags := flags.String("tags", "", "comma-separated tags to apply to every file")
+	chunkMB := flags.Int("chunk-size", client.DefaultChunkSize>>20, "chunk size in MB")
+	conflict := flags.String("on-conflict", "", "when a file of the same name exists: replace, keep_both or skip (default: a new version if versioning is on, otherwise keep both)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+	switch *conflict {
+	case "", client.ConflictReplace, client.ConflictKeepBoth, client.ConflictSkip:
+	default:
+		return fmt.Errorf("-on-conflict must be %s, %s or %s", client.ConflictReplace, client.ConflictKeepBoth, client.ConflictSkip)
+	}
+	c, err := a.connect()
+	if err != nil {
+		return err
+	}
+	state, err := loadResumeState()
+	if err != nil {
+		return err
+	}
+
+	var tagList []string
+	for _, t := range strings.Split(*tags, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tagList = append(tagList, t)
+		}
+	}
+	up := &uploader{app: a, client: c, state: state, tags: tagList, chunkSize: int64(*chunkMB) << 20}
+	upload := func(localPath string, info os.FileInfo, folder string) error {
+		result, err := up.file(ctx, localPath, info, folder, *conflict)
+		if err != nil || a.quiet {
+			return err
+		}
+		if result.Action == "skipped" {
+			fmt.Fprintf(os.Stderr, "skipped %s: %s already exists\n", localPath, joinFolder(folder, result.Filename))
+			return nil
+		}
+		fmt.Printf("%d\t%s\n", result.FileID, joinFolder(folder, result.Filename))
+		return nil
+	}
+
+	for _, arg := range flags.Args() {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			if err := upload(arg, info, *folder); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// A directory is recreated under the destination, like cp -r
+		root := joinFolder(*folder, filepath.Base(filepath.Clean(arg)))
+		err = filepath.WalkDir(arg, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(arg, p)
+			if err != nil {
+				return err
+			}
+			remote := root
+			if rel != "." {
+				remote = joinFolder(root, filepath.ToSlash(rel))
+			}
+			if d.IsDir() {
+				return up.mkdir(ctx, remote)
+			}
+			if !d.Type().IsRegular() {
+				return nil // Skip symlinks, sockets and the like
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			return upload(p, info, path.Dir(remote))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type uploader struct {
+	*app
+	client    *client.Client
+	state     *resumeState
+	tags      []string
+	chunkSize int64
+}
+
+// mkdir creates a remote folder; one that already exists is fine.
+func (u *uploader) mkdir(ctx context.Context, folder string) error {
+	if folder == "/" {
+		return nil
+	}
+	if _, err := u.client.CreateFolder(ctx, folder); err != nil && !client.IsConflict(err) {
+		return fmt.Errorf("creating folder %s: %w", folder, err)
+	}
+	return nil
+}
+
+// file uploads one local file into folder, resuming an earlier attempt at
+// the same file if there was one. conflict is the upload's conflict policy.
+func (u *uploader) file(ctx context.Context, localPath string, info os.FileInfo, folder, conflict string) (*client.UploadResult, error) {
+	abs, err := filepath.Abs(localPath)
+	if err != nil {
+		return nil, err
+	}
+	key := resumeKey(u.client.BaseURL, folder, abs, info)
+
+	result, err := u.client.UploadFile(ctx, localPath, client.UploadOptions{
+		Folder:    folder,
+		Tags:      u.tags,
+		ChunkSize: u.chunkSize,
+		Conflict:  conflict,
+		ResumeID:  u.state.get(key),
+		OnSession: func(id string) {
+			if err := u.state.set(key, id); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: could not save resume state: %v\n", err)
+			}
+		},
+		Progress: u.progress(localPath),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", localPath, err)
+	}
+	if err := u.state.set(key, ""); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: could not save resume state: %v\n", err)
+	}
+	return result, nil
+}
+
+func runDownload(ctx context.Context, a *app, args []string) error {
+	flags := newFlagSet("download")
+	out := flags.String("o", "", "output path (a directory when downloading several files)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+	c, err := a.connect()
+	if err != nil {
+		return err
+	}
+
+	ids, err := parseIDs(flags.Args())
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		dest := *out
+		if dest == "" || len(ids) > 1 || isDir(dest) {
+			meta, err := c.GetFile(ctx, id)
+			if err != nil {
+				return err
+			}
+			dest = filepath.Join(dest, filepath.Base(meta.Filename))
+		}
+		if err := c.DownloadFile(ctx, id, dest, a.progress(dest)); err != nil {
+			return fmt.Errorf("file %d: %w", id, err)
+		}
+	}
+	return nil
+}
+
+func runMove(ctx context.Context, a *app, args []string) error {
+	flags := newFlagSet("mv")
+	name := flags.String("name", "", "new filename")
+	folder := flags.String("folder", "", "destination folder")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 || (*name == "" && *folder == "") {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+	ids, err := parseIDs(flags.Args())
+	if err != nil {
+		return err
+	}
+	c, err := a.connect()
+	if err != nil {
+		return err
+	}
+	file, err := c.MoveFile(ctx, ids[0], *name, *folder)
+	if err != nil {
+		return err
+	}
+	fmt.Println(joinFolder(file.Folder, file.Filename))
+	return nil
+}
+
+func runRemove(ctx context.Context, a *app, args []string) error {
+	flags := newFlagSet("rm")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	ids, err := parseIDs(flags.Args())
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+	c, err := a.connect()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := c.DeleteFile(ctx, id); err != nil {
+			return fmt.Errorf("file %d: %w", id, err)
+		}
+	}
+	return nil
+}
+
+func runMkdir(ctx context.Context, a *app, args []string) error {
+	flags := newFlagSet("mkdir")
+	parents := flags.Bool("p", false, "create missing parent folders; no error if the folder exists")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+	c, err := a.connect()
+	if err != nil {
+		return err
+	}
+
+	target := path.Clean("/" + flags.Arg(0))
+	if !*parents {
+		_, err := c.CreateFolder(ctx, target)
+		return err
+	}
+	return mkdirAll(ctx, c, target)
+}
+
+// mkdirAll creates a folder and any missing parents.
+func mkdirAll(ctx context.Context, c *client.Client, folder string) error {
+	current := ""
+	for _, segment := range strings.Split(strings.TrimPrefix(path.Clean("/"+folder), "/"), "/") {
+		if segment == "" {
+			continue
+		}
+		current += "/" + segment
+		if _, err := c.CreateFolder(ctx, current); err != nil && !client.IsConflict(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func runShare(ctx context.Context, a *app, args []string) error {
+	flags := newFlagSet("share")
+	folder := flags.String("folder", "", "share a folder instead of a file")
+	expires := flags.String("expires", "", "expiry date (YYYY-MM-DD); the link stops working after that day (UTC)")
+	maxUses := flags.Int("max-uses", 0, "maximum number of downloads (0 = unlimited)")
+	password := flags.String("password", "", "require this password to open the link")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if (*folder == "") == (flags.NArg() == 0) || flags.NArg() > 1 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+	c, err := a.connect()
+	if err != nil {
+		return err
+	}
+
+	opts := client.ShareOptions{ExpiresAt: *expires, Password: *password}
+	if *maxUses > 0 {
+		opts.MaxUses = maxUses
+	}
+	var link *client.ShareLink
+	if *folder != "" {
+		link, err = c.CreateFolderShare(ctx, *folder, opts)
+	} else {
+		var ids []uint
+		if ids, err = parseIDs(flags.Args()); err == nil {
+			link, err = c.CreateShare(ctx, ids[0], opts)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println(c.BaseURL + link.URL)
+	return nil
+}
+
+func runQuota(ctx context.Context, a *app, args []string) error {
+	if err := newFlagSet("quota").Parse(args); err != nil {
+		return err
+	}
+	c, err := a.connect()
+	if err != nil {
+		return err
+	}
+	quota, err := c.Quota(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s of %s used (%d%%), %s available\n",
+		templateutil.FormatBytes(quota.Used), templateutil.FormatBytes(quota.Quota),
+		templateutil.StoragePercentage(quota.Used, quota.Quota), templateutil.FormatBytes(quota.Available))
+	return nil
+}
+
+// progress returns a ProgressFunc that redraws a single status line on
+// stderr, or nil when progress output is disabled.
+func (a *app) progress(label string) client.ProgressFunc {
+	if a.quiet {
+		return nil
+	}
+	if len(label) > 40 {
+		label = "…" + label[len(label)-39:]
+	}
+	var last time.Time
+	finished := false
+	return func(done, total int64) {
+		if finished || (done < total && time.Since(last) < 200*time.Millisecond) {
+			return
+		}
+		last = time.Now()
+		percent := 100.0
+		if total > 0 {
+			percent = float64(done) * 100 / float64(total)
+		}
+		fmt.Fprintf(os.Stderr, "\r%-40s %5.1f%%  %s / %s   ", label, percent,
+			templateutil.FormatBytes(done), templateutil.FormatBytes(total))
+		if done >= total {
+			finished = true
+			fmt.Fprintln(os.Stderr)
+		}
+	}
+}
+
+func parseIDs(args []string) ([]uint, error) {
+	ids := make([]uint, 0, len(args))
+	for _, arg := range args {
+		id, err := strconv.ParseUint(arg, 10, 0)
+		if err != nil || id == 0 {
+			return nil, fmt.Errorf("invalid file ID %q", arg)
+		}
+		ids = append(ids, uint(id))
+	}
+	return ids, nil
+}
+
+func joinFolder(folder, name string) string {
+	return path.Join("/", folder, name)
+}
+
+func isDir(p string) bool {
+	info, err := os.Stat(p)
+	return err == nil && info.IsDir()
+}
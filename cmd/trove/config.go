@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// cliConfig is the saved login. Only one of Token and Session is set.
+type cliConfig struct {
+	Server  string `json:"server"`
+	Token   string `json:"token,omitempty"`
+	Session string `json:"session,omitempty"`
+}
+
+// configDir returns the directory for the config and resume state,
+// TROVE_CONFIG_DIR or the user config directory.
+func configDir() (string, error) {
+	if dir := os.Getenv("TROVE_CONFIG_DIR"); dir != "" {
+		return dir, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("locating config directory: %w", err)
+	}
+	return filepath.Join(dir, "trove"), nil
+}
+
+// loadConfig reads the saved login and applies TROVE_SERVER and TROVE_TOKEN.
+func loadConfig() (*cliConfig, error) {
+	cfg := &cliConfig{}
+	dir, err := configDir()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("reading config: %w", err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, err
+	}
+
+	if server := os.Getenv("TROVE_SERVER"); server != "" {
+		cfg.Server = server
+	}
+	if token := os.Getenv("TROVE_TOKEN"); token != "" {
+		cfg.Token, cfg.Session = token, ""
+	}
+	cfg.Server = strings.TrimRight(cfg.Server, "/")
+	return cfg, nil
+}
+
+// saveConfig writes the login with owner-only permissions, since it holds credentials.
+func saveConfig(cfg *cliConfig) error {
+	dir, err := configDir()
+	if err != nil {
+		return err
+	}
+	return writeJSONFile(filepath.Join(dir, "config.json"), cfg)
+}
+
+func writeJSONFile(path string, v any) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// resumeState remembers the upload session of each file being uploaded so an
+// interrupted 'trove upload' picks up where it stopped. Entries are keyed on
+// the server, destination and the file's path, size and modification time,
+// so a changed file starts a fresh upload.
+type resumeState struct {
+	mu       sync.Mutex
+	path     string
+	Sessions map[string]string `json:"sessions"`
+}
+
+func loadResumeState() (*resumeState, error) {
+	dir, err := configDir()
+	if err != nil {
+		return nil, err
+	}
+	state := &resumeState{path: filepath.Join(dir, "uploads.json"), Sessions: map[string]string{}}
+	data, err := os.ReadFile(state.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil || state.Sessions == nil {
+		state.Sessions = map[string]string{} // Corrupt state only costs a restart
+	}
+	return state, nil
+}
+
+func resumeKey(server, folder, localPath string, info os.FileInfo) string {
+	return fmt.Sprintf("%s|%s|%s|%d|%d", server, folder, localPath, info.Size(), info.ModTime().UnixNano())
+}
+
+func (s *resumeState) get(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Sessions[key]
+}
+
+// set records (or, with an empty id, forgets) the session for key.
+func (s *resumeState) set(key, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id == "" {
+		delete(s.Sessions, key)
+	} else {
+		s.Sessions[key] = id
+	}
+	return writeJSONFile(s.path, s)
+}
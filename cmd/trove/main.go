@@ -0,0 +1,150 @@
+// Command trove is a command-line client for a Trove server. It stores the server
+// URL and credentials (an API token or a login session) in
+// $XDG_CONFIG_HOME/trove/config.json; TROVE_SERVER and TROVE_TOKEN override
+// the stored values.
+//
+// Usage:
+//
+//	trove login -server https://trove.example.com -token trove_...
+//	trove upload -folder /photos ./holiday
+//	trove ls /photos/holiday
+//	trove download 42
+//	trove sync ~/Documents /documents
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/agjmills/trove/client"
+)
+
+var (
+	version = "dev"
+	commit  = "none"
+	date    = "unknown"
+)
+
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(ctx context.Context, app *app, args []string) error
+}
+
+// commands is filled in by init because the subcommands refer back to it for
+// their usage text.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"login", "[-server URL] (-token TOKEN | -username NAME [-password-stdin])", "Save the server and credentials", runLogin},
+		{"logout", "", "Forget the saved credentials", runLogout},
+		{"ls", "[-json] [-sort filename|size|created_at] [-desc] [FOLDER]", "List a folder", runList},
+		{"upload", "[-folder FOLDER] [-tags a,b] [-chunk-size MB] [-on-conflict POLICY] PATH...", "Upload files and directories (resumable)", runUpload},
+		{"download", "[-o PATH] ID...", "Download files (resumable)", runDownload},
+		{"sync", "[-n] DIRECTORY FOLDER", "Two-way sync a local directory with a folder", runSync},
+		{"mv", "[-name NAME] [-folder FOLDER] ID", "Rename and/or move a file", runMove},
+		{"rm", "ID...", "Move files to deleted items", runRemove},
+		{"mkdir", "[-p] FOLDER", "Create a folder", runMkdir},
+		{"share", "[-expires YYYY-MM-DD] [-max-uses N] [-password P] (ID | -folder FOLDER)", "Create a share link", runShare},
+		{"quota", "", "Show storage usage", runQuota},
+		{"version", "", "Print the client version", runVersion},
+	}
+}
+
+func main() {
+	quiet := flag.Bool("q", false, "do not print progress")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	name := flag.Arg(0)
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		a := &app{quiet: *quiet}
+		if err := cmd.run(ctx, a, flag.Args()[1:]); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				os.Exit(2)
+			}
+			fmt.Fprintf(os.Stderr, "trove %s: %v\n", name, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "trove: unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: trove [-q] <command> [arguments]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-9s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun 'trove <command> -h' for a command's flags.\n")
+}
+
+// newFlagSet returns a flag set for a subcommand whose usage line matches the command table.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		for _, cmd := range commands {
+			if cmd.name == name {
+				fmt.Fprintf(os.Stderr, "Usage: trove %s %s\n\n%s.\n", name, cmd.usage, cmd.summary)
+			}
+		}
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// app carries state shared by the subcommands.
+type app struct {
+	quiet  bool
+	config *cliConfig
+	client *client.Client
+}
+
+// connect loads the saved configuration and returns a client for it.
+func (a *app) connect() (*client.Client, error) {
+	if a.client != nil {
+		return a.client, nil
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Server == "" {
+		return nil, errors.New("no server configured; run 'trove login' first or set TROVE_SERVER")
+	}
+	if cfg.Token == "" && cfg.Session == "" {
+		return nil, errors.New("not logged in; run 'trove login' first or set TROVE_TOKEN")
+	}
+	a.config = cfg
+	a.client = client.New(cfg.Server)
+	a.client.Token = cfg.Token
+	a.client.Session = cfg.Session
+	a.client.UserAgent = "trove-cli/" + version
+	return a.client, nil
+}
+
+func runVersion(_ context.Context, _ *app, _ []string) error {
+	fmt.Printf("trove %s (commit: %s, built: %s)\n", version, commit, date)
+	return nil
+}
@@ -0,0 +1,531 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/agjmills/trove/client"
+)
+
+// syncState is what a directory and folder looked like after the last sync.
+// Comparing both sides against it tells which side changed, so a file
+// missing on one side can be told apart as deleted there or new on the other.
+type syncState struct {
+	path    string
+	Server  string               `json:"server"`
+	Folder  string               `json:"folder"`
+	Local   string               `json:"local"`
+	Files   map[string]syncEntry `json:"files"`   // Keyed on slash-separated path relative to both roots
+	Folders map[string]bool      `json:"folders"` // Subfolders that have been on both sides
+}
+
+type syncEntry struct {
+	FileID  uint   `json:"file_id"`
+	Hash    string `json:"hash"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mod_time"` // Local modification time (Unix nanoseconds), to skip rehashing
+}
+
+// loadSyncState reads the state for one directory and folder pair, kept under
+// the config directory so the synced directory holds nothing but its files.
+func loadSyncState(server, folder, local string) (*syncState, error) {
+	dir, err := configDir()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(server + "|" + folder + "|" + local))
+	state := &syncState{
+		path:   filepath.Join(dir, "sync", hex.EncodeToString(sum[:8])+".json"),
+		Server: server,
+		Folder: folder,
+		Local:  local,
+	}
+	data, err := os.ReadFile(state.path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, state); err != nil {
+			return nil, fmt.Errorf("reading sync state %s: %w", state.path, err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, err
+	}
+	if state.Files == nil {
+		state.Files = map[string]syncEntry{}
+	}
+	if state.Folders == nil {
+		state.Folders = map[string]bool{}
+	}
+	return state, nil
+}
+
+func (s *syncState) save() error {
+	return writeJSONFile(s.path, s)
+}
+
+type localFile struct {
+	size    int64
+	modTime int64
+	hash    string
+}
+
+func runSync(ctx context.Context, a *app, args []string) error {
+	flags := newFlagSet("sync")
+	dryRun := flags.Bool("n", false, "print what would change without changing anything")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+	c, err := a.connect()
+	if err != nil {
+		return err
+	}
+
+	s, err := newSyncer(a, c, flags.Arg(0), flags.Arg(1), *dryRun)
+	if err != nil {
+		return err
+	}
+	return s.run(ctx)
+}
+
+// newSyncer loads the state for syncing the local directory with folder.
+func newSyncer(a *app, c *client.Client, local, folder string, dryRun bool) (*syncer, error) {
+	local, err := filepath.Abs(local)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(local); err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", local)
+	}
+	folder = path.Clean("/" + folder)
+
+	state, err := loadSyncState(c.BaseURL, folder, local)
+	if err != nil {
+		return nil, err
+	}
+	resume, err := loadResumeState()
+	if err != nil {
+		return nil, err
+	}
+	return &syncer{
+		app:    a,
+		client: c,
+		up:     &uploader{app: a, client: c, state: resume},
+		state:  state,
+		local:  local,
+		folder: folder,
+		dryRun: dryRun,
+	}, nil
+}
+
+// syncer makes a local directory and a Trove folder match.
+type syncer struct {
+	*app
+	client *client.Client
+	up     *uploader
+	state  *syncState
+	local  string
+	folder string
+	dryRun bool
+
+	changes int
+}
+
+func (s *syncer) run(ctx context.Context) error {
+	localFiles, localDirs, err := s.scanLocal()
+	if err != nil {
+		return err
+	}
+	remoteFiles, remoteDirs, err := s.scanRemote(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := s.syncFolders(ctx, localDirs, remoteDirs); err != nil {
+		return err
+	}
+
+	paths := map[string]bool{}
+	for p := range localFiles {
+		paths[p] = true
+	}
+	for p := range remoteFiles {
+		paths[p] = true
+	}
+	for p := range s.state.Files {
+		paths[p] = true
+	}
+	sorted := make([]string, 0, len(paths))
+	for p := range paths {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+
+	for _, p := range sorted {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		l, hasLocal := localFiles[p]
+		r, hasRemote := remoteFiles[p]
+		if err := s.reconcile(ctx, p, l, hasLocal, r, hasRemote); err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+	}
+
+	if !s.quiet {
+		switch {
+		case s.changes == 0:
+			fmt.Println("Already in sync")
+		case s.dryRun:
+			fmt.Printf("%d change(s) would be made\n", s.changes)
+		}
+	}
+	if s.dryRun {
+		return nil
+	}
+	return s.state.save()
+}
+
+// reconcile brings one path into line. A side whose hash still matches the
+// last sync is unchanged, so the other side's version (or deletion) wins;
+// when both sides changed the local copy is kept under a conflict name.
+func (s *syncer) reconcile(ctx context.Context, p string, l localFile, hasLocal bool, r client.File, hasRemote bool) error {
+	base, hasBase := s.state.Files[p]
+
+	switch {
+	case hasLocal && hasRemote:
+		if l.hash == r.Hash {
+			return s.record(p, r.ID, r.Hash, l)
+		}
+		localChanged := !hasBase || l.hash != base.Hash
+		remoteChanged := !hasBase || r.Hash != base.Hash
+		switch {
+		case !remoteChanged:
+			return s.upload(ctx, p, l, r.ID)
+		case !localChanged:
+			return s.download(ctx, p, r)
+		default:
+			return s.conflict(ctx, p, l, r)
+		}
+
+	case hasLocal:
+		if hasBase && l.hash == base.Hash {
+			return s.removeLocal(p) // Deleted on the server
+		}
+		return s.upload(ctx, p, l, 0)
+
+	case hasRemote:
+		if hasBase && r.Hash == base.Hash {
+			return s.trash(ctx, p, r) // Deleted locally
+		}
+		return s.download(ctx, p, r)
+	}
+
+	// Gone from both sides
+	delete(s.state.Files, p)
+	return nil
+}
+
+// syncFolders creates folders that are new on one side on the other. A
+// folder removed on one side is not recreated; its files are handled one by
+// one, and the emptied folder is left for the user to remove.
+func (s *syncer) syncFolders(ctx context.Context, localDirs, remoteDirs map[string]bool) error {
+	if !s.dryRun {
+		if err := mkdirAll(ctx, s.client, s.folder); err != nil {
+			return fmt.Errorf("creating folder %s: %w", s.folder, err)
+		}
+	}
+
+	var toRemote, toLocal []string
+	for d := range localDirs {
+		if !remoteDirs[d] && !s.state.Folders[d] {
+			toRemote = append(toRemote, d)
+		}
+	}
+	for d := range remoteDirs {
+		if !localDirs[d] && !s.state.Folders[d] {
+			toLocal = append(toLocal, d)
+		}
+	}
+	sort.Strings(toRemote) // Parents sort before their children
+	sort.Strings(toLocal)
+
+	for _, d := range toRemote {
+		s.note("mkdir", joinFolder(s.folder, d))
+		if s.dryRun {
+			continue
+		}
+		if _, err := s.client.CreateFolder(ctx, joinFolder(s.folder, d)); err != nil && !client.IsConflict(err) {
+			return fmt.Errorf("creating folder %s: %w", d, err)
+		}
+		remoteDirs[d] = true
+	}
+	for _, d := range toLocal {
+		s.note("mkdir", filepath.Join(s.local, filepath.FromSlash(d)))
+		if s.dryRun {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Join(s.local, filepath.FromSlash(d)), 0755); err != nil {
+			return err
+		}
+		localDirs[d] = true
+	}
+
+	// Remember folders seen on both sides while either side still has them,
+	// so one deleted on one side is not brought back on the next run
+	folders := map[string]bool{}
+	for d := range localDirs {
+		if remoteDirs[d] {
+			folders[d] = true
+		}
+	}
+	for d := range s.state.Folders {
+		if localDirs[d] || remoteDirs[d] {
+			folders[d] = true
+		}
+	}
+	s.state.Folders = folders
+	return nil
+}
+
+// upload sends a local file to the server. When it replaces an older
+// server copy, the server moves that copy to deleted items, or keeps it as a
+// previous version if the user has versioning enabled.
+func (s *syncer) upload(ctx context.Context, p string, l localFile, replaces uint) error {
+	s.note("upload", p)
+	if s.dryRun {
+		return nil
+	}
+	localPath := s.localPath(p)
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+	conflict := ""
+	if replaces != 0 {
+		conflict = client.ConflictReplace
+	}
+	result, err := s.up.file(ctx, localPath, info, s.remoteDir(p), conflict)
+	if err != nil {
+		return err
+	}
+	// Servers without conflict policies leave the old copy in place
+	if replaces != 0 && result.Action == "" {
+		if err := s.client.DeleteFile(ctx, replaces); err != nil && !client.IsNotFound(err) {
+			return err
+		}
+	}
+	return s.record(p, result.FileID, result.Hash, l)
+}
+
+func (s *syncer) download(ctx context.Context, p string, r client.File) error {
+	s.note("download", p)
+	if s.dryRun {
+		return nil
+	}
+	dest := s.localPath(p)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	if err := s.client.DownloadFile(ctx, r.ID, dest, s.progress(p)); err != nil {
+		return err
+	}
+	info, err := os.Stat(dest)
+	if err != nil {
+		return err
+	}
+	return s.record(p, r.ID, r.Hash, localFile{size: info.Size(), modTime: info.ModTime().UnixNano(), hash: r.Hash})
+}
+
+// conflict keeps both versions: the local file is renamed to a conflict
+// copy and uploaded under that name, and the server's version is downloaded
+// in its place.
+func (s *syncer) conflict(ctx context.Context, p string, l localFile, r client.File) error {
+	copyPath := conflictName(p, time.Now())
+	s.note("conflict", p+" (local copy kept as "+path.Base(copyPath)+")")
+	if s.dryRun {
+		return nil
+	}
+	if err := os.Rename(s.localPath(p), s.localPath(copyPath)); err != nil {
+		return err
+	}
+	if err := s.upload(ctx, copyPath, l, 0); err != nil {
+		return err
+	}
+	return s.download(ctx, p, r)
+}
+
+func (s *syncer) removeLocal(p string) error {
+	s.note("delete", p)
+	if s.dryRun {
+		return nil
+	}
+	if err := os.Remove(s.localPath(p)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	delete(s.state.Files, p)
+	return s.state.save()
+}
+
+func (s *syncer) trash(ctx context.Context, p string, r client.File) error {
+	s.note("trash", p)
+	if s.dryRun {
+		return nil
+	}
+	if err := s.client.DeleteFile(ctx, r.ID); err != nil && !client.IsNotFound(err) {
+		return err
+	}
+	delete(s.state.Files, p)
+	return s.state.save()
+}
+
+// record notes that p is in sync, saving the state as it goes so an
+// interrupted sync does not redo finished work.
+func (s *syncer) record(p string, fileID uint, hash string, l localFile) error {
+	entry := syncEntry{FileID: fileID, Hash: hash, Size: l.size, ModTime: l.modTime}
+	if s.state.Files[p] == entry {
+		return nil
+	}
+	s.state.Files[p] = entry
+	if s.dryRun {
+		return nil
+	}
+	return s.state.save()
+}
+
+func (s *syncer) note(action, p string) {
+	s.changes++
+	fmt.Printf("%-9s %s\n", action, p)
+}
+
+func (s *syncer) localPath(p string) string {
+	return filepath.Join(s.local, filepath.FromSlash(p))
+}
+
+func (s *syncer) remoteDir(p string) string {
+	return path.Dir(joinFolder(s.folder, p))
+}
+
+// scanLocal walks the local directory. Files whose size and modification
+// time match the last sync reuse the recorded hash instead of being read.
+func (s *syncer) scanLocal() (map[string]localFile, map[string]bool, error) {
+	files := map[string]localFile{}
+	dirs := map[string]bool{}
+	err := filepath.WalkDir(s.local, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.local, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			dirs[rel] = true
+			return nil
+		}
+		// Skip symlinks and the like, and downloads in progress
+		if !d.Type().IsRegular() || strings.HasSuffix(rel, ".part") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		f := localFile{size: info.Size(), modTime: info.ModTime().UnixNano()}
+		if base, ok := s.state.Files[rel]; ok && base.Size == f.size && base.ModTime == f.modTime {
+			f.hash = base.Hash
+		} else if f.hash, err = hashFile(p); err != nil {
+			return err
+		}
+		files[rel] = f
+		return nil
+	})
+	return files, dirs, err
+}
+
+// scanRemote lists the folder tree on the server. Files still being
+// processed have no hash yet and are left for a later run.
+func (s *syncer) scanRemote(ctx context.Context) (map[string]client.File, map[string]bool, error) {
+	files := map[string]client.File{}
+	dirs := map[string]bool{}
+	queue := []string{""}
+	for len(queue) > 0 {
+		rel := queue[0]
+		queue = queue[1:]
+
+		opts := &client.ListOptions{PerPage: 200}
+		for page := 1; ; page++ {
+			opts.Page = page
+			listing, err := s.client.ListFiles(ctx, joinFolder(s.folder, rel), opts)
+			if rel == "" && client.IsNotFound(err) {
+				return files, dirs, nil // Created by syncFolders
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			if page == 1 {
+				for _, name := range listing.Folders {
+					sub := path.Join(rel, name)
+					dirs[sub] = true
+					queue = append(queue, sub)
+				}
+			}
+			for _, f := range listing.Files {
+				if f.Hash == "" {
+					continue
+				}
+				p := path.Join(rel, f.Filename)
+				// With duplicate names in one folder, the newest upload wins
+				if existing, ok := files[p]; !ok || f.ID > existing.ID {
+					files[p] = f
+				}
+			}
+			if page >= listing.Pagination.TotalPages {
+				break
+			}
+		}
+	}
+	return files, dirs, nil
+}
+
+// conflictName returns the name a conflicting local copy is kept under, e.g.
+// "notes (conflict 2026-01-02 150405).txt".
+func conflictName(p string, now time.Time) string {
+	ext := path.Ext(p)
+	if ext == path.Base(p) {
+		ext = "" // A dotfile such as .bashrc has no extension
+	}
+	return fmt.Sprintf("%s (conflict %s)%s", strings.TrimSuffix(p, ext), now.Format("2006-01-02 150405"), ext)
+}
+
+func hashFile(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close() //nolint:errcheck
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
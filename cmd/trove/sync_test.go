@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/go-chi/chi/v5"
+	"gorm.io/datatypes"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/agjmills/trove/client"
+	"github.com/agjmills/trove/internal/auth"
+	"github.com/agjmills/trove/internal/config"
+	"github.com/agjmills/trove/internal/database/models"
+	"github.com/agjmills/trove/internal/routes"
+	"github.com/agjmills/trove/internal/storage"
+)
+
+// newSyncTestClient runs the full router against an in-memory database and
+// storage and returns a client authenticated with an all-scopes token.
+func newSyncTestClient(t *testing.T) *client.Client {
+	t.Helper()
+	t.Setenv("TROVE_CONFIG_DIR", t.TempDir())
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	err = db.AutoMigrate(&models.User{}, &models.File{}, &models.FileVersion{}, &models.Blob{}, &models.Folder{}, &models.UploadSession{}, &models.APIToken{})
+	if err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	cfg := &config.Config{
+		BcryptCost:           4,
+		DefaultUserQuota:     100 * 1024 * 1024,
+		MaxUploadSize:        10 * 1024 * 1024,
+		SessionSecret:        "test-secret-key-32-bytes-long!!",
+		Env:                  "test",
+		TempDir:              t.TempDir(),
+		UploadSessionTimeout: time.Hour,
+	}
+	router := chi.NewRouter()
+	fileHandler, deletedHandler := routes.Setup(router, db, cfg, storage.NewMemoryBackend(), scs.New(), nil, "test")
+	srv := httptest.NewServer(router)
+	t.Cleanup(func() {
+		srv.Close()
+		fileHandler.Shutdown()
+		deletedHandler.Shutdown()
+	})
+
+	user := &models.User{Username: "alice", Email: "alice@example.com", StorageQuota: cfg.DefaultUserQuota}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	plaintext, prefix, hash, err := auth.GenerateAPIToken()
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	token := &models.APIToken{
+		UserID:      user.ID,
+		Name:        "test",
+		TokenHash:   hash,
+		TokenPrefix: prefix,
+		Scopes:      datatypes.NewJSONType([]string{auth.ScopeFilesRead, auth.ScopeFilesWrite}),
+	}
+	if err := db.Create(token).Error; err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+
+	c := client.New(srv.URL)
+	c.Token = plaintext
+	return c
+}
+
+// syncOnce runs one sync and returns the number of changes it made.
+func syncOnce(t *testing.T, c *client.Client, dir string) int {
+	t.Helper()
+	s, err := newSyncer(&app{quiet: true}, c, dir, "/sync", false)
+	if err != nil {
+		t.Fatalf("newSyncer failed: %v", err)
+	}
+	if err := s.run(context.Background()); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	return s.changes
+}
+
+// remoteFiles returns the content of every file under /sync by path.
+func remoteFiles(t *testing.T, c *client.Client) map[string]string {
+	t.Helper()
+	s, err := newSyncer(&app{quiet: true}, c, t.TempDir(), "/sync", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, _, err := s.scanRemote(context.Background())
+	if err != nil {
+		t.Fatalf("Listing remote files failed: %v", err)
+	}
+	out := map[string]string{}
+	for p, f := range files {
+		stream, err := c.Download(context.Background(), f.ID, 0)
+		if err != nil {
+			t.Fatalf("Downloading %s failed: %v", p, err)
+		}
+		var buf bytes.Buffer
+		_, _ = buf.ReadFrom(stream.Body)
+		_ = stream.Body.Close()
+		out[p] = buf.String()
+	}
+	return out
+}
+
+func writeLocal(t *testing.T, dir, p, content string) {
+	t.Helper()
+	full := filepath.Join(dir, filepath.FromSlash(p))
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readLocal(t *testing.T, dir, p string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(p)))
+	if err != nil {
+		t.Fatalf("Reading %s: %v", p, err)
+	}
+	return string(data)
+}
+
+func uploadRemote(t *testing.T, c *client.Client, folder, name, content string) uint {
+	t.Helper()
+	res, err := c.Upload(context.Background(), strings.NewReader(content), int64(len(content)), client.UploadOptions{
+		Folder:   folder,
+		Filename: name,
+	})
+	if err != nil {
+		t.Fatalf("Uploading %s failed: %v", name, err)
+	}
+	return res.FileID
+}
+
+func TestSync(t *testing.T) {
+	c := newSyncTestClient(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// First run merges both sides
+	writeLocal(t, dir, "a.txt", "local a")
+	writeLocal(t, dir, "sub/b.txt", "local b")
+	if err := mkdirAll(ctx, c, "/sync/remote-only"); err != nil {
+		t.Fatal(err)
+	}
+	uploadRemote(t, c, "/sync/remote-only", "c.txt", "remote c")
+
+	syncOnce(t, c, dir)
+	remote := remoteFiles(t, c)
+	if remote["a.txt"] != "local a" || remote["sub/b.txt"] != "local b" || remote["remote-only/c.txt"] != "remote c" {
+		t.Fatalf("Unexpected remote files after first sync: %v", remote)
+	}
+	if got := readLocal(t, dir, "remote-only/c.txt"); got != "remote c" {
+		t.Fatalf("Expected remote file downloaded, got %q", got)
+	}
+	if n := syncOnce(t, c, dir); n != 0 {
+		t.Fatalf("Expected second sync to be a no-op, made %d changes", n)
+	}
+
+	// A local edit replaces the server copy, which goes to deleted items
+	oldID := mustRemoteID(t, c, "a.txt")
+	time.Sleep(10 * time.Millisecond) // Make sure the modification time moves
+	writeLocal(t, dir, "a.txt", "local a v2")
+	syncOnce(t, c, dir)
+	if got := remoteFiles(t, c)["a.txt"]; got != "local a v2" {
+		t.Fatalf("Expected local edit uploaded, got %q", got)
+	}
+	if _, err := c.GetFile(ctx, oldID); !client.IsNotFound(err) {
+		t.Errorf("Expected replaced file to be trashed, got %v", err)
+	}
+
+	// Deletes propagate both ways
+	if err := os.Remove(filepath.Join(dir, "remote-only", "c.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteFile(ctx, mustRemoteID(t, c, "sub/b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	syncOnce(t, c, dir)
+	remote = remoteFiles(t, c)
+	if _, ok := remote["remote-only/c.txt"]; ok {
+		t.Error("Expected locally deleted file to be trashed on the server")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "sub", "b.txt")); !os.IsNotExist(err) {
+		t.Error("Expected file deleted on the server to be removed locally")
+	}
+
+	// Changes on both sides keep both versions
+	aID := mustRemoteID(t, c, "a.txt")
+	if err := c.DeleteFile(ctx, aID); err != nil {
+		t.Fatal(err)
+	}
+	uploadRemote(t, c, "/sync", "a.txt", "remote a v3")
+	time.Sleep(10 * time.Millisecond)
+	writeLocal(t, dir, "a.txt", "local a v3")
+	syncOnce(t, c, dir)
+
+	if got := readLocal(t, dir, "a.txt"); got != "remote a v3" {
+		t.Errorf("Expected server version in place after conflict, got %q", got)
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "a (conflict *).txt"))
+	if len(matches) != 1 {
+		t.Fatalf("Expected one local conflict copy, found %v", matches)
+	}
+	if got, _ := os.ReadFile(matches[0]); string(got) != "local a v3" {
+		t.Errorf("Expected conflict copy to hold the local edit, got %q", got)
+	}
+	if got := remoteFiles(t, c)[filepath.Base(matches[0])]; got != "local a v3" {
+		t.Errorf("Expected conflict copy uploaded, got %q", got)
+	}
+
+	if n := syncOnce(t, c, dir); n != 0 {
+		t.Errorf("Expected final sync to be a no-op, made %d changes", n)
+	}
+}
+
+func mustRemoteID(t *testing.T, c *client.Client, p string) uint {
+	t.Helper()
+	s, err := newSyncer(&app{quiet: true}, c, t.TempDir(), "/sync", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, _, err := s.scanRemote(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, ok := files[p]
+	if !ok {
+		t.Fatalf("No remote file %s", p)
+	}
+	return f.ID
+}
+
+func TestConflictName(t *testing.T) {
+	now := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	tests := map[string]string{
+		"notes.txt":         "notes (conflict 2026-01-02 150405).txt",
+		"dir/archive.tar":   "dir/archive (conflict 2026-01-02 150405).tar",
+		"Makefile":          "Makefile (conflict 2026-01-02 150405)",
+		"photos/.hidden.md": "photos/.hidden (conflict 2026-01-02 150405).md",
+		".bashrc":           ".bashrc (conflict 2026-01-02 150405)",
+	}
+	for in, want := range tests {
+		if got := conflictName(in, now); got != want {
+			t.Errorf("conflictName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
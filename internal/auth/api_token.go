@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"gorm.io/gorm"
+
+	"github.com/agjmills/trove/internal/database/models"
+	"github.com/agjmills/trove/internal/logger"
+)
+
+// API token scopes.
+const (
+	ScopeFilesRead     = "files:read"
+	ScopeFilesWrite    = "files:write"
+	ScopeSharesManage  = "shares:manage"
+	apiTokenPrefix     = "trove_"
+	apiTokenPrefixLen  = 12
+	lastUsedUpdateStep = time.Minute
+)
+
+// AllScopes lists every scope a token can be granted, in display order.
+var AllScopes = []string{ScopeFilesRead, ScopeFilesWrite, ScopeSharesManage}
+
+const APITokenContextKey contextKey = "api_token"
+
+// ValidScope reports whether s is a known scope.
+func ValidScope(s string) bool {
+	return slices.Contains(AllScopes, s)
+}
+
+// GenerateAPIToken returns a new random token, its display prefix and the
+// SHA-256 hash that is persisted. The plaintext is never stored.
+func GenerateAPIToken() (token, prefix, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	token = apiTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return token, token[:apiTokenPrefixLen], HashAPIToken(token), nil
+}
+
+// HashAPIToken returns the hex-encoded SHA-256 of a plaintext token.
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// GetAPIToken returns the token that authenticated the request, or nil for
+// session-authenticated requests.
+func GetAPIToken(r *http.Request) *models.APIToken {
+	token, _ := r.Context().Value(APITokenContextKey).(*models.APIToken)
+	return token
+}
+
+// TokenHasScope reports whether the token grants scope.
+func TokenHasScope(token *models.APIToken, scope string) bool {
+	return slices.Contains(token.Scopes.Data(), scope)
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// ErrorWriter writes an authentication or authorization failure. It lets JSON
+// APIs report failures in their own error format.
+type ErrorWriter func(w http.ResponseWriter, status int, code, message string)
+
+// plainErrorWriter reports failures as text/plain, matching http.Error.
+func plainErrorWriter(w http.ResponseWriter, status int, _ string, message string) {
+	http.Error(w, message, status)
+}
+
+// RequireAuthOrToken behaves like RequireAuth for browser sessions, but also
+// accepts an "Authorization: Bearer" API token carrying every listed scope.
+// Session users are implicitly granted all scopes.
+func RequireAuthOrToken(db *gorm.DB, sessionManager *scs.SessionManager, scopes ...string) func(http.Handler) http.Handler {
+	session := RequireAuth(db, sessionManager)
+	return func(next http.Handler) http.Handler {
+		sessionNext := session(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			raw, ok := bearerToken(r)
+			if !ok {
+				sessionNext.ServeHTTP(w, r)
+				return
+			}
+			serveWithToken(db, raw, scopes, plainErrorWriter, next, w, r)
+		})
+	}
+}
+
+// RequireAPIAuth is the JSON API counterpart of RequireAuthOrToken: instead of
+// redirecting to /login, failures are reported through writeError.
+func RequireAPIAuth(db *gorm.DB, sessionManager *scs.SessionManager, writeError ErrorWriter, scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if raw, ok := bearerToken(r); ok {
+				serveWithToken(db, raw, scopes, writeError, next, w, r)
+				return
+			}
+
+			userID := sessionManager.GetInt(r.Context(), "user_id")
+			var user models.User
+			if userID == 0 || db.First(&user, userID).Error != nil {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, http.StatusUnauthorized, "unauthorized", "Authentication required")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), UserContextKey, &user)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequireTokenAuth authenticates with an API token only, accepted either as a
+// bearer token or as the password of HTTP Basic auth (the username is ignored)
+// for clients such as WebDAV file managers that cannot send custom headers.
+// scopeFor returns the scope required by each request. Browser sessions are
+// not consulted.
+func RequireTokenAuth(db *gorm.DB, realm string, scopeFor func(r *http.Request) string) func(http.Handler) http.Handler {
+	writeError := func(w http.ResponseWriter, status int, _ string, message string) {
+		if status == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+realm+`", charset="UTF-8"`)
+		}
+		http.Error(w, message, status)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			raw, ok := bearerToken(r)
+			if !ok {
+				_, raw, ok = r.BasicAuth()
+			}
+			if !ok || raw == "" {
+				writeError(w, http.StatusUnauthorized, "unauthorized", "Authentication required")
+				return
+			}
+			serveWithToken(db, raw, []string{scopeFor(r)}, writeError, next, w, r)
+		})
+	}
+}
+
+// serveWithToken authenticates raw as an API token holding every scope and,
+// on success, calls next with the token's user and the token in context.
+func serveWithToken(db *gorm.DB, raw string, scopes []string, writeError ErrorWriter, next http.Handler, w http.ResponseWriter, r *http.Request) {
+	var token models.APIToken
+	err := db.Where("token_hash = ?", HashAPIToken(raw)).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		First(&token).Error
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeError(w, http.StatusUnauthorized, "invalid_token", "Invalid or expired API token")
+		return
+	}
+
+	for _, scope := range scopes {
+		if !TokenHasScope(&token, scope) {
+			w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+strings.Join(scopes, " ")+`"`)
+			writeError(w, http.StatusForbidden, "insufficient_scope", "API token lacks required scope: "+scope)
+			return
+		}
+	}
+
+	var user models.User
+	if err := db.First(&user, token.UserID).Error; err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeError(w, http.StatusUnauthorized, "invalid_token", "Invalid or expired API token")
+		return
+	}
+
+	// Throttle last-used writes so busy clients don't update the row on every request
+	now := time.Now()
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > lastUsedUpdateStep {
+		if err := db.Model(&token).UpdateColumn("last_used_at", now).Error; err != nil {
+			logger.Warn("failed to update api token last_used_at", "token_id", token.ID, "error", err)
+		}
+		token.LastUsedAt = &now
+	}
+
+	ctx := context.WithValue(r.Context(), UserContextKey, &user)
+	ctx = context.WithValue(ctx, APITokenContextKey, &token)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
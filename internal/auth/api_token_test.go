@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateAPIToken(t *testing.T) {
+	token, prefix, hash, err := GenerateAPIToken()
+	if err != nil {
+		t.Fatalf("GenerateAPIToken() error = %v", err)
+	}
+	if !strings.HasPrefix(token, "trove_") {
+		t.Errorf("token %q missing trove_ prefix", token)
+	}
+	if !strings.HasPrefix(token, prefix) {
+		t.Errorf("prefix %q is not a prefix of token", prefix)
+	}
+	if hash != HashAPIToken(token) {
+		t.Error("hash does not match HashAPIToken(token)")
+	}
+	if len(hash) != 64 {
+		t.Errorf("expected 64-char hex hash, got %d", len(hash))
+	}
+
+	other, _, _, _ := GenerateAPIToken()
+	if other == token {
+		t.Error("GenerateAPIToken() returned the same token twice")
+	}
+}
+
+func TestValidScope(t *testing.T) {
+	for _, s := range AllScopes {
+		if !ValidScope(s) {
+			t.Errorf("ValidScope(%q) = false, want true", s)
+		}
+	}
+	for _, s := range []string{"", "admin", "files:*"} {
+		if ValidScope(s) {
+			t.Errorf("ValidScope(%q) = true, want false", s)
+		}
+	}
+}
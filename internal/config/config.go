@@ -30,6 +30,40 @@ type Config struct {
 	TempDir        string // Temp directory for uploads (defaults to system temp)
 	S3Bucket       string // S3 bucket name (required for s3 backend)
 	S3UsePathStyle bool   // Use path-style addressing (required for MinIO/rustfs)
+	S3PartSize     int64  // Size of each part of a multipart upload
+	S3Concurrency  int    // Parts of one upload sent at once
+
+	// Low disk space protection: uploads are refused while a volume has
+	// less free space than this (0 = no minimum)
+	MinFreeStorage int64 // On the volume holding StoragePath (disk backend only)
+	MinFreeTemp    int64 // On the volume holding TempDir
+
+	// Presigned downloads redirect clients to S3 instead of proxying content
+	S3PresignedDownloads bool
+	S3PresignExpiry      time.Duration // Lifetime of presigned download URLs
+
+	// Local read cache for S3 (enabled when StorageCacheDir is set)
+	StorageCacheDir  string // Directory holding cached blocks of S3 objects
+	StorageCacheSize int64  // Maximum bytes cached
+
+	// Storage mirroring (enabled when StorageMirrors is set)
+	StorageMirrors       []string      // Secondary backends holding a copy of every object, e.g. "s3:bucket", "disk:/path"
+	MirrorRepairInterval time.Duration // Time between repair runs that re-copy missing objects (0 = disabled)
+
+	// Storage policies: named backends admins can assign to users and folders
+	StoragePolicies []string // "name=backend" entries, e.g. "s3-archive=s3:archive-bucket", "local-ssd=disk:/mnt/ssd"
+
+	// Cold-storage tiering (enabled when TieringColdPolicy is set)
+	TieringHotPolicy       string        // Policy objects are moved from ("" = default backend)
+	TieringColdPolicy      string        // Policy objects untouched for TieringAfterDays are moved to
+	TieringAfterDays       int           // Days without access before an object is moved to the cold policy
+	TieringInterval        time.Duration // Time between tiering runs (0 = disabled)
+	TieringPromoteOnAccess bool          // Move objects back to the hot policy when their files are read
+
+	// Encryption at rest (enabled when EncryptionKeys is set)
+	EncryptionKeys      string // Comma-separated "id:base64-key" master keys
+	EncryptionActiveKey string // ID of the key that wraps new objects (default: first key)
+	EncryptionRequired  bool   // Refuse to read objects stored without encryption
 
 	DefaultUserQuota int64
 	MaxUploadSize    int64
@@ -41,16 +75,24 @@ type Config struct {
 
 	EnableRegistration      bool
 	EnableFileDeduplication bool
+	WebDAVEnabled           bool // Serve the WebDAV endpoint under /dav/
 
 	// Deleted items configuration
 	DeletedRetentionDays      int // Default number of days to retain deleted files (0 = permanent delete immediately)
 	DeletedCleanupIntervalMin int // Interval in minutes between deleted items cleanup runs
 
+	// File versioning configuration (users opt in from their settings)
+	MaxFileVersions      int // Default number of previous versions kept per file (0 = unlimited)
+	VersionRetentionDays int // Default days a previous version is kept after being replaced (0 = forever)
+
 	// Chunked upload configuration
 	UploadChunkSize            int64         // Default chunk size for resumable uploads (e.g., 5MB)
 	UploadSessionTimeout       time.Duration // How long upload sessions remain active
 	UploadSessionRetentionDays int           // Days to retain completed/canceled/expired upload sessions before cleanup
 
+	// Archive extraction configuration
+	ExtractMaxEntries int // Most files and folders an archive may have to be extracted (0 = no limit)
+
 	// Video transcoding configuration
 	TranscodeEnabled      bool          // Enqueue transcode jobs when video files are uploaded
 	TranscodePollInterval time.Duration // How often the transcoder worker polls for pending jobs
@@ -65,6 +107,14 @@ type Config struct {
 	FFprobePath           string        // Path to the ffprobe binary
 	TranscodeStaleJobAge  time.Duration // Age after which "processing" jobs are considered stale and re-queued
 
+	// Storage integrity scrubbing
+	ScrubInterval  time.Duration // Time between scheduled scrubs of all stored objects (0 = only on demand)
+	ScrubRateLimit int64         // Maximum bytes per second the scrubber reads from storage
+
+	// Orphaned object garbage collection
+	GCInterval    time.Duration // Time between garbage collection runs (0 = disabled)
+	GCGracePeriod time.Duration // Minimum age of an unreferenced object before it is deleted
+
 	// TrustedProxyCIDRs is a list of CIDR ranges (e.g., "127.0.0.1/32", "10.0.0.0/8")
 	// from which X-Forwarded-Proto headers will be trusted for CSRF origin validation.
 	// If empty, X-Forwarded-Proto is never trusted and r.TLS is used to detect HTTPS.
@@ -109,6 +159,25 @@ func Load() (*Config, error) {
 		TempDir:                    getEnv("TEMP_DIR", ""),
 		S3Bucket:                   getEnv("S3_BUCKET", ""),
 		S3UsePathStyle:             getEnvBool("S3_USE_PATH_STYLE", false),
+		S3PartSize:                 getEnvSize("S3_PART_SIZE", "16M"),
+		S3Concurrency:              getEnvInt("S3_UPLOAD_CONCURRENCY", 4),
+		MinFreeStorage:             getEnvSize("MIN_FREE_STORAGE", "1G"),
+		MinFreeTemp:                getEnvSize("MIN_FREE_TEMP", "1G"),
+		S3PresignedDownloads:       getEnvBool("S3_PRESIGNED_DOWNLOADS", false),
+		S3PresignExpiry:            getEnvDuration("S3_PRESIGN_EXPIRY", "5m"),
+		StorageCacheDir:            getEnv("STORAGE_CACHE_DIR", ""),
+		StorageCacheSize:           getEnvSize("STORAGE_CACHE_SIZE", "10G"),
+		StorageMirrors:             getEnvStringSlice("STORAGE_MIRRORS", nil),
+		MirrorRepairInterval:       getEnvDuration("MIRROR_REPAIR_INTERVAL", "24h"),
+		StoragePolicies:            getEnvStringSlice("STORAGE_POLICIES", nil),
+		TieringHotPolicy:           getEnv("TIERING_HOT_POLICY", ""),
+		TieringColdPolicy:          getEnv("TIERING_COLD_POLICY", ""),
+		TieringAfterDays:           getEnvInt("TIERING_AFTER_DAYS", 30),
+		TieringInterval:            getEnvDuration("TIERING_INTERVAL", "24h"),
+		TieringPromoteOnAccess:     getEnvBool("TIERING_PROMOTE_ON_ACCESS", false),
+		EncryptionKeys:             getEnv("ENCRYPTION_KEYS", ""),
+		EncryptionActiveKey:        getEnv("ENCRYPTION_ACTIVE_KEY", ""),
+		EncryptionRequired:         getEnvBool("ENCRYPTION_REQUIRED", false),
 		DefaultUserQuota:           getEnvSize("DEFAULT_USER_QUOTA", "10G"),
 		MaxUploadSize:              getEnvSize("MAX_UPLOAD_SIZE", "500M"),
 		SessionSecret:              getEnv("SESSION_SECRET", "change_me_in_production"),
@@ -117,11 +186,15 @@ func Load() (*Config, error) {
 		CSRFEnabled:                getEnvBool("CSRF_ENABLED", true),
 		EnableRegistration:         getEnvBool("ENABLE_REGISTRATION", true),
 		EnableFileDeduplication:    getEnvBool("ENABLE_FILE_DEDUPLICATION", true),
+		WebDAVEnabled:              getEnvBool("WEBDAV_ENABLED", false),
 		DeletedRetentionDays:       getEnvInt("DELETED_RETENTION_DAYS", 30),
 		DeletedCleanupIntervalMin:  getEnvInt("DELETED_CLEANUP_INTERVAL_MIN", 60),
+		MaxFileVersions:            getEnvInt("MAX_FILE_VERSIONS", 10),
+		VersionRetentionDays:       getEnvInt("FILE_VERSION_RETENTION_DAYS", 90),
 		UploadChunkSize:            getEnvSize("UPLOAD_CHUNK_SIZE", "5M"),
 		UploadSessionTimeout:       getEnvDuration("UPLOAD_SESSION_TIMEOUT", "24h"),
 		UploadSessionRetentionDays: getEnvInt("UPLOAD_SESSION_RETENTION_DAYS", 7),
+		ExtractMaxEntries:          getEnvInt("EXTRACT_MAX_ENTRIES", 10000),
 		TranscodeEnabled:           getEnvBool("TRANSCODE_ENABLED", true),
 		TranscodePollInterval:      getEnvDuration("TRANSCODE_POLL_INTERVAL", "5s"),
 		TranscodeWorkers:           getEnvInt("TRANSCODE_WORKERS", 1),
@@ -134,6 +207,10 @@ func Load() (*Config, error) {
 		FFmpegPath:                 getEnv("FFMPEG_PATH", "ffmpeg"),
 		FFprobePath:                getEnv("FFPROBE_PATH", "ffprobe"),
 		TranscodeStaleJobAge:       getEnvDuration("TRANSCODE_STALE_JOB_AGE", "30m"),
+		ScrubInterval:              getEnvDuration("SCRUB_INTERVAL", "168h"),
+		ScrubRateLimit:             getEnvSize("SCRUB_RATE_LIMIT", "20M"),
+		GCInterval:                 getEnvDuration("GC_INTERVAL", "24h"),
+		GCGracePeriod:              getEnvDuration("GC_GRACE_PERIOD", "24h"),
 		TrustedProxyCIDRs:          getEnvStringSlice("TRUSTED_PROXY_CIDRS", nil),
 		CORSAllowedOrigins:         getEnvStringSlice("CORS_ALLOWED_ORIGINS", nil),
 		OIDCEnabled:                getEnvBool("OIDC_ENABLED", false),
@@ -163,6 +240,14 @@ func Load() (*Config, error) {
 		cfg.DeletedCleanupIntervalMin = 1 // Minimum 1 minute
 	}
 
+	// Validate file versioning configuration
+	if cfg.MaxFileVersions < 0 {
+		cfg.MaxFileVersions = 0
+	}
+	if cfg.VersionRetentionDays < 0 {
+		cfg.VersionRetentionDays = 0
+	}
+
 	// Validate chunked upload configuration
 	if cfg.UploadChunkSize < 1024 {
 		cfg.UploadChunkSize = 5 * 1024 * 1024 // Default to 5MB if invalid
@@ -174,6 +259,11 @@ func Load() (*Config, error) {
 		cfg.UploadSessionRetentionDays = 0
 	}
 
+	// Validate archive extraction configuration
+	if cfg.ExtractMaxEntries < 0 {
+		cfg.ExtractMaxEntries = 0
+	}
+
 	// Validate transcoding configuration
 	if cfg.TranscodePollInterval < time.Second {
 		cfg.TranscodePollInterval = 5 * time.Second
@@ -197,6 +287,73 @@ func Load() (*Config, error) {
 		cfg.TranscodeStaleJobAge = 30 * time.Minute
 	}
 
+	// Validate scrub configuration
+	if cfg.ScrubInterval < 0 {
+		cfg.ScrubInterval = 0
+	}
+	if cfg.ScrubRateLimit < 1024*1024 {
+		cfg.ScrubRateLimit = 20 * 1024 * 1024
+	}
+
+	// Validate S3 upload configuration
+	if cfg.S3PartSize < 5*1024*1024 {
+		cfg.S3PartSize = 5 * 1024 * 1024 // S3's minimum part size
+	}
+	if cfg.S3PartSize > 5*1024*1024*1024 {
+		cfg.S3PartSize = 5 * 1024 * 1024 * 1024 // S3's maximum part size
+	}
+	if cfg.S3Concurrency < 1 {
+		cfg.S3Concurrency = 1
+	}
+	if cfg.S3PresignExpiry <= 0 {
+		cfg.S3PresignExpiry = 5 * time.Minute
+	}
+	if cfg.S3PresignExpiry > 7*24*time.Hour {
+		cfg.S3PresignExpiry = 7 * 24 * time.Hour // Longest a SigV4 signature is valid
+	}
+
+	// Validate storage cache configuration
+	if cfg.StorageCacheDir != "" && cfg.StorageBackend != "s3" {
+		return nil, fmt.Errorf("STORAGE_CACHE_DIR is only supported with STORAGE_BACKEND=s3")
+	}
+
+	// Validate mirroring configuration
+	if cfg.MirrorRepairInterval < 0 {
+		cfg.MirrorRepairInterval = 0
+	}
+
+	// Validate encryption configuration
+	if cfg.EncryptionRequired && cfg.EncryptionKeys == "" {
+		return nil, fmt.Errorf("ENCRYPTION_REQUIRED=true requires ENCRYPTION_KEYS")
+	}
+
+	// Validate tiering configuration
+	if cfg.TieringColdPolicy != "" && cfg.TieringColdPolicy == cfg.TieringHotPolicy {
+		return nil, fmt.Errorf("TIERING_COLD_POLICY must differ from TIERING_HOT_POLICY")
+	}
+	if cfg.TieringAfterDays < 1 {
+		cfg.TieringAfterDays = 1
+	}
+	if cfg.TieringInterval < 0 {
+		cfg.TieringInterval = 0
+	}
+
+	// Validate low disk space thresholds
+	if cfg.MinFreeStorage < 0 {
+		cfg.MinFreeStorage = 0
+	}
+	if cfg.MinFreeTemp < 0 {
+		cfg.MinFreeTemp = 0
+	}
+
+	// Validate garbage collection configuration
+	if cfg.GCInterval < 0 {
+		cfg.GCInterval = 0
+	}
+	if cfg.GCGracePeriod < time.Hour {
+		cfg.GCGracePeriod = time.Hour // Uploads and transcodes must finish within it
+	}
+
 	log.Printf("Config loaded: MaxUploadSize=%d bytes (%.2f MB), DefaultUserQuota=%d bytes (%.2f GB)",
 		cfg.MaxUploadSize, float64(cfg.MaxUploadSize)/(1024*1024),
 		cfg.DefaultUserQuota, float64(cfg.DefaultUserQuota)/(1024*1024*1024))
@@ -244,6 +244,10 @@ func TestLoadConfig_Defaults(t *testing.T) {
 	if !cfg.EnableFileDeduplication {
 		t.Error("Expected EnableFileDeduplication=true by default")
 	}
+
+	if cfg.WebDAVEnabled {
+		t.Error("Expected WebDAVEnabled=false by default")
+	}
 }
 
 func TestParseSizeEdgeCases(t *testing.T) {
@@ -0,0 +1,343 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/agjmills/trove/internal/database/models"
+	"github.com/agjmills/trove/internal/logger"
+	"github.com/agjmills/trove/internal/storage"
+)
+
+// Reference counts are changed with single conditional statements rather
+// than read-modify-write, so concurrent uploads and deletes of the same
+// content never lose an update. A blob row only exists while its count is
+// at least one: the last release deletes the row in the same statement that
+// checks the count, so an upload can never take a reference to an object
+// that is about to be deleted.
+
+// blobRetries bounds the retries when a concurrent upload or delete changes
+// a blob between two statements.
+const blobRetries = 5
+
+// BlobScope limits which stored blobs a new file row may share. Content
+// that is stored but outside the scope is saved again as a separate object
+// without a blob, which is released like objects stored before blobs were
+// tracked.
+type BlobScope struct {
+	UserID uint   // Only share blobs this user's files already use (0 = anyone's)
+	Policy string // Only share blobs stored under this storage policy ("" = default backend)
+}
+
+// allows reports whether the blob stored at path may be shared.
+func (s BlobScope) allows(db *gorm.DB, path string) (bool, error) {
+	if storage.PolicyOf(path) != s.Policy {
+		return false, nil
+	}
+	if s.UserID == 0 {
+		return true, nil
+	}
+	return ReferencedBy(db, s.UserID, path)
+}
+
+// ownObject returns the path of an object without a blob holding content
+// with the hash that is stored under the scope's policy and, if the scope
+// has a user, used by that user's files, or "". Such an object is released
+// once no file references it, like one stored before blobs were tracked, so
+// no reference is taken.
+func (s BlobScope) ownObject(db *gorm.DB, hash string) (string, error) {
+	var paths, versionPaths []string
+	files := db.Model(&models.File{}).
+		Where("hash = ? AND upload_status = ? AND storage_path NOT IN (?)",
+			hash, "completed", db.Model(&models.Blob{}).Select("storage_path"))
+	versions := db.Model(&models.FileVersion{}).
+		Where("hash = ? AND storage_path NOT IN (?)", hash, db.Model(&models.Blob{}).Select("storage_path"))
+	if s.UserID != 0 {
+		files = files.Where("user_id = ?", s.UserID)
+		versions = versions.Where("user_id = ?", s.UserID)
+	}
+	if err := files.Distinct().Pluck("storage_path", &paths).Error; err != nil {
+		return "", err
+	}
+	if err := versions.Distinct().Pluck("storage_path", &versionPaths).Error; err != nil {
+		return "", err
+	}
+	for _, path := range append(paths, versionPaths...) {
+		if storage.PolicyOf(path) == s.Policy {
+			return path, nil
+		}
+	}
+	return "", nil
+}
+
+// AcquireBlob adds a reference to the blob with the given content hash and
+// returns its storage path, or "" if no object with that content is stored
+// or scope may not share it.
+func AcquireBlob(db *gorm.DB, hash string, scope BlobScope) (string, error) {
+	path, _, err := acquireBlob(db, hash, scope)
+	return path, err
+}
+
+// acquireBlob is AcquireBlob, also reporting whether a blob with the hash
+// exists at all.
+func acquireBlob(db *gorm.DB, hash string, scope BlobScope) (string, bool, error) {
+	if hash == "" {
+		return "", false, nil
+	}
+	for range blobRetries {
+		var blob models.Blob
+		if err := db.Where("hash = ?", hash).Limit(1).Find(&blob).Error; err != nil {
+			return "", false, err
+		}
+		if blob.Hash == "" {
+			path, err := scope.ownObject(db, hash)
+			return path, false, err
+		}
+		if ok, err := scope.allows(db, blob.StoragePath); err != nil {
+			return "", true, err
+		} else if !ok {
+			path, err := scope.ownObject(db, hash)
+			return path, true, err
+		}
+		res := db.Model(&models.Blob{}).Where("hash = ? AND storage_path = ?", hash, blob.StoragePath).
+			UpdateColumn("ref_count", gorm.Expr("ref_count + 1"))
+		if res.Error != nil {
+			return "", false, res.Error
+		}
+		if res.RowsAffected == 1 {
+			return blob.StoragePath, true, nil
+		}
+		// The blob was released or moved between the two statements
+	}
+	return "", false, fmt.Errorf("blob %s kept changing while acquiring", hash)
+}
+
+// AcquireBlobPath adds a reference to the blob stored at path, for a new
+// file row that shares an existing file's object. Paths without a blob are
+// ignored.
+func AcquireBlobPath(db *gorm.DB, path string) error {
+	return db.Model(&models.Blob{}).Where("storage_path = ?", path).
+		UpdateColumn("ref_count", gorm.Expr("ref_count + 1")).Error
+}
+
+// RegisterBlob records a newly saved object holding one reference. If
+// another upload registered the same content first, that blob gets the
+// reference instead and its path is returned; the caller should then delete
+// the object it saved. If scope may not share that blob, the new object is
+// kept without a blob and path is returned.
+func RegisterBlob(db *gorm.DB, hash, path string, size int64, scope BlobScope) (string, error) {
+	if hash == "" {
+		return path, nil
+	}
+	for range blobRetries {
+		res := db.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.Blob{Hash: hash, StoragePath: path, Size: size, RefCount: 1})
+		if res.Error != nil {
+			return "", res.Error
+		}
+		if res.RowsAffected == 1 {
+			return path, nil
+		}
+		existing, found, err := acquireBlob(db, hash, scope)
+		if err != nil {
+			return "", err
+		}
+		if existing != "" {
+			return existing, nil
+		}
+		if found {
+			return path, nil
+		}
+		// The existing blob was released between the two statements
+	}
+	return "", fmt.Errorf("blob %s kept changing while registering", hash)
+}
+
+// ReleaseBlob drops the reference a deleted file row held on the object at
+// path, and reports whether it was the last, in which case the caller should
+// delete the object. Call it after deleting the row. Objects stored before
+// blobs were tracked are last once no file references them.
+func ReleaseBlob(db *gorm.DB, path string) (bool, error) {
+	for range blobRetries {
+		res := db.Where("storage_path = ? AND ref_count <= 1", path).Delete(&models.Blob{})
+		if res.Error != nil {
+			return false, res.Error
+		}
+		if res.RowsAffected == 1 {
+			return true, nil
+		}
+
+		res = db.Model(&models.Blob{}).Where("storage_path = ? AND ref_count > 1", path).
+			UpdateColumn("ref_count", gorm.Expr("ref_count - 1"))
+		if res.Error != nil {
+			return false, res.Error
+		}
+		if res.RowsAffected == 1 {
+			return false, nil
+		}
+
+		var blob models.Blob
+		err := db.Where("storage_path = ?", path).First(&blob).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			referenced, err := IsReferenced(db, path)
+			return !referenced, err
+		}
+		if err != nil {
+			return false, err
+		}
+		// The count changed between the two statements
+	}
+	return false, fmt.Errorf("blob at %s kept changing while releasing", path)
+}
+
+// BlobBackfill summarizes a BackfillBlobs run.
+type BlobBackfill struct {
+	Blobs          int   // Blobs created
+	Collapsed      int   // Duplicate objects whose files now share a blob
+	Deleted        int   // Duplicate objects deleted from storage
+	ReclaimedBytes int64 // Their total size
+}
+
+// BackfillBlobs creates blobs for content stored before blobs were tracked,
+// collapsing duplicates on the way: files with the same content, which were
+// only deduplicated per user, are pointed at one object and the other copies
+// are deleted. Only copies under the same storage policy are collapsed, and
+// unless crossUser is set (ENABLE_FILE_DEDUPLICATION) only copies belonging
+// to the same user. Content that already has
+// a blob is skipped, so it is cheap to run at every startup. Run it before
+// accepting uploads.
+func BackfillBlobs(ctx context.Context, db *gorm.DB, backend storage.StorageBackend, crossUser bool) (BlobBackfill, error) {
+	var summary BlobBackfill
+	cursor := ""
+	for {
+		var hashes []string
+		if err := db.Model(&models.File{}).
+			Joins("LEFT JOIN blobs ON blobs.hash = files.hash").
+			Where("files.upload_status = ? AND files.hash > ? AND blobs.hash IS NULL", "completed", cursor).
+			Distinct().
+			Order("files.hash").
+			Limit(100).
+			Pluck("files.hash", &hashes).Error; err != nil {
+			return summary, err
+		}
+		if len(hashes) == 0 {
+			return summary, nil
+		}
+		for _, hash := range hashes {
+			if err := ctx.Err(); err != nil {
+				return summary, err
+			}
+			if err := backfillBlob(ctx, db, backend, hash, crossUser, &summary); err != nil {
+				return summary, fmt.Errorf("failed to backfill blob %s: %w", hash, err)
+			}
+		}
+		cursor = hashes[len(hashes)-1]
+	}
+}
+
+func backfillBlob(ctx context.Context, db *gorm.DB, backend storage.StorageBackend, hash string, crossUser bool, summary *BlobBackfill) error {
+	var copies []struct {
+		StoragePath string
+		FileSize    int64
+		Refs        int64
+		MinUserID   uint
+		MaxUserID   uint
+	}
+	if err := db.Model(&models.File{}).
+		Select("storage_path, MAX(file_size) AS file_size, COUNT(*) AS refs, MIN(user_id) AS min_user_id, MAX(user_id) AS max_user_id").
+		Where("hash = ? AND upload_status = ?", hash, "completed").
+		Group("storage_path").
+		Order("refs DESC, storage_path").
+		Scan(&copies).Error; err != nil {
+		return err
+	}
+	if len(copies) == 0 {
+		return nil
+	}
+
+	// Keep the most shared copy that still exists
+	keep := 0
+	for i, c := range copies {
+		_, err := backend.Stat(ctx, c.StoragePath)
+		if err == nil {
+			keep = i
+			break
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			return err // Never pick a copy while storage is unreachable
+		}
+	}
+	canonical := copies[keep]
+
+	// Copies under another storage policy, and without cross-user
+	// deduplication copies of other users, keep their own objects, without
+	// a blob
+	policy, owner := storage.PolicyOf(canonical.StoragePath), canonical.MinUserID
+	kept, keptIndex := copies[:0], 0
+	for i, c := range copies {
+		if i == keep {
+			keptIndex = len(kept)
+		} else if storage.PolicyOf(c.StoragePath) != policy {
+			continue
+		} else if !crossUser && (canonical.MaxUserID != owner || c.MinUserID != owner || c.MaxUserID != owner) {
+			continue
+		}
+		kept = append(kept, c)
+	}
+	copies, keep = kept, keptIndex
+
+	var refs int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for i, c := range copies {
+			if i == keep {
+				continue
+			}
+			if err := ReplaceStoragePath(tx, c.StoragePath, canonical.StoragePath); err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&models.File{}).
+			Where("storage_path = ? AND upload_status = ?", canonical.StoragePath, "completed").
+			Count(&refs).Error; err != nil {
+			return err
+		}
+		var versionRefs int64
+		if err := tx.Model(&models.FileVersion{}).
+			Where("storage_path = ?", canonical.StoragePath).
+			Count(&versionRefs).Error; err != nil {
+			return err
+		}
+		refs += versionRefs
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.Blob{
+			Hash:        hash,
+			StoragePath: canonical.StoragePath,
+			Size:        canonical.FileSize,
+			RefCount:    refs,
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+	summary.Blobs++
+
+	for i, c := range copies {
+		if i == keep {
+			continue
+		}
+		summary.Collapsed++
+		if referenced, err := IsReferenced(db, c.StoragePath); err != nil || referenced {
+			continue
+		}
+		if err := backend.Delete(ctx, c.StoragePath); err != nil {
+			logger.Warn("failed to delete duplicate object", "path", c.StoragePath, "error", err)
+			continue
+		}
+		summary.Deleted++
+		summary.ReclaimedBytes += c.FileSize
+	}
+	return nil
+}
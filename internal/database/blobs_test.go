@@ -0,0 +1,292 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/agjmills/trove/internal/database/models"
+	"github.com/agjmills/trove/internal/storage"
+)
+
+func newBlobTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := fmt.Sprintf("file:blobs-%d?mode=memory&cache=shared", time.Now().UnixNano())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to open test db: %v", err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.File{}, &models.FileVersion{}, &models.Blob{}); err != nil {
+		t.Fatalf("failed to migrate test db: %v", err)
+	}
+	return db
+}
+
+func refCount(t *testing.T, db *gorm.DB, hash string) int64 {
+	t.Helper()
+	var blob models.Blob
+	if err := db.Where("hash = ?", hash).First(&blob).Error; err != nil {
+		return 0
+	}
+	return blob.RefCount
+}
+
+func TestBlobReferenceCounting(t *testing.T) {
+	db := newBlobTestDB(t)
+
+	path, err := AcquireBlob(db, "h1", BlobScope{})
+	if err != nil || path != "" {
+		t.Fatalf("expected no blob, got %q (err %v)", path, err)
+	}
+
+	path, err = RegisterBlob(db, "h1", "obj-1", 10, BlobScope{})
+	if err != nil || path != "obj-1" {
+		t.Fatalf("RegisterBlob = %q, %v", path, err)
+	}
+	if path, _ = AcquireBlob(db, "h1", BlobScope{}); path != "obj-1" {
+		t.Fatalf("AcquireBlob = %q, want obj-1", path)
+	}
+	if err := AcquireBlobPath(db, "obj-1"); err != nil {
+		t.Fatalf("AcquireBlobPath failed: %v", err)
+	}
+	if n := refCount(t, db, "h1"); n != 3 {
+		t.Fatalf("expected 3 references, got %d", n)
+	}
+
+	for i, wantLast := range []bool{false, false, true} {
+		last, err := ReleaseBlob(db, "obj-1")
+		if err != nil {
+			t.Fatalf("ReleaseBlob failed: %v", err)
+		}
+		if last != wantLast {
+			t.Fatalf("release %d: last = %v, want %v", i+1, last, wantLast)
+		}
+	}
+	if n := refCount(t, db, "h1"); n != 0 {
+		t.Errorf("blob should be gone after the last release, has %d references", n)
+	}
+}
+
+func TestRegisterBlobConcurrentUpload(t *testing.T) {
+	db := newBlobTestDB(t)
+
+	if _, err := RegisterBlob(db, "h1", "obj-1", 10, BlobScope{}); err != nil {
+		t.Fatalf("RegisterBlob failed: %v", err)
+	}
+	// A second upload of the same content saved its own object meanwhile
+	path, err := RegisterBlob(db, "h1", "obj-2", 10, BlobScope{})
+	if err != nil {
+		t.Fatalf("RegisterBlob failed: %v", err)
+	}
+	if path != "obj-1" {
+		t.Errorf("expected the first object to win, got %q", path)
+	}
+	if n := refCount(t, db, "h1"); n != 2 {
+		t.Errorf("expected 2 references, got %d", n)
+	}
+}
+
+func TestReleaseBlobUntracked(t *testing.T) {
+	db := newBlobTestDB(t)
+	db.Create(&models.File{UserID: 1, Filename: "a", OriginalFilename: "a", StoragePath: "legacy", UploadStatus: "completed"})
+
+	last, err := ReleaseBlob(db, "legacy")
+	if err != nil || last {
+		t.Errorf("object still referenced by a file: last = %v, err %v", last, err)
+	}
+	last, err = ReleaseBlob(db, "unused")
+	if err != nil || !last {
+		t.Errorf("unreferenced object: last = %v, err %v", last, err)
+	}
+}
+
+func TestBackfillBlobsCollapsesDuplicates(t *testing.T) {
+	ctx := context.Background()
+	db := newBlobTestDB(t)
+	backend := storage.NewMemoryBackend()
+
+	save := func(content string) storage.SaveResult {
+		result, err := backend.Save(ctx, strings.NewReader(content), storage.SaveOptions{OriginalFilename: "f.txt"})
+		if err != nil {
+			t.Fatalf("failed to save object: %v", err)
+		}
+		return result
+	}
+	create := func(userID uint, result storage.SaveResult) {
+		db.Create(&models.File{UserID: userID, Filename: "f.txt", OriginalFilename: "f.txt", StoragePath: result.Path,
+			FileSize: result.Size, Hash: result.Hash, UploadStatus: "completed"})
+	}
+
+	// Two users each stored the same content; the first has two copies of it
+	first := save("same content")
+	second := save("same content")
+	unique := save("unique content")
+	create(1, first)
+	create(1, first)
+	create(2, second)
+	create(2, unique)
+
+	summary, err := BackfillBlobs(ctx, db, backend, true)
+	if err != nil {
+		t.Fatalf("BackfillBlobs failed: %v", err)
+	}
+	if summary.Blobs != 2 || summary.Collapsed != 1 || summary.Deleted != 1 || summary.ReclaimedBytes != second.Size {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+
+	var paths []string
+	db.Model(&models.File{}).Where("hash = ?", first.Hash).Distinct().Pluck("storage_path", &paths)
+	if len(paths) != 1 || paths[0] != first.Path {
+		t.Errorf("expected all copies to point at %s, got %v", first.Path, paths)
+	}
+	if n := refCount(t, db, first.Hash); n != 3 {
+		t.Errorf("expected 3 references, got %d", n)
+	}
+	if _, err := backend.Stat(ctx, second.Path); err == nil {
+		t.Error("duplicate object was not deleted")
+	}
+
+	// Content with a blob is skipped on the next run
+	summary, err = BackfillBlobs(ctx, db, backend, true)
+	if err != nil || summary.Blobs != 0 {
+		t.Errorf("second run should do nothing, got %+v (err %v)", summary, err)
+	}
+}
+
+func TestAcquireBlobScopedToUser(t *testing.T) {
+	db := newBlobTestDB(t)
+	db.Create(&models.File{UserID: 1, Filename: "a", OriginalFilename: "a", StoragePath: "obj-1", Hash: "h1", UploadStatus: "completed"})
+	if _, err := RegisterBlob(db, "h1", "obj-1", 10, BlobScope{}); err != nil {
+		t.Fatalf("RegisterBlob failed: %v", err)
+	}
+
+	if path, err := AcquireBlob(db, "h1", BlobScope{UserID: 2}); err != nil || path != "" {
+		t.Errorf("another user's blob was shared: %q (err %v)", path, err)
+	}
+	// The other user's copy is kept without a blob, and found again
+	if path, err := RegisterBlob(db, "h1", "obj-2", 10, BlobScope{UserID: 2}); err != nil || path != "obj-2" {
+		t.Errorf("RegisterBlob = %q, %v, want obj-2 kept", path, err)
+	}
+	db.Create(&models.File{UserID: 2, Filename: "b", OriginalFilename: "b", StoragePath: "obj-2", Hash: "h1", UploadStatus: "completed"})
+	if path, _ := AcquireBlob(db, "h1", BlobScope{UserID: 2}); path != "obj-2" {
+		t.Errorf("AcquireBlob = %q, want the user's own obj-2", path)
+	}
+	if path, _ := AcquireBlob(db, "h1", BlobScope{UserID: 1}); path != "obj-1" {
+		t.Errorf("AcquireBlob = %q, want obj-1", path)
+	}
+	if n := refCount(t, db, "h1"); n != 2 {
+		t.Errorf("expected 2 references, got %d", n)
+	}
+}
+
+func TestBackfillBlobsKeepsUsersApart(t *testing.T) {
+	ctx := context.Background()
+	db := newBlobTestDB(t)
+	backend := storage.NewMemoryBackend()
+
+	var paths []string
+	var hash string
+	for _, userID := range []uint{1, 1, 2} {
+		result, err := backend.Save(ctx, strings.NewReader("same content"), storage.SaveOptions{OriginalFilename: "f.txt"})
+		if err != nil {
+			t.Fatalf("failed to save object: %v", err)
+		}
+		db.Create(&models.File{UserID: userID, Filename: "f.txt", OriginalFilename: "f.txt", StoragePath: result.Path,
+			FileSize: result.Size, Hash: result.Hash, UploadStatus: "completed"})
+		paths, hash = append(paths, result.Path), result.Hash
+	}
+	// The most shared copy is kept: make it user 1's
+	db.Create(&models.File{UserID: 1, Filename: "g.txt", OriginalFilename: "g.txt", StoragePath: paths[0],
+		FileSize: 12, Hash: hash, UploadStatus: "completed"})
+
+	summary, err := BackfillBlobs(ctx, db, backend, false)
+	if err != nil {
+		t.Fatalf("BackfillBlobs failed: %v", err)
+	}
+	if summary.Blobs != 1 || summary.Collapsed != 1 {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+	var user2 models.File
+	db.Where("user_id = ?", 2).First(&user2)
+	if user2.StoragePath != paths[2] {
+		t.Errorf("user 2's file moved to %s", user2.StoragePath)
+	}
+	if _, err := backend.Stat(ctx, paths[2]); err != nil {
+		t.Errorf("user 2's object was deleted: %v", err)
+	}
+}
+
+func TestAcquireBlobScopedToPolicy(t *testing.T) {
+	db := newBlobTestDB(t)
+	db.Create(&models.File{UserID: 1, Filename: "a", OriginalFilename: "a", StoragePath: "obj-1", Hash: "h1", UploadStatus: "completed"})
+	if _, err := RegisterBlob(db, "h1", "obj-1", 10, BlobScope{}); err != nil {
+		t.Fatalf("RegisterBlob failed: %v", err)
+	}
+
+	if path, err := AcquireBlob(db, "h1", BlobScope{Policy: "archive"}); err != nil || path != "" {
+		t.Errorf("a blob in the default backend was shared under another policy: %q (err %v)", path, err)
+	}
+	// The copy under the policy is kept without a blob, and found again
+	if path, err := RegisterBlob(db, "h1", "archive:obj-2", 10, BlobScope{Policy: "archive"}); err != nil || path != "archive:obj-2" {
+		t.Errorf("RegisterBlob = %q, %v, want archive:obj-2 kept", path, err)
+	}
+	db.Create(&models.File{UserID: 1, Filename: "b", OriginalFilename: "b", StoragePath: "archive:obj-2", Hash: "h1", UploadStatus: "completed"})
+	if path, _ := AcquireBlob(db, "h1", BlobScope{Policy: "archive"}); path != "archive:obj-2" {
+		t.Errorf("AcquireBlob = %q, want archive:obj-2", path)
+	}
+	if path, _ := AcquireBlob(db, "h1", BlobScope{UserID: 1}); path != "obj-1" {
+		t.Errorf("AcquireBlob = %q, want obj-1", path)
+	}
+	if n := refCount(t, db, "h1"); n != 2 {
+		t.Errorf("expected 2 references, got %d", n)
+	}
+}
+
+func TestBackfillBlobsKeepsPoliciesApart(t *testing.T) {
+	ctx := context.Background()
+	def := storage.NewMemoryBackend()
+	backend, err := storage.NewPolicyBackend(def, storage.Member{Name: "archive", Backend: storage.NewMemoryBackend()})
+	if err != nil {
+		t.Fatalf("NewPolicyBackend failed: %v", err)
+	}
+	db := newBlobTestDB(t)
+
+	var paths []string
+	var hash string
+	for _, policy := range []string{"", "", "archive"} {
+		result, err := backend.Save(ctx, strings.NewReader("same content"), storage.SaveOptions{OriginalFilename: "f.txt", Policy: policy})
+		if err != nil {
+			t.Fatalf("failed to save object: %v", err)
+		}
+		db.Create(&models.File{UserID: 1, Filename: "f.txt", OriginalFilename: "f.txt", StoragePath: result.Path,
+			FileSize: result.Size, Hash: result.Hash, UploadStatus: "completed"})
+		paths, hash = append(paths, result.Path), result.Hash
+	}
+	// The most shared copy is kept: make it one in the default backend
+	db.Create(&models.File{UserID: 1, Filename: "g.txt", OriginalFilename: "g.txt", StoragePath: paths[0],
+		FileSize: 12, Hash: hash, UploadStatus: "completed"})
+
+	summary, err := BackfillBlobs(ctx, db, backend, true)
+	if err != nil {
+		t.Fatalf("BackfillBlobs failed: %v", err)
+	}
+	if summary.Blobs != 1 || summary.Collapsed != 1 {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+	var archived int64
+	db.Model(&models.File{}).Where("storage_path = ?", paths[2]).Count(&archived)
+	if archived != 1 {
+		t.Errorf("the archived file was moved out of its policy")
+	}
+	if _, err := backend.Stat(ctx, paths[2]); err != nil {
+		t.Errorf("the archived object was deleted: %v", err)
+	}
+}
@@ -60,10 +60,16 @@ func Migrate(db *gorm.DB) error {
 		&models.User{},
 		&models.Folder{},
 		&models.File{},
+		&models.FileVersion{},
+		&models.Blob{},
 		&models.UploadSession{},
 		&models.TranscodeJob{},
+		&models.ExtractJob{},
+		&models.ScrubRun{},
+		&models.Replica{},
 		&models.ShareLink{},
 		&models.FolderShareLink{},
+		&models.APIToken{},
 	)
 	if err != nil {
 		return fmt.Errorf("migration failed: %w", err)
@@ -18,6 +18,10 @@ type User struct {
 	DeletedRetentionDays *int           `gorm:"column:trash_retention_days;default:null" json:"deleted_retention_days,omitempty"` // Per-user deleted items retention (nil = use system default)
 	IdentityProvider     string         `gorm:"not null;size:50;default:'internal'" json:"identity_provider"`                     // "internal" or "oidc"
 	OIDCSubject          string         `gorm:"column:oidc_subject;size:255;index" json:"-"`                                      // OIDC "sub" claim; set on first OIDC login
+	StoragePolicy        string         `gorm:"size:50;not null;default:''" json:"storage_policy,omitempty"`                      // Storage policy for new files ("" = default backend)
+	VersioningEnabled    bool           `gorm:"not null;default:false" json:"versioning_enabled"`                                 // Re-uploading a file adds a version instead of a renamed copy
+	MaxFileVersions      *int           `gorm:"default:null" json:"max_file_versions,omitempty"`                                  // Previous versions kept per file (nil = use system default, 0 = unlimited)
+	VersionRetentionDays *int           `gorm:"default:null" json:"version_retention_days,omitempty"`                             // Days previous versions are kept (nil = use system default, 0 = forever)
 	CreatedAt            time.Time      `json:"created_at"`
 	UpdatedAt            time.Time      `json:"updated_at"`
 	DeletedAt            gorm.DeletedAt `gorm:"index" json:"-"`
@@ -30,8 +34,9 @@ type Folder struct {
 	ID                 uint           `gorm:"primaryKey" json:"id"`
 	UserID             uint           `gorm:"not null;index:idx_user_folder_path" json:"user_id"`
 	FolderPath         string         `gorm:"not null;size:1024;index:idx_user_folder_path" json:"folder_path"`
-	SoftDeletedAt      *time.Time     `gorm:"column:trashed_at;index" json:"soft_deleted_at,omitempty"` // When folder was soft-deleted (nil = not deleted)
-	OriginalFolderPath string         `gorm:"size:1024" json:"original_folder_path,omitempty"`          // Original path before deletion (for restore)
+	SoftDeletedAt      *time.Time     `gorm:"column:trashed_at;index" json:"soft_deleted_at,omitempty"`    // When folder was soft-deleted (nil = not deleted)
+	OriginalFolderPath string         `gorm:"size:1024" json:"original_folder_path,omitempty"`             // Original path before deletion (for restore)
+	StoragePolicy      string         `gorm:"size:50;not null;default:''" json:"storage_policy,omitempty"` // Storage policy for new files in this folder and its subfolders ("" = inherit)
 	CreatedAt          time.Time      `json:"created_at"`
 	UpdatedAt          time.Time      `json:"updated_at"`
 	DeletedAt          gorm.DeletedAt `gorm:"index" json:"-"`
@@ -42,25 +47,30 @@ type Folder struct {
 type File struct {
 	ID                  uint                                  `gorm:"primaryKey" json:"id"`
 	UserID              uint                                  `gorm:"not null;index" json:"user_id"`
-	StoragePath         string                                `gorm:"not null;size:1024;index" json:"storage_path"`             // UUID-based path for storage operations (not unique - deduplication)
+	StoragePath         string                                `gorm:"not null;size:1024;index" json:"storage_path"`             // UUID-based path for storage operations, prefixed "<policy>:" when stored under a storage policy (not unique - deduplication, see Blob)
 	LogicalPath         string                                `gorm:"not null;size:1024;default:'/';index" json:"logical_path"` // Logical folder path for UI navigation
 	Filename            string                                `gorm:"not null;size:255" json:"filename"`                        // Display name (editable)
 	OriginalFilename    string                                `gorm:"not null;size:255" json:"original_filename"`               // Original name (immutable)
 	FileSize            int64                                 `gorm:"not null" json:"file_size"`
 	MimeType            string                                `gorm:"size:100" json:"mime_type"`
 	Hash                string                                `gorm:"index;size:64" json:"hash"`
-	UploadStatus        string                                `gorm:"size:20;default:'completed';index" json:"upload_status"`   // Upload status: pending, uploading, completed, failed
-	ErrorMessage        string                                `gorm:"size:500" json:"error_message,omitempty"`                  // Error message for failed uploads
-	TempPath            string                                `gorm:"size:1024" json:"-"`                                       // Temporary local path (used during async upload, not shown to user)
-	Metadata            datatypes.JSONType[map[string]string] `json:"metadata"`                                                 // Arbitrary key-value metadata
-	Tags                datatypes.JSONType[[]string]          `json:"tags"`                                                     // Simple string tags for filtering
-	VideoVariantPath    string                                `gorm:"size:1024;index" json:"video_variant_path,omitempty"`      // Storage path of the web-optimized MP4 variant (empty = none)
-	VideoVariantSize    int64                                 `gorm:"default:0" json:"video_variant_size,omitempty"`            // Size of the transcoded variant in bytes
-	VideoVariantMime    string                                `gorm:"size:100" json:"video_variant_mime,omitempty"`             // MIME type of the transcoded variant (e.g. video/mp4)
-	TranscodeStatus     string                                `gorm:"size:20;default:'none';index" json:"transcode_status"`     // Transcode status: none, pending, processing, completed, failed
-	TranscodeError      string                                `gorm:"size:500" json:"transcode_error,omitempty"`                // Error message for failed transcodes
-	SoftDeletedAt       *time.Time                            `gorm:"column:trashed_at;index" json:"soft_deleted_at,omitempty"` // When file was soft-deleted (nil = not deleted)
-	OriginalLogicalPath string                                `gorm:"size:1024" json:"original_logical_path,omitempty"`         // Original path before deletion (for restore)
+	UploadStatus        string                                `gorm:"size:20;default:'completed';index" json:"upload_status"`     // Upload status: pending, uploading, completed, failed
+	ErrorMessage        string                                `gorm:"size:500" json:"error_message,omitempty"`                    // Error message for failed uploads
+	TempPath            string                                `gorm:"size:1024" json:"-"`                                         // Temporary local path (used during async upload, not shown to user)
+	Metadata            datatypes.JSONType[map[string]string] `json:"metadata"`                                                   // Arbitrary key-value metadata
+	Tags                datatypes.JSONType[[]string]          `json:"tags"`                                                       // Simple string tags for filtering
+	VideoVariantPath    string                                `gorm:"size:1024;index" json:"video_variant_path,omitempty"`        // Storage path of the web-optimized MP4 variant (empty = none)
+	VideoVariantSize    int64                                 `gorm:"default:0" json:"video_variant_size,omitempty"`              // Size of the transcoded variant in bytes
+	VideoVariantMime    string                                `gorm:"size:100" json:"video_variant_mime,omitempty"`               // MIME type of the transcoded variant (e.g. video/mp4)
+	TranscodeStatus     string                                `gorm:"size:20;default:'none';index" json:"transcode_status"`       // Transcode status: none, pending, processing, completed, failed
+	TranscodeError      string                                `gorm:"size:500" json:"transcode_error,omitempty"`                  // Error message for failed transcodes
+	IntegrityStatus     string                                `gorm:"size:20;default:'unverified';index" json:"integrity_status"` // Result of the last scrub: unverified, ok, missing, corrupted
+	VerifiedAt          *time.Time                            `gorm:"index" json:"verified_at,omitempty"`                         // When the scrubber last checked the stored object
+	LastAccessedAt      *time.Time                            `gorm:"index" json:"last_accessed_at,omitempty"`                    // When the content was last read (download, preview, stream, share); recorded at most hourly
+	Version             int                                   `gorm:"not null;default:1" json:"version"`                          // Number of the current content; earlier ones are FileVersions
+	ContentUpdatedAt    *time.Time                            `json:"content_updated_at,omitempty"`                               // When the current version was uploaded (nil = CreatedAt, the first version)
+	SoftDeletedAt       *time.Time                            `gorm:"column:trashed_at;index" json:"soft_deleted_at,omitempty"`   // When file was soft-deleted (nil = not deleted)
+	OriginalLogicalPath string                                `gorm:"size:1024" json:"original_logical_path,omitempty"`           // Original path before deletion (for restore)
 	CreatedAt           time.Time                             `json:"created_at"`
 	UpdatedAt           time.Time                             `json:"updated_at"`
 	DeletedAt           gorm.DeletedAt                        `gorm:"index" json:"-"`
@@ -68,6 +78,38 @@ type File struct {
 	User User `gorm:"foreignKey:UserID" json:"-"`
 }
 
+// FileVersion is a previous content of a file, kept when a user with
+// versioning enabled uploads a file under an existing name. It holds its own
+// reference on the stored object and counts towards its owner's quota until
+// it is pruned or the file is permanently deleted.
+type FileVersion struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	FileID      uint      `gorm:"not null;uniqueIndex:idx_file_version" json:"file_id"`
+	UserID      uint      `gorm:"not null;index" json:"user_id"`
+	Version     int       `gorm:"not null;uniqueIndex:idx_file_version" json:"version"`
+	StoragePath string    `gorm:"not null;size:1024;index" json:"storage_path"`
+	FileSize    int64     `gorm:"not null" json:"file_size"`
+	MimeType    string    `gorm:"size:100" json:"mime_type"`
+	Hash        string    `gorm:"index;size:64" json:"hash"`
+	UploadedAt  time.Time `json:"uploaded_at"`             // When this content was uploaded
+	CreatedAt   time.Time `gorm:"index" json:"created_at"` // When a newer version replaced it
+
+	File File `gorm:"foreignKey:FileID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+// Blob is a stored object shared by every file with the same content, across
+// all users. RefCount is the number of file and file version rows whose
+// StoragePath points at it; the object is deleted when the last one goes.
+// Video variants are not tracked here.
+type Blob struct {
+	Hash        string    `gorm:"primaryKey;size:64" json:"hash"` // SHA-256 of the content
+	StoragePath string    `gorm:"not null;size:1024;uniqueIndex" json:"storage_path"`
+	Size        int64     `gorm:"not null" json:"size"`
+	RefCount    int64     `gorm:"not null;default:0" json:"ref_count"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
 // ShareLink represents a public download link for a file
 type ShareLink struct {
 	ID           uint           `gorm:"primaryKey" json:"id"`
@@ -101,7 +143,8 @@ type FolderShareLink struct {
 	User User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
 }
 
-// UploadSession tracks state for resumable chunked uploads
+// UploadSession tracks state for resumable uploads, both the chunked
+// /api/uploads protocol and tus (TotalChunks is 0 for tus sessions).
 type UploadSession struct {
 	ID             string                       `gorm:"primaryKey;size:36" json:"id"` // UUID
 	UserID         uint                         `gorm:"not null;index" json:"user_id"`
@@ -112,15 +155,22 @@ type UploadSession struct {
 	ChunkSize      int64                        `gorm:"not null" json:"chunk_size"`
 	ReceivedChunks int                          `gorm:"not null;default:0" json:"received_chunks"`
 	ChunksReceived datatypes.JSON               `gorm:"type:json" json:"chunks_received"`             // Array of chunk numbers received
+	UploadOffset   int64                        `gorm:"not null;default:0" json:"upload_offset"`      // Bytes received so far (tus uploads only)
 	Status         string                       `gorm:"size:20;default:'active';index" json:"status"` // active, completed, canceled, expired
 	Hash           string                       `gorm:"size:64" json:"hash,omitempty"`                // Expected hash for verification (optional)
 	MimeType       string                       `gorm:"size:100" json:"mime_type"`
-	Tags           datatypes.JSONType[[]string] `json:"tags"`                      // Tags to apply to the completed file
-	TempDir        string                       `gorm:"size:1024" json:"temp_dir"` // Temporary directory for chunks
-	CreatedAt      time.Time                    `json:"created_at"`
-	UpdatedAt      time.Time                    `json:"updated_at"`
-	ExpiresAt      time.Time                    `gorm:"index" json:"expires_at"` // When this session should be cleaned up
-	DeletedAt      gorm.DeletedAt               `gorm:"index" json:"-"`
+	Tags           datatypes.JSONType[[]string] `json:"tags"`                           // Tags to apply to the completed file
+	TempDir        string                       `gorm:"size:1024" json:"temp_dir"`      // Temporary directory for chunks
+	ConflictPolicy string                       `gorm:"size:20" json:"conflict_policy"` // If the folder has a file of the same name: replace, keep_both, skip ("" = new version or keep both)
+	// Multipart upload in the storage backend that chunks are sent to as
+	// they arrive; "" if they are assembled in TempDir on completion
+	StorageUploadID string         `gorm:"size:1024" json:"-"`
+	HashState       []byte         `json:"-"`                           // SHA-256 state after the first HashedChunks chunks
+	HashedChunks    int            `gorm:"not null;default:0" json:"-"` // Chunks of a multipart upload hashed so far
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
+	ExpiresAt       time.Time      `gorm:"index" json:"expires_at"` // When this session should be cleaned up
+	DeletedAt       gorm.DeletedAt `gorm:"index" json:"-"`
 
 	User User `gorm:"foreignKey:UserID" json:"-"`
 }
@@ -141,3 +191,72 @@ type TranscodeJob struct {
 	CreatedAt     time.Time  `json:"created_at"`
 	UpdatedAt     time.Time  `json:"updated_at"`
 }
+
+// ExtractJob extracts an archive file into the folder it is in. Jobs are
+// processed one at a time by the file handler's extraction worker, which
+// records its progress here for the status stream.
+type ExtractJob struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	UserID        uint       `gorm:"not null;index" json:"user_id"`
+	FileID        uint       `gorm:"not null;index" json:"file_id"`                          // The archive
+	Filename      string     `gorm:"size:255" json:"filename"`                               // Name of the archive when the job was queued
+	FolderPath    string     `gorm:"not null;size:1024" json:"folder_path"`                  // Folder the entries are extracted into
+	Status        string     `gorm:"size:20;not null;default:'pending';index" json:"status"` // pending, running, completed, failed
+	Error         string     `gorm:"size:500" json:"error,omitempty"`
+	Entries       int        `gorm:"not null;default:0" json:"entries"`        // Files and folders in the archive (0 until it has been checked)
+	Processed     int        `gorm:"not null;default:0" json:"processed"`      // Entries extracted so far, in archive order
+	TotalSize     int64      `gorm:"not null;default:0" json:"total_size"`     // Expanded size of the files in the archive
+	ExtractedSize int64      `gorm:"not null;default:0" json:"extracted_size"` // Bytes extracted so far
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// ScrubRun is one pass of the storage integrity scrubber over every stored
+// object. Runs are queued by the schedule or by an admin and processed by
+// the scrub worker one at a time.
+type ScrubRun struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	Status     string     `gorm:"size:20;not null;default:'pending';index" json:"status"` // pending, running, completed, failed
+	Trigger    string     `gorm:"size:20;not null" json:"trigger"`                        // schedule, admin
+	Error      string     `gorm:"size:500" json:"error,omitempty"`
+	Checked    int64      `gorm:"not null;default:0" json:"checked"` // Objects checked so far
+	OK         int64      `gorm:"not null;default:0" json:"ok"`
+	Missing    int64      `gorm:"not null;default:0" json:"missing"`
+	Corrupted  int64      `gorm:"not null;default:0" json:"corrupted"`
+	Errors     int64      `gorm:"not null;default:0" json:"errors"` // Objects that could not be read for other reasons (left unchanged)
+	BytesRead  int64      `gorm:"not null;default:0" json:"bytes_read"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// Replica records a member of a mirrored storage backend that lacks a
+// complete copy of an object. Objects without rows are fully replicated;
+// the mirror repair job copies the object and deletes the row.
+type Replica struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	StoragePath string    `gorm:"not null;size:1024;uniqueIndex:idx_replica_object" json:"storage_path"`
+	Member      string    `gorm:"not null;size:255;uniqueIndex:idx_replica_object;index" json:"member"` // "primary" or the STORAGE_MIRRORS entry
+	Error       string    `gorm:"size:500" json:"error,omitempty"`
+	CreatedAt   time.Time `json:"created_at"` // When the copy was first found missing
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// APIToken is a personal access token used by non-browser clients.
+// Only the SHA-256 of the token is stored; the plaintext is shown once at creation.
+type APIToken struct {
+	ID          uint                         `gorm:"primaryKey" json:"id"`
+	UserID      uint                         `gorm:"not null;index" json:"user_id"`
+	Name        string                       `gorm:"not null;size:100" json:"name"`
+	TokenHash   string                       `gorm:"uniqueIndex;not null;size:64" json:"-"`
+	TokenPrefix string                       `gorm:"not null;size:16" json:"token_prefix"` // First characters of the token, for identification in the UI
+	Scopes      datatypes.JSONType[[]string] `json:"scopes"`
+	ExpiresAt   *time.Time                   `gorm:"index" json:"expires_at,omitempty"` // nil = never expires
+	LastUsedAt  *time.Time                   `json:"last_used_at,omitempty"`
+	CreatedAt   time.Time                    `json:"created_at"`
+	DeletedAt   gorm.DeletedAt               `gorm:"index" json:"-"`
+
+	User User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+}
@@ -0,0 +1,173 @@
+package database
+
+import (
+	"gorm.io/gorm"
+
+	"github.com/agjmills/trove/internal/database/models"
+)
+
+// StoredObject is an object in the storage backend referenced by one or more
+// files or file versions. Deduplicated files share an object, and a video
+// variant can be the original object itself.
+type StoredObject struct {
+	Path    string
+	Hash    string // SHA-256 of the content; empty for video variants, which have no recorded hash
+	Size    int64
+	Variant bool // Web-optimized video variant rather than an uploaded original
+}
+
+// StoredObjects returns every distinct object referenced by a completed
+// upload, including files in deleted items, previous file versions and video
+// variants. Placeholder paths of uploads still being processed are excluded.
+func StoredObjects(db *gorm.DB) ([]StoredObject, error) {
+	var originals []struct {
+		StoragePath string
+		Hash        string
+		FileSize    int64
+	}
+	if err := db.Unscoped().Model(&models.File{}).
+		Where("upload_status = ?", "completed").
+		Distinct("storage_path", "hash", "file_size").
+		Order("storage_path").
+		Find(&originals).Error; err != nil {
+		return nil, err
+	}
+
+	var versions []struct {
+		StoragePath string
+		Hash        string
+		FileSize    int64
+	}
+	if err := db.Model(&models.FileVersion{}).
+		Distinct("storage_path", "hash", "file_size").
+		Order("storage_path").
+		Find(&versions).Error; err != nil {
+		return nil, err
+	}
+	originals = append(originals, versions...)
+
+	var variants []struct {
+		VideoVariantPath string
+		VideoVariantSize int64
+	}
+	if err := db.Unscoped().Model(&models.File{}).
+		Where("upload_status = ? AND video_variant_path <> ''", "completed").
+		Distinct("video_variant_path", "video_variant_size").
+		Order("video_variant_path").
+		Find(&variants).Error; err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(originals)+len(variants))
+	objects := make([]StoredObject, 0, len(originals)+len(variants))
+	for _, o := range originals {
+		if o.StoragePath != "" && !seen[o.StoragePath] {
+			seen[o.StoragePath] = true
+			objects = append(objects, StoredObject{Path: o.StoragePath, Hash: o.Hash, Size: o.FileSize})
+		}
+	}
+	for _, v := range variants {
+		if !seen[v.VideoVariantPath] {
+			seen[v.VideoVariantPath] = true
+			objects = append(objects, StoredObject{Path: v.VideoVariantPath, Size: v.VideoVariantSize, Variant: true})
+		}
+	}
+	return objects, nil
+}
+
+// ReplaceStoragePath points every file and file version referencing oldPath,
+// as its original or its video variant, and its blob at newPath.
+func ReplaceStoragePath(db *gorm.DB, oldPath, newPath string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&models.File{}).Where("storage_path = ?", oldPath).
+			UpdateColumn("storage_path", newPath).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.FileVersion{}).Where("storage_path = ?", oldPath).
+			UpdateColumn("storage_path", newPath).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Blob{}).Where("storage_path = ?", oldPath).
+			UpdateColumn("storage_path", newPath).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Model(&models.File{}).Where("video_variant_path = ?", oldPath).
+			UpdateColumn("video_variant_path", newPath).Error
+	})
+}
+
+// ReferencedPaths returns every storage path referenced by a file in any
+// state, as its original or its video variant, by a file version or by a
+// blob.
+func ReferencedPaths(db *gorm.DB) (map[string]bool, error) {
+	refs := make(map[string]bool)
+	var blobPaths []string
+	if err := db.Model(&models.Blob{}).Pluck("storage_path", &blobPaths).Error; err != nil {
+		return nil, err
+	}
+	var versionPaths []string
+	if err := db.Model(&models.FileVersion{}).Distinct().Pluck("storage_path", &versionPaths).Error; err != nil {
+		return nil, err
+	}
+	for _, p := range append(blobPaths, versionPaths...) {
+		refs[p] = true
+	}
+	for _, column := range []string{"storage_path", "video_variant_path"} {
+		var paths []string
+		if err := db.Unscoped().Model(&models.File{}).
+			Where(column+" <> ''").
+			Distinct().
+			Pluck(column, &paths).Error; err != nil {
+			return nil, err
+		}
+		for _, p := range paths {
+			refs[p] = true
+		}
+	}
+	return refs, nil
+}
+
+// IsReferenced reports whether any file references path, as its original
+// or its video variant, any file version does, or a blob is stored there.
+func IsReferenced(db *gorm.DB, path string) (bool, error) {
+	var count int64
+	if err := db.Unscoped().Model(&models.File{}).
+		Where("storage_path = ? OR video_variant_path = ?", path, path).
+		Limit(1).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return true, nil
+	}
+	if err := db.Model(&models.FileVersion{}).Where("storage_path = ?", path).
+		Limit(1).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return true, nil
+	}
+	err := db.Model(&models.Blob{}).Where("storage_path = ?", path).Count(&count).Error
+	return count > 0, err
+}
+
+// ReferencedBy reports whether a file or file version of userID uses the
+// object at path as its content.
+func ReferencedBy(db *gorm.DB, userID uint, path string) (bool, error) {
+	var count int64
+	if err := db.Unscoped().Model(&models.File{}).
+		Where("user_id = ? AND storage_path = ?", userID, path).
+		Limit(1).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return true, nil
+	}
+	err := db.Model(&models.FileVersion{}).
+		Where("user_id = ? AND storage_path = ?", userID, path).
+		Limit(1).
+		Count(&count).Error
+	return count > 0, err
+}
@@ -0,0 +1,123 @@
+// Package diskspace watches free space on the volumes uploads are written
+// to, the disk storage backend's and the temp directory's, so that uploads
+// are refused with a clear error before a volume fills up rather than
+// failing half-written.
+package diskspace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/agjmills/trove/internal/config"
+	"github.com/agjmills/trove/internal/logger"
+	"github.com/agjmills/trove/internal/metrics"
+	"github.com/agjmills/trove/internal/templateutil"
+)
+
+// ErrLowSpace is returned when a volume has, or would be left with, less
+// free space than its configured minimum.
+var ErrLowSpace = errors.New("not enough free disk space")
+
+// Volume is a directory uploads are written to and the free space to keep
+// on the volume holding it.
+type Volume struct {
+	Name    string // "storage" or "temp"; also the metrics label
+	Path    string
+	MinFree int64 // 0 = no minimum
+}
+
+// Usage is the free space on a volume, or why it could not be read.
+type Usage struct {
+	Volume
+	Free int64
+	Err  error
+}
+
+// Low reports whether the volume has less free space than its minimum.
+func (u Usage) Low() bool {
+	return u.Err == nil && u.Free < u.MinFree
+}
+
+// String describes the free space on the volume.
+func (u Usage) String() string {
+	if u.Err != nil {
+		return fmt.Sprintf("%s volume (%s): %v", u.Name, u.Path, u.Err)
+	}
+	return fmt.Sprintf("%s volume (%s) has %s free, minimum %s",
+		u.Name, u.Path, templateutil.FormatBytes(u.Free), templateutil.FormatBytes(u.MinFree))
+}
+
+// Volumes returns the volumes uploads are written to under cfg: the storage
+// path when the disk backend is used, and the temp directory.
+func Volumes(cfg *config.Config) []Volume {
+	var volumes []Volume
+	if cfg.StorageBackend == "disk" {
+		volumes = append(volumes, Volume{Name: "storage", Path: cfg.StoragePath, MinFree: cfg.MinFreeStorage})
+	}
+	tempDir := cfg.TempDir
+	if tempDir == "" {
+		tempDir = os.TempDir()
+	}
+	return append(volumes, Volume{Name: "temp", Path: tempDir, MinFree: cfg.MinFreeTemp})
+}
+
+// Stat reads the free space on each volume and records it in metrics.
+func Stat(volumes []Volume) []Usage {
+	usage := make([]Usage, len(volumes))
+	for i, v := range volumes {
+		usage[i] = Usage{Volume: v}
+		usage[i].Free, usage[i].Err = free(v.Path)
+		if usage[i].Err == nil {
+			metrics.DiskFreeBytes.WithLabelValues(v.Name).Set(float64(usage[i].Free))
+		}
+		metrics.DiskMinFreeBytes.WithLabelValues(v.Name).Set(float64(v.MinFree))
+	}
+	return usage
+}
+
+// Check returns an error wrapping ErrLowSpace if writing size more bytes
+// would leave any volume with less free space than its minimum. A volume
+// whose free space cannot be read does not block writes.
+func Check(volumes []Volume, size int64) error {
+	for _, u := range Stat(volumes) {
+		if u.Err != nil || u.MinFree <= 0 {
+			continue
+		}
+		if u.Free-max(size, 0) < u.MinFree {
+			return fmt.Errorf("%w: %s", ErrLowSpace, u)
+		}
+	}
+	return nil
+}
+
+// Watch records the free space on each volume every interval until ctx is
+// cancelled, logging when a volume falls below its minimum and when it
+// recovers.
+func Watch(ctx context.Context, volumes []Volume, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	low := make(map[string]bool)
+	for {
+		for _, u := range Stat(volumes) {
+			switch {
+			case u.Err != nil:
+				logger.Debug("failed to read free disk space", "volume", u.Name, "path", u.Path, "error", u.Err)
+			case u.Low() && !low[u.Name]:
+				logger.Warn("low disk space, refusing uploads", "volume", u.Name, "path", u.Path, "free_bytes", u.Free, "min_free_bytes", u.MinFree)
+			case !u.Low() && low[u.Name]:
+				logger.Info("disk space recovered, accepting uploads", "volume", u.Name, "path", u.Path, "free_bytes", u.Free)
+			}
+			low[u.Name] = u.Low()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
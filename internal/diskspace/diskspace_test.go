@@ -0,0 +1,53 @@
+package diskspace
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/agjmills/trove/internal/config"
+)
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	usage := Stat([]Volume{{Name: "temp", Path: dir}})
+	if usage[0].Err != nil {
+		t.Skipf("free space unavailable on this platform: %v", usage[0].Err)
+	}
+	free := usage[0].Free
+
+	if err := Check([]Volume{{Name: "temp", Path: dir}}, 1<<40); err != nil {
+		t.Errorf("Check without a minimum = %v, want nil", err)
+	}
+	if err := Check([]Volume{{Name: "temp", Path: dir, MinFree: 1}}, 0); err != nil {
+		t.Errorf("Check above the minimum = %v, want nil", err)
+	}
+	if err := Check([]Volume{{Name: "temp", Path: dir, MinFree: 1}}, free+1<<30); !errors.Is(err, ErrLowSpace) {
+		t.Errorf("Check of a write that would cross the minimum = %v, want ErrLowSpace", err)
+	}
+
+	low := Stat([]Volume{{Name: "temp", Path: dir, MinFree: 1 << 62}})[0]
+	if !low.Low() {
+		t.Errorf("%s: want it reported low", low)
+	}
+}
+
+func TestCheckIgnoresUnreadableVolumes(t *testing.T) {
+	missing := Volume{Name: "storage", Path: "/nonexistent/trove", MinFree: 1 << 62}
+	if err := Check([]Volume{missing}, 0); err != nil {
+		t.Errorf("Check = %v, want unreadable volumes ignored", err)
+	}
+}
+
+func TestVolumes(t *testing.T) {
+	volumes := Volumes(&config.Config{StorageBackend: "disk", StoragePath: "/data", MinFreeStorage: 10, MinFreeTemp: 5})
+	if len(volumes) != 2 || volumes[0] != (Volume{Name: "storage", Path: "/data", MinFree: 10}) ||
+		volumes[1] != (Volume{Name: "temp", Path: os.TempDir(), MinFree: 5}) {
+		t.Errorf("disk backend volumes = %+v", volumes)
+	}
+
+	volumes = Volumes(&config.Config{StorageBackend: "s3", TempDir: "/scratch", MinFreeTemp: 5})
+	if len(volumes) != 1 || volumes[0] != (Volume{Name: "temp", Path: "/scratch", MinFree: 5}) {
+		t.Errorf("s3 backend volumes = %+v", volumes)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !windows
+
+package diskspace
+
+import "errors"
+
+// free is not implemented on this platform, so no minimum is enforced.
+func free(string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,187 @@
+// Package gc deletes orphaned objects: objects in the storage backend that
+// no file references. They are left behind when deleting an object fails,
+// for example while cleaning up after a failed upload or permanently
+// deleting a file, and otherwise take up space forever.
+//
+// Objects modified within a grace period are never deleted, since an upload
+// or transcode saves its object a moment before the file row pointing at it
+// is written. Incomplete uploads the backend keeps out of sight, such as S3
+// multipart uploads, are aborted once they are older than the grace period
+// and than every chunked upload session still sending chunks to one.
+package gc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/agjmills/trove/internal/database"
+	"github.com/agjmills/trove/internal/database/models"
+	"github.com/agjmills/trove/internal/logger"
+	"github.com/agjmills/trove/internal/metrics"
+	"github.com/agjmills/trove/internal/storage"
+)
+
+// Collector finds and deletes orphaned objects in one backend.
+type Collector struct {
+	db      *gorm.DB
+	storage storage.StorageBackend
+	grace   time.Duration
+}
+
+// Report describes a collection run.
+type Report struct {
+	Scanned      int // Objects in the backend
+	ScannedBytes int64
+	Orphans      []storage.FileInfo // Unreferenced objects older than the grace period
+	OrphanBytes  int64
+	Recent       int // Unreferenced objects within the grace period, left alone
+	Deleted      int
+	DeletedBytes int64
+	Failed       int
+
+	AbortedUploads int // Incomplete uploads older than the grace period and every active upload session, discarded
+}
+
+// New returns a Collector for backend that leaves objects modified within
+// grace alone.
+func New(db *gorm.DB, backend storage.StorageBackend, grace time.Duration) *Collector {
+	return &Collector{db: db, storage: backend, grace: grace}
+}
+
+// Run compares the backend's objects against the files in the database and
+// deletes orphans older than the grace period. With dryRun it only reports
+// them. It returns storage.ErrListUnsupported if the backend cannot list.
+func (c *Collector) Run(ctx context.Context, dryRun bool) (Report, error) {
+	var report Report
+	lister, ok := c.storage.(storage.Lister)
+	if !ok {
+		return report, storage.ErrListUnsupported
+	}
+
+	// Load references before listing; any object saved after this point is
+	// within the grace period
+	cutoff := time.Now().Add(-c.grace)
+	refs, err := database.ReferencedPaths(c.db)
+	if err != nil {
+		return report, fmt.Errorf("failed to load referenced paths: %w", err)
+	}
+
+	err = lister.List(ctx, func(info storage.FileInfo) error {
+		report.Scanned++
+		report.ScannedBytes += info.Size
+		if refs[info.Path] {
+			return nil
+		}
+		if info.ModTime.After(cutoff) {
+			report.Recent++
+			return nil
+		}
+		report.Orphans = append(report.Orphans, info)
+		report.OrphanBytes += info.Size
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("failed to list storage: %w", err)
+	}
+	metrics.GCOrphansFound.Set(float64(len(report.Orphans) + report.Recent))
+
+	if !dryRun {
+		for _, orphan := range report.Orphans {
+			if ctx.Err() != nil {
+				return report, ctx.Err()
+			}
+			c.delete(ctx, orphan, &report)
+		}
+		c.abortStaleUploads(ctx, cutoff, &report)
+		metrics.GCLastRunTimestamp.Set(float64(time.Now().Unix()))
+	}
+	return report, nil
+}
+
+// delete removes one orphan, checking first that nothing started
+// referencing it since the references were loaded.
+func (c *Collector) delete(ctx context.Context, orphan storage.FileInfo, report *Report) {
+	referenced, err := database.IsReferenced(c.db, orphan.Path)
+	if err != nil {
+		report.Failed++
+		logger.Error("failed to check orphaned object", "path", orphan.Path, "error", err)
+		return
+	}
+	if referenced {
+		return
+	}
+	if err := c.storage.Delete(ctx, orphan.Path); err != nil {
+		report.Failed++
+		logger.Error("failed to delete orphaned object", "path", orphan.Path, "error", err)
+		return
+	}
+	report.Deleted++
+	report.DeletedBytes += orphan.Size
+	metrics.GCOrphansDeleted.Inc()
+	metrics.GCBytesReclaimed.Add(float64(orphan.Size))
+	logger.Info("deleted orphaned object", "path", orphan.Path, "size", orphan.Size, "modified", orphan.ModTime)
+}
+
+// abortStaleUploads discards incomplete uploads started before cutoff, if
+// the backend can leave them behind. Chunked upload sessions send chunks to
+// a multipart upload for up to UPLOAD_SESSION_TIMEOUT, usually far longer
+// than the grace period, so uploads started after the oldest active session
+// that has one are kept too.
+func (c *Collector) abortStaleUploads(ctx context.Context, cutoff time.Time, report *Report) {
+	sweeper, ok := c.storage.(storage.UploadSweeper)
+	if !ok {
+		return
+	}
+	var oldest models.UploadSession
+	if err := c.db.Where("status = ? AND storage_upload_id <> ''", "active").
+		Order("created_at").
+		Limit(1).
+		Find(&oldest).Error; err != nil {
+		logger.Error("failed to load active upload sessions", "error", err)
+		return
+	}
+	if oldest.ID != "" && oldest.CreatedAt.Before(cutoff) {
+		cutoff = oldest.CreatedAt
+	}
+	aborted, err := sweeper.AbortStaleUploads(ctx, cutoff)
+	report.AbortedUploads = aborted
+	metrics.GCUploadsAborted.Add(float64(aborted))
+	if err != nil {
+		logger.Error("failed to abort stale uploads", "error", err)
+	}
+}
+
+// Schedule runs a collection now and then every interval until ctx is
+// cancelled, logging the results.
+func (c *Collector) Schedule(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		report, err := c.Run(ctx, false)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Error("garbage collection failed", "error", err)
+		} else {
+			logger.Info("garbage collection finished",
+				"scanned", report.Scanned,
+				"orphans", len(report.Orphans),
+				"recent_orphans", report.Recent,
+				"deleted", report.Deleted,
+				"reclaimed_bytes", report.DeletedBytes,
+				"aborted_uploads", report.AbortedUploads,
+				"failed", report.Failed,
+			)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package gc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/agjmills/trove/internal/database/models"
+	"github.com/agjmills/trove/internal/storage"
+)
+
+func newGCTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := fmt.Sprintf("file:gc-%d?mode=memory&cache=shared", time.Now().UnixNano())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to open test db: %v", err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.File{}, &models.FileVersion{}, &models.Blob{}, &models.UploadSession{}); err != nil {
+		t.Fatalf("failed to migrate test db: %v", err)
+	}
+	return db
+}
+
+func saveObject(t *testing.T, backend storage.StorageBackend, content string) storage.SaveResult {
+	t.Helper()
+	result, err := backend.Save(context.Background(), strings.NewReader(content), storage.SaveOptions{OriginalFilename: "f.bin"})
+	if err != nil {
+		t.Fatalf("failed to save object: %v", err)
+	}
+	return result
+}
+
+func exists(backend storage.StorageBackend, path string) bool {
+	_, err := backend.Stat(context.Background(), path)
+	return err == nil
+}
+
+// unlistable hides the Lister implementation of the backend it wraps.
+type unlistable struct {
+	storage.StorageBackend
+}
+
+// sweepRecorder records the cutoff its uploads were swept with.
+type sweepRecorder struct {
+	*storage.MemoryBackend
+	cutoff time.Time
+}
+
+func (s *sweepRecorder) AbortStaleUploads(ctx context.Context, cutoff time.Time) (int, error) {
+	s.cutoff = cutoff
+	return 0, nil
+}
+
+func TestCollectorDeletesOnlyOrphans(t *testing.T) {
+	ctx := context.Background()
+	db := newGCTestDB(t)
+	backend := storage.NewMemoryBackend()
+
+	kept := saveObject(t, backend, "referenced")
+	trashed := saveObject(t, backend, "in the trash")
+	variant := saveObject(t, backend, "web video")
+	orphan := saveObject(t, backend, "left behind")
+
+	db.Create(&models.File{UserID: 1, Filename: "a", OriginalFilename: "a", StoragePath: kept.Path, VideoVariantPath: variant.Path, UploadStatus: "completed"})
+	deleted := &models.File{UserID: 1, Filename: "b", OriginalFilename: "b", StoragePath: trashed.Path, UploadStatus: "completed"}
+	db.Create(deleted)
+	db.Delete(deleted) // Soft-deleted rows still reference their object
+
+	// Dry run reports without deleting
+	report, err := New(db, backend, 0).Run(ctx, true)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if report.Scanned != 4 || len(report.Orphans) != 1 || report.Orphans[0].Path != orphan.Path || report.OrphanBytes != orphan.Size {
+		t.Fatalf("unexpected dry-run report: %+v", report)
+	}
+	if report.Deleted != 0 || !exists(backend, orphan.Path) {
+		t.Fatal("dry run must not delete anything")
+	}
+
+	report, err = New(db, backend, 0).Run(ctx, false)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if report.Deleted != 1 || report.DeletedBytes != orphan.Size || report.Failed != 0 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if exists(backend, orphan.Path) {
+		t.Error("orphan was not deleted")
+	}
+	for _, p := range []string{kept.Path, trashed.Path, variant.Path} {
+		if !exists(backend, p) {
+			t.Errorf("referenced object %s was deleted", p)
+		}
+	}
+}
+
+func TestCollectorKeepsRecentObjects(t *testing.T) {
+	db := newGCTestDB(t)
+	backend := storage.NewMemoryBackend()
+	// Saved by an upload whose file row is not written yet
+	inFlight := saveObject(t, backend, "uploading")
+
+	report, err := New(db, backend, time.Hour).Run(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if report.Recent != 1 || len(report.Orphans) != 0 || report.Deleted != 0 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if !exists(backend, inFlight.Path) {
+		t.Error("object within the grace period was deleted")
+	}
+}
+
+func TestCollectorRequiresLister(t *testing.T) {
+	db := newGCTestDB(t)
+	_, err := New(db, unlistable{storage.NewMemoryBackend()}, 0).Run(context.Background(), false)
+	if !errors.Is(err, storage.ErrListUnsupported) {
+		t.Errorf("expected ErrListUnsupported, got %v", err)
+	}
+}
+
+func TestCollectorKeepsUploadsOfActiveSessions(t *testing.T) {
+	db := newGCTestDB(t)
+	backend := &sweepRecorder{MemoryBackend: storage.NewMemoryBackend()}
+	started := time.Now().Add(-6 * time.Hour).Truncate(time.Second)
+	db.Create(&models.UploadSession{ID: "live", UserID: 1, Filename: "big.iso", TotalSize: 1, TotalChunks: 1, ChunkSize: 1,
+		Status: "active", StorageUploadID: "upload-1", CreatedAt: started, ExpiresAt: time.Now().Add(time.Hour)})
+	db.Create(&models.UploadSession{ID: "done", UserID: 1, Filename: "old.iso", TotalSize: 1, TotalChunks: 1, ChunkSize: 1,
+		Status: "completed", StorageUploadID: "upload-0", CreatedAt: started.Add(-time.Hour), ExpiresAt: time.Now()})
+
+	if _, err := New(db, backend, time.Hour).Run(context.Background(), false); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !backend.cutoff.Equal(started) {
+		t.Errorf("uploads swept with cutoff %v, want the active session's start %v", backend.cutoff, started)
+	}
+
+	db.Model(&models.UploadSession{}).Where("id = ?", "live").Update("status", "completed")
+	if _, err := New(db, backend, time.Hour).Run(context.Background(), false); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !backend.cutoff.After(started) {
+		t.Errorf("uploads swept with cutoff %v once no session was active", backend.cutoff)
+	}
+}
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/agjmills/trove/internal/database/models"
+	"github.com/agjmills/trove/internal/logger"
+)
+
+// accessRecordInterval is how stale a file's last access time must be
+// before a read records a new one, so repeated reads (such as the range
+// requests of a video player) do not each write to the database.
+const accessRecordInterval = time.Hour
+
+// recordAccess records that the content of file is being read, for
+// storage tiering.
+func recordAccess(db *gorm.DB, file *models.File) {
+	now := time.Now()
+	if file.LastAccessedAt != nil && now.Sub(*file.LastAccessedAt) < accessRecordInterval {
+		return
+	}
+	cutoff := now.Add(-accessRecordInterval)
+	if err := db.Model(&models.File{}).
+		Where("id = ? AND (last_accessed_at IS NULL OR last_accessed_at < ?)", file.ID, cutoff).
+		UpdateColumn("last_accessed_at", now).Error; err != nil {
+		logger.Warn("failed to record file access", "file_id", file.ID, "error", err)
+		return
+	}
+	file.LastAccessedAt = &now
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"gorm.io/gorm"
@@ -15,6 +16,7 @@ import (
 	"github.com/agjmills/trove/internal/database/models"
 	"github.com/agjmills/trove/internal/flash"
 	"github.com/agjmills/trove/internal/logger"
+	"github.com/agjmills/trove/internal/scrub"
 	"github.com/agjmills/trove/internal/storage"
 )
 
@@ -39,6 +41,28 @@ type DashboardStats struct {
 	TotalStorageUsed int64
 }
 
+// IntegrityStats summarises the storage integrity scrubber for the dashboard
+type IntegrityStats struct {
+	Counts  map[string]int64 // Completed files by integrity status
+	Current *models.ScrubRun // Queued or running run, if any
+	Last    *models.ScrubRun // Most recently finished run, if any
+	Flagged []FlaggedFile    // Files whose stored object is missing or corrupted
+}
+
+// FlaggedFile is a file the scrubber found missing or corrupted
+type FlaggedFile struct {
+	ID              uint
+	Username        string
+	Filename        string
+	LogicalPath     string
+	StoragePath     string
+	IntegrityStatus string
+	VerifiedAt      *time.Time
+}
+
+// maxFlaggedFiles caps the flagged files listed on the dashboard
+const maxFlaggedFiles = 50
+
 // ShowDashboard displays the admin dashboard with usage statistics
 func (h *AdminHandler) ShowDashboard(w http.ResponseWriter, r *http.Request) {
 	user := auth.GetUser(r)
@@ -70,16 +94,71 @@ func (h *AdminHandler) ShowDashboard(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	integrity, err := h.integrityStats()
+	if err != nil {
+		logger.Error("Failed to load integrity stats", "error", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
 	if err := render(w, "admin.html", map[string]any{
 		"Title":     "Admin Dashboard",
 		"User":      user,
 		"Stats":     stats,
+		"Integrity": integrity,
+		"Flash":     flash.Get(w, r),
 		"FullWidth": true,
 	}); err != nil {
 		logger.Error("render error", "error", err)
 	}
 }
 
+func (h *AdminHandler) integrityStats() (IntegrityStats, error) {
+	var stats IntegrityStats
+	var err error
+	if stats.Counts, err = scrub.StatusCounts(h.db); err != nil {
+		return stats, err
+	}
+	if stats.Current, err = scrub.Current(h.db); err != nil {
+		return stats, err
+	}
+	if stats.Last, err = scrub.LastFinished(h.db); err != nil {
+		return stats, err
+	}
+	err = h.db.Model(&models.File{}).
+		Select("files.id, users.username, files.filename, files.logical_path, files.storage_path, files.integrity_status, files.verified_at").
+		Joins("JOIN users ON users.id = files.user_id").
+		Where("files.integrity_status IN ?", []string{scrub.StatusMissing, scrub.StatusCorrupted}).
+		Order("files.verified_at DESC").
+		Limit(maxFlaggedFiles).
+		Scan(&stats.Flagged).Error
+	return stats, err
+}
+
+// StartScrub queues an integrity scrub of all stored objects
+func (h *AdminHandler) StartScrub(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+	if user == nil || !user.IsAdmin {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if _, err := scrub.Enqueue(h.db, scrub.TriggerAdmin); err != nil {
+		if errors.Is(err, scrub.ErrRunActive) {
+			flash.Error(w, "An integrity check is already queued or running")
+		} else {
+			logger.Error("Failed to queue scrub run", "error", err)
+			flash.Error(w, "Failed to start integrity check")
+		}
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+		return
+	}
+
+	logger.Info("integrity scrub queued", "admin_id", user.ID)
+	flash.Success(w, "Integrity check queued. It starts within a minute and runs in the background.")
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
 // ShowUsers displays the user management page
 func (h *AdminHandler) ShowUsers(w http.ResponseWriter, r *http.Request) {
 	user := auth.GetUser(r)
@@ -116,26 +195,43 @@ func (h *AdminHandler) ShowUsers(w http.ResponseWriter, r *http.Request) {
 		fileCountMap[agg.UserID] = agg.FileCount
 	}
 
+	// Folders with a storage policy assigned, by user
+	var policyFolders []models.Folder
+	if err := h.db.Where("storage_policy <> '' AND trashed_at IS NULL").
+		Order("folder_path").
+		Find(&policyFolders).Error; err != nil {
+		logger.Error("Failed to fetch folder storage policies", "error", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	folderPolicyMap := make(map[uint][]models.Folder)
+	for _, f := range policyFolders {
+		folderPolicyMap[f.UserID] = append(folderPolicyMap[f.UserID], f)
+	}
+
 	type UserWithStats struct {
 		models.User
-		FileCount int64
+		FileCount      int64
+		FolderPolicies []models.Folder
 	}
 	var usersWithStats []UserWithStats
 	for _, u := range users {
 		usersWithStats = append(usersWithStats, UserWithStats{
-			User:      u,
-			FileCount: fileCountMap[u.ID],
+			User:           u,
+			FileCount:      fileCountMap[u.ID],
+			FolderPolicies: folderPolicyMap[u.ID],
 		})
 	}
 
 	if err := render(w, "admin_users.html", map[string]any{
-		"Title":        "User Management",
-		"User":         user,
-		"Users":        usersWithStats,
-		"Flash":        flash.Get(w, r),
-		"FullWidth":    true,
-		"DefaultQuota": h.cfg.DefaultUserQuota,
-		"OIDCEnabled":  h.cfg.OIDCEnabled,
+		"Title":           "User Management",
+		"User":            user,
+		"Users":           usersWithStats,
+		"Flash":           flash.Get(w, r),
+		"FullWidth":       true,
+		"DefaultQuota":    h.cfg.DefaultUserQuota,
+		"OIDCEnabled":     h.cfg.OIDCEnabled,
+		"StoragePolicies": storage.PolicyNames(h.storage),
 	}); err != nil {
 		logger.Error("render error", "error", err)
 	}
@@ -310,6 +406,79 @@ func (h *AdminHandler) UpdateUserQuota(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
 }
 
+// UpdateUserStoragePolicy sets the storage policy a user's new files are
+// saved under, unless a folder assigns its own. An empty policy selects the
+// default backend. Existing files stay where they are.
+func (h *AdminHandler) UpdateUserStoragePolicy(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	policy := r.FormValue("storage_policy")
+	if !storage.HasPolicy(h.storage, policy) {
+		http.Error(w, "Unknown storage policy", http.StatusBadRequest)
+		return
+	}
+
+	res := h.db.Model(&models.User{}).Where("id = ?", userID).Update("storage_policy", policy)
+	if res.Error != nil {
+		logger.Error("failed to update user storage policy", "error", res.Error, "user_id", userID)
+		http.Error(w, "Failed to update user", http.StatusInternalServerError)
+		return
+	}
+	if res.RowsAffected == 0 {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// UpdateFolderStoragePolicy sets the storage policy new files in one of a
+// user's folders, and its subfolders, are saved under, overriding the
+// user's. An empty policy makes the folder inherit again. Existing files
+// stay where they are.
+func (h *AdminHandler) UpdateFolderStoragePolicy(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	policy := r.FormValue("storage_policy")
+	if !storage.HasPolicy(h.storage, policy) {
+		http.Error(w, "Unknown storage policy", http.StatusBadRequest)
+		return
+	}
+	folderPath := sanitizeFolderPath(r.FormValue("folder_path"))
+	if folderPath == "/" {
+		flash.Error(w, "Set the user's storage policy to change the root folder.")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	owner := uint(userID)
+	if !folderExists(h.db, owner, folderPath) {
+		flash.Error(w, "Folder "+folderPath+" not found.")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+	// Folders that only hold files have no row yet
+	folder := models.Folder{UserID: owner, FolderPath: folderPath}
+	err = h.db.Where("user_id = ? AND folder_path = ? AND trashed_at IS NULL", owner, folderPath).
+		FirstOrCreate(&folder).Error
+	if err == nil {
+		err = h.db.Model(&folder).Update("storage_policy", policy).Error
+	}
+	if err != nil {
+		logger.Error("failed to update folder storage policy", "error", err, "user_id", userID, "folder", folderPath)
+		http.Error(w, "Failed to update folder", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
 // DeleteUser deletes a user and their files in the background (admins cannot delete themselves)
 func (h *AdminHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	adminUser := auth.GetUser(r)
@@ -345,11 +514,38 @@ func (h *AdminHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 
 	// Delete user and their database records (folders, files metadata)
 	// Using a transaction to ensure consistency
+	var unreferenced []string
 	if err := h.db.Transaction(func(tx *gorm.DB) error {
-		// Delete files metadata
-		if err := tx.Where("user_id = ?", userID).Delete(&models.File{}).Error; err != nil {
+		// Delete previous file versions and files metadata, releasing the
+		// objects they share with other users
+		var versions []models.FileVersion
+		if err := tx.Where("user_id = ?", userID).Find(&versions).Error; err != nil {
 			return err
 		}
+		_, versionPaths, err := releaseFileVersions(tx, versions)
+		if err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&models.File{}).Error; err != nil {
+			return err
+		}
+		seen := make(map[string]bool)
+		for _, p := range versionPaths {
+			seen[p] = true
+			unreferenced = append(unreferenced, p)
+		}
+		for i := range files {
+			paths, err := unreferencedObjects(tx, &files[i])
+			if err != nil {
+				return err
+			}
+			for _, p := range paths {
+				if !seen[p] {
+					seen[p] = true
+					unreferenced = append(unreferenced, p)
+				}
+			}
+		}
 		// Delete folders
 		if err := tx.Where("user_id = ?", userID).Delete(&models.Folder{}).Error; err != nil {
 			return err
@@ -364,24 +560,24 @@ func (h *AdminHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Delete actual files from storage in the background
+	// Delete objects no other user shares from storage in the background
 	// TODO: Consider passing an app-lifecycle context for graceful shutdown support
 	// instead of context.Background() so long-running deletions can be canceled.
-	if len(files) > 0 {
-		go func(filesToDelete []models.File, username string) {
-			logger.Info("Starting background file deletion", "user", username, "file_count", len(filesToDelete))
+	if len(unreferenced) > 0 {
+		go func(pathsToDelete []string, username string) {
+			logger.Info("Starting background file deletion", "user", username, "file_count", len(pathsToDelete))
 			deleted := 0
 			failed := 0
-			for _, file := range filesToDelete {
-				if err := h.storage.Delete(context.Background(), file.StoragePath); err != nil {
-					logger.Error("Failed to delete file from storage", "path", file.StoragePath, "error", err)
+			for _, path := range pathsToDelete {
+				if err := h.storage.Delete(context.Background(), path); err != nil {
+					logger.Error("Failed to delete file from storage", "path", path, "error", err)
 					failed++
 				} else {
 					deleted++
 				}
 			}
 			logger.Info("Background file deletion complete", "user", username, "deleted", deleted, "failed", failed)
-		}(files, targetUser.Username)
+		}(unreferenced, targetUser.Username)
 	}
 
 	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
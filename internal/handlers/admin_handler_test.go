@@ -63,7 +63,7 @@ func setupTestAdminHandler(t *testing.T) (*AdminHandler, *gorm.DB, *scs.SessionM
 		t.Fatalf("Failed to open database: %v", err)
 	}
 
-	err = db.AutoMigrate(&models.User{}, &models.File{}, &models.Folder{})
+	err = db.AutoMigrate(&models.User{}, &models.File{}, &models.FileVersion{}, &models.Blob{}, &models.Folder{}, &models.ScrubRun{})
 	if err != nil {
 		t.Fatalf("Failed to migrate: %v", err)
 	}
@@ -281,3 +281,94 @@ func TestDeleteUser_DeletesUserAndFiles(t *testing.T) {
 	// Note: Storage deletion happens in a goroutine, so we can't easily verify it here
 	// In a real scenario, you'd use a channel or wait group
 }
+
+func TestStartScrub_QueuesSingleRun(t *testing.T) {
+	handler, db, sessionManager, _ := setupTestAdminHandler(t)
+	adminUser := createAdminTestUser(t, db, "admin", "admin@example.com", true)
+
+	for range 2 {
+		req := httptest.NewRequest(http.MethodPost, "/admin/scrub", nil)
+		req = csrf.UnsafeSkipCheck(req)
+		req = withUser(req, adminUser)
+
+		w := httptest.NewRecorder()
+		sessionManager.LoadAndSave(http.HandlerFunc(handler.StartScrub)).ServeHTTP(w, req)
+
+		if w.Code != http.StatusSeeOther {
+			t.Errorf("Expected status 303, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	// A second request while the first run is queued adds nothing
+	var runs []models.ScrubRun
+	db.Find(&runs)
+	if len(runs) != 1 || runs[0].Status != "pending" || runs[0].Trigger != "admin" {
+		t.Errorf("Expected one pending admin run, got %+v", runs)
+	}
+}
+
+func TestStoragePolicyAssignment(t *testing.T) {
+	handler, db, sessionManager, _ := setupTestAdminHandler(t)
+	policies, err := storage.NewPolicyBackend(storage.NewMemoryBackend(),
+		storage.Member{Name: "archive", Backend: storage.NewMemoryBackend()},
+		storage.Member{Name: "fast", Backend: storage.NewMemoryBackend()},
+	)
+	if err != nil {
+		t.Fatalf("NewPolicyBackend failed: %v", err)
+	}
+	handler.storage = policies
+	adminUser := createAdminTestUser(t, db, "admin", "admin@example.com", true)
+	regularUser := createAdminTestUser(t, db, "regular", "regular@example.com", false)
+	// A folder that only exists because a file is in it
+	db.Create(&models.File{UserID: regularUser.ID, StoragePath: "a.bin", LogicalPath: "/projects/old", Filename: "a.bin", OriginalFilename: "a.bin"})
+
+	post := func(action string, fn http.HandlerFunc, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/users/"+action, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = csrf.UnsafeSkipCheck(req)
+		req = withUser(req, adminUser)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", strconv.FormatUint(uint64(regularUser.ID), 10))
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		w := httptest.NewRecorder()
+		sessionManager.LoadAndSave(fn).ServeHTTP(w, req)
+		return w
+	}
+
+	if w := post("storage-policy", handler.UpdateUserStoragePolicy, url.Values{"storage_policy": {"missing"}}); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown policy, got %d", w.Code)
+	}
+	if w := post("storage-policy", handler.UpdateUserStoragePolicy, url.Values{"storage_policy": {"fast"}}); w.Code != http.StatusSeeOther {
+		t.Fatalf("expected 303, got %d: %s", w.Code, w.Body.String())
+	}
+	form := url.Values{"folder_path": {"projects/old/"}, "storage_policy": {"archive"}}
+	if w := post("folder-storage-policy", handler.UpdateFolderStoragePolicy, form); w.Code != http.StatusSeeOther {
+		t.Fatalf("expected 303, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// The nearest folder with a policy wins, then the user's
+	for folder, want := range map[string]string{
+		"/":                    "fast",
+		"/projects":            "fast",
+		"/projects/old":        "archive",
+		"/projects/old/2019/q": "archive",
+	} {
+		if got := storagePolicy(db, policies, regularUser.ID, folder); got != want {
+			t.Errorf("%s: policy %q, want %q", folder, got, want)
+		}
+	}
+
+	// Clearing the folder's policy makes it inherit again
+	form.Set("storage_policy", "")
+	if w := post("folder-storage-policy", handler.UpdateFolderStoragePolicy, form); w.Code != http.StatusSeeOther {
+		t.Fatalf("expected 303, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := storagePolicy(db, policies, regularUser.ID, "/projects/old"); got != "fast" {
+		t.Errorf("cleared folder: policy %q, want the user's", got)
+	}
+	var rows int64
+	db.Model(&models.Folder{}).Where("user_id = ? AND folder_path = ?", regularUser.ID, "/projects/old").Count(&rows)
+	if rows != 1 {
+		t.Errorf("expected one folder row, found %d", rows)
+	}
+}
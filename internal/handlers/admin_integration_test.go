@@ -77,7 +77,7 @@ func newAdminTestApp(t *testing.T) *adminTestApp {
 		t.Fatalf("Failed to open test database: %v", err)
 	}
 
-	err = db.AutoMigrate(&models.User{}, &models.File{}, &models.Folder{})
+	err = db.AutoMigrate(&models.User{}, &models.File{}, &models.FileVersion{}, &models.Blob{}, &models.Folder{}, &models.ScrubRun{})
 	if err != nil {
 		t.Fatalf("Failed to migrate database: %v", err)
 	}
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+
+	"github.com/agjmills/trove/internal/auth"
+	"github.com/agjmills/trove/internal/config"
+	"github.com/agjmills/trove/internal/database/models"
+	"github.com/agjmills/trove/internal/flash"
+	"github.com/agjmills/trove/internal/logger"
+)
+
+// maxAPITokensPerUser bounds how many active tokens a single user may hold.
+const maxAPITokensPerUser = 50
+
+// APITokenHandler manages personal API tokens from the settings page.
+type APITokenHandler struct {
+	db  *gorm.DB
+	cfg *config.Config
+}
+
+func NewAPITokenHandler(db *gorm.DB, cfg *config.Config) *APITokenHandler {
+	return &APITokenHandler{db: db, cfg: cfg}
+}
+
+type CreateAPITokenRequest struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	ExpiresAt string   `json:"expires_at"` // YYYY-MM-DD, empty = never
+}
+
+type CreateAPITokenResponse struct {
+	ID        uint       `json:"id"`
+	Name      string     `json:"name"`
+	Token     string     `json:"token"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// listAPITokens returns the user's tokens, newest first, for the settings page.
+func listAPITokens(db *gorm.DB, userID uint) []models.APIToken {
+	var tokens []models.APIToken
+	if err := db.Where("user_id = ?", userID).Order("created_at DESC").Find(&tokens).Error; err != nil {
+		logger.Error("failed to list api tokens", "user_id", userID, "error", err)
+	}
+	return tokens
+}
+
+// CreateAPIToken handles POST /settings/tokens.
+// The plaintext token is returned exactly once; only its hash is stored.
+func (h *APITokenHandler) CreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	isJSON := isJSONRequest(r)
+	var req CreateAPITokenRequest
+	if isJSON {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		req.Name = r.FormValue("name")
+		req.Scopes = r.Form["scopes"]
+		req.ExpiresAt = r.FormValue("expires_at")
+	}
+
+	fail := func(msg string) {
+		if isJSON {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		flash.Error(w, msg)
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		fail("Token name is required (max 100 characters)")
+		return
+	}
+
+	var scopes []string
+	for _, s := range req.Scopes {
+		if !auth.ValidScope(s) {
+			fail("Unknown scope: " + s)
+			return
+		}
+		if !slices.Contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	if len(scopes) == 0 {
+		fail("Select at least one scope")
+		return
+	}
+
+	var expiresAt *time.Time
+	if v := strings.TrimSpace(req.ExpiresAt); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			fail("Invalid expiry date (use YYYY-MM-DD)")
+			return
+		}
+		// Expire at end of the chosen day in UTC
+		endOfDay := time.Date(t.Year(), t.Month(), t.Day(), 23, 59, 59, 0, time.UTC)
+		if endOfDay.Before(time.Now()) {
+			fail("Expiry date must be in the future")
+			return
+		}
+		expiresAt = &endOfDay
+	}
+
+	var count int64
+	if err := h.db.Model(&models.APIToken{}).Where("user_id = ?", user.ID).Count(&count).Error; err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if count >= maxAPITokensPerUser {
+		fail("Token limit reached; revoke an existing token first")
+		return
+	}
+
+	plaintext, prefix, hash, err := auth.GenerateAPIToken()
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	token := models.APIToken{
+		UserID:      user.ID,
+		Name:        req.Name,
+		TokenHash:   hash,
+		TokenPrefix: prefix,
+		Scopes:      datatypes.NewJSONType(scopes),
+		ExpiresAt:   expiresAt,
+	}
+	if err := h.db.Create(&token).Error; err != nil {
+		logger.Error("failed to create api token", "user_id", user.ID, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Info("api token created", "user_id", user.ID, "token_id", token.ID, "scopes", scopes)
+
+	if isJSON {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(CreateAPITokenResponse{ //nolint:errcheck
+			ID:        token.ID,
+			Name:      token.Name,
+			Token:     plaintext,
+			Scopes:    scopes,
+			ExpiresAt: expiresAt,
+		})
+		return
+	}
+
+	// Render directly rather than redirecting so the plaintext never touches a cookie or session
+	w.Header().Set("Cache-Control", "no-store")
+	if err := render(w, "settings.html", settingsData(h.db, h.cfg, user, map[string]any{
+		"NewAPIToken": plaintext,
+		"Success":     "API token \"" + token.Name + "\" created. Copy it now — it won't be shown again.",
+	})); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// RevokeAPIToken handles POST /settings/tokens/{id}/revoke.
+func (h *APITokenHandler) RevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	tokenID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid token ID", http.StatusBadRequest)
+		return
+	}
+
+	var token models.APIToken
+	if err := h.db.Where("id = ? AND user_id = ?", tokenID, user.ID).First(&token).Error; err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := h.db.Delete(&token).Error; err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Info("api token revoked", "user_id", user.ID, "token_id", token.ID)
+
+	if isJSONRequest(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	flash.Success(w, "API token \""+token.Name+"\" revoked.")
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/agjmills/trove/internal/auth"
+	"github.com/agjmills/trove/internal/config"
+	"github.com/agjmills/trove/internal/database/models"
+)
+
+func setupAPITokenTest(t *testing.T) (*APITokenHandler, *gorm.DB, *models.User) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.APIToken{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	user := &models.User{
+		Username:     "alice",
+		Email:        "alice@example.com",
+		StorageQuota: 1024 * 1024 * 100,
+	}
+	db.Create(user)
+
+	return NewAPITokenHandler(db, &config.Config{}), db, user
+}
+
+func createAPITokenJSON(t *testing.T, h *APITokenHandler, user *models.User, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	payload, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, "/settings/tokens", bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req = withUser(req, user)
+
+	w := httptest.NewRecorder()
+	h.CreateAPIToken(w, req)
+	return w
+}
+
+func TestCreateAPIToken_JSON(t *testing.T) {
+	h, db, user := setupAPITokenTest(t)
+
+	w := createAPITokenJSON(t, h, user, CreateAPITokenRequest{
+		Name:   "backup",
+		Scopes: []string{auth.ScopeFilesRead, auth.ScopeFilesRead, auth.ScopeFilesWrite},
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("want 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp CreateAPITokenResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if !strings.HasPrefix(resp.Token, "trove_") {
+		t.Errorf("unexpected token format %q", resp.Token)
+	}
+
+	var stored models.APIToken
+	if err := db.First(&stored, resp.ID).Error; err != nil {
+		t.Fatalf("token not stored: %v", err)
+	}
+	if stored.TokenHash != auth.HashAPIToken(resp.Token) {
+		t.Error("stored hash does not match returned token")
+	}
+	if strings.Contains(stored.TokenHash, resp.Token) {
+		t.Error("plaintext token must not be stored")
+	}
+	if got := stored.Scopes.Data(); len(got) != 2 {
+		t.Errorf("expected duplicate scopes to collapse to 2, got %v", got)
+	}
+}
+
+func TestCreateAPIToken_Validation(t *testing.T) {
+	h, _, user := setupAPITokenTest(t)
+
+	tests := []struct {
+		name string
+		body CreateAPITokenRequest
+	}{
+		{"missing name", CreateAPITokenRequest{Scopes: []string{auth.ScopeFilesRead}}},
+		{"no scopes", CreateAPITokenRequest{Name: "x"}},
+		{"unknown scope", CreateAPITokenRequest{Name: "x", Scopes: []string{"admin"}}},
+		{"bad expiry", CreateAPITokenRequest{Name: "x", Scopes: []string{auth.ScopeFilesRead}, ExpiresAt: "tomorrow"}},
+		{"past expiry", CreateAPITokenRequest{Name: "x", Scopes: []string{auth.ScopeFilesRead}, ExpiresAt: "2000-01-01"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := createAPITokenJSON(t, h, user, tt.body)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("want 400, got %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestRevokeAPIToken(t *testing.T) {
+	h, db, user := setupAPITokenTest(t)
+	other := &models.User{Username: "bob", Email: "bob@example.com"}
+	db.Create(other)
+
+	token := &models.APIToken{UserID: user.ID, Name: "ci", TokenHash: auth.HashAPIToken("a"), TokenPrefix: "trove_a"}
+	db.Create(token)
+
+	revoke := func(u *models.User) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/settings/tokens/x/revoke", nil)
+		req = withUser(req, u)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", fmt.Sprint(token.ID))
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		w := httptest.NewRecorder()
+		h.RevokeAPIToken(w, req)
+		return w
+	}
+
+	if w := revoke(other); w.Code != http.StatusNotFound {
+		t.Fatalf("other user: want 404, got %d", w.Code)
+	}
+	if w := revoke(user); w.Code != http.StatusSeeOther {
+		t.Fatalf("owner: want 303, got %d", w.Code)
+	}
+
+	var count int64
+	db.Model(&models.APIToken{}).Where("id = ?", token.ID).Count(&count)
+	if count != 0 {
+		t.Error("token should be revoked")
+	}
+}
@@ -0,0 +1,749 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+
+	"github.com/agjmills/trove/internal/auth"
+	"github.com/agjmills/trove/internal/config"
+	"github.com/agjmills/trove/internal/database/models"
+	"github.com/agjmills/trove/internal/logger"
+)
+
+const (
+	apiDefaultPerPage = 50
+	apiMaxPerPage     = 200
+	apiMaxBodyBytes   = 1 << 20
+)
+
+// APIHandler serves the versioned JSON API under /api/v1.
+type APIHandler struct {
+	db      *gorm.DB
+	cfg     *config.Config
+	version string
+}
+
+func NewAPIHandler(db *gorm.DB, cfg *config.Config, version string) *APIHandler {
+	return &APIHandler{db: db, cfg: cfg, version: version}
+}
+
+// APIError is the body of every non-2xx /api/v1 response.
+type APIError struct {
+	Error APIErrorDetail `json:"error"`
+}
+
+type APIErrorDetail struct {
+	Code    string `json:"code"`    // Stable machine-readable code, e.g. "not_found"
+	Message string `json:"message"` // Human-readable description
+}
+
+type APIPagination struct {
+	Page       int   `json:"page"`
+	PerPage    int   `json:"per_page"`
+	Total      int64 `json:"total"`
+	TotalPages int   `json:"total_pages"`
+}
+
+type APIFile struct {
+	ID               uint              `json:"id"`
+	Filename         string            `json:"filename"`
+	OriginalFilename string            `json:"original_filename"`
+	Folder           string            `json:"folder"`
+	Size             int64             `json:"size"`
+	MimeType         string            `json:"mime_type"`
+	Hash             string            `json:"hash"`
+	UploadStatus     string            `json:"upload_status"`
+	ErrorMessage     string            `json:"error_message,omitempty"`
+	TranscodeStatus  string            `json:"transcode_status"`
+	Tags             []string          `json:"tags"`
+	Metadata         map[string]string `json:"metadata"`
+	CreatedAt        time.Time         `json:"created_at"`
+	UpdatedAt        time.Time         `json:"updated_at"`
+	DeletedAt        *time.Time        `json:"deleted_at,omitempty"`      // Set for files in deleted items
+	OriginalFolder   string            `json:"original_folder,omitempty"` // Folder a deleted file will be restored to
+}
+
+type APIFolderListing struct {
+	Folder     string        `json:"folder"`
+	Folders    []string      `json:"folders"` // Immediate subfolder names; not paginated
+	Files      []APIFile     `json:"files"`
+	Pagination APIPagination `json:"pagination"`
+}
+
+type APIDeletedListing struct {
+	Files      []APIFile     `json:"files"`
+	Pagination APIPagination `json:"pagination"`
+}
+
+type APIFolder struct {
+	Path      string    `json:"path"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type APICreateFolderRequest struct {
+	Path string `json:"path"` // Full folder path, e.g. "/photos/2024"
+}
+
+type APIUpdateFileRequest struct {
+	Name   *string `json:"name,omitempty"`   // New filename (rename)
+	Folder *string `json:"folder,omitempty"` // Destination folder (move)
+}
+
+type APIShareLink struct {
+	Token             string     `json:"token"`
+	URL               string     `json:"url"` // Path of the public link, relative to the server root
+	FileID            uint       `json:"file_id,omitempty"`
+	Folder            string     `json:"folder,omitempty"`
+	ExpiresAt         *time.Time `json:"expires_at,omitempty"`
+	MaxUses           *int       `json:"max_uses,omitempty"`
+	Uses              int        `json:"uses"`
+	PasswordProtected bool       `json:"password_protected"`
+	CreatedAt         time.Time  `json:"created_at"`
+}
+
+type APICreateShareRequest struct {
+	ExpiresAt string `json:"expires_at,omitempty"` // YYYY-MM-DD, expires at the end of that day (UTC)
+	MaxUses   *int   `json:"max_uses,omitempty"`
+	Password  string `json:"password,omitempty"`
+}
+
+type APICreateFolderShareRequest struct {
+	Folder string `json:"folder"`
+	APICreateShareRequest
+}
+
+type APIQuota struct {
+	Used      int64 `json:"used"`
+	Quota     int64 `json:"quota"`
+	Available int64 `json:"available"`
+}
+
+// WriteAPIError writes a JSON error body in the /api/v1 format.
+func WriteAPIError(w http.ResponseWriter, status int, code, message string) {
+	writeAPIJSON(w, status, APIError{Error: APIErrorDetail{Code: code, Message: message}})
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Warn("failed to encode api response", "error", err)
+	}
+}
+
+// decodeAPIRequest decodes a JSON request body into v, writing a 400 on failure.
+func decodeAPIRequest(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(io.LimitReader(r.Body, apiMaxBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		WriteAPIError(w, http.StatusBadRequest, "invalid_json", "Request body must be valid JSON: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// apiPagination parses page and per_page query parameters.
+func apiPagination(w http.ResponseWriter, r *http.Request) (page, perPage int, ok bool) {
+	page, perPage = 1, apiDefaultPerPage
+	if v := r.URL.Query().Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			WriteAPIError(w, http.StatusBadRequest, "invalid_parameter", "page must be a positive integer")
+			return 0, 0, false
+		}
+		page = n
+	}
+	if v := r.URL.Query().Get("per_page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > apiMaxPerPage {
+			WriteAPIError(w, http.StatusBadRequest, "invalid_parameter", "per_page must be between 1 and "+strconv.Itoa(apiMaxPerPage))
+			return 0, 0, false
+		}
+		perPage = n
+	}
+	return page, perPage, true
+}
+
+func newAPIPagination(page, perPage int, total int64) APIPagination {
+	totalPages := int((total + int64(perPage) - 1) / int64(perPage))
+	if totalPages == 0 {
+		totalPages = 1
+	}
+	return APIPagination{Page: page, PerPage: perPage, Total: total, TotalPages: totalPages}
+}
+
+func apiFileID(w http.ResponseWriter, r *http.Request) (uint64, bool) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		WriteAPIError(w, http.StatusBadRequest, "invalid_parameter", "Invalid file ID")
+		return 0, false
+	}
+	return id, true
+}
+
+func toAPIFile(f models.File) APIFile {
+	tags := f.Tags.Data()
+	if tags == nil {
+		tags = []string{}
+	}
+	metadata := f.Metadata.Data()
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	return APIFile{
+		ID:               f.ID,
+		Filename:         f.Filename,
+		OriginalFilename: f.OriginalFilename,
+		Folder:           f.LogicalPath,
+		Size:             f.FileSize,
+		MimeType:         f.MimeType,
+		Hash:             f.Hash,
+		UploadStatus:     f.UploadStatus,
+		ErrorMessage:     f.ErrorMessage,
+		TranscodeStatus:  f.TranscodeStatus,
+		Tags:             tags,
+		Metadata:         metadata,
+		CreatedAt:        f.CreatedAt,
+		UpdatedAt:        f.UpdatedAt,
+		DeletedAt:        f.SoftDeletedAt,
+		OriginalFolder:   f.OriginalLogicalPath,
+	}
+}
+
+func toAPIShareLink(link models.ShareLink) APIShareLink {
+	return APIShareLink{
+		Token:             link.Token,
+		URL:               "/s/" + link.Token,
+		FileID:            link.FileID,
+		ExpiresAt:         link.ExpiresAt,
+		MaxUses:           link.MaxUses,
+		Uses:              link.Uses,
+		PasswordProtected: link.PasswordHash != nil,
+		CreatedAt:         link.CreatedAt,
+	}
+}
+
+func toAPIFolderShareLink(link models.FolderShareLink) APIShareLink {
+	return APIShareLink{
+		Token:             link.Token,
+		URL:               "/f/" + link.Token,
+		Folder:            link.FolderPath,
+		ExpiresAt:         link.ExpiresAt,
+		MaxUses:           link.MaxUses,
+		Uses:              link.Uses,
+		PasswordProtected: link.PasswordHash != nil,
+		CreatedAt:         link.CreatedAt,
+	}
+}
+
+// folderExists reports whether folderPath exists for the user, either as an
+// explicit Folder row or implicitly because files live in it. Root always exists.
+func folderExists(db *gorm.DB, userID uint, folderPath string) bool {
+	if folderPath == "/" {
+		return true
+	}
+	var folderCount int64
+	db.Model(&models.Folder{}).Where("user_id = ? AND folder_path = ? AND trashed_at IS NULL", userID, folderPath).Count(&folderCount)
+	if folderCount > 0 {
+		return true
+	}
+	var fileCount int64
+	db.Model(&models.File{}).Where("user_id = ? AND logical_path = ? AND trashed_at IS NULL", userID, folderPath).Count(&fileCount)
+	return fileCount > 0
+}
+
+// validateFilename applies the same rules as RenameFile.
+func validateFilename(name string) string {
+	switch {
+	case name == "":
+		return "Name is required"
+	case len(name) > 255:
+		return "File name is too long (max 255 characters)"
+	case strings.Contains(name, "/") || strings.Contains(name, "..") || strings.Contains(name, "\\"):
+		return "Invalid file name"
+	}
+	return ""
+}
+
+// parseShareOptions validates share link options shared by file and folder shares.
+func parseShareOptions(req APICreateShareRequest) (expiresAt *time.Time, maxUses *int, passwordHash *string, msg string) {
+	if v := strings.TrimSpace(req.ExpiresAt); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return nil, nil, nil, "Invalid expiry date (use YYYY-MM-DD)"
+		}
+		// Expire at end of the chosen day in UTC
+		endOfDay := time.Date(t.Year(), t.Month(), t.Day(), 23, 59, 59, 0, time.UTC)
+		expiresAt = &endOfDay
+	}
+	if req.MaxUses != nil {
+		if *req.MaxUses < 1 {
+			return nil, nil, nil, "max_uses must be a positive integer"
+		}
+		maxUses = req.MaxUses
+	}
+	if req.Password != "" {
+		h, err := auth.HashPassword(req.Password, 10)
+		if err != nil {
+			return nil, nil, nil, "Failed to hash password"
+		}
+		passwordHash = &h
+	}
+	return expiresAt, maxUses, passwordHash, ""
+}
+
+// ListFiles handles GET /api/v1/files?folder=/path&page=1&per_page=50&sort=filename&order=asc.
+// Subfolders are always returned in full; only files are paginated.
+func (h *APIHandler) ListFiles(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+
+	folder := sanitizeFolderPath(r.URL.Query().Get("folder"))
+	if !folderExists(h.db, user.ID, folder) {
+		WriteAPIError(w, http.StatusNotFound, "not_found", "Folder not found")
+		return
+	}
+
+	page, perPage, ok := apiPagination(w, r)
+	if !ok {
+		return
+	}
+
+	sortColumns := map[string]string{
+		"filename":   "filename",
+		"size":       "file_size",
+		"created_at": "created_at",
+	}
+	sortField := r.URL.Query().Get("sort")
+	if sortField == "" {
+		sortField = "filename"
+	}
+	column, ok := sortColumns[sortField]
+	if !ok {
+		WriteAPIError(w, http.StatusBadRequest, "invalid_parameter", "sort must be one of filename, size, created_at")
+		return
+	}
+	order := strings.ToLower(r.URL.Query().Get("order"))
+	if order == "" {
+		order = "asc"
+	}
+	if order != "asc" && order != "desc" {
+		WriteAPIError(w, http.StatusBadRequest, "invalid_parameter", "order must be asc or desc")
+		return
+	}
+
+	query := h.db.Model(&models.File{}).
+		Where("user_id = ? AND logical_path = ? AND upload_status != ? AND trashed_at IS NULL", user.ID, folder, "failed")
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to list files")
+		return
+	}
+
+	var files []models.File
+	if err := query.Order(column + " " + strings.ToUpper(order)).Order("id ASC").
+		Limit(perPage).Offset((page - 1) * perPage).
+		Find(&files).Error; err != nil {
+		WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to list files")
+		return
+	}
+
+	resp := APIFolderListing{
+		Folder:     folder,
+		Folders:    listSubfolderNames(h.db, user.ID, folder),
+		Files:      make([]APIFile, 0, len(files)),
+		Pagination: newAPIPagination(page, perPage, total),
+	}
+	for _, f := range files {
+		resp.Files = append(resp.Files, toAPIFile(f))
+	}
+	writeAPIJSON(w, http.StatusOK, resp)
+}
+
+// GetFile handles GET /api/v1/files/{id}.
+func (h *APIHandler) GetFile(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+	fileID, ok := apiFileID(w, r)
+	if !ok {
+		return
+	}
+
+	var file models.File
+	if err := h.db.Where("id = ? AND user_id = ? AND trashed_at IS NULL", fileID, user.ID).First(&file).Error; err != nil {
+		WriteAPIError(w, http.StatusNotFound, "not_found", "File not found")
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, toAPIFile(file))
+}
+
+// UpdateFile handles PATCH /api/v1/files/{id}. A body with "name" renames the
+// file, "folder" moves it; both may be given to do both at once.
+func (h *APIHandler) UpdateFile(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+	fileID, ok := apiFileID(w, r)
+	if !ok {
+		return
+	}
+
+	var req APIUpdateFileRequest
+	if !decodeAPIRequest(w, r, &req) {
+		return
+	}
+	if req.Name == nil && req.Folder == nil {
+		WriteAPIError(w, http.StatusBadRequest, "invalid_request", "Provide name and/or folder")
+		return
+	}
+
+	var file models.File
+	if err := h.db.Where("id = ? AND user_id = ? AND trashed_at IS NULL", fileID, user.ID).First(&file).Error; err != nil {
+		WriteAPIError(w, http.StatusNotFound, "not_found", "File not found")
+		return
+	}
+
+	newName := file.Filename
+	if req.Name != nil {
+		newName = strings.TrimSpace(*req.Name)
+		if msg := validateFilename(newName); msg != "" {
+			WriteAPIError(w, http.StatusBadRequest, "invalid_name", msg)
+			return
+		}
+	}
+
+	newFolder := file.LogicalPath
+	if req.Folder != nil {
+		newFolder = sanitizeFolderPath(*req.Folder)
+		if !folderExists(h.db, user.ID, newFolder) {
+			WriteAPIError(w, http.StatusNotFound, "folder_not_found", "Destination folder does not exist")
+			return
+		}
+	}
+
+	if newName == file.Filename && newFolder == file.LogicalPath {
+		writeAPIJSON(w, http.StatusOK, toAPIFile(file))
+		return
+	}
+
+	// Check for name collision at the destination
+	var count int64
+	h.db.Model(&models.File{}).
+		Where("user_id = ? AND logical_path = ? AND filename = ? AND id != ?", user.ID, newFolder, newName, file.ID).
+		Count(&count)
+	if count > 0 {
+		WriteAPIError(w, http.StatusConflict, "conflict", "A file with that name already exists in the destination folder")
+		return
+	}
+
+	if err := h.db.Model(&file).Updates(map[string]interface{}{
+		"filename":     newName,
+		"logical_path": newFolder,
+	}).Error; err != nil {
+		WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to update file")
+		return
+	}
+
+	h.db.First(&file, file.ID)
+	writeAPIJSON(w, http.StatusOK, toAPIFile(file))
+}
+
+// DeleteFile handles DELETE /api/v1/files/{id}. The file moves to deleted items,
+// exactly like the Delete button in the UI.
+func (h *APIHandler) DeleteFile(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+	fileID, ok := apiFileID(w, r)
+	if !ok {
+		return
+	}
+
+	var file models.File
+	if err := h.db.Where("id = ? AND user_id = ? AND trashed_at IS NULL", fileID, user.ID).First(&file).Error; err != nil {
+		WriteAPIError(w, http.StatusNotFound, "not_found", "File not found")
+		return
+	}
+
+	if err := trashFile(h.db, &file, time.Now()); err != nil {
+		WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to delete file")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListDeleted handles GET /api/v1/deleted, newest deletions first.
+func (h *APIHandler) ListDeleted(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+	page, perPage, ok := apiPagination(w, r)
+	if !ok {
+		return
+	}
+
+	query := h.db.Model(&models.File{}).Where("user_id = ? AND trashed_at IS NOT NULL", user.ID)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to list deleted files")
+		return
+	}
+
+	var files []models.File
+	if err := query.Order("trashed_at DESC").Order("id ASC").
+		Limit(perPage).Offset((page - 1) * perPage).
+		Find(&files).Error; err != nil {
+		WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to list deleted files")
+		return
+	}
+
+	resp := APIDeletedListing{
+		Files:      make([]APIFile, 0, len(files)),
+		Pagination: newAPIPagination(page, perPage, total),
+	}
+	for _, f := range files {
+		resp.Files = append(resp.Files, toAPIFile(f))
+	}
+	writeAPIJSON(w, http.StatusOK, resp)
+}
+
+// RestoreFile handles POST /api/v1/deleted/files/{id}/restore.
+func (h *APIHandler) RestoreFile(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+	fileID, ok := apiFileID(w, r)
+	if !ok {
+		return
+	}
+
+	var file models.File
+	if err := h.db.Where("id = ? AND user_id = ? AND trashed_at IS NOT NULL", fileID, user.ID).First(&file).Error; err != nil {
+		WriteAPIError(w, http.StatusNotFound, "not_found", "File not found in deleted items")
+		return
+	}
+
+	if _, err := restoreDeletedFile(h.db, user.ID, &file); err != nil {
+		if errors.Is(err, errRestoreConflict) {
+			WriteAPIError(w, http.StatusConflict, "conflict", "A file with the same name already exists in the destination folder")
+			return
+		}
+		WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to restore file")
+		return
+	}
+
+	h.db.First(&file, file.ID)
+	writeAPIJSON(w, http.StatusOK, toAPIFile(file))
+}
+
+// CreateFolder handles POST /api/v1/folders. Missing parent folders are not created.
+func (h *APIHandler) CreateFolder(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+
+	var req APICreateFolderRequest
+	if !decodeAPIRequest(w, r, &req) {
+		return
+	}
+	if strings.Contains(req.Path, "..") {
+		WriteAPIError(w, http.StatusBadRequest, "invalid_path", "Invalid folder path")
+		return
+	}
+	folderPath := sanitizeFolderPath(req.Path)
+	if folderPath == "/" {
+		WriteAPIError(w, http.StatusBadRequest, "invalid_path", "Folder path is required")
+		return
+	}
+
+	parent := folderPath[:strings.LastIndex(folderPath, "/")]
+	if parent == "" {
+		parent = "/"
+	}
+	if !folderExists(h.db, user.ID, parent) {
+		WriteAPIError(w, http.StatusNotFound, "folder_not_found", "Parent folder does not exist")
+		return
+	}
+
+	var existing int64
+	h.db.Model(&models.Folder{}).Where("user_id = ? AND folder_path = ?", user.ID, folderPath).Count(&existing)
+	if existing > 0 {
+		WriteAPIError(w, http.StatusConflict, "conflict", "A folder with that name already exists")
+		return
+	}
+
+	folder := models.Folder{UserID: user.ID, FolderPath: folderPath}
+	if err := h.db.Create(&folder).Error; err != nil {
+		WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to create folder")
+		return
+	}
+	writeAPIJSON(w, http.StatusCreated, APIFolder{Path: folder.FolderPath, CreatedAt: folder.CreatedAt})
+}
+
+// ListFileShares handles GET /api/v1/files/{id}/shares.
+func (h *APIHandler) ListFileShares(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+	fileID, ok := apiFileID(w, r)
+	if !ok {
+		return
+	}
+
+	var file models.File
+	if err := h.db.Where("id = ? AND user_id = ? AND trashed_at IS NULL", fileID, user.ID).First(&file).Error; err != nil {
+		WriteAPIError(w, http.StatusNotFound, "not_found", "File not found")
+		return
+	}
+
+	var links []models.ShareLink
+	h.db.Where("file_id = ? AND user_id = ?", file.ID, user.ID).Order("created_at DESC").Find(&links)
+
+	resp := make([]APIShareLink, 0, len(links))
+	for _, l := range links {
+		resp = append(resp, toAPIShareLink(l))
+	}
+	writeAPIJSON(w, http.StatusOK, resp)
+}
+
+// CreateFileShare handles POST /api/v1/files/{id}/shares.
+func (h *APIHandler) CreateFileShare(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+	fileID, ok := apiFileID(w, r)
+	if !ok {
+		return
+	}
+
+	var req APICreateShareRequest
+	if !decodeAPIRequest(w, r, &req) {
+		return
+	}
+
+	var file models.File
+	if err := h.db.Where("id = ? AND user_id = ? AND trashed_at IS NULL", fileID, user.ID).First(&file).Error; err != nil {
+		WriteAPIError(w, http.StatusNotFound, "not_found", "File not found")
+		return
+	}
+
+	expiresAt, maxUses, passwordHash, msg := parseShareOptions(req)
+	if msg != "" {
+		WriteAPIError(w, http.StatusBadRequest, "invalid_request", msg)
+		return
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to create share link")
+		return
+	}
+
+	link := models.ShareLink{
+		Token:        token,
+		FileID:       file.ID,
+		UserID:       user.ID,
+		ExpiresAt:    expiresAt,
+		MaxUses:      maxUses,
+		PasswordHash: passwordHash,
+	}
+	if err := h.db.Create(&link).Error; err != nil {
+		WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to create share link")
+		return
+	}
+	writeAPIJSON(w, http.StatusCreated, toAPIShareLink(link))
+}
+
+// RevokeFileShare handles DELETE /api/v1/shares/{token}.
+func (h *APIHandler) RevokeFileShare(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+
+	result := h.db.Where("token = ? AND user_id = ?", chi.URLParam(r, "token"), user.ID).Delete(&models.ShareLink{})
+	if result.Error != nil {
+		WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to revoke share link")
+		return
+	}
+	if result.RowsAffected == 0 {
+		WriteAPIError(w, http.StatusNotFound, "not_found", "Share link not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListFolderShares handles GET /api/v1/folder-shares.
+func (h *APIHandler) ListFolderShares(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+
+	var links []models.FolderShareLink
+	h.db.Where("user_id = ?", user.ID).Order("created_at DESC").Find(&links)
+
+	resp := make([]APIShareLink, 0, len(links))
+	for _, l := range links {
+		resp = append(resp, toAPIFolderShareLink(l))
+	}
+	writeAPIJSON(w, http.StatusOK, resp)
+}
+
+// CreateFolderShare handles POST /api/v1/folder-shares.
+func (h *APIHandler) CreateFolderShare(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+
+	var req APICreateFolderShareRequest
+	if !decodeAPIRequest(w, r, &req) {
+		return
+	}
+
+	folder := sanitizeFolderPath(req.Folder)
+	if !folderExists(h.db, user.ID, folder) {
+		WriteAPIError(w, http.StatusNotFound, "folder_not_found", "Folder not found")
+		return
+	}
+
+	expiresAt, maxUses, passwordHash, msg := parseShareOptions(req.APICreateShareRequest)
+	if msg != "" {
+		WriteAPIError(w, http.StatusBadRequest, "invalid_request", msg)
+		return
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to create share link")
+		return
+	}
+
+	link := models.FolderShareLink{
+		Token:        token,
+		FolderPath:   folder,
+		UserID:       user.ID,
+		ExpiresAt:    expiresAt,
+		MaxUses:      maxUses,
+		PasswordHash: passwordHash,
+	}
+	if err := h.db.Create(&link).Error; err != nil {
+		WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to create share link")
+		return
+	}
+	writeAPIJSON(w, http.StatusCreated, toAPIFolderShareLink(link))
+}
+
+// RevokeFolderShare handles DELETE /api/v1/folder-shares/{token}.
+func (h *APIHandler) RevokeFolderShare(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+
+	result := h.db.Where("token = ? AND user_id = ?", chi.URLParam(r, "token"), user.ID).Delete(&models.FolderShareLink{})
+	if result.Error != nil {
+		WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to revoke share link")
+		return
+	}
+	if result.RowsAffected == 0 {
+		WriteAPIError(w, http.StatusNotFound, "not_found", "Share link not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetQuota handles GET /api/v1/quota.
+func (h *APIHandler) GetQuota(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+	writeAPIJSON(w, http.StatusOK, APIQuota{
+		Used:      user.StorageUsed,
+		Quota:     user.StorageQuota,
+		Available: max(user.StorageQuota-user.StorageUsed, 0),
+	})
+}
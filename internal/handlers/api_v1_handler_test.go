@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/agjmills/trove/internal/config"
+	"github.com/agjmills/trove/internal/database/models"
+)
+
+type apiTestEnv struct {
+	db     *gorm.DB
+	user   *models.User
+	router chi.Router
+}
+
+// setupAPITest mounts the /api/v1 routes with an auth middleware that always
+// authenticates as the test user.
+func setupAPITest(t *testing.T) *apiTestEnv {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.File{}, &models.FileVersion{}, &models.Blob{}, &models.Folder{}, &models.ShareLink{}, &models.FolderShareLink{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	user := &models.User{
+		Username:     "alice",
+		Email:        "alice@example.com",
+		StorageQuota: 1000,
+		StorageUsed:  250,
+	}
+	db.Create(user)
+
+	h := NewAPIHandler(db, &config.Config{}, "test")
+	r := chi.NewRouter()
+	h.Mount(r, func(string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				next.ServeHTTP(w, withUser(r, user))
+			})
+		}
+	})
+	return &apiTestEnv{db: db, user: user, router: r}
+}
+
+func (env *apiTestEnv) do(t *testing.T, method, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader *bytes.Reader
+	if body != nil {
+		payload, _ := json.Marshal(body)
+		reader = bytes.NewReader(payload)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	env.router.ServeHTTP(w, req)
+	return w
+}
+
+func (env *apiTestEnv) createFile(t *testing.T, folder, name string) *models.File {
+	t.Helper()
+	file := &models.File{
+		UserID:           env.user.ID,
+		StoragePath:      name + ".bin",
+		LogicalPath:      folder,
+		Filename:         name,
+		OriginalFilename: name,
+		FileSize:         10,
+	}
+	if err := env.db.Create(file).Error; err != nil {
+		t.Fatalf("create file: %v", err)
+	}
+	return file
+}
+
+func decodeAPIError(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body APIError
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("error body is not JSON: %v (%s)", err, w.Body.String())
+	}
+	return body.Error.Code
+}
+
+func TestAPIListFiles_Pagination(t *testing.T) {
+	env := setupAPITest(t)
+	for i := range 5 {
+		env.createFile(t, "/", fmt.Sprintf("file%d.txt", i))
+	}
+	env.db.Create(&models.Folder{UserID: env.user.ID, FolderPath: "/docs"})
+	env.createFile(t, "/docs", "inner.txt")
+
+	w := env.do(t, http.MethodGet, "/files?per_page=2&page=2", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var listing APIFolderListing
+	if err := json.Unmarshal(w.Body.Bytes(), &listing); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if listing.Pagination.Total != 5 || listing.Pagination.TotalPages != 3 {
+		t.Errorf("unexpected pagination %+v", listing.Pagination)
+	}
+	if len(listing.Files) != 2 || listing.Files[0].Filename != "file2.txt" {
+		t.Errorf("unexpected page contents %+v", listing.Files)
+	}
+	if len(listing.Folders) != 1 || listing.Folders[0] != "docs" {
+		t.Errorf("want subfolder docs, got %v", listing.Folders)
+	}
+}
+
+func TestAPIListFiles_Errors(t *testing.T) {
+	env := setupAPITest(t)
+
+	tests := []struct {
+		path string
+		code int
+		err  string
+	}{
+		{"/files?folder=/missing", http.StatusNotFound, "not_found"},
+		{"/files?per_page=500", http.StatusBadRequest, "invalid_parameter"},
+		{"/files?sort=owner", http.StatusBadRequest, "invalid_parameter"},
+		{"/nope", http.StatusNotFound, "not_found"},
+	}
+	for _, tt := range tests {
+		w := env.do(t, http.MethodGet, tt.path, nil)
+		if w.Code != tt.code {
+			t.Errorf("%s: want %d, got %d", tt.path, tt.code, w.Code)
+			continue
+		}
+		if code := decodeAPIError(t, w); code != tt.err {
+			t.Errorf("%s: want error code %q, got %q", tt.path, tt.err, code)
+		}
+	}
+}
+
+func TestAPIUpdateFile_RenameAndMove(t *testing.T) {
+	env := setupAPITest(t)
+	file := env.createFile(t, "/", "a.txt")
+	env.createFile(t, "/docs", "b.txt")
+
+	name, folder := "b.txt", "/docs"
+	w := env.do(t, http.MethodPatch, fmt.Sprintf("/files/%d", file.ID), APIUpdateFileRequest{Name: &name, Folder: &folder})
+	if w.Code != http.StatusConflict {
+		t.Fatalf("want 409 on name collision, got %d: %s", w.Code, w.Body.String())
+	}
+
+	name = "c.txt"
+	w = env.do(t, http.MethodPatch, fmt.Sprintf("/files/%d", file.ID), APIUpdateFileRequest{Name: &name, Folder: &folder})
+	if w.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var updated APIFile
+	json.Unmarshal(w.Body.Bytes(), &updated) //nolint:errcheck
+	if updated.Filename != "c.txt" || updated.Folder != "/docs" {
+		t.Errorf("unexpected result %+v", updated)
+	}
+
+	bad := "../x"
+	w = env.do(t, http.MethodPatch, fmt.Sprintf("/files/%d", file.ID), APIUpdateFileRequest{Name: &bad})
+	if w.Code != http.StatusBadRequest || decodeAPIError(t, w) != "invalid_name" {
+		t.Errorf("want invalid_name, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestAPIDeleteAndRestore(t *testing.T) {
+	env := setupAPITest(t)
+	file := env.createFile(t, "/", "a.txt")
+
+	w := env.do(t, http.MethodDelete, fmt.Sprintf("/files/%d", file.ID), nil)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("want 204, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := env.do(t, http.MethodGet, fmt.Sprintf("/files/%d", file.ID), nil); w.Code != http.StatusNotFound {
+		t.Errorf("deleted file should not be returned, got %d", w.Code)
+	}
+
+	w = env.do(t, http.MethodGet, "/deleted", nil)
+	var deleted APIDeletedListing
+	json.Unmarshal(w.Body.Bytes(), &deleted) //nolint:errcheck
+	if len(deleted.Files) != 1 || deleted.Files[0].DeletedAt == nil {
+		t.Fatalf("want one deleted file, got %s", w.Body.String())
+	}
+
+	// A new file with the same name blocks the restore
+	blocker := env.createFile(t, "/", "a.txt")
+	w = env.do(t, http.MethodPost, fmt.Sprintf("/deleted/files/%d/restore", file.ID), nil)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("want 409, got %d: %s", w.Code, w.Body.String())
+	}
+	env.db.Delete(blocker)
+
+	w = env.do(t, http.MethodPost, fmt.Sprintf("/deleted/files/%d/restore", file.ID), nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var restored APIFile
+	json.Unmarshal(w.Body.Bytes(), &restored) //nolint:errcheck
+	if restored.DeletedAt != nil || restored.Folder != "/" {
+		t.Errorf("unexpected restored file %+v", restored)
+	}
+}
+
+func TestAPIShares(t *testing.T) {
+	env := setupAPITest(t)
+	file := env.createFile(t, "/", "a.txt")
+
+	maxUses := 3
+	w := env.do(t, http.MethodPost, fmt.Sprintf("/files/%d/shares", file.ID), APICreateShareRequest{MaxUses: &maxUses, Password: "secret"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("want 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var link APIShareLink
+	json.Unmarshal(w.Body.Bytes(), &link) //nolint:errcheck
+	if link.URL != "/s/"+link.Token || !link.PasswordProtected || link.MaxUses == nil || *link.MaxUses != 3 {
+		t.Errorf("unexpected share link %+v", link)
+	}
+
+	w = env.do(t, http.MethodGet, fmt.Sprintf("/files/%d/shares", file.ID), nil)
+	var links []APIShareLink
+	json.Unmarshal(w.Body.Bytes(), &links) //nolint:errcheck
+	if len(links) != 1 {
+		t.Fatalf("want 1 link, got %s", w.Body.String())
+	}
+
+	if w := env.do(t, http.MethodDelete, "/shares/"+link.Token, nil); w.Code != http.StatusNoContent {
+		t.Fatalf("want 204, got %d", w.Code)
+	}
+	if w := env.do(t, http.MethodDelete, "/shares/"+link.Token, nil); w.Code != http.StatusNotFound {
+		t.Errorf("want 404 for revoked link, got %d", w.Code)
+	}
+}
+
+func TestAPIGetQuota(t *testing.T) {
+	env := setupAPITest(t)
+
+	w := env.do(t, http.MethodGet, "/quota", nil)
+	var quota APIQuota
+	json.Unmarshal(w.Body.Bytes(), &quota) //nolint:errcheck
+	if quota != (APIQuota{Used: 250, Quota: 1000, Available: 750}) {
+		t.Errorf("unexpected quota %+v", quota)
+	}
+}
+
+func TestAPIOpenAPIDocument(t *testing.T) {
+	env := setupAPITest(t)
+
+	w := env.do(t, http.MethodGet, "/openapi.json", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d", w.Code)
+	}
+
+	var doc struct {
+		OpenAPI    string                               `json:"openapi"`
+		Paths      map[string]map[string]map[string]any `json:"paths"`
+		Components struct {
+			Schemas map[string]any `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	for _, route := range apiRoutes {
+		op, ok := doc.Paths[route.Pattern][strings.ToLower(route.Method)]
+		if !ok {
+			t.Errorf("%s %s missing from document", route.Method, route.Pattern)
+			continue
+		}
+		if op["operationId"] == "" {
+			t.Errorf("%s %s has no operationId", route.Method, route.Pattern)
+		}
+	}
+	for _, name := range []string{"APIError", "APIFile", "APIFolderListing", "APIShareLink", "APIQuota"} {
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			t.Errorf("schema %s missing from components", name)
+		}
+	}
+}
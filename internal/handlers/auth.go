@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/alexedwards/scs/v2"
@@ -258,6 +259,26 @@ func (h *AuthHandler) ShowRegister(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// settingsData returns the template data every render of the settings page
+// needs, with extra (messages, flash, a new token) merged over it.
+func settingsData(db *gorm.DB, cfg *config.Config, user *models.User, extra map[string]any) map[string]any {
+	data := map[string]any{
+		"Title":      "Settings",
+		"User":       user,
+		"FullWidth":  true,
+		"IsOIDCUser": user.IdentityProvider == "oidc",
+		"APITokens":  listAPITokens(db, user.ID),
+		"APIScopes":  auth.AllScopes,
+
+		"DefaultMaxFileVersions":      cfg.MaxFileVersions,
+		"DefaultVersionRetentionDays": cfg.VersionRetentionDays,
+	}
+	for k, v := range extra {
+		data[k] = v
+	}
+	return data
+}
+
 func (h *AuthHandler) ShowSettings(w http.ResponseWriter, r *http.Request) {
 	user := auth.GetUser(r)
 	if user == nil {
@@ -265,16 +286,67 @@ func (h *AuthHandler) ShowSettings(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := render(w, "settings.html", map[string]any{
-		"Title":      "Settings",
-		"User":       user,
-		"FullWidth":  true,
-		"IsOIDCUser": user.IdentityProvider == "oidc",
-	}); err != nil {
+	if err := render(w, "settings.html", settingsData(h.db, h.cfg, user, map[string]any{
+		"Flash": flash.Get(w, r),
+	})); err != nil {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 	}
 }
 
+// UpdateVersioning turns file versioning on or off for the current user and
+// sets how many previous versions are kept and for how long. A blank limit
+// uses the server default.
+func (h *AuthHandler) UpdateVersioning(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	// parseLimit reads an optional non-negative number of a form field
+	parseLimit := func(field string) (*int, bool) {
+		value := strings.TrimSpace(r.FormValue(field))
+		if value == "" {
+			return nil, true
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		return &n, true
+	}
+	maxVersions, ok := parseLimit("max_versions")
+	if !ok {
+		flash.Error(w, "Versions to keep must be a whole number, 0 for unlimited")
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+		return
+	}
+	retentionDays, ok := parseLimit("retention_days")
+	if !ok {
+		flash.Error(w, "Days to keep versions must be a whole number, 0 for forever")
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+		return
+	}
+
+	if err := h.db.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+		"versioning_enabled":     r.FormValue("versioning_enabled") != "",
+		"max_file_versions":      maxVersions,
+		"version_retention_days": retentionDays,
+	}).Error; err != nil {
+		flash.Error(w, "Failed to save file version settings")
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+		return
+	}
+
+	flash.Success(w, "File version settings saved")
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
 type ChangePasswordRequest struct {
 	CurrentPassword string `json:"current_password"`
 	NewPassword     string `json:"new_password"`
@@ -298,13 +370,7 @@ func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
 		if isJSON {
 			http.Error(w, "Password changes are not available for SSO accounts", http.StatusForbidden)
 		} else {
-			if err := render(w, "settings.html", map[string]any{
-				"Title":      "Settings",
-				"User":       user,
-				"FullWidth":  true,
-				"IsOIDCUser": true,
-				"Error":      "Password changes are not available for SSO accounts.",
-			}); err != nil {
+			if err := render(w, "settings.html", settingsData(h.db, h.cfg, user, map[string]any{"Error": "Password changes are not available for SSO accounts."})); err != nil {
 				http.Error(w, "Internal server error", http.StatusInternalServerError)
 			}
 		}
@@ -329,12 +395,7 @@ func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
 		if isJSON {
 			http.Error(w, "All fields are required", http.StatusBadRequest)
 		} else {
-			if err := render(w, "settings.html", map[string]any{
-				"Title":     "Settings",
-				"User":      user,
-				"Error":     "All fields are required",
-				"FullWidth": true,
-			}); err != nil {
+			if err := render(w, "settings.html", settingsData(h.db, h.cfg, user, map[string]any{"Error": "All fields are required"})); err != nil {
 				http.Error(w, "Internal server error", http.StatusInternalServerError)
 			}
 		}
@@ -345,12 +406,7 @@ func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
 		if isJSON {
 			http.Error(w, "New passwords do not match", http.StatusBadRequest)
 		} else {
-			if err := render(w, "settings.html", map[string]any{
-				"Title":     "Settings",
-				"User":      user,
-				"Error":     "New passwords do not match",
-				"FullWidth": true,
-			}); err != nil {
+			if err := render(w, "settings.html", settingsData(h.db, h.cfg, user, map[string]any{"Error": "New passwords do not match"})); err != nil {
 				http.Error(w, "Internal server error", http.StatusInternalServerError)
 			}
 		}
@@ -361,12 +417,7 @@ func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
 		if isJSON {
 			http.Error(w, "New password must be at least 8 characters", http.StatusBadRequest)
 		} else {
-			if err := render(w, "settings.html", map[string]any{
-				"Title":     "Settings",
-				"User":      user,
-				"Error":     "New password must be at least 8 characters",
-				"FullWidth": true,
-			}); err != nil {
+			if err := render(w, "settings.html", settingsData(h.db, h.cfg, user, map[string]any{"Error": "New password must be at least 8 characters"})); err != nil {
 				http.Error(w, "Internal server error", http.StatusInternalServerError)
 			}
 		}
@@ -377,12 +428,7 @@ func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
 		if isJSON {
 			http.Error(w, "New password must be at most 72 characters", http.StatusBadRequest)
 		} else {
-			if err := render(w, "settings.html", map[string]any{
-				"Title":     "Settings",
-				"User":      user,
-				"Error":     "New password must be at most 72 characters",
-				"FullWidth": true,
-			}); err != nil {
+			if err := render(w, "settings.html", settingsData(h.db, h.cfg, user, map[string]any{"Error": "New password must be at most 72 characters"})); err != nil {
 				http.Error(w, "Internal server error", http.StatusInternalServerError)
 			}
 		}
@@ -401,12 +447,7 @@ func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
 		if isJSON {
 			http.Error(w, "Current password is incorrect", http.StatusUnauthorized)
 		} else {
-			if err := render(w, "settings.html", map[string]any{
-				"Title":     "Settings",
-				"User":      user,
-				"Error":     "Current password is incorrect",
-				"FullWidth": true,
-			}); err != nil {
+			if err := render(w, "settings.html", settingsData(h.db, h.cfg, user, map[string]any{"Error": "Current password is incorrect"})); err != nil {
 				http.Error(w, "Internal server error", http.StatusInternalServerError)
 			}
 		}
@@ -430,12 +471,7 @@ func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		_ = json.NewEncoder(w).Encode(map[string]string{"message": "Password changed successfully"})
 	} else {
-		if err := render(w, "settings.html", map[string]any{
-			"Title":     "Settings",
-			"User":      user,
-			"Success":   "Password changed successfully",
-			"FullWidth": true,
-		}); err != nil {
+		if err := render(w, "settings.html", settingsData(h.db, h.cfg, user, map[string]any{"Success": "Password changed successfully"})); err != nil {
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 		}
 	}
@@ -39,7 +39,7 @@ func newTestApp(t *testing.T) *testApp {
 		t.Fatalf("Failed to open test database: %v", err)
 	}
 
-	err = db.AutoMigrate(&models.User{}, &models.File{}, &models.Folder{})
+	err = db.AutoMigrate(&models.User{}, &models.File{}, &models.FileVersion{}, &models.Blob{}, &models.Folder{})
 	if err != nil {
 		t.Fatalf("Failed to migrate database: %v", err)
 	}
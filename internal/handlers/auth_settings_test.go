@@ -317,3 +317,27 @@ func TestChangePassword_OIDCUserForbidden_Form(t *testing.T) {
 		t.Error("OIDC user should not get a success redirect on ChangePassword")
 	}
 }
+
+func TestChangePassword_FormErrorShowsVersionDefaults(t *testing.T) {
+	handler, db, sm := setupTestAuthHandler(t)
+	handler.cfg.MaxFileVersions = 10
+	handler.cfg.VersionRetentionDays = 90
+	user := createTestUser(t, db, "versiondefaults", "versiondefaults@example.com", "oldpassword123")
+
+	body := "current_password=oldpassword123&new_password=newpassword456&confirm_password=different789"
+	req := httptest.NewRequest(http.MethodPost, "/settings/change-password", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = withUser(csrf.UnsafeSkipCheck(req), user)
+
+	w := httptest.NewRecorder()
+	sm.LoadAndSave(http.HandlerFunc(handler.ChangePassword)).ServeHTTP(w, req)
+
+	if !strings.Contains(w.Body.String(), "New passwords do not match") {
+		t.Fatalf("settings page without the error: %d", w.Code)
+	}
+	for _, want := range []string{"Default (10)", "Default (90)"} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("settings page re-rendered without %q", want)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"path"
+	"slices"
+
+	"gorm.io/gorm"
+
+	"github.com/agjmills/trove/internal/config"
+	"github.com/agjmills/trove/internal/database"
+	"github.com/agjmills/trove/internal/database/models"
+	"github.com/agjmills/trove/internal/logger"
+	"github.com/agjmills/trove/internal/storage"
+	"github.com/agjmills/trove/internal/transcode"
+)
+
+// storagePolicy returns the storage policy new files in folder logicalPath
+// of a user are saved under: that of the nearest enclosing folder that has
+// one, else the user's, else "" for the default backend. A policy that is no
+// longer configured is ignored.
+func storagePolicy(db *gorm.DB, backend storage.StorageBackend, userID uint, logicalPath string) string {
+	var folders []string
+	for p := logicalPath; p != "/" && p != "."; p = path.Dir(p) {
+		folders = append(folders, p)
+	}
+	var policy string
+	var assigned []models.Folder
+	if len(folders) > 0 {
+		db.Where("user_id = ? AND folder_path IN ? AND storage_policy <> '' AND trashed_at IS NULL", userID, folders).
+			Find(&assigned)
+	}
+	for _, folder := range folders {
+		if i := slices.IndexFunc(assigned, func(f models.Folder) bool { return f.FolderPath == folder }); i >= 0 {
+			policy = assigned[i].StoragePolicy
+			break
+		}
+	}
+	if policy == "" {
+		db.Model(&models.User{}).Where("id = ?", userID).Select("storage_policy").Scan(&policy)
+	}
+	if !storage.HasPolicy(backend, policy) {
+		logger.Warn("ignoring unconfigured storage policy", "policy", policy, "user_id", userID, "folder", logicalPath)
+		return ""
+	}
+	return policy
+}
+
+// blobScope returns the stored objects a new file row of userID may share:
+// anyone's with ENABLE_FILE_DEDUPLICATION, else only those the user's own
+// files already use.
+func blobScope(cfg *config.Config, userID uint) database.BlobScope {
+	if cfg.EnableFileDeduplication {
+		return database.BlobScope{}
+	}
+	return database.BlobScope{UserID: userID}
+}
+
+// saveBlob stores content with the given hash for a new file row, reusing
+// any object with the same content stored under opts.Policy that scope may
+// share. It returns the
+// path to store on the row and whether an existing object was reused. The
+// caller holds a reference on the path and must drop it with
+// dropBlobReference if the row is never written. Whether the object was
+// reused must not be shown to the user, as it may be another user's.
+func saveBlob(ctx context.Context, db *gorm.DB, backend storage.StorageBackend, scope database.BlobScope, hash string, content io.Reader, opts storage.SaveOptions) (string, bool, error) {
+	scope.Policy = opts.Policy
+	return storeBlob(ctx, db, backend, scope, hash, func() (storage.SaveResult, error) {
+		return backend.Save(ctx, content, opts)
+	})
+}
+
+// storeBlob is saveBlob for content stored by store under scope.Policy,
+// which is only called if no object with the same content may be shared
+// yet.
+func storeBlob(ctx context.Context, db *gorm.DB, backend storage.StorageBackend, scope database.BlobScope, hash string, store func() (storage.SaveResult, error)) (string, bool, error) {
+	if path, err := database.AcquireBlob(db, hash, scope); err != nil {
+		return "", false, err
+	} else if path != "" {
+		return path, true, nil
+	}
+
+	result, err := store()
+	if err != nil {
+		return "", false, err
+	}
+	path, err := database.RegisterBlob(db, hash, result.Path, result.Size, scope)
+	if err != nil {
+		// Nothing references the new object yet
+		_ = backend.Delete(ctx, result.Path)
+		return "", false, err
+	}
+	if path != result.Path {
+		// The same content was stored concurrently; keep theirs
+		if err := backend.Delete(ctx, result.Path); err != nil {
+			logger.Warn("Failed to delete duplicate object", "path", result.Path, "error", err)
+		}
+		return path, true, nil
+	}
+	return path, false, nil
+}
+
+// dropBlobReference releases the reference saveBlob took for a file row that
+// was never written, deleting the object if nothing else uses it.
+func dropBlobReference(ctx context.Context, db *gorm.DB, backend storage.StorageBackend, path string) {
+	last, err := database.ReleaseBlob(db, path)
+	if err != nil {
+		logger.Warn("Failed to release storage object", "path", path, "error", err)
+		return
+	}
+	if last {
+		if err := backend.Delete(ctx, path); err != nil {
+			logger.Warn("Failed to delete orphaned object", "path", path, "error", err)
+		}
+	}
+}
+
+// unreferencedObjects releases the references held by a file row that has
+// just been permanently deleted and returns the objects (original and video
+// variant) that nothing references any more, for the caller to delete.
+func unreferencedObjects(db *gorm.DB, file *models.File) ([]string, error) {
+	var paths []string
+	if file.UploadStatus == "completed" && file.StoragePath != "" {
+		last, err := database.ReleaseBlob(db, file.StoragePath)
+		if err != nil {
+			return nil, err
+		}
+		if last {
+			paths = append(paths, file.StoragePath)
+		}
+	}
+	if variant := file.VideoVariantPath; variant != "" && variant != file.StoragePath {
+		referenced, err := database.IsReferenced(db, variant)
+		if err != nil {
+			return paths, err
+		}
+		if !referenced {
+			paths = append(paths, variant)
+		}
+	}
+	return paths, nil
+}
+
+// variantSource returns a file sharing the object at storagePath that has a
+// finished video variant, for startTranscode to copy, or nil.
+func variantSource(db *gorm.DB, storagePath string) *models.File {
+	var file models.File
+	if err := db.Where("storage_path = ? AND transcode_status = ? AND video_variant_path <> ''", storagePath, transcode.StatusCompleted).
+		First(&file).Error; err != nil {
+		return nil
+	}
+	return &file
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -91,6 +92,7 @@ func (h *DeletedHandler) runCleanup() {
 
 	totalFiles := 0
 	totalFolders := 0
+	totalVersions := 0
 	totalBytes := int64(0)
 
 	// Process users in batches to avoid loading all users into memory
@@ -108,6 +110,19 @@ func (h *DeletedHandler) runCleanup() {
 		}
 
 		for _, user := range users {
+			// Prune previous file versions older than this user keeps them
+			if _, maxAge := versionLimits(h.cfg, &user); maxAge > 0 {
+				var expiredVersions []models.FileVersion
+				if err := h.db.Where("user_id = ? AND created_at < ?", user.ID, time.Now().Add(-maxAge)).
+					Find(&expiredVersions).Error; err != nil {
+					logger.Error("Deleted items cleanup: failed to find expired file versions", "user_id", user.ID, "error", err)
+				} else {
+					versions, bytes := deleteFileVersions(ctx, h.db, h.storage, expiredVersions)
+					totalVersions += versions
+					totalBytes += bytes
+				}
+			}
+
 			// Determine retention days for this user
 			retentionDays := h.cfg.DeletedRetentionDays
 			if user.DeletedRetentionDays != nil {
@@ -161,47 +176,44 @@ func (h *DeletedHandler) runCleanup() {
 		lastID = users[len(users)-1].ID
 	}
 
-	if totalFiles > 0 || totalFolders > 0 {
-		logger.Info("Deleted items cleanup complete", "files", totalFiles, "bytes", totalBytes, "folders", totalFolders)
+	if totalFiles > 0 || totalFolders > 0 || totalVersions > 0 {
+		logger.Info("Deleted items cleanup complete", "files", totalFiles, "versions", totalVersions, "bytes", totalBytes, "folders", totalFolders)
 	}
 }
 
-// permanentlyDeleteFile removes a file from storage and database
+// permanentlyDeleteFile removes a file and its previous versions from
+// storage and database
 func (h *DeletedHandler) permanentlyDeleteFile(ctx context.Context, file *models.File) error {
-	storagePath := file.StoragePath
 	fileSize := file.FileSize
 	userID := file.UserID
 	variantPath := file.VideoVariantPath
 	variantSize := file.VideoVariantSize
 
+	var versions []models.FileVersion
+	if err := h.db.Where("file_id = ?", file.ID).Find(&versions).Error; err != nil {
+		return fmt.Errorf("failed to list file versions: %w", err)
+	}
+	deleteFileVersions(ctx, h.db, h.storage, versions)
+
 	// Delete from database first
 	if err := h.db.Unscoped().Delete(file).Error; err != nil {
 		return fmt.Errorf("failed to delete file record: %w", err)
 	}
 
-	quotaDelta := int64(0)
+	// Each copy was charged to its owner's quota, so it is refunded even if
+	// the object stays for others sharing it
+	quotaDelta := fileSize
 
-	// Check if any other File records reference this physical file (deduplication check)
-	var refCount int64
-	h.db.Model(&models.File{}).Where("user_id = ? AND storage_path = ?", userID, storagePath).Count(&refCount)
-
-	// Only delete from storage if no other references exist
-	if refCount == 0 {
-		if err := h.storage.Delete(ctx, storagePath); err != nil {
-			logger.Warn("Failed to delete file from storage", "path", storagePath, "error", err)
-		}
-		quotaDelta += fileSize
+	// Delete the original and video variant once nothing references them
+	unreferenced, err := unreferencedObjects(h.db, file)
+	if err != nil {
+		logger.Warn("Failed to release storage objects", "file_id", file.ID, "error", err)
 	}
-
-	// Delete the video variant (if any) once no other records reference it.
-	// Skip when the variant shares the original's storage path (counted above).
-	if variantPath != "" && variantPath != storagePath {
-		var variantRefs int64
-		h.db.Model(&models.File{}).Where("video_variant_path = ?", variantPath).Count(&variantRefs)
-		if variantRefs == 0 {
-			if err := h.storage.Delete(ctx, variantPath); err != nil {
-				logger.Warn("Failed to delete video variant from storage", "path", variantPath, "error", err)
-			}
+	for _, path := range unreferenced {
+		if err := h.storage.Delete(ctx, path); err != nil {
+			logger.Warn("Failed to delete file from storage", "path", path, "error", err)
+		}
+		if path == variantPath && variantPath != file.StoragePath {
 			quotaDelta += variantSize
 		}
 	}
@@ -376,29 +388,13 @@ func extractFolderName(path string) string {
 	return parts[len(parts)-1]
 }
 
-// RestoreFile restores a file from deleted items
-func (h *DeletedHandler) RestoreFile(w http.ResponseWriter, r *http.Request) {
-	user := auth.GetUser(r)
-	if user == nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	fileID := chi.URLParam(r, "id")
-	if fileID == "" {
-		http.Error(w, "File ID is required", http.StatusBadRequest)
-		return
-	}
-
-	// Find the deleted file
-	var file models.File
-	if err := h.db.Where("id = ? AND user_id = ? AND trashed_at IS NOT NULL", fileID, user.ID).First(&file).Error; err != nil {
-		flash.Error(w, "File not found in deleted items")
-		http.Redirect(w, r, "/deleted", http.StatusSeeOther)
-		return
-	}
+// errRestoreConflict is returned by restoreDeletedFile when a file with the
+// same name already exists at the restore destination.
+var errRestoreConflict = errors.New("a file with the same name already exists in the destination folder")
 
-	// Restore the file to its original location
+// restoreDeletedFile moves a deleted file back to its original folder, or to the
+// root if that folder no longer exists. Returns the folder it was restored to.
+func restoreDeletedFile(db *gorm.DB, userID uint, file *models.File) (string, error) {
 	originalPath := file.OriginalLogicalPath
 	if originalPath == "" {
 		originalPath = "/"
@@ -407,11 +403,11 @@ func (h *DeletedHandler) RestoreFile(w http.ResponseWriter, r *http.Request) {
 	// Check if original folder still exists, if not restore to root
 	if originalPath != "/" {
 		var folderCount int64
-		h.db.Model(&models.Folder{}).Where("user_id = ? AND folder_path = ? AND trashed_at IS NULL", user.ID, originalPath).Count(&folderCount)
+		db.Model(&models.Folder{}).Where("user_id = ? AND folder_path = ? AND trashed_at IS NULL", userID, originalPath).Count(&folderCount)
 		if folderCount == 0 {
 			// Check for implicit folder
 			var fileCount int64
-			h.db.Model(&models.File{}).Where("user_id = ? AND logical_path = ? AND trashed_at IS NULL", user.ID, originalPath).Count(&fileCount)
+			db.Model(&models.File{}).Where("user_id = ? AND logical_path = ? AND trashed_at IS NULL", userID, originalPath).Count(&fileCount)
 			if fileCount == 0 {
 				originalPath = "/"
 			}
@@ -420,23 +416,53 @@ func (h *DeletedHandler) RestoreFile(w http.ResponseWriter, r *http.Request) {
 
 	// Check for filename collision
 	var count int64
-	h.db.Model(&models.File{}).
+	db.Model(&models.File{}).
 		Where("user_id = ? AND logical_path = ? AND filename = ? AND trashed_at IS NULL AND id != ?",
-			user.ID, originalPath, file.Filename, file.ID).
+			userID, originalPath, file.Filename, file.ID).
 		Count(&count)
-
 	if count > 0 {
-		flash.Error(w, "A file with the same name already exists in the destination folder")
-		http.Redirect(w, r, "/deleted", http.StatusSeeOther)
-		return
+		return "", errRestoreConflict
 	}
 
-	// Restore the file
-	if err := h.db.Model(&file).Updates(map[string]interface{}{
+	if err := db.Model(file).Updates(map[string]interface{}{
 		"logical_path":          originalPath,
 		"trashed_at":            nil,
 		"original_logical_path": "",
 	}).Error; err != nil {
+		return "", err
+	}
+	return originalPath, nil
+}
+
+// RestoreFile restores a file from deleted items
+func (h *DeletedHandler) RestoreFile(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	fileID := chi.URLParam(r, "id")
+	if fileID == "" {
+		http.Error(w, "File ID is required", http.StatusBadRequest)
+		return
+	}
+
+	// Find the deleted file
+	var file models.File
+	if err := h.db.Where("id = ? AND user_id = ? AND trashed_at IS NOT NULL", fileID, user.ID).First(&file).Error; err != nil {
+		flash.Error(w, "File not found in deleted items")
+		http.Redirect(w, r, "/deleted", http.StatusSeeOther)
+		return
+	}
+
+	originalPath, err := restoreDeletedFile(h.db, user.ID, &file)
+	if errors.Is(err, errRestoreConflict) {
+		flash.Error(w, "A file with the same name already exists in the destination folder")
+		http.Redirect(w, r, "/deleted", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
 		flash.Error(w, "Failed to restore file")
 		http.Redirect(w, r, "/deleted", http.StatusSeeOther)
 		return
@@ -40,7 +40,7 @@ func newDeletedTestApp(t *testing.T) *deletedTestApp {
 		t.Fatalf("Failed to open test database: %v", err)
 	}
 
-	err = db.AutoMigrate(&models.User{}, &models.File{}, &models.Folder{})
+	err = db.AutoMigrate(&models.User{}, &models.File{}, &models.FileVersion{}, &models.Blob{}, &models.Folder{})
 	if err != nil {
 		t.Fatalf("Failed to migrate database: %v", err)
 	}
@@ -508,6 +508,42 @@ func TestPermanentlyDeleteFileIntegration(t *testing.T) {
 		}
 	})
 
+	t.Run("keeps object shared with another user", func(t *testing.T) {
+		file := app.createTestFile(t, user, "shared.txt", "shared content")
+		sharer := app.createTestUser(t, "shareuser")
+		shared := *file
+		shared.ID = 0
+		shared.UserID = sharer.ID
+		app.db.Create(&shared)
+		app.db.Create(&models.Blob{Hash: file.Hash, StoragePath: file.StoragePath, Size: file.FileSize, RefCount: 2})
+		app.softDeleteFile(t, file)
+
+		var before models.User
+		app.db.First(&before, user.ID)
+
+		req := app.authenticatedRequest(t, http.MethodPost, fmt.Sprintf("/deleted/files/%d/delete", file.ID), user)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", fmt.Sprintf("%d", file.ID))
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		w := httptest.NewRecorder()
+		app.deletedHandler.PermanentlyDeleteFile(w, req)
+
+		if _, err := app.storage.Stat(context.Background(), file.StoragePath); err != nil {
+			t.Errorf("Shared object should remain in storage: %v", err)
+		}
+		var blob models.Blob
+		if err := app.db.Where("hash = ?", file.Hash).First(&blob).Error; err != nil || blob.RefCount != 1 {
+			t.Errorf("Expected blob with ref count 1, got %+v (err %v)", blob, err)
+		}
+
+		// The deleting user's quota is refunded even though the object stays
+		var after models.User
+		app.db.First(&after, user.ID)
+		if after.StorageUsed != before.StorageUsed-file.FileSize {
+			t.Errorf("Expected storage used %d, got %d", before.StorageUsed-file.FileSize, after.StorageUsed)
+		}
+	})
+
 	t.Run("cannot permanently delete other user's file", func(t *testing.T) {
 		file := app.createTestFile(t, user, "protected.txt", "protected")
 		app.softDeleteFile(t, file)
@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/agjmills/trove/internal/config"
+	"github.com/agjmills/trove/internal/diskspace"
+	"github.com/agjmills/trove/internal/logger"
+)
+
+// lowDiskSpaceMessage is the response to an upload refused because a
+// volume is low on space.
+const lowDiskSpaceMessage = "The server is low on disk space and cannot accept uploads right now; try again later or contact your administrator"
+
+// checkDiskSpace refuses an upload of size bytes (0 if unknown) with 507
+// Insufficient Storage, returning false, if storing it would leave a volume
+// it is written to below its minimum free space.
+func checkDiskSpace(w http.ResponseWriter, cfg *config.Config, size int64) bool {
+	err := diskspace.Check(diskspace.Volumes(cfg), size)
+	if err == nil {
+		return true
+	}
+	logger.Warn("upload refused", "size", size, "error", err)
+	http.Error(w, lowDiskSpaceMessage, http.StatusInsufficientStorage)
+	return false
+}
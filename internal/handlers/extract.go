@@ -0,0 +1,614 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+
+	"github.com/agjmills/trove/internal/auth"
+	"github.com/agjmills/trove/internal/database/models"
+	"github.com/agjmills/trove/internal/diskspace"
+	"github.com/agjmills/trove/internal/flash"
+	"github.com/agjmills/trove/internal/logger"
+	"github.com/agjmills/trove/internal/storage"
+	"github.com/agjmills/trove/internal/templateutil"
+)
+
+// Extraction job statuses, as stored on models.ExtractJob.Status.
+const (
+	extractPending   = "pending"
+	extractRunning   = "running"
+	extractCompleted = "completed"
+	extractFailed    = "failed"
+)
+
+// archiveFormat returns the format of an archive named filename, "zip",
+// "tar" or "tar.gz", or "" if it cannot be extracted.
+func archiveFormat(filename string) string {
+	name := strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return "zip"
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tar.gz"
+	case strings.HasSuffix(name, ".tar"):
+		return "tar"
+	}
+	return ""
+}
+
+// extractError is an extraction failure whose message can be shown to the
+// owner of the archive. err, if set, is only logged.
+type extractError struct {
+	msg string
+	err error
+}
+
+func (e *extractError) Error() string {
+	if e.err != nil {
+		return e.msg + ": " + e.err.Error()
+	}
+	return e.msg
+}
+
+func (e *extractError) Unwrap() error { return e.err }
+
+// ExtractArchive handles POST /files/{id}/extract, which queues extraction
+// of a zip or tar archive into the folder it is in.
+func (h *FileHandler) ExtractArchive(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var file models.File
+	if err := h.db.Where("id = ? AND user_id = ? AND trashed_at IS NULL AND upload_status = 'completed'", chi.URLParam(r, "id"), user.ID).
+		First(&file).Error; err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if archiveFormat(file.Filename) == "" {
+		flash.Error(w, "Only zip, tar and tar.gz archives can be extracted.")
+		http.Redirect(w, r, fmt.Sprintf("/files/%d", file.ID), http.StatusSeeOther)
+		return
+	}
+	if !checkDiskSpace(w, h.cfg, file.FileSize) {
+		return
+	}
+
+	var active int64
+	h.db.Model(&models.ExtractJob{}).
+		Where("file_id = ? AND status IN ?", file.ID, []string{extractPending, extractRunning}).
+		Count(&active)
+	if active > 0 {
+		flash.Error(w, "This archive is already being extracted.")
+		http.Redirect(w, r, folderRedirectURL(file.LogicalPath), http.StatusSeeOther)
+		return
+	}
+
+	job := models.ExtractJob{
+		UserID:     user.ID,
+		FileID:     file.ID,
+		Filename:   file.Filename,
+		FolderPath: file.LogicalPath,
+		Status:     extractPending,
+	}
+	if err := h.db.Create(&job).Error; err != nil {
+		http.Error(w, "Failed to queue extraction", http.StatusInternalServerError)
+		return
+	}
+	h.startExtractWorker()
+
+	flash.Success(w, fmt.Sprintf("Extracting \"%s\" in the background.", file.Filename))
+	http.Redirect(w, r, folderRedirectURL(file.LogicalPath), http.StatusSeeOther)
+}
+
+// ResumeExtractions queues extractions interrupted by a restart again and
+// starts the worker if any are waiting. Entries extracted before the
+// restart are skipped.
+func (h *FileHandler) ResumeExtractions() error {
+	if err := h.db.Model(&models.ExtractJob{}).Where("status = ?", extractRunning).
+		Update("status", extractPending).Error; err != nil {
+		return err
+	}
+	var pending int64
+	if err := h.db.Model(&models.ExtractJob{}).Where("status = ?", extractPending).Count(&pending).Error; err != nil {
+		return err
+	}
+	if pending > 0 {
+		h.startExtractWorker()
+	}
+	return nil
+}
+
+// WaitForExtractions waits until no extraction is queued or running.
+// This is useful in tests to ensure background processing finishes before assertions.
+func (h *FileHandler) WaitForExtractions() {
+	for {
+		var active int64
+		h.db.Model(&models.ExtractJob{}).Where("status IN ?", []string{extractPending, extractRunning}).Count(&active)
+		if active == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// startExtractWorker starts the extraction worker if it is not running yet
+// and wakes it to pick up queued jobs.
+func (h *FileHandler) startExtractWorker() {
+	h.extractOnce.Do(func() {
+		h.wg.Add(1)
+		go h.extractWorker()
+	})
+	select {
+	case h.extractWake <- struct{}{}:
+	default:
+	}
+}
+
+// extractWorker processes queued extractions one at a time until the
+// handler shuts down. A job interrupted by the shutdown is left running for
+// ResumeExtractions.
+func (h *FileHandler) extractWorker() {
+	defer h.wg.Done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-h.extractStop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		var jobs []models.ExtractJob
+		if err := h.db.Where("status = ?", extractPending).Order("id").Limit(1).Find(&jobs).Error; err != nil {
+			logger.Error("failed to load queued extraction", "error", err)
+		}
+		if len(jobs) > 0 {
+			h.runExtraction(ctx, &jobs[0])
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+
+		select {
+		case <-h.extractWake:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// runExtraction extracts the archive of job and records the outcome.
+func (h *FileHandler) runExtraction(ctx context.Context, job *models.ExtractJob) {
+	if err := h.db.Model(job).Update("status", extractRunning).Error; err != nil {
+		logger.Error("failed to start extraction", "job_id", job.ID, "error", err)
+		return
+	}
+	logger.Info("extracting archive", "job_id", job.ID, "file_id", job.FileID, "folder", job.FolderPath)
+
+	err := h.extract(ctx, job)
+	if ctx.Err() != nil {
+		return
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{"status": extractCompleted, "finished_at": now}
+	if err != nil {
+		logger.Warn("archive extraction failed", "job_id", job.ID, "file_id", job.FileID, "error", err)
+		updates["status"] = extractFailed
+		updates["error"] = "Extraction failed. Check server logs for details."
+		var shown *extractError
+		if errors.As(err, &shown) {
+			updates["error"] = shown.msg
+		}
+	} else {
+		logger.Info("archive extracted", "job_id", job.ID, "file_id", job.FileID, "entries", job.Processed)
+	}
+	if err := h.db.Model(job).Updates(updates).Error; err != nil {
+		logger.Error("failed to record extraction result", "job_id", job.ID, "error", err)
+	}
+}
+
+// extract checks the whole archive of job against the entry limit, the
+// upload size limit and the owner's remaining quota, then adds its entries
+// to the folder tree, skipping those a previous attempt extracted.
+func (h *FileHandler) extract(ctx context.Context, job *models.ExtractJob) error {
+	var archive models.File
+	if err := h.db.Where("id = ? AND user_id = ? AND trashed_at IS NULL AND upload_status = 'completed'", job.FileID, job.UserID).
+		First(&archive).Error; err != nil {
+		return &extractError{msg: "The archive no longer exists", err: err}
+	}
+	format := archiveFormat(job.Filename)
+
+	tempPath, err := h.downloadArchive(ctx, &archive)
+	if tempPath != "" {
+		defer os.Remove(tempPath) //nolint:errcheck
+	}
+	if err != nil {
+		return err
+	}
+
+	entries, total, err := scanArchive(tempPath, format, h.cfg.ExtractMaxEntries, h.cfg.MaxUploadSize)
+	if err != nil {
+		return err
+	}
+	var user models.User
+	if err := h.db.First(&user, job.UserID).Error; err != nil {
+		return err
+	}
+	if remaining := total - job.ExtractedSize; user.StorageUsed+remaining > user.StorageQuota {
+		return &extractError{msg: fmt.Sprintf("Storage quota exceeded: the archive expands to %s", templateutil.FormatBytes(total))}
+	}
+	if err := h.db.Model(job).Updates(map[string]interface{}{"entries": entries, "total_size": total}).Error; err != nil {
+		return err
+	}
+
+	index := 0
+	return walkArchive(tempPath, format, func(entry archiveEntry) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		index++
+		if index <= job.Processed {
+			return nil
+		}
+
+		var err error
+		if entry.dir {
+			err = h.db.Transaction(func(tx *gorm.DB) error {
+				return ensureFolders(tx, job.UserID, path.Join(job.FolderPath, entry.name))
+			})
+		} else {
+			err = h.extractFile(ctx, job, entry)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", entry.name, err)
+		}
+
+		job.Processed = index
+		if !entry.dir {
+			job.ExtractedSize += entry.size
+		}
+		return h.db.Model(job).Updates(map[string]interface{}{
+			"processed":      job.Processed,
+			"extracted_size": job.ExtractedSize,
+		}).Error
+	})
+}
+
+// downloadArchive copies the content of archive to a temp file, as zip
+// archives must be read out of order, and returns its path.
+func (h *FileHandler) downloadArchive(ctx context.Context, archive *models.File) (string, error) {
+	reader, err := h.storage.Open(ctx, archive.StoragePath)
+	if err != nil {
+		return "", &extractError{msg: "The archive could not be read from storage", err: err}
+	}
+	defer reader.Close() //nolint:errcheck
+	recordAccess(h.db, archive)
+
+	temp, err := os.CreateTemp(h.cfg.TempDir, "trove-extract-*")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(temp, reader)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	return temp.Name(), err
+}
+
+// extractFile stores one file entry of an archive through the same
+// deduplicating save as uploads, charging it to the owner's quota.
+func (h *FileHandler) extractFile(ctx context.Context, job *models.ExtractJob, entry archiveEntry) error {
+	folder := path.Join(job.FolderPath, path.Dir(entry.name))
+	filename := path.Base(entry.name)
+
+	if err := diskspace.Check(diskspace.Volumes(h.cfg), entry.size); err != nil {
+		return &extractError{msg: lowDiskSpaceMessage, err: err}
+	}
+
+	src, err := entry.open()
+	if err != nil {
+		return &extractError{msg: "The archive is damaged", err: err}
+	}
+	defer src.Close() //nolint:errcheck
+
+	temp, err := os.CreateTemp(h.cfg.TempDir, "trove-extract-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name()) //nolint:errcheck
+	defer temp.Close()           //nolint:errcheck
+
+	// An entry may not expand past the size the archive declared for it,
+	// which is what the quota was checked against
+	hasher := sha256.New()
+	written, err := io.Copy(io.MultiWriter(temp, hasher), io.LimitReader(src, entry.size+1))
+	if err != nil {
+		return &extractError{msg: "The archive is damaged", err: err}
+	}
+	if written > entry.size {
+		return &extractError{msg: "The archive is damaged: a file is larger than the archive says"}
+	}
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	mimeType := firstNonEmpty(mime.TypeByExtension(path.Ext(filename)), "application/octet-stream")
+	storagePath, deduplicated, err := saveBlob(ctx, h.db, h.storage, blobScope(h.cfg, job.UserID), hash, temp, storage.SaveOptions{
+		OriginalFilename: filename,
+		ContentType:      mimeType,
+		Policy:           storagePolicy(h.db, h.storage, job.UserID, folder),
+	})
+	if err != nil {
+		return err
+	}
+
+	file := models.File{
+		UserID:           job.UserID,
+		StoragePath:      storagePath,
+		LogicalPath:      folder,
+		OriginalFilename: filename,
+		FileSize:         written,
+		MimeType:         mimeType,
+		Hash:             hash,
+		UploadStatus:     "completed",
+	}
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := ensureFolders(tx, job.UserID, folder); err != nil {
+			return err
+		}
+		// Other uploads may have used the quota since the archive was checked
+		res := tx.Model(&models.User{}).
+			Where("id = ? AND storage_used + ? <= storage_quota", job.UserID, written).
+			UpdateColumn("storage_used", gorm.Expr("storage_used + ?", written))
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return &extractError{msg: "Storage quota exceeded"}
+		}
+		file.Filename = uniqueFilename(tx, job.UserID, folder, filename)
+		return tx.Create(&file).Error
+	})
+	if err != nil {
+		dropBlobReference(context.Background(), h.db, h.storage, storagePath)
+		return err
+	}
+
+	var duplicateOf *models.File
+	if deduplicated {
+		duplicateOf = variantSource(h.db, storagePath)
+	}
+	startTranscode(h.db, h.cfg, &file, duplicateOf)
+	return nil
+}
+
+// archiveEntry is a file or folder in an archive.
+type archiveEntry struct {
+	name string // Slash-separated path relative to the folder the archive is extracted into
+	dir  bool
+	size int64 // Expanded size the archive declares for a file
+	open func() (io.ReadCloser, error)
+}
+
+// errUnsafeEntryPath refuses an archive with an entry that would be
+// extracted outside its folder ("zip slip").
+var errUnsafeEntryPath = errors.New("entry path leaves the extraction folder")
+
+// archiveEntryPath returns the path of an archive entry named name relative
+// to the folder it is extracted into, or "" for the folder itself. Absolute
+// paths and paths that climb out of the folder are refused.
+func archiveEntryPath(name string) (string, error) {
+	name = strings.ReplaceAll(name, `\`, "/")
+	if strings.HasPrefix(name, "/") || (len(name) >= 2 && name[1] == ':') {
+		return "", errUnsafeEntryPath
+	}
+	var parts []string
+	for _, part := range strings.Split(name, "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			return "", errUnsafeEntryPath
+		}
+		if len(part) > 255 {
+			return "", &extractError{msg: "File name too long: the archive has a name over 255 bytes"}
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "/"), nil
+}
+
+// walkArchive calls fn with each file and folder of the archive at
+// archivePath, in archive order. Symbolic links, devices and macOS resource
+// forks are left out.
+func walkArchive(archivePath, format string, fn func(archiveEntry) error) error {
+	visit := func(rawName string, entry archiveEntry) error {
+		name, err := archiveEntryPath(rawName)
+		if errors.Is(err, errUnsafeEntryPath) {
+			return &extractError{msg: "The archive has paths that lead outside its folder, so nothing was extracted", err: fmt.Errorf("%q: %w", rawName, err)}
+		}
+		if err != nil {
+			return err
+		}
+		if name == "" || name == "__MACOSX" || strings.HasPrefix(name, "__MACOSX/") {
+			return nil
+		}
+		entry.name = name
+		return fn(entry)
+	}
+
+	if format == "zip" {
+		zr, err := zip.OpenReader(archivePath)
+		if err != nil {
+			return &extractError{msg: "The archive is damaged or not a zip file", err: err}
+		}
+		defer zr.Close() //nolint:errcheck
+		for _, f := range zr.File {
+			mode := f.Mode()
+			if !mode.IsDir() && !mode.IsRegular() {
+				continue
+			}
+			size := int64(f.UncompressedSize64)
+			if size < 0 {
+				return &extractError{msg: "The archive is damaged or not a zip file"}
+			}
+			if err := visit(f.Name, archiveEntry{dir: mode.IsDir(), size: size, open: f.Open}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close() //nolint:errcheck
+	var r io.Reader = file
+	if format == "tar.gz" {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return &extractError{msg: "The archive is damaged or not a tar.gz file", err: err}
+		}
+		defer gz.Close() //nolint:errcheck
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return &extractError{msg: "The archive is damaged or not a tar file", err: err}
+		}
+		var entry archiveEntry
+		switch header.Typeflag {
+		case tar.TypeDir:
+			entry.dir = true
+		case tar.TypeReg:
+			entry.size = header.Size
+			entry.open = func() (io.ReadCloser, error) { return io.NopCloser(tr), nil }
+		default:
+			continue
+		}
+		if err := visit(header.Name, entry); err != nil {
+			return err
+		}
+	}
+}
+
+// scanArchive checks every entry of an archive before anything is
+// extracted, guarding against archives that expand far beyond their size,
+// and returns the number of entries and their total expanded size.
+func scanArchive(archivePath, format string, maxEntries int, maxFileSize int64) (int, int64, error) {
+	var entries int
+	var total int64
+	err := walkArchive(archivePath, format, func(entry archiveEntry) error {
+		entries++
+		if maxEntries > 0 && entries > maxEntries {
+			return &extractError{msg: fmt.Sprintf("The archive has more than %d files and folders", maxEntries)}
+		}
+		if entry.dir {
+			return nil
+		}
+		if maxFileSize > 0 && entry.size > maxFileSize {
+			return &extractError{msg: fmt.Sprintf("File too large: the archive has a file over the %s upload limit", templateutil.FormatBytes(maxFileSize))}
+		}
+		total += entry.size
+		if total < 0 {
+			return &extractError{msg: "The archive is damaged"}
+		}
+		return nil
+	})
+	return entries, total, err
+}
+
+// ExtractStatusEvent reports the progress of an archive extraction on the
+// status stream.
+type ExtractStatusEvent struct {
+	ID        uint   `json:"id"`
+	FileID    uint   `json:"file_id"`
+	Filename  string `json:"filename"`
+	Folder    string `json:"folder"`
+	Status    string `json:"status"`
+	Entries   int    `json:"entries"`
+	Processed int    `json:"processed"`
+	Error     string `json:"error,omitempty"`
+}
+
+// sendExtractEvents writes an extract event for each of the user's
+// extractions that is queued, running or finished since the given time and
+// has changed since the last one sent, as recorded in last. It returns how
+// many are still queued or running.
+func (h *FileHandler) sendExtractEvents(w io.Writer, userID uint, since time.Time, last map[uint]string) (int, error) {
+	var jobs []models.ExtractJob
+	if err := h.db.Where("user_id = ? AND (status IN ? OR updated_at > ?)",
+		userID, []string{extractPending, extractRunning}, since).
+		Find(&jobs).Error; err != nil {
+		return 0, err
+	}
+
+	active := 0
+	seen := make(map[uint]bool, len(jobs))
+	for _, job := range jobs {
+		seen[job.ID] = true
+		if job.Status == extractPending || job.Status == extractRunning {
+			active++
+		}
+		state := fmt.Sprintf("%s|%d|%d", job.Status, job.Entries, job.Processed)
+		if last[job.ID] == state {
+			continue
+		}
+		last[job.ID] = state
+
+		data, err := json.Marshal(ExtractStatusEvent{
+			ID:        job.ID,
+			FileID:    job.FileID,
+			Filename:  job.Filename,
+			Folder:    job.FolderPath,
+			Status:    job.Status,
+			Entries:   job.Entries,
+			Processed: job.Processed,
+			Error:     job.Error,
+		})
+		if err != nil {
+			return active, err
+		}
+		if _, err := fmt.Fprintf(w, "event: extract\ndata: %s\n\n", data); err != nil {
+			return active, err
+		}
+	}
+	for id := range last {
+		if !seen[id] {
+			delete(last, id)
+		}
+	}
+	return active, nil
+}
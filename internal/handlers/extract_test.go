@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/agjmills/trove/internal/database/models"
+)
+
+// archiveFile is an entry of a test archive; names ending in "/" are folders.
+type archiveFile struct {
+	name    string
+	content string
+}
+
+func buildZip(t *testing.T, files ...archiveFile) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatalf("zip %s: %v", f.name, err)
+		}
+		_, _ = io.WriteString(w, f.content)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	return buf.String()
+}
+
+func buildTarGz(t *testing.T, files ...archiveFile) string {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, f := range files {
+		header := &tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.content)), Typeflag: tar.TypeReg}
+		if strings.HasSuffix(f.name, "/") {
+			header = &tar.Header{Name: f.name, Mode: 0755, Typeflag: tar.TypeDir}
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatalf("tar %s: %v", f.name, err)
+		}
+		_, _ = io.WriteString(tw, f.content)
+	}
+	_ = tw.WriteHeader(&tar.Header{Name: "link", Linkname: "/etc/passwd", Typeflag: tar.TypeSymlink})
+	if err := tw.Close(); err != nil {
+		t.Fatalf("tar: %v", err)
+	}
+	_ = gz.Close()
+	return buf.String()
+}
+
+// runTestExtraction extracts archive synchronously from job and returns the
+// job as it was left.
+func runTestExtraction(t *testing.T, app *fileTestApp, user *models.User, archive *models.File, processed int) models.ExtractJob {
+	t.Helper()
+	job := models.ExtractJob{UserID: user.ID, FileID: archive.ID, Filename: archive.Filename, FolderPath: archive.LogicalPath, Status: extractPending, Processed: processed}
+	if err := app.db.Create(&job).Error; err != nil {
+		t.Fatalf("Failed to create job: %v", err)
+	}
+	app.fileHandler.runExtraction(context.Background(), &job)
+	app.db.First(&job, job.ID)
+	return job
+}
+
+func (app *fileTestApp) fileContent(t *testing.T, user *models.User, folder, name string) string {
+	t.Helper()
+	var file models.File
+	if err := app.db.Where("user_id = ? AND logical_path = ? AND filename = ?", user.ID, folder, name).First(&file).Error; err != nil {
+		t.Fatalf("%s/%s not extracted: %v", folder, name, err)
+	}
+	rc, err := app.storage.Open(context.Background(), file.StoragePath)
+	if err != nil {
+		t.Fatalf("open %s: %v", name, err)
+	}
+	defer rc.Close() //nolint:errcheck
+	data, _ := io.ReadAll(rc)
+	return string(data)
+}
+
+func TestExtractArchive_Zip(t *testing.T) {
+	app := newFileTestApp(t)
+	app.cfg.TempDir = t.TempDir()
+	user := app.createTestUser(t, "extractzipuser")
+
+	archive := app.createTestFile(t, user, "bundle.zip", buildZip(t,
+		archiveFile{name: "docs/"},
+		archiveFile{name: "docs/a.txt", content: "alpha"},
+		archiveFile{name: "docs/sub/b.txt", content: "beta"},
+		archiveFile{name: "./top.txt", content: "top"},
+		archiveFile{name: "__MACOSX/docs/._a.txt", content: "fork"},
+	))
+	app.db.Model(archive).Update("logical_path", "/in")
+	app.createTestFile(t, user, "top.txt", "existing")
+	app.db.Model(&models.File{}).Where("user_id = ? AND filename = ?", user.ID, "top.txt").Update("logical_path", "/in")
+	var before models.User
+	app.db.First(&before, user.ID)
+
+	req := app.authenticatedRequest(t, http.MethodPost, "/files/1/extract", nil, user)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", fmt.Sprint(archive.ID))
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	w := httptest.NewRecorder()
+	app.fileHandler.ExtractArchive(w, req)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != folderRedirectURL("/in") {
+		t.Fatalf("ExtractArchive = %d to %q, want a redirect to /in", w.Code, w.Header().Get("Location"))
+	}
+	app.fileHandler.WaitForExtractions()
+
+	var job models.ExtractJob
+	app.db.Where("file_id = ?", archive.ID).First(&job)
+	if job.Status != extractCompleted || job.Entries != 4 || job.Processed != 4 || job.TotalSize != 12 {
+		t.Fatalf("job = %+v, want 4 entries of 12 bytes completed", job)
+	}
+
+	if got := app.fileContent(t, user, "/in/docs", "a.txt"); got != "alpha" {
+		t.Errorf("a.txt = %q", got)
+	}
+	if got := app.fileContent(t, user, "/in/docs/sub", "b.txt"); got != "beta" {
+		t.Errorf("b.txt = %q", got)
+	}
+	if got := app.fileContent(t, user, "/in", "top (1).txt"); got != "top" {
+		t.Errorf("top (1).txt = %q, want the extracted copy renamed beside the existing top.txt", got)
+	}
+	for _, folder := range []string{"/in", "/in/docs", "/in/docs/sub"} {
+		var count int64
+		app.db.Model(&models.Folder{}).Where("user_id = ? AND folder_path = ?", user.ID, folder).Count(&count)
+		if count != 1 {
+			t.Errorf("%d folder rows for %s, want 1", count, folder)
+		}
+	}
+	var forks int64
+	app.db.Model(&models.File{}).Where("user_id = ? AND logical_path LIKE ?", user.ID, "%__MACOSX%").Count(&forks)
+	if forks != 0 {
+		t.Error("macOS resource forks were extracted")
+	}
+
+	var after models.User
+	app.db.First(&after, user.ID)
+	if after.StorageUsed != before.StorageUsed+12 {
+		t.Errorf("storage used = %d, want %d", after.StorageUsed, before.StorageUsed+12)
+	}
+}
+
+func TestExtractArchive_TarGzResume(t *testing.T) {
+	app := newFileTestApp(t)
+	app.cfg.TempDir = t.TempDir()
+	user := app.createTestUser(t, "extracttaruser")
+
+	archive := app.createTestFile(t, user, "photos.tar.gz", buildTarGz(t,
+		archiveFile{name: "2024/"},
+		archiveFile{name: "2024/one.txt", content: "one"},
+		archiveFile{name: "2024/two.txt", content: "two"},
+	))
+
+	// The first two entries were extracted before a restart
+	job := runTestExtraction(t, app, user, archive, 2)
+	if job.Status != extractCompleted || job.Entries != 3 || job.Processed != 3 || job.ExtractedSize != 3 {
+		t.Fatalf("job = %+v, want the last entry extracted", job)
+	}
+	if got := app.fileContent(t, user, "/2024", "two.txt"); got != "two" {
+		t.Errorf("two.txt = %q", got)
+	}
+	var count int64
+	app.db.Model(&models.File{}).Where("user_id = ? AND filename = ?", user.ID, "one.txt").Count(&count)
+	if count != 0 {
+		t.Error("an entry extracted before the restart was extracted again")
+	}
+}
+
+func TestExtractArchive_Refused(t *testing.T) {
+	app := newFileTestApp(t)
+	app.cfg.TempDir = t.TempDir()
+	app.cfg.ExtractMaxEntries = 3
+	user := app.createTestUser(t, "extractrefuseduser")
+
+	for name, tc := range map[string]struct {
+		content string
+		want    string
+	}{
+		"slip.zip": {buildZip(t, archiveFile{name: "ok.txt", content: "ok"}, archiveFile{name: "../../evil.txt", content: "evil"}), "The archive has paths that lead outside its folder"},
+		"abs.zip":  {buildZip(t, archiveFile{name: "/etc/evil.txt", content: "evil"}), "The archive has paths that lead outside its folder"},
+		"many.zip": {buildZip(t, archiveFile{name: "a"}, archiveFile{name: "b"}, archiveFile{name: "c"}, archiveFile{name: "d"}), "The archive has more than 3 files and folders"},
+		"big.tar":  {buildZip(t, archiveFile{name: "a", content: strings.Repeat("x", 64)}), "The archive is damaged or not a tar file"},
+		"huge.zip": {buildZip(t, archiveFile{name: "a", content: strings.Repeat("x", 200)}), "Storage quota exceeded"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			archive := app.createTestFile(t, user, name, tc.content)
+			if name == "huge.zip" {
+				var current models.User
+				app.db.First(&current, user.ID)
+				app.db.Model(user).Update("storage_quota", current.StorageUsed+100)
+				defer app.db.Model(user).Update("storage_quota", app.cfg.DefaultUserQuota)
+			}
+
+			job := runTestExtraction(t, app, user, archive, 0)
+			if job.Status != extractFailed || !strings.HasPrefix(job.Error, tc.want) {
+				t.Errorf("job = %q %q, want failed with %q", job.Status, job.Error, tc.want)
+			}
+			var extracted int64
+			app.db.Model(&models.File{}).Where("user_id = ? AND id > ?", user.ID, archive.ID).Count(&extracted)
+			if extracted != 0 {
+				t.Errorf("%d files extracted from a refused archive", extracted)
+			}
+		})
+	}
+}
+
+func TestExtractArchive_NotAnArchive(t *testing.T) {
+	app := newFileTestApp(t)
+	user := app.createTestUser(t, "extractplainuser")
+	file := app.createTestFile(t, user, "notes.txt", "notes")
+
+	req := app.authenticatedRequest(t, http.MethodPost, "/files/1/extract", nil, user)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", fmt.Sprint(file.ID))
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	w := httptest.NewRecorder()
+	app.fileHandler.ExtractArchive(w, req)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("ExtractArchive = %d, want a redirect", w.Code)
+	}
+	var jobs int64
+	app.db.Model(&models.ExtractJob{}).Where("user_id = ?", user.ID).Count(&jobs)
+	if jobs != 0 {
+		t.Error("a job was queued for a file that is not an archive")
+	}
+}
+
+func TestArchiveEntryPath(t *testing.T) {
+	for name, want := range map[string]string{
+		"a/b.txt":     "a/b.txt",
+		"./a//b.txt":  "a/b.txt",
+		`dir\file`:    "dir/file",
+		"a/":          "a",
+		".":           "",
+		"../a":        "!",
+		"a/../../b":   "!",
+		"/etc/passwd": "!",
+		`C:\evil`:     "!",
+	} {
+		got, err := archiveEntryPath(name)
+		if want == "!" {
+			if err == nil {
+				t.Errorf("archiveEntryPath(%q) = %q, want it refused", name, got)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("archiveEntryPath(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+}
@@ -28,6 +28,7 @@ import (
 
 	"github.com/agjmills/trove/internal/auth"
 	"github.com/agjmills/trove/internal/config"
+	"github.com/agjmills/trove/internal/database"
 	"github.com/agjmills/trove/internal/database/models"
 	"github.com/agjmills/trove/internal/flash"
 	"github.com/agjmills/trove/internal/storage"
@@ -51,6 +52,9 @@ type FileHandler struct {
 	uploadQueue chan uploadJob
 	wg          sync.WaitGroup
 	pendingJobs sync.WaitGroup // tracks jobs currently being processed
+	extractWake chan struct{}  // signals the extraction worker that a job is queued
+	extractStop chan struct{}  // closed on shutdown to stop the extraction worker
+	extractOnce sync.Once      // starts the extraction worker on first use
 }
 
 func NewFileHandler(db *gorm.DB, cfg *config.Config, storage storage.StorageBackend) *FileHandler {
@@ -59,6 +63,8 @@ func NewFileHandler(db *gorm.DB, cfg *config.Config, storage storage.StorageBack
 		cfg:         cfg,
 		storage:     storage,
 		uploadQueue: make(chan uploadJob, 100), // Buffer up to 100 pending uploads
+		extractWake: make(chan struct{}, 1),
+		extractStop: make(chan struct{}),
 	}
 
 	// Start background workers (adjust number based on your needs)
@@ -74,6 +80,7 @@ func NewFileHandler(db *gorm.DB, cfg *config.Config, storage storage.StorageBack
 // Shutdown gracefully stops the background workers
 func (h *FileHandler) Shutdown() {
 	close(h.uploadQueue)
+	close(h.extractStop)
 	h.wg.Wait()
 }
 
@@ -119,26 +126,6 @@ func (h *FileHandler) processUpload(job uploadJob) {
 	// Update status to uploading
 	h.db.Model(&file).Update("upload_status", "uploading")
 
-	// Check for deduplication
-	var existingFile models.File
-	if err := h.db.Where("user_id = ? AND hash = ? AND upload_status = ?", file.UserID, file.Hash, "completed").
-		Where("id != ?", file.ID).First(&existingFile).Error; err == nil {
-		// Duplicate found - reuse existing storage path
-		log.Printf("Upload worker: deduplication - reusing storage path %s", existingFile.StoragePath)
-
-		if err := h.db.Model(&file).Updates(map[string]interface{}{
-			"storage_path":  existingFile.StoragePath,
-			"upload_status": "completed",
-			"temp_path":     "",
-		}).Error; err != nil {
-			log.Printf("Upload worker: failed to update file record: %v", err)
-		}
-
-		// Clean up temp file
-		_ = os.Remove(job.tempPath)
-		return
-	}
-
 	// Open temp file
 	tempFile, err := os.Open(job.tempPath)
 	if err != nil {
@@ -149,11 +136,12 @@ func (h *FileHandler) processUpload(job uploadJob) {
 	}
 	defer tempFile.Close() //nolint:errcheck
 
-	// Upload to storage backend
+	// Upload to storage backend, unless an object with this content may be shared
 	log.Printf("Upload worker: uploading file %d to storage backend", job.fileID)
-	result, err := h.storage.Save(ctx, tempFile, storage.SaveOptions{
+	storagePath, deduplicated, err := saveBlob(ctx, h.db, h.storage, blobScope(h.cfg, file.UserID), file.Hash, tempFile, storage.SaveOptions{
 		OriginalFilename: file.OriginalFilename,
 		ContentType:      file.MimeType,
+		Policy:           storagePolicy(h.db, h.storage, file.UserID, file.LogicalPath),
 	})
 
 	if err != nil {
@@ -162,23 +150,24 @@ func (h *FileHandler) processUpload(job uploadJob) {
 		_ = os.Remove(job.tempPath)
 		return
 	}
+	if deduplicated {
+		log.Printf("Upload worker: deduplication - reusing storage path %s", storagePath)
+	}
 
 	// Update file record with storage path and mark as completed
 	if err := h.db.Model(&file).Updates(map[string]interface{}{
-		"storage_path":  result.Path,
+		"storage_path":  storagePath,
 		"upload_status": "completed",
 		"temp_path":     "",
 	}).Error; err != nil {
 		log.Printf("Upload worker: failed to update file record: %v", err)
 		// Try to clean up the uploaded file
-		if err := h.storage.Delete(ctx, result.Path); err != nil {
-			log.Printf("Upload worker: failed to delete orphaned file %s: %v", result.Path, err)
-		}
+		dropBlobReference(ctx, h.db, h.storage, storagePath)
 		_ = os.Remove(job.tempPath)
 		return
 	}
 
-	log.Printf("Upload worker: successfully uploaded file %d as %s", job.fileID, result.Path)
+	log.Printf("Upload worker: successfully uploaded file %d as %s", job.fileID, storagePath)
 
 	// Clean up temp file
 	_ = os.Remove(job.tempPath)
@@ -328,6 +317,12 @@ func escapeSQLLike(s string) string {
 	return replacer.Replace(s)
 }
 
+// Upload handles POST /upload, a multipart form with one or more files. A
+// relative_path field before a file gives its path within a folder being
+// uploaded, and the file is stored in the matching folder under the folder
+// field, which is created if need be. Each file is stored on its own, so one
+// that fails does not undo or stop the others; a client that accepts JSON
+// gets what happened to each file.
 func (h *FileHandler) Upload(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Upload handler: MaxUploadSize configured as %d bytes (%.2f MB)", h.cfg.MaxUploadSize, float64(h.cfg.MaxUploadSize)/(1024*1024))
 
@@ -343,6 +338,9 @@ func (h *FileHandler) Upload(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("File too large (max %d MB)", h.cfg.MaxUploadSize/(1024*1024)), http.StatusRequestEntityTooLarge)
 		return
 	}
+	if !checkDiskSpace(w, h.cfg, r.ContentLength) {
+		return
+	}
 
 	// Wrap request body with MaxBytesReader for streaming protection
 	r.Body = http.MaxBytesReader(w, r.Body, h.cfg.MaxUploadSize)
@@ -364,21 +362,20 @@ func (h *FileHandler) Upload(w http.ResponseWriter, r *http.Request) {
 
 	folderPath := "/"
 	var tagsRaw string
-	var fileProcessed bool
-	var originalFilename string
-	var hash string
-	var actualSize int64
-	var mimeType string
-	var tempFilePath string
+	var conflictRaw string
+	var relativePath string
+	var parts []uploadedPart
 
 	// Ensure temp file cleanup on all exit paths
 	defer func() {
-		if tempFilePath != "" {
-			_ = os.Remove(tempFilePath)
+		for _, part := range parts {
+			if part.tempPath != "" {
+				_ = os.Remove(part.tempPath)
+			}
 		}
 	}()
 
-	// Parse multipart form: stream file to temp while computing hash
+	// Parse multipart form: stream each file to temp while computing hash
 	for {
 		part, err := mr.NextPart()
 		if err == io.EOF {
@@ -407,28 +404,33 @@ func (h *FileHandler) Upload(w http.ResponseWriter, r *http.Request) {
 				folderPath = sanitizeFolderPath(string(data))
 			}
 
+		case "relative_path":
+			// Applies to the file part that follows it
+			data, _ := io.ReadAll(io.LimitReader(part, 4096))
+			relativePath = string(data)
+
 		case "tags":
 			data, _ := io.ReadAll(io.LimitReader(part, 4096))
 			tagsRaw = strings.TrimSpace(string(data))
 
-		case "file":
-			if fileProcessed {
-				_ = part.Close()
-				continue
-			}
+		case "on_conflict":
+			data, _ := io.ReadAll(io.LimitReader(part, 64))
+			conflictRaw = string(data)
 
-			originalFilename = part.FileName()
-			if originalFilename == "" {
+		case "file":
+			uploaded := uploadedPart{originalFilename: part.FileName(), relativePath: relativePath}
+			relativePath = ""
+			if uploaded.originalFilename == "" {
 				_ = part.Close()
 				continue
 			}
 
-			mimeType = part.Header.Get("Content-Type")
-			if mimeType == "" {
-				mimeType = "application/octet-stream"
+			uploaded.mimeType = part.Header.Get("Content-Type")
+			if uploaded.mimeType == "" {
+				uploaded.mimeType = "application/octet-stream"
 			}
 
-			log.Printf("Upload: streaming %s to temp file", originalFilename)
+			log.Printf("Upload: streaming %s to temp file", uploaded.originalFilename)
 
 			// Create temp file (use configured temp dir, or system default if empty)
 			tempFile, err := os.CreateTemp(h.cfg.TempDir, "trove-upload-*")
@@ -438,7 +440,8 @@ func (h *FileHandler) Upload(w http.ResponseWriter, r *http.Request) {
 				http.Error(w, "Failed to create temp file", http.StatusInternalServerError)
 				return
 			}
-			tempFilePath = tempFile.Name()
+			uploaded.tempPath = tempFile.Name()
+			parts = append(parts, uploaded)
 
 			// Stream to temp file while computing hash
 			hasher := sha256.New()
@@ -459,16 +462,15 @@ func (h *FileHandler) Upload(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
-			hash = hex.EncodeToString(hasher.Sum(nil))
-			actualSize = written
+			last := &parts[len(parts)-1]
+			last.hash = hex.EncodeToString(hasher.Sum(nil))
+			last.size = written
 
-			hashPreview := hash
-			if len(hash) > 16 {
-				hashPreview = hash[:16] + "..."
+			hashPreview := last.hash
+			if len(last.hash) > 16 {
+				hashPreview = last.hash[:16] + "..."
 			}
-			log.Printf("Upload: temp file complete, size=%d bytes, hash=%s", actualSize, hashPreview)
-
-			fileProcessed = true
+			log.Printf("Upload: temp file complete, size=%d bytes, hash=%s", last.size, hashPreview)
 			continue
 
 		default:
@@ -478,101 +480,273 @@ func (h *FileHandler) Upload(w http.ResponseWriter, r *http.Request) {
 		_ = part.Close()
 	}
 
-	if !fileProcessed {
+	if len(parts) == 0 {
 		http.Error(w, "No file provided", http.StatusBadRequest)
 		return
 	}
 
-	// Check storage quota before proceeding
-	if user.StorageUsed+actualSize > user.StorageQuota {
+	// Decide what to do about files of the same name in their folders
+	policy, err := parseConflictPolicy(r, conflictRaw)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Check storage quota before storing anything: the batch is refused as a
+	// whole unless all of it fits, apart from the files that will be skipped
+	var batchSize int64
+	for i := range parts {
+		part := &parts[i]
+		part.folder = relativeUploadFolder(folderPath, part.relativePath)
+		if planUpload(h.db, user.ID, part.folder, part.originalFilename, policy).action != actionSkipped {
+			batchSize += part.size
+		}
+	}
+	if quotaExceeded(h.db, user, batchSize) {
 		http.Error(w, "Storage quota exceeded", http.StatusInsufficientStorage)
 		return
 	}
 
-	// Calculate unique display filename for this folder
-	displayFilename := h.getUniqueFilename(user.ID, folderPath, originalFilename)
+	// Parse comma-separated tags
+	var parsedTags []string
+	for _, t := range strings.Split(tagsRaw, ",") {
+		if s := strings.TrimSpace(t); s != "" {
+			parsedTags = append(parsedTags, s)
+		}
+	}
+
+	// Files are planned one after another, so a file renamed to keep both
+	// sees the names taken by the files of the batch before it
+	results := make([]uploadResult, 0, len(parts))
+	var failed []string
+	for i := range parts {
+		result, msg := h.storeUploadedPart(r.Context(), user.ID, &parts[i], policy, parsedTags)
+		if msg != "" {
+			result = uploadResult{action: actionFailed, err: msg}
+			failed = append(failed, parts[i].displayName())
+		}
+		results = append(results, result)
+	}
+
+	if acceptsJSON(r) {
+		writeBatchResults(w, parts, results, len(failed) == len(results))
+		return
+	}
+	if len(failed) == len(results) {
+		http.Error(w, results[0].err, http.StatusInternalServerError)
+		return
+	}
+
+	if len(results) > 1 {
+		skipped := 0
+		for _, result := range results {
+			if result.action == actionSkipped {
+				skipped++
+			}
+		}
+		if len(failed) > 0 {
+			flash.Error(w, fmt.Sprintf("%d of %d files failed to upload: %s", len(failed), len(results), strings.Join(failed, ", ")))
+		} else if skipped > 0 {
+			flash.Success(w, fmt.Sprintf("%d files uploaded, %d skipped as they already exist", len(results)-skipped, skipped))
+		} else {
+			flash.Success(w, fmt.Sprintf("%d files uploaded successfully.", len(results)))
+		}
+		http.Redirect(w, r, folderRedirectURL(folderPath), http.StatusSeeOther)
+		return
+	}
+
+	// Success message
+	result := results[0]
+	w.Header().Set(UploadActionHeader, result.action)
+	switch {
+	case result.action == actionSkipped:
+		flash.Success(w, fmt.Sprintf("File \"%s\" already exists, upload skipped", parts[0].originalFilename))
+	case result.action == actionUnchanged:
+		flash.Success(w, fmt.Sprintf("File \"%s\" is unchanged", result.file.Filename))
+	case result.action == actionVersioned:
+		flash.Success(w, fmt.Sprintf("File \"%s\" updated to version %d", result.file.Filename, result.file.Version))
+	case result.action == actionReplaced:
+		flash.Success(w, fmt.Sprintf("File \"%s\" uploaded, replacing the existing file", result.file.Filename))
+	case result.deduplicated:
+		flash.Success(w, fmt.Sprintf("File \"%s\" uploaded (deduplicated)", result.file.Filename))
+	case result.file.Filename != parts[0].originalFilename:
+		flash.Success(w, fmt.Sprintf("File uploaded as \"%s\"", result.file.Filename))
+	default:
+		flash.Success(w, "File uploaded successfully.")
+	}
+
+	http.Redirect(w, r, folderRedirectURL(folderPath), http.StatusSeeOther)
+}
+
+// uploadedPart is a file of a multipart upload, received in a temp file.
+type uploadedPart struct {
+	originalFilename string
+	relativePath     string // Path within a folder being uploaded, from the relative_path field before the file
+	folder           string // Folder the file is stored in
+	mimeType         string
+	hash             string
+	size             int64
+	tempPath         string // Cleared once a worker has taken over the temp file
+}
+
+// displayName names the file in messages: by its path within the folder
+// being uploaded, if it has one.
+func (p *uploadedPart) displayName() string {
+	if p.relativePath != "" {
+		return p.relativePath
+	}
+	return p.originalFilename
+}
+
+// uploadResult is what storing an uploadedPart did.
+type uploadResult struct {
+	action       string
+	file         *models.File // The stored file, or the existing one a skipped upload left alone; nil if it failed
+	deduplicated bool
+	err          string // Why it failed, for the user
+}
 
-	// Check if we already have a completed file with this hash (fast deduplication)
-	var existingFile models.File
-	existingErr := h.db.Where("user_id = ? AND hash = ? AND upload_status = ?", user.ID, hash, "completed").
-		First(&existingFile).Error
+// acceptsJSON reports whether the client asked for a JSON response.
+func acceptsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// writeBatchResults responds with what a multipart upload did to each of
+// its files, in the order they were sent: the action, and the file it
+// stored or was skipped for, or the error. The status is 500 only if every
+// file failed.
+func writeBatchResults(w http.ResponseWriter, parts []uploadedPart, results []uploadResult, allFailed bool) {
+	files := make([]map[string]interface{}, 0, len(results))
+	for i, result := range results {
+		entry := map[string]interface{}{
+			"filename":      parts[i].originalFilename,
+			"relative_path": parts[i].relativePath,
+			"action":        result.action,
+		}
+		if result.file != nil {
+			entry["file_id"] = result.file.ID
+			entry["filename"] = result.file.Filename
+		}
+		if result.err != "" {
+			entry["error"] = result.err
+		}
+		files = append(files, entry)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if len(results) == 1 {
+		w.Header().Set(UploadActionHeader, results[0].action)
+	}
+	if allFailed {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"files": files})
+}
+
+// storeUploadedPart stores one file of a multipart upload under policy.
+// New content is left in its temp file for the upload workers, so the file
+// is recorded as pending. On failure a non-empty message for the user is
+// returned and the cause logged.
+func (h *FileHandler) storeUploadedPart(ctx context.Context, userID uint, part *uploadedPart, policy conflictPolicy, tags []string) (uploadResult, string) {
+	plan := planUpload(h.db, userID, part.folder, part.originalFilename, policy)
+	if plan.action == actionSkipped {
+		return uploadResult{action: actionSkipped, file: plan.existing}, ""
+	}
+
+	// With versioning enabled, an existing file of the same name gets a new version
+	if plan.action == actionVersioned {
+		action, msg := h.uploadVersion(ctx, plan.existing, part.tempPath, fileContent{FileSize: part.size, MimeType: part.mimeType, Hash: part.hash})
+		return uploadResult{action: action, file: plan.existing}, msg
+	}
+
+	// Check if the user already stored this content under the folder's
+	// storage policy (fast deduplication). This takes a reference on the
+	// object for the new file row. Content only other users stored is shared
+	// by the upload worker instead, so the upload looks the same as any
+	// other to the user.
+	existingPath, err := database.AcquireBlob(h.db, part.hash, database.BlobScope{
+		UserID: userID,
+		Policy: storagePolicy(h.db, h.storage, userID, part.folder),
+	})
+	if err != nil {
+		log.Printf("Warning: deduplication lookup failed: %v", err)
+	}
 
 	var storagePath string
 	var uploadStatus string
 	var tempPathForDB string
 	var isDuplicate bool
 
-	if existingErr == nil {
-		// Immediate deduplication - reuse existing storage path
-		storagePath = existingFile.StoragePath
+	if existingPath != "" {
+		// Immediate deduplication - reuse existing storage path; the temp
+		// file is deleted with the others
+		storagePath = existingPath
 		uploadStatus = "completed"
 		isDuplicate = true
-		log.Printf("Deduplication: hash %s exists, reusing %s (no upload needed)", hash[:16], storagePath)
-		// Can delete temp file immediately
-		defer func() { _ = os.Remove(tempFilePath) }()
+		log.Printf("Deduplication: hash %s exists, reusing %s (no upload needed)", part.hash[:16], storagePath)
 	} else {
 		// New file - will be uploaded in background
 		// Use a placeholder path that will be updated by the worker
-		storagePath = fmt.Sprintf("pending-%s%s", uuid.New().String(), filepath.Ext(originalFilename))
+		storagePath = fmt.Sprintf("pending-%s%s", uuid.New().String(), filepath.Ext(part.originalFilename))
 		uploadStatus = "pending"
-		tempPathForDB = tempFilePath
+		tempPathForDB = part.tempPath
 		isDuplicate = false
 		log.Printf("Upload: queued for background processing")
-		// DON'T delete temp file yet - worker will do it
-		tempFilePath = "" // Clear so defer doesn't delete it
-	}
-
-	// Parse comma-separated tags
-	var parsedTags []string
-	for _, t := range strings.Split(tagsRaw, ",") {
-		if s := strings.TrimSpace(t); s != "" {
-			parsedTags = append(parsedTags, s)
-		}
 	}
 
 	// Create database record immediately
 	fileRecord := models.File{
-		UserID:           user.ID,
+		UserID:           userID,
 		StoragePath:      storagePath,
-		LogicalPath:      folderPath,
-		Filename:         displayFilename,
-		OriginalFilename: originalFilename,
-		FileSize:         actualSize,
-		MimeType:         mimeType,
-		Hash:             hash,
+		LogicalPath:      part.folder,
+		Filename:         plan.filename,
+		OriginalFilename: part.originalFilename,
+		FileSize:         part.size,
+		MimeType:         part.mimeType,
+		Hash:             part.hash,
 		UploadStatus:     uploadStatus,
 		TempPath:         tempPathForDB,
-		Tags:             datatypes.NewJSONType(parsedTags),
-	}
-
-	if err := h.db.Create(&fileRecord).Error; err != nil {
-		http.Error(w, "Failed to save file metadata", http.StatusInternalServerError)
-		return
+		Tags:             datatypes.NewJSONType(tags),
 	}
 
-	// Handle video transcoding for the new record:
-	//  - Duplicates reuse any variant the existing file already has.
-	//  - Otherwise enqueue a transcode job for the background worker.
-	if isDuplicate && existingFile.VideoVariantPath != "" && existingFile.TranscodeStatus == transcode.StatusCompleted {
-		if err := h.db.Model(&fileRecord).Updates(map[string]interface{}{
-			"video_variant_path": existingFile.VideoVariantPath,
-			"video_variant_size": existingFile.VideoVariantSize,
-			"video_variant_mime": existingFile.VideoVariantMime,
-			"transcode_status":   existingFile.TranscodeStatus,
-		}).Error; err != nil {
-			log.Printf("Warning: failed to copy video variant from deduplicated file: %v", err)
+	// The file's folders are created, and a replaced file goes to deleted
+	// items, along with the new file's creation
+	action := plan.action
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := ensureFolders(tx, userID, part.folder); err != nil {
+			return err
 		}
-	} else if h.cfg.TranscodeEnabled && transcode.IsVideoFile(mimeType, originalFilename) {
-		if err := transcode.Enqueue(h.db, fileRecord.ID, user.ID); err != nil {
-			log.Printf("Warning: failed to enqueue transcode job for file %d: %v", fileRecord.ID, err)
-		} else {
-			log.Printf("Upload: queued transcode job for file %d", fileRecord.ID)
+		if err := tx.Create(&fileRecord).Error; err != nil {
+			return err
+		}
+		if action != actionReplaced {
+			return nil
+		}
+		replaced, err := trashNamesakes(tx, &fileRecord)
+		if err == nil && !replaced {
+			action = actionCreated
 		}
+		return err
+	}); err != nil {
+		log.Printf("Failed to save metadata of uploaded file %s: %v", part.originalFilename, err)
+		if isDuplicate {
+			dropBlobReference(ctx, h.db, h.storage, storagePath)
+		}
+		return uploadResult{}, "Failed to save file metadata"
+	}
+	// DON'T delete the temp file of new content - the worker will do it
+	if !isDuplicate {
+		part.tempPath = ""
 	}
 
+	var duplicateOf *models.File
+	if isDuplicate {
+		duplicateOf = variantSource(h.db, storagePath)
+	}
+	startTranscode(h.db, h.cfg, &fileRecord, duplicateOf)
+
 	// Update user storage quota (always count it immediately)
-	if err := h.db.Model(&models.User{}).Where("id = ?", user.ID).
-		UpdateColumn("storage_used", gorm.Expr("storage_used + ?", actualSize)).Error; err != nil {
+	if err := h.db.Model(&models.User{}).Where("id = ?", userID).
+		UpdateColumn("storage_used", gorm.Expr("storage_used + ?", part.size)).Error; err != nil {
 		log.Printf("Warning: failed to update user storage: %v", err)
 	}
 
@@ -592,47 +766,31 @@ func (h *FileHandler) Upload(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Success message
-	if isDuplicate {
-		flash.Success(w, fmt.Sprintf("File \"%s\" uploaded (deduplicated)", displayFilename))
-	} else if displayFilename != originalFilename {
-		flash.Success(w, fmt.Sprintf("File uploaded as \"%s\"", displayFilename))
-	} else {
-		flash.Success(w, "File uploaded successfully.")
-	}
-
-	http.Redirect(w, r, folderRedirectURL(folderPath), http.StatusSeeOther)
+	return uploadResult{action: action, file: &fileRecord, deduplicated: isDuplicate}, ""
 }
 
-// getUniqueFilename checks if a file with the same name exists in the folder and returns a unique name
-func (h *FileHandler) getUniqueFilename(userID uint, logicalPath, originalFilename string) string {
-	// Check if file exists in this folder
-	var count int64
-	h.db.Model(&models.File{}).
-		Where("user_id = ? AND logical_path = ? AND filename = ?", userID, logicalPath, originalFilename).
-		Count(&count)
-
-	if count == 0 {
-		return originalFilename
-	}
-
-	// File exists, find a unique name
-	ext := filepath.Ext(originalFilename)
-	nameWithoutExt := strings.TrimSuffix(originalFilename, ext)
-
-	for i := 1; i <= 10000; i++ {
-		newName := fmt.Sprintf("%s (%d)%s", nameWithoutExt, i, ext)
-		h.db.Model(&models.File{}).
-			Where("user_id = ? AND logical_path = ? AND filename = ?", userID, logicalPath, newName).
-			Count(&count)
-
-		if count == 0 {
-			return newName
+// startTranscode handles video transcoding for a newly created file record:
+//   - Duplicates reuse any variant the existing file already has.
+//   - Otherwise enqueue a transcode job for the background worker.
+//
+// duplicateOf is the completed file whose storage object was reused, or nil.
+func startTranscode(db *gorm.DB, cfg *config.Config, file *models.File, duplicateOf *models.File) {
+	if duplicateOf != nil && duplicateOf.VideoVariantPath != "" && duplicateOf.TranscodeStatus == transcode.StatusCompleted {
+		if err := db.Model(file).Updates(map[string]interface{}{
+			"video_variant_path": duplicateOf.VideoVariantPath,
+			"video_variant_size": duplicateOf.VideoVariantSize,
+			"video_variant_mime": duplicateOf.VideoVariantMime,
+			"transcode_status":   duplicateOf.TranscodeStatus,
+		}).Error; err != nil {
+			log.Printf("Warning: failed to copy video variant from deduplicated file: %v", err)
+		}
+	} else if cfg.TranscodeEnabled && transcode.IsVideoFile(file.MimeType, file.OriginalFilename) {
+		if err := transcode.Enqueue(db, file.ID, file.UserID); err != nil {
+			log.Printf("Warning: failed to enqueue transcode job for file %d: %v", file.ID, err)
+		} else {
+			log.Printf("Upload: queued transcode job for file %d", file.ID)
 		}
 	}
-
-	// Fallback: use UUID suffix if too many collisions
-	return fmt.Sprintf("%s (%s)%s", nameWithoutExt, uuid.New().String()[:8], ext)
 }
 
 func (h *FileHandler) CreateFolder(w http.ResponseWriter, r *http.Request) {
@@ -726,8 +884,28 @@ func (h *FileHandler) Download(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	recordAccess(h.db, &file)
+
+	// Let the client fetch the content straight from storage if it can
+	if redirectToStorage(w, r, h.storage, &file, "attachment") {
+		return
+	}
+
+	// A Range request lets clients resume an interrupted download
+	start, end, ranged := parseRangeHeader(r.Header.Get("Range"), file.FileSize)
+	if ranged && start >= file.FileSize {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", file.FileSize))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
 	// Open file from storage
-	reader, err := h.storage.Open(ctx, file.StoragePath)
+	var reader io.ReadCloser
+	if ranged {
+		reader, err = h.storage.OpenRange(ctx, file.StoragePath, start, end-start+1)
+	} else {
+		reader, err = h.storage.Open(ctx, file.StoragePath)
+	}
 	if err != nil {
 		if errors.Is(err, storage.ErrNotFound) {
 			http.Error(w, "File not found in storage", http.StatusNotFound)
@@ -740,11 +918,15 @@ func (h *FileHandler) Download(w http.ResponseWriter, r *http.Request) {
 
 	// Set headers - use Filename for display name
 	w.Header().Set("Content-Type", file.MimeType)
-	// Escape quotes in filename and add UTF-8 encoded version for non-ASCII support
-	safeFilename := strings.ReplaceAll(file.Filename, `"`, `\"`)
-	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`,
-		safeFilename, url.PathEscape(file.Filename)))
-	w.Header().Set("Content-Length", strconv.FormatInt(file.FileSize, 10))
+	w.Header().Set("Content-Disposition", contentDisposition("attachment", file.Filename))
+	w.Header().Set("Accept-Ranges", "bytes")
+	if ranged {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, file.FileSize))
+		w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+		w.WriteHeader(http.StatusPartialContent)
+	} else {
+		w.Header().Set("Content-Length", strconv.FormatInt(file.FileSize, 10))
+	}
 
 	// Stream file to response
 	if _, err := io.Copy(w, reader); err != nil {
@@ -787,6 +969,8 @@ func (h *FileHandler) Preview(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	recordAccess(h.db, &file)
+
 	// Open file from storage
 	reader, err := h.storage.Open(ctx, file.StoragePath)
 	if err != nil {
@@ -802,9 +986,7 @@ func (h *FileHandler) Preview(w http.ResponseWriter, r *http.Request) {
 	// Set headers for inline display
 	w.Header().Set("Content-Type", file.MimeType)
 	// Use inline disposition to allow browser preview
-	safeFilename := strings.ReplaceAll(file.Filename, `"`, `\"`)
-	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s"; filename*=UTF-8''%s`,
-		safeFilename, url.PathEscape(file.Filename)))
+	w.Header().Set("Content-Disposition", contentDisposition("inline", file.Filename))
 	w.Header().Set("Content-Length", strconv.FormatInt(file.FileSize, 10))
 
 	// Add security headers for preview
@@ -904,6 +1086,10 @@ func (h *FileHandler) ViewFile(w http.ResponseWriter, r *http.Request) {
 	var shareLinks []models.ShareLink
 	h.db.Where("file_id = ? AND user_id = ? AND (expires_at IS NULL OR expires_at > ?)", file.ID, user.ID, time.Now()).Find(&shareLinks)
 
+	// Previous versions, newest first
+	var versions []models.FileVersion
+	h.db.Where("file_id = ?", file.ID).Order("version DESC").Find(&versions)
+
 	// Render template
 	data := map[string]interface{}{
 		"Title":          file.Filename,
@@ -916,10 +1102,12 @@ func (h *FileHandler) ViewFile(w http.ResponseWriter, r *http.Request) {
 		"IsAudio":        isAudio,
 		"IsText":         isText,
 		"IsVideo":        isVideo,
+		"IsArchive":      archiveFormat(file.Filename) != "",
 		"VideoReady":     isVideo && file.TranscodeStatus == transcode.StatusCompleted && file.VideoVariantPath != "",
 		"TranscodeState": file.TranscodeStatus,
 		"FullWidth":      true,
 		"ShareLinks":     shareLinks,
+		"Versions":       versions,
 	}
 
 	if err := render(w, "file_view.html", data); err != nil {
@@ -978,12 +1166,7 @@ func (h *FileHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Soft delete - keep file at original location, just mark as trashed
-	now := time.Now()
-	if err := h.db.Model(&file).Updates(map[string]interface{}{
-		"trashed_at":            now,
-		"original_logical_path": file.LogicalPath,
-	}).Error; err != nil {
+	if err := trashFile(h.db, &file, time.Now()); err != nil {
 		http.Error(w, "Failed to delete file", http.StatusInternalServerError)
 		return
 	}
@@ -1027,50 +1210,8 @@ func (h *FileHandler) DeleteFolder(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Soft delete folder and all its contents
-	now := time.Now()
 	err := h.db.Transaction(func(tx *gorm.DB) error {
-		// Soft delete the folder itself
-		if err := tx.Model(&existingFolder).Updates(map[string]interface{}{
-			"trashed_at":           now,
-			"original_folder_path": fullFolderPath,
-		}).Error; err != nil {
-			return err
-		}
-
-		// Soft delete all files in this folder
-		if err := tx.Model(&models.File{}).
-			Where("user_id = ? AND logical_path = ? AND trashed_at IS NULL", user.ID, fullFolderPath).
-			Updates(map[string]interface{}{
-				"trashed_at":            now,
-				"original_logical_path": gorm.Expr("logical_path"),
-			}).Error; err != nil {
-			return err
-		}
-
-		// Soft delete all subfolders
-		escapedFullFolderPath := escapeSQLLike(fullFolderPath)
-		if err := tx.Model(&models.Folder{}).
-			Where("user_id = ? AND folder_path LIKE ? ESCAPE '\\' AND folder_path != ? AND trashed_at IS NULL",
-				user.ID, escapedFullFolderPath+"/%", fullFolderPath).
-			Updates(map[string]interface{}{
-				"trashed_at":           now,
-				"original_folder_path": gorm.Expr("folder_path"),
-			}).Error; err != nil {
-			return err
-		}
-
-		// Soft delete all files in subfolders
-		if err := tx.Model(&models.File{}).
-			Where("user_id = ? AND logical_path LIKE ? ESCAPE '\\' AND trashed_at IS NULL",
-				user.ID, escapedFullFolderPath+"/%").
-			Updates(map[string]interface{}{
-				"trashed_at":            now,
-				"original_logical_path": gorm.Expr("logical_path"),
-			}).Error; err != nil {
-			return err
-		}
-
-		return nil
+		return trashFolder(tx, user.ID, fullFolderPath, time.Now())
 	})
 
 	if err != nil {
@@ -1084,6 +1225,60 @@ func (h *FileHandler) DeleteFolder(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, folderRedirectURL(currentFolder), http.StatusSeeOther)
 }
 
+// trashFile soft-deletes file: it stays at its original location and is only
+// marked as trashed, remembering the folder to restore it to.
+func trashFile(tx *gorm.DB, file *models.File, now time.Time) error {
+	return tx.Model(file).Updates(map[string]interface{}{
+		"trashed_at":            now,
+		"original_logical_path": file.LogicalPath,
+	}).Error
+}
+
+// trashFolder soft-deletes folderPath, its subfolders and every file beneath it.
+// Implicit folders (no Folder row) are handled too; only their files are marked.
+func trashFolder(tx *gorm.DB, userID uint, folderPath string, now time.Time) error {
+	// Soft delete the folder itself
+	if err := tx.Model(&models.Folder{}).
+		Where("user_id = ? AND folder_path = ? AND trashed_at IS NULL", userID, folderPath).
+		Updates(map[string]interface{}{
+			"trashed_at":           now,
+			"original_folder_path": folderPath,
+		}).Error; err != nil {
+		return err
+	}
+
+	// Soft delete all files in this folder
+	if err := tx.Model(&models.File{}).
+		Where("user_id = ? AND logical_path = ? AND trashed_at IS NULL", userID, folderPath).
+		Updates(map[string]interface{}{
+			"trashed_at":            now,
+			"original_logical_path": gorm.Expr("logical_path"),
+		}).Error; err != nil {
+		return err
+	}
+
+	// Soft delete all subfolders
+	escapedFolderPath := escapeSQLLike(folderPath)
+	if err := tx.Model(&models.Folder{}).
+		Where("user_id = ? AND folder_path LIKE ? ESCAPE '\\' AND folder_path != ? AND trashed_at IS NULL",
+			userID, escapedFolderPath+"/%", folderPath).
+		Updates(map[string]interface{}{
+			"trashed_at":           now,
+			"original_folder_path": gorm.Expr("folder_path"),
+		}).Error; err != nil {
+		return err
+	}
+
+	// Soft delete all files in subfolders
+	return tx.Model(&models.File{}).
+		Where("user_id = ? AND logical_path LIKE ? ESCAPE '\\' AND trashed_at IS NULL",
+			userID, escapedFolderPath+"/%").
+		Updates(map[string]interface{}{
+			"trashed_at":            now,
+			"original_logical_path": gorm.Expr("logical_path"),
+		}).Error
+}
+
 // FileStatusEvent represents a file status update for SSE
 type FileStatusEvent struct {
 	ID              uint   `json:"id"`
@@ -1095,7 +1290,8 @@ type FileStatusEvent struct {
 
 // StatusStream provides Server-Sent Events for file upload status updates.
 // Clients connect to this endpoint to receive real-time updates when files
-// transition between pending, uploading, completed, and failed states.
+// transition between pending, uploading, completed, and failed states, and
+// "extract" events as archive extractions progress.
 func (h *FileHandler) StatusStream(w http.ResponseWriter, r *http.Request) {
 	user := auth.GetUser(r)
 	if user == nil {
@@ -1137,6 +1333,7 @@ func (h *FileHandler) StatusStream(w http.ResponseWriter, r *http.Request) {
 
 	// Track last known state to detect changes
 	lastState := make(map[uint]string)
+	lastExtract := make(map[uint]string)
 
 	// Adaptive polling: start with 1s, back off to 5s if no activity detected
 	pollInterval := 1 * time.Second
@@ -1263,8 +1460,15 @@ func (h *FileHandler) StatusStream(w http.ResponseWriter, r *http.Request) {
 			// Update last known state
 			lastState = currentState
 
+			// Report progress of archive extractions
+			activeExtractions, err := h.sendExtractEvents(w, user.ID, fiveSecondsAgo, lastExtract)
+			if err != nil {
+				log.Printf("SSE: failed to send extraction progress: %v", err)
+			}
+			flusher.Flush()
+
 			// Adaptive polling: back off when idle, speed up when active
-			if len(currentState) == 0 && !hasChanges {
+			if len(currentState) == 0 && !hasChanges && activeExtractions == 0 {
 				idleCount++
 				if idleCount > idleThreshold && pollInterval != maxPollInterval {
 					pollInterval = maxPollInterval
@@ -1511,36 +1715,7 @@ func (h *FileHandler) RenameFolder(w http.ResponseWriter, r *http.Request) {
 
 	// Perform rename within a transaction
 	err := h.db.Transaction(func(tx *gorm.DB) error {
-		// Update the folder record itself
-		if err := tx.Model(&models.Folder{}).
-			Where("user_id = ? AND folder_path = ?", user.ID, oldPath).
-			Update("folder_path", newPath).Error; err != nil {
-			return err
-		}
-
-		// Update all subfolders (replace prefix)
-		escapedOldPath := escapeSQLLike(oldPath)
-		if err := tx.Model(&models.Folder{}).
-			Where("user_id = ? AND folder_path LIKE ? ESCAPE '\\'", user.ID, escapedOldPath+"/%").
-			Update("folder_path", gorm.Expr("REPLACE(folder_path, ?, ?)", oldPath+"/", newPath+"/")).Error; err != nil {
-			return err
-		}
-
-		// Update all files in the folder (exact match)
-		if err := tx.Model(&models.File{}).
-			Where("user_id = ? AND logical_path = ?", user.ID, oldPath).
-			Update("logical_path", newPath).Error; err != nil {
-			return err
-		}
-
-		// Update all files in subfolders (replace prefix)
-		if err := tx.Model(&models.File{}).
-			Where("user_id = ? AND logical_path LIKE ? ESCAPE '\\'", user.ID, escapedOldPath+"/%").
-			Update("logical_path", gorm.Expr("REPLACE(logical_path, ?, ?)", oldPath+"/", newPath+"/")).Error; err != nil {
-			return err
-		}
-
-		return nil
+		return relocateFolder(tx, user.ID, oldPath, newPath)
 	})
 
 	if err != nil {
@@ -1658,36 +1833,7 @@ func (h *FileHandler) MoveFolder(w http.ResponseWriter, r *http.Request) {
 
 	// Perform move within a transaction
 	err := h.db.Transaction(func(tx *gorm.DB) error {
-		// Update the folder record itself
-		if err := tx.Model(&models.Folder{}).
-			Where("user_id = ? AND folder_path = ?", user.ID, sourcePath).
-			Update("folder_path", newPath).Error; err != nil {
-			return err
-		}
-
-		// Update all subfolders (replace prefix)
-		escapedSourcePath := escapeSQLLike(sourcePath)
-		if err := tx.Model(&models.Folder{}).
-			Where("user_id = ? AND folder_path LIKE ? ESCAPE '\\'", user.ID, escapedSourcePath+"/%").
-			Update("folder_path", gorm.Expr("REPLACE(folder_path, ?, ?)", sourcePath+"/", newPath+"/")).Error; err != nil {
-			return err
-		}
-
-		// Update all files in the folder (exact match)
-		if err := tx.Model(&models.File{}).
-			Where("user_id = ? AND logical_path = ?", user.ID, sourcePath).
-			Update("logical_path", newPath).Error; err != nil {
-			return err
-		}
-
-		// Update all files in subfolders (replace prefix)
-		if err := tx.Model(&models.File{}).
-			Where("user_id = ? AND logical_path LIKE ? ESCAPE '\\'", user.ID, escapedSourcePath+"/%").
-			Update("logical_path", gorm.Expr("REPLACE(logical_path, ?, ?)", sourcePath+"/", newPath+"/")).Error; err != nil {
-			return err
-		}
-
-		return nil
+		return relocateFolder(tx, user.ID, sourcePath, newPath)
 	})
 
 	if err != nil {
@@ -1702,6 +1848,37 @@ func (h *FileHandler) MoveFolder(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, folderRedirectURL(destinationFolder), http.StatusSeeOther)
 }
 
+// relocateFolder changes a folder's path from oldPath to newPath, rewriting the
+// paths of all subfolders and files beneath it. Callers validate collisions.
+func relocateFolder(tx *gorm.DB, userID uint, oldPath, newPath string) error {
+	// Update the folder record itself
+	if err := tx.Model(&models.Folder{}).
+		Where("user_id = ? AND folder_path = ?", userID, oldPath).
+		Update("folder_path", newPath).Error; err != nil {
+		return err
+	}
+
+	// Update all subfolders (replace prefix)
+	escapedOldPath := escapeSQLLike(oldPath)
+	if err := tx.Model(&models.Folder{}).
+		Where("user_id = ? AND folder_path LIKE ? ESCAPE '\\'", userID, escapedOldPath+"/%").
+		Update("folder_path", gorm.Expr("REPLACE(folder_path, ?, ?)", oldPath+"/", newPath+"/")).Error; err != nil {
+		return err
+	}
+
+	// Update all files in the folder (exact match)
+	if err := tx.Model(&models.File{}).
+		Where("user_id = ? AND logical_path = ?", userID, oldPath).
+		Update("logical_path", newPath).Error; err != nil {
+		return err
+	}
+
+	// Update all files in subfolders (replace prefix)
+	return tx.Model(&models.File{}).
+		Where("user_id = ? AND logical_path LIKE ? ESCAPE '\\'", userID, escapedOldPath+"/%").
+		Update("logical_path", gorm.Expr("REPLACE(logical_path, ?, ?)", oldPath+"/", newPath+"/")).Error
+}
+
 // DismissFailedUpload removes a failed upload and restores the user's quota.
 // This allows users to acknowledge and dismiss failed uploads from the UI.
 func (h *FileHandler) DismissFailedUpload(w http.ResponseWriter, r *http.Request) {
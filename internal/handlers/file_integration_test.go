@@ -3,6 +3,7 @@ package handlers
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"mime/multipart"
@@ -44,15 +45,16 @@ func newFileTestApp(t *testing.T) *fileTestApp {
 		t.Fatalf("Failed to open test database: %v", err)
 	}
 
-	err = db.AutoMigrate(&models.User{}, &models.File{}, &models.Folder{}, &models.TranscodeJob{})
+	err = db.AutoMigrate(&models.User{}, &models.File{}, &models.FileVersion{}, &models.Blob{}, &models.Folder{}, &models.TranscodeJob{}, &models.ExtractJob{}, &models.UploadSession{})
 	if err != nil {
 		t.Fatalf("Failed to migrate database: %v", err)
 	}
 
 	cfg := &config.Config{
-		MaxUploadSize:    10 * 1024 * 1024,  // 10MB
-		DefaultUserQuota: 100 * 1024 * 1024, // 100MB
-		Env:              "test",
+		MaxUploadSize:           10 * 1024 * 1024,  // 10MB
+		DefaultUserQuota:        100 * 1024 * 1024, // 100MB
+		EnableFileDeduplication: true,
+		Env:                     "test",
 	}
 
 	memStorage := storage.NewMemoryBackend()
@@ -353,6 +355,135 @@ func TestUploadDeduplication(t *testing.T) {
 	}
 }
 
+// TestUploadDeduplicationAcrossUsers verifies that identical content uploaded
+// by different users is stored once and charged to each user
+func TestUploadDeduplicationAcrossUsers(t *testing.T) {
+	app := newFileTestApp(t)
+
+	alice := app.createTestUser(t, "dedupalice")
+	bob := app.createTestUser(t, "dedupbob")
+	content := "Content shared by two users"
+
+	upload := func(user *models.User) {
+		req, ct := createMultipartRequest(t, "shared.txt", content, "/")
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserContextKey, user))
+		req.Header.Set("Content-Type", ct)
+		req = csrf.UnsafeSkipCheck(req)
+		w := httptest.NewRecorder()
+		app.fileHandler.Upload(w, req)
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("Upload for %s failed: %d", user.Username, w.Code)
+		}
+		app.fileHandler.WaitForPendingUploads()
+	}
+	upload(alice)
+	upload(bob)
+
+	var aliceFile, bobFile models.File
+	app.db.Where("user_id = ?", alice.ID).First(&aliceFile)
+	app.db.Where("user_id = ?", bob.ID).First(&bobFile)
+	if aliceFile.UploadStatus != "completed" || bobFile.UploadStatus != "completed" {
+		t.Fatalf("Expected both uploads completed, got %q and %q", aliceFile.UploadStatus, bobFile.UploadStatus)
+	}
+	if aliceFile.StoragePath != bobFile.StoragePath {
+		t.Errorf("Expected shared storage path, got %q and %q", aliceFile.StoragePath, bobFile.StoragePath)
+	}
+	if app.storage.FileCount() != 1 {
+		t.Errorf("Expected 1 stored object, got %d", app.storage.FileCount())
+	}
+
+	var blob models.Blob
+	if err := app.db.Where("hash = ?", aliceFile.Hash).First(&blob).Error; err != nil {
+		t.Fatalf("Expected blob for shared content: %v", err)
+	}
+	if blob.RefCount != 2 {
+		t.Errorf("Expected ref count 2, got %d", blob.RefCount)
+	}
+
+	// Quota is still charged to each user
+	for _, user := range []*models.User{alice, bob} {
+		var u models.User
+		app.db.First(&u, user.ID)
+		if u.StorageUsed != int64(len(content)) {
+			t.Errorf("Expected %s to be charged %d bytes, got %d", user.Username, len(content), u.StorageUsed)
+		}
+	}
+}
+
+// uploadFlash uploads content as filename for user and returns the flash
+// message set by the upload.
+func (app *fileTestApp) uploadFlash(t *testing.T, user *models.User, filename, content string) string {
+	t.Helper()
+	req, ct := createMultipartRequest(t, filename, content, "/")
+	req = req.WithContext(context.WithValue(req.Context(), auth.UserContextKey, user))
+	req.Header.Set("Content-Type", ct)
+	req = csrf.UnsafeSkipCheck(req)
+	w := httptest.NewRecorder()
+	app.fileHandler.Upload(w, req)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("Upload for %s failed: %d", user.Username, w.Code)
+	}
+	app.fileHandler.WaitForPendingUploads()
+	return flashMessage(w)
+}
+
+// flashMessage returns the flash message a response set, or "".
+func flashMessage(w *httptest.ResponseRecorder) string {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "flash_message" {
+			msg, _ := base64.StdEncoding.DecodeString(c.Value)
+			return string(msg)
+		}
+	}
+	return ""
+}
+
+// TestUploadDeduplicationAcrossUsers_NotReported verifies that an upload
+// sharing another user's object is not reported as deduplicated, while one
+// matching the user's own content is
+func TestUploadDeduplicationAcrossUsers_NotReported(t *testing.T) {
+	app := newFileTestApp(t)
+
+	alice := app.createTestUser(t, "reportalice")
+	bob := app.createTestUser(t, "reportbob")
+	content := "Content alice stored first"
+
+	app.uploadFlash(t, alice, "first.txt", content)
+	if msg := app.uploadFlash(t, bob, "mine.txt", content); strings.Contains(msg, "deduplicated") {
+		t.Errorf("Upload of another user's content reported %q", msg)
+	}
+	if msg := app.uploadFlash(t, alice, "again.txt", content); !strings.Contains(msg, "deduplicated") {
+		t.Errorf("Upload of the user's own content reported %q, want it deduplicated", msg)
+	}
+	if app.storage.FileCount() != 1 {
+		t.Errorf("Expected 1 stored object, got %d", app.storage.FileCount())
+	}
+}
+
+// TestUploadDeduplicationDisabled verifies that with ENABLE_FILE_DEDUPLICATION
+// off users only share objects with their own files
+func TestUploadDeduplicationDisabled(t *testing.T) {
+	app := newFileTestApp(t)
+	app.cfg.EnableFileDeduplication = false
+
+	alice := app.createTestUser(t, "nodedupalice")
+	bob := app.createTestUser(t, "nodedupbob")
+	content := "Content each user keeps to themselves"
+
+	app.uploadFlash(t, alice, "a.txt", content)
+	app.uploadFlash(t, bob, "b.txt", content)
+	app.uploadFlash(t, bob, "c.txt", content)
+
+	var paths []string
+	app.db.Model(&models.File{}).Where("user_id IN ?", []uint{alice.ID, bob.ID}).Order("filename").Pluck("storage_path", &paths)
+	if len(paths) != 3 || paths[0] == paths[1] || paths[1] != paths[2] {
+		t.Errorf("storage paths = %v, want bob's two files sharing an object apart from alice's", paths)
+	}
+	if app.storage.FileCount() != 2 {
+		t.Errorf("Expected 2 stored objects, got %d", app.storage.FileCount())
+	}
+}
+
 // TestDownloadIntegration tests file download functionality
 func TestDownloadIntegration(t *testing.T) {
 	app := newFileTestApp(t)
@@ -393,6 +524,30 @@ func TestDownloadIntegration(t *testing.T) {
 		}
 	})
 
+	t.Run("redirects to storage that presigns downloads", func(t *testing.T) {
+		app.fileHandler.storage = presigningStorage{app.storage}
+		defer func() { app.fileHandler.storage = app.storage }()
+
+		req := app.authenticatedRequest(t, http.MethodGet, fmt.Sprintf("/download/%d", file.ID), nil, user)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", fmt.Sprintf("%d", file.ID))
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+		w := httptest.NewRecorder()
+		app.fileHandler.Download(w, req)
+
+		if w.Code != http.StatusFound {
+			t.Fatalf("Expected status 302, got %d", w.Code)
+		}
+		location, _ := url.Parse(w.Header().Get("Location"))
+		if location.Path != "/"+file.StoragePath || !strings.Contains(location.Query().Get("disposition"), "download.txt") {
+			t.Errorf("Unexpected redirect to %q", w.Header().Get("Location"))
+		}
+		if w.Header().Get("Cache-Control") != "no-store" {
+			t.Errorf("Expected the redirect not to be cached")
+		}
+	})
+
 	t.Run("download non-existent file", func(t *testing.T) {
 		req := app.authenticatedRequest(t, http.MethodGet, "/download/99999", nil, user)
 
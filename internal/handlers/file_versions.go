@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/agjmills/trove/internal/auth"
+	"github.com/agjmills/trove/internal/database"
+	"github.com/agjmills/trove/internal/database/models"
+	"github.com/agjmills/trove/internal/flash"
+	"github.com/agjmills/trove/internal/storage"
+)
+
+// fileVersion loads the file named by the {id} URL parameter, owned by the
+// current user, and its previous version named by {version}.
+func (h *FileHandler) fileVersion(r *http.Request, userID uint) (*models.File, *models.FileVersion, bool) {
+	number, err := strconv.Atoi(chi.URLParam(r, "version"))
+	if err != nil {
+		return nil, nil, false
+	}
+	var file models.File
+	if err := h.db.Where("id = ? AND user_id = ?", chi.URLParam(r, "id"), userID).First(&file).Error; err != nil {
+		return nil, nil, false
+	}
+	var version models.FileVersion
+	if err := h.db.Where("file_id = ? AND version = ?", file.ID, number).First(&version).Error; err != nil {
+		return nil, nil, false
+	}
+	return &file, &version, true
+}
+
+// uploadVersion stores an upload received in tempPath as a new version of
+// file. Unlike a new file, the content is saved before responding, so the
+// file never points at content that is still being uploaded. It returns
+// actionVersioned, or actionUnchanged if file already has the content; on
+// failure a non-empty message for the user is returned and the cause logged.
+func (h *FileHandler) uploadVersion(ctx context.Context, file *models.File, tempPath string, content fileContent) (string, string) {
+	if content.Hash == file.Hash {
+		return actionUnchanged, ""
+	}
+
+	tempFile, err := os.Open(tempPath)
+	if err != nil {
+		log.Printf("Failed to open uploaded file: %v", err)
+		return "", "Failed to process upload"
+	}
+	defer tempFile.Close() //nolint:errcheck
+
+	var deduplicated bool
+	content.StoragePath, deduplicated, err = saveBlob(ctx, h.db, h.storage, blobScope(h.cfg, file.UserID), content.Hash, tempFile, storage.SaveOptions{
+		OriginalFilename: file.Filename,
+		ContentType:      content.MimeType,
+		Policy:           storagePolicy(h.db, h.storage, file.UserID, file.LogicalPath),
+	})
+	if err != nil {
+		log.Printf("Failed to store new version of file %d: %v", file.ID, err)
+		return "", "Storage upload failed"
+	}
+	if err := addFileVersion(ctx, h.db, h.storage, h.cfg, file, content, deduplicated); err != nil {
+		log.Printf("Failed to add version of file %d: %v", file.ID, err)
+		dropBlobReference(ctx, h.db, h.storage, content.StoragePath)
+		return "", "Failed to save file metadata"
+	}
+	return actionVersioned, ""
+}
+
+// DownloadVersion downloads a previous version of a file.
+func (h *FileHandler) DownloadVersion(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	file, version, ok := h.fileVersion(r, user.ID)
+	if !ok {
+		http.Error(w, "File version not found", http.StatusNotFound)
+		return
+	}
+
+	// Served like the file itself, under the file's current name
+	content := models.File{
+		StoragePath: version.StoragePath,
+		Filename:    file.Filename,
+		FileSize:    version.FileSize,
+		MimeType:    version.MimeType,
+	}
+	if redirectToStorage(w, r, h.storage, &content, "attachment") {
+		return
+	}
+
+	reader, err := h.storage.Open(r.Context(), content.StoragePath)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, "File not found in storage", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to open file", http.StatusInternalServerError)
+		return
+	}
+	defer reader.Close() //nolint:errcheck
+
+	w.Header().Set("Content-Type", content.MimeType)
+	w.Header().Set("Content-Disposition", contentDisposition("attachment", content.Filename))
+	w.Header().Set("Content-Length", strconv.FormatInt(content.FileSize, 10))
+	if _, err := io.Copy(w, reader); err != nil {
+		log.Printf("Warning: error streaming file version %s: %v", content.StoragePath, err)
+	}
+}
+
+// RestoreVersion makes a previous version of a file its current content
+// again. The restored content becomes a new version, so the content it
+// replaces is kept as well, and is charged to the quota like an upload.
+func (h *FileHandler) RestoreVersion(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	file, version, ok := h.fileVersion(r, user.ID)
+	if !ok {
+		flash.Error(w, "File version not found")
+		http.Redirect(w, r, "/files", http.StatusSeeOther)
+		return
+	}
+	fileURL := fmt.Sprintf("/files/%d", file.ID)
+
+	if file.SoftDeletedAt != nil || file.UploadStatus != "completed" {
+		flash.Error(w, "Only files that are not deleted or uploading can be restored to an earlier version")
+		http.Redirect(w, r, fileURL, http.StatusSeeOther)
+		return
+	}
+	if version.Hash != "" && version.Hash == file.Hash {
+		flash.Success(w, fmt.Sprintf("Version %d is the same as the current version", version.Version))
+		http.Redirect(w, r, fileURL, http.StatusSeeOther)
+		return
+	}
+	if user.StorageUsed+version.FileSize > user.StorageQuota {
+		flash.Error(w, "Storage quota exceeded")
+		http.Redirect(w, r, fileURL, http.StatusSeeOther)
+		return
+	}
+
+	// The file row takes its own reference on the version's object
+	if err := database.AcquireBlobPath(h.db, version.StoragePath); err != nil {
+		log.Printf("Failed to reference file version %d of file %d: %v", version.Version, file.ID, err)
+		flash.Error(w, "Failed to restore version")
+		http.Redirect(w, r, fileURL, http.StatusSeeOther)
+		return
+	}
+	content := fileContent{
+		StoragePath: version.StoragePath,
+		FileSize:    version.FileSize,
+		MimeType:    version.MimeType,
+		Hash:        version.Hash,
+	}
+	if err := addFileVersion(r.Context(), h.db, h.storage, h.cfg, file, content, true); err != nil {
+		log.Printf("Failed to restore version %d of file %d: %v", version.Version, file.ID, err)
+		dropBlobReference(r.Context(), h.db, h.storage, version.StoragePath)
+		flash.Error(w, "Failed to restore version")
+		http.Redirect(w, r, fileURL, http.StatusSeeOther)
+		return
+	}
+
+	flash.Success(w, fmt.Sprintf("Restored version %d as version %d", version.Version, file.Version))
+	http.Redirect(w, r, fileURL, http.StatusSeeOther)
+}
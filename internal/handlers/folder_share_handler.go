@@ -1,7 +1,6 @@
 package handlers
 
 import (
-	"fmt"
 	"io"
 	"net/http"
 	"net/url"
@@ -370,6 +369,11 @@ func (h *FolderShareHandler) DownloadSharedFolderFile(w http.ResponseWriter, r *
 		return
 	}
 
+	recordAccess(h.db, &file)
+	if redirectToStorage(w, r, h.storage, &file, "attachment") {
+		return
+	}
+
 	reader, err := h.storage.Open(r.Context(), file.StoragePath)
 	if err != nil {
 		http.Error(w, "File not found", http.StatusNotFound)
@@ -377,9 +381,7 @@ func (h *FolderShareHandler) DownloadSharedFolderFile(w http.ResponseWriter, r *
 	}
 	defer reader.Close() //nolint:errcheck
 
-	safeFilename := strings.ReplaceAll(file.Filename, `"`, `\"`)
-	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`,
-		safeFilename, url.PathEscape(file.Filename)))
+	w.Header().Set("Content-Disposition", contentDisposition("attachment", file.Filename))
 	w.Header().Set("Content-Type", file.MimeType)
 	if file.FileSize > 0 {
 		w.Header().Set("Content-Length", strconv.FormatInt(file.FileSize, 10))
@@ -389,3 +391,30 @@ func (h *FolderShareHandler) DownloadSharedFolderFile(w http.ResponseWriter, r *
 		logger.Error("error streaming shared folder file", "path", file.StoragePath, "error", err)
 	}
 }
+
+// DownloadSharedFolderZip handles GET /f/{token}/zip — public download of the
+// whole shared folder as a ZIP.
+func (h *FolderShareHandler) DownloadSharedFolderZip(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+	link := h.lookupValidFolderLink(w, token)
+	if link == nil {
+		return
+	}
+
+	if link.PasswordHash != nil && !h.isUnlocked(r, token) {
+		http.Redirect(w, r, "/f/"+token, http.StatusSeeOther)
+		return
+	}
+
+	files, err := h.folderFiles(link)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if len(files) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	streamZip(w, r, h.db, h.storage, files, link.FolderPath, zipName(link.FolderPath))
+}
@@ -26,7 +26,7 @@ func setupFolderShareTest(t *testing.T) (*FolderShareHandler, *gorm.DB, *models.
 	if err != nil {
 		t.Fatalf("open db: %v", err)
 	}
-	if err := db.AutoMigrate(&models.User{}, &models.Folder{}, &models.File{}, &models.FolderShareLink{}); err != nil {
+	if err := db.AutoMigrate(&models.User{}, &models.Folder{}, &models.File{}, &models.FileVersion{}, &models.Blob{}, &models.FolderShareLink{}); err != nil {
 		t.Fatalf("migrate: %v", err)
 	}
 
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"path"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"github.com/agjmills/trove/internal/database/models"
+)
+
+// ensureFolders creates Folder rows for folderPath and any of its parents
+// that do not have one outside deleted items, so that files added beneath
+// them show up in the folder tree.
+func ensureFolders(tx *gorm.DB, userID uint, folderPath string) error {
+	var missing []string
+	for p := folderPath; p != "/" && p != "."; p = path.Dir(p) {
+		var count int64
+		if err := tx.Model(&models.Folder{}).
+			Where("user_id = ? AND folder_path = ? AND trashed_at IS NULL", userID, p).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			// Parents of an existing folder exist too, or are implicit
+			break
+		}
+		missing = append(missing, p)
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := tx.Create(&models.Folder{UserID: userID, FolderPath: missing[i]}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// relativeUploadFolder returns the folder that a file uploaded into folder
+// with relativePath, its path within a folder being uploaded such as
+// "photos/2024/beach.jpg", is stored in. The directories of relativePath
+// are sanitized like any folder path, so they cannot lead out of folder.
+func relativeUploadFolder(folder, relativePath string) string {
+	if relativePath == "" {
+		return folder
+	}
+	dir := path.Dir(strings.ReplaceAll(relativePath, `\`, "/"))
+	return sanitizeFolderPath(path.Join(folder, sanitizeFolderPath(dir)))
+}
@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	csrf "filippo.io/csrf/gorilla"
+
+	"github.com/agjmills/trove/internal/auth"
+	"github.com/agjmills/trove/internal/database/models"
+)
+
+func TestRelativeUploadFolder(t *testing.T) {
+	for _, tc := range []struct{ folder, relativePath, want string }{
+		{"/", "", "/"},
+		{"/docs", "", "/docs"},
+		{"/docs", "a.txt", "/docs"},
+		{"/", "photos/2024/beach.jpg", "/photos/2024"},
+		{"/docs", `photos\2024\beach.jpg`, "/docs/photos/2024"},
+		{"/docs", "./photos//beach.jpg", "/docs/photos"},
+		{"/docs", "../../etc/passwd", "/docs/etc"},
+		{"/docs", "/abs/beach.jpg", "/docs/abs"},
+	} {
+		if got := relativeUploadFolder(tc.folder, tc.relativePath); got != tc.want {
+			t.Errorf("relativeUploadFolder(%q, %q) = %q, want %q", tc.folder, tc.relativePath, got, tc.want)
+		}
+	}
+}
+
+func TestEnsureFolders(t *testing.T) {
+	app := newFileTestApp(t)
+	user := app.createTestUser(t, "ensurefoldersuser")
+	app.db.Create(&models.Folder{UserID: user.ID, FolderPath: "/a"})
+
+	if err := ensureFolders(app.db, user.ID, "/a/b/c"); err != nil {
+		t.Fatalf("ensureFolders: %v", err)
+	}
+	if err := ensureFolders(app.db, user.ID, "/a/b/c"); err != nil {
+		t.Fatalf("ensureFolders again: %v", err)
+	}
+	var folders []models.Folder
+	app.db.Where("user_id = ?", user.ID).Order("folder_path").Find(&folders)
+	if len(folders) != 3 || folders[0].FolderPath != "/a" || folders[1].FolderPath != "/a/b" || folders[2].FolderPath != "/a/b/c" {
+		t.Errorf("folders = %+v, want /a, /a/b and /a/b/c once each", folders)
+	}
+}
+
+// folderUpload posts files, pairs of relative path and content, to /upload
+// in one multipart request.
+func folderUpload(t *testing.T, app *fileTestApp, user *models.User, folder, policy string, files ...[2]string) *httptest.ResponseRecorder {
+	t.Helper()
+	return sendFolderUpload(t, app, newFolderUploadRequest(t, user, folder, policy, files...))
+}
+
+func newFolderUploadRequest(t *testing.T, user *models.User, folder, policy string, files ...[2]string) *http.Request {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	_ = writer.WriteField("folder", folder)
+	_ = writer.WriteField("on_conflict", policy)
+	for _, f := range files {
+		_ = writer.WriteField("relative_path", f[0])
+		part, _ := writer.CreateFormFile("file", f[0])
+		_, _ = part.Write([]byte(f[1]))
+	}
+	_ = writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", &buf)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return csrf.UnsafeSkipCheck(req.WithContext(context.WithValue(req.Context(), auth.UserContextKey, user)))
+}
+
+func sendFolderUpload(t *testing.T, app *fileTestApp, req *http.Request) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	app.fileHandler.Upload(w, req)
+	app.fileHandler.WaitForPendingUploads()
+	return w
+}
+
+func TestUpload_FolderStructure(t *testing.T) {
+	app := newFileTestApp(t)
+	user := app.createTestUser(t, "folderuploaduser")
+
+	w := folderUpload(t, app, user, "/dest", "",
+		[2]string{"photos/2024/beach.jpg", "beach"},
+		[2]string{"photos/notes.txt", "notes"},
+		[2]string{"photos/../../escape.txt", "escape"},
+	)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != folderRedirectURL("/dest") {
+		t.Fatalf("Upload = %d to %q, want a redirect to /dest", w.Code, w.Header().Get("Location"))
+	}
+
+	for folder, name := range map[string]string{
+		"/dest/photos/2024": "beach.jpg",
+		"/dest/photos":      "notes.txt",
+		"/dest":             "escape.txt",
+	} {
+		var count int64
+		app.db.Model(&models.File{}).Where("user_id = ? AND logical_path = ? AND filename = ?", user.ID, folder, name).Count(&count)
+		if count != 1 {
+			t.Errorf("%s not stored in %s", name, folder)
+		}
+	}
+	var folders []models.Folder
+	app.db.Where("user_id = ?", user.ID).Order("folder_path").Find(&folders)
+	if len(folders) != 3 || folders[0].FolderPath != "/dest" || folders[1].FolderPath != "/dest/photos" || folders[2].FolderPath != "/dest/photos/2024" {
+		t.Errorf("folders = %+v, want /dest, /dest/photos and /dest/photos/2024", folders)
+	}
+}
+
+func TestUpload_FolderBatchConflicts(t *testing.T) {
+	app := newFileTestApp(t)
+	user := app.createTestUser(t, "folderconflictuser")
+	folderUpload(t, app, user, "/", "", [2]string{"docs/a.txt", "old"})
+
+	// Names taken earlier in the same batch are kept apart too
+	folderUpload(t, app, user, "/", "keep_both",
+		[2]string{"docs/a.txt", "one"},
+		[2]string{"docs/a.txt", "two"},
+	)
+	var names []string
+	app.db.Model(&models.File{}).Where("user_id = ? AND logical_path = ?", user.ID, "/docs").Order("filename").Pluck("filename", &names)
+	if len(names) != 3 || names[0] != "a (1).txt" || names[1] != "a (2).txt" || names[2] != "a.txt" {
+		t.Errorf("files = %v, want a.txt, a (1).txt and a (2).txt", names)
+	}
+
+	w := folderUpload(t, app, user, "/", "skip",
+		[2]string{"docs/a.txt", "three"},
+		[2]string{"docs/b.txt", "four"},
+	)
+	if w.Code != http.StatusSeeOther || w.Header().Get(UploadActionHeader) != "" {
+		t.Fatalf("Upload = %d with %s %q, want a redirect without a single action", w.Code, UploadActionHeader, w.Header().Get(UploadActionHeader))
+	}
+	var count int64
+	app.db.Model(&models.File{}).Where("user_id = ?", user.ID).Count(&count)
+	if count != 4 {
+		t.Errorf("%d files, want b.txt added and a.txt skipped", count)
+	}
+}
+
+func TestUpload_FolderBatchQuota(t *testing.T) {
+	app := newFileTestApp(t)
+	user := app.createTestUser(t, "folderquotauser")
+	user.StorageQuota = 10
+	app.db.Model(user).Update("storage_quota", 10)
+
+	// Each file fits, but not all of them
+	w := folderUpload(t, app, user, "/", "",
+		[2]string{"big/one.txt", "123456"},
+		[2]string{"big/two.txt", "abcdef"},
+	)
+	if w.Code != http.StatusInsufficientStorage {
+		t.Fatalf("Upload = %d, want 507", w.Code)
+	}
+	var files, folders int64
+	app.db.Model(&models.File{}).Where("user_id = ?", user.ID).Count(&files)
+	app.db.Model(&models.Folder{}).Where("user_id = ?", user.ID).Count(&folders)
+	if files != 0 || folders != 0 {
+		t.Errorf("%d files and %d folders stored from a refused batch", files, folders)
+	}
+}
+
+func TestUpload_FolderBatchPartialFailure(t *testing.T) {
+	app := newFileTestApp(t)
+	user := app.createTestUser(t, "folderfailureuser")
+	// Saving the metadata of one file of the batch fails
+	app.db.Exec(fmt.Sprintf(`CREATE TRIGGER fail_broken_upload BEFORE INSERT ON files
+		WHEN NEW.user_id = %d AND NEW.filename = 'broken.txt'
+		BEGIN SELECT RAISE(ABORT, 'injected failure'); END`, user.ID))
+	t.Cleanup(func() { app.db.Exec("DROP TRIGGER fail_broken_upload") })
+
+	files := [][2]string{{"docs/a.txt", "one"}, {"docs/broken.txt", "two"}, {"docs/b.txt", "three"}}
+	req := newFolderUploadRequest(t, user, "/", "", files...)
+	req.Header.Set("Accept", "application/json")
+	w := sendFolderUpload(t, app, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Upload = %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Files []struct {
+			Filename     string `json:"filename"`
+			RelativePath string `json:"relative_path"`
+			Action       string `json:"action"`
+			FileID       uint   `json:"file_id"`
+			Error        string `json:"error"`
+		} `json:"files"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(resp.Files) != 3 {
+		t.Fatalf("got %d results, want one per file: %+v", len(resp.Files), resp.Files)
+	}
+	for i, f := range resp.Files {
+		failed := files[i][0] == "docs/broken.txt"
+		if f.RelativePath != files[i][0] || (f.Action == actionFailed) != failed || (f.Error != "") != failed || (f.FileID != 0) == failed {
+			t.Errorf("result %d = %+v", i, f)
+		}
+	}
+
+	// The files around the failed one are stored
+	var names []string
+	app.db.Model(&models.File{}).Where("user_id = ?", user.ID).Order("filename").Pluck("filename", &names)
+	if len(names) != 2 || names[0] != "a.txt" || names[1] != "b.txt" {
+		t.Errorf("files = %v, want a.txt and b.txt", names)
+	}
+
+	// Without JSON the failure is reported in the flash message
+	w = folderUpload(t, app, user, "/", "keep_both", files...)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("Upload = %d, want a redirect", w.Code)
+	}
+	if msg := flashMessage(w); !strings.Contains(msg, "1 of 3 files failed to upload: docs/broken.txt") {
+		t.Errorf("flash = %q, want the failed file named", msg)
+	}
+}
+
+func TestInitUpload_RelativePath(t *testing.T) {
+	handler, db, user := setupUploadHandlerTest(t)
+
+	body, _ := json.Marshal(InitUploadRequest{
+		Filename:     "beach.jpg",
+		TotalSize:    5,
+		ChunkSize:    5,
+		TotalChunks:  1,
+		LogicalPath:  "/dest",
+		RelativePath: "photos/2024/beach.jpg",
+	})
+	w := sendUploadRequest(t, handler.InitUpload, user, "", "/api/uploads/init", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("InitUpload returned %d: %s", w.Code, w.Body.String())
+	}
+	var init InitUploadResponse
+	_ = json.NewDecoder(w.Body).Decode(&init)
+	if w := sendUploadRequest(t, handler.UploadChunk, user, init.UploadID, "/api/uploads/"+init.UploadID+"/chunk?chunk=0", []byte("beach")); w.Code != http.StatusOK {
+		t.Fatalf("UploadChunk returned %d: %s", w.Code, w.Body.String())
+	}
+	if w := sendUploadRequest(t, handler.CompleteUpload, user, init.UploadID, "/api/uploads/"+init.UploadID+"/complete", nil); w.Code != http.StatusOK {
+		t.Fatalf("CompleteUpload returned %d: %s", w.Code, w.Body.String())
+	}
+
+	var file models.File
+	if err := db.Where("filename = ?", "beach.jpg").First(&file).Error; err != nil || file.LogicalPath != "/dest/photos/2024" {
+		t.Errorf("file in %q (%v), want /dest/photos/2024", file.LogicalPath, err)
+	}
+	var folders int64
+	db.Model(&models.Folder{}).Where("folder_path IN ?", []string{"/dest", "/dest/photos", "/dest/photos/2024"}).Count(&folders)
+	if folders != 3 {
+		t.Errorf("%d folder rows, want 3", folders)
+	}
+}
+
+func TestInitUpload_QuotaCountsUploadsInProgress(t *testing.T) {
+	handler, db, user := setupUploadHandlerTest(t)
+	user.StorageQuota = 100
+	db.Model(user).Update("storage_quota", 100)
+
+	init := func(name string) int {
+		body, _ := json.Marshal(InitUploadRequest{Filename: name, TotalSize: 60, ChunkSize: 60, TotalChunks: 1, RelativePath: "batch/" + name})
+		return sendUploadRequest(t, handler.InitUpload, user, "", "/api/uploads/init", body).Code
+	}
+	if code := init("one.bin"); code != http.StatusOK {
+		t.Fatalf("first init = %d, want 200", code)
+	}
+	if code := init("two.bin"); code != http.StatusForbidden {
+		t.Errorf("second init = %d, want 403 as both together exceed the quota", code)
+	}
+}
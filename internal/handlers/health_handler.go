@@ -8,6 +8,8 @@ import (
 
 	"gorm.io/gorm"
 
+	"github.com/agjmills/trove/internal/config"
+	"github.com/agjmills/trove/internal/diskspace"
 	"github.com/agjmills/trove/internal/storage"
 )
 
@@ -15,14 +17,16 @@ import (
 type HealthHandler struct {
 	db             *gorm.DB
 	storageService storage.StorageBackend
+	volumes        []diskspace.Volume
 	version        string
 }
 
 // NewHealthHandler creates a new health handler
-func NewHealthHandler(db *gorm.DB, storageService storage.StorageBackend, version string) *HealthHandler {
+func NewHealthHandler(db *gorm.DB, cfg *config.Config, storageService storage.StorageBackend, version string) *HealthHandler {
 	return &HealthHandler{
 		db:             db,
 		storageService: storageService,
+		volumes:        diskspace.Volumes(cfg),
 		version:        version,
 	}
 }
@@ -44,7 +48,9 @@ type Check struct {
 
 var startTime = time.Now()
 
-// Health performs comprehensive health checks
+// Health performs comprehensive health checks. A degraded check, such as
+// storage that is low on disk space, leaves the service up (200) but is
+// reported in the overall status.
 func (h *HealthHandler) Health(w http.ResponseWriter, r *http.Request) {
 	checks := make(map[string]Check)
 	overallStatus := "healthy"
@@ -59,7 +65,13 @@ func (h *HealthHandler) Health(w http.ResponseWriter, r *http.Request) {
 	// Storage check
 	storageCheck := h.checkStorage()
 	checks["storage"] = storageCheck
-	if storageCheck.Status != "healthy" {
+	switch storageCheck.Status {
+	case "healthy":
+	case "degraded":
+		if overallStatus == "healthy" {
+			overallStatus = "degraded"
+		}
+	default:
 		overallStatus = "unhealthy"
 	}
 
@@ -72,7 +84,7 @@ func (h *HealthHandler) Health(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 
-	if overallStatus != "healthy" {
+	if overallStatus == "unhealthy" {
 		w.WriteHeader(http.StatusServiceUnavailable)
 	}
 
@@ -108,7 +120,9 @@ func (h *HealthHandler) checkDatabase() Check {
 	}
 }
 
-// checkStorage verifies storage backend is accessible
+// checkStorage verifies storage backend is accessible, reporting it as
+// degraded while a volume uploads are written to is below its minimum free
+// space
 func (h *HealthHandler) checkStorage() Check {
 	start := time.Now()
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
@@ -122,6 +136,16 @@ func (h *HealthHandler) checkStorage() Check {
 		}
 	}
 
+	for _, u := range diskspace.Stat(h.volumes) {
+		if u.Low() {
+			return Check{
+				Status:  "degraded",
+				Message: "low disk space, uploads are refused: " + u.String(),
+				Latency: time.Since(start).String(),
+			}
+		}
+	}
+
 	return Check{
 		Status:  "healthy",
 		Latency: time.Since(start).String(),
@@ -28,7 +28,7 @@ func TestHealthHandler(t *testing.T) {
 	storageService := storage.NewMemoryBackend()
 
 	// Create handler
-	handler := NewHealthHandler(db, storageService, "test-version")
+	handler := NewHealthHandler(db, cfg, storageService, "test-version")
 
 	// Create request
 	req := httptest.NewRequest(http.MethodGet, "/health", nil)
@@ -77,3 +77,36 @@ func TestHealthHandler(t *testing.T) {
 		t.Error("Uptime missing from response")
 	}
 }
+
+func TestHealthHandler_LowDiskSpace(t *testing.T) {
+	cfg := &config.Config{
+		DBType:      "sqlite",
+		DBPath:      ":memory:",
+		Env:         "test",
+		TempDir:     t.TempDir(),
+		MinFreeTemp: 1 << 62,
+	}
+	db, err := database.Connect(cfg)
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+	handler := NewHealthHandler(db, cfg, storage.NewMemoryBackend(), "test-version")
+
+	w := httptest.NewRecorder()
+	handler.Health(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	// Low disk space degrades the service but does not take it down
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+	var response HealthResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.Status != "degraded" {
+		t.Errorf("Expected status 'degraded', got '%s'", response.Status)
+	}
+	if check := response.Checks["storage"]; check.Status != "degraded" || check.Message == "" {
+		t.Errorf("Expected a degraded storage check with a message, got %+v", check)
+	}
+}
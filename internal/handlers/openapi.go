@@ -0,0 +1,367 @@
+package handlers
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/agjmills/trove/internal/auth"
+)
+
+// apiParam describes a query parameter for the OpenAPI document.
+type apiParam struct {
+	Name        string
+	Type        string // OpenAPI primitive type: string, integer, boolean
+	Description string
+}
+
+// apiRoute is the single source of truth for an /api/v1 endpoint: Mount
+// registers it on the router and OpenAPI describes it, so the two cannot drift.
+type apiRoute struct {
+	Method   string
+	Pattern  string
+	Scope    string
+	Summary  string
+	Query    []apiParam
+	Request  any // Zero value of the JSON request body type, or nil
+	Response any // Zero value of the JSON response body type, or nil for no body
+	Status   int
+	Handler  func(h *APIHandler, w http.ResponseWriter, r *http.Request)
+}
+
+var paginationParams = []apiParam{
+	{Name: "page", Type: "integer", Description: "Page number, starting at 1"},
+	{Name: "per_page", Type: "integer", Description: "Items per page (default 50, max 200)"},
+}
+
+var apiRoutes = []apiRoute{
+	{
+		Method: http.MethodGet, Pattern: "/files", Scope: auth.ScopeFilesRead,
+		Summary: "List the contents of a folder",
+		Query: append([]apiParam{
+			{Name: "folder", Type: "string", Description: "Folder path (default /)"},
+			{Name: "sort", Type: "string", Description: "filename, size or created_at"},
+			{Name: "order", Type: "string", Description: "asc or desc"},
+		}, paginationParams...),
+		Response: APIFolderListing{}, Status: http.StatusOK,
+		Handler: (*APIHandler).ListFiles,
+	},
+	{
+		Method: http.MethodGet, Pattern: "/files/{id}", Scope: auth.ScopeFilesRead,
+		Summary:  "Get file metadata",
+		Response: APIFile{}, Status: http.StatusOK,
+		Handler: (*APIHandler).GetFile,
+	},
+	{
+		Method: http.MethodPatch, Pattern: "/files/{id}", Scope: auth.ScopeFilesWrite,
+		Summary: "Rename and/or move a file",
+		Request: APIUpdateFileRequest{}, Response: APIFile{}, Status: http.StatusOK,
+		Handler: (*APIHandler).UpdateFile,
+	},
+	{
+		Method: http.MethodDelete, Pattern: "/files/{id}", Scope: auth.ScopeFilesWrite,
+		Summary: "Move a file to deleted items",
+		Status:  http.StatusNoContent,
+		Handler: (*APIHandler).DeleteFile,
+	},
+	{
+		Method: http.MethodPost, Pattern: "/folders", Scope: auth.ScopeFilesWrite,
+		Summary: "Create a folder",
+		Request: APICreateFolderRequest{}, Response: APIFolder{}, Status: http.StatusCreated,
+		Handler: (*APIHandler).CreateFolder,
+	},
+	{
+		Method: http.MethodGet, Pattern: "/deleted", Scope: auth.ScopeFilesRead,
+		Summary:  "List deleted files",
+		Query:    paginationParams,
+		Response: APIDeletedListing{}, Status: http.StatusOK,
+		Handler: (*APIHandler).ListDeleted,
+	},
+	{
+		Method: http.MethodPost, Pattern: "/deleted/files/{id}/restore", Scope: auth.ScopeFilesWrite,
+		Summary:  "Restore a deleted file to its original folder",
+		Response: APIFile{}, Status: http.StatusOK,
+		Handler: (*APIHandler).RestoreFile,
+	},
+	{
+		Method: http.MethodGet, Pattern: "/files/{id}/shares", Scope: auth.ScopeSharesManage,
+		Summary:  "List share links for a file",
+		Response: []APIShareLink{}, Status: http.StatusOK,
+		Handler: (*APIHandler).ListFileShares,
+	},
+	{
+		Method: http.MethodPost, Pattern: "/files/{id}/shares", Scope: auth.ScopeSharesManage,
+		Summary: "Create a share link for a file",
+		Request: APICreateShareRequest{}, Response: APIShareLink{}, Status: http.StatusCreated,
+		Handler: (*APIHandler).CreateFileShare,
+	},
+	{
+		Method: http.MethodDelete, Pattern: "/shares/{token}", Scope: auth.ScopeSharesManage,
+		Summary: "Revoke a file share link",
+		Status:  http.StatusNoContent,
+		Handler: (*APIHandler).RevokeFileShare,
+	},
+	{
+		Method: http.MethodGet, Pattern: "/folder-shares", Scope: auth.ScopeSharesManage,
+		Summary:  "List folder share links",
+		Response: []APIShareLink{}, Status: http.StatusOK,
+		Handler: (*APIHandler).ListFolderShares,
+	},
+	{
+		Method: http.MethodPost, Pattern: "/folder-shares", Scope: auth.ScopeSharesManage,
+		Summary: "Create a share link for a folder",
+		Request: APICreateFolderShareRequest{}, Response: APIShareLink{}, Status: http.StatusCreated,
+		Handler: (*APIHandler).CreateFolderShare,
+	},
+	{
+		Method: http.MethodDelete, Pattern: "/folder-shares/{token}", Scope: auth.ScopeSharesManage,
+		Summary: "Revoke a folder share link",
+		Status:  http.StatusNoContent,
+		Handler: (*APIHandler).RevokeFolderShare,
+	},
+	{
+		Method: http.MethodGet, Pattern: "/quota", Scope: auth.ScopeFilesRead,
+		Summary:  "Get storage usage and quota",
+		Response: APIQuota{}, Status: http.StatusOK,
+		Handler: (*APIHandler).GetQuota,
+	},
+}
+
+// Mount registers every /api/v1 route on r. authFor returns the authentication
+// middleware enforcing the given token scope.
+func (h *APIHandler) Mount(r chi.Router, authFor func(scope string) func(http.Handler) http.Handler) {
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		WriteAPIError(w, http.StatusNotFound, "not_found", "No such API endpoint")
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		WriteAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+	})
+
+	// The document itself is public so tooling can fetch it before authenticating
+	r.Get("/openapi.json", h.OpenAPI)
+
+	for _, route := range apiRoutes {
+		handler := route.Handler
+		r.With(authFor(route.Scope)).Method(route.Method, route.Pattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler(h, w, r)
+		}))
+	}
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPIDoc  map[string]any
+)
+
+// OpenAPI handles GET /api/v1/openapi.json.
+func (h *APIHandler) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	openAPIOnce.Do(func() {
+		openAPIDoc = buildOpenAPI(h.version)
+	})
+	writeAPIJSON(w, http.StatusOK, openAPIDoc)
+}
+
+var pathParamPattern = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// buildOpenAPI generates an OpenAPI 3.1 document from apiRoutes.
+func buildOpenAPI(version string) map[string]any {
+	if version == "" {
+		version = "dev"
+	}
+	g := &schemaGenerator{components: map[string]any{}}
+	errorRef := g.schemaFor(reflect.TypeOf(APIError{}))
+
+	errorResponse := func(description string) map[string]any {
+		return map[string]any{
+			"description": description,
+			"content":     map[string]any{"application/json": map[string]any{"schema": errorRef}},
+		}
+	}
+
+	paths := map[string]any{}
+	for _, route := range apiRoutes {
+		var params []any
+		for _, m := range pathParamPattern.FindAllStringSubmatch(route.Pattern, -1) {
+			typ := "string"
+			if m[1] == "id" {
+				typ = "integer"
+			}
+			params = append(params, map[string]any{
+				"name": m[1], "in": "path", "required": true,
+				"schema": map[string]any{"type": typ},
+			})
+		}
+		for _, q := range route.Query {
+			params = append(params, map[string]any{
+				"name": q.Name, "in": "query", "description": q.Description,
+				"schema": map[string]any{"type": q.Type},
+			})
+		}
+
+		success := map[string]any{"description": http.StatusText(route.Status)}
+		if route.Response != nil {
+			success["content"] = map[string]any{
+				"application/json": map[string]any{"schema": g.schemaFor(reflect.TypeOf(route.Response))},
+			}
+		}
+		responses := map[string]any{
+			strconv.Itoa(route.Status): success,
+			"400":                      errorResponse("Invalid request"),
+			"401":                      errorResponse("Missing or invalid credentials"),
+			"403":                      errorResponse("API token lacks the required scope"),
+		}
+		if strings.Contains(route.Pattern, "{") || route.Method != http.MethodGet {
+			responses["404"] = errorResponse("Not found")
+		}
+		if route.Method == http.MethodPatch || route.Method == http.MethodPost {
+			responses["409"] = errorResponse("Conflicts with an existing item")
+		}
+
+		op := map[string]any{
+			"summary":     route.Summary,
+			"operationId": operationID(route.Handler),
+			"security": []any{
+				map[string]any{"bearerAuth": []string{route.Scope}},
+				map[string]any{"sessionCookie": []string{}},
+			},
+			"responses": responses,
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		if route.Request != nil {
+			op["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": g.schemaFor(reflect.TypeOf(route.Request))},
+				},
+			}
+		}
+
+		item, _ := paths[route.Pattern].(map[string]any)
+		if item == nil {
+			item = map[string]any{}
+			paths[route.Pattern] = item
+		}
+		item[strings.ToLower(route.Method)] = op
+	}
+
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":       "Trove API",
+			"version":     version,
+			"description": "Errors are returned as {\"error\": {\"code\", \"message\"}} with a non-2xx status.",
+		},
+		"servers": []any{map[string]any{"url": "/api/v1"}},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": g.components,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{
+					"type": "http", "scheme": "bearer",
+					"description": "Personal API token created under Settings. Required scopes are listed per operation.",
+				},
+				"sessionCookie": map[string]any{
+					"type": "apiKey", "in": "cookie", "name": "session_token",
+					"description": "Browser session; grants every scope.",
+				},
+			},
+		},
+	}
+}
+
+// operationID derives a stable operation ID from a handler method expression,
+// e.g. (*APIHandler).ListFiles becomes "ListFiles".
+func operationID(handler func(h *APIHandler, w http.ResponseWriter, r *http.Request)) string {
+	name := runtime.FuncForPC(reflect.ValueOf(handler).Pointer()).Name()
+	name = strings.TrimSuffix(name, "-fm")
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// schemaGenerator converts Go types into JSON Schema, collecting named structs
+// under components/schemas and referencing them by $ref.
+type schemaGenerator struct {
+	components map[string]any
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (g *schemaGenerator) schemaFor(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Struct:
+		name := t.Name()
+		if _, ok := g.components[name]; !ok {
+			g.components[name] = nil // reserve to stop recursion
+			g.components[name] = g.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		return map[string]any{"type": "array", "items": g.schemaFor(t.Elem())}
+	case t.Kind() == reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schemaFor(t.Elem())}
+	case t.Kind() == reflect.String:
+		return map[string]any{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		if t.Kind() == reflect.Int64 || t.Kind() == reflect.Uint64 {
+			return map[string]any{"type": "integer", "format": "int64"}
+		}
+		return map[string]any{"type": "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return map[string]any{"type": "number"}
+	}
+	return map[string]any{}
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	var required []string
+	g.collectFields(t, properties, &required)
+
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// collectFields adds t's JSON-visible fields to properties, flattening
+// embedded structs the same way encoding/json does.
+func (g *schemaGenerator) collectFields(t reflect.Type, properties map[string]any, required *[]string) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			g.collectFields(f.Type, properties, required)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = g.schemaFor(f.Type)
+		if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -71,67 +71,15 @@ func sortFoldersByPathNaturally(folders []models.Folder) {
 	})
 }
 
-func (h *PageHandler) ShowFiles(w http.ResponseWriter, r *http.Request) {
-	user := auth.GetUser(r)
-
-	// Get current folder from query param, default to root
-	currentFolder := sanitizeFolderPath(r.URL.Query().Get("folder"))
-
-	// Validate folder exists (root folder "/" is always valid)
-	if currentFolder != "/" {
-		// Check if folder exists in folders table
-		var folderCount int64
-		h.db.Model(&models.Folder{}).Where("user_id = ? AND folder_path = ?", user.ID, currentFolder).Count(&folderCount)
-
-		// Also check if any files exist in this folder path (implicit folders)
-		var fileCount int64
-		if folderCount == 0 {
-			h.db.Model(&models.File{}).Where("user_id = ? AND logical_path = ?", user.ID, currentFolder).Count(&fileCount)
-		}
-
-		// If folder doesn't exist in either table, return 404
-		if folderCount == 0 && fileCount == 0 {
-			http.NotFound(w, r)
-			return
-		}
-	}
-
-	// Pagination parameters
-	page := 1
-	pageSize := 15
-	if p := r.URL.Query().Get("page"); p != "" {
-		if parsed, err := strconv.Atoi(p); err == nil && parsed > 0 {
-			page = parsed
-		}
-	}
-	offset := (page - 1) * pageSize
-
-	sortField := r.URL.Query().Get("sort")
-	sortOrder := strings.ToLower(r.URL.Query().Get("order"))
-
-	if sortOrder != "asc" && sortOrder != "desc" {
-		sortOrder = "asc"
-	}
-
-	allowedSorts := map[string]string{
-		"filename":   "original_filename",
-		"file_size":  "file_size",
-		"created_at": "created_at",
-	}
-
-	dbOrderColumn, ok := allowedSorts[sortField]
-	if !ok {
-		dbOrderColumn = "original_filename"
-		sortField = "filename"
-	}
-
-	orderExpression := dbOrderColumn + " " + strings.ToUpper(sortOrder)
-
+// listSubfolderNames returns the names of the immediate, non-deleted subfolders of
+// currentFolder, including implicit folders that only exist because files live in them.
+// Names are sorted naturally (case-insensitive).
+func listSubfolderNames(db *gorm.DB, userID uint, currentFolder string) []string {
 	// Get direct subfolders from Folders table (exclude deleted)
 	var folders []models.Folder
 	if currentFolder == "/" {
 		// Root level: get folders that don't contain additional slashes after the first one
-		h.db.Raw(`
+		db.Raw(`
 			SELECT * FROM folders
 			WHERE user_id = ?
 			AND folder_path LIKE '/%'
@@ -140,10 +88,10 @@ func (h *PageHandler) ShowFiles(w http.ResponseWriter, r *http.Request) {
 			AND deleted_at IS NULL
 			AND trashed_at IS NULL
 			ORDER BY folder_path
-		`, user.ID).Scan(&folders)
+		`, userID).Scan(&folders)
 	} else {
 		// Subdirectory: get direct children only
-		h.db.Raw(`
+		db.Raw(`
 			SELECT * FROM folders
 			WHERE user_id = ?
 			AND folder_path LIKE ?
@@ -151,7 +99,7 @@ func (h *PageHandler) ShowFiles(w http.ResponseWriter, r *http.Request) {
 			AND deleted_at IS NULL
 			AND trashed_at IS NULL
 			ORDER BY folder_path
-		`, user.ID, currentFolder+"/%", currentFolder+"/%/%").Scan(&folders)
+		`, userID, currentFolder+"/%", currentFolder+"/%/%").Scan(&folders)
 	}
 
 	// Also check for implicit folders (folders that only exist because files are in them)
@@ -159,11 +107,15 @@ func (h *PageHandler) ShowFiles(w http.ResponseWriter, r *http.Request) {
 	type implicitFolderPath struct {
 		LogicalPath string
 	}
+	prefix := currentFolder + "/"
+	if currentFolder == "/" {
+		prefix = "/"
+	}
 	var implicitFolders []implicitFolderPath
-	h.db.Model(&models.File{}).
+	db.Model(&models.File{}).
 		Select("DISTINCT logical_path").
-		Where("user_id = ? AND logical_path LIKE ? AND logical_path != ? AND trashed_at IS NULL",
-			user.ID, currentFolder+"/%", currentFolder).
+		Where("user_id = ? AND logical_path LIKE ? ESCAPE '\\' AND logical_path != ? AND trashed_at IS NULL",
+			userID, escapeSQLLike(prefix)+"%", currentFolder).
 		Scan(&implicitFolders)
 
 	// Extract direct subfolder names
@@ -197,20 +149,80 @@ func (h *PageHandler) ShowFiles(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// FolderInfo holds folder name and sanitized ID for safe HTML rendering
-	type FolderInfo struct {
-		Name string
-		ID   string
-	}
-
 	// Convert to slice and sort naturally (case-insensitive)
-	// All folders are shown (no pagination for folders)
 	folderNames := make([]string, 0, len(folderMap))
 	for name := range folderMap {
 		folderNames = append(folderNames, name)
 	}
 	sortStringsNaturally(folderNames)
 
+	return folderNames
+}
+
+func (h *PageHandler) ShowFiles(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+
+	// Get current folder from query param, default to root
+	currentFolder := sanitizeFolderPath(r.URL.Query().Get("folder"))
+
+	// Validate folder exists (root folder "/" is always valid)
+	if currentFolder != "/" {
+		// Check if folder exists in folders table
+		var folderCount int64
+		h.db.Model(&models.Folder{}).Where("user_id = ? AND folder_path = ?", user.ID, currentFolder).Count(&folderCount)
+
+		// Also check if any files exist in this folder path (implicit folders)
+		var fileCount int64
+		if folderCount == 0 {
+			h.db.Model(&models.File{}).Where("user_id = ? AND logical_path = ?", user.ID, currentFolder).Count(&fileCount)
+		}
+
+		// If folder doesn't exist in either table, return 404
+		if folderCount == 0 && fileCount == 0 {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	// Pagination parameters
+	page := 1
+	pageSize := 15
+	if p := r.URL.Query().Get("page"); p != "" {
+		if parsed, err := strconv.Atoi(p); err == nil && parsed > 0 {
+			page = parsed
+		}
+	}
+	offset := (page - 1) * pageSize
+
+	sortField := r.URL.Query().Get("sort")
+	sortOrder := strings.ToLower(r.URL.Query().Get("order"))
+
+	if sortOrder != "asc" && sortOrder != "desc" {
+		sortOrder = "asc"
+	}
+
+	allowedSorts := map[string]string{
+		"filename":   "original_filename",
+		"file_size":  "file_size",
+		"created_at": "created_at",
+	}
+
+	dbOrderColumn, ok := allowedSorts[sortField]
+	if !ok {
+		dbOrderColumn = "original_filename"
+		sortField = "filename"
+	}
+
+	orderExpression := dbOrderColumn + " " + strings.ToUpper(sortOrder)
+
+	folderNames := listSubfolderNames(h.db, user.ID, currentFolder)
+
+	// FolderInfo holds folder name and sanitized ID for safe HTML rendering
+	type FolderInfo struct {
+		Name string
+		ID   string
+	}
+
 	// Build folder info with sanitized IDs
 	folderInfos := make([]FolderInfo, 0, len(folderNames))
 	for i, name := range folderNames {
@@ -297,6 +309,10 @@ func (h *PageHandler) ShowFiles(w http.ResponseWriter, r *http.Request) {
 	var failedUploads []models.File
 	h.db.Where("user_id = ? AND upload_status = ? AND trashed_at IS NULL", user.ID, "failed").Find(&failedUploads)
 
+	// Archive extractions still running, whose progress the page follows
+	var extractions []models.ExtractJob
+	h.db.Where("user_id = ? AND status IN ?", user.ID, []string{extractPending, extractRunning}).Order("id").Find(&extractions)
+
 	// Count deleted items for nav badge (single query for both files and folders)
 	var deletedCount int64
 	h.db.Raw(`
@@ -320,6 +336,7 @@ func (h *PageHandler) ShowFiles(w http.ResponseWriter, r *http.Request) {
 		"FullWidth":     true,
 		"MaxUploadSize": h.cfg.MaxUploadSize,
 		"FailedUploads": failedUploads,
+		"Extractions":   extractions,
 		"DeletedCount":  deletedCount,
 		"SortField":     sortField,
 		"SortOrder":     sortOrder,
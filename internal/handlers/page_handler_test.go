@@ -23,7 +23,7 @@ func setupTestPageHandler(t *testing.T) (*PageHandler, *gorm.DB) {
 		t.Fatalf("Failed to open test database: %v", err)
 	}
 
-	err = db.AutoMigrate(&models.User{}, &models.Folder{}, &models.File{})
+	err = db.AutoMigrate(&models.User{}, &models.Folder{}, &models.File{}, &models.FileVersion{}, &models.Blob{})
 	if err != nil {
 		t.Fatalf("Failed to migrate database: %v", err)
 	}
@@ -6,6 +6,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -56,7 +57,7 @@ func newPageTestApp(t *testing.T) *pageTestApp {
 		t.Fatalf("Failed to open test database: %v", err)
 	}
 
-	err = db.AutoMigrate(&models.User{}, &models.File{}, &models.Folder{})
+	err = db.AutoMigrate(&models.User{}, &models.File{}, &models.FileVersion{}, &models.Blob{}, &models.Folder{})
 	if err != nil {
 		t.Fatalf("Failed to migrate database: %v", err)
 	}
@@ -596,3 +597,41 @@ func TestShowFilesWithUploadStatus(t *testing.T) {
 		}
 	})
 }
+
+// TestShowFilesRootImplicitFolders tests that folders only holding files are
+// listed at the root, and that LIKE wildcards in folder names match literally
+func TestShowFilesRootImplicitFolders(t *testing.T) {
+	app := newPageTestApp(t)
+
+	user := app.createTestUser(t, "rootimplicituser")
+	app.createTestFile(t, user, "top.txt", "/")
+	app.createTestFile(t, user, "a.txt", "/only_files")
+	app.createTestFile(t, user, "b.txt", "/only_files/nested")
+	for _, folder := range []string{"/a_b", "/axb", "/100%", "/100x"} {
+		app.createTestFile(t, user, "c.txt", folder)
+	}
+	app.createTestFile(t, user, "d.txt", "/a_b/inner")
+	app.createTestFile(t, user, "e.txt", "/axb/other")
+	app.createTestFile(t, user, "f.txt", "/100%/inner")
+	app.createTestFile(t, user, "g.txt", "/100x/other")
+
+	if got := listSubfolderNames(app.db, user.ID, "/"); !reflect.DeepEqual(got, []string{"100%", "100x", "a_b", "axb", "only_files"}) {
+		t.Errorf("root subfolders = %v", got)
+	}
+	for folder, want := range map[string][]string{
+		"/only_files": {"nested"},
+		"/a_b":        {"inner"},
+		"/100%":       {"inner"},
+	} {
+		if got := listSubfolderNames(app.db, user.ID, folder); !reflect.DeepEqual(got, want) {
+			t.Errorf("subfolders of %s = %v, want %v", folder, got, want)
+		}
+	}
+
+	req := app.authenticatedRequest(t, http.MethodGet, "/files", user)
+	w := httptest.NewRecorder()
+	app.pageHandler.ShowFiles(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "only_files") {
+		t.Errorf("ShowFiles = %d, want the implicit folder listed at the root", w.Code)
+	}
+}
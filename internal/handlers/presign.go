@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/agjmills/trove/internal/database/models"
+	"github.com/agjmills/trove/internal/logger"
+	"github.com/agjmills/trove/internal/storage"
+)
+
+// contentDisposition returns the Content-Disposition header serving a file
+// named filename, either "attachment" or "inline". The filename is quoted
+// and also given UTF-8 encoded for non-ASCII names.
+func contentDisposition(disposition, filename string) string {
+	safeFilename := strings.ReplaceAll(filename, `"`, `\"`)
+	return fmt.Sprintf(`%s; filename="%s"; filename*=UTF-8''%s`,
+		disposition, safeFilename, url.PathEscape(filename))
+}
+
+// redirectToStorage redirects an authorized download of file to a
+// presigned URL on the storage backend, which serves it with the file's
+// content type and the given disposition. It returns false without writing
+// a response if the backend does not presign downloads, so the caller
+// serves the file itself.
+func redirectToStorage(w http.ResponseWriter, r *http.Request, backend storage.StorageBackend, file *models.File, disposition string) bool {
+	presigner, ok := backend.(storage.Presigner)
+	if !ok {
+		return false
+	}
+	location, err := presigner.PresignGet(r.Context(), file.StoragePath, storage.PresignOptions{
+		ContentType:        file.MimeType,
+		ContentDisposition: contentDisposition(disposition, file.Filename),
+	})
+	if err != nil {
+		if !errors.Is(err, storage.ErrPresignUnsupported) {
+			logger.Warn("failed to presign download, serving it directly", "path", file.StoragePath, "error", err)
+		}
+		return false
+	}
+	// The URL expires, so it must not outlive it in a cache
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, location, http.StatusFound)
+	return true
+}
@@ -3,10 +3,8 @@ package handlers
 import (
 	"crypto/rand"
 	"encoding/base64"
-	"fmt"
 	"io"
 	"net/http"
-	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -53,8 +51,14 @@ func (h *ShareHandler) consumeUse(link *models.ShareLink) bool {
 	return true
 }
 
-// streamFile writes the file contents to the response with appropriate headers.
+// streamFile writes the file contents to the response with appropriate
+// headers, or redirects to the storage backend if it presigns downloads.
 func (h *ShareHandler) streamFile(w http.ResponseWriter, r *http.Request, file models.File) {
+	recordAccess(h.db, &file)
+	if redirectToStorage(w, r, h.storage, &file, "attachment") {
+		return
+	}
+
 	reader, err := h.storage.Open(r.Context(), file.StoragePath)
 	if err != nil {
 		http.Error(w, "File not found", http.StatusNotFound)
@@ -62,9 +66,7 @@ func (h *ShareHandler) streamFile(w http.ResponseWriter, r *http.Request, file m
 	}
 	defer reader.Close() //nolint:errcheck
 
-	safeFilename := strings.ReplaceAll(file.Filename, `"`, `\"`)
-	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`,
-		safeFilename, url.PathEscape(file.Filename)))
+	w.Header().Set("Content-Disposition", contentDisposition("attachment", file.Filename))
 	w.Header().Set("Content-Type", file.MimeType)
 	if file.FileSize > 0 {
 		w.Header().Set("Content-Length", strconv.FormatInt(file.FileSize, 10))
@@ -6,6 +6,7 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 	"time"
@@ -17,6 +18,7 @@ import (
 
 	"github.com/agjmills/trove/internal/auth"
 	"github.com/agjmills/trove/internal/database/models"
+	"github.com/agjmills/trove/internal/storage"
 )
 
 // readableStorage wraps mockStorage and returns a readable body for Open.
@@ -29,13 +31,24 @@ func (s *readableStorage) Open(ctx context.Context, path string) (io.ReadCloser,
 	return io.NopCloser(strings.NewReader(s.content)), nil
 }
 
+// presigningStorage adds presigned downloads to a backend, with URLs that
+// show the response headers asked for.
+type presigningStorage struct {
+	storage.StorageBackend
+}
+
+func (presigningStorage) PresignGet(_ context.Context, path string, opts storage.PresignOptions) (string, error) {
+	query := url.Values{"type": {opts.ContentType}, "disposition": {opts.ContentDisposition}}
+	return "https://storage.example.com/" + path + "?" + query.Encode(), nil
+}
+
 func setupShareTest(t *testing.T) (*ShareHandler, *gorm.DB, *models.User) {
 	t.Helper()
 	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
 	if err != nil {
 		t.Fatalf("open db: %v", err)
 	}
-	if err := db.AutoMigrate(&models.User{}, &models.File{}, &models.ShareLink{}); err != nil {
+	if err := db.AutoMigrate(&models.User{}, &models.File{}, &models.FileVersion{}, &models.Blob{}, &models.ShareLink{}); err != nil {
 		t.Fatalf("migrate: %v", err)
 	}
 
@@ -264,6 +277,71 @@ func TestAccessShareLink_Success(t *testing.T) {
 	if updated.Uses != 1 {
 		t.Errorf("want uses=1, got %d", updated.Uses)
 	}
+
+	// The read is recorded for storage tiering
+	var accessed models.File
+	db.First(&accessed, file.ID)
+	if accessed.LastAccessedAt == nil {
+		t.Error("want last_accessed_at set after a share download")
+	}
+}
+
+func TestRecordAccess_Throttled(t *testing.T) {
+	_, db, user := setupShareTest(t)
+	file := createTestFile(t, db, user.ID)
+
+	recordAccess(db, file)
+	first := file.LastAccessedAt
+	if first == nil {
+		t.Fatal("want last_accessed_at set")
+	}
+
+	// A second read within the interval does not write again
+	var stored models.File
+	db.First(&stored, file.ID)
+	recordAccess(db, &stored)
+	db.First(&stored, file.ID)
+	if !stored.LastAccessedAt.Equal(*first) {
+		t.Errorf("last_accessed_at changed from %v to %v within the interval", first, stored.LastAccessedAt)
+	}
+
+	// Once the interval has passed it is updated
+	old := time.Now().Add(-2 * accessRecordInterval)
+	db.Model(&stored).UpdateColumn("last_accessed_at", old)
+	stored.LastAccessedAt = &old
+	recordAccess(db, &stored)
+	db.First(&stored, file.ID)
+	if !stored.LastAccessedAt.After(old) {
+		t.Errorf("last_accessed_at = %v, want it updated", stored.LastAccessedAt)
+	}
+}
+
+func TestAccessShareLink_PresignedRedirect(t *testing.T) {
+	h, db, user := setupShareTest(t)
+	h.storage = presigningStorage{h.storage}
+	file := createTestFile(t, db, user.ID)
+	link := createShareLink(t, db, file.ID, user.ID, nil, nil)
+
+	w := makeAccessRequest(t, h, link.Token)
+	if w.Code != http.StatusFound {
+		t.Fatalf("want 302, got %d: %s", w.Code, w.Body.String())
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil || location.Host != "storage.example.com" || location.Path != "/test/path.bin" {
+		t.Fatalf("unexpected Location %q", w.Header().Get("Location"))
+	}
+	if got := location.Query().Get("disposition"); !strings.HasPrefix(got, `attachment; filename="test.txt"`) {
+		t.Errorf("unexpected disposition %q", got)
+	}
+	if got := location.Query().Get("type"); got != "text/plain" {
+		t.Errorf("unexpected content type %q", got)
+	}
+
+	var updated models.ShareLink
+	db.First(&updated, link.ID)
+	if updated.Uses != 1 {
+		t.Errorf("want uses=1, got %d", updated.Uses)
+	}
 }
 
 func TestAccessShareLink_InvalidToken(t *testing.T) {
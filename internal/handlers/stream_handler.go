@@ -57,6 +57,7 @@ func (h *FileHandler) Stream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	size := info.Size
+	recordAccess(h.db, &file)
 
 	contentType := file.VideoVariantMime
 	if contentType == "" {
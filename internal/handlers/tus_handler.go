@@ -0,0 +1,477 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/md5"  //nolint:gosec // tus checksum extension algorithm, not used for security
+	"crypto/sha1" //nolint:gosec // tus checksum extension algorithm, not used for security
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+
+	"github.com/agjmills/trove/internal/auth"
+	"github.com/agjmills/trove/internal/database/models"
+	"github.com/agjmills/trove/internal/logger"
+)
+
+// TusPrefix is the URL the tus endpoint is mounted at. Upload URLs returned
+// in the Location header are TusPrefix + "/" + upload ID.
+const TusPrefix = "/api/tus"
+
+const (
+	tusVersion            = "1.0.0"
+	tusExtensions         = "creation,creation-with-upload,termination,checksum,expiration"
+	tusChecksumAlgorithms = "sha1,sha256,md5"
+	tusContentType        = "application/offset+octet-stream"
+	tusDataFile           = "data"
+
+	// statusChecksumMismatch is defined by the tus checksum extension.
+	statusChecksumMismatch = 460
+)
+
+// tus 1.0 (https://tus.io/protocols/resumable-upload) is implemented on top of
+// UploadSession so off-the-shelf clients (Uppy, tus-js-client, mobile SDKs)
+// can upload to Trove. A tus session has TotalChunks = 0 and appends bytes to
+// a single data file in its temp directory, tracking progress in UploadOffset.
+// Quota is checked when the upload is created and sessions expire after
+// UPLOAD_SESSION_TIMEOUT, exactly as for the chunked /api/uploads protocol.
+
+// tusResumable sets the Tus-Resumable response header and rejects requests
+// for a protocol version the server does not speak.
+func tusResumable(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "Unsupported tus version", http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// TusOptions advertises the supported tus version and extensions.
+func (h *UploadHandler) TusOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Checksum-Algorithm", tusChecksumAlgorithms)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TusCreate creates an upload (creation extension). Upload-Metadata may carry
+// filename (or name), filetype (or type), folder, comma-separated tags and
+// on_conflict. A request body with Content-Type application/offset+octet-stream
+// is appended straight away (creation-with-upload).
+func (h *UploadHandler) TusCreate(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !tusResumable(w, r) {
+		return
+	}
+
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		http.Error(w, "Upload-Defer-Length is not supported", http.StatusBadRequest)
+		return
+	}
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "Invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+
+	meta, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, "Invalid Upload-Metadata", http.StatusBadRequest)
+		return
+	}
+
+	filename := firstNonEmpty(meta["filename"], meta["name"])
+	if msg := validateFilename(filename); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	logicalPath := normalizeLogicalPath(meta["folder"])
+	if logicalPath == "" {
+		http.Error(w, "Invalid logical path", http.StatusBadRequest)
+		return
+	}
+	mimeType := firstNonEmpty(meta["filetype"], meta["type"], mime.TypeByExtension(filepath.Ext(filename)), "application/octet-stream")
+
+	var tags []string
+	if meta["tags"] != "" {
+		var msg string
+		if tags, msg = normalizeUploadTags(strings.Split(meta["tags"], ",")); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+	}
+
+	policy, err := parseConflictPolicy(r, meta["on_conflict"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if quotaExceeded(h.db, user, length) {
+		http.Error(w, "Storage quota exceeded", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if !checkDiskSpace(w, h.cfg, length) {
+		return
+	}
+
+	uploadID := uuid.New().String()
+	tempDir, err := h.createUploadTempDir(uploadID)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, tusDataFile), nil, 0600); err != nil {
+		logger.Error("failed to create tus data file", "error", err, "dir", tempDir)
+		_ = os.RemoveAll(tempDir)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	session := models.UploadSession{
+		ID:             uploadID,
+		UserID:         user.ID,
+		Filename:       filename,
+		LogicalPath:    logicalPath,
+		TotalSize:      length,
+		ChunksReceived: []byte("[]"),
+		Status:         "active",
+		MimeType:       mimeType,
+		Tags:           datatypes.NewJSONType(tags),
+		TempDir:        tempDir,
+		ConflictPolicy: string(policy),
+		ExpiresAt:      time.Now().Add(h.cfg.UploadSessionTimeout),
+	}
+	if err := h.db.Create(&session).Error; err != nil {
+		logger.Error("failed to create upload session", "error", err)
+		_ = os.RemoveAll(tempDir)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Info("tus upload created",
+		"upload_id", uploadID,
+		"user_id", user.ID,
+		"filename", filename,
+		"size", length,
+	)
+
+	w.Header().Set("Location", TusPrefix+"/"+uploadID)
+	w.Header().Set("Upload-Expires", session.ExpiresAt.UTC().Format(http.TimeFormat))
+
+	// An empty upload is complete as soon as it exists; otherwise take any
+	// data sent along with the creation request.
+	if length == 0 || r.Header.Get("Content-Type") == tusContentType {
+		if status, msg := h.tusAppend(w, r, &session); status != 0 {
+			http.Error(w, msg, status)
+			return
+		}
+		w.Header().Set("Upload-Offset", strconv.FormatInt(session.UploadOffset, 10))
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// TusHead reports how many bytes of an upload the server has.
+func (h *UploadHandler) TusHead(w http.ResponseWriter, r *http.Request) {
+	if !tusResumable(w, r) {
+		return
+	}
+	session, ok := h.loadTusSession(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(session.UploadOffset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(session.TotalSize, 10))
+	if session.Status == "active" {
+		w.Header().Set("Upload-Expires", session.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// TusPatch appends the request body to an upload at Upload-Offset. When the
+// final byte arrives the file is stored before the response is sent.
+func (h *UploadHandler) TusPatch(w http.ResponseWriter, r *http.Request) {
+	if !tusResumable(w, r) {
+		return
+	}
+	if r.Header.Get("Content-Type") != tusContentType {
+		http.Error(w, "Content-Type must be "+tusContentType, http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "Invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+
+	// Only one request may write to an upload at a time
+	uploadID := chi.URLParam(r, "id")
+	lock, _ := tusLocks.LoadOrStore(uploadID, &sync.Mutex{})
+	if !lock.(*sync.Mutex).TryLock() {
+		http.Error(w, "Upload is locked by another request", http.StatusLocked)
+		return
+	}
+	defer lock.(*sync.Mutex).Unlock()
+
+	session, ok := h.loadTusSession(w, r)
+	if !ok {
+		return
+	}
+	if session.Status != "active" {
+		http.Error(w, fmt.Sprintf("Upload is %s", session.Status), http.StatusConflict)
+		return
+	}
+	if offset != session.UploadOffset {
+		http.Error(w, "Upload-Offset does not match", http.StatusConflict)
+		return
+	}
+	if !checkDiskSpace(w, h.cfg, r.ContentLength) {
+		return
+	}
+
+	if status, msg := h.tusAppend(w, r, session); status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+	if session.Status == "completed" {
+		tusLocks.Delete(uploadID)
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(session.UploadOffset, 10))
+	w.Header().Set("Upload-Expires", session.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TusDelete cancels an upload and discards the received data (termination extension).
+func (h *UploadHandler) TusDelete(w http.ResponseWriter, r *http.Request) {
+	if !tusResumable(w, r) {
+		return
+	}
+	session, ok := h.loadTusSession(w, r)
+	if !ok {
+		return
+	}
+	if session.Status != "active" {
+		http.Error(w, fmt.Sprintf("Upload is %s", session.Status), http.StatusConflict)
+		return
+	}
+
+	if err := h.db.Model(session).Update("status", "canceled").Error; err != nil {
+		logger.Error("failed to update session status", "error", err, "upload_id", session.ID)
+		http.Error(w, "Failed to cancel upload", http.StatusInternalServerError)
+		return
+	}
+	tusLocks.Delete(session.ID)
+
+	// Clean up temp directory
+	go func() {
+		if err := os.RemoveAll(session.TempDir); err != nil {
+			logger.Error("failed to clean up temp directory", "error", err, "dir", session.TempDir)
+		}
+	}()
+
+	logger.Info("tus upload terminated", "upload_id", session.ID, "user_id", session.UserID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadTusSession fetches the caller's tus session named in the URL, writing
+// 404 for unknown, canceled or expired uploads and 410 for ones that have
+// just passed their expiry time.
+func (h *UploadHandler) loadTusSession(w http.ResponseWriter, r *http.Request) (*models.UploadSession, bool) {
+	user := auth.GetUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	var session models.UploadSession
+	if err := h.db.Where("id = ? AND user_id = ? AND total_chunks = 0", chi.URLParam(r, "id"), user.ID).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Upload not found", http.StatusNotFound)
+		} else {
+			logger.Error("failed to get upload session", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return nil, false
+	}
+
+	switch {
+	case session.Status == "canceled" || session.Status == "expired":
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return nil, false
+	case session.Status == "active" && time.Now().After(session.ExpiresAt):
+		if err := h.db.Model(&session).Update("status", "expired").Error; err != nil {
+			logger.Error("failed to mark session as expired", "error", err, "upload_id", session.ID)
+		}
+		http.Error(w, "Upload has expired", http.StatusGone)
+		return nil, false
+	}
+	return &session, true
+}
+
+// tusAppend writes the request body at session.UploadOffset and records the
+// new offset. Once every byte has arrived the data is stored as a file, and
+// the action taken is reported in the UploadActionHeader. On
+// failure it returns an HTTP status and message; bytes that fail an
+// Upload-Checksum are discarded, but without a checksum whatever was received
+// before an interrupted request is kept so the client can resume from there.
+func (h *UploadHandler) tusAppend(w http.ResponseWriter, r *http.Request, session *models.UploadSession) (int, string) {
+	var checksum hash.Hash
+	var expected []byte
+	if header := r.Header.Get("Upload-Checksum"); header != "" {
+		algorithm, encoded, _ := strings.Cut(header, " ")
+		switch algorithm {
+		case "sha1":
+			checksum = sha1.New() //nolint:gosec
+		case "sha256":
+			checksum = sha256.New()
+		case "md5":
+			checksum = md5.New() //nolint:gosec
+		default:
+			return http.StatusBadRequest, "Unsupported checksum algorithm"
+		}
+		var err error
+		if expected, err = base64.StdEncoding.DecodeString(encoded); err != nil {
+			return http.StatusBadRequest, "Invalid Upload-Checksum"
+		}
+	}
+
+	dataPath := filepath.Join(session.TempDir, tusDataFile)
+	data, err := os.OpenFile(dataPath, os.O_RDWR, 0600)
+	if err != nil {
+		logger.Error("failed to open tus data file", "error", err, "upload_id", session.ID)
+		return http.StatusInternalServerError, "Internal server error"
+	}
+	defer data.Close() //nolint:errcheck
+
+	start := session.UploadOffset
+	if _, err := data.Seek(start, io.SeekStart); err != nil {
+		logger.Error("failed to seek tus data file", "error", err, "upload_id", session.ID)
+		return http.StatusInternalServerError, "Internal server error"
+	}
+
+	// Read one byte past the declared length so oversized bodies are detected
+	remaining := session.TotalSize - start
+	var dst io.Writer = data
+	if checksum != nil {
+		dst = io.MultiWriter(data, checksum)
+	}
+	written, copyErr := io.Copy(dst, io.LimitReader(r.Body, remaining+1))
+
+	discard := func() {
+		if err := data.Truncate(start); err != nil {
+			logger.Error("failed to truncate tus data file", "error", err, "upload_id", session.ID)
+		}
+	}
+	switch {
+	case written > remaining:
+		discard()
+		return http.StatusRequestEntityTooLarge, "Upload exceeds Upload-Length"
+	case checksum != nil && copyErr != nil:
+		discard()
+		return http.StatusBadRequest, "Failed to read request body"
+	case checksum != nil && !bytes.Equal(checksum.Sum(nil), expected):
+		discard()
+		return statusChecksumMismatch, "Checksum mismatch"
+	}
+
+	if written > 0 {
+		if err := h.db.Model(session).Updates(map[string]interface{}{
+			"upload_offset": start + written,
+			"updated_at":    time.Now(),
+		}).Error; err != nil {
+			logger.Error("failed to update upload offset", "error", err, "upload_id", session.ID)
+			discard()
+			return http.StatusInternalServerError, "Internal server error"
+		}
+		session.UploadOffset = start + written
+	}
+	if copyErr != nil {
+		logger.Debug("tus upload interrupted", "error", copyErr, "upload_id", session.ID, "offset", session.UploadOffset)
+		return http.StatusBadRequest, "Failed to read request body"
+	}
+
+	if session.UploadOffset < session.TotalSize {
+		return 0, ""
+	}
+
+	// All bytes received: hash the assembled data and store it
+	if _, err := data.Seek(0, io.SeekStart); err != nil {
+		return http.StatusInternalServerError, "Internal server error"
+	}
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, data); err != nil {
+		logger.Error("failed to hash tus upload", "error", err, "upload_id", session.ID)
+		return http.StatusInternalServerError, "Internal server error"
+	}
+	file, action, err := h.storeAssembledUpload(r.Context(), session, data, hex.EncodeToString(hasher.Sum(nil)))
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to upload file"
+	}
+	session.Status = "completed"
+	w.Header().Set(UploadActionHeader, action)
+
+	logger.Info("tus upload completed",
+		"upload_id", session.ID,
+		"file_id", file.ID,
+		"filename", session.Filename,
+		"size", session.TotalSize,
+		"action", action,
+	)
+	return 0, ""
+}
+
+// parseTusMetadata decodes an Upload-Metadata header: comma-separated pairs
+// of a key and a base64-encoded value (the value may be omitted).
+func parseTusMetadata(header string) (map[string]string, error) {
+	meta := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return meta, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("empty metadata key")
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("metadata %q: %w", key, err)
+		}
+		meta[key] = string(value)
+	}
+	return meta, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"crypto/sha1" //nolint:gosec
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/agjmills/trove/internal/database/models"
+)
+
+func setupTusTest(t *testing.T) (chi.Router, *UploadHandler, *models.User) {
+	t.Helper()
+	handler, _, user := setupUploadHandlerTest(t)
+	handler.cfg.TempDir = t.TempDir()
+
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, withUser(r, user))
+		})
+	})
+	r.Options(TusPrefix, handler.TusOptions)
+	r.Post(TusPrefix, handler.TusCreate)
+	r.Head(TusPrefix+"/{id}", handler.TusHead)
+	r.Patch(TusPrefix+"/{id}", handler.TusPatch)
+	r.Delete(TusPrefix+"/{id}", handler.TusDelete)
+	return r, handler, user
+}
+
+func tusRequest(t *testing.T, router http.Handler, method, target, body string, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func tusMetadata(pairs ...string) string {
+	var parts []string
+	for i := 0; i < len(pairs); i += 2 {
+		parts = append(parts, pairs[i]+" "+base64.StdEncoding.EncodeToString([]byte(pairs[i+1])))
+	}
+	return strings.Join(parts, ",")
+}
+
+func TestTusOptions(t *testing.T) {
+	router, _, _ := setupTusTest(t)
+
+	w := tusRequest(t, router, http.MethodOptions, TusPrefix, "", nil)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", w.Code)
+	}
+	if ext := w.Header().Get("Tus-Extension"); !strings.Contains(ext, "creation") || !strings.Contains(ext, "checksum") {
+		t.Errorf("Unexpected Tus-Extension %q", ext)
+	}
+}
+
+func TestTusUpload_ResumeAndComplete(t *testing.T) {
+	router, handler, user := setupTusTest(t)
+
+	w := tusRequest(t, router, http.MethodPost, TusPrefix, "", map[string]string{
+		"Upload-Length":   "11",
+		"Upload-Metadata": tusMetadata("filename", "hello.txt", "folder", "/docs", "tags", "a, b"),
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	location := w.Header().Get("Location")
+	if !strings.HasPrefix(location, TusPrefix+"/") || w.Header().Get("Upload-Expires") == "" {
+		t.Fatalf("Unexpected creation headers %v", w.Header())
+	}
+
+	patch := func(offset, body string) *httptest.ResponseRecorder {
+		return tusRequest(t, router, http.MethodPatch, location, body, map[string]string{
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": offset,
+		})
+	}
+
+	if w := patch("0", "hello "); w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != "6" {
+		t.Fatalf("Expected 204 at offset 6, got %d %q: %s", w.Code, w.Header().Get("Upload-Offset"), w.Body.String())
+	}
+
+	// A client resuming from a stale offset is rejected
+	if w := patch("0", "hello "); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for offset mismatch, got %d", w.Code)
+	}
+
+	w = tusRequest(t, router, http.MethodHead, location, "", nil)
+	if w.Code != http.StatusOK || w.Header().Get("Upload-Offset") != "6" || w.Header().Get("Upload-Length") != "11" {
+		t.Fatalf("Unexpected HEAD response %d %v", w.Code, w.Header())
+	}
+
+	if w := patch("6", "world"); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var file models.File
+	if err := handler.db.Where("filename = ?", "hello.txt").First(&file).Error; err != nil {
+		t.Fatalf("Expected file record: %v", err)
+	}
+	if file.LogicalPath != "/docs" || file.FileSize != 11 || file.MimeType != "text/plain; charset=utf-8" {
+		t.Errorf("Unexpected file %+v", file)
+	}
+	if tags := file.Tags.Data(); len(tags) != 2 || tags[1] != "b" {
+		t.Errorf("Expected tags [a b], got %v", tags)
+	}
+	reader, err := handler.storage.Open(t.Context(), file.StoragePath)
+	if err != nil {
+		t.Fatalf("Open stored file: %v", err)
+	}
+	defer reader.Close() //nolint:errcheck
+	if content, _ := io.ReadAll(reader); string(content) != "hello world" {
+		t.Errorf("Unexpected stored content %q", content)
+	}
+
+	var updated models.User
+	handler.db.First(&updated, user.ID)
+	if updated.StorageUsed != 11 {
+		t.Errorf("Expected storage_used 11, got %d", updated.StorageUsed)
+	}
+}
+
+func TestTusUpload_Checksum(t *testing.T) {
+	router, _, _ := setupTusTest(t)
+
+	w := tusRequest(t, router, http.MethodPost, TusPrefix, "", map[string]string{
+		"Upload-Length":   "4",
+		"Upload-Metadata": tusMetadata("filename", "a.bin"),
+	})
+	location := w.Header().Get("Location")
+
+	sum := sha1.Sum([]byte("data")) //nolint:gosec
+	headers := map[string]string{
+		"Content-Type":    "application/offset+octet-stream",
+		"Upload-Offset":   "0",
+		"Upload-Checksum": "sha1 " + base64.StdEncoding.EncodeToString(sum[:]),
+	}
+	if w := tusRequest(t, router, http.MethodPatch, location, "dat!", headers); w.Code != 460 {
+		t.Fatalf("Expected 460 checksum mismatch, got %d", w.Code)
+	}
+	if w := tusRequest(t, router, http.MethodHead, location, "", nil); w.Header().Get("Upload-Offset") != "0" {
+		t.Errorf("Rejected bytes should be discarded, offset is %s", w.Header().Get("Upload-Offset"))
+	}
+	if w := tusRequest(t, router, http.MethodPatch, location, "data", headers); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestTusCreate_Errors(t *testing.T) {
+	router, _, _ := setupTusTest(t)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  int
+	}{
+		{"wrong version", map[string]string{"Tus-Resumable": "0.2.2", "Upload-Length": "1", "Upload-Metadata": tusMetadata("filename", "a")}, http.StatusPreconditionFailed},
+		{"missing length", map[string]string{"Upload-Metadata": tusMetadata("filename", "a")}, http.StatusBadRequest},
+		{"missing filename", map[string]string{"Upload-Length": "1"}, http.StatusBadRequest},
+		{"traversal in folder", map[string]string{"Upload-Length": "1", "Upload-Metadata": tusMetadata("filename", "a", "folder", "../etc")}, http.StatusBadRequest},
+		{"over quota", map[string]string{"Upload-Length": "999999999999", "Upload-Metadata": tusMetadata("filename", "a")}, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := tusRequest(t, router, http.MethodPost, TusPrefix, "", tt.headers); w.Code != tt.status {
+				t.Errorf("Expected %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestTusDelete(t *testing.T) {
+	router, handler, _ := setupTusTest(t)
+
+	w := tusRequest(t, router, http.MethodPost, TusPrefix, "abc", map[string]string{
+		"Upload-Length":   "10",
+		"Upload-Metadata": tusMetadata("filename", "a.txt"),
+		"Content-Type":    "application/offset+octet-stream",
+	})
+	if w.Code != http.StatusCreated || w.Header().Get("Upload-Offset") != "3" {
+		t.Fatalf("Expected creation-with-upload to accept 3 bytes, got %d %q", w.Code, w.Header().Get("Upload-Offset"))
+	}
+	location := w.Header().Get("Location")
+
+	if w := tusRequest(t, router, http.MethodDelete, location, "", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", w.Code)
+	}
+	if w := tusRequest(t, router, http.MethodHead, location, "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 after termination, got %d", w.Code)
+	}
+
+	var session models.UploadSession
+	handler.db.First(&session, "id = ?", strings.TrimPrefix(location, TusPrefix+"/"))
+	if session.Status != "canceled" {
+		t.Errorf("Expected canceled session, got %q", session.Status)
+	}
+}
+
+func TestTusLocks_DroppedByCleanup(t *testing.T) {
+	router, handler, _ := setupTusTest(t)
+
+	w := tusRequest(t, router, http.MethodPost, TusPrefix, "", map[string]string{
+		"Upload-Length":   "10",
+		"Upload-Metadata": tusMetadata("filename", "abandoned.txt"),
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d", w.Code)
+	}
+	location := w.Header().Get("Location")
+	uploadID := strings.TrimPrefix(location, TusPrefix+"/")
+	patch := map[string]string{"Upload-Offset": "0", "Content-Type": "application/offset+octet-stream"}
+	if w := tusRequest(t, router, http.MethodPatch, location, "abc", patch); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", w.Code)
+	}
+	if w := tusRequest(t, router, http.MethodPatch, TusPrefix+"/no-such-upload", "abc", patch); w.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404 for an unknown upload, got %d", w.Code)
+	}
+
+	// The client goes away and the session expires
+	handler.db.Model(&models.UploadSession{}).Where("id = ?", uploadID).Update("expires_at", time.Now().Add(-time.Hour))
+	if err := NewUploadHandler(handler.db, handler.cfg, handler.storage).CleanupExpiredSessions(); err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+
+	for _, id := range []string{uploadID, "no-such-upload"} {
+		if _, ok := tusLocks.Load(id); ok {
+			t.Errorf("lock for %s kept after cleanup", id)
+		}
+	}
+}
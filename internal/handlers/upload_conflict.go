@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/agjmills/trove/internal/database/models"
+)
+
+// ConflictHeader names an upload's conflict policy for clients that cannot
+// set the on_conflict form field, JSON field or tus metadata key.
+const ConflictHeader = "X-Conflict-Policy"
+
+// UploadActionHeader reports what an upload did, as the "action" field does
+// in JSON responses.
+const UploadActionHeader = "X-Upload-Action"
+
+// conflictPolicy is what an upload does when its folder already has a file
+// with the same name.
+type conflictPolicy string
+
+const (
+	// conflictDefault adds a version if the owner has versioning enabled,
+	// and keeps both files otherwise.
+	conflictDefault  conflictPolicy = ""
+	conflictReplace  conflictPolicy = "replace"   // Trash the existing file, or add a version with versioning enabled
+	conflictKeepBoth conflictPolicy = "keep_both" // Store the upload as "name (1).ext"
+	conflictSkip     conflictPolicy = "skip"      // Keep the existing file and discard the upload
+)
+
+// Upload actions, as reported to the client.
+const (
+	actionCreated   = "created"   // No file had the name
+	actionRenamed   = "renamed"   // Stored under a new name next to the existing file
+	actionReplaced  = "replaced"  // The existing file was moved to deleted items
+	actionVersioned = "versioned" // Added as a new version of the existing file
+	actionUnchanged = "unchanged" // Same content as the existing file, so no version was added
+	actionSkipped   = "skipped"   // Discarded, the existing file is kept
+	actionFailed    = "failed"    // Not stored; only reported for one file of a batch
+)
+
+var errConflictPolicy = errors.New(`on_conflict must be "replace", "keep_both" or "skip"`)
+
+// parseConflictPolicy parses the conflict policy in value, or in the
+// ConflictHeader if value is empty.
+func parseConflictPolicy(r *http.Request, value string) (conflictPolicy, error) {
+	if value == "" {
+		value = r.Header.Get(ConflictHeader)
+	}
+	switch policy := conflictPolicy(strings.ToLower(strings.TrimSpace(value))); policy {
+	case conflictDefault, conflictReplace, conflictKeepBoth, conflictSkip:
+		return policy, nil
+	case "keep-both":
+		return conflictKeepBoth, nil
+	}
+	return "", errConflictPolicy
+}
+
+// uploadPlan is what an upload does about the files already in its folder.
+type uploadPlan struct {
+	action   string
+	filename string       // Name to store a new file under
+	existing *models.File // File to add a version to, or kept in place of a skipped upload
+}
+
+// planUpload decides what an upload named filename into folder logicalPath
+// does under policy. A replacing upload is planned as actionReplaced;
+// trashNamesakes reports whether there was anything left to replace.
+func planUpload(db *gorm.DB, userID uint, logicalPath, filename string, policy conflictPolicy) uploadPlan {
+	if policy == conflictDefault || policy == conflictReplace {
+		if target := versionTarget(db, userID, logicalPath, filename); target != nil {
+			return uploadPlan{action: actionVersioned, filename: filename, existing: target}
+		}
+	}
+
+	switch policy {
+	case conflictReplace, conflictSkip:
+		existing := namesake(db, userID, logicalPath, filename)
+		switch {
+		case existing == nil:
+			return uploadPlan{action: actionCreated, filename: filename}
+		case policy == conflictSkip:
+			return uploadPlan{action: actionSkipped, filename: filename, existing: existing}
+		default:
+			return uploadPlan{action: actionReplaced, filename: filename}
+		}
+	}
+
+	unique := uniqueFilename(db, userID, logicalPath, filename)
+	if unique != filename {
+		return uploadPlan{action: actionRenamed, filename: unique}
+	}
+	return uploadPlan{action: actionCreated, filename: filename}
+}
+
+// namesake returns the file named filename in folder logicalPath that is not
+// in deleted items, or nil if there is none.
+func namesake(db *gorm.DB, userID uint, logicalPath, filename string) *models.File {
+	var files []models.File
+	if err := db.Where("user_id = ? AND logical_path = ? AND filename = ? AND trashed_at IS NULL", userID, logicalPath, filename).
+		Order("id").
+		Limit(1).
+		Find(&files).Error; err != nil || len(files) == 0 {
+		return nil
+	}
+	return &files[0]
+}
+
+// trashNamesakes moves the other completed files with file's name in its
+// folder to deleted items, as deleting them would, and reports whether there
+// were any. Uploads of the name still in progress are left alone.
+func trashNamesakes(tx *gorm.DB, file *models.File) (bool, error) {
+	var namesakes []models.File
+	if err := tx.Where("user_id = ? AND logical_path = ? AND filename = ? AND upload_status = ? AND trashed_at IS NULL AND id != ?",
+		file.UserID, file.LogicalPath, file.Filename, "completed", file.ID).
+		Find(&namesakes).Error; err != nil {
+		return false, err
+	}
+	now := time.Now()
+	for i := range namesakes {
+		if err := trashFile(tx, &namesakes[i], now); err != nil {
+			return false, err
+		}
+	}
+	return len(namesakes) > 0, nil
+}
+
+// uniqueFilename returns originalFilename, or "name (n).ext" if a file in
+// folder logicalPath, not counting deleted items, already has that name.
+func uniqueFilename(db *gorm.DB, userID uint, logicalPath, originalFilename string) string {
+	if namesake(db, userID, logicalPath, originalFilename) == nil {
+		return originalFilename
+	}
+
+	// File exists, find a unique name
+	ext := filepath.Ext(originalFilename)
+	nameWithoutExt := strings.TrimSuffix(originalFilename, ext)
+
+	for i := 1; i <= 10000; i++ {
+		newName := fmt.Sprintf("%s (%d)%s", nameWithoutExt, i, ext)
+		if namesake(db, userID, logicalPath, newName) == nil {
+			return newName
+		}
+	}
+
+	// Fallback: use UUID suffix if too many collisions
+	return fmt.Sprintf("%s (%s)%s", nameWithoutExt, uuid.New().String()[:8], ext)
+}
@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	csrf "filippo.io/csrf/gorilla"
+
+	"github.com/agjmills/trove/internal/auth"
+	"github.com/agjmills/trove/internal/database/models"
+)
+
+// chunkedUpload uploads content as name into the root folder in one chunk
+// under the conflict policy and returns the final JSON response.
+func chunkedUpload(t *testing.T, handler *UploadHandler, user *models.User, name, content, policy string) map[string]interface{} {
+	t.Helper()
+	body, _ := json.Marshal(InitUploadRequest{
+		Filename:    name,
+		TotalSize:   int64(len(content)),
+		ChunkSize:   int64(max(len(content), 1)),
+		TotalChunks: 1,
+		LogicalPath: "/",
+		MimeType:    "text/plain",
+		OnConflict:  policy,
+	})
+	w := sendUploadRequest(t, handler.InitUpload, user, "", "/api/uploads/init", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("InitUpload returned %d: %s", w.Code, w.Body.String())
+	}
+	var resp map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode init response: %v", err)
+	}
+	id, _ := resp["upload_id"].(string)
+	if id == "" {
+		return resp
+	}
+
+	if w := sendUploadRequest(t, handler.UploadChunk, user, id, "/api/uploads/"+id+"/chunk?chunk=0", []byte(content)); w.Code != http.StatusOK {
+		t.Fatalf("UploadChunk returned %d: %s", w.Code, w.Body.String())
+	}
+	w = sendUploadRequest(t, handler.CompleteUpload, user, id, "/api/uploads/"+id+"/complete", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("CompleteUpload returned %d: %s", w.Code, w.Body.String())
+	}
+	resp = nil
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode complete response: %v", err)
+	}
+	if got := w.Header().Get(UploadActionHeader); got != resp["action"] {
+		t.Errorf("%s = %q, want %q", UploadActionHeader, got, resp["action"])
+	}
+	return resp
+}
+
+func TestUploadConflict_Chunked(t *testing.T) {
+	handler, db, user := setupUploadHandlerTest(t)
+
+	first := chunkedUpload(t, handler, user, "a.txt", "one", "")
+	if first["action"] != actionCreated || first["filename"] != "a.txt" {
+		t.Fatalf("first upload = %v, want a.txt created", first)
+	}
+	firstID := uint(first["file_id"].(float64))
+
+	// Keeping both is the default without versioning
+	second := chunkedUpload(t, handler, user, "a.txt", "two", "")
+	if second["action"] != actionRenamed || second["filename"] != "a (1).txt" {
+		t.Errorf("default upload = %v, want it renamed to a (1).txt", second)
+	}
+
+	skipped := chunkedUpload(t, handler, user, "a.txt", "three", "skip")
+	if skipped["action"] != actionSkipped || uint(skipped["file_id"].(float64)) != firstID {
+		t.Errorf("skipped upload = %v, want the existing file %d reported", skipped, firstID)
+	}
+	var sessions int64
+	db.Model(&models.UploadSession{}).Count(&sessions)
+	if sessions != 2 {
+		t.Errorf("%d upload sessions, want none started for the skipped upload", sessions)
+	}
+
+	replaced := chunkedUpload(t, handler, user, "a.txt", "four", "replace")
+	if replaced["action"] != actionReplaced || replaced["filename"] != "a.txt" {
+		t.Fatalf("replacing upload = %v, want a.txt replaced", replaced)
+	}
+	var old models.File
+	db.First(&old, firstID)
+	if old.SoftDeletedAt == nil || old.OriginalLogicalPath != "/" {
+		t.Errorf("replaced file not moved to deleted items: %+v", old)
+	}
+	var live int64
+	db.Model(&models.File{}).Where("trashed_at IS NULL").Count(&live)
+	if live != 2 {
+		t.Errorf("%d files outside deleted items, want the new a.txt and a (1).txt", live)
+	}
+
+	// Replacing a name nobody has just creates the file
+	if resp := chunkedUpload(t, handler, user, "b.txt", "five", "replace"); resp["action"] != actionCreated {
+		t.Errorf("replacing upload of a new name = %v, want created", resp)
+	}
+}
+
+func TestUploadConflict_ReplaceLeavesUnfinishedUploads(t *testing.T) {
+	handler, db, user := setupUploadHandlerTest(t)
+
+	done := chunkedUpload(t, handler, user, "c.txt", "stored", "")
+	doneID := uint(done["file_id"].(float64))
+	// Another upload of the name is still being stored in the background
+	pending := models.File{UserID: user.ID, LogicalPath: "/", Filename: "c.txt", OriginalFilename: "c.txt",
+		StoragePath: "pending-c.txt", FileSize: 7, UploadStatus: "pending"}
+	db.Create(&pending)
+
+	replaced := chunkedUpload(t, handler, user, "c.txt", "replacement", "replace")
+	if replaced["action"] != actionReplaced {
+		t.Fatalf("replacing upload = %v, want c.txt replaced", replaced)
+	}
+	var old, unfinished models.File
+	db.First(&old, doneID)
+	if old.SoftDeletedAt == nil || old.OriginalLogicalPath != "/" {
+		t.Errorf("completed file not moved to deleted items: %+v", old)
+	}
+	db.First(&unfinished, pending.ID)
+	if unfinished.SoftDeletedAt != nil {
+		t.Error("upload still in progress was moved to deleted items")
+	}
+}
+
+func TestUploadConflict_ChunkedHeaderAndErrors(t *testing.T) {
+	handler, _, user := setupUploadHandlerTest(t)
+	chunkedUpload(t, handler, user, "a.txt", "one", "")
+
+	body, _ := json.Marshal(InitUploadRequest{Filename: "a.txt", TotalSize: 3, ChunkSize: 3, TotalChunks: 1, LogicalPath: "/"})
+	req := httptest.NewRequest(http.MethodPost, "/api/uploads/init", bytes.NewReader(body))
+	req.Header.Set(ConflictHeader, "skip")
+	w := httptest.NewRecorder()
+	handler.InitUpload(w, withUser(req, user))
+	if w.Code != http.StatusOK || w.Header().Get(UploadActionHeader) != actionSkipped {
+		t.Errorf("init with %s: skip = %d %q, want the upload skipped", ConflictHeader, w.Code, w.Header().Get(UploadActionHeader))
+	}
+
+	body, _ = json.Marshal(InitUploadRequest{Filename: "a.txt", TotalSize: 3, ChunkSize: 3, TotalChunks: 1, OnConflict: "overwrite"})
+	if w := sendUploadRequest(t, handler.InitUpload, user, "", "/api/uploads/init", body); w.Code != http.StatusBadRequest {
+		t.Errorf("init with an unknown policy = %d, want 400", w.Code)
+	}
+}
+
+func TestUploadConflict_SkipDecidedOnCompletion(t *testing.T) {
+	handler, db, user := setupUploadHandlerTest(t)
+
+	id := initMultipartUpload(t, handler, user, InitUploadRequest{Filename: "a.txt", TotalSize: 3, ChunkSize: 3, TotalChunks: 1, LogicalPath: "/", OnConflict: "skip"})
+	if w := sendUploadRequest(t, handler.UploadChunk, user, id, "/api/uploads/"+id+"/chunk?chunk=0", []byte("new")); w.Code != http.StatusOK {
+		t.Fatalf("UploadChunk returned %d: %s", w.Code, w.Body.String())
+	}
+
+	// Another upload takes the name while this one is in progress
+	existing := chunkedUpload(t, handler, user, "a.txt", "old", "")
+
+	w := sendUploadRequest(t, handler.CompleteUpload, user, id, "/api/uploads/"+id+"/complete", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("CompleteUpload returned %d: %s", w.Code, w.Body.String())
+	}
+	var resp map[string]interface{}
+	_ = json.NewDecoder(w.Body).Decode(&resp)
+	if resp["action"] != actionSkipped || resp["file_id"] != existing["file_id"] {
+		t.Errorf("complete = %v, want skipped in favour of file %v", resp, existing["file_id"])
+	}
+	var count int64
+	db.Model(&models.File{}).Count(&count)
+	if count != 1 {
+		t.Errorf("%d files, want only the existing one", count)
+	}
+	var session models.UploadSession
+	db.First(&session, "id = ?", id)
+	if session.Status != "completed" {
+		t.Errorf("session status = %q, want completed", session.Status)
+	}
+}
+
+func TestUploadConflict_Versioning(t *testing.T) {
+	env := setupVersioningTest(t, 1000)
+	if err := env.db.AutoMigrate(&models.UploadSession{}); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+	env.handler.cfg.UploadSessionTimeout = time.Hour
+	handler := NewUploadHandler(env.db, env.handler.cfg, env.handler.storage)
+	var user models.User
+	env.db.First(&user, env.userID)
+
+	env.expect(t, env.do(t, http.MethodPut, "/dav/a.txt", "one", nil), http.StatusCreated)
+	original := env.file(t, "a.txt")
+
+	// Replacing keeps the old content as a version
+	resp := chunkedUpload(t, handler, &user, "a.txt", "two!", "replace")
+	if resp["action"] != actionVersioned || uint(resp["file_id"].(float64)) != original.ID || resp["version"].(float64) != 2 {
+		t.Errorf("replacing upload = %v, want version 2 of file %d", resp, original.ID)
+	}
+	if resp := chunkedUpload(t, handler, &user, "a.txt", "two!", ""); resp["action"] != actionUnchanged {
+		t.Errorf("upload of the current content = %v, want unchanged", resp)
+	}
+
+	// Keeping both stores a copy even with versioning enabled
+	if resp := chunkedUpload(t, handler, &user, "a.txt", "three", "keep_both"); resp["action"] != actionRenamed || resp["filename"] != "a (1).txt" {
+		t.Errorf("keep_both upload = %v, want a (1).txt", resp)
+	}
+}
+
+func TestUploadConflict_Multipart(t *testing.T) {
+	app := newFileTestApp(t)
+	user := app.createTestUser(t, "conflictuser")
+	existing := app.createTestFile(t, user, "doc.txt", "Content 1")
+
+	upload := func(policy string) *httptest.ResponseRecorder {
+		t.Helper()
+		var buf bytes.Buffer
+		writer := multipart.NewWriter(&buf)
+		_ = writer.WriteField("folder", "/")
+		_ = writer.WriteField("on_conflict", policy)
+		part, _ := writer.CreateFormFile("file", "doc.txt")
+		_, _ = part.Write([]byte("Content 2"))
+		_ = writer.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/upload", &buf)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req = csrf.UnsafeSkipCheck(req.WithContext(context.WithValue(req.Context(), auth.UserContextKey, user)))
+		w := httptest.NewRecorder()
+		app.fileHandler.Upload(w, req)
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("Upload with %q returned %d: %s", policy, w.Code, w.Body.String())
+		}
+		return w
+	}
+
+	if w := upload("skip"); w.Header().Get(UploadActionHeader) != actionSkipped {
+		t.Errorf("skip: %s = %q", UploadActionHeader, w.Header().Get(UploadActionHeader))
+	}
+	var count int64
+	app.db.Model(&models.File{}).Where("user_id = ?", user.ID).Count(&count)
+	if count != 1 {
+		t.Fatalf("%d files after a skipped upload, want 1", count)
+	}
+
+	if w := upload("replace"); w.Header().Get(UploadActionHeader) != actionReplaced {
+		t.Errorf("replace: %s = %q", UploadActionHeader, w.Header().Get(UploadActionHeader))
+	}
+	var files []models.File
+	app.db.Where("user_id = ?", user.ID).Order("id").Find(&files)
+	if len(files) != 2 || files[0].ID != existing.ID || files[0].SoftDeletedAt == nil {
+		t.Fatalf("files = %+v, want the existing file in deleted items", files)
+	}
+	if files[1].Filename != "doc.txt" || files[1].SoftDeletedAt != nil {
+		t.Errorf("new file = %q (trashed %v), want doc.txt", files[1].Filename, files[1].SoftDeletedAt != nil)
+	}
+
+	if w := upload("keep_both"); w.Header().Get(UploadActionHeader) != actionRenamed {
+		t.Errorf("keep_both: %s = %q", UploadActionHeader, w.Header().Get(UploadActionHeader))
+	}
+}
@@ -5,15 +5,18 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -27,7 +30,6 @@ import (
 	"github.com/agjmills/trove/internal/database/models"
 	"github.com/agjmills/trove/internal/logger"
 	"github.com/agjmills/trove/internal/storage"
-	"github.com/agjmills/trove/internal/transcode"
 )
 
 type UploadHandler struct {
@@ -36,6 +38,11 @@ type UploadHandler struct {
 	storage storage.StorageBackend
 }
 
+// tusLocks maps upload IDs to the *sync.Mutex that serializes writes to a tus
+// upload. It is shared by every UploadHandler so the cleanup worker, which
+// has its own handler, can drop the locks of sessions it expires.
+var tusLocks sync.Map
+
 func NewUploadHandler(db *gorm.DB, cfg *config.Config, storage storage.StorageBackend) *UploadHandler {
 	return &UploadHandler{
 		db:      db,
@@ -54,6 +61,11 @@ type InitUploadRequest struct {
 	MimeType    string   `json:"mime_type"`
 	Hash        string   `json:"hash,omitempty"` // Optional client-side hash for verification
 	Tags        []string `json:"tags,omitempty"`
+	OnConflict  string   `json:"on_conflict,omitempty"` // replace, keep_both or skip; see conflictPolicy
+
+	// RelativePath is the file's path within a folder being uploaded, such
+	// as "photos/2024/beach.jpg". Its folders are created under LogicalPath.
+	RelativePath string `json:"relative_path,omitempty"`
 }
 
 // InitUploadResponse represents the response after initializing an upload
@@ -107,6 +119,54 @@ func normalizeLogicalPath(p string) string {
 	return cleanPath
 }
 
+// normalizeUploadTags strips whitespace, drops empty tags and enforces the tag
+// limits. A non-empty message is returned when the tags are rejected.
+func normalizeUploadTags(tags []string) ([]string, string) {
+	if len(tags) > 50 {
+		return nil, "Too many tags (max 50)"
+	}
+	normalized := tags[:0]
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+		if len(tag) > 64 {
+			return nil, "Tag too long (max 64 characters)"
+		}
+		normalized = append(normalized, tag)
+	}
+	return normalized, ""
+}
+
+// quotaExceeded reports whether size more bytes would take user past their
+// storage quota. Uploads the user still has in progress count towards it, so
+// the files of a folder upload, started one after another before any of
+// them completes, are held to the quota as a whole.
+func quotaExceeded(db *gorm.DB, user *models.User, size int64) bool {
+	var inProgress int64
+	db.Model(&models.UploadSession{}).
+		Select("COALESCE(SUM(total_size), 0)").
+		Where("user_id = ? AND status = ? AND expires_at > ?", user.ID, "active", time.Now()).
+		Scan(&inProgress)
+	return user.StorageUsed+inProgress+size > user.StorageQuota
+}
+
+// createUploadTempDir creates the per-session temporary directory.
+// Use configured TempDir if set, otherwise fall back to system temp directory
+func (h *UploadHandler) createUploadTempDir(uploadID string) (string, error) {
+	baseTempDir := h.cfg.TempDir
+	if baseTempDir == "" {
+		baseTempDir = os.TempDir()
+	}
+	tempDir := filepath.Join(baseTempDir, "trove-uploads", uploadID)
+	if err := os.MkdirAll(tempDir, 0700); err != nil {
+		logger.Error("failed to create temp directory", "error", err, "dir", tempDir)
+		return "", err
+	}
+	return tempDir, nil
+}
+
 // InitUpload initializes a new chunked upload session
 func (h *UploadHandler) InitUpload(w http.ResponseWriter, r *http.Request) {
 	user := auth.GetUser(r)
@@ -124,7 +184,7 @@ func (h *UploadHandler) InitUpload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate request
-	if req.Filename == "" || req.TotalSize <= 0 || req.ChunkSize <= 0 || req.TotalChunks <= 0 {
+	if req.Filename == "" || req.TotalSize < 0 || req.ChunkSize <= 0 || req.TotalChunks <= 0 {
 		http.Error(w, "Invalid upload parameters", http.StatusBadRequest)
 		return
 	}
@@ -135,68 +195,80 @@ func (h *UploadHandler) InitUpload(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid logical path", http.StatusBadRequest)
 		return
 	}
+	req.LogicalPath = relativeUploadFolder(req.LogicalPath, req.RelativePath)
 
 	// Normalize tags: strip whitespace, drop empties, enforce limits
-	if len(req.Tags) > 50 {
-		http.Error(w, "Too many tags (max 50)", http.StatusBadRequest)
+	tags, msg := normalizeUploadTags(req.Tags)
+	if msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
 		return
 	}
-	normalizedTags := req.Tags[:0]
-	for _, tag := range req.Tags {
-		tag = strings.TrimSpace(tag)
-		if tag == "" {
-			continue
-		}
-		if len(tag) > 64 {
-			http.Error(w, "Tag too long (max 64 characters)", http.StatusBadRequest)
+	req.Tags = tags
+
+	policy, err := parseConflictPolicy(r, req.OnConflict)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// A skipped upload is known before any data is sent
+	if policy == conflictSkip {
+		if plan := planUpload(h.db, userID, req.LogicalPath, req.Filename, policy); plan.action == actionSkipped {
+			writeUploadResult(w, plan.existing, actionSkipped)
 			return
 		}
-		normalizedTags = append(normalizedTags, tag)
 	}
-	req.Tags = normalizedTags
 
 	// Check user quota
-	if user.StorageUsed+req.TotalSize > user.StorageQuota {
+	if quotaExceeded(h.db, user, req.TotalSize) {
 		http.Error(w, "Storage quota exceeded", http.StatusForbidden)
 		return
 	}
+	if !checkDiskSpace(w, h.cfg, req.TotalSize) {
+		return
+	}
 
 	// Create temporary directory for chunks
-	// Use configured TempDir if set, otherwise fall back to system temp directory
 	uploadID := uuid.New().String()
-	baseTempDir := h.cfg.TempDir
-	if baseTempDir == "" {
-		baseTempDir = os.TempDir()
+	tempDir, err := h.createUploadTempDir(uploadID)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
 	}
-	tempDir := filepath.Join(baseTempDir, "trove-uploads", uploadID)
-	if err := os.MkdirAll(tempDir, 0700); err != nil {
-		logger.Error("failed to create temp directory", "error", err, "dir", tempDir)
+
+	// Send chunks straight to storage if the backend can take them as parts
+	storageUploadID, err := h.initStorageUpload(r.Context(), userID, &req)
+	if err != nil {
+		logger.Error("failed to start storage upload", "error", err)
+		_ = os.RemoveAll(tempDir)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
 	// Create upload session
 	session := models.UploadSession{
-		ID:             uploadID,
-		UserID:         userID,
-		Filename:       req.Filename,
-		LogicalPath:    req.LogicalPath,
-		TotalSize:      req.TotalSize,
-		TotalChunks:    req.TotalChunks,
-		ChunkSize:      req.ChunkSize,
-		ReceivedChunks: 0,
-		ChunksReceived: []byte("[]"), // Empty JSON array
-		Status:         "active",
-		Hash:           req.Hash,
-		MimeType:       req.MimeType,
-		Tags:           datatypes.NewJSONType(req.Tags),
-		TempDir:        tempDir,
-		ExpiresAt:      time.Now().Add(h.cfg.UploadSessionTimeout),
+		ID:              uploadID,
+		UserID:          userID,
+		Filename:        req.Filename,
+		LogicalPath:     req.LogicalPath,
+		TotalSize:       req.TotalSize,
+		TotalChunks:     req.TotalChunks,
+		ChunkSize:       req.ChunkSize,
+		ReceivedChunks:  0,
+		ChunksReceived:  []byte("[]"), // Empty JSON array
+		Status:          "active",
+		Hash:            req.Hash,
+		MimeType:        req.MimeType,
+		Tags:            datatypes.NewJSONType(req.Tags),
+		TempDir:         tempDir,
+		ConflictPolicy:  string(policy),
+		StorageUploadID: storageUploadID,
+		ExpiresAt:       time.Now().Add(h.cfg.UploadSessionTimeout),
 	}
 
 	if err := h.db.Create(&session).Error; err != nil {
 		logger.Error("failed to create upload session", "error", err)
 		_ = os.RemoveAll(tempDir) // Clean up temp dir
+		h.abortStorageUpload(&session)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
@@ -242,6 +314,9 @@ func (h *UploadHandler) UploadChunk(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid chunk number", http.StatusBadRequest)
 		return
 	}
+	if !checkDiskSpace(w, h.cfg, r.ContentLength) {
+		return
+	}
 
 	// Get upload session
 	var session models.UploadSession
@@ -278,29 +353,49 @@ func (h *UploadHandler) UploadChunk(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Save chunk to temp file first (before acquiring lock)
-	chunkPath := filepath.Join(session.TempDir, fmt.Sprintf("chunk_%d", chunkNum))
-	chunkFile, err := os.Create(chunkPath)
-	if err != nil {
-		logger.Error("failed to create chunk file", "error", err, "path", chunkPath)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-	defer chunkFile.Close() //nolint:errcheck
+	// Save chunk to storage or a temp file first (before acquiring lock)
+	chunkPath := chunkFilePath(&session, chunkNum)
+	var state []byte
+	if session.StorageUploadID != "" {
+		state, err = h.sendChunk(r.Context(), &session, chunkNum, r.Body)
+		if errors.Is(err, errChunkSize) {
+			http.Error(w, fmt.Sprintf("Chunk %d must be %d bytes", chunkNum, expectedChunkSize(&session, chunkNum)), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			logger.Error("failed to send chunk to storage", "error", err, "upload_id", uploadID, "chunk", chunkNum)
+			http.Error(w, "Failed to save chunk", http.StatusInternalServerError)
+			return
+		}
 
-	written, err := io.Copy(chunkFile, r.Body)
-	if err != nil {
-		logger.Error("failed to write chunk", "error", err)
-		_ = os.Remove(chunkPath)
-		http.Error(w, "Failed to save chunk", http.StatusInternalServerError)
-		return
-	}
+		logger.Debug("chunk sent to storage",
+			"upload_id", uploadID,
+			"chunk", chunkNum,
+			"hashed", state != nil,
+		)
+	} else {
+		chunkFile, err := os.Create(chunkPath)
+		if err != nil {
+			logger.Error("failed to create chunk file", "error", err, "path", chunkPath)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		defer chunkFile.Close() //nolint:errcheck
 
-	logger.Debug("chunk saved",
-		"upload_id", uploadID,
-		"chunk", chunkNum,
-		"size", written,
-	)
+		written, err := io.Copy(chunkFile, r.Body)
+		if err != nil {
+			logger.Error("failed to write chunk", "error", err)
+			_ = os.Remove(chunkPath)
+			http.Error(w, "Failed to save chunk", http.StatusInternalServerError)
+			return
+		}
+
+		logger.Debug("chunk saved",
+			"upload_id", uploadID,
+			"chunk", chunkNum,
+			"size", written,
+		)
+	}
 
 	// Use a database transaction with SELECT ... FOR UPDATE to prevent lost updates
 	// This locks the row during the read-modify-write sequence
@@ -320,11 +415,13 @@ func (h *UploadHandler) UploadChunk(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Check if chunk already received (idempotent)
-		for _, num := range chunksReceived {
-			if num == chunkNum {
-				// Chunk already received, nothing to update
-				return nil
+		if slices.Contains(chunksReceived, chunkNum) {
+			// Chunk already received; a resend may still be the one
+			// the hash is waiting for
+			if lockedSession.StorageUploadID != "" {
+				return advanceHash(tx, &lockedSession, chunksReceived, chunkNum, state)
 			}
+			return nil
 		}
 
 		// Append new chunk and update
@@ -337,7 +434,13 @@ func (h *UploadHandler) UploadChunk(w http.ResponseWriter, r *http.Request) {
 			"updated_at":      time.Now(),
 		}
 
-		return tx.Model(&lockedSession).Updates(updates).Error
+		if err := tx.Model(&lockedSession).Updates(updates).Error; err != nil {
+			return err
+		}
+		if lockedSession.StorageUploadID != "" {
+			return advanceHash(tx, &lockedSession, chunksReceived, chunkNum, state)
+		}
+		return nil
 	})
 
 	if err != nil {
@@ -355,7 +458,8 @@ func (h *UploadHandler) UploadChunk(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// CompleteUpload finalizes the upload by assembling chunks and storing the file
+// CompleteUpload finalizes the upload by assembling chunks and storing the file,
+// or by having the storage backend join the chunks it was sent
 func (h *UploadHandler) CompleteUpload(w http.ResponseWriter, r *http.Request) {
 	user := auth.GetUser(r)
 	if user == nil {
@@ -412,6 +516,17 @@ func (h *UploadHandler) CompleteUpload(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Chunks already sent to storage are joined there
+	if session.StorageUploadID != "" {
+		file, action, status, msg := h.completeStorageUpload(r.Context(), &session)
+		if file == nil {
+			http.Error(w, msg, status)
+			return
+		}
+		writeUploadCompleted(w, &session, file, action)
+		return
+	}
+
 	// Create final file by assembling chunks
 	finalPath := filepath.Join(session.TempDir, "complete")
 	finalFile, err := os.Create(finalPath)
@@ -473,91 +588,184 @@ func (h *UploadHandler) CompleteUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	file, action, err := h.storeAssembledUpload(r.Context(), &session, finalFile, calculatedHash)
+	if err != nil {
+		http.Error(w, "Failed to upload file", http.StatusInternalServerError)
+		return
+	}
+	writeUploadCompleted(w, &session, file, action)
+}
+
+// writeUploadCompleted responds to a completed chunked upload.
+func writeUploadCompleted(w http.ResponseWriter, session *models.UploadSession, file *models.File, action string) {
+	logger.Info("upload completed",
+		"upload_id", session.ID,
+		"file_id", file.ID,
+		"filename", session.Filename,
+		"size", session.TotalSize,
+		"action", action,
+	)
+	writeUploadResult(w, file, action)
+}
+
+// writeUploadResult responds with the file an upload stored, or the file it
+// was skipped for, and the action it took.
+func writeUploadResult(w http.ResponseWriter, file *models.File, action string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(UploadActionHeader, action)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"file_id":  file.ID,
+		"filename": file.Filename,
+		"size":     file.FileSize,
+		"hash":     file.Hash,
+		"version":  file.Version,
+		"action":   action,
+	})
+}
+
+// storeAssembledUpload saves a fully received upload to the storage backend
+// (or reuses an object with the same content) and records it with
+// recordUpload, unless its conflict policy skips it. It returns the file and
+// the action taken, and logs its own failures; callers only need to report
+// them.
+func (h *UploadHandler) storeAssembledUpload(ctx context.Context, session *models.UploadSession, data *os.File, hash string) (*models.File, string, error) {
+	plan := h.planSessionUpload(session)
+	if plan.action == actionSkipped {
+		h.completeSession(session)
+		return plan.existing, actionSkipped, nil
+	}
+
 	// Upload to storage backend first to get the generated path
 	// Reset file pointer before saving to storage
-	if _, err := finalFile.Seek(0, 0); err != nil {
+	if _, err := data.Seek(0, 0); err != nil {
 		logger.Error("failed to seek to beginning of file",
 			"error", err,
-			"upload_id", uploadID,
+			"upload_id", session.ID,
 			"filename", session.Filename,
 		)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+		return nil, "", err
 	}
-	saveResult, err := h.storage.Save(r.Context(), finalFile, storage.SaveOptions{
+	storagePath, deduplicated, err := saveBlob(ctx, h.db, h.storage, blobScope(h.cfg, session.UserID), hash, data, storage.SaveOptions{
 		OriginalFilename: session.Filename,
 		ContentType:      session.MimeType,
+		Policy:           storagePolicy(h.db, h.storage, session.UserID, session.LogicalPath),
 	})
 	if err != nil {
 		logger.Error("failed to upload to storage", "error", err)
-		http.Error(w, "Failed to upload file", http.StatusInternalServerError)
-		return
+		return nil, "", err
+	}
+	if deduplicated {
+		logger.Info("deduplicated upload", "upload_id", session.ID, "path", storagePath)
+	}
+	return h.recordUpload(ctx, session, plan, storagePath, deduplicated, hash)
+}
+
+// planSessionUpload is planUpload for an upload session.
+func (h *UploadHandler) planSessionUpload(session *models.UploadSession) uploadPlan {
+	return planUpload(h.db, session.UserID, session.LogicalPath, session.Filename, conflictPolicy(session.ConflictPolicy))
+}
+
+// completeSession marks a session completed without storing a file, and
+// removes its temp directory.
+func (h *UploadHandler) completeSession(session *models.UploadSession) {
+	h.db.Model(session).Update("status", "completed")
+	go func() {
+		if err := os.RemoveAll(session.TempDir); err != nil {
+			logger.Error("failed to clean up temp directory", "error", err, "dir", session.TempDir)
+		}
+	}()
+}
+
+// recordUpload creates the file record for an upload stored at storagePath
+// and any folders above it that are missing, and charges the owner's quota,
+// in one transaction, then marks the session completed and removes its temp
+// directory. If the record cannot be created, the reference on storagePath
+// is dropped. plan, from
+// planSessionUpload, names the file and says whether it replaces an
+// existing one; a versioned upload becomes a new version of plan.existing
+// instead. It returns the file and the action taken.
+func (h *UploadHandler) recordUpload(ctx context.Context, session *models.UploadSession, plan uploadPlan, storagePath string, deduplicated bool, hash string) (*models.File, string, error) {
+	if plan.action == actionVersioned {
+		return h.recordVersion(ctx, session, plan.existing, storagePath, deduplicated, hash)
 	}
 
 	// Create file record with storage-generated path
 	// Create directly with "completed" status to avoid inconsistency window
 	file := models.File{
-		UserID:           userID,
-		StoragePath:      saveResult.Path,
+		UserID:           session.UserID,
+		StoragePath:      storagePath,
 		LogicalPath:      session.LogicalPath,
-		Filename:         session.Filename,
+		Filename:         plan.filename,
 		OriginalFilename: session.Filename,
-		FileSize:         saveResult.Size,
+		FileSize:         session.TotalSize,
 		MimeType:         session.MimeType,
-		Hash:             calculatedHash,
+		Hash:             hash,
 		UploadStatus:     "completed",
 		Tags:             session.Tags,
 	}
 
 	// Use a transaction to atomically create file record and update storage_used
 	// This prevents quota drift if one operation succeeds and the other fails
+	action := plan.action
 	txErr := h.db.Transaction(func(tx *gorm.DB) error {
+		// Folders of a folder upload appear in the tree along with the file
+		if err := ensureFolders(tx, session.UserID, session.LogicalPath); err != nil {
+			return fmt.Errorf("failed to create folders: %w", err)
+		}
+
 		// Create the file record
 		if err := tx.Create(&file).Error; err != nil {
 			return fmt.Errorf("failed to create file record: %w", err)
 		}
 
 		// Update user storage usage atomically within the same transaction
-		if err := tx.Model(&models.User{}).Where("id = ?", userID).
+		if err := tx.Model(&models.User{}).Where("id = ?", session.UserID).
 			UpdateColumn("storage_used", gorm.Expr("storage_used + ?", session.TotalSize)).Error; err != nil {
 			return fmt.Errorf("failed to update user storage usage: %w", err)
 		}
 
+		// A replaced file goes to deleted items
+		if action == actionReplaced {
+			replaced, err := trashNamesakes(tx, &file)
+			if err != nil {
+				return fmt.Errorf("failed to trash replaced file: %w", err)
+			}
+			if !replaced {
+				action = actionCreated
+			}
+		}
+
 		return nil
 	})
 
 	if txErr != nil {
-		logger.Error("failed to complete upload transaction", "error", txErr, "user_id", userID, "filename", session.Filename)
+		logger.Error("failed to complete upload transaction", "error", txErr, "user_id", session.UserID, "filename", session.Filename)
 
-		// Use a background context so the delete finishes even if the user disconnects
+		// Use a background context so the cleanup finishes even if the user disconnects
 		// 10 seconds is usually plenty for a storage deletion
 		cleanupCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
-		if err := h.storage.Delete(cleanupCtx, saveResult.Path); err != nil {
-			logger.Error("failed to delete orphaned file after transaction failure", "error", err, "path", saveResult.Path)
-		}
+		dropBlobReference(cleanupCtx, h.db, h.storage, storagePath)
 		// Clean up temp directory
 		go func() {
 			if err := os.RemoveAll(session.TempDir); err != nil {
 				logger.Error("failed to clean up temp directory", "error", err, "dir", session.TempDir)
 			}
 		}()
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+		return nil, "", txErr
 	}
 
-	// Enqueue a transcode job for videos so they can be streamed in the browser.
-	if h.cfg.TranscodeEnabled && transcode.IsVideoFile(file.MimeType, file.Filename) {
-		if err := transcode.Enqueue(h.db, file.ID, userID); err != nil {
-			logger.Error("failed to enqueue transcode job", "error", err, "file_id", file.ID)
-		} else {
-			logger.Info("queued transcode job for uploaded video", "file_id", file.ID, "filename", file.Filename)
-		}
+	// Reuse the video variant of deduplicated content, or enqueue a transcode
+	// job for videos so they can be streamed in the browser.
+	var duplicateOf *models.File
+	if deduplicated {
+		duplicateOf = variantSource(h.db, storagePath)
 	}
+	startTranscode(h.db, h.cfg, &file, duplicateOf)
 
 	// Mark session as completed
-	h.db.Model(&session).Update("status", "completed")
+	h.db.Model(session).Update("status", "completed")
 
 	// Clean up temp directory
 	go func() {
@@ -566,20 +774,43 @@ func (h *UploadHandler) CompleteUpload(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	logger.Info("upload completed",
-		"upload_id", uploadID,
-		"file_id", file.ID,
-		"filename", session.Filename,
-		"size", session.TotalSize,
-	)
+	return &file, action, nil
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(map[string]interface{}{
-		"file_id":  file.ID,
-		"filename": file.Filename,
-		"size":     file.FileSize,
-		"hash":     file.Hash,
-	})
+// recordVersion is recordUpload for an upload that adds a version to file.
+func (h *UploadHandler) recordVersion(ctx context.Context, session *models.UploadSession, file *models.File, storagePath string, deduplicated bool, hash string) (*models.File, string, error) {
+	var err error
+	action := actionVersioned
+	if hash == file.Hash {
+		// Unchanged content needs no new version
+		action = actionUnchanged
+		dropBlobReference(ctx, h.db, h.storage, storagePath)
+	} else {
+		err = addFileVersion(ctx, h.db, h.storage, h.cfg, file, fileContent{
+			StoragePath: storagePath,
+			FileSize:    session.TotalSize,
+			MimeType:    session.MimeType,
+			Hash:        hash,
+		}, deduplicated)
+	}
+
+	// Clean up temp directory
+	go func() {
+		if err := os.RemoveAll(session.TempDir); err != nil {
+			logger.Error("failed to clean up temp directory", "error", err, "dir", session.TempDir)
+		}
+	}()
+
+	if err != nil {
+		logger.Error("failed to add file version", "error", err, "user_id", session.UserID, "file_id", file.ID)
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		dropBlobReference(cleanupCtx, h.db, h.storage, storagePath)
+		return nil, "", err
+	}
+
+	h.db.Model(session).Update("status", "completed")
+	return file, action, nil
 }
 
 // CancelUpload cancels an upload session and cleans up chunks
@@ -622,11 +853,12 @@ func (h *UploadHandler) CancelUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Clean up temp directory
+	// Clean up temp directory and any parts sent to storage
 	go func() {
 		if err := os.RemoveAll(session.TempDir); err != nil {
 			logger.Error("failed to clean up temp directory", "error", err, "dir", session.TempDir)
 		}
+		h.abortStorageUpload(&session)
 	}()
 
 	logger.Info("upload canceled", "upload_id", uploadID, "user_id", userID)
@@ -713,6 +945,8 @@ func (h *UploadHandler) CleanupExpiredSessions() error {
 				)
 			}
 		}
+		h.abortStorageUpload(&session)
+		tusLocks.Delete(session.ID)
 
 		logger.Info("cleaned up expired upload session",
 			"upload_id", session.ID,
@@ -746,5 +980,33 @@ func (h *UploadHandler) CleanupExpiredSessions() error {
 		logger.Info("deleted old upload sessions", "count", result.RowsAffected)
 	}
 
+	h.dropStaleTusLocks()
 	return nil
 }
+
+// dropStaleTusLocks removes the locks of uploads that are no longer active,
+// such as those left by PATCH requests for unknown upload IDs.
+func (h *UploadHandler) dropStaleTusLocks() {
+	var ids []string
+	tusLocks.Range(func(key, _ any) bool {
+		ids = append(ids, key.(string))
+		return true
+	})
+	if len(ids) == 0 {
+		return
+	}
+	var active []string
+	if err := h.db.Model(&models.UploadSession{}).Where("id IN ? AND status = ?", ids, "active").Pluck("id", &active).Error; err != nil {
+		logger.Error("failed to look up tus upload locks", "error", err)
+		return
+	}
+	keep := make(map[string]bool, len(active))
+	for _, id := range active {
+		keep[id] = true
+	}
+	for _, id := range ids {
+		if !keep[id] {
+			tusLocks.Delete(id)
+		}
+	}
+}
@@ -3,7 +3,11 @@ package handlers
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -28,7 +32,7 @@ func setupUploadHandlerTest(t *testing.T) (*UploadHandler, *gorm.DB, *models.Use
 		t.Fatalf("Failed to connect to test database: %v", err)
 	}
 
-	if err := db.AutoMigrate(&models.User{}, &models.File{}, &models.UploadSession{}); err != nil {
+	if err := db.AutoMigrate(&models.User{}, &models.File{}, &models.FileVersion{}, &models.Blob{}, &models.Folder{}, &models.UploadSession{}); err != nil {
 		t.Fatalf("Failed to migrate database: %v", err)
 	}
 
@@ -511,3 +515,120 @@ func TestCompleteUpload_MissingChunks(t *testing.T) {
 		t.Errorf("Expected status 400 for missing chunks, got %d", w.Code)
 	}
 }
+
+// sendUploadRequest calls an upload handler for the session with the given
+// ID and returns the response.
+func sendUploadRequest(t *testing.T, handle http.HandlerFunc, user *models.User, id, target string, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	req = withUser(req, user)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", id)
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	w := httptest.NewRecorder()
+	handle(w, req)
+	return w
+}
+
+func initMultipartUpload(t *testing.T, handler *UploadHandler, user *models.User, req InitUploadRequest) string {
+	t.Helper()
+	body, _ := json.Marshal(req)
+	w := sendUploadRequest(t, handler.InitUpload, user, "", "/api/uploads/init", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("InitUpload returned %d: %s", w.Code, w.Body.String())
+	}
+	var resp InitUploadResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return resp.UploadID
+}
+
+func TestCompleteUpload_Multipart(t *testing.T) {
+	handler, db, user := setupUploadHandlerTest(t)
+	content := []byte("0123456789")
+	sum := sha256.Sum256(content)
+	chunks := [][]byte{content[0:4], content[4:8], content[8:]}
+
+	upload := func(filename string, order []int) (*models.UploadSession, map[string]interface{}) {
+		id := initMultipartUpload(t, handler, user, InitUploadRequest{
+			Filename:    filename,
+			TotalSize:   int64(len(content)),
+			ChunkSize:   4,
+			TotalChunks: 3,
+			LogicalPath: "/",
+			Hash:        hex.EncodeToString(sum[:]),
+		})
+		for _, n := range order {
+			w := sendUploadRequest(t, handler.UploadChunk, user, id, fmt.Sprintf("/api/uploads/%s/chunk?chunk=%d", id, n), chunks[n])
+			if w.Code != http.StatusOK {
+				t.Fatalf("UploadChunk %d returned %d: %s", n, w.Code, w.Body.String())
+			}
+		}
+		var session models.UploadSession
+		db.First(&session, "id = ?", id)
+		if session.StorageUploadID == "" {
+			t.Fatal("expected chunks to be sent to storage")
+		}
+
+		w := sendUploadRequest(t, handler.CompleteUpload, user, id, "/api/uploads/"+id+"/complete", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("CompleteUpload returned %d: %s", w.Code, w.Body.String())
+		}
+		var resp map[string]interface{}
+		_ = json.NewDecoder(w.Body).Decode(&resp)
+		return &session, resp
+	}
+
+	// Chunks arriving out of order are spooled until they can be hashed;
+	// resending a hashed chunk changes nothing
+	session, resp := upload("first.txt", []int{2, 0, 1, 0})
+	if resp["hash"] != hex.EncodeToString(sum[:]) {
+		t.Errorf("expected hash %x, got %v", sum, resp["hash"])
+	}
+	if entries, _ := os.ReadDir(session.TempDir); len(entries) != 0 {
+		t.Errorf("expected nothing left in the temp directory, found %d entries", len(entries))
+	}
+
+	var first models.File
+	if err := db.First(&first, "filename = ?", "first.txt").Error; err != nil {
+		t.Fatalf("File record not found: %v", err)
+	}
+	r, err := handler.storage.Open(context.Background(), first.StoragePath)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	stored, _ := io.ReadAll(r)
+	_ = r.Close()
+	if !bytes.Equal(stored, content) {
+		t.Errorf("stored %q, want %q", stored, content)
+	}
+
+	// The same content again reuses the stored object
+	upload("second.txt", []int{0, 1, 2})
+	var second models.File
+	if err := db.First(&second, "filename = ?", "second.txt").Error; err != nil {
+		t.Fatalf("File record not found: %v", err)
+	}
+	if second.StoragePath != first.StoragePath {
+		t.Errorf("expected the object to be reused, got %q and %q", first.StoragePath, second.StoragePath)
+	}
+}
+
+func TestUploadChunk_MultipartWrongSize(t *testing.T) {
+	handler, _, user := setupUploadHandlerTest(t)
+	id := initMultipartUpload(t, handler, user, InitUploadRequest{
+		Filename:    "short.txt",
+		TotalSize:   8,
+		ChunkSize:   4,
+		TotalChunks: 2,
+		LogicalPath: "/",
+	})
+
+	for _, body := range []string{"abc", "abcde"} {
+		w := sendUploadRequest(t, handler.UploadChunk, user, id, "/api/uploads/"+id+"/chunk?chunk=0", []byte(body))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for a %d byte chunk, got %d", len(body), w.Code)
+		}
+	}
+}
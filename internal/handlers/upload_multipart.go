@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/agjmills/trove/internal/database/models"
+	"github.com/agjmills/trove/internal/logger"
+	"github.com/agjmills/trove/internal/storage"
+)
+
+// When the storage backend is a storage.MultipartUploader, chunked uploads
+// send each chunk to it as a part as soon as it arrives, and the backend
+// joins the parts on completion, so the file is never assembled in the
+// session's temp directory. The SHA-256 of the content is computed as chunks
+// arrive: its state after the first HashedChunks chunks is kept on the
+// session, and chunks that arrive ahead of the next one to hash are spooled
+// to the temp directory until it does.
+
+// errChunkSize is returned by sendChunk when a chunk body is not the size
+// the session expects for it.
+var errChunkSize = errors.New("chunk has the wrong size")
+
+// initStorageUpload starts a multipart upload in the storage backend for a
+// new session and returns its ID, or "" if the session's chunks have to be
+// assembled locally instead.
+func (h *UploadHandler) initStorageUpload(ctx context.Context, userID uint, req *InitUploadRequest) (string, error) {
+	uploader, ok := h.storage.(storage.MultipartUploader)
+	if !ok {
+		return "", nil
+	}
+	// Parts have to be exactly the chunk size, so the chunk count must
+	// follow from the sizes
+	chunks := max(1, (req.TotalSize+req.ChunkSize-1)/req.ChunkSize)
+	if int64(req.TotalChunks) != chunks {
+		return "", nil
+	}
+	partSize := req.ChunkSize
+	if chunks == 1 {
+		partSize = 0
+	}
+	id, err := uploader.InitMultipart(ctx, partSize, storage.SaveOptions{
+		OriginalFilename: req.Filename,
+		ContentType:      req.MimeType,
+		Policy:           storagePolicy(h.db, h.storage, userID, req.LogicalPath),
+	})
+	if errors.Is(err, storage.ErrMultipartUnsupported) {
+		return "", nil
+	}
+	return id, err
+}
+
+// expectedChunkSize returns the size of chunk number n of a session: the
+// chunk size for every chunk but the last, which holds the remainder.
+func expectedChunkSize(session *models.UploadSession, n int) int64 {
+	if n < session.TotalChunks-1 {
+		return session.ChunkSize
+	}
+	return session.TotalSize - session.ChunkSize*int64(session.TotalChunks-1)
+}
+
+// chunkFilePath returns where chunk number n of a session is kept in its
+// temp directory.
+func chunkFilePath(session *models.UploadSession, n int) string {
+	return filepath.Join(session.TempDir, fmt.Sprintf("chunk_%d", n))
+}
+
+// restoreHash returns a SHA-256 hash resumed from a state saved by
+// hashState, or a new one if state is empty.
+func restoreHash(state []byte) (hash.Hash, error) {
+	hasher := sha256.New()
+	if len(state) > 0 {
+		if err := hasher.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+			return nil, fmt.Errorf("failed to restore hash state: %w", err)
+		}
+	}
+	return hasher, nil
+}
+
+func hashState(hasher hash.Hash) ([]byte, error) {
+	return hasher.(encoding.BinaryMarshaler).MarshalBinary()
+}
+
+// sendChunk sends chunk number chunkNum of a multipart session to the
+// storage backend. If the chunk is the next one to hash it is hashed on the
+// way and the new hash state is returned; otherwise it is spooled to the
+// temp directory for advanceHash, and the returned state is nil. A chunk
+// that has already been hashed is not sent again.
+func (h *UploadHandler) sendChunk(ctx context.Context, session *models.UploadSession, chunkNum int, body io.Reader) ([]byte, error) {
+	if chunkNum < session.HashedChunks {
+		return nil, nil
+	}
+	uploader, ok := h.storage.(storage.MultipartUploader)
+	if !ok {
+		return nil, storage.ErrMultipartUnsupported
+	}
+
+	size := expectedChunkSize(session, chunkNum)
+	limited := &io.LimitedReader{R: body, N: size + 1}
+	var hasher hash.Hash
+	var spool *os.File
+	var content io.Reader
+	if chunkNum == session.HashedChunks {
+		var err error
+		if hasher, err = restoreHash(session.HashState); err != nil {
+			return nil, err
+		}
+		content = io.TeeReader(limited, hasher)
+	} else {
+		var err error
+		if spool, err = os.Create(chunkFilePath(session, chunkNum)); err != nil {
+			return nil, err
+		}
+		defer spool.Close() //nolint:errcheck
+		content = io.TeeReader(limited, spool)
+	}
+
+	if err := uploader.PutPart(ctx, session.StorageUploadID, chunkNum+1, content, size); err != nil {
+		if spool != nil {
+			_ = os.Remove(spool.Name())
+		}
+		// The body was too long, or ended early rather than the backend
+		// stopping reading it
+		if limited.N == 0 || (limited.N > 1 && endOfBody(limited)) {
+			return nil, errChunkSize
+		}
+		return nil, err
+	}
+	if hasher == nil {
+		return nil, nil
+	}
+	return hashState(hasher)
+}
+
+// endOfBody reports whether r has nothing more to read.
+func endOfBody(r io.Reader) bool {
+	n, _ := r.Read(make([]byte, 1))
+	return n == 0
+}
+
+// advanceHash records chunk number chunkNum of a multipart session as sent,
+// with the hash state sendChunk returned for it, then hashes any spooled
+// chunks that now follow on and updates the session's hash columns. It runs
+// in the transaction holding the session's row lock; received lists the
+// chunks received so far.
+func advanceHash(tx *gorm.DB, session *models.UploadSession, received []int, chunkNum int, state []byte) error {
+	hashed, hashedState := session.HashedChunks, session.HashState
+	if chunkNum == hashed && state != nil {
+		hashed, hashedState = hashed+1, state
+	}
+
+	var hasher hash.Hash
+	for hashed < session.TotalChunks && slices.Contains(received, hashed) {
+		if hasher == nil {
+			var err error
+			if hasher, err = restoreHash(hashedState); err != nil {
+				return err
+			}
+		}
+		path := chunkFilePath(session, hashed)
+		spool, err := os.Open(path)
+		if err != nil {
+			// Only a resend of the chunk can fill the gap now
+			logger.Warn("spooled chunk is missing", "upload_id", session.ID, "chunk", hashed, "error", err)
+			break
+		}
+		_, err = io.Copy(hasher, spool)
+		_ = spool.Close()
+		if err != nil {
+			return fmt.Errorf("failed to hash chunk %d: %w", hashed, err)
+		}
+		_ = os.Remove(path)
+		hashed++
+	}
+	if hasher != nil {
+		var err error
+		if hashedState, err = hashState(hasher); err != nil {
+			return err
+		}
+	}
+
+	if hashed == session.HashedChunks {
+		return nil
+	}
+	return tx.Model(session).Updates(map[string]interface{}{
+		"hashed_chunks": hashed,
+		"hash_state":    hashedState,
+	}).Error
+}
+
+// completeStorageUpload finishes a multipart session: the backend joins the
+// parts into a new object, unless one with the same content is already
+// stored, in which case the parts are discarded and it is reused. Parts of
+// an upload its conflict policy skips are discarded too. It returns the file
+// and the action taken, or on failure the status and message to report.
+func (h *UploadHandler) completeStorageUpload(ctx context.Context, session *models.UploadSession) (*models.File, string, int, string) {
+	uploader, ok := h.storage.(storage.MultipartUploader)
+	if !ok {
+		logger.Error("storage backend no longer supports multipart uploads", "upload_id", session.ID)
+		return nil, "", http.StatusInternalServerError, "Internal server error"
+	}
+	if session.HashedChunks != session.TotalChunks {
+		logger.Warn("upload has unhashed chunks", "upload_id", session.ID, "hashed", session.HashedChunks)
+		return nil, "", http.StatusBadRequest, fmt.Sprintf("Chunk %d must be sent again", session.HashedChunks)
+	}
+
+	hasher, err := restoreHash(session.HashState)
+	if err != nil {
+		logger.Error("failed to restore upload hash", "error", err, "upload_id", session.ID)
+		return nil, "", http.StatusInternalServerError, "Internal server error"
+	}
+	calculatedHash := hex.EncodeToString(hasher.Sum(nil))
+	if session.Hash != "" && session.Hash != calculatedHash {
+		logger.Error("hash mismatch",
+			"expected", session.Hash,
+			"calculated", calculatedHash,
+		)
+		return nil, "", http.StatusBadRequest, "File integrity check failed"
+	}
+
+	plan := h.planSessionUpload(session)
+	if plan.action == actionSkipped {
+		h.abortStorageUpload(session)
+		h.completeSession(session)
+		return plan.existing, actionSkipped, 0, ""
+	}
+
+	completed := false
+	scope := blobScope(h.cfg, session.UserID)
+	scope.Policy = storage.PolicyOf(session.StorageUploadID) // The upload ID names its policy like a path
+	storagePath, deduplicated, err := storeBlob(ctx, h.db, h.storage, scope, calculatedHash, func() (storage.SaveResult, error) {
+		completed = true
+		return uploader.CompleteMultipart(ctx, session.StorageUploadID, session.TotalChunks)
+	})
+	if err != nil {
+		logger.Error("failed to complete storage upload", "error", err, "upload_id", session.ID)
+		return nil, "", http.StatusInternalServerError, "Failed to upload file"
+	}
+	if !completed {
+		h.abortStorageUpload(session)
+	}
+	if deduplicated {
+		logger.Info("deduplicated upload", "upload_id", session.ID, "path", storagePath)
+	}
+
+	file, action, err := h.recordUpload(ctx, session, plan, storagePath, deduplicated, calculatedHash)
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, "Failed to upload file"
+	}
+	return file, action, 0, ""
+}
+
+// abortStorageUpload discards the storage backend's multipart upload for a
+// session that will not be completed, if it has one. Failures are only
+// logged: garbage collection aborts uploads left behind.
+func (h *UploadHandler) abortStorageUpload(session *models.UploadSession) {
+	if session.StorageUploadID == "" {
+		return
+	}
+	uploader, ok := h.storage.(storage.MultipartUploader)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := uploader.AbortMultipart(ctx, session.StorageUploadID); err != nil {
+		logger.Warn("failed to abort storage upload", "error", err, "upload_id", session.ID)
+	}
+}
@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/agjmills/trove/internal/config"
+	"github.com/agjmills/trove/internal/database"
+	"github.com/agjmills/trove/internal/database/models"
+	"github.com/agjmills/trove/internal/logger"
+	"github.com/agjmills/trove/internal/scrub"
+	"github.com/agjmills/trove/internal/storage"
+	"github.com/agjmills/trove/internal/transcode"
+)
+
+// errVersionConflict is returned when a file gets a new version while
+// another is being added.
+var errVersionConflict = errors.New("file was changed concurrently")
+
+// fileContent is the stored content of one version of a file.
+type fileContent struct {
+	StoragePath string
+	FileSize    int64
+	MimeType    string
+	Hash        string
+}
+
+// versionLimits returns how many previous versions of each file user keeps
+// and for how long, 0 meaning no limit.
+func versionLimits(cfg *config.Config, user *models.User) (int, time.Duration) {
+	maxCount := cfg.MaxFileVersions
+	if user.MaxFileVersions != nil {
+		maxCount = *user.MaxFileVersions
+	}
+	days := cfg.VersionRetentionDays
+	if user.VersionRetentionDays != nil {
+		days = *user.VersionRetentionDays
+	}
+	return max(maxCount, 0), time.Duration(max(days, 0)) * 24 * time.Hour
+}
+
+// versionTarget returns the file an upload named filename into folder
+// logicalPath adds a version to, or nil if the user has not enabled
+// versioning or no such file exists.
+func versionTarget(db *gorm.DB, userID uint, logicalPath, filename string) *models.File {
+	var enabled bool
+	if err := db.Model(&models.User{}).Where("id = ?", userID).Select("versioning_enabled").Scan(&enabled).Error; err != nil || !enabled {
+		return nil
+	}
+	var file models.File
+	if err := db.Where("user_id = ? AND logical_path = ? AND filename = ? AND upload_status = ? AND trashed_at IS NULL",
+		userID, logicalPath, filename, "completed").
+		Order("id").
+		First(&file).Error; err != nil {
+		return nil
+	}
+	return &file
+}
+
+// addFileVersion makes content the current version of file, keeps the
+// content it replaces as a FileVersion and charges the owner for the new
+// content. The caller holds a reference on content.StoragePath, as saveBlob
+// returns, which passes to the file row; the replaced content's reference
+// passes to the version row. deduplicated reports whether content reused an
+// existing object, whose video variant can then be reused too. Versions
+// beyond the owner's limits are pruned afterwards.
+func addFileVersion(ctx context.Context, db *gorm.DB, backend storage.StorageBackend, cfg *config.Config, file *models.File, content fileContent, deduplicated bool) error {
+	previous := *file
+	uploadedAt := file.CreatedAt
+	if file.ContentUpdatedAt != nil {
+		uploadedAt = *file.ContentUpdatedAt
+	}
+	now := time.Now()
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&models.FileVersion{
+			FileID:      file.ID,
+			UserID:      file.UserID,
+			Version:     file.Version,
+			StoragePath: file.StoragePath,
+			FileSize:    file.FileSize,
+			MimeType:    file.MimeType,
+			Hash:        file.Hash,
+			UploadedAt:  uploadedAt,
+		}).Error; err != nil {
+			return err
+		}
+		res := tx.Model(&models.File{}).Where("id = ? AND version = ?", file.ID, file.Version).Updates(map[string]interface{}{
+			"storage_path":       content.StoragePath,
+			"file_size":          content.FileSize,
+			"mime_type":          content.MimeType,
+			"hash":               content.Hash,
+			"version":            file.Version + 1,
+			"content_updated_at": now,
+			"integrity_status":   scrub.StatusUnverified,
+			"verified_at":        nil,
+			"video_variant_path": "",
+			"video_variant_size": 0,
+			"video_variant_mime": "",
+			"transcode_status":   transcode.StatusNone,
+			"transcode_error":    "",
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errVersionConflict
+		}
+		// Any transcode of the replaced content is no longer wanted
+		if err := tx.Where("file_id = ?", file.ID).Delete(&models.TranscodeJob{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.User{}).Where("id = ?", file.UserID).
+			UpdateColumn("storage_used", gorm.Expr("storage_used + ?", content.FileSize)).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add version of file %d: %w", file.ID, err)
+	}
+
+	file.StoragePath = content.StoragePath
+	file.FileSize = content.FileSize
+	file.MimeType = content.MimeType
+	file.Hash = content.Hash
+	file.Version++
+	file.ContentUpdatedAt = &now
+	file.IntegrityStatus = scrub.StatusUnverified
+	file.VerifiedAt = nil
+	file.VideoVariantPath = ""
+	file.VideoVariantSize = 0
+	file.VideoVariantMime = ""
+	file.TranscodeStatus = transcode.StatusNone
+	file.TranscodeError = ""
+
+	// The replaced content's video variant goes with it, as when the file is
+	// permanently deleted
+	if variant := previous.VideoVariantPath; variant != "" && variant != previous.StoragePath {
+		referenced, err := database.IsReferenced(db, variant)
+		if err != nil {
+			logger.Warn("Failed to check video variant references", "path", variant, "error", err)
+		} else if !referenced {
+			if err := backend.Delete(ctx, variant); err != nil {
+				logger.Warn("Failed to delete video variant", "path", variant, "error", err)
+			}
+			refundQuota(db, file.UserID, previous.VideoVariantSize)
+		}
+	}
+
+	var duplicateOf *models.File
+	if deduplicated {
+		duplicateOf = variantSource(db, file.StoragePath)
+	}
+	startTranscode(db, cfg, file, duplicateOf)
+
+	pruneFileVersions(ctx, db, backend, cfg, file)
+	return nil
+}
+
+// pruneFileVersions deletes the versions of file beyond its owner's count
+// and age limits.
+func pruneFileVersions(ctx context.Context, db *gorm.DB, backend storage.StorageBackend, cfg *config.Config, file *models.File) {
+	var user models.User
+	if err := db.First(&user, file.UserID).Error; err != nil {
+		logger.Warn("Failed to load file owner for version pruning", "file_id", file.ID, "error", err)
+		return
+	}
+	maxCount, maxAge := versionLimits(cfg, &user)
+	if maxCount == 0 && maxAge == 0 {
+		return
+	}
+
+	var versions []models.FileVersion
+	if err := db.Where("file_id = ?", file.ID).Order("version DESC").Find(&versions).Error; err != nil {
+		logger.Warn("Failed to list file versions", "file_id", file.ID, "error", err)
+		return
+	}
+	var expired []models.FileVersion
+	for i, v := range versions {
+		if (maxCount > 0 && i >= maxCount) || (maxAge > 0 && time.Since(v.CreatedAt) > maxAge) {
+			expired = append(expired, v)
+		}
+	}
+	deleteFileVersions(ctx, db, backend, expired)
+}
+
+// deleteFileVersions deletes versions, refunding their size to their owners
+// and deleting objects nothing else references. It returns how many were
+// deleted and their total size.
+func deleteFileVersions(ctx context.Context, db *gorm.DB, backend storage.StorageBackend, versions []models.FileVersion) (int, int64) {
+	deleted, unreferenced, err := releaseFileVersions(db, versions)
+	if err != nil {
+		logger.Warn("Failed to delete file versions", "error", err)
+	}
+	var freed int64
+	for _, v := range deleted {
+		refundQuota(db, v.UserID, v.FileSize)
+		freed += v.FileSize
+	}
+	for _, path := range unreferenced {
+		if err := backend.Delete(ctx, path); err != nil {
+			logger.Warn("Failed to delete file version from storage", "path", path, "error", err)
+		}
+	}
+	return len(deleted), freed
+}
+
+// releaseFileVersions deletes version rows and drops the references they
+// held. It returns the versions it deleted, skipping any already gone, and
+// the objects that nothing references any more, for the caller to delete.
+func releaseFileVersions(db *gorm.DB, versions []models.FileVersion) ([]models.FileVersion, []string, error) {
+	var deleted []models.FileVersion
+	var unreferenced []string
+	for _, v := range versions {
+		res := db.Delete(&models.FileVersion{}, v.ID)
+		if res.Error != nil {
+			return deleted, unreferenced, res.Error
+		}
+		if res.RowsAffected == 0 {
+			continue
+		}
+		deleted = append(deleted, v)
+		last, err := database.ReleaseBlob(db, v.StoragePath)
+		if err != nil {
+			return deleted, unreferenced, err
+		}
+		if last {
+			unreferenced = append(unreferenced, v.StoragePath)
+		}
+	}
+	return deleted, unreferenced, nil
+}
+
+// refundQuota gives size bytes of storage back to a user.
+func refundQuota(db *gorm.DB, userID uint, size int64) {
+	if size <= 0 {
+		return
+	}
+	if err := db.Model(&models.User{}).Where("id = ?", userID).
+		UpdateColumn("storage_used", gorm.Expr("CASE WHEN storage_used >= ? THEN storage_used - ? ELSE 0 END", size, size)).Error; err != nil {
+		logger.Warn("Failed to update user storage", "user_id", userID, "error", err)
+	}
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/agjmills/trove/internal/database/models"
+)
+
+// setupVersioningTest is setupWebDAVTest for a user with versioning enabled.
+func setupVersioningTest(t *testing.T, quota int64) *davTestEnv {
+	t.Helper()
+	env := setupWebDAVTest(t, quota)
+	env.db.Model(&models.User{}).Where("id = ?", env.userID).Update("versioning_enabled", true)
+	return env
+}
+
+func (env *davTestEnv) file(t *testing.T, name string) models.File {
+	t.Helper()
+	var file models.File
+	if err := env.db.Where("user_id = ? AND filename = ? AND trashed_at IS NULL", env.userID, name).First(&file).Error; err != nil {
+		t.Fatalf("load file %s: %v", name, err)
+	}
+	return file
+}
+
+func (env *davTestEnv) versions(t *testing.T, fileID uint) []models.FileVersion {
+	t.Helper()
+	var versions []models.FileVersion
+	env.db.Where("file_id = ?", fileID).Order("version").Find(&versions)
+	return versions
+}
+
+func (env *davTestEnv) storageUsed(t *testing.T) int64 {
+	t.Helper()
+	var user models.User
+	env.db.First(&user, env.userID)
+	return user.StorageUsed
+}
+
+// versionRequest calls a FileHandler version endpoint for version of file.
+func (env *davTestEnv) versionRequest(t *testing.T, handler http.HandlerFunc, method string, fileID uint, version int) *httptest.ResponseRecorder {
+	t.Helper()
+	var user models.User
+	env.db.First(&user, env.userID)
+	req := httptest.NewRequest(method, fmt.Sprintf("/files/%d/versions/%d", fileID, version), nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", fmt.Sprint(fileID))
+	rctx.URLParams.Add("version", fmt.Sprint(version))
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	w := httptest.NewRecorder()
+	handler(w, withUser(req, &user))
+	return w
+}
+
+func TestVersioning_PutAddsVersion(t *testing.T) {
+	env := setupVersioningTest(t, 1000)
+
+	env.expect(t, env.do(t, http.MethodPut, "/dav/notes.txt", "first draft", nil), http.StatusCreated)
+	original := env.file(t, "notes.txt")
+	env.expect(t, env.do(t, http.MethodPut, "/dav/notes.txt", "second draft!", nil), http.StatusNoContent)
+
+	var count int64
+	env.db.Model(&models.File{}).Where("user_id = ?", env.userID).Count(&count)
+	if count != 1 {
+		t.Fatalf("want the same file updated, got %d files", count)
+	}
+	file := env.file(t, "notes.txt")
+	if file.ID != original.ID || file.Version != 2 || file.FileSize != 13 {
+		t.Errorf("file = id %d version %d size %d, want id %d version 2 size 13", file.ID, file.Version, file.FileSize, original.ID)
+	}
+	if w := env.do(t, http.MethodGet, "/dav/notes.txt", "", nil); w.Body.String() != "second draft!" {
+		t.Errorf("current content = %q", w.Body.String())
+	}
+
+	versions := env.versions(t, file.ID)
+	if len(versions) != 1 || versions[0].Version != 1 || versions[0].Hash != original.Hash || versions[0].StoragePath != original.StoragePath {
+		t.Fatalf("versions = %+v, want version 1 with the original content", versions)
+	}
+	if used := env.storageUsed(t); used != 11+13 {
+		t.Errorf("storage_used = %d, want both versions counted", used)
+	}
+
+	// Unchanged content adds no version
+	env.expect(t, env.do(t, http.MethodPut, "/dav/notes.txt", "second draft!", nil), http.StatusNoContent)
+	if versions := env.versions(t, file.ID); len(versions) != 1 {
+		t.Errorf("unchanged upload added a version: %d versions", len(versions))
+	}
+}
+
+func TestVersioning_DownloadAndRestore(t *testing.T) {
+	env := setupVersioningTest(t, 1000)
+	files := &FileHandler{db: env.db, cfg: env.handler.cfg, storage: env.handler.storage}
+
+	env.expect(t, env.do(t, http.MethodPut, "/dav/doc.txt", "v1", nil), http.StatusCreated)
+	env.expect(t, env.do(t, http.MethodPut, "/dav/doc.txt", "v2 content", nil), http.StatusNoContent)
+	file := env.file(t, "doc.txt")
+
+	w := env.versionRequest(t, files.DownloadVersion, http.MethodGet, file.ID, 1)
+	if w.Code != http.StatusOK || w.Body.String() != "v1" {
+		t.Fatalf("download version 1 = %d %q", w.Code, w.Body.String())
+	}
+	if w := env.versionRequest(t, files.DownloadVersion, http.MethodGet, file.ID, 2); w.Code != http.StatusNotFound {
+		t.Errorf("download of the current version as a previous one = %d, want 404", w.Code)
+	}
+
+	w = env.versionRequest(t, files.RestoreVersion, http.MethodPost, file.ID, 1)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("restore = %d: %s", w.Code, w.Body.String())
+	}
+	if w := env.do(t, http.MethodGet, "/dav/doc.txt", "", nil); w.Body.String() != "v1" {
+		t.Errorf("content after restore = %q", w.Body.String())
+	}
+	file = env.file(t, "doc.txt")
+	if file.Version != 3 {
+		t.Errorf("version after restore = %d, want 3", file.Version)
+	}
+	if versions := env.versions(t, file.ID); len(versions) != 2 {
+		t.Errorf("want versions 1 and 2 kept, got %d", len(versions))
+	}
+	if used := env.storageUsed(t); used != 2+10+2 {
+		t.Errorf("storage_used = %d, want the restored copy charged", used)
+	}
+}
+
+func TestVersioning_PrunesByCount(t *testing.T) {
+	env := setupVersioningTest(t, 1000)
+	env.handler.cfg.MaxFileVersions = 1
+
+	env.expect(t, env.do(t, http.MethodPut, "/dav/a.txt", "one", nil), http.StatusCreated)
+	oldest := env.file(t, "a.txt")
+	env.expect(t, env.do(t, http.MethodPut, "/dav/a.txt", "two!", nil), http.StatusNoContent)
+	env.expect(t, env.do(t, http.MethodPut, "/dav/a.txt", "three", nil), http.StatusNoContent)
+
+	file := env.file(t, "a.txt")
+	versions := env.versions(t, file.ID)
+	if len(versions) != 1 || versions[0].Version != 2 {
+		t.Fatalf("versions = %+v, want only version 2", versions)
+	}
+	if _, err := env.handler.storage.Stat(context.Background(), oldest.StoragePath); err == nil {
+		t.Error("pruned version's object was not deleted")
+	}
+	if used := env.storageUsed(t); used != 4+5 {
+		t.Errorf("storage_used = %d, want the pruned version refunded", used)
+	}
+}
+
+func TestVersioning_CleanupPrunesByAgeAndDeleteRemovesVersions(t *testing.T) {
+	env := setupVersioningTest(t, 1000)
+	env.handler.cfg.VersionRetentionDays = 30
+	deleted := &DeletedHandler{db: env.db, cfg: env.handler.cfg, storage: env.handler.storage}
+
+	env.expect(t, env.do(t, http.MethodPut, "/dav/a.txt", "one", nil), http.StatusCreated)
+	env.expect(t, env.do(t, http.MethodPut, "/dav/a.txt", "two!", nil), http.StatusNoContent)
+	env.expect(t, env.do(t, http.MethodPut, "/dav/a.txt", "three", nil), http.StatusNoContent)
+	file := env.file(t, "a.txt")
+	versions := env.versions(t, file.ID)
+	env.db.Model(&versions[0]).UpdateColumn("created_at", time.Now().Add(-31*24*time.Hour))
+
+	deleted.runCleanup()
+	if versions := env.versions(t, file.ID); len(versions) != 1 || versions[0].Version != 2 {
+		t.Fatalf("versions after cleanup = %+v, want only version 2", versions)
+	}
+	if used := env.storageUsed(t); used != 4+5 {
+		t.Errorf("storage_used = %d after cleanup", used)
+	}
+
+	if err := deleted.permanentlyDeleteFile(context.Background(), &file); err != nil {
+		t.Fatalf("permanentlyDeleteFile: %v", err)
+	}
+	if versions := env.versions(t, file.ID); len(versions) != 0 {
+		t.Errorf("%d versions left after deleting the file", len(versions))
+	}
+	if _, err := env.handler.storage.Stat(context.Background(), versions[1].StoragePath); err == nil {
+		t.Error("version object was not deleted with the file")
+	}
+	if used := env.storageUsed(t); used != 0 {
+		t.Errorf("storage_used = %d after deleting the file", used)
+	}
+}
+
+func TestVersioning_DisabledKeepsTrashBehaviour(t *testing.T) {
+	env := setupWebDAVTest(t, 1000)
+
+	env.expect(t, env.do(t, http.MethodPut, "/dav/a.txt", "one", nil), http.StatusCreated)
+	env.expect(t, env.do(t, http.MethodPut, "/dav/a.txt", "two", nil), http.StatusNoContent)
+
+	var count int64
+	env.db.Model(&models.FileVersion{}).Count(&count)
+	if count != 0 {
+		t.Errorf("%d versions created without versioning enabled", count)
+	}
+}
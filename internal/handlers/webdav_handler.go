@@ -0,0 +1,1073 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/agjmills/trove/internal/auth"
+	"github.com/agjmills/trove/internal/config"
+	"github.com/agjmills/trove/internal/database"
+	"github.com/agjmills/trove/internal/database/models"
+	"github.com/agjmills/trove/internal/logger"
+	"github.com/agjmills/trove/internal/storage"
+)
+
+// DAVPrefix is the URL prefix the WebDAV endpoint is mounted under.
+const DAVPrefix = "/dav"
+
+const (
+	davLockTimeout = time.Hour
+	davMaxBodySize = 1 << 20 // PROPFIND/PROPPATCH/LOCK request bodies
+)
+
+// davMethods are the WebDAV methods chi must know about before routes are registered.
+var davMethods = []string{"PROPFIND", "PROPPATCH", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK"}
+
+func init() {
+	for _, m := range davMethods {
+		chi.RegisterMethod(m)
+	}
+}
+
+// WebDAVScope returns the API token scope a WebDAV request requires:
+// files:read for methods that only inspect, files:write for everything else.
+func WebDAVScope(r *http.Request) string {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND":
+		return auth.ScopeFilesRead
+	}
+	return auth.ScopeFilesWrite
+}
+
+// WebDAVHandler exposes each user's logical folder tree (Folder.FolderPath,
+// File.LogicalPath + Filename) as a WebDAV class 1 and 2 collection.
+//
+// Locks are advisory: LOCK hands out tokens so clients such as macOS Finder
+// mount read-write, but they are not enforced against other writers.
+// Dead properties are not stored; PROPPATCH reports every change as forbidden.
+type WebDAVHandler struct {
+	db      *gorm.DB
+	cfg     *config.Config
+	storage storage.StorageBackend
+
+	locksMu sync.Mutex
+	locks   map[string]davLock // keyed by lock token
+}
+
+type davLock struct {
+	userID  uint
+	path    string
+	depth   string
+	expires time.Time
+}
+
+func NewWebDAVHandler(db *gorm.DB, cfg *config.Config, storage storage.StorageBackend) *WebDAVHandler {
+	return &WebDAVHandler{
+		db:      db,
+		cfg:     cfg,
+		storage: storage,
+		locks:   make(map[string]davLock),
+	}
+}
+
+// davResource is a resolved WebDAV URL: a collection (folder) or a file.
+type davResource struct {
+	path   string // Logical path, "/" for the root collection
+	isDir  bool
+	folder *models.Folder // Explicit folder row, nil for root and implicit folders
+	file   *models.File
+}
+
+func (h *WebDAVHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	p, ok := davPath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodOptions:
+		h.handleOptions(w)
+	case http.MethodGet, http.MethodHead:
+		h.handleGet(w, r, user, p)
+	case http.MethodPut:
+		h.handlePut(w, r, user, p)
+	case http.MethodDelete:
+		h.handleDelete(w, r, user, p)
+	case "MKCOL":
+		h.handleMkcol(w, r, user, p)
+	case "COPY", "MOVE":
+		h.handleCopyMove(w, r, user, p)
+	case "PROPFIND":
+		h.handlePropfind(w, r, user, p)
+	case "PROPPATCH":
+		h.handleProppatch(w, r, user, p)
+	case "LOCK":
+		h.handleLock(w, r, user, p)
+	case "UNLOCK":
+		h.handleUnlock(w, r, user)
+	default:
+		w.Header().Set("Allow", davAllow)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+const davAllow = "OPTIONS, GET, HEAD, PUT, DELETE, PROPFIND, PROPPATCH, MKCOL, COPY, MOVE, LOCK, UNLOCK"
+
+// davPath converts a request URL path under DAVPrefix into a logical path.
+func davPath(urlPath string) (string, bool) {
+	if urlPath != DAVPrefix && !strings.HasPrefix(urlPath, DAVPrefix+"/") {
+		return "", false
+	}
+	p := strings.TrimPrefix(urlPath, DAVPrefix)
+	if strings.Contains(p, "\\") {
+		return "", false
+	}
+	return sanitizeFolderPath(p), true
+}
+
+// davHref builds the escaped href for a logical path; collections get a trailing slash.
+func davHref(p string, isDir bool) string {
+	if p == "/" {
+		return DAVPrefix + "/"
+	}
+	segments := strings.Split(strings.TrimPrefix(p, "/"), "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	href := DAVPrefix + "/" + strings.Join(segments, "/")
+	if isDir {
+		href += "/"
+	}
+	return href
+}
+
+// splitDAVPath returns the parent folder and final name of a logical path.
+func splitDAVPath(p string) (parent, name string) {
+	parent, name = path.Split(p)
+	parent = strings.TrimSuffix(parent, "/")
+	if parent == "" {
+		parent = "/"
+	}
+	return parent, name
+}
+
+// joinDAVPath appends name to a logical folder path.
+func joinDAVPath(parent, name string) string {
+	if parent == "/" {
+		return "/" + name
+	}
+	return parent + "/" + name
+}
+
+// resolve looks up the folder or file at p. Explicit and implicit folders win
+// over a file with the same name, matching how the files page lists them.
+func (h *WebDAVHandler) resolve(userID uint, p string) *davResource {
+	if p == "/" {
+		return &davResource{path: p, isDir: true}
+	}
+
+	var folder models.Folder
+	if err := h.db.Where("user_id = ? AND folder_path = ? AND trashed_at IS NULL", userID, p).First(&folder).Error; err == nil {
+		return &davResource{path: p, isDir: true, folder: &folder}
+	}
+
+	parent, name := splitDAVPath(p)
+	var file models.File
+	if err := h.db.Where("user_id = ? AND logical_path = ? AND filename = ? AND upload_status = ? AND trashed_at IS NULL",
+		userID, parent, name, "completed").Order("id ASC").First(&file).Error; err == nil {
+		return &davResource{path: p, file: &file}
+	}
+
+	if folderExists(h.db, userID, p) {
+		return &davResource{path: p, isDir: true}
+	}
+	return nil
+}
+
+func (h *WebDAVHandler) handleOptions(w http.ResponseWriter) {
+	w.Header().Set("DAV", "1, 2")
+	w.Header().Set("MS-Author-Via", "DAV")
+	w.Header().Set("Allow", davAllow)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *WebDAVHandler) handleGet(w http.ResponseWriter, r *http.Request, user *models.User, p string) {
+	res := h.resolve(user.ID, p)
+	if res == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if res.isDir {
+		w.Header().Set("Allow", "OPTIONS, PROPFIND, PROPPATCH, MKCOL, COPY, MOVE, DELETE, LOCK, UNLOCK")
+		http.Error(w, "Collections cannot be downloaded; use PROPFIND to list them", http.StatusMethodNotAllowed)
+		return
+	}
+
+	file := res.file
+	ctx := r.Context()
+	size := file.FileSize
+	if r.Method == http.MethodGet {
+		recordAccess(h.db, file)
+	}
+
+	w.Header().Set("Content-Type", file.MimeType)
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("ETag", davETag(file))
+	w.Header().Set("Last-Modified", file.UpdatedAt.UTC().Format(http.TimeFormat))
+
+	start, end, ranged := parseRangeHeader(r.Header.Get("Range"), size)
+	if ranged {
+		if start >= size {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		length := end - start + 1
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+			w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+			w.WriteHeader(http.StatusPartialContent)
+			return
+		}
+		reader, err := h.storage.OpenRange(ctx, file.StoragePath, start, length)
+		if err != nil {
+			h.storageError(w, err, file)
+			return
+		}
+		defer reader.Close() //nolint:errcheck
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+		w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+		w.WriteHeader(http.StatusPartialContent)
+		if _, err := io.Copy(w, reader); err != nil {
+			logger.Debug("webdav: error streaming range", "error", err, "path", file.StoragePath)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	reader, err := h.storage.Open(ctx, file.StoragePath)
+	if err != nil {
+		h.storageError(w, err, file)
+		return
+	}
+	defer reader.Close() //nolint:errcheck
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, reader); err != nil {
+		logger.Debug("webdav: error streaming file", "error", err, "path", file.StoragePath)
+	}
+}
+
+func (h *WebDAVHandler) storageError(w http.ResponseWriter, err error, file *models.File) {
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, "File not found in storage", http.StatusNotFound)
+		return
+	}
+	logger.Error("webdav: failed to open file", "error", err, "file_id", file.ID, "path", file.StoragePath)
+	http.Error(w, "Failed to open file", http.StatusInternalServerError)
+}
+
+// handlePut stores the request body at p, replacing any existing file there.
+// The replaced file is moved to deleted items so it can still be recovered,
+// or kept as a previous version if the user has versioning enabled.
+// Quota checks and deduplication follow FileHandler.Upload, but the object is
+// saved synchronously so it can be read back as soon as PUT returns.
+func (h *WebDAVHandler) handlePut(w http.ResponseWriter, r *http.Request, user *models.User, p string) {
+	if p == "/" {
+		http.Error(w, "Cannot replace the root collection", http.StatusMethodNotAllowed)
+		return
+	}
+	parent, name := splitDAVPath(p)
+	if msg := validateFilename(name); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	existing := h.resolve(user.ID, p)
+	if existing != nil && existing.isDir {
+		http.Error(w, "A collection exists at this path", http.StatusMethodNotAllowed)
+		return
+	}
+	if !folderExists(h.db, user.ID, parent) {
+		http.Error(w, "Parent collection does not exist", http.StatusConflict)
+		return
+	}
+
+	if r.ContentLength > h.cfg.MaxUploadSize {
+		http.Error(w, fmt.Sprintf("File too large (max %d MB)", h.cfg.MaxUploadSize/(1024*1024)), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if !checkDiskSpace(w, h.cfg, r.ContentLength) {
+		return
+	}
+	body := http.MaxBytesReader(w, r.Body, h.cfg.MaxUploadSize)
+
+	tempFile, err := os.CreateTemp(h.cfg.TempDir, "trove-dav-*")
+	if err != nil {
+		logger.Error("webdav: failed to create temp file", "error", err, "temp_dir", h.cfg.TempDir)
+		http.Error(w, "Failed to create temp file", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tempFile.Name()) //nolint:errcheck
+	defer tempFile.Close()           //nolint:errcheck
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tempFile, hasher), body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, fmt.Sprintf("File too large (max %d MB)", h.cfg.MaxUploadSize/(1024*1024)), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+	hash := hex.EncodeToString(hasher.Sum(nil))
+
+	if user.StorageUsed+size > user.StorageQuota {
+		http.Error(w, "Storage quota exceeded", http.StatusInsufficientStorage)
+		return
+	}
+
+	mimeType := davContentType(r.Header.Get("Content-Type"), name)
+	version := existing != nil && user.VersioningEnabled
+	if version && existing.file.Hash == hash {
+		// Unchanged content needs no new version
+		w.Header().Set("ETag", davETag(existing.file))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// Reuse an existing object with the same content, exactly like FileHandler.Upload
+	if _, err := tempFile.Seek(0, io.SeekStart); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	storagePath, deduplicated, err := saveBlob(r.Context(), h.db, h.storage, blobScope(h.cfg, user.ID), hash, tempFile, storage.SaveOptions{
+		OriginalFilename: name,
+		ContentType:      mimeType,
+		Policy:           storagePolicy(h.db, h.storage, user.ID, parent),
+	})
+	if err != nil {
+		logger.Error("webdav: failed to save to storage", "error", err, "user_id", user.ID)
+		http.Error(w, "Storage upload failed", http.StatusInternalServerError)
+		return
+	}
+
+	if version {
+		file := existing.file
+		if err := addFileVersion(r.Context(), h.db, h.storage, h.cfg, file, fileContent{
+			StoragePath: storagePath,
+			FileSize:    size,
+			MimeType:    mimeType,
+			Hash:        hash,
+		}, deduplicated); err != nil {
+			logger.Error("webdav: failed to add file version", "error", err, "user_id", user.ID, "path", p)
+			cleanupCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			dropBlobReference(cleanupCtx, h.db, h.storage, storagePath)
+			http.Error(w, "Failed to save file metadata", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("webdav: file version stored", "user_id", user.ID, "file_id", file.ID, "version", file.Version, "path", p, "size", size)
+		w.Header().Set("ETag", davETag(file))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	file := models.File{
+		UserID:           user.ID,
+		StoragePath:      storagePath,
+		LogicalPath:      parent,
+		Filename:         name,
+		OriginalFilename: name,
+		FileSize:         size,
+		MimeType:         mimeType,
+		Hash:             hash,
+		UploadStatus:     "completed",
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if existing != nil {
+			if err := trashFile(tx, existing.file, time.Now()); err != nil {
+				return err
+			}
+		}
+		if err := tx.Create(&file).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.User{}).Where("id = ?", user.ID).
+			UpdateColumn("storage_used", gorm.Expr("storage_used + ?", size)).Error
+	})
+	if err != nil {
+		logger.Error("webdav: failed to record upload", "error", err, "user_id", user.ID, "path", p)
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		dropBlobReference(cleanupCtx, h.db, h.storage, storagePath)
+		http.Error(w, "Failed to save file metadata", http.StatusInternalServerError)
+		return
+	}
+
+	var duplicateOf *models.File
+	if deduplicated {
+		duplicateOf = variantSource(h.db, storagePath)
+	}
+	startTranscode(h.db, h.cfg, &file, duplicateOf)
+
+	logger.Info("webdav: file stored", "user_id", user.ID, "file_id", file.ID, "path", p, "size", size, "deduplicated", deduplicated)
+
+	w.Header().Set("ETag", davETag(&file))
+	if existing != nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// davContentType picks the stored MIME type, preferring the filename extension
+// when the client sends nothing more specific than application/octet-stream.
+func davContentType(header, name string) string {
+	if mt, _, err := mime.ParseMediaType(header); err == nil && mt != "application/octet-stream" {
+		return header
+	}
+	if byExt := mime.TypeByExtension(filepath.Ext(name)); byExt != "" {
+		return byExt
+	}
+	return "application/octet-stream"
+}
+
+func davETag(file *models.File) string {
+	if file.Hash != "" {
+		return `"` + file.Hash + `"`
+	}
+	return fmt.Sprintf(`"%d-%d"`, file.ID, file.UpdatedAt.UnixNano())
+}
+
+func (h *WebDAVHandler) handleDelete(w http.ResponseWriter, r *http.Request, user *models.User, p string) {
+	if p == "/" {
+		http.Error(w, "Cannot delete the root collection", http.StatusForbidden)
+		return
+	}
+	res := h.resolve(user.ID, p)
+	if res == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		return h.trash(tx, user.ID, res)
+	}); err != nil {
+		logger.Error("webdav: failed to delete", "error", err, "user_id", user.ID, "path", p)
+		http.Error(w, "Failed to delete", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// trash moves a resource to deleted items, the same as the Delete buttons in the UI.
+func (h *WebDAVHandler) trash(tx *gorm.DB, userID uint, res *davResource) error {
+	now := time.Now()
+	if res.isDir {
+		return trashFolder(tx, userID, res.path, now)
+	}
+	return trashFile(tx, res.file, now)
+}
+
+func (h *WebDAVHandler) handleMkcol(w http.ResponseWriter, r *http.Request, user *models.User, p string) {
+	if r.ContentLength > 0 {
+		http.Error(w, "MKCOL request bodies are not supported", http.StatusUnsupportedMediaType)
+		return
+	}
+	if h.resolve(user.ID, p) != nil {
+		http.Error(w, "Resource already exists", http.StatusMethodNotAllowed)
+		return
+	}
+	parent, name := splitDAVPath(p)
+	if msg := validateFilename(name); msg != "" {
+		http.Error(w, strings.Replace(msg, "File", "Folder", 1), http.StatusBadRequest)
+		return
+	}
+	if !folderExists(h.db, user.ID, parent) {
+		http.Error(w, "Parent collection does not exist", http.StatusConflict)
+		return
+	}
+
+	if err := h.db.Create(&models.Folder{UserID: user.ID, FolderPath: p}).Error; err != nil {
+		logger.Error("webdav: failed to create folder", "error", err, "user_id", user.ID, "path", p)
+		http.Error(w, "Failed to create folder", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// handleCopyMove implements COPY and MOVE. Copies share the source's storage
+// object (deduplication) but are charged to the quota like any other file.
+func (h *WebDAVHandler) handleCopyMove(w http.ResponseWriter, r *http.Request, user *models.User, src string) {
+	isMove := r.Method == "MOVE"
+
+	dest, status := davDestination(r)
+	if status != 0 {
+		http.Error(w, "Invalid Destination header", status)
+		return
+	}
+	if src == "/" || dest == "/" {
+		http.Error(w, "Cannot copy or move the root collection", http.StatusForbidden)
+		return
+	}
+	if dest == src {
+		http.Error(w, "Source and destination are the same", http.StatusForbidden)
+		return
+	}
+
+	res := h.resolve(user.ID, src)
+	if res == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if res.isDir && strings.HasPrefix(dest+"/", src+"/") {
+		http.Error(w, "Cannot copy or move a collection into itself", http.StatusForbidden)
+		return
+	}
+
+	destParent, destName := splitDAVPath(dest)
+	if msg := validateFilename(destName); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	if !folderExists(h.db, user.ID, destParent) {
+		http.Error(w, "Destination parent collection does not exist", http.StatusConflict)
+		return
+	}
+
+	existing := h.resolve(user.ID, dest)
+	if existing != nil && r.Header.Get("Overwrite") == "F" {
+		http.Error(w, "Destination exists and Overwrite is F", http.StatusPreconditionFailed)
+		return
+	}
+
+	depthInfinity := r.Header.Get("Depth") != "0"
+	if !isMove && res.isDir {
+		var addedBytes int64
+		if depthInfinity {
+			h.db.Model(&models.File{}).Select("COALESCE(SUM(file_size), 0)").
+				Where("user_id = ? AND (logical_path = ? OR logical_path LIKE ? ESCAPE '\\') AND trashed_at IS NULL AND upload_status = ?",
+					user.ID, src, escapeSQLLike(src)+"/%", "completed").
+				Scan(&addedBytes)
+		}
+		if user.StorageUsed+addedBytes > user.StorageQuota {
+			http.Error(w, "Storage quota exceeded", http.StatusInsufficientStorage)
+			return
+		}
+	} else if !isMove && user.StorageUsed+res.file.FileSize > user.StorageQuota {
+		http.Error(w, "Storage quota exceeded", http.StatusInsufficientStorage)
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if existing != nil {
+			if err := h.trash(tx, user.ID, existing); err != nil {
+				return err
+			}
+		}
+		switch {
+		case isMove && res.isDir:
+			return relocateFolder(tx, user.ID, src, dest)
+		case isMove:
+			return tx.Model(res.file).Updates(map[string]interface{}{
+				"filename":     destName,
+				"logical_path": destParent,
+			}).Error
+		case res.isDir:
+			return copyFolder(tx, user.ID, src, dest, depthInfinity)
+		default:
+			return copyFile(tx, res.file, destParent, destName)
+		}
+	})
+	if err != nil {
+		logger.Error("webdav: copy/move failed", "error", err, "method", r.Method, "user_id", user.ID, "src", src, "dest", dest)
+		http.Error(w, "Failed to "+strings.ToLower(r.Method), http.StatusInternalServerError)
+		return
+	}
+
+	if existing != nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// davDestination parses the Destination header into a logical path. A non-zero
+// status is returned for malformed headers or destinations on another server.
+func davDestination(r *http.Request) (string, int) {
+	raw := r.Header.Get("Destination")
+	if raw == "" {
+		return "", http.StatusBadRequest
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", http.StatusBadRequest
+	}
+	if u.Host != "" && u.Host != r.Host {
+		return "", http.StatusBadGateway
+	}
+	p, ok := davPath(u.Path)
+	if !ok {
+		return "", http.StatusBadGateway
+	}
+	return p, 0
+}
+
+// copyFile creates a new record for file at parent/name sharing the same
+// storage object, and charges its size to the owner's quota.
+func copyFile(tx *gorm.DB, file *models.File, parent, name string) error {
+	copied := *file
+	copied.ID = 0
+	copied.LogicalPath = parent
+	copied.Filename = name
+	copied.CreatedAt = time.Time{}
+	copied.UpdatedAt = time.Time{}
+	if err := tx.Omit("User").Create(&copied).Error; err != nil {
+		return err
+	}
+	// The copy shares the original's object
+	if err := database.AcquireBlobPath(tx, file.StoragePath); err != nil {
+		return err
+	}
+	return tx.Model(&models.User{}).Where("id = ?", file.UserID).
+		UpdateColumn("storage_used", gorm.Expr("storage_used + ?", file.FileSize)).Error
+}
+
+// copyFolder recreates src at dest. With recursive set, subfolders and files
+// are copied too; otherwise only the folder itself is created.
+func copyFolder(tx *gorm.DB, userID uint, src, dest string, recursive bool) error {
+	if err := tx.Create(&models.Folder{UserID: userID, FolderPath: dest}).Error; err != nil {
+		return err
+	}
+	if !recursive {
+		return nil
+	}
+
+	escapedSrc := escapeSQLLike(src)
+
+	var subfolders []models.Folder
+	if err := tx.Where("user_id = ? AND folder_path LIKE ? ESCAPE '\\' AND trashed_at IS NULL", userID, escapedSrc+"/%").
+		Find(&subfolders).Error; err != nil {
+		return err
+	}
+	for _, f := range subfolders {
+		if err := tx.Create(&models.Folder{UserID: userID, FolderPath: dest + strings.TrimPrefix(f.FolderPath, src)}).Error; err != nil {
+			return err
+		}
+	}
+
+	var files []models.File
+	if err := tx.Where("user_id = ? AND (logical_path = ? OR logical_path LIKE ? ESCAPE '\\') AND trashed_at IS NULL AND upload_status = ?",
+		userID, src, escapedSrc+"/%", "completed").Find(&files).Error; err != nil {
+		return err
+	}
+	for i := range files {
+		if err := copyFile(tx, &files[i], dest+strings.TrimPrefix(files[i].LogicalPath, src), files[i].Filename); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// davEntry is one resource in a PROPFIND response.
+type davEntry struct {
+	path     string
+	isDir    bool
+	size     int64
+	mimeType string
+	etag     string
+	created  time.Time
+	modified time.Time
+}
+
+func (h *WebDAVHandler) entryFor(user *models.User, res *davResource) davEntry {
+	if res.file != nil {
+		return fileEntry(res.file)
+	}
+	e := davEntry{path: res.path, isDir: true, created: user.CreatedAt, modified: user.CreatedAt}
+	if res.folder != nil {
+		e.created, e.modified = res.folder.CreatedAt, res.folder.UpdatedAt
+	}
+	return e
+}
+
+func fileEntry(f *models.File) davEntry {
+	return davEntry{
+		path:     joinDAVPath(f.LogicalPath, f.Filename),
+		size:     f.FileSize,
+		mimeType: f.MimeType,
+		etag:     davETag(f),
+		created:  f.CreatedAt,
+		modified: f.UpdatedAt,
+	}
+}
+
+// children lists the immediate subfolders and files of a collection.
+func (h *WebDAVHandler) children(user *models.User, p string) []davEntry {
+	names := listSubfolderNames(h.db, user.ID, p)
+	paths := make([]string, len(names))
+	for i, name := range names {
+		paths[i] = joinDAVPath(p, name)
+	}
+
+	folderRows := map[string]models.Folder{}
+	if len(paths) > 0 {
+		var folders []models.Folder
+		h.db.Where("user_id = ? AND folder_path IN ? AND trashed_at IS NULL", user.ID, paths).Find(&folders)
+		for _, f := range folders {
+			folderRows[f.FolderPath] = f
+		}
+	}
+
+	entries := make([]davEntry, 0, len(paths))
+	for _, fp := range paths {
+		res := &davResource{path: fp, isDir: true}
+		if f, ok := folderRows[fp]; ok {
+			res.folder = &f
+		}
+		entries = append(entries, h.entryFor(user, res))
+	}
+
+	var files []models.File
+	h.db.Where("user_id = ? AND logical_path = ? AND upload_status = ? AND trashed_at IS NULL", user.ID, p, "completed").
+		Order("filename ASC").Order("id ASC").Find(&files)
+	seen := make(map[string]bool, len(files))
+	for i := range files {
+		// Folders shadow files of the same name, and duplicate names resolve to the oldest file
+		if seen[files[i].Filename] || folderRows[joinDAVPath(p, files[i].Filename)].ID != 0 {
+			continue
+		}
+		seen[files[i].Filename] = true
+		entries = append(entries, fileEntry(&files[i]))
+	}
+	return entries
+}
+
+// davProperty is a rendered property: its name and escaped inner XML.
+type davProperty struct {
+	name  xml.Name
+	inner string
+}
+
+const davNS = "DAV:"
+
+// properties returns the live properties of an entry. Quota properties
+// (RFC 4331) are only returned when asked for by name.
+func (h *WebDAVHandler) properties(user *models.User, e davEntry, includeQuota bool) []davProperty {
+	_, name := splitDAVPath(e.path)
+	if e.path == "/" {
+		name = ""
+	}
+	props := []davProperty{
+		{xml.Name{Space: davNS, Local: "displayname"}, xmlEscape(name)},
+		{xml.Name{Space: davNS, Local: "creationdate"}, e.created.UTC().Format(time.RFC3339)},
+		{xml.Name{Space: davNS, Local: "getlastmodified"}, e.modified.UTC().Format(http.TimeFormat)},
+		{xml.Name{Space: davNS, Local: "supportedlock"}, "<D:lockentry><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockentry>"},
+		{xml.Name{Space: davNS, Local: "lockdiscovery"}, ""},
+	}
+	if e.isDir {
+		props = append(props, davProperty{xml.Name{Space: davNS, Local: "resourcetype"}, "<D:collection/>"})
+		if includeQuota {
+			props = append(props,
+				davProperty{xml.Name{Space: davNS, Local: "quota-used-bytes"}, strconv.FormatInt(user.StorageUsed, 10)},
+				davProperty{xml.Name{Space: davNS, Local: "quota-available-bytes"}, strconv.FormatInt(max(user.StorageQuota-user.StorageUsed, 0), 10)},
+			)
+		}
+	} else {
+		props = append(props,
+			davProperty{xml.Name{Space: davNS, Local: "resourcetype"}, ""},
+			davProperty{xml.Name{Space: davNS, Local: "getcontentlength"}, strconv.FormatInt(e.size, 10)},
+			davProperty{xml.Name{Space: davNS, Local: "getcontenttype"}, xmlEscape(e.mimeType)},
+			davProperty{xml.Name{Space: davNS, Local: "getetag"}, xmlEscape(e.etag)},
+		)
+	}
+	return props
+}
+
+type davPropfind struct {
+	XMLName  xml.Name      `xml:"DAV: propfind"`
+	AllProp  *struct{}     `xml:"DAV: allprop"`
+	PropName *struct{}     `xml:"DAV: propname"`
+	Prop     *davPropNames `xml:"DAV: prop"`
+}
+
+type davPropNames struct {
+	Names []struct {
+		XMLName xml.Name
+	} `xml:",any"`
+}
+
+func (h *WebDAVHandler) handlePropfind(w http.ResponseWriter, r *http.Request, user *models.User, p string) {
+	depth := r.Header.Get("Depth")
+	if depth != "0" && depth != "1" {
+		// RFC 4918 §9.1: servers may refuse infinite-depth PROPFIND
+		writeDAVError(w, http.StatusForbidden, "propfind-finite-depth")
+		return
+	}
+
+	var req davPropfind
+	body, err := io.ReadAll(io.LimitReader(r.Body, davMaxBodySize))
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := xml.Unmarshal(body, &req); err != nil {
+			http.Error(w, "Invalid PROPFIND body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	res := h.resolve(user.ID, p)
+	if res == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	entries := []davEntry{h.entryFor(user, res)}
+	if res.isDir && depth == "1" {
+		entries = append(entries, h.children(user, p)...)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header + `<D:multistatus xmlns:D="DAV:">`)
+	for _, e := range entries {
+		buf.WriteString("<D:response><D:href>" + xmlEscape(davHref(e.path, e.isDir)) + "</D:href>")
+		switch {
+		case req.PropName != nil:
+			props := h.properties(user, e, false)
+			for i := range props {
+				props[i].inner = ""
+			}
+			writePropstat(&buf, props, http.StatusOK)
+		case req.Prop != nil:
+			available := map[xml.Name]davProperty{}
+			for _, prop := range h.properties(user, e, true) {
+				available[prop.name] = prop
+			}
+			var found, missing []davProperty
+			for _, n := range req.Prop.Names {
+				if prop, ok := available[n.XMLName]; ok {
+					found = append(found, prop)
+				} else {
+					missing = append(missing, davProperty{name: n.XMLName})
+				}
+			}
+			writePropstat(&buf, found, http.StatusOK)
+			writePropstat(&buf, missing, http.StatusNotFound)
+		default:
+			writePropstat(&buf, h.properties(user, e, false), http.StatusOK)
+		}
+		buf.WriteString("</D:response>")
+	}
+	buf.WriteString("</D:multistatus>")
+
+	writeDAVXML(w, http.StatusMultiStatus, buf.Bytes())
+}
+
+type davPropertyUpdate struct {
+	XMLName xml.Name `xml:"DAV: propertyupdate"`
+	Set     []struct {
+		Prop davPropNames `xml:"DAV: prop"`
+	} `xml:"DAV: set"`
+	Remove []struct {
+		Prop davPropNames `xml:"DAV: prop"`
+	} `xml:"DAV: remove"`
+}
+
+// handleProppatch rejects every change: Trove has no dead-property store and
+// live properties are read-only.
+func (h *WebDAVHandler) handleProppatch(w http.ResponseWriter, r *http.Request, user *models.User, p string) {
+	res := h.resolve(user.ID, p)
+	if res == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var req davPropertyUpdate
+	if err := xml.NewDecoder(io.LimitReader(r.Body, davMaxBodySize)).Decode(&req); err != nil {
+		http.Error(w, "Invalid PROPPATCH body", http.StatusBadRequest)
+		return
+	}
+
+	var props []davProperty
+	for _, set := range req.Set {
+		for _, n := range set.Prop.Names {
+			props = append(props, davProperty{name: n.XMLName})
+		}
+	}
+	for _, remove := range req.Remove {
+		for _, n := range remove.Prop.Names {
+			props = append(props, davProperty{name: n.XMLName})
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header + `<D:multistatus xmlns:D="DAV:"><D:response>`)
+	buf.WriteString("<D:href>" + xmlEscape(davHref(res.path, res.isDir)) + "</D:href>")
+	writePropstat(&buf, props, http.StatusForbidden)
+	buf.WriteString("</D:response></D:multistatus>")
+	writeDAVXML(w, http.StatusMultiStatus, buf.Bytes())
+}
+
+type davLockInfo struct {
+	XMLName xml.Name `xml:"DAV: lockinfo"`
+}
+
+// handleLock issues or refreshes an advisory exclusive write lock.
+func (h *WebDAVHandler) handleLock(w http.ResponseWriter, r *http.Request, user *models.User, p string) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, davMaxBodySize))
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	h.locksMu.Lock()
+	defer h.locksMu.Unlock()
+
+	now := time.Now()
+	for token, l := range h.locks {
+		if now.After(l.expires) {
+			delete(h.locks, token)
+		}
+	}
+
+	var token string
+	var lock davLock
+	if len(bytes.TrimSpace(body)) == 0 {
+		// Refresh: the token comes from the If header, e.g. If: (<opaquelocktoken:...>)
+		ifHeader := r.Header.Get("If")
+		start, end := strings.Index(ifHeader, "<"), strings.Index(ifHeader, ">")
+		if start < 0 || end <= start {
+			http.Error(w, "Lock refresh requires an If header", http.StatusBadRequest)
+			return
+		}
+		token = ifHeader[start+1 : end]
+		existing, ok := h.locks[token]
+		if !ok || existing.userID != user.ID {
+			writeDAVError(w, http.StatusPreconditionFailed, "lock-token-matches-request-uri")
+			return
+		}
+		lock = existing
+	} else {
+		var info davLockInfo
+		if err := xml.Unmarshal(body, &info); err != nil {
+			http.Error(w, "Invalid LOCK body", http.StatusBadRequest)
+			return
+		}
+		token = "opaquelocktoken:" + uuid.New().String()
+		lock = davLock{userID: user.ID, path: p, depth: "infinity"}
+		if r.Header.Get("Depth") == "0" {
+			lock.depth = "0"
+		}
+	}
+	lock.expires = now.Add(davLockTimeout)
+	h.locks[token] = lock
+
+	status := http.StatusOK
+	res := h.resolve(user.ID, lock.path)
+	if res == nil {
+		res = &davResource{path: lock.path}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header + `<D:prop xmlns:D="DAV:"><D:lockdiscovery><D:activelock>`)
+	buf.WriteString("<D:locktype><D:write/></D:locktype><D:lockscope><D:exclusive/></D:lockscope>")
+	buf.WriteString("<D:depth>" + lock.depth + "</D:depth>")
+	buf.WriteString(fmt.Sprintf("<D:timeout>Second-%d</D:timeout>", int(davLockTimeout.Seconds())))
+	buf.WriteString("<D:locktoken><D:href>" + xmlEscape(token) + "</D:href></D:locktoken>")
+	buf.WriteString("<D:lockroot><D:href>" + xmlEscape(davHref(res.path, res.isDir)) + "</D:href></D:lockroot>")
+	buf.WriteString("</D:activelock></D:lockdiscovery></D:prop>")
+
+	w.Header().Set("Lock-Token", "<"+token+">")
+	writeDAVXML(w, status, buf.Bytes())
+}
+
+func (h *WebDAVHandler) handleUnlock(w http.ResponseWriter, r *http.Request, user *models.User) {
+	token := strings.Trim(r.Header.Get("Lock-Token"), "<> ")
+
+	h.locksMu.Lock()
+	defer h.locksMu.Unlock()
+
+	lock, ok := h.locks[token]
+	if !ok || lock.userID != user.ID {
+		writeDAVError(w, http.StatusConflict, "lock-token-matches-request-uri")
+		return
+	}
+	delete(h.locks, token)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writePropstat writes one <D:propstat> block; nothing is written for an empty list.
+func writePropstat(buf *bytes.Buffer, props []davProperty, status int) {
+	if len(props) == 0 {
+		return
+	}
+	buf.WriteString("<D:propstat><D:prop>")
+	for _, p := range props {
+		open, closeTag := "<D:"+p.name.Local, "</D:"+p.name.Local+">"
+		if p.name.Space != davNS {
+			open = "<" + p.name.Local + ` xmlns="` + xmlEscape(p.name.Space) + `"`
+			closeTag = "</" + p.name.Local + ">"
+		}
+		if p.inner == "" {
+			buf.WriteString(open + "/>")
+			continue
+		}
+		buf.WriteString(open + ">" + p.inner + closeTag)
+	}
+	buf.WriteString("</D:prop>")
+	buf.WriteString(fmt.Sprintf("<D:status>HTTP/1.1 %d %s</D:status>", status, http.StatusText(status)))
+	buf.WriteString("</D:propstat>")
+}
+
+// writeDAVError writes an RFC 4918 <D:error> body naming a failed precondition.
+func writeDAVError(w http.ResponseWriter, status int, condition string) {
+	writeDAVXML(w, status, []byte(xml.Header+`<D:error xmlns:D="DAV:"><D:`+condition+`/></D:error>`))
+}
+
+func writeDAVXML(w http.ResponseWriter, status int, body []byte) {
+	w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/agjmills/trove/internal/config"
+	"github.com/agjmills/trove/internal/database/models"
+	"github.com/agjmills/trove/internal/storage"
+)
+
+type davTestEnv struct {
+	db      *gorm.DB
+	userID  uint
+	handler *WebDAVHandler
+}
+
+func setupWebDAVTest(t *testing.T, quota int64) *davTestEnv {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.File{}, &models.FileVersion{}, &models.Blob{}, &models.Folder{}, &models.TranscodeJob{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	user := &models.User{Username: "alice", Email: "alice@example.com", StorageQuota: quota}
+	db.Create(user)
+
+	cfg := &config.Config{MaxUploadSize: 1 << 20, TempDir: t.TempDir()}
+	return &davTestEnv{
+		db:      db,
+		userID:  user.ID,
+		handler: NewWebDAVHandler(db, cfg, storage.NewMemoryBackend()),
+	}
+}
+
+// do sends a request as the test user, reloading the user first as the auth
+// middleware would so quota changes from earlier requests are visible.
+func (env *davTestEnv) do(t *testing.T, method, target, body string, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	var user models.User
+	if err := env.db.First(&user, env.userID).Error; err != nil {
+		t.Fatalf("load user: %v", err)
+	}
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	env.handler.ServeHTTP(w, withUser(req, &user))
+	return w
+}
+
+func (env *davTestEnv) expect(t *testing.T, w *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("want %d, got %d: %s", status, w.Code, w.Body.String())
+	}
+}
+
+func TestWebDAV_PutGetAndRange(t *testing.T) {
+	env := setupWebDAVTest(t, 1000)
+
+	env.expect(t, env.do(t, http.MethodPut, "/dav/hello.txt", "hello world", nil), http.StatusCreated)
+
+	w := env.do(t, http.MethodGet, "/dav/hello.txt", "", nil)
+	env.expect(t, w, http.StatusOK)
+	if w.Body.String() != "hello world" {
+		t.Errorf("unexpected body %q", w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("want content type from extension, got %q", ct)
+	}
+
+	w = env.do(t, http.MethodGet, "/dav/hello.txt", "", map[string]string{"Range": "bytes=6-"})
+	env.expect(t, w, http.StatusPartialContent)
+	if w.Body.String() != "world" {
+		t.Errorf("unexpected range body %q", w.Body.String())
+	}
+
+	// Overwriting replaces the file and sends the old version to deleted items
+	env.expect(t, env.do(t, http.MethodPut, "/dav/hello.txt", "bye", nil), http.StatusNoContent)
+	if w := env.do(t, http.MethodGet, "/dav/hello.txt", "", nil); w.Body.String() != "bye" {
+		t.Errorf("want overwritten content, got %q", w.Body.String())
+	}
+	var trashed int64
+	env.db.Model(&models.File{}).Where("trashed_at IS NOT NULL").Count(&trashed)
+	if trashed != 1 {
+		t.Errorf("want 1 trashed file, got %d", trashed)
+	}
+
+	env.expect(t, env.do(t, http.MethodPut, "/dav/missing/hello.txt", "x", nil), http.StatusConflict)
+}
+
+func TestWebDAV_PutQuotaAndDedup(t *testing.T) {
+	env := setupWebDAVTest(t, 15)
+
+	env.expect(t, env.do(t, http.MethodPut, "/dav/a.txt", "0123456789", nil), http.StatusCreated)
+	env.expect(t, env.do(t, http.MethodPut, "/dav/b.txt", "0123456789", nil), http.StatusInsufficientStorage)
+	env.expect(t, env.do(t, http.MethodPut, "/dav/b.txt", "01234", nil), http.StatusCreated)
+
+	var user models.User
+	env.db.First(&user, env.userID)
+	if user.StorageUsed != 15 {
+		t.Errorf("want storage_used 15, got %d", user.StorageUsed)
+	}
+
+	env.expect(t, env.do(t, http.MethodDelete, "/dav/b.txt", "", nil), http.StatusNoContent)
+	env.expect(t, env.do(t, http.MethodPut, "/dav/c.txt", "01234", nil), http.StatusInsufficientStorage)
+}
+
+func TestWebDAV_PutLowDiskSpace(t *testing.T) {
+	env := setupWebDAVTest(t, 1<<20)
+	env.handler.cfg.MinFreeTemp = 1 << 62
+
+	w := env.do(t, http.MethodPut, "/dav/a.txt", "0123456789", nil)
+	env.expect(t, w, http.StatusInsufficientStorage)
+	if !strings.Contains(w.Body.String(), "low on disk space") {
+		t.Errorf("want a low disk space message, got %q", w.Body.String())
+	}
+	var count int64
+	env.db.Model(&models.File{}).Count(&count)
+	if count != 0 {
+		t.Errorf("want no file stored, got %d", count)
+	}
+
+	env.handler.cfg.MinFreeTemp = 0
+	env.expect(t, env.do(t, http.MethodPut, "/dav/a.txt", "0123456789", nil), http.StatusCreated)
+}
+
+func TestWebDAV_PropfindDepth1(t *testing.T) {
+	env := setupWebDAVTest(t, 1000)
+	env.expect(t, env.do(t, "MKCOL", "/dav/docs", "", nil), http.StatusCreated)
+	env.expect(t, env.do(t, http.MethodPut, "/dav/docs/my%20report.pdf", "%PDF", nil), http.StatusCreated)
+	env.expect(t, env.do(t, http.MethodPut, "/dav/top.txt", "top", nil), http.StatusCreated)
+
+	w := env.do(t, "PROPFIND", "/dav/", "", map[string]string{"Depth": "1"})
+	env.expect(t, w, http.StatusMultiStatus)
+	body := w.Body.String()
+	for _, want := range []string{
+		"<D:href>/dav/</D:href>",
+		"<D:href>/dav/docs/</D:href>",
+		"<D:href>/dav/top.txt</D:href>",
+		"<D:collection/>",
+		"<D:getcontentlength>3</D:getcontentlength>",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("PROPFIND response missing %s:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "report") {
+		t.Errorf("Depth 1 should not include grandchildren:\n%s", body)
+	}
+
+	w = env.do(t, "PROPFIND", "/dav/docs/", `<?xml version="1.0"?>
+<propfind xmlns="DAV:"><prop><quota-used-bytes/><x xmlns="urn:example"/></prop></propfind>`, map[string]string{"Depth": "1"})
+	env.expect(t, w, http.StatusMultiStatus)
+	body = w.Body.String()
+	if !strings.Contains(body, "<D:href>/dav/docs/my%20report.pdf</D:href>") {
+		t.Errorf("want escaped child href:\n%s", body)
+	}
+	if !strings.Contains(body, "<D:quota-used-bytes>7</D:quota-used-bytes>") || !strings.Contains(body, "404 Not Found") {
+		t.Errorf("want quota prop and a 404 propstat:\n%s", body)
+	}
+
+	env.expect(t, env.do(t, "PROPFIND", "/dav/", "", nil), http.StatusForbidden)
+	env.expect(t, env.do(t, "PROPFIND", "/dav/nope", "", map[string]string{"Depth": "0"}), http.StatusNotFound)
+}
+
+func TestWebDAV_MkcolErrors(t *testing.T) {
+	env := setupWebDAVTest(t, 1000)
+	env.expect(t, env.do(t, "MKCOL", "/dav/a/b", "", nil), http.StatusConflict)
+	env.expect(t, env.do(t, "MKCOL", "/dav/a", "", nil), http.StatusCreated)
+	env.expect(t, env.do(t, "MKCOL", "/dav/a", "", nil), http.StatusMethodNotAllowed)
+	env.expect(t, env.do(t, "MKCOL", "/dav/b", "<x/>", nil), http.StatusUnsupportedMediaType)
+}
+
+func TestWebDAV_MoveAndCopy(t *testing.T) {
+	env := setupWebDAVTest(t, 1000)
+	env.expect(t, env.do(t, "MKCOL", "/dav/src", "", nil), http.StatusCreated)
+	env.expect(t, env.do(t, "MKCOL", "/dav/src/sub", "", nil), http.StatusCreated)
+	env.expect(t, env.do(t, http.MethodPut, "/dav/src/sub/f.txt", "data", nil), http.StatusCreated)
+
+	env.expect(t, env.do(t, "COPY", "/dav/src", "", map[string]string{"Destination": "/dav/copy"}), http.StatusCreated)
+	w := env.do(t, http.MethodGet, "/dav/copy/sub/f.txt", "", nil)
+	env.expect(t, w, http.StatusOK)
+	if w.Body.String() != "data" {
+		t.Errorf("unexpected copied content %q", w.Body.String())
+	}
+	var user models.User
+	env.db.First(&user, env.userID)
+	if user.StorageUsed != 8 {
+		t.Errorf("copy should be charged to quota, want 8 got %d", user.StorageUsed)
+	}
+
+	env.expect(t, env.do(t, "MOVE", "/dav/src", "", map[string]string{"Destination": "http://example.com/dav/src/sub/x"}), http.StatusForbidden)
+	env.expect(t, env.do(t, "MOVE", "/dav/src", "", map[string]string{"Destination": "http://elsewhere.test/dav/x"}), http.StatusBadGateway)
+	env.expect(t, env.do(t, "MOVE", "/dav/src", "", map[string]string{"Destination": "/dav/copy", "Overwrite": "F"}), http.StatusPreconditionFailed)
+
+	env.expect(t, env.do(t, "MOVE", "/dav/src", "", map[string]string{"Destination": "/dav/moved"}), http.StatusCreated)
+	env.expect(t, env.do(t, http.MethodGet, "/dav/src/sub/f.txt", "", nil), http.StatusNotFound)
+	env.expect(t, env.do(t, http.MethodGet, "/dav/moved/sub/f.txt", "", nil), http.StatusOK)
+
+	env.expect(t, env.do(t, "MOVE", "/dav/moved/sub/f.txt", "", map[string]string{"Destination": "/dav/g.txt"}), http.StatusCreated)
+	env.expect(t, env.do(t, http.MethodGet, "/dav/g.txt", "", nil), http.StatusOK)
+}
+
+func TestWebDAV_DeleteFolderGoesToTrash(t *testing.T) {
+	env := setupWebDAVTest(t, 1000)
+	env.expect(t, env.do(t, "MKCOL", "/dav/docs", "", nil), http.StatusCreated)
+	env.expect(t, env.do(t, http.MethodPut, "/dav/docs/a.txt", "a", nil), http.StatusCreated)
+
+	env.expect(t, env.do(t, http.MethodDelete, "/dav/docs", "", nil), http.StatusNoContent)
+	env.expect(t, env.do(t, "PROPFIND", "/dav/docs", "", map[string]string{"Depth": "0"}), http.StatusNotFound)
+
+	var file models.File
+	env.db.Where("filename = ?", "a.txt").First(&file)
+	if file.SoftDeletedAt == nil || file.OriginalLogicalPath != "/docs" {
+		t.Errorf("want file trashed with original path, got %+v", file)
+	}
+
+	env.expect(t, env.do(t, http.MethodDelete, "/dav/", "", nil), http.StatusForbidden)
+}
+
+func TestWebDAV_LockUnlock(t *testing.T) {
+	env := setupWebDAVTest(t, 1000)
+	env.expect(t, env.do(t, http.MethodPut, "/dav/a.txt", "a", nil), http.StatusCreated)
+
+	w := env.do(t, "LOCK", "/dav/a.txt", `<?xml version="1.0"?>
+<D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockinfo>`, nil)
+	env.expect(t, w, http.StatusOK)
+	token := w.Header().Get("Lock-Token")
+	if !strings.HasPrefix(token, "<opaquelocktoken:") {
+		t.Fatalf("unexpected Lock-Token %q", token)
+	}
+	body, _ := io.ReadAll(w.Body)
+	if !strings.Contains(string(body), "<D:href>/dav/a.txt</D:href>") {
+		t.Errorf("lockdiscovery missing lockroot:\n%s", body)
+	}
+
+	env.expect(t, env.do(t, "LOCK", "/dav/a.txt", "", map[string]string{"If": "(" + token + ")"}), http.StatusOK)
+	env.expect(t, env.do(t, "UNLOCK", "/dav/a.txt", "", map[string]string{"Lock-Token": token}), http.StatusNoContent)
+	env.expect(t, env.do(t, "UNLOCK", "/dav/a.txt", "", map[string]string{"Lock-Token": token}), http.StatusConflict)
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"github.com/agjmills/trove/internal/auth"
+	"github.com/agjmills/trove/internal/database/models"
+	"github.com/agjmills/trove/internal/logger"
+	"github.com/agjmills/trove/internal/storage"
+)
+
+// DownloadZip handles GET /download/zip?folder=/path, which downloads a
+// folder and everything beneath it, and GET /download/zip?id=1&id=2, which
+// downloads a selection of files under the folder they have in common.
+func (h *FileHandler) DownloadZip(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUser(r)
+	if user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	query := h.db.Where("user_id = ? AND trashed_at IS NULL AND upload_status = 'completed'", user.ID)
+	var root, name string
+	if ids := r.URL.Query()["id"]; len(ids) > 0 {
+		for _, id := range ids {
+			if _, err := strconv.ParseUint(id, 10, 64); err != nil {
+				http.Error(w, "Invalid file ID", http.StatusBadRequest)
+				return
+			}
+		}
+		query = query.Where("id IN ?", ids)
+	} else if folder := r.URL.Query().Get("folder"); folder != "" {
+		root = sanitizeFolderPath(folder)
+		name = zipName(root)
+		if root != "/" {
+			query = query.Where("(logical_path = ? OR logical_path LIKE ? ESCAPE '\\')", root, escapeSQLLike(root)+"/%")
+		}
+	} else {
+		http.Error(w, "A folder or file IDs are required", http.StatusBadRequest)
+		return
+	}
+
+	var files []models.File
+	if err := query.Find(&files).Error; err != nil {
+		http.Error(w, "Failed to list files", http.StatusInternalServerError)
+		return
+	}
+	if len(files) == 0 {
+		http.Error(w, "No files to download", http.StatusNotFound)
+		return
+	}
+	if root == "" {
+		root = commonFolder(files)
+		name = zipName(root)
+	}
+
+	streamZip(w, r, h.db, h.storage, files, root, name)
+}
+
+// zipName returns the download name of a ZIP of folder.
+func zipName(folder string) string {
+	if folder == "/" {
+		return "Trove.zip"
+	}
+	return path.Base(folder) + ".zip"
+}
+
+// commonFolder returns the deepest folder that contains every one of files.
+func commonFolder(files []models.File) string {
+	common := files[0].LogicalPath
+	for _, f := range files[1:] {
+		for common != "/" && f.LogicalPath != common && !strings.HasPrefix(f.LogicalPath, common+"/") {
+			common = path.Dir(common)
+		}
+	}
+	return common
+}
+
+// streamZip writes files to the response as a ZIP named name, with each
+// file at its path relative to folder root. The archive is written as it is
+// read from storage, so nothing is buffered on disk; entries past 4 GiB are
+// written in the zip64 format.
+func streamZip(w http.ResponseWriter, r *http.Request, db *gorm.DB, backend storage.StorageBackend, files []models.File, root, name string) {
+	sortFilesByPathAndFilenameNaturally(files)
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", contentDisposition("attachment", name))
+
+	if err := writeZip(r.Context(), w, backend, files, root, func(f *models.File) { recordAccess(db, f) }); err != nil {
+		logger.Error("error streaming zip download", "name", name, "error", err)
+		// The status has been sent, so abort the connection rather than end
+		// the response normally and leave the client with a truncated archive.
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// writeZip writes files to w as a ZIP archive, calling opened for each file
+// whose content has been opened.
+func writeZip(ctx context.Context, w io.Writer, backend storage.StorageBackend, files []models.File, root string, opened func(*models.File)) error {
+	zw := zip.NewWriter(w)
+	used := make(map[string]bool, len(files))
+	for i := range files {
+		file := &files[i]
+		header := &zip.FileHeader{
+			Name:     zipEntryName(used, root, file),
+			Method:   zipMethod(file.MimeType),
+			Modified: file.CreatedAt,
+		}
+		if file.ContentUpdatedAt != nil {
+			header.Modified = *file.ContentUpdatedAt
+		}
+		header.SetMode(0644)
+		if err := writeZipEntry(ctx, zw, backend, header, file); err != nil {
+			return fmt.Errorf("%s: %w", header.Name, err)
+		}
+		if opened != nil {
+			opened(file)
+		}
+	}
+	return zw.Close()
+}
+
+func writeZipEntry(ctx context.Context, zw *zip.Writer, backend storage.StorageBackend, header *zip.FileHeader, file *models.File) error {
+	reader, err := backend.Open(ctx, file.StoragePath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close() //nolint:errcheck
+
+	entry, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, reader)
+	return err
+}
+
+// zipEntryName returns file's path relative to folder root, made unique
+// among the names in used by numbering it as uniqueFilename does.
+func zipEntryName(used map[string]bool, root string, file *models.File) string {
+	dir := strings.TrimPrefix(strings.TrimPrefix(file.LogicalPath, root), "/")
+	if dir != "" {
+		dir += "/"
+	}
+	name := dir + file.Filename
+	ext := path.Ext(file.Filename)
+	base := strings.TrimSuffix(file.Filename, ext)
+	for i := 1; used[name]; i++ {
+		name = fmt.Sprintf("%s%s (%d)%s", dir, base, i, ext)
+	}
+	used[name] = true
+	return name
+}
+
+// zipMethod stores content that is already compressed as is, and deflates
+// everything else.
+func zipMethod(mimeType string) uint16 {
+	switch {
+	case strings.HasPrefix(mimeType, "image/") && mimeType != "image/svg+xml" && mimeType != "image/bmp",
+		strings.HasPrefix(mimeType, "video/"),
+		strings.HasPrefix(mimeType, "audio/") && mimeType != "audio/wav" && mimeType != "audio/x-wav":
+		return zip.Store
+	}
+	switch mimeType {
+	case "application/zip", "application/gzip", "application/x-gzip", "application/x-7z-compressed",
+		"application/x-bzip2", "application/x-xz", "application/zstd", "application/x-rar-compressed",
+		"application/vnd.rar":
+		return zip.Store
+	}
+	return zip.Deflate
+}
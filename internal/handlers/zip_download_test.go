@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/agjmills/trove/internal/database/models"
+)
+
+// readZip returns the content of every entry in a ZIP response by name.
+func readZip(t *testing.T, w *httptest.ResponseRecorder) map[string]string {
+	t.Helper()
+	if w.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/zip" {
+		t.Errorf("Content-Type = %q, want application/zip", ct)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("response is not a ZIP: %v", err)
+	}
+	entries := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("open %s: %v", f.Name, err)
+		}
+		data, _ := io.ReadAll(rc)
+		_ = rc.Close()
+		entries[f.Name] = string(data)
+	}
+	return entries
+}
+
+func entryNames(entries map[string]string) []string {
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestDownloadZip_Folder(t *testing.T) {
+	app := newFileTestApp(t)
+	user := app.createTestUser(t, "zipfolderuser")
+
+	report := app.createTestFile(t, user, "report.txt", "report")
+	nested := app.createTestFile(t, user, "nested.txt", "nested")
+	outside := app.createTestFile(t, user, "outside.txt", "outside")
+	trashed := app.createTestFile(t, user, "trashed.txt", "trashed")
+	sibling := app.createTestFile(t, user, "sibling.txt", "sibling")
+	app.db.Model(report).Update("logical_path", "/docs")
+	app.db.Model(nested).Update("logical_path", "/docs/sub")
+	app.db.Model(outside).Update("logical_path", "/other")
+	app.db.Model(trashed).Updates(map[string]interface{}{"logical_path": "/docs", "trashed_at": time.Now()})
+	app.db.Model(sibling).Update("logical_path", "/docs2")
+
+	req := app.authenticatedRequest(t, http.MethodGet, "/download/zip?folder=/docs", nil, user)
+	w := httptest.NewRecorder()
+	app.fileHandler.DownloadZip(w, req)
+
+	entries := readZip(t, w)
+	if names := entryNames(entries); len(names) != 2 || names[0] != "report.txt" || names[1] != "sub/nested.txt" {
+		t.Fatalf("entries = %v, want report.txt and sub/nested.txt", names)
+	}
+	if entries["sub/nested.txt"] != "nested" {
+		t.Errorf("sub/nested.txt = %q", entries["sub/nested.txt"])
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != contentDisposition("attachment", "docs.zip") {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+
+	var file models.File
+	app.db.First(&file, report.ID)
+	if file.LastAccessedAt == nil {
+		t.Error("zipped file's access was not recorded")
+	}
+}
+
+func TestDownloadZip_Selection(t *testing.T) {
+	app := newFileTestApp(t)
+	user := app.createTestUser(t, "zipselectuser")
+	other := app.createTestUser(t, "zipotheruser")
+
+	a := app.createTestFile(t, user, "a.txt", "a")
+	b := app.createTestFile(t, user, "b.txt", "b")
+	notMine := app.createTestFile(t, other, "c.txt", "c")
+	app.db.Model(a).Update("logical_path", "/photos/2024")
+	app.db.Model(b).Update("logical_path", "/photos/2025/june")
+
+	req := app.authenticatedRequest(t, http.MethodGet, "/download/zip?id="+fmt.Sprint(a.ID)+"&id="+fmt.Sprint(b.ID)+"&id="+fmt.Sprint(notMine.ID), nil, user)
+	w := httptest.NewRecorder()
+	app.fileHandler.DownloadZip(w, req)
+
+	entries := readZip(t, w)
+	if names := entryNames(entries); len(names) != 2 || names[0] != "2024/a.txt" || names[1] != "2025/june/b.txt" {
+		t.Fatalf("entries = %v, want paths under /photos", names)
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != contentDisposition("attachment", "photos.zip") {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+}
+
+func TestDownloadZip_BadRequests(t *testing.T) {
+	app := newFileTestApp(t)
+	user := app.createTestUser(t, "zipbaduser")
+
+	for target, want := range map[string]int{
+		"/download/zip":                http.StatusBadRequest,
+		"/download/zip?id=abc":         http.StatusBadRequest,
+		"/download/zip?id=999":         http.StatusNotFound,
+		"/download/zip?folder=/nobody": http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		app.fileHandler.DownloadZip(w, app.authenticatedRequest(t, http.MethodGet, target, nil, user))
+		if w.Code != want {
+			t.Errorf("%s = %d, want %d", target, w.Code, want)
+		}
+	}
+}
+
+func TestZipEntryName_Unique(t *testing.T) {
+	used := map[string]bool{}
+	file := &models.File{LogicalPath: "/a/b", Filename: "x.txt"}
+	for _, want := range []string{"b/x.txt", "b/x (1).txt", "b/x (2).txt"} {
+		if got := zipEntryName(used, "/a", file); got != want {
+			t.Errorf("zipEntryName = %q, want %q", got, want)
+		}
+	}
+}
+
+func makeFolderZipRequest(t *testing.T, h *FolderShareHandler, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/f/"+token+"/zip", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("token", token)
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	w := httptest.NewRecorder()
+	h.sessionManager.LoadAndSave(http.HandlerFunc(h.DownloadSharedFolderZip)).ServeHTTP(w, req)
+	return w
+}
+
+func TestDownloadSharedFolderZip(t *testing.T) {
+	h, db, user, _ := setupFolderShareTest(t)
+	createTestFileInFolder(t, db, user.ID, "/docs", "report.pdf")
+	createTestFileInFolder(t, db, user.ID, "/docs/sub", "nested.txt")
+	createTestFileInFolder(t, db, user.ID, "/other", "private.txt")
+	link := createFolderShareLink(t, db, user.ID, "/docs", nil, nil)
+
+	entries := readZip(t, makeFolderZipRequest(t, h, link.Token))
+	if names := entryNames(entries); len(names) != 2 || names[0] != "report.pdf" || names[1] != "sub/nested.txt" {
+		t.Fatalf("entries = %v, want the shared folder's files only", names)
+	}
+	if entries["report.pdf"] != "hello" {
+		t.Errorf("report.pdf = %q", entries["report.pdf"])
+	}
+
+	protected := createFolderShareLinkWithPassword(t, db, user.ID, "/docs", nil, nil, "pw")
+	if w := makeFolderZipRequest(t, h, protected.Token); w.Code != http.StatusSeeOther {
+		t.Errorf("want 303 redirect for a locked share, got %d", w.Code)
+	}
+	if w := makeFolderZipRequest(t, h, "nope"); w.Code != http.StatusNotFound {
+		t.Errorf("want 404 for an unknown token, got %d", w.Code)
+	}
+}
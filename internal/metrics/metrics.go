@@ -58,6 +58,169 @@ var (
 		[]string{"user_id"},
 	)
 
+	// Integrity scrub metrics
+	ScrubObjectsChecked = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "trove_scrub_objects_checked_total",
+			Help: "Total number of stored objects checked by the integrity scrubber, by result",
+		},
+		[]string{"result"},
+	)
+
+	ScrubBytesRead = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "trove_scrub_bytes_read_total",
+			Help: "Total bytes read from storage by the integrity scrubber",
+		},
+	)
+
+	ScrubLastRunTimestamp = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "trove_scrub_last_run_timestamp_seconds",
+			Help: "Unix time the last integrity scrub run finished",
+		},
+	)
+
+	FilesByIntegrityStatus = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "trove_files_integrity_status",
+			Help: "Number of files by integrity status from the last scrub (unverified, ok, missing, corrupted)",
+		},
+		[]string{"status"},
+	)
+
+	// Garbage collection metrics
+	GCOrphansDeleted = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "trove_gc_orphans_deleted_total",
+			Help: "Total number of orphaned storage objects deleted by garbage collection",
+		},
+	)
+
+	GCBytesReclaimed = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "trove_gc_reclaimed_bytes_total",
+			Help: "Total bytes reclaimed by deleting orphaned storage objects",
+		},
+	)
+
+	GCOrphansFound = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "trove_gc_orphans_found",
+			Help: "Orphaned storage objects found by the last garbage collection run, including those too recent to delete",
+		},
+	)
+
+	GCUploadsAborted = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "trove_gc_uploads_aborted_total",
+			Help: "Total number of incomplete uploads (such as S3 multipart uploads) aborted by garbage collection",
+		},
+	)
+
+	GCLastRunTimestamp = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "trove_gc_last_run_timestamp_seconds",
+			Help: "Unix time the last garbage collection run finished",
+		},
+	)
+
+	// Storage mirroring metrics
+	MirrorFailovers = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "trove_mirror_failovers_total",
+			Help: "Total number of reads served by a storage mirror because the members before it failed, by serving member",
+		},
+		[]string{"member"},
+	)
+
+	MirrorObjectsRepaired = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "trove_mirror_objects_repaired_total",
+			Help: "Total number of object copies restored by mirror repair, by member",
+		},
+		[]string{"member"},
+	)
+
+	MirrorMissingCopies = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "trove_mirror_missing_copies",
+			Help: "Objects known to be missing from each storage mirror member",
+		},
+		[]string{"member"},
+	)
+
+	MirrorLastRepairTimestamp = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "trove_mirror_last_repair_timestamp_seconds",
+			Help: "Unix time the last mirror repair run finished",
+		},
+	)
+
+	// Storage tiering metrics
+	TieringObjectsMoved = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "trove_tiering_objects_moved_total",
+			Help: "Total number of objects moved between storage tiers, by direction (demoted, promoted)",
+		},
+		[]string{"direction"},
+	)
+
+	TieringBytesMoved = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "trove_tiering_moved_bytes_total",
+			Help: "Total bytes moved between storage tiers, by direction (demoted, promoted)",
+		},
+		[]string{"direction"},
+	)
+
+	TieringLastRunTimestamp = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "trove_tiering_last_run_timestamp_seconds",
+			Help: "Unix time the last tiering run finished",
+		},
+	)
+
+	// Disk space metrics
+	DiskFreeBytes = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "trove_disk_free_bytes",
+			Help: "Free bytes on the volumes uploads are written to, by volume (storage, temp)",
+		},
+		[]string{"volume"},
+	)
+
+	DiskMinFreeBytes = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "trove_disk_min_free_bytes",
+			Help: "Free bytes below which uploads are refused, by volume (storage, temp)",
+		},
+		[]string{"volume"},
+	)
+
+	// Storage cache metrics
+	StorageCacheRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "trove_storage_cache_requests_total",
+			Help: "Total number of object and block reads through the local storage cache, by result (hit, miss)",
+		},
+		[]string{"result"},
+	)
+
+	StorageCacheEvictions = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "trove_storage_cache_evictions_total",
+			Help: "Total number of blocks evicted from the local storage cache to stay within its size limit",
+		},
+	)
+
+	StorageCacheBytes = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "trove_storage_cache_bytes",
+			Help: "Bytes held in the local storage cache",
+		},
+	)
+
 	// Authentication metrics
 	LoginAttempts = promauto.NewCounterVec(
 		prometheus.CounterOpts{
@@ -0,0 +1,232 @@
+// Package migrate copies stored objects from one storage backend to another,
+// for example when moving an instance from disk to S3.
+//
+// A migration runs in two steps. Copy transfers every object referenced by
+// a file, verifying each against its SHA-256 hash, and can run while Trove
+// keeps serving from the source. Rewrite then points the database at the
+// copies; Trove must be stopped for it and restarted on the destination
+// backend. Source objects are never modified or deleted.
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+
+	"gorm.io/gorm"
+
+	"github.com/agjmills/trove/internal/database"
+	"github.com/agjmills/trove/internal/logger"
+	"github.com/agjmills/trove/internal/storage"
+)
+
+// progressInterval is how many copied objects pass between progress logs.
+const progressInterval = 100
+
+// Migrator copies objects between two backends.
+type Migrator struct {
+	db      *gorm.DB
+	src     storage.StorageBackend
+	dst     storage.StorageBackend
+	state   *State
+	workers int
+}
+
+// Summary counts objects and bytes in a migration.
+type Summary struct {
+	Objects     int   // Objects referenced by files
+	Bytes       int64 // Their total size
+	Done        int   // Objects copied by an earlier run
+	DoneBytes   int64
+	Copied      int // Objects copied by this run
+	CopiedBytes int64
+	Failed      int
+}
+
+// Remaining returns the objects and bytes still to copy.
+func (s Summary) Remaining() (int, int64) {
+	return s.Objects - s.Done - s.Copied, s.Bytes - s.DoneBytes - s.CopiedBytes
+}
+
+// New returns a Migrator that copies from src to dst with the given number
+// of parallel workers, recording progress in state.
+func New(db *gorm.DB, src, dst storage.StorageBackend, state *State, workers int) *Migrator {
+	return &Migrator{db: db, src: src, dst: dst, state: state, workers: max(workers, 1)}
+}
+
+// Plan returns a summary of the migration and the objects still to copy,
+// without copying anything.
+func (m *Migrator) Plan() (Summary, []database.StoredObject, error) {
+	objects, err := database.StoredObjects(m.db)
+	if err != nil {
+		return Summary{}, nil, fmt.Errorf("failed to list stored objects: %w", err)
+	}
+
+	var summary Summary
+	var pending []database.StoredObject
+	for _, obj := range objects {
+		summary.Objects++
+		summary.Bytes += obj.Size
+		if m.copied(obj.Path) {
+			summary.Done++
+			summary.DoneBytes += obj.Size
+			continue
+		}
+		pending = append(pending, obj)
+	}
+	return summary, pending, nil
+}
+
+// Copy copies every object not yet copied. Failures are logged and counted
+// in the summary; the returned error is for failures of the whole run.
+func (m *Migrator) Copy(ctx context.Context) (Summary, error) {
+	summary, pending, err := m.Plan()
+	if err != nil {
+		return summary, err
+	}
+
+	var mu sync.Mutex
+	jobs := make(chan database.StoredObject)
+	var wg sync.WaitGroup
+	for range m.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for obj := range jobs {
+				dest, err := m.copyObject(ctx, obj)
+				if err == nil {
+					err = m.state.Record(obj.Path, dest)
+				}
+
+				mu.Lock()
+				if err != nil {
+					summary.Failed++
+					logger.Error("failed to copy object", "path", obj.Path, "error", err)
+				} else {
+					summary.Copied++
+					summary.CopiedBytes += obj.Size
+					if summary.Copied%progressInterval == 0 {
+						objects, bytes := summary.Remaining()
+						logger.Info("migration progress", "copied", summary.Copied, "remaining_objects", objects, "remaining_bytes", bytes)
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, obj := range pending {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- obj
+	}
+	close(jobs)
+	wg.Wait()
+
+	return summary, ctx.Err()
+}
+
+// Rewrite points every file at the copies of its objects and returns how
+// many objects were repointed. Objects without a copy are left alone, so
+// run it after a Copy with no failures.
+func (m *Migrator) Rewrite() (int, error) {
+	objects, err := database.StoredObjects(m.db)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list stored objects: %w", err)
+	}
+
+	count := 0
+	err = m.db.Transaction(func(tx *gorm.DB) error {
+		for _, obj := range objects {
+			dest, ok := m.state.Lookup(obj.Path)
+			if !ok {
+				continue
+			}
+			if err := database.ReplaceStoragePath(tx, obj.Path, dest); err != nil {
+				return fmt.Errorf("failed to update %s: %w", obj.Path, err)
+			}
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// copied reports whether the object at path, as the database names it, is
+// already on the destination.
+func (m *Migrator) copied(path string) bool {
+	if _, ok := m.state.Lookup(path); ok {
+		return true
+	}
+	return m.state.IsDest(path)
+}
+
+// copyObject copies one object and verifies the copy, returning its path on
+// the destination. A copy that fails verification is deleted.
+func (m *Migrator) copyObject(ctx context.Context, obj database.StoredObject) (string, error) {
+	info, err := m.src.Stat(ctx, obj.Path)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat source: %w", err)
+	}
+	r, err := m.src.Open(ctx, obj.Path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open source: %w", err)
+	}
+	defer r.Close() //nolint:errcheck
+
+	hasher := sha256.New()
+	result, err := m.dst.Save(ctx, io.TeeReader(r, hasher), storage.SaveOptions{
+		OriginalFilename: obj.Path,
+		ContentType:      info.ContentType,
+		Policy:           storage.PolicyFor(m.dst, obj.Path), // Kept if the destination has it too
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to write destination: %w", err)
+	}
+
+	// Video variants have no recorded hash, so check the copy against the
+	// source instead
+	want := hex.EncodeToString(hasher.Sum(nil))
+	if obj.Hash != "" && want != obj.Hash {
+		m.discard(result.Path)
+		return "", fmt.Errorf("source object does not match its recorded hash (got %s, want %s)", want, obj.Hash)
+	}
+	got, err := hashObject(ctx, m.dst, result.Path)
+	if err != nil {
+		m.discard(result.Path)
+		return "", fmt.Errorf("failed to read back destination: %w", err)
+	}
+	if got != want {
+		m.discard(result.Path)
+		return "", fmt.Errorf("destination copy does not match (got %s, want %s)", got, want)
+	}
+	return result.Path, nil
+}
+
+// discard deletes a copy that failed verification.
+func (m *Migrator) discard(path string) {
+	if err := m.dst.Delete(context.Background(), path); err != nil {
+		logger.Warn("failed to delete bad copy", "path", path, "error", err)
+	}
+}
+
+func hashObject(ctx context.Context, backend storage.StorageBackend, path string) (string, error) {
+	r, err := backend.Open(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close() //nolint:errcheck
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
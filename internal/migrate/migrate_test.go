@@ -0,0 +1,236 @@
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/agjmills/trove/internal/database/models"
+	"github.com/agjmills/trove/internal/storage"
+)
+
+func newMigrateTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := fmt.Sprintf("file:migrate-%d?mode=memory&cache=shared", time.Now().UnixNano())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to open test db: %v", err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.File{}, &models.FileVersion{}, &models.Blob{}); err != nil {
+		t.Fatalf("failed to migrate test db: %v", err)
+	}
+	return db
+}
+
+// storeTestFile saves content to backend and creates a completed file row
+// referencing it.
+func storeTestFile(t *testing.T, db *gorm.DB, backend storage.StorageBackend, name, content string) *models.File {
+	t.Helper()
+	result, err := backend.Save(context.Background(), strings.NewReader(content), storage.SaveOptions{OriginalFilename: name})
+	if err != nil {
+		t.Fatalf("failed to save %s: %v", name, err)
+	}
+	file := &models.File{
+		UserID:           1,
+		StoragePath:      result.Path,
+		LogicalPath:      "/",
+		Filename:         name,
+		OriginalFilename: name,
+		FileSize:         result.Size,
+		Hash:             result.Hash,
+		UploadStatus:     "completed",
+	}
+	if err := db.Create(file).Error; err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	return file
+}
+
+func readObject(t *testing.T, backend storage.StorageBackend, path string) string {
+	t.Helper()
+	r, err := backend.Open(context.Background(), path)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", path, err)
+	}
+	defer r.Close() //nolint:errcheck
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", path, err)
+	}
+	return string(data)
+}
+
+func openTestState(t *testing.T, path string) *State {
+	t.Helper()
+	state, err := OpenState(path)
+	if err != nil {
+		t.Fatalf("OpenState failed: %v", err)
+	}
+	t.Cleanup(func() { _ = state.Close() })
+	return state
+}
+
+func TestMigrateCopiesAndRewrites(t *testing.T) {
+	ctx := context.Background()
+	db := newMigrateTestDB(t)
+	src, dst := storage.NewMemoryBackend(), storage.NewMemoryBackend()
+
+	doc := storeTestFile(t, db, src, "doc.txt", "hello world")
+	// A deduplicated copy shares the object
+	dup := *doc
+	dup.ID, dup.Filename = 0, "copy.txt"
+	if err := db.Create(&dup).Error; err != nil {
+		t.Fatal(err)
+	}
+	// Files in deleted items are migrated too
+	trashed := storeTestFile(t, db, src, "old.txt", "in the trash")
+	db.Model(trashed).Update("trashed_at", time.Now())
+	// A video with a variant
+	video := storeTestFile(t, db, src, "movie.mkv", "original video")
+	variant, err := src.Save(ctx, strings.NewReader("web video"), storage.SaveOptions{OriginalFilename: "movie.mp4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Model(video).Updates(map[string]any{"video_variant_path": variant.Path, "video_variant_size": variant.Size})
+	// Uploads still processing have placeholder paths and are skipped
+	db.Create(&models.File{UserID: 1, StoragePath: "pending-x.bin", Filename: "p", OriginalFilename: "p", UploadStatus: "pending"})
+
+	statePath := filepath.Join(t.TempDir(), "state")
+	m := New(db, src, dst, openTestState(t, statePath), 2)
+
+	summary, pending, err := m.Plan()
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	wantBytes := int64(len("hello world") + len("in the trash") + len("original video") + len("web video"))
+	if summary.Objects != 4 || summary.Bytes != wantBytes || len(pending) != 4 {
+		t.Fatalf("unexpected plan: %+v, %d pending", summary, len(pending))
+	}
+	if dst.FileCount() != 0 {
+		t.Fatal("Plan must not copy anything")
+	}
+
+	summary, err = m.Copy(ctx)
+	if err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	if summary.Copied != 4 || summary.Failed != 0 || summary.CopiedBytes != wantBytes {
+		t.Fatalf("unexpected copy summary: %+v", summary)
+	}
+	if dst.FileCount() != 4 {
+		t.Errorf("expected 4 objects on the destination, got %d", dst.FileCount())
+	}
+
+	// The database is untouched until Rewrite
+	var reloaded models.File
+	db.First(&reloaded, doc.ID)
+	if reloaded.StoragePath != doc.StoragePath {
+		t.Fatal("Copy must not change storage paths")
+	}
+
+	// Resuming with the saved state copies nothing
+	m = New(db, src, dst, openTestState(t, statePath), 2)
+	if summary, err := m.Copy(ctx); err != nil || summary.Copied != 0 || summary.Done != 4 {
+		t.Fatalf("expected resumed copy to skip everything, got %+v, %v", summary, err)
+	}
+
+	count, err := m.Rewrite()
+	if err != nil || count != 4 {
+		t.Fatalf("Rewrite = %d, %v", count, err)
+	}
+	var files []models.File
+	db.Unscoped().Where("upload_status = ?", "completed").Find(&files)
+	want := map[string]string{"doc.txt": "hello world", "copy.txt": "hello world", "old.txt": "in the trash", "movie.mkv": "original video"}
+	for _, f := range files {
+		if got := readObject(t, dst, f.StoragePath); got != want[f.Filename] {
+			t.Errorf("%s: destination content = %q", f.Filename, got)
+		}
+		if f.VideoVariantPath != "" && readObject(t, dst, f.VideoVariantPath) != "web video" {
+			t.Errorf("%s: variant not rewritten", f.Filename)
+		}
+	}
+
+	// After the rewrite, another run sees everything as done
+	if summary, _, err := m.Plan(); err != nil || summary.Done != 4 {
+		t.Errorf("expected all objects done after rewrite, got %+v, %v", summary, err)
+	}
+	if src.FileCount() != 4 {
+		t.Error("source objects must not be deleted")
+	}
+}
+
+func TestMigrateRejectsCorruptSource(t *testing.T) {
+	ctx := context.Background()
+	db := newMigrateTestDB(t)
+	src, dst := storage.NewMemoryBackend(), storage.NewMemoryBackend()
+
+	good := storeTestFile(t, db, src, "good.txt", "good")
+	bad := storeTestFile(t, db, src, "bad.txt", "bad")
+	sum := sha256.Sum256([]byte("something else"))
+	db.Model(bad).Update("hash", hex.EncodeToString(sum[:]))
+
+	state := openTestState(t, filepath.Join(t.TempDir(), "state"))
+	m := New(db, src, dst, state, 1)
+	summary, err := m.Copy(ctx)
+	if err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	if summary.Copied != 1 || summary.Failed != 1 {
+		t.Fatalf("expected one copy and one failure, got %+v", summary)
+	}
+	if dst.FileCount() != 1 {
+		t.Errorf("expected the bad copy to be removed, %d objects on destination", dst.FileCount())
+	}
+	if _, ok := state.Lookup(bad.StoragePath); ok {
+		t.Error("failed object must not be recorded as copied")
+	}
+	if _, ok := state.Lookup(good.StoragePath); !ok {
+		t.Error("good object should be recorded as copied")
+	}
+}
+
+func TestStateIgnoresTruncatedLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state")
+	state := openTestState(t, path)
+	if err := state.Record("a.bin", "x.bin"); err != nil {
+		t.Fatal(err)
+	}
+	// Simulate a crash part way through writing the next line
+	if _, err := state.file.Write([]byte(`{"src":"b.b`)); err != nil {
+		t.Fatal(err)
+	}
+	_ = state.Close()
+
+	state = openTestState(t, path)
+	if dest, ok := state.Lookup("a.bin"); !ok || dest != "x.bin" {
+		t.Errorf("Lookup(a.bin) = %q, %v", dest, ok)
+	}
+	if _, ok := state.Lookup("b.b"); ok {
+		t.Error("truncated entry must be ignored")
+	}
+	if !state.IsDest("x.bin") {
+		t.Error("expected x.bin to be a destination path")
+	}
+
+	// Entries recorded after the truncated line survive another reopen
+	if err := state.Record("c.bin", "z.bin"); err != nil {
+		t.Fatal(err)
+	}
+	_ = state.Close()
+	state = openTestState(t, path)
+	if dest, ok := state.Lookup("c.bin"); !ok || dest != "z.bin" {
+		t.Errorf("Lookup(c.bin) = %q, %v", dest, ok)
+	}
+}
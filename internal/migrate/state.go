@@ -0,0 +1,113 @@
+package migrate
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// State records which objects have been copied, so an interrupted migration
+// can resume. It is an append-only file of JSON lines, one per copied object,
+// so a crash loses at most the line being written.
+type State struct {
+	mu     sync.Mutex
+	file   *os.File
+	copied map[string]string // Source path -> destination path
+	dest   map[string]bool   // Destination paths, for runs after the rewrite
+}
+
+type stateEntry struct {
+	Source string `json:"src"`
+	Dest   string `json:"dst"`
+}
+
+// OpenState loads the state file at path, creating it if needed.
+func OpenState(path string) (*State, error) {
+	s := &State{copied: map[string]string{}, dest: map[string]bool{}}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state file: %w", err)
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e stateEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.Source == "" || e.Dest == "" {
+			// A line cut short by a crash; its object is copied again
+			continue
+		}
+		s.copied[e.Source] = e.Dest
+		s.dest[e.Dest] = true
+	}
+	if err := scanner.Err(); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	// Start a fresh line after a truncated one
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			if _, err := f.Write([]byte{'\n'}); err != nil {
+				_ = f.Close()
+				return nil, fmt.Errorf("failed to write state file: %w", err)
+			}
+		}
+	}
+	s.file = f
+	return s, nil
+}
+
+// Lookup returns the destination path of a copied source object.
+func (s *State) Lookup(source string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dest, ok := s.copied[source]
+	return dest, ok
+}
+
+// IsDest reports whether path is the destination of a copied object, which
+// is what the database holds once paths have been rewritten.
+func (s *State) IsDest(path string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dest[path]
+}
+
+// Record stores a copied object and syncs it to disk.
+func (s *State) Record(source, dest string) error {
+	line, err := json.Marshal(stateEntry{Source: source, Dest: dest})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return errors.New("state file is closed")
+	}
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync state file: %w", err)
+	}
+	s.copied[source] = dest
+	s.dest[dest] = true
+	return nil
+}
+
+// Close closes the state file.
+func (s *State) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
@@ -0,0 +1,185 @@
+// Package mirror records the replication state of a mirrored storage backend
+// (see storage.MirroredBackend) and repairs it: copies missing from a member,
+// because a write to it failed or the volume behind it was lost, are copied
+// back from a member that still has the object.
+package mirror
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/agjmills/trove/internal/database"
+	"github.com/agjmills/trove/internal/database/models"
+	"github.com/agjmills/trove/internal/logger"
+	"github.com/agjmills/trove/internal/metrics"
+	"github.com/agjmills/trove/internal/storage"
+)
+
+// Log records replication events in the replicas table. It implements
+// storage.ReplicaLog.
+type Log struct {
+	db *gorm.DB
+}
+
+// NewLog returns a Log writing to db.
+func NewLog(db *gorm.DB) *Log {
+	return &Log{db: db}
+}
+
+// Missing records that member lacks path.
+func (l *Log) Missing(path, member string, err error) {
+	logger.Warn("storage mirror is missing an object", "path", path, "member", member, "error", err)
+	msg := ""
+	if err != nil {
+		msg = err.Error()
+		if len(msg) > 500 {
+			msg = msg[:500]
+		}
+	}
+	if err := l.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "storage_path"}, {Name: "member"}},
+		DoUpdates: clause.AssignmentColumns([]string{"error", "updated_at"}),
+	}).Create(&models.Replica{StoragePath: path, Member: member, Error: msg}).Error; err != nil {
+		logger.Error("failed to record missing replica", "path", path, "member", member, "error", err)
+	}
+}
+
+// Replicated records that member holds path.
+func (l *Log) Replicated(path, member string) {
+	if err := l.db.Where("storage_path = ? AND member = ?", path, member).Delete(&models.Replica{}).Error; err != nil {
+		logger.Error("failed to record replica", "path", path, "member", member, "error", err)
+	}
+}
+
+// Failover counts a read served by a secondary.
+func (l *Log) Failover(path, member string, err error) {
+	metrics.MirrorFailovers.WithLabelValues(member).Inc()
+	logger.Warn("storage read failed over to mirror", "path", path, "member", member, "error", err)
+}
+
+// Deleted forgets path.
+func (l *Log) Deleted(path string) {
+	if err := l.db.Where("storage_path = ?", path).Delete(&models.Replica{}).Error; err != nil {
+		logger.Error("failed to forget replicas", "path", path, "error", err)
+	}
+}
+
+// Repairer re-copies stored objects to the members of a mirrored backend
+// that lack them.
+type Repairer struct {
+	db      *gorm.DB
+	backend *storage.MirroredBackend
+}
+
+// Report describes a repair run.
+type Report struct {
+	Objects  int // Objects referenced by files
+	Repaired int // Copies restored
+	Lost     int // Objects no member has
+	Failed   int // Objects that could not be checked or copied everywhere
+}
+
+// NewRepairer returns a Repairer for backend.
+func NewRepairer(db *gorm.DB, backend *storage.MirroredBackend) *Repairer {
+	return &Repairer{db: db, backend: backend}
+}
+
+// Run checks every object referenced by a file in every member and copies
+// it to the members that lack it.
+func (r *Repairer) Run(ctx context.Context) (Report, error) {
+	var report Report
+	objects, err := database.StoredObjects(r.db)
+	if err != nil {
+		return report, err
+	}
+
+	stored := make(map[string]bool, len(objects))
+	for _, obj := range objects {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		stored[obj.Path] = true
+		report.Objects++
+
+		copied, err := r.backend.Repair(ctx, obj.Path)
+		for _, member := range copied {
+			metrics.MirrorObjectsRepaired.WithLabelValues(member).Inc()
+			logger.Info("restored mirrored object", "path", obj.Path, "member", member)
+		}
+		report.Repaired += len(copied)
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			report.Lost++
+			logger.Error("object is missing from every storage mirror member", "path", obj.Path)
+		case err != nil:
+			report.Failed++
+			logger.Error("failed to repair mirrored object", "path", obj.Path, "error", err)
+		}
+	}
+
+	// Forget objects deleted since they were found missing
+	var recorded []string
+	if err := r.db.Model(&models.Replica{}).Distinct().Pluck("storage_path", &recorded).Error; err != nil {
+		return report, err
+	}
+	for _, path := range recorded {
+		if !stored[path] {
+			r.db.Where("storage_path = ?", path).Delete(&models.Replica{})
+		}
+	}
+
+	r.updateMetrics()
+	metrics.MirrorLastRepairTimestamp.Set(float64(time.Now().Unix()))
+	return report, nil
+}
+
+// updateMetrics sets the missing copies gauge from the replicas table.
+func (r *Repairer) updateMetrics() {
+	var counts []struct {
+		Member string
+		Count  int64
+	}
+	if err := r.db.Model(&models.Replica{}).Select("member, COUNT(*) AS count").Group("member").Scan(&counts).Error; err != nil {
+		logger.Error("failed to count missing replicas", "error", err)
+		return
+	}
+	for _, member := range r.backend.Members() {
+		metrics.MirrorMissingCopies.WithLabelValues(member.Name).Set(0)
+	}
+	for _, c := range counts {
+		metrics.MirrorMissingCopies.WithLabelValues(c.Member).Set(float64(c.Count))
+	}
+}
+
+// Schedule runs a repair now and then every interval until ctx is
+// cancelled, logging the results.
+func (r *Repairer) Schedule(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		report, err := r.Run(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Error("mirror repair failed", "error", err)
+		} else {
+			logger.Info("mirror repair finished",
+				"objects", report.Objects,
+				"repaired", report.Repaired,
+				"lost", report.Lost,
+				"failed", report.Failed,
+			)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
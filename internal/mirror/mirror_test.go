@@ -0,0 +1,124 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/agjmills/trove/internal/database/models"
+	"github.com/agjmills/trove/internal/storage"
+)
+
+func newMirrorTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := fmt.Sprintf("file:mirror-%d?mode=memory&cache=shared", time.Now().UnixNano())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to open test db: %v", err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.File{}, &models.FileVersion{}, &models.Replica{}); err != nil {
+		t.Fatalf("failed to migrate test db: %v", err)
+	}
+	return db
+}
+
+func newTestMirror(t *testing.T, db *gorm.DB) (*storage.MirroredBackend, *storage.MemoryBackend, *storage.MemoryBackend) {
+	t.Helper()
+	primary, secondary := storage.NewMemoryBackend(), storage.NewMemoryBackend()
+	m, err := storage.NewMirroredBackend(primary, storage.Member{Name: "memory:backup", Backend: secondary})
+	if err != nil {
+		t.Fatalf("NewMirroredBackend failed: %v", err)
+	}
+	m.SetReplicaLog(NewLog(db))
+	return m, primary, secondary
+}
+
+func storeFile(t *testing.T, db *gorm.DB, backend storage.StorageBackend, content string) string {
+	t.Helper()
+	result, err := backend.Save(context.Background(), strings.NewReader(content), storage.SaveOptions{OriginalFilename: "f.txt"})
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	db.Create(&models.File{UserID: 1, Filename: "f.txt", OriginalFilename: "f.txt", StoragePath: result.Path,
+		FileSize: result.Size, Hash: result.Hash, UploadStatus: "completed"})
+	return result.Path
+}
+
+func missingCopies(db *gorm.DB) []models.Replica {
+	var replicas []models.Replica
+	db.Order("storage_path, member").Find(&replicas)
+	return replicas
+}
+
+func TestLogRecordsMissingCopies(t *testing.T) {
+	ctx := context.Background()
+	db := newMirrorTestDB(t)
+	m, primary, _ := newTestMirror(t, db)
+
+	path := storeFile(t, db, m, "content")
+	if err := primary.Delete(ctx, path); err != nil {
+		t.Fatal(err)
+	}
+
+	// A read failing over records the primary's copy as missing, once
+	for range 2 {
+		if _, err := m.Stat(ctx, path); err != nil {
+			t.Fatalf("Stat failed: %v", err)
+		}
+	}
+	replicas := missingCopies(db)
+	if len(replicas) != 1 || replicas[0].StoragePath != path || replicas[0].Member != storage.PrimaryMember {
+		t.Fatalf("unexpected replicas: %+v", replicas)
+	}
+
+	// Deleting the object forgets it
+	if err := m.Delete(ctx, path); err != nil {
+		t.Fatal(err)
+	}
+	if replicas := missingCopies(db); len(replicas) != 0 {
+		t.Errorf("expected no replicas after delete, got %+v", replicas)
+	}
+}
+
+func TestRepairerRestoresLostMember(t *testing.T) {
+	ctx := context.Background()
+	db := newMirrorTestDB(t)
+	m, primary, secondary := newTestMirror(t, db)
+
+	first := storeFile(t, db, m, "first")
+	second := storeFile(t, db, m, "second")
+	lost := storeFile(t, db, primary, "never mirrored")
+	_ = primary.Delete(ctx, lost)
+	db.Create(&models.Replica{StoragePath: "deleted-long-ago", Member: "memory:backup"})
+
+	// The secondary volume was lost
+	secondary.Clear()
+
+	report, err := NewRepairer(db, m).Run(ctx)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if report.Objects != 3 || report.Repaired != 2 || report.Lost != 1 || report.Failed != 0 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	for _, path := range []string{first, second} {
+		if _, err := secondary.Stat(ctx, path); err != nil {
+			t.Errorf("%s was not restored to the secondary: %v", path, err)
+		}
+	}
+	if _, err := secondary.Stat(ctx, lost); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("lost object should stay lost, got %v", err)
+	}
+	if replicas := missingCopies(db); len(replicas) != 0 {
+		t.Errorf("expected replication state to be clean, got %+v", replicas)
+	}
+}
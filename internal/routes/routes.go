@@ -119,7 +119,7 @@ func getClientIP(r *http.Request, trustedCIDRs []*net.IPNet) string {
 //   - Requests WITHOUT Sec-Fetch-Site or Origin headers are ALLOWED through
 //   - This permits CLI tools (curl, wget), API clients, webhooks, and mobile apps
 //   - These clients cannot be exploited via CSRF since they don't automatically attach cookies
-//   - Authentication still required via session cookie (obtained through login flow)
+//   - Authentication still required via session cookie or an API token (Authorization: Bearer)
 //
 // Security Model:
 //   - CSRF attacks require a browser to automatically attach session cookies
@@ -133,10 +133,31 @@ func getClientIP(r *http.Request, trustedCIDRs []*net.IPNet) string {
 // The following endpoints are exempt from CSRF middleware for non-browser client support:
 //   - /upload (streaming multipart uploads)
 //   - /api/uploads/* (chunked upload JSON API)
+//   - /api/tus/* (tus resumable upload protocol)
 //   - /api/files/status (SSE, GET-only)
 //
 // These endpoints rely on session-based authentication and SameSite cookie policy.
 //
+// API TOKENS:
+// Personal API tokens (created under /settings) authenticate via "Authorization: Bearer".
+// Token scopes are enforced per route group: files:read for downloads, previews, streaming and
+// the status stream; files:write for uploads and file/folder/deleted-item mutations;
+// shares:manage for creating and revoking share links. HTML pages, settings and admin routes
+// remain session-only. Bearer tokens are never attached automatically by browsers, so they
+// are not a CSRF vector.
+//
+// JSON API:
+// /api/v1 is the stable JSON surface for scripts and tooling. Every route declares its token
+// scope in handlers.apiRoutes, failures (including authentication) use the JSON error body
+// written by handlers.WriteAPIError, and the OpenAPI document is served at /api/v1/openapi.json.
+// The CSRF middleware still applies so browser sessions get the same Fetch Metadata checks.
+//
+// WEBDAV:
+// /dav/ serves each user's folder tree over WebDAV when WEBDAV_ENABLED is set. It is token-only:
+// clients send an API token as a Bearer token or as the Basic auth password (the username is
+// ignored). files:read covers OPTIONS/GET/HEAD/PROPFIND, files:write everything else. No session
+// is loaded, so browser cookies are never honoured there and the CSRF middleware is not needed.
+//
 // Returns the file handler and deleted handler for graceful shutdown support.
 func Setup(r chi.Router, db *gorm.DB, cfg *config.Config, storageService storage.StorageBackend, sessionManager *scs.SessionManager, oidcProvider *oidc.Provider, version string) (*handlers.FileHandler, *handlers.DeletedHandler) {
 	authHandler := handlers.NewAuthHandler(db, cfg, sessionManager)
@@ -144,11 +165,13 @@ func Setup(r chi.Router, db *gorm.DB, cfg *config.Config, storageService storage
 	searchHandler := handlers.NewSearchHandler(db, cfg)
 	fileHandler := handlers.NewFileHandler(db, cfg, storageService)
 	uploadHandler := handlers.NewUploadHandler(db, cfg, storageService)
-	healthHandler := handlers.NewHealthHandler(db, storageService, version)
+	healthHandler := handlers.NewHealthHandler(db, cfg, storageService, version)
 	adminHandler := handlers.NewAdminHandler(db, cfg, storageService)
 	deletedHandler := handlers.NewDeletedHandler(db, cfg, storageService)
 	shareHandler := handlers.NewShareHandler(db, storageService)
 	folderShareHandler := handlers.NewFolderShareHandler(db, storageService, sessionManager)
+	apiTokenHandler := handlers.NewAPITokenHandler(db, cfg)
+	apiHandler := handlers.NewAPIHandler(db, cfg, version)
 
 	// Create rate limiter for auth endpoints
 	// Allow 5 login/register attempts per 15 minutes per IP
@@ -194,6 +217,7 @@ func Setup(r chi.Router, db *gorm.DB, cfg *config.Config, storageService storage
 		r.Get("/f/{token}", folderShareHandler.AccessFolderShareLink)
 		r.Post("/f/{token}", folderShareHandler.VerifyFolderSharePassword)
 		r.Get("/f/{token}/files/{id}", folderShareHandler.DownloadSharedFolderFile)
+		r.Get("/f/{token}/zip", folderShareHandler.DownloadSharedFolderZip)
 	})
 
 	fileServer := http.FileServer(http.Dir("web/static"))
@@ -227,6 +251,7 @@ func Setup(r chi.Router, db *gorm.DB, cfg *config.Config, storageService storage
 		r.Post("/logout", authHandler.Logout)
 	})
 
+	// Browser-only pages - session authentication only
 	r.Group(func(r chi.Router) {
 		r.Use(sessionManager.LoadAndSave)
 		r.Use(auth.RequireAuth(db, sessionManager))
@@ -235,26 +260,54 @@ func Setup(r chi.Router, db *gorm.DB, cfg *config.Config, storageService storage
 		r.Get("/search", searchHandler.Search)
 		r.Get("/files/{id}", fileHandler.ViewFile)
 		r.Get("/deleted", deletedHandler.ShowDeleted)
+		r.Get("/settings", authHandler.ShowSettings)
+		r.Post("/settings/tokens", apiTokenHandler.CreateAPIToken)
+		r.Post("/settings/tokens/{id}/revoke", apiTokenHandler.RevokeAPIToken)
+		r.Post("/settings/versioning", authHandler.UpdateVersioning)
+		r.Get("/folders/view", folderShareHandler.ShowFolderShareManagement)
+	})
+
+	// Read access - session or API token with files:read
+	r.Group(func(r chi.Router) {
+		r.Use(sessionManager.LoadAndSave)
+		r.Use(auth.RequireAuthOrToken(db, sessionManager, auth.ScopeFilesRead))
+		r.Use(csrfMiddleware)
+		r.Get("/download/zip", fileHandler.DownloadZip)
+		r.Get("/download/{id}", fileHandler.Download)
+		r.Get("/preview/{id}", fileHandler.Preview)
+		r.Get("/stream/{id}", fileHandler.Stream)
+		r.Get("/files/{id}/versions/{version}/download", fileHandler.DownloadVersion)
+	})
+
+	// Write access - session or API token with files:write
+	r.Group(func(r chi.Router) {
+		r.Use(sessionManager.LoadAndSave)
+		r.Use(auth.RequireAuthOrToken(db, sessionManager, auth.ScopeFilesWrite))
+		r.Use(csrfMiddleware)
 		r.Post("/deleted/empty", deletedHandler.EmptyDeleted)
 		r.Post("/deleted/files/{id}/restore", deletedHandler.RestoreFile)
 		r.Post("/deleted/files/{id}/delete", deletedHandler.PermanentlyDeleteFile)
 		r.Post("/deleted/folders/{id}/restore", deletedHandler.RestoreFolder)
 		r.Post("/deleted/folders/{id}/delete", deletedHandler.PermanentlyDeleteFolder)
-		r.Get("/settings", authHandler.ShowSettings)
 		r.Post("/folders/create", fileHandler.CreateFolder)
 		r.Post("/folders/rename", fileHandler.RenameFolder)
 		r.Post("/folders/move", fileHandler.MoveFolder)
-		r.Get("/download/{id}", fileHandler.Download)
-		r.Get("/preview/{id}", fileHandler.Preview)
-		r.Get("/stream/{id}", fileHandler.Stream)
 		r.Post("/delete/{id}", fileHandler.Delete)
 		r.Post("/rename/{id}", fileHandler.RenameFile)
 		r.Post("/move/{id}", fileHandler.MoveFile)
 		r.Post("/folders/delete/{name}", fileHandler.DeleteFolder)
 		r.Post("/files/{id}/dismiss", fileHandler.DismissFailedUpload)
+		r.Post("/files/{id}/versions/{version}/restore", fileHandler.RestoreVersion)
+		r.Post("/files/{id}/extract", fileHandler.ExtractArchive)
+	})
+
+	// Share management - session or API token with shares:manage
+	r.Group(func(r chi.Router) {
+		r.Use(sessionManager.LoadAndSave)
+		r.Use(auth.RequireAuthOrToken(db, sessionManager, auth.ScopeSharesManage))
+		r.Use(csrfMiddleware)
 		r.Post("/files/{id}/share", shareHandler.CreateShareLink)
 		r.Post("/share/{token}/revoke", shareHandler.RevokeShareLink)
-		r.Get("/folders/view", folderShareHandler.ShowFolderShareManagement)
 		r.Post("/folders/share", folderShareHandler.CreateFolderShareLink)
 		r.Post("/f/{token}/revoke", folderShareHandler.RevokeFolderShareLink)
 	})
@@ -262,7 +315,7 @@ func Setup(r chi.Router, db *gorm.DB, cfg *config.Config, storageService storage
 	// SSE endpoint for file upload status - no CSRF needed (GET request, read-only)
 	r.Group(func(r chi.Router) {
 		r.Use(sessionManager.LoadAndSave)
-		r.Use(auth.RequireAuth(db, sessionManager))
+		r.Use(auth.RequireAuthOrToken(db, sessionManager, auth.ScopeFilesRead))
 		r.Get("/api/files/status", fileHandler.StatusStream)
 	})
 
@@ -280,11 +333,11 @@ func Setup(r chi.Router, db *gorm.DB, cfg *config.Config, storageService storage
 	// Upload endpoint - exempt from Gorilla CSRF middleware
 	// Gorilla CSRF calls ParseMultipartForm internally which consumes the request body,
 	// breaking our streaming upload. Protection is still provided via:
-	// 1. Session-based authentication (RequireAuth middleware)
+	// 1. Session or API token authentication (RequireAuthOrToken middleware)
 	// 2. SameSite cookie policy preventing cross-origin requests
 	r.Group(func(r chi.Router) {
 		r.Use(sessionManager.LoadAndSave)
-		r.Use(auth.RequireAuth(db, sessionManager))
+		r.Use(auth.RequireAuthOrToken(db, sessionManager, auth.ScopeFilesWrite))
 		// No CSRF middleware - streaming uploads handle their own protection
 		r.Post("/upload", fileHandler.Upload)
 	})
@@ -292,15 +345,44 @@ func Setup(r chi.Router, db *gorm.DB, cfg *config.Config, storageService storage
 	// Chunked upload API endpoints - JSON API for resumable uploads
 	r.Group(func(r chi.Router) {
 		r.Use(sessionManager.LoadAndSave)
-		r.Use(auth.RequireAuth(db, sessionManager))
-		// No CSRF middleware - JSON API with session authentication
+		r.Use(auth.RequireAuthOrToken(db, sessionManager, auth.ScopeFilesWrite))
+		// No CSRF middleware - JSON API with session or API token authentication
 		r.Post("/api/uploads/init", uploadHandler.InitUpload)
 		r.Post("/api/uploads/{id}/chunk", uploadHandler.UploadChunk)
 		r.Post("/api/uploads/{id}/complete", uploadHandler.CompleteUpload)
 		r.Delete("/api/uploads/{id}", uploadHandler.CancelUpload)
 		r.Get("/api/uploads/{id}/status", uploadHandler.GetUploadStatus)
+
+		// tus 1.0 resumable uploads backed by the same upload sessions
+		r.Post(handlers.TusPrefix, uploadHandler.TusCreate)
+		r.Head(handlers.TusPrefix+"/{id}", uploadHandler.TusHead)
+		r.Patch(handlers.TusPrefix+"/{id}", uploadHandler.TusPatch)
+		r.Delete(handlers.TusPrefix+"/{id}", uploadHandler.TusDelete)
 	})
 
+	// tus capability discovery is public, as the protocol expects
+	r.Options(handlers.TusPrefix, uploadHandler.TusOptions)
+	r.Options(handlers.TusPrefix+"/{id}", uploadHandler.TusOptions)
+
+	// Versioned JSON API - session or API token, scopes enforced per route
+	r.Route("/api/v1", func(r chi.Router) {
+		r.Use(sessionManager.LoadAndSave)
+		r.Use(csrfMiddleware)
+		apiHandler.Mount(r, func(scope string) func(http.Handler) http.Handler {
+			return auth.RequireAPIAuth(db, sessionManager, handlers.WriteAPIError, scope)
+		})
+	})
+
+	// WebDAV — token auth only, no session or CSRF middleware.
+	if cfg.WebDAVEnabled {
+		davHandler := handlers.NewWebDAVHandler(db, cfg, storageService)
+		r.Group(func(r chi.Router) {
+			r.Use(auth.RequireTokenAuth(db, "Trove", handlers.WebDAVScope))
+			r.Handle(handlers.DAVPrefix, davHandler)
+			r.Handle(handlers.DAVPrefix+"/*", davHandler)
+		})
+	}
+
 	// OIDC routes — no CSRF middleware; state parameter provides equivalent protection.
 	if cfg.OIDCEnabled && oidcProvider != nil {
 		oidcHandler := handlers.NewOIDCHandler(db, cfg, sessionManager, oidcProvider)
@@ -325,7 +407,10 @@ func Setup(r chi.Router, db *gorm.DB, cfg *config.Config, storageService storage
 		r.Post("/admin/users/{id}/delete", adminHandler.DeleteUser)
 		r.Post("/admin/users/{id}/reset-password", adminHandler.ResetUserPassword)
 		r.Post("/admin/users/{id}/idp", adminHandler.UpdateUserIDP)
+		r.Post("/admin/users/{id}/storage-policy", adminHandler.UpdateUserStoragePolicy)
+		r.Post("/admin/users/{id}/folder-storage-policy", adminHandler.UpdateFolderStoragePolicy)
 		r.Post("/admin/deleted/empty-all", deletedHandler.AdminEmptyAllDeleted)
+		r.Post("/admin/scrub", adminHandler.StartScrub)
 	})
 
 	return fileHandler, deletedHandler
@@ -3,6 +3,7 @@ package routes
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -17,6 +18,7 @@ import (
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-chi/chi/v5"
+	"gorm.io/datatypes"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 
@@ -68,7 +70,7 @@ func newRouteTestApp(t *testing.T) *routeTestApp {
 		t.Fatalf("Failed to open test database: %v", err)
 	}
 
-	err = db.AutoMigrate(&models.User{}, &models.File{}, &models.Folder{})
+	err = db.AutoMigrate(&models.User{}, &models.File{}, &models.FileVersion{}, &models.Blob{}, &models.Folder{}, &models.APIToken{}, &models.UploadSession{}, &models.ScrubRun{})
 	if err != nil {
 		t.Fatalf("Failed to migrate database: %v", err)
 	}
@@ -81,6 +83,7 @@ func newRouteTestApp(t *testing.T) *routeTestApp {
 		SessionSecret:      "test-secret-key-32-bytes-long!!",
 		Env:                "test",
 		CSRFEnabled:        false, // Disable CSRF for easier testing
+		WebDAVEnabled:      true,
 	}
 
 	sessionManager := scs.New()
@@ -567,7 +570,7 @@ func TestCSRFProtection(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to open test database: %v", err)
 	}
-	if err := db.AutoMigrate(&models.User{}, &models.File{}, &models.Folder{}); err != nil {
+	if err := db.AutoMigrate(&models.User{}, &models.File{}, &models.FileVersion{}, &models.Blob{}, &models.Folder{}); err != nil {
 		t.Fatalf("Failed to migrate database: %v", err)
 	}
 
@@ -773,6 +776,7 @@ func TestAdminRoutesRequireAuth(t *testing.T) {
 		{http.MethodPost, "/admin/users/1/quota"},
 		{http.MethodPost, "/admin/users/1/delete"},
 		{http.MethodPost, "/admin/users/1/reset-password"},
+		{http.MethodPost, "/admin/scrub"},
 	}
 
 	for _, route := range adminRoutes {
@@ -833,6 +837,7 @@ func TestAdminRoutesRequireAdmin(t *testing.T) {
 		{http.MethodPost, "/admin/users/1/quota"},
 		{http.MethodPost, "/admin/users/1/delete"},
 		{http.MethodPost, "/admin/users/1/reset-password"},
+		{http.MethodPost, "/admin/scrub"},
 	}
 
 	for _, route := range adminRoutes {
@@ -1132,3 +1137,201 @@ func TestAdminUserManagementViaRoutes(t *testing.T) {
 		}
 	})
 }
+
+// createAPIToken stores a token for user with the given scopes and returns the plaintext
+func (app *routeTestApp) createAPIToken(t *testing.T, user *models.User, expiresAt *time.Time, scopes ...string) string {
+	t.Helper()
+
+	plaintext, prefix, hash, err := auth.GenerateAPIToken()
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	token := &models.APIToken{
+		UserID:      user.ID,
+		Name:        "test",
+		TokenHash:   hash,
+		TokenPrefix: prefix,
+		Scopes:      datatypes.NewJSONType(scopes),
+		ExpiresAt:   expiresAt,
+	}
+	if err := app.db.Create(token).Error; err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+	return plaintext
+}
+
+// TestAPITokenScopes verifies bearer tokens are accepted per route group according to their scopes
+func TestAPITokenScopes(t *testing.T) {
+	app := newRouteTestApp(t)
+	user := app.createTestUser(t, "tokenuser", "password123")
+
+	readToken := app.createAPIToken(t, user, nil, auth.ScopeFilesRead)
+	writeToken := app.createAPIToken(t, user, nil, auth.ScopeFilesWrite)
+	past := time.Now().Add(-time.Hour)
+	expiredToken := app.createAPIToken(t, user, &past, auth.ScopeFilesRead, auth.ScopeFilesWrite)
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		token          string
+		expectedStatus int
+	}{
+		{"read scope allows download route", http.MethodGet, "/download/999", readToken, http.StatusNotFound},
+		{"read scope cannot write", http.MethodPost, "/delete/999", readToken, http.StatusForbidden},
+		{"write scope allows delete route", http.MethodPost, "/delete/999", writeToken, http.StatusNotFound},
+		{"write scope cannot manage shares", http.MethodPost, "/files/999/share", writeToken, http.StatusForbidden},
+		{"expired token rejected", http.MethodGet, "/download/999", expiredToken, http.StatusUnauthorized},
+		{"unknown token rejected", http.MethodGet, "/download/999", "trove_bogus", http.StatusUnauthorized},
+		{"tokens cannot open settings", http.MethodGet, "/settings", readToken, http.StatusSeeOther},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := app.newRequest(tt.method, tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			w := httptest.NewRecorder()
+			app.router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+
+	var token models.APIToken
+	if err := app.db.Where("token_hash = ?", auth.HashAPIToken(readToken)).First(&token).Error; err != nil {
+		t.Fatalf("Failed to load token: %v", err)
+	}
+	if token.LastUsedAt == nil {
+		t.Error("Expected last_used_at to be recorded")
+	}
+}
+
+func TestAPIv1Auth(t *testing.T) {
+	app := newRouteTestApp(t)
+	user := app.createTestUser(t, "apiuser", "password123")
+	readToken := app.createAPIToken(t, user, nil, auth.ScopeFilesRead)
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		token          string
+		expectedStatus int
+		expectedCode   string
+	}{
+		{"no credentials", http.MethodGet, "/api/v1/quota", "", http.StatusUnauthorized, "unauthorized"},
+		{"invalid token", http.MethodGet, "/api/v1/quota", "trove_bogus", http.StatusUnauthorized, "invalid_token"},
+		{"missing scope", http.MethodDelete, "/api/v1/files/1", readToken, http.StatusForbidden, "insufficient_scope"},
+		{"unknown endpoint", http.MethodGet, "/api/v1/nope", readToken, http.StatusNotFound, "not_found"},
+		{"read scope lists files", http.MethodGet, "/api/v1/files", readToken, http.StatusOK, ""},
+		{"openapi is public", http.MethodGet, "/api/v1/openapi.json", "", http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := app.newRequest(tt.method, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			app.router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+				t.Errorf("Expected JSON response, got %q", ct)
+			}
+			if tt.expectedCode != "" {
+				var body handlers.APIError
+				if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+					t.Fatalf("Failed to decode error body: %v", err)
+				}
+				if body.Error.Code != tt.expectedCode {
+					t.Errorf("Expected error code %q, got %q", tt.expectedCode, body.Error.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestWebDAVAuth(t *testing.T) {
+	app := newRouteTestApp(t)
+	user := app.createTestUser(t, "davuser", "password123")
+	readToken := app.createAPIToken(t, user, nil, auth.ScopeFilesRead)
+	writeToken := app.createAPIToken(t, user, nil, auth.ScopeFilesWrite)
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		basicPassword  string
+		bearer         string
+		expectedStatus int
+	}{
+		{"no credentials", "PROPFIND", "/dav/", "", "", http.StatusUnauthorized},
+		{"account password is not accepted", "PROPFIND", "/dav/", "password123", "", http.StatusUnauthorized},
+		{"basic auth with token", "PROPFIND", "/dav/", readToken, "", http.StatusMultiStatus},
+		{"bearer token", "PROPFIND", "/dav", "", readToken, http.StatusMultiStatus},
+		{"read scope cannot write", "MKCOL", "/dav/docs", readToken, "", http.StatusForbidden},
+		{"write scope can write", "MKCOL", "/dav/docs", writeToken, "", http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := app.newRequest(tt.method, tt.path, nil)
+			req.Header.Set("Depth", "1")
+			if tt.basicPassword != "" {
+				req.SetBasicAuth("anything", tt.basicPassword)
+			}
+			if tt.bearer != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			w := httptest.NewRecorder()
+			app.router.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if w.Code == http.StatusUnauthorized && !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Basic ") {
+				t.Errorf("Expected a Basic challenge, got %q", w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestTusRoutes(t *testing.T) {
+	app := newRouteTestApp(t)
+	user := app.createTestUser(t, "tususer", "password123")
+	writeToken := app.createAPIToken(t, user, nil, auth.ScopeFilesWrite)
+
+	// Capability discovery needs no credentials
+	req := app.newRequest(http.MethodOptions, "/api/tus", nil)
+	w := httptest.NewRecorder()
+	app.router.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent || w.Header().Get("Tus-Version") != "1.0.0" {
+		t.Fatalf("Expected tus OPTIONS response, got %d %v", w.Code, w.Header())
+	}
+
+	req = app.newRequest(http.MethodPost, "/api/tus", nil)
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	req.Header.Set("Upload-Length", "5")
+	req.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte("a.txt")))
+	w = httptest.NewRecorder()
+	app.router.ServeHTTP(w, req)
+	if w.Code != http.StatusSeeOther {
+		t.Errorf("Expected redirect to login without credentials, got %d", w.Code)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+writeToken)
+	w = httptest.NewRecorder()
+	app.router.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.HasPrefix(w.Header().Get("Location"), "/api/tus/") {
+		t.Errorf("Unexpected Location %q", w.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,390 @@
+// Package scrub verifies that stored objects still match the SHA-256 hash
+// recorded when they were uploaded. Passes over all objects ("runs") are
+// queued in the scrub_runs table, either on a schedule or by an admin, and
+// processed one at a time by a Worker. Each file's outcome is recorded in
+// its integrity_status and verified_at columns.
+package scrub
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/agjmills/trove/internal/database/models"
+	"github.com/agjmills/trove/internal/logger"
+	"github.com/agjmills/trove/internal/metrics"
+	"github.com/agjmills/trove/internal/storage"
+)
+
+// Run statuses.
+const (
+	RunPending   = "pending"
+	RunRunning   = "running"
+	RunCompleted = "completed"
+	RunFailed    = "failed"
+)
+
+// Run triggers.
+const (
+	TriggerSchedule = "schedule"
+	TriggerAdmin    = "admin"
+)
+
+// File integrity statuses.
+const (
+	StatusUnverified = "unverified"
+	StatusOK         = "ok"
+	StatusMissing    = "missing"
+	StatusCorrupted  = "corrupted"
+)
+
+// batchSize is how many objects are loaded from the database at a time.
+const batchSize = 100
+
+// ErrRunActive is returned by Enqueue when a run is already pending or running.
+var ErrRunActive = errors.New("scrub: a run is already queued or in progress")
+
+// Enqueue queues a run unless one is already pending or running.
+func Enqueue(db *gorm.DB, trigger string) (*models.ScrubRun, error) {
+	var run *models.ScrubRun
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var active int64
+		if err := tx.Model(&models.ScrubRun{}).Where("status IN ?", []string{RunPending, RunRunning}).
+			Count(&active).Error; err != nil {
+			return err
+		}
+		if active > 0 {
+			return ErrRunActive
+		}
+		run = &models.ScrubRun{Status: RunPending, Trigger: trigger}
+		return tx.Create(run).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// Current returns the pending or running run, or nil if there is none.
+func Current(db *gorm.DB) (*models.ScrubRun, error) {
+	var run models.ScrubRun
+	err := db.Where("status IN ?", []string{RunPending, RunRunning}).Order("id").First(&run).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// LastFinished returns the most recently finished run, or nil if there is none.
+func LastFinished(db *gorm.DB) (*models.ScrubRun, error) {
+	var run models.ScrubRun
+	err := db.Where("status IN ?", []string{RunCompleted, RunFailed}).Order("id DESC").First(&run).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// StatusCounts returns the number of completed uploads in each integrity status.
+func StatusCounts(db *gorm.DB) (map[string]int64, error) {
+	var rows []struct {
+		IntegrityStatus string
+		Count           int64
+	}
+	if err := db.Model(&models.File{}).
+		Select("integrity_status, COUNT(*) AS count").
+		Where("upload_status = ?", "completed").
+		Group("integrity_status").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	counts := map[string]int64{StatusUnverified: 0, StatusOK: 0, StatusMissing: 0, StatusCorrupted: 0}
+	for _, r := range rows {
+		status := r.IntegrityStatus
+		if status == "" {
+			status = StatusUnverified
+		}
+		counts[status] += r.Count
+	}
+	return counts, nil
+}
+
+// Worker processes queued runs and queues scheduled ones.
+type Worker struct {
+	db           *gorm.DB
+	storage      storage.StorageBackend
+	interval     time.Duration // Time between scheduled runs (0 = on demand only)
+	rateLimit    int64         // Bytes per second
+	pollInterval time.Duration
+	staleAge     time.Duration // A running run not updated for this long is resumed
+}
+
+// NewWorker creates a scrub worker. interval is the time between scheduled
+// runs (0 disables the schedule) and rateLimit caps how many bytes per
+// second are read from storage.
+func NewWorker(db *gorm.DB, storage storage.StorageBackend, interval time.Duration, rateLimit int64) *Worker {
+	return &Worker{
+		db:           db,
+		storage:      storage,
+		interval:     interval,
+		rateLimit:    rateLimit,
+		pollInterval: time.Minute,
+		staleAge:     time.Hour,
+	}
+}
+
+// Run processes runs until the context is cancelled. With once=true it
+// returns as soon as there is nothing to do.
+func (w *Worker) Run(ctx context.Context, once bool) {
+	w.updateStatusMetrics()
+	for {
+		if err := w.schedule(); err != nil {
+			logger.Error("failed to schedule scrub run", "error", err)
+		}
+
+		run, err := w.claim()
+		if err != nil {
+			logger.Error("failed to claim scrub run", "error", err)
+		} else if run != nil {
+			w.process(ctx, run)
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+
+		if once {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(w.pollInterval):
+		}
+	}
+}
+
+// schedule queues a run when the last one started more than the interval ago.
+func (w *Worker) schedule() error {
+	if w.interval <= 0 {
+		return nil
+	}
+	var last models.ScrubRun
+	err := w.db.Order("id DESC").First(&last).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if err == nil && time.Since(last.CreatedAt) < w.interval {
+		return nil
+	}
+	_, err = Enqueue(w.db, TriggerSchedule)
+	if errors.Is(err, ErrRunActive) {
+		return nil
+	}
+	return err
+}
+
+// claim marks the oldest pending run as running, or picks up a run left
+// running by a process that stopped part way through.
+func (w *Worker) claim() (*models.ScrubRun, error) {
+	now := time.Now()
+	var run models.ScrubRun
+	err := w.db.Where("status = ? OR (status = ? AND updated_at < ?)", RunPending, RunRunning, now.Add(-w.staleAge)).
+		Order("id").First(&run).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	updates := map[string]any{"status": RunRunning, "updated_at": now}
+	if run.StartedAt == nil {
+		updates["started_at"] = now
+		run.StartedAt = &now
+	}
+	res := w.db.Model(&models.ScrubRun{}).
+		Where("id = ? AND (status = ? OR (status = ? AND updated_at < ?))", run.ID, RunPending, RunRunning, now.Add(-w.staleAge)).
+		Updates(updates)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, nil // Claimed by another worker
+	}
+	run.Status = RunRunning
+	return &run, nil
+}
+
+// object is a stored object and the hash its files recorded.
+type object struct {
+	StoragePath string
+	Hash        string
+}
+
+// process checks every object not verified since the run started. Objects
+// are visited in path order, so a resumed run carries on where it stopped.
+func (w *Worker) process(ctx context.Context, run *models.ScrubRun) {
+	logger.Info("scrub run starting", "run_id", run.ID, "trigger", run.Trigger)
+	throttle := newThrottle(w.rateLimit)
+	cursor := ""
+
+	for {
+		var batch []object
+		if err := w.db.Unscoped().Model(&models.File{}).
+			Distinct("storage_path", "hash").
+			Where("upload_status = ? AND hash <> '' AND storage_path > ?", "completed", cursor).
+			Where("verified_at IS NULL OR verified_at < ?", *run.StartedAt).
+			Order("storage_path").
+			Limit(batchSize).
+			Find(&batch).Error; err != nil {
+			w.finish(run, err)
+			return
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		for _, obj := range batch {
+			if ctx.Err() != nil {
+				// Requeue; the next worker resumes it from its start time
+				logger.Info("scrub run interrupted", "run_id", run.ID, "checked", run.Checked)
+				if err := w.db.Model(run).Update("status", RunPending).Error; err != nil {
+					logger.Warn("failed to requeue scrub run", "run_id", run.ID, "error", err)
+				}
+				return
+			}
+			w.check(ctx, run, throttle, obj)
+			cursor = obj.StoragePath
+		}
+	}
+
+	w.finish(run, nil)
+}
+
+// check verifies one object and records the result on its files and the run.
+func (w *Worker) check(ctx context.Context, run *models.ScrubRun, throttle *throttle, obj object) {
+	status, n, err := w.verify(ctx, throttle, obj)
+	run.BytesRead += n
+	metrics.ScrubBytesRead.Add(float64(n))
+
+	if status == "" {
+		if ctx.Err() != nil {
+			return
+		}
+		run.Errors++
+		metrics.ScrubObjectsChecked.WithLabelValues("error").Inc()
+		logger.Warn("scrub could not read object", "path", obj.StoragePath, "error", err)
+	} else {
+		run.Checked++
+		switch status {
+		case StatusOK:
+			run.OK++
+		case StatusMissing:
+			run.Missing++
+			logger.Error("scrub found missing object", "path", obj.StoragePath)
+		case StatusCorrupted:
+			run.Corrupted++
+			logger.Error("scrub found corrupted object", "path", obj.StoragePath, "error", err)
+		}
+		metrics.ScrubObjectsChecked.WithLabelValues(status).Inc()
+
+		if err := w.db.Unscoped().Model(&models.File{}).
+			Where("storage_path = ? AND hash = ?", obj.StoragePath, obj.Hash).
+			UpdateColumns(map[string]any{"integrity_status": status, "verified_at": time.Now()}).Error; err != nil {
+			logger.Error("failed to record scrub result", "path", obj.StoragePath, "error", err)
+		}
+	}
+
+	if err := w.db.Model(run).Updates(map[string]any{
+		"checked":    run.Checked,
+		"ok":         run.OK,
+		"missing":    run.Missing,
+		"corrupted":  run.Corrupted,
+		"errors":     run.Errors,
+		"bytes_read": run.BytesRead,
+	}).Error; err != nil {
+		logger.Warn("failed to save scrub progress", "run_id", run.ID, "error", err)
+	}
+}
+
+// verify reads an object and compares its hash. It returns an empty status
+// when the object could not be checked, e.g. because storage is unreachable.
+func (w *Worker) verify(ctx context.Context, throttle *throttle, obj object) (string, int64, error) {
+	r, err := w.storage.Open(ctx, obj.StoragePath)
+	if errors.Is(err, storage.ErrNotFound) {
+		return StatusMissing, 0, err
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	defer r.Close() //nolint:errcheck
+
+	hasher := sha256.New()
+	n, err := io.Copy(hasher, throttle.reader(ctx, r))
+	if errors.Is(err, storage.ErrDecrypt) {
+		return StatusCorrupted, n, err
+	}
+	if err != nil {
+		return "", n, err
+	}
+	if got := hex.EncodeToString(hasher.Sum(nil)); got != obj.Hash {
+		return StatusCorrupted, n, fmt.Errorf("hash mismatch: got %s, want %s", got, obj.Hash)
+	}
+	return StatusOK, n, nil
+}
+
+// finish records the end of a run and refreshes the metrics.
+func (w *Worker) finish(run *models.ScrubRun, runErr error) {
+	now := time.Now()
+	updates := map[string]any{"status": RunCompleted, "finished_at": now}
+	if runErr != nil {
+		updates["status"] = RunFailed
+		updates["error"] = truncate(runErr.Error(), 500)
+		logger.Error("scrub run failed", "run_id", run.ID, "error", runErr)
+	}
+	if err := w.db.Model(run).Updates(updates).Error; err != nil {
+		logger.Error("failed to finish scrub run", "run_id", run.ID, "error", err)
+	}
+
+	logger.Info("scrub run finished",
+		"run_id", run.ID,
+		"checked", run.Checked,
+		"missing", run.Missing,
+		"corrupted", run.Corrupted,
+		"errors", run.Errors,
+		"bytes_read", run.BytesRead,
+	)
+	metrics.ScrubLastRunTimestamp.Set(float64(now.Unix()))
+	w.updateStatusMetrics()
+}
+
+func (w *Worker) updateStatusMetrics() {
+	counts, err := StatusCounts(w.db)
+	if err != nil {
+		logger.Warn("failed to count file integrity statuses", "error", err)
+		return
+	}
+	for status, n := range counts {
+		metrics.FilesByIntegrityStatus.WithLabelValues(status).Set(float64(n))
+	}
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
@@ -0,0 +1,284 @@
+package scrub
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/agjmills/trove/internal/database/models"
+	"github.com/agjmills/trove/internal/storage"
+)
+
+func newScrubTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := fmt.Sprintf("file:scrub-%d?mode=memory&cache=shared", time.Now().UnixNano())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to open test db: %v", err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.File{}, &models.FileVersion{}, &models.Blob{}, &models.ScrubRun{}); err != nil {
+		t.Fatalf("failed to migrate test db: %v", err)
+	}
+	return db
+}
+
+func storeTestFile(t *testing.T, db *gorm.DB, backend storage.StorageBackend, name, content string) *models.File {
+	t.Helper()
+	result, err := backend.Save(context.Background(), strings.NewReader(content), storage.SaveOptions{OriginalFilename: name})
+	if err != nil {
+		t.Fatalf("failed to save %s: %v", name, err)
+	}
+	file := &models.File{
+		UserID:           1,
+		StoragePath:      result.Path,
+		LogicalPath:      "/",
+		Filename:         name,
+		OriginalFilename: name,
+		FileSize:         result.Size,
+		Hash:             result.Hash,
+		UploadStatus:     "completed",
+	}
+	if err := db.Create(file).Error; err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	return file
+}
+
+func integrityStatus(t *testing.T, db *gorm.DB, id uint) string {
+	t.Helper()
+	var file models.File
+	if err := db.Unscoped().First(&file, id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return file.IntegrityStatus
+}
+
+// failingBackend fails to open the listed paths with a transient error.
+type failingBackend struct {
+	*storage.MemoryBackend
+	fail map[string]bool
+}
+
+func (b *failingBackend) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	if b.fail[path] {
+		return nil, errors.New("connection reset")
+	}
+	return b.MemoryBackend.Open(ctx, path)
+}
+
+func TestScrubRecordsStatuses(t *testing.T) {
+	db := newScrubTestDB(t)
+	backend := storage.NewMemoryBackend()
+
+	good := storeTestFile(t, db, backend, "good.txt", "all fine")
+	missing := storeTestFile(t, db, backend, "missing.txt", "gone")
+	corrupted := storeTestFile(t, db, backend, "corrupted.txt", "original")
+	trashed := storeTestFile(t, db, backend, "trashed.txt", "in the trash")
+	db.Model(trashed).Update("trashed_at", time.Now())
+	if err := backend.Delete(context.Background(), missing.StoragePath); err != nil {
+		t.Fatal(err)
+	}
+	// The object no longer matches the hash recorded at upload
+	sum := sha256.Sum256([]byte("something else"))
+	db.Model(corrupted).Update("hash", hex.EncodeToString(sum[:]))
+
+	if _, err := Enqueue(db, TriggerAdmin); err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+	NewWorker(db, backend, 0, 1<<30).Run(context.Background(), true)
+
+	want := map[uint]string{
+		good.ID:      StatusOK,
+		missing.ID:   StatusMissing,
+		corrupted.ID: StatusCorrupted,
+		trashed.ID:   StatusOK,
+	}
+	for id, status := range want {
+		if got := integrityStatus(t, db, id); got != status {
+			t.Errorf("file %d: status = %q, want %q", id, got, status)
+		}
+	}
+
+	run, err := LastFinished(db)
+	if err != nil || run == nil {
+		t.Fatalf("LastFinished = %v, %v", run, err)
+	}
+	if run.Status != RunCompleted || run.Checked != 4 || run.OK != 2 || run.Missing != 1 || run.Corrupted != 1 || run.Errors != 0 {
+		t.Errorf("unexpected run: %+v", run)
+	}
+	if run.BytesRead != int64(len("all fine")+len("original")+len("in the trash")) {
+		t.Errorf("BytesRead = %d", run.BytesRead)
+	}
+
+	counts, err := StatusCounts(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts[StatusOK] != 2 || counts[StatusMissing] != 1 || counts[StatusCorrupted] != 1 || counts[StatusUnverified] != 0 {
+		t.Errorf("unexpected counts: %v", counts)
+	}
+}
+
+func TestScrubLeavesStatusOnReadError(t *testing.T) {
+	db := newScrubTestDB(t)
+	backend := &failingBackend{MemoryBackend: storage.NewMemoryBackend(), fail: map[string]bool{}}
+
+	file := storeTestFile(t, db, backend, "flaky.txt", "content")
+	backend.fail[file.StoragePath] = true
+
+	if _, err := Enqueue(db, TriggerAdmin); err != nil {
+		t.Fatal(err)
+	}
+	NewWorker(db, backend, 0, 1<<30).Run(context.Background(), true)
+
+	if got := integrityStatus(t, db, file.ID); got != StatusUnverified {
+		t.Errorf("status = %q, want it left %q", got, StatusUnverified)
+	}
+	run, _ := LastFinished(db)
+	if run == nil || run.Errors != 1 || run.Checked != 0 {
+		t.Errorf("unexpected run: %+v", run)
+	}
+}
+
+func TestScrubResumesInterruptedRun(t *testing.T) {
+	db := newScrubTestDB(t)
+	backend := storage.NewMemoryBackend()
+	var files []*models.File
+	for i := range 3 {
+		files = append(files, storeTestFile(t, db, backend, fmt.Sprintf("f%d.txt", i), fmt.Sprintf("content %d", i)))
+	}
+
+	run, err := Enqueue(db, TriggerAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a run that checked one object before the process stopped
+	w := NewWorker(db, backend, 0, 1<<30)
+	claimed, err := w.claim()
+	if err != nil || claimed == nil || claimed.ID != run.ID {
+		t.Fatalf("claim = %v, %v", claimed, err)
+	}
+	var first models.File
+	db.Order("storage_path").First(&first)
+	w.check(context.Background(), claimed, newThrottle(1<<30), object{StoragePath: first.StoragePath, Hash: first.Hash})
+	db.Model(claimed).Update("status", RunPending)
+	verifiedAt := func(id uint) time.Time {
+		var f models.File
+		db.First(&f, id)
+		if f.VerifiedAt == nil {
+			return time.Time{}
+		}
+		return *f.VerifiedAt
+	}
+	firstVerified := verifiedAt(first.ID)
+
+	w.Run(context.Background(), true)
+
+	if verifiedAt(first.ID) != firstVerified {
+		t.Error("object checked before the interruption was checked again")
+	}
+	for _, f := range files {
+		if got := integrityStatus(t, db, f.ID); got != StatusOK {
+			t.Errorf("%s: status = %q", f.Filename, got)
+		}
+	}
+	finished, _ := LastFinished(db)
+	if finished == nil || finished.ID != run.ID || finished.Checked != 3 {
+		t.Errorf("expected the original run to finish with 3 checked, got %+v", finished)
+	}
+}
+
+func TestScrubInterruptRequeuesRun(t *testing.T) {
+	db := newScrubTestDB(t)
+	backend := storage.NewMemoryBackend()
+	storeTestFile(t, db, backend, "a.txt", "a")
+
+	run, _ := Enqueue(db, TriggerAdmin)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	NewWorker(db, backend, 0, 1<<30).Run(ctx, true)
+
+	var reloaded models.ScrubRun
+	db.First(&reloaded, run.ID)
+	if reloaded.Status != RunPending || reloaded.StartedAt == nil {
+		t.Errorf("expected the run to be requeued with its start time, got %+v", reloaded)
+	}
+}
+
+func TestEnqueueRejectsActiveRun(t *testing.T) {
+	db := newScrubTestDB(t)
+	if _, err := Enqueue(db, TriggerAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Enqueue(db, TriggerAdmin); !errors.Is(err, ErrRunActive) {
+		t.Errorf("second Enqueue error = %v, want ErrRunActive", err)
+	}
+}
+
+func TestScheduleQueuesAfterInterval(t *testing.T) {
+	db := newScrubTestDB(t)
+	backend := storage.NewMemoryBackend()
+	w := NewWorker(db, backend, time.Hour, 1<<30)
+
+	if err := w.schedule(); err != nil {
+		t.Fatal(err)
+	}
+	current, _ := Current(db)
+	if current == nil || current.Trigger != TriggerSchedule {
+		t.Fatalf("expected a scheduled run, got %+v", current)
+	}
+
+	// Nothing more is queued until the interval has passed
+	w.Run(context.Background(), true)
+	if err := w.schedule(); err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := Current(db); current != nil {
+		t.Fatalf("unexpected run queued: %+v", current)
+	}
+
+	db.Model(&models.ScrubRun{}).Where("1 = 1").Update("created_at", time.Now().Add(-2*time.Hour))
+	if err := w.schedule(); err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := Current(db); current == nil {
+		t.Error("expected a run to be queued once the interval passed")
+	}
+
+	// An interval of zero never schedules
+	db2 := newScrubTestDB(t)
+	if err := NewWorker(db2, backend, 0, 1<<30).schedule(); err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := Current(db2); current != nil {
+		t.Error("expected no scheduled run with a zero interval")
+	}
+}
+
+func TestThrottleLimitsRate(t *testing.T) {
+	const rate = 10 * 1024
+	data := bytes.Repeat([]byte("x"), rate/2)
+
+	start := time.Now()
+	n, err := io.Copy(io.Discard, newThrottle(rate).reader(context.Background(), bytes.NewReader(data)))
+	if err != nil || n != int64(len(data)) {
+		t.Fatalf("Copy = %d, %v", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("read %d bytes at %d B/s in %v", len(data), rate, elapsed)
+	}
+}
@@ -0,0 +1,62 @@
+package scrub
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// throttle limits the rate at which the scrubber reads from storage, so a
+// run does not compete with user downloads for disk or network bandwidth.
+type throttle struct {
+	rate  int64 // Bytes per second
+	start time.Time
+	read  int64
+}
+
+func newThrottle(rate int64) *throttle {
+	return &throttle{rate: rate, start: time.Now()}
+}
+
+// reader wraps r so reads through it count against the rate limit.
+func (t *throttle) reader(ctx context.Context, r io.Reader) io.Reader {
+	return &throttledReader{ctx: ctx, r: r, t: t}
+}
+
+// wait sleeps until n more bytes fit within the rate limit.
+func (t *throttle) wait(ctx context.Context, n int) error {
+	t.read += int64(n)
+	due := t.start.Add(time.Duration(float64(t.read) / float64(t.rate) * float64(time.Second)))
+	delay := time.Until(due)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+type throttledReader struct {
+	ctx context.Context
+	r   io.Reader
+	t   *throttle
+}
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	// Keep reads small enough that the pauses between them stay short
+	if max := int(tr.t.rate / 10); max > 0 && len(p) > max {
+		p = p[:max]
+	}
+	n, err := tr.r.Read(p)
+	if n > 0 {
+		if werr := tr.t.wait(tr.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
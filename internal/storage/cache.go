@@ -0,0 +1,544 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/agjmills/trove/internal/metrics"
+)
+
+// cacheBlockSize is the unit objects are cached in. A range request is
+// served from the blocks covering it, so seeking in a video costs at most a
+// block-sized fetch from the inner backend per block not yet cached.
+const cacheBlockSize = 4 * 1024 * 1024
+
+// CachedBackend keeps recently read blocks of objects from a remote backend
+// (S3) on local disk, evicting the least recently used blocks beyond a size
+// limit. OpenRange fetches missing blocks with ranged reads; Open streams
+// from the inner backend and caches the blocks on the way, unless the whole
+// object is already cached. Writes go straight to the inner backend.
+//
+// Objects are never modified in place, only replaced by Put or deleted, and
+// both invalidate the cached blocks. The cache directory must not be shared
+// with another process.
+type CachedBackend struct {
+	inner     StorageBackend
+	dir       string
+	maxBytes  int64
+	blockSize int64
+
+	mu      sync.Mutex
+	lru     *list.List                         // *cacheBlock, most recently used first
+	objects map[string]map[int64]*list.Element // Blocks by object key and index
+	sizes   map[string]int64                   // Object sizes by key, where known
+	used    int64
+}
+
+type cacheBlock struct {
+	key   string // Object key, see cacheKey
+	index int64
+	size  int64
+}
+
+// NewCachedBackend returns a backend caching reads from inner in dir, using
+// at most maxBytes. Blocks cached by an earlier run are kept.
+func NewCachedBackend(inner StorageBackend, dir string, maxBytes int64) (*CachedBackend, error) {
+	return newCachedBackend(inner, dir, maxBytes, cacheBlockSize)
+}
+
+func newCachedBackend(inner StorageBackend, dir string, maxBytes, blockSize int64) (*CachedBackend, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	c := &CachedBackend{
+		inner:     inner,
+		dir:       dir,
+		maxBytes:  maxBytes,
+		blockSize: blockSize,
+		lru:       list.New(),
+		objects:   map[string]map[int64]*list.Element{},
+		sizes:     map[string]int64{},
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// load indexes the blocks already in the cache directory, most recently
+// modified first, and removes leftovers of interrupted writes.
+func (c *CachedBackend) load() error {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read cache directory: %w", err)
+	}
+	type found struct {
+		block   cacheBlock
+		modTime time.Time
+	}
+	var blocks []found
+	for _, entry := range entries {
+		name := entry.Name()
+		key, index, ok := parseBlockName(name)
+		info, err := entry.Info()
+		if !ok || err != nil || entry.IsDir() {
+			_ = os.Remove(filepath.Join(c.dir, name))
+			continue
+		}
+		blocks = append(blocks, found{cacheBlock{key: key, index: index, size: info.Size()}, info.ModTime()})
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].modTime.After(blocks[j].modTime) })
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, b := range blocks {
+		c.insert(b.block, false)
+		if b.block.size < c.blockSize {
+			c.sizes[b.block.key] = b.block.index*c.blockSize + b.block.size
+		}
+	}
+	c.evict()
+	metrics.StorageCacheBytes.Set(float64(c.used))
+	return nil
+}
+
+// Save stores content in the inner backend.
+func (c *CachedBackend) Save(ctx context.Context, r io.Reader, opts SaveOptions) (SaveResult, error) {
+	return c.inner.Save(ctx, r, opts)
+}
+
+// Put replaces the object at path in the inner backend, which must
+// implement Putter, and drops its cached blocks.
+func (c *CachedBackend) Put(ctx context.Context, path string, r io.Reader, opts SaveOptions) (SaveResult, error) {
+	putter, ok := c.inner.(Putter)
+	if !ok {
+		return SaveResult{}, errors.New("storage backend cannot store objects at a given path")
+	}
+	c.invalidate(path)
+	result, err := putter.Put(ctx, path, r, opts)
+	c.invalidate(path)
+	return result, err
+}
+
+// Open serves the object from the cache if every block of it is cached, and
+// otherwise streams it from the inner backend, caching it as it is read.
+func (c *CachedBackend) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	key := cacheKey(path)
+	if size, ok := c.cachedSize(key); ok {
+		metrics.StorageCacheRequests.WithLabelValues("hit").Inc()
+		return &cacheReader{c: c, ctx: ctx, path: path, key: key, remaining: size}, nil
+	}
+	metrics.StorageCacheRequests.WithLabelValues("miss").Inc()
+	body, err := c.inner.Open(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return &cacheFiller{c: c, key: key, body: body, block: make([]byte, 0, c.blockSize)}, nil
+}
+
+// OpenRange serves the range from the blocks covering it, fetching the
+// missing ones from the inner backend.
+func (c *CachedBackend) OpenRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	if length <= 0 {
+		return nil, fmt.Errorf("length must be > 0")
+	}
+	first := offset / c.blockSize
+	r := &cacheReader{
+		c:         c,
+		ctx:       ctx,
+		path:      path,
+		key:       cacheKey(path),
+		index:     first,
+		skip:      offset - first*c.blockSize,
+		remaining: length,
+	}
+	// Read the first block now so a missing object fails here, like the
+	// other backends
+	if err := r.next(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Delete removes the object from the inner backend and the cache.
+func (c *CachedBackend) Delete(ctx context.Context, path string) error {
+	c.invalidate(path)
+	return c.inner.Delete(ctx, path)
+}
+
+// Stat returns the inner backend's metadata for the object.
+func (c *CachedBackend) Stat(ctx context.Context, path string) (FileInfo, error) {
+	return c.inner.Stat(ctx, path)
+}
+
+// List lists the inner backend, if it supports listing.
+func (c *CachedBackend) List(ctx context.Context, fn func(FileInfo) error) error {
+	lister, ok := c.inner.(Lister)
+	if !ok {
+		return ErrListUnsupported
+	}
+	return lister.List(ctx, fn)
+}
+
+// InitMultipart starts a multipart upload in the inner backend.
+func (c *CachedBackend) InitMultipart(ctx context.Context, partSize int64, opts SaveOptions) (string, error) {
+	uploader, err := asMultipart(c.inner)
+	if err != nil {
+		return "", err
+	}
+	return uploader.InitMultipart(ctx, partSize, opts)
+}
+
+// PutPart stores a part in the inner backend.
+func (c *CachedBackend) PutPart(ctx context.Context, uploadID string, number int, r io.Reader, size int64) error {
+	uploader, err := asMultipart(c.inner)
+	if err != nil {
+		return err
+	}
+	return uploader.PutPart(ctx, uploadID, number, r, size)
+}
+
+// CompleteMultipart completes a multipart upload in the inner backend.
+func (c *CachedBackend) CompleteMultipart(ctx context.Context, uploadID string, parts int) (SaveResult, error) {
+	uploader, err := asMultipart(c.inner)
+	if err != nil {
+		return SaveResult{}, err
+	}
+	return uploader.CompleteMultipart(ctx, uploadID, parts)
+}
+
+// AbortMultipart aborts a multipart upload in the inner backend.
+func (c *CachedBackend) AbortMultipart(ctx context.Context, uploadID string) error {
+	uploader, err := asMultipart(c.inner)
+	if err != nil {
+		return err
+	}
+	return uploader.AbortMultipart(ctx, uploadID)
+}
+
+// PresignGet presigns a download from the inner backend, bypassing the
+// cache.
+func (c *CachedBackend) PresignGet(ctx context.Context, path string, opts PresignOptions) (string, error) {
+	presigner, err := asPresigner(c.inner)
+	if err != nil {
+		return "", err
+	}
+	return presigner.PresignGet(ctx, path, opts)
+}
+
+// MigrateLayout migrates the inner backend.
+func (c *CachedBackend) MigrateLayout(ctx context.Context) (int, error) {
+	return MigrateLayout(ctx, c.inner)
+}
+
+// AbortStaleUploads sweeps the inner backend.
+func (c *CachedBackend) AbortStaleUploads(ctx context.Context, cutoff time.Time) (int, error) {
+	return abortStaleUploads(ctx, c.inner, cutoff)
+}
+
+// HealthCheck checks the inner backend.
+func (c *CachedBackend) HealthCheck(ctx context.Context) error {
+	return c.inner.HealthCheck(ctx)
+}
+
+// ValidateAccess validates the inner backend and that the cache directory
+// is writable.
+func (c *CachedBackend) ValidateAccess(ctx context.Context) error {
+	if err := c.inner.ValidateAccess(ctx); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(c.dir, "access-test-*.tmp")
+	if err != nil {
+		return fmt.Errorf("cache directory write test failed: %w", err)
+	}
+	_ = f.Close()
+	return os.Remove(f.Name())
+}
+
+// readBlock returns block index of the object, from the cache or else the
+// inner backend. A block shorter than the block size is the last one.
+func (c *CachedBackend) readBlock(ctx context.Context, path, key string, index int64) ([]byte, error) {
+	if data, ok := c.readCached(key, index); ok {
+		metrics.StorageCacheRequests.WithLabelValues("hit").Inc()
+		return data, nil
+	}
+	metrics.StorageCacheRequests.WithLabelValues("miss").Inc()
+
+	body, err := c.inner.OpenRange(ctx, path, index*c.blockSize, c.blockSize)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(body, c.blockSize))
+	_ = body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	c.store(key, index, data)
+	return data, nil
+}
+
+// readCached returns a cached block and marks it recently used.
+func (c *CachedBackend) readCached(key string, index int64) ([]byte, bool) {
+	c.mu.Lock()
+	elem, ok := c.objects[key][index]
+	if ok {
+		c.lru.MoveToFront(elem)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	data, err := os.ReadFile(filepath.Join(c.dir, blockName(key, index)))
+	if err != nil {
+		// Removed behind our back; forget it
+		c.mu.Lock()
+		if elem, ok := c.objects[key][index]; ok {
+			c.remove(elem)
+		}
+		c.mu.Unlock()
+		return nil, false
+	}
+	return data, true
+}
+
+// store caches a block read from the inner backend. Failures only cost a
+// later cache miss, so they are ignored.
+func (c *CachedBackend) store(key string, index int64, data []byte) {
+	c.mu.Lock()
+	_, exists := c.objects[key][index]
+	c.mu.Unlock()
+	if exists || int64(len(data)) > c.maxBytes {
+		return
+	}
+
+	name := filepath.Join(c.dir, blockName(key, index))
+	tmp := name + ".tmp-" + uuid.New().String()
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		_ = os.Remove(tmp)
+		return
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		_ = os.Remove(tmp)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.insert(cacheBlock{key: key, index: index, size: int64(len(data))}, true)
+	if int64(len(data)) < c.blockSize {
+		c.sizes[key] = index*c.blockSize + int64(len(data))
+	}
+	c.evict()
+	metrics.StorageCacheBytes.Set(float64(c.used))
+}
+
+// insert indexes a block; the caller holds mu.
+func (c *CachedBackend) insert(b cacheBlock, front bool) {
+	if _, ok := c.objects[b.key][b.index]; ok {
+		return
+	}
+	var elem *list.Element
+	if front {
+		elem = c.lru.PushFront(&b)
+	} else {
+		elem = c.lru.PushBack(&b)
+	}
+	if c.objects[b.key] == nil {
+		c.objects[b.key] = map[int64]*list.Element{}
+	}
+	c.objects[b.key][b.index] = elem
+	c.used += b.size
+}
+
+// remove unindexes a block and deletes its file; the caller holds mu.
+func (c *CachedBackend) remove(elem *list.Element) {
+	b := c.lru.Remove(elem).(*cacheBlock)
+	delete(c.objects[b.key], b.index)
+	if len(c.objects[b.key]) == 0 {
+		delete(c.objects, b.key)
+		delete(c.sizes, b.key)
+	}
+	c.used -= b.size
+	_ = os.Remove(filepath.Join(c.dir, blockName(b.key, b.index)))
+}
+
+// evict removes the least recently used blocks until the cache fits its
+// limit; the caller holds mu.
+func (c *CachedBackend) evict() {
+	for c.used > c.maxBytes && c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+		metrics.StorageCacheEvictions.Inc()
+	}
+}
+
+// invalidate drops every cached block of the object at path.
+func (c *CachedBackend) invalidate(path string) {
+	key := cacheKey(path)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, elem := range c.objects[key] {
+		c.remove(elem)
+	}
+	delete(c.sizes, key)
+	metrics.StorageCacheBytes.Set(float64(c.used))
+}
+
+// cachedSize returns the object's size if all of it is cached.
+func (c *CachedBackend) cachedSize(key string) (int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	size, ok := c.sizes[key]
+	if !ok {
+		return 0, false
+	}
+	for i := int64(0); i*c.blockSize < size; i++ {
+		if _, ok := c.objects[key][i]; !ok {
+			return 0, false
+		}
+	}
+	return size, true
+}
+
+// pastEnd reports whether block index is known to lie past the end of the
+// object, which happens when its size is a multiple of the block size.
+func (c *CachedBackend) pastEnd(key string, index int64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	size, ok := c.sizes[key]
+	return ok && index*c.blockSize >= size
+}
+
+// setSize records the size of an object read to the end.
+func (c *CachedBackend) setSize(key string, size int64) {
+	c.mu.Lock()
+	if _, ok := c.objects[key]; ok || size == 0 {
+		c.sizes[key] = size
+	}
+	c.mu.Unlock()
+}
+
+// cacheKey names an object's blocks. Hashing the path keeps block names
+// safe as file names.
+func cacheKey(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return hex.EncodeToString(sum[:16])
+}
+
+func blockName(key string, index int64) string {
+	return key + "." + strconv.FormatInt(index, 10)
+}
+
+func parseBlockName(name string) (string, int64, bool) {
+	key, idx, ok := strings.Cut(name, ".")
+	if !ok || len(key) != 32 {
+		return "", 0, false
+	}
+	index, err := strconv.ParseInt(idx, 10, 64)
+	return key, index, err == nil && index >= 0
+}
+
+// cacheReader reads an object, or a range of it, block by block through the
+// cache.
+type cacheReader struct {
+	c         *CachedBackend
+	ctx       context.Context
+	path      string
+	key       string
+	index     int64 // Next block to read
+	skip      int64 // Bytes to skip at the start of the next block
+	remaining int64 // Bytes left to return
+	buf       []byte
+	last      bool // The block in buf is the object's last
+}
+
+func (r *cacheReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+	for len(r.buf) == 0 {
+		if r.last || r.c.pastEnd(r.key, r.index) {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf[:min(int64(len(r.buf)), r.remaining)])
+	r.buf = r.buf[n:]
+	r.remaining -= int64(n)
+	return n, nil
+}
+
+// next loads the next block into buf.
+func (r *cacheReader) next() error {
+	data, err := r.c.readBlock(r.ctx, r.path, r.key, r.index)
+	if err != nil {
+		return err
+	}
+	r.last = int64(len(data)) < r.c.blockSize
+	r.index++
+	r.buf = data[min(r.skip, int64(len(data))):]
+	r.skip = 0
+	return nil
+}
+
+func (r *cacheReader) Close() error {
+	r.buf = nil
+	return nil
+}
+
+// cacheFiller streams an object from the inner backend, caching each block
+// as it completes.
+type cacheFiller struct {
+	c     *CachedBackend
+	key   string
+	body  io.ReadCloser
+	block []byte // Bytes of the current block read so far
+	index int64
+	total int64
+}
+
+func (f *cacheFiller) Read(p []byte) (int, error) {
+	n, err := f.body.Read(p)
+	data := p[:n]
+	for len(data) > 0 {
+		take := min(len(data), cap(f.block)-len(f.block))
+		f.block = append(f.block, data[:take]...)
+		data = data[take:]
+		if len(f.block) == cap(f.block) {
+			f.flush()
+		}
+	}
+	f.total += int64(n)
+	if errors.Is(err, io.EOF) {
+		if len(f.block) > 0 {
+			f.flush()
+		}
+		f.c.setSize(f.key, f.total)
+	}
+	return n, err
+}
+
+func (f *cacheFiller) flush() {
+	f.c.store(f.key, f.index, f.block)
+	f.index++
+	f.block = f.block[:0]
+}
+
+func (f *cacheFiller) Close() error {
+	return f.body.Close()
+}
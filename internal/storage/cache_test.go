@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/agjmills/trove/internal/config"
+)
+
+// countingBackend counts the reads that reach a backend.
+type countingBackend struct {
+	*MemoryBackend
+	opens, ranges int
+}
+
+func (b *countingBackend) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	b.opens++
+	return b.MemoryBackend.Open(ctx, path)
+}
+
+func (b *countingBackend) OpenRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	b.ranges++
+	return b.MemoryBackend.OpenRange(ctx, path, offset, length)
+}
+
+func newTestCache(t *testing.T, maxBytes int64) (*CachedBackend, *countingBackend, string) {
+	t.Helper()
+	inner := &countingBackend{MemoryBackend: NewMemoryBackend()}
+	dir := t.TempDir()
+	c, err := newCachedBackend(inner, dir, maxBytes, 4)
+	if err != nil {
+		t.Fatalf("newCachedBackend failed: %v", err)
+	}
+	return c, inner, dir
+}
+
+func readRange(t *testing.T, b StorageBackend, path string, offset, length int64) string {
+	t.Helper()
+	r, err := b.OpenRange(context.Background(), path, offset, length)
+	if err != nil {
+		t.Fatalf("OpenRange failed: %v", err)
+	}
+	defer r.Close() //nolint:errcheck
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	return string(data)
+}
+
+func TestCachedBackend_InterfaceCompliance(t *testing.T) {
+	var _ StorageBackend = (*CachedBackend)(nil)
+	var _ Lister = (*CachedBackend)(nil)
+	var _ Putter = (*CachedBackend)(nil)
+}
+
+func TestNewBackendFromConfig_Cache(t *testing.T) {
+	backend, err := NewBackendFromConfig(&config.Config{StorageBackend: "memory", StorageCacheDir: t.TempDir(), StorageCacheSize: 1024})
+	if err != nil {
+		t.Fatalf("NewBackendFromConfig failed: %v", err)
+	}
+	if _, ok := backend.(*CachedBackend); !ok {
+		t.Errorf("expected a CachedBackend, got %T", backend)
+	}
+}
+
+func TestCachedBackend_OpenRange(t *testing.T) {
+	ctx := context.Background()
+	c, inner, _ := newTestCache(t, 1024)
+	result, err := c.Save(ctx, strings.NewReader("0123456789"), SaveOptions{})
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// Blocks 0 and 1 are fetched, then served from the cache
+	for range 2 {
+		if got := readRange(t, c, result.Path, 2, 5); got != "23456" {
+			t.Errorf("OpenRange returned %q", got)
+		}
+	}
+	if inner.ranges != 2 {
+		t.Errorf("expected 2 ranged reads of the inner backend, got %d", inner.ranges)
+	}
+
+	// Reading past the end stops at the last, short block
+	if got := readRange(t, c, result.Path, 6, 100); got != "6789" {
+		t.Errorf("OpenRange returned %q", got)
+	}
+	if inner.ranges != 3 {
+		t.Errorf("expected 3 ranged reads of the inner backend, got %d", inner.ranges)
+	}
+
+	// Every block is now cached, so Open does not touch the inner backend
+	if got := readAll(t, c, result.Path); got != "0123456789" {
+		t.Errorf("Open returned %q", got)
+	}
+	if inner.opens != 0 {
+		t.Errorf("expected Open to be served from the cache, got %d inner opens", inner.opens)
+	}
+
+	if _, err := c.OpenRange(ctx, "absent", 0, 10); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestCachedBackend_OpenFillsCache(t *testing.T) {
+	ctx := context.Background()
+	c, inner, _ := newTestCache(t, 1024)
+	result, _ := c.Save(ctx, strings.NewReader("01234567"), SaveOptions{})
+
+	if got := readAll(t, c, result.Path); got != "01234567" {
+		t.Errorf("Open returned %q", got)
+	}
+	if got := readAll(t, c, result.Path); got != "01234567" {
+		t.Errorf("Open returned %q", got)
+	}
+	if got := readRange(t, c, result.Path, 3, 5); got != "34567" {
+		t.Errorf("OpenRange returned %q", got)
+	}
+	if inner.opens != 1 || inner.ranges != 0 {
+		t.Errorf("expected one inner read, got %d opens and %d ranged reads", inner.opens, inner.ranges)
+	}
+}
+
+func TestCachedBackend_DeleteAndPutInvalidate(t *testing.T) {
+	ctx := context.Background()
+	c, _, dir := newTestCache(t, 1024)
+	result, _ := c.Save(ctx, strings.NewReader("original"), SaveOptions{})
+	readAll(t, c, result.Path)
+
+	if _, err := c.Put(ctx, result.Path, strings.NewReader("replaced"), SaveOptions{}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if got := readRange(t, c, result.Path, 0, 8); got != "replaced" {
+		t.Errorf("read %q after Put", got)
+	}
+
+	if err := c.Delete(ctx, result.Path); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := c.OpenRange(ctx, result.Path, 0, 8); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound after Delete, got %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("expected an empty cache directory, found %d entries", len(entries))
+	}
+}
+
+func TestCachedBackend_EvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	c, inner, dir := newTestCache(t, 8)
+	a, _ := c.Save(ctx, strings.NewReader("aaaa"), SaveOptions{})
+	b, _ := c.Save(ctx, strings.NewReader("bbbb"), SaveOptions{})
+	d, _ := c.Save(ctx, strings.NewReader("dddd"), SaveOptions{})
+
+	readRange(t, c, a.Path, 0, 4)
+	readRange(t, c, b.Path, 0, 4)
+	readRange(t, c, a.Path, 0, 4) // a is now more recent than b
+	readRange(t, c, d.Path, 0, 4) // evicts b
+	if inner.ranges != 3 {
+		t.Fatalf("expected 3 inner reads, got %d", inner.ranges)
+	}
+	readRange(t, c, a.Path, 0, 4)
+	if inner.ranges != 3 {
+		t.Errorf("a should still be cached")
+	}
+	readRange(t, c, b.Path, 0, 4)
+	if inner.ranges != 4 {
+		t.Errorf("b should have been evicted")
+	}
+
+	// A restart keeps what fits in the cache
+	reopened, err := newCachedBackend(inner, dir, 8, 4)
+	if err != nil {
+		t.Fatalf("newCachedBackend failed: %v", err)
+	}
+	if reopened.used != 8 {
+		t.Errorf("expected 8 cached bytes after reopening, got %d", reopened.used)
+	}
+}
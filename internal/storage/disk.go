@@ -5,27 +5,48 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 // DiskBackend implements StorageBackend using the local filesystem.
 // It uses os.Root (Go 1.23+) for sandboxed file operations, preventing path traversal attacks.
+//
+// Objects are stored two directories deep, under the first four hex digits
+// of the SHA-256 of their name (e.g. 3f/a2/<name>), so no directory grows
+// too large. Paths stay the bare names Save returns, the same as on other
+// backends; the directories are derived from them. Objects written by
+// earlier versions sit in the root directory, where they are still read,
+// until MigrateLayout moves them.
+//
+// Files are written to tmpDir, synced and then renamed into place, and the
+// directory is synced after the rename, so after a crash an object is
+// either complete or absent.
 type DiskBackend struct {
 	root     *os.Root
 	basePath string // stored for ValidateAccess logging
 }
 
+// tmpDir holds files being written until they are renamed into place. List
+// skips it and AbortStaleUploads removes files a crash left there.
+const tmpDir = ".tmp"
+
 // NewDiskBackend creates a new disk-based storage backend.
 // The basePath directory will be created if it doesn't exist.
 // All file operations are sandboxed to this directory using os.Root.
 func NewDiskBackend(basePath string) (*DiskBackend, error) {
 	// Create directory if needed
-	if err := os.MkdirAll(basePath, 0755); err != nil {
+	if err := os.MkdirAll(filepath.Join(basePath, tmpDir), 0755); err != nil {
 		return nil, fmt.Errorf("failed to create storage directory: %w", err)
 	}
 
@@ -41,17 +62,46 @@ func NewDiskBackend(basePath string) (*DiskBackend, error) {
 	}, nil
 }
 
+// location returns where the object at p is stored: in its shard directory
+// for a bare name, or as given for a path with directories.
+func location(p string) string {
+	if strings.Contains(p, "/") || strings.HasPrefix(p, ".") {
+		return p
+	}
+	sum := sha256.Sum256([]byte(p))
+	shard := hex.EncodeToString(sum[:2])
+	return path.Join(shard[:2], shard[2:], p)
+}
+
 // Save stores content and returns the generated path, hash, and size.
 // Size limits should be enforced at the HTTP handler level using http.MaxBytesReader.
 func (d *DiskBackend) Save(ctx context.Context, r io.Reader, opts SaveOptions) (SaveResult, error) {
 	// Generate unique filename
 	ext := filepath.Ext(opts.OriginalFilename)
-	filename := uuid.New().String() + ext
+	return d.Put(ctx, uuid.New().String()+ext, r, opts)
+}
 
+// Put stores content at path, replacing any file there. A reader never sees
+// a partial copy.
+func (d *DiskBackend) Put(ctx context.Context, path string, r io.Reader, opts SaveOptions) (SaveResult, error) {
+	tmp, result, err := d.writeTemp(r)
+	if err != nil {
+		return SaveResult{}, err
+	}
+	if err := d.commit(tmp, path); err != nil {
+		return SaveResult{}, err
+	}
+	result.Path = path
+	return result, nil
+}
+
+// writeTemp writes the content of r to a new file in tmpDir and syncs it.
+func (d *DiskBackend) writeTemp(r io.Reader) (string, SaveResult, error) {
 	// Create file using sandboxed root
-	file, err := d.root.Create(filename)
+	name := path.Join(tmpDir, uuid.New().String())
+	file, err := d.root.Create(name)
 	if err != nil {
-		return SaveResult{}, fmt.Errorf("failed to create file: %w", err)
+		return "", SaveResult{}, fmt.Errorf("failed to create file: %w", err)
 	}
 	defer file.Close() //nolint:errcheck
 
@@ -61,21 +111,304 @@ func (d *DiskBackend) Save(ctx context.Context, r io.Reader, opts SaveOptions) (
 	buf := make([]byte, copyBufferSize)
 
 	size, err := io.CopyBuffer(writer, r, buf)
+	if err == nil {
+		err = file.Sync()
+	}
+	if err == nil {
+		err = file.Close()
+	}
 	if err != nil {
-		_ = d.root.Remove(filename) // Clean up on error
-		return SaveResult{}, fmt.Errorf("failed to write file: %w", err)
+		_ = d.root.Remove(name) // Clean up on error
+		return "", SaveResult{}, fmt.Errorf("failed to write file: %w", err)
 	}
 
-	return SaveResult{
-		Path: filename,
+	return name, SaveResult{
 		Hash: hex.EncodeToString(hasher.Sum(nil)),
 		Size: size,
 	}, nil
 }
 
+// commit renames a synced temporary file to the object at p and syncs the
+// directory holding it, so the object survives a crash once commit returns.
+// A copy of the object left in the root directory by an earlier version is
+// removed.
+func (d *DiskBackend) commit(tmp, p string) error {
+	loc := location(p)
+	dir := path.Dir(loc)
+	if err := d.mkdirs(dir); err != nil {
+		_ = d.root.Remove(tmp)
+		return err
+	}
+	if err := d.root.Rename(tmp, loc); err != nil {
+		_ = d.root.Remove(tmp)
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := d.syncDir(dir); err != nil {
+		return err
+	}
+	if loc != p {
+		if err := d.root.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove old copy: %w", err)
+		}
+	}
+	return nil
+}
+
+// mkdirs creates dir and any missing parents, syncing the directory each
+// one is created in.
+func (d *DiskBackend) mkdirs(dir string) error {
+	if dir == "." {
+		return nil
+	}
+	if _, err := d.root.Stat(dir); err == nil {
+		return nil
+	}
+	parent := path.Dir(dir)
+	if err := d.mkdirs(parent); err != nil {
+		return err
+	}
+	if err := d.root.Mkdir(dir, 0755); err != nil && !errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	return d.syncDir(parent)
+}
+
+// syncDir flushes a directory's entries to disk.
+func (d *DiskBackend) syncDir(dir string) error {
+	f, err := d.root.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory: %w", err)
+	}
+	defer f.Close() //nolint:errcheck
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory: %w", err)
+	}
+	return nil
+}
+
+// openObject opens the object at p, falling back to the root directory for
+// an object not yet moved to its shard directory.
+func (d *DiskBackend) openObject(p string) (*os.File, error) {
+	loc := location(p)
+	file, err := d.root.Open(loc)
+	if loc == p || !errors.Is(err, fs.ErrNotExist) {
+		return file, err
+	}
+	if file, err = d.root.Open(p); !errors.Is(err, fs.ErrNotExist) {
+		return file, err
+	}
+	// MigrateLayout may have moved it in the meantime
+	return d.root.Open(loc)
+}
+
+// statObject is openObject for Stat.
+func (d *DiskBackend) statObject(p string) (fs.FileInfo, error) {
+	loc := location(p)
+	info, err := d.root.Stat(loc)
+	if loc == p || !errors.Is(err, fs.ErrNotExist) {
+		return info, err
+	}
+	if info, err = d.root.Stat(p); !errors.Is(err, fs.ErrNotExist) {
+		return info, err
+	}
+	return d.root.Stat(loc)
+}
+
+// MigrateLayout moves objects that earlier versions stored in the root
+// directory into their shard directories and returns how many it moved.
+// They stay readable at the same paths throughout, so Trove can keep
+// running. The directories are synced once all objects are moved.
+func (d *DiskBackend) MigrateLayout(ctx context.Context) (int, error) {
+	entries, err := fs.ReadDir(d.root.FS(), ".")
+	if err != nil {
+		return 0, fmt.Errorf("failed to list files: %w", err)
+	}
+	moved := 0
+	dirs := map[string]bool{}
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return moved, err
+		}
+		name := entry.Name()
+		loc := location(name)
+		if !entry.Type().IsRegular() || loc == name {
+			continue
+		}
+		dir := path.Dir(loc)
+		if err := d.mkdirs(dir); err != nil {
+			return moved, err
+		}
+		if _, err := d.root.Stat(loc); err == nil {
+			// Already replaced by a newer copy in the shard directory
+			if err := d.root.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return moved, fmt.Errorf("failed to remove old copy: %w", err)
+			}
+			continue
+		}
+		if err := d.root.Rename(name, loc); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue // Deleted meanwhile
+			}
+			return moved, fmt.Errorf("failed to move %s: %w", name, err)
+		}
+		dirs[dir] = true
+		moved++
+	}
+	if moved == 0 {
+		return 0, nil
+	}
+	dirs["."] = true
+	for dir := range dirs {
+		if err := d.syncDir(dir); err != nil {
+			return moved, err
+		}
+	}
+	return moved, nil
+}
+
+// multipartDir holds the parts of multipart uploads, a directory per upload
+// named after it. List skips it.
+const multipartDir = ".multipart"
+
+// InitMultipart starts a multipart upload. The upload ID is the path the
+// file will have.
+func (d *DiskBackend) InitMultipart(ctx context.Context, partSize int64, opts SaveOptions) (string, error) {
+	id := uuid.New().String() + filepath.Ext(opts.OriginalFilename)
+	if err := d.root.MkdirAll(path.Join(multipartDir, id), 0755); err != nil {
+		return "", fmt.Errorf("failed to create upload directory: %w", err)
+	}
+	return id, nil
+}
+
+// PutPart stores a part of a multipart upload, replacing it atomically if it
+// was sent before.
+func (d *DiskBackend) PutPart(ctx context.Context, uploadID string, number int, r io.Reader, size int64) error {
+	dir := path.Join(multipartDir, uploadID)
+	if _, err := d.root.Stat(dir); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to stat upload directory: %w", err)
+	}
+
+	tmp, result, err := d.writeTemp(io.LimitReader(r, size+1))
+	if err != nil {
+		return err
+	}
+	if result.Size != size {
+		_ = d.root.Remove(tmp)
+		return partSizeError(number, result.Size, size)
+	}
+	return d.commit(tmp, path.Join(dir, strconv.Itoa(number)))
+}
+
+// CompleteMultipart joins the parts of a multipart upload into a file. The
+// parts are copied on the same filesystem, which Linux does without
+// reading them into memory.
+func (d *DiskBackend) CompleteMultipart(ctx context.Context, uploadID string, parts int) (SaveResult, error) {
+	dir := path.Join(multipartDir, uploadID)
+	if _, err := d.root.Stat(dir); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return SaveResult{}, ErrNotFound
+		}
+		return SaveResult{}, fmt.Errorf("failed to stat upload directory: %w", err)
+	}
+	tmp := path.Join(tmpDir, uuid.New().String())
+	file, err := d.root.Create(tmp)
+	if err != nil {
+		return SaveResult{}, fmt.Errorf("failed to create file: %w", err)
+	}
+	defer file.Close() //nolint:errcheck
+	fail := func(err error) (SaveResult, error) {
+		_ = d.root.Remove(tmp)
+		return SaveResult{}, err
+	}
+
+	var size int64
+	for number := 1; number <= parts; number++ {
+		n, err := d.appendPart(file, path.Join(dir, strconv.Itoa(number)))
+		if errors.Is(err, fs.ErrNotExist) {
+			return fail(fmt.Errorf("part %d was not uploaded", number))
+		}
+		if err != nil {
+			return fail(fmt.Errorf("failed to join part %d: %w", number, err))
+		}
+		size += n
+	}
+	if err := file.Sync(); err != nil {
+		return fail(fmt.Errorf("failed to write file: %w", err))
+	}
+	if err := file.Close(); err != nil {
+		return fail(fmt.Errorf("failed to write file: %w", err))
+	}
+	if err := d.commit(tmp, uploadID); err != nil {
+		return SaveResult{}, err
+	}
+	if err := d.root.RemoveAll(dir); err != nil {
+		return SaveResult{}, fmt.Errorf("failed to remove upload directory: %w", err)
+	}
+	return SaveResult{Path: uploadID, Size: size}, nil
+}
+
+func (d *DiskBackend) appendPart(dst *os.File, name string) (int64, error) {
+	part, err := d.root.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer part.Close() //nolint:errcheck
+	return io.Copy(dst, part)
+}
+
+// AbortMultipart discards a multipart upload.
+func (d *DiskBackend) AbortMultipart(ctx context.Context, uploadID string) error {
+	if err := d.root.RemoveAll(path.Join(multipartDir, uploadID)); err != nil {
+		return fmt.Errorf("failed to remove upload directory: %w", err)
+	}
+	return nil
+}
+
+// AbortStaleUploads discards multipart uploads that have not received a
+// part since cutoff, and files a crash left half written before then.
+func (d *DiskBackend) AbortStaleUploads(ctx context.Context, cutoff time.Time) (int, error) {
+	aborted := 0
+	temps, err := fs.ReadDir(d.root.FS(), tmpDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return 0, fmt.Errorf("failed to list temporary files: %w", err)
+	}
+	for _, entry := range temps {
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := d.root.Remove(path.Join(tmpDir, entry.Name())); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return aborted, fmt.Errorf("failed to remove temporary file: %w", err)
+		}
+		aborted++
+	}
+
+	entries, err := fs.ReadDir(d.root.FS(), multipartDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return aborted, nil
+	}
+	if err != nil {
+		return aborted, fmt.Errorf("failed to list multipart uploads: %w", err)
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := d.AbortMultipart(ctx, entry.Name()); err != nil {
+			return aborted, err
+		}
+		aborted++
+	}
+	return aborted, nil
+}
+
 // Open returns a reader for the file at the given path.
 func (d *DiskBackend) Open(ctx context.Context, path string) (io.ReadCloser, error) {
-	file, err := d.root.Open(path)
+	file, err := d.openObject(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, ErrNotFound
@@ -90,7 +423,7 @@ func (d *DiskBackend) OpenRange(ctx context.Context, path string, offset, length
 	if length <= 0 {
 		return nil, fmt.Errorf("length must be > 0")
 	}
-	file, err := d.root.Open(path)
+	file, err := d.openObject(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, ErrNotFound
@@ -107,17 +440,20 @@ func (d *DiskBackend) OpenRange(ctx context.Context, path string, offset, length
 	}, nil
 }
 
-// Delete removes a file. Returns nil if file doesn't exist (idempotent).
+// Delete removes a file, wherever the layout it was written in put it.
+// Returns nil if file doesn't exist (idempotent).
 func (d *DiskBackend) Delete(ctx context.Context, path string) error {
-	if err := d.root.Remove(path); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to delete file: %w", err)
+	for _, name := range []string{location(path), path} {
+		if err := d.root.Remove(name); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete file: %w", err)
+		}
 	}
 	return nil
 }
 
 // Stat returns file metadata without opening it.
 func (d *DiskBackend) Stat(ctx context.Context, path string) (FileInfo, error) {
-	info, err := d.root.Stat(path)
+	info, err := d.statObject(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return FileInfo{}, ErrNotFound
@@ -132,6 +468,36 @@ func (d *DiskBackend) Stat(ctx context.Context, path string) (FileInfo, error) {
 	}, nil
 }
 
+// List calls fn for every file under the storage root. Objects in their
+// shard directory are listed by their bare name.
+func (d *DiskBackend) List(ctx context.Context, fn func(FileInfo) error) error {
+	return fs.WalkDir(d.root.FS(), ".", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to list files: %w", err)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path == multipartDir || path == tmpDir {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if name := entry.Name(); location(name) == path {
+			path = name
+		}
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil // Deleted while listing
+		}
+		if err != nil {
+			return fmt.Errorf("failed to stat file: %w", err)
+		}
+		return fn(FileInfo{Path: path, Size: info.Size(), ModTime: info.ModTime()})
+	})
+}
+
 // HealthCheck verifies the backend is reachable (cheap, safe for frequent polling).
 func (d *DiskBackend) HealthCheck(ctx context.Context) error {
 	// Check that we can stat the root directory
@@ -93,8 +93,11 @@ func TestDiskBackend_Save(t *testing.T) {
 		t.Errorf("Expected hash %s, got %s", expectedHash, result.Hash)
 	}
 
-	// Verify file exists on disk
-	filePath := filepath.Join(tempDir, result.Path)
+	// Verify file exists on disk, in its shard directory
+	filePath := filepath.Join(tempDir, filepath.FromSlash(location(result.Path)))
+	if filepath.Dir(filePath) == tempDir {
+		t.Errorf("expected %s to be stored in a subdirectory", result.Path)
+	}
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
 		t.Error("File was not saved to disk")
 	}
@@ -372,6 +375,154 @@ func TestDiskBackend_ValidateAccess(t *testing.T) {
 func TestDiskBackend_InterfaceCompliance(t *testing.T) {
 	// This test ensures DiskBackend implements StorageBackend interface
 	var _ StorageBackend = (*DiskBackend)(nil)
+	var _ Lister = (*DiskBackend)(nil)
+	var _ Putter = (*DiskBackend)(nil)
+}
+
+func TestDiskBackend_Put(t *testing.T) {
+	tempDir := t.TempDir()
+	backend, err := NewDiskBackend(tempDir)
+	if err != nil {
+		t.Fatalf("NewDiskBackend failed: %v", err)
+	}
+	defer backend.Close() //nolint:errcheck
+
+	ctx := context.Background()
+	for _, content := range []string{"first version", "second"} {
+		result, err := backend.Put(ctx, "chosen.txt", strings.NewReader(content), SaveOptions{})
+		if err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if result.Path != "chosen.txt" || result.Size != int64(len(content)) {
+			t.Errorf("unexpected result: %+v", result)
+		}
+	}
+
+	// The second Put replaced the first, leaving no temporary files behind
+	got, err := os.ReadFile(filepath.Join(tempDir, filepath.FromSlash(location("chosen.txt"))))
+	if err != nil || string(got) != "second" {
+		t.Errorf("expected replaced content, got %q (err %v)", got, err)
+	}
+	entries, _ := os.ReadDir(filepath.Join(tempDir, tmpDir))
+	if len(entries) != 0 {
+		t.Errorf("expected no temporary files, found %d entries", len(entries))
+	}
+}
+
+func TestDiskBackend_MigrateLayout(t *testing.T) {
+	tempDir := t.TempDir()
+	backend, err := NewDiskBackend(tempDir)
+	if err != nil {
+		t.Fatalf("NewDiskBackend failed: %v", err)
+	}
+	defer backend.Close() //nolint:errcheck
+
+	// Objects stored flat by earlier versions are readable before migrating
+	ctx := context.Background()
+	flat := []string{"0f8e1f0c-legacy.txt", "a3c9-legacy.bin"}
+	for _, name := range flat {
+		if err := os.WriteFile(filepath.Join(tempDir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := backend.Stat(ctx, name); err != nil {
+			t.Errorf("%s: Stat before migrating failed: %v", name, err)
+		}
+	}
+
+	moved, err := backend.MigrateLayout(ctx)
+	if err != nil {
+		t.Fatalf("MigrateLayout failed: %v", err)
+	}
+	if moved != len(flat) {
+		t.Errorf("expected %d objects moved, got %d", len(flat), moved)
+	}
+	listed := map[string]bool{}
+	if err := backend.List(ctx, func(info FileInfo) error {
+		listed[info.Path] = true
+		return nil
+	}); err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	for _, name := range flat {
+		if _, err := os.Stat(filepath.Join(tempDir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s: flat copy left behind", name)
+		}
+		reader, err := backend.Open(ctx, name)
+		if err != nil {
+			t.Fatalf("%s: Open after migrating failed: %v", name, err)
+		}
+		got, _ := io.ReadAll(reader)
+		_ = reader.Close()
+		if string(got) != name {
+			t.Errorf("%s: read %q after migrating", name, got)
+		}
+		if !listed[name] {
+			t.Errorf("%s: not listed by its original path", name)
+		}
+	}
+
+	// Nothing is left to move
+	if moved, err := backend.MigrateLayout(ctx); err != nil || moved != 0 {
+		t.Errorf("expected nothing to migrate again, moved %d (err %v)", moved, err)
+	}
+}
+
+func TestDiskBackend_List(t *testing.T) {
+	tempDir := t.TempDir()
+	backend, err := NewDiskBackend(tempDir)
+	if err != nil {
+		t.Fatalf("NewDiskBackend failed: %v", err)
+	}
+	defer backend.Close() //nolint:errcheck
+
+	ctx := context.Background()
+	want := map[string]int64{}
+	for _, content := range []string{"one", "three"} {
+		result, err := backend.Save(ctx, strings.NewReader(content), SaveOptions{OriginalFilename: "f.txt"})
+		if err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+		want[result.Path] = result.Size
+	}
+	// Files in subdirectories are listed with their relative path
+	if err := os.MkdirAll(filepath.Join(tempDir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "sub", "nested.bin"), []byte("nested"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	want["sub/nested.bin"] = int64(len("nested"))
+
+	got := map[string]int64{}
+	err = backend.List(ctx, func(info FileInfo) error {
+		got[info.Path] = info.Size
+		if info.ModTime.IsZero() {
+			t.Errorf("%s: missing modification time", info.Path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("List returned %v, want %v", got, want)
+	}
+	for path, size := range want {
+		if got[path] != size {
+			t.Errorf("%s: size %d, want %d", path, got[path], size)
+		}
+	}
+
+	// An error from fn stops the listing
+	stop := errors.New("stop")
+	calls := 0
+	err = backend.List(ctx, func(FileInfo) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("expected listing to stop after the first error, got %v after %d calls", err, calls)
+	}
 }
 
 func TestDiskBackend_Save_MultipleConcurrent(t *testing.T) {
@@ -0,0 +1,609 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Encrypted object layout:
+//
+//	magic    4 bytes   "TRV\x01"
+//	key ID   32 bytes  length byte + ID, zero padded
+//	data key 60 bytes  nonce + data key sealed with the master key (AAD: magic + key ID)
+//	chunks   ...       AES-256-GCM, 64 KiB of plaintext each, the last one may be short
+//
+// Each chunk's nonce is its index plus a flag marking the final chunk, so
+// chunks cannot be reordered, dropped or truncated without failing
+// authentication. The header has a fixed size, which lets OpenRange and Stat
+// map plaintext offsets to ciphertext offsets without reading the object.
+const (
+	encMagic       = "TRV\x01"
+	encKeyIDSize   = 32
+	encWrappedSize = 12 + 32 + 16
+	encHeaderSize  = len(encMagic) + encKeyIDSize + encWrappedSize
+	encChunkSize   = 64 * 1024
+	encSealedChunk = encChunkSize + 16
+)
+
+// ErrDecrypt is returned when an encrypted object fails authentication,
+// because it was modified, truncated or wrapped with an unknown key.
+var ErrDecrypt = errors.New("storage: decryption failed")
+
+// ErrNotEncrypted is returned when encryption is required and an object has
+// no encryption header.
+var ErrNotEncrypted = errors.New("storage: object is not encrypted")
+
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,31}$`)
+
+// Keyring holds the master keys that wrap per-object data keys. New objects
+// are wrapped with the active key; the others are kept so objects wrapped
+// before a rotation can still be read.
+type Keyring struct {
+	active string
+	keys   map[string]cipher.AEAD
+}
+
+// ParseKeyring parses ENCRYPTION_KEYS, a comma-separated list of
+// "id:base64-key" pairs with 32-byte keys (e.g. from `openssl rand -base64 32`).
+// active names the key for new objects and defaults to the first one.
+func ParseKeyring(spec, active string) (*Keyring, error) {
+	kr := &Keyring{active: active, keys: map[string]cipher.AEAD{}}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || !keyIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("invalid encryption key %q: expected id:base64-key with an ID of up to 31 letters, digits, '.', '_' or '-'", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("encryption key %q must be 32 bytes, base64 encoded", id)
+		}
+		if _, dup := kr.keys[id]; dup {
+			return nil, fmt.Errorf("duplicate encryption key ID %q", id)
+		}
+		aead, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		kr.keys[id] = aead
+		if kr.active == "" {
+			kr.active = id
+		}
+	}
+	if len(kr.keys) == 0 {
+		return nil, errors.New("no encryption keys configured")
+	}
+	if _, ok := kr.keys[kr.active]; !ok {
+		return nil, fmt.Errorf("active encryption key %q is not in ENCRYPTION_KEYS", kr.active)
+	}
+	return kr, nil
+}
+
+// ActiveKeyID returns the ID of the key used for new objects.
+func (kr *Keyring) ActiveKeyID() string {
+	return kr.active
+}
+
+// KeyIDs returns the IDs of all configured keys.
+func (kr *Keyring) KeyIDs() []string {
+	ids := make([]string, 0, len(kr.keys))
+	for id := range kr.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// EncryptedBackend wraps another backend and encrypts objects at rest. Each
+// object has its own random data key, stored in the object's header wrapped
+// with a master key from the Keyring. Objects stored before encryption was
+// enabled are read as plaintext until Rewrap encrypts them, unless
+// encryption is required.
+type EncryptedBackend struct {
+	inner    StorageBackend
+	keys     *Keyring
+	required bool
+}
+
+// NewEncryptedBackend returns a backend that encrypts objects stored in inner.
+func NewEncryptedBackend(inner StorageBackend, keys *Keyring) *EncryptedBackend {
+	return &EncryptedBackend{inner: inner, keys: keys}
+}
+
+// SetRequired makes reads of objects without an encryption header fail with
+// ErrNotEncrypted instead of returning them unauthenticated. Turn it on once
+// Rewrap has encrypted every object stored before encryption was enabled.
+func (e *EncryptedBackend) SetRequired(required bool) {
+	e.required = required
+}
+
+// Keys returns the backend's keyring.
+func (e *EncryptedBackend) Keys() *Keyring {
+	return e.keys
+}
+
+// Save encrypts content into the inner backend. The returned hash and size
+// describe the plaintext, so deduplication is unaffected.
+func (e *EncryptedBackend) Save(ctx context.Context, r io.Reader, opts SaveOptions) (SaveResult, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return SaveResult{}, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	header, err := e.newHeader(e.keys.active, dataKey)
+	if err != nil {
+		return SaveResult{}, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return SaveResult{}, err
+	}
+
+	// Encrypt in a goroutine so the inner backend can stream the ciphertext
+	pr, pw := io.Pipe()
+	hasher := sha256.New()
+	var size int64
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sealer := &chunkSealer{w: pw, aead: aead, buf: make([]byte, 0, encChunkSize)}
+		_, err := pw.Write(header)
+		if err == nil {
+			size, err = io.CopyBuffer(io.MultiWriter(sealer, hasher), r, make([]byte, copyBufferSize))
+		}
+		if err == nil {
+			err = sealer.Close()
+		}
+		_ = pw.CloseWithError(err)
+	}()
+
+	result, err := e.inner.Save(ctx, pr, opts)
+	_ = pr.CloseWithError(io.ErrClosedPipe) // Unblock the writer if Save stopped early
+	<-done
+	if err != nil {
+		return SaveResult{}, err
+	}
+
+	return SaveResult{
+		Path: result.Path,
+		Hash: hex.EncodeToString(hasher.Sum(nil)),
+		Size: size,
+	}, nil
+}
+
+// Open returns a reader that decrypts the object as it is read.
+func (e *EncryptedBackend) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	body, err := e.inner.Open(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, encHeaderSize)
+	n, err := io.ReadFull(body, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		_ = body.Close()
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if !isEncrypted(header[:n]) {
+		if e.required {
+			_ = body.Close()
+			return nil, ErrNotEncrypted
+		}
+		// Stored before encryption was enabled
+		return &limitedReadCloser{Reader: io.MultiReader(bytes.NewReader(header[:n]), body), Closer: body}, nil
+	}
+
+	aead, err := e.unwrap(header)
+	if err != nil {
+		_ = body.Close()
+		return nil, err
+	}
+	return newChunkOpener(body, aead, 0, 0, -1), nil
+}
+
+// OpenRange decrypts only the chunks covering the requested range, so
+// seeking in a large video does not read the whole object.
+func (e *EncryptedBackend) OpenRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	if length <= 0 {
+		return nil, fmt.Errorf("length must be > 0")
+	}
+	header, err := e.readHeader(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if !isEncrypted(header) {
+		if e.required {
+			return nil, ErrNotEncrypted
+		}
+		return e.inner.OpenRange(ctx, path, offset, length)
+	}
+	aead, err := e.unwrap(header)
+	if err != nil {
+		return nil, err
+	}
+
+	first := offset / encChunkSize
+	last := (offset + length - 1) / encChunkSize
+	start := int64(encHeaderSize) + first*encSealedChunk
+	// One byte past the last chunk tells whether that chunk is the final one
+	end := int64(encHeaderSize) + (last+1)*encSealedChunk + 1
+	body, err := e.inner.OpenRange(ctx, path, start, end-start)
+	if err != nil {
+		return nil, err
+	}
+	return newChunkOpener(body, aead, uint64(first), int(offset-first*encChunkSize), length), nil
+}
+
+// Delete removes the object from the inner backend.
+func (e *EncryptedBackend) Delete(ctx context.Context, path string) error {
+	return e.inner.Delete(ctx, path)
+}
+
+// Stat returns the object's metadata with its plaintext size.
+func (e *EncryptedBackend) Stat(ctx context.Context, path string) (FileInfo, error) {
+	info, err := e.inner.Stat(ctx, path)
+	if err != nil {
+		return info, err
+	}
+	if info.Size < int64(encHeaderSize) {
+		if e.required {
+			return FileInfo{}, ErrNotEncrypted
+		}
+		return info, nil
+	}
+	header, err := e.readHeader(ctx, path)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	switch {
+	case isEncrypted(header):
+		info.Size = plaintextSize(info.Size)
+	case e.required:
+		return FileInfo{}, ErrNotEncrypted
+	}
+	return info, nil
+}
+
+// List lists the inner backend, if it supports listing. Sizes are those of
+// the encrypted objects.
+func (e *EncryptedBackend) List(ctx context.Context, fn func(FileInfo) error) error {
+	lister, ok := e.inner.(Lister)
+	if !ok {
+		return ErrListUnsupported
+	}
+	return lister.List(ctx, fn)
+}
+
+// MigrateLayout migrates the inner backend.
+func (e *EncryptedBackend) MigrateLayout(ctx context.Context) (int, error) {
+	return MigrateLayout(ctx, e.inner)
+}
+
+// AbortStaleUploads sweeps the inner backend.
+func (e *EncryptedBackend) AbortStaleUploads(ctx context.Context, cutoff time.Time) (int, error) {
+	return abortStaleUploads(ctx, e.inner, cutoff)
+}
+
+// HealthCheck checks the inner backend.
+func (e *EncryptedBackend) HealthCheck(ctx context.Context) error {
+	return e.inner.HealthCheck(ctx)
+}
+
+// ValidateAccess checks the inner backend, then round-trips a test object
+// through encryption.
+func (e *EncryptedBackend) ValidateAccess(ctx context.Context) error {
+	if err := e.inner.ValidateAccess(ctx); err != nil {
+		return err
+	}
+	content := []byte("trove-encryption-test")
+	result, err := e.Save(ctx, bytes.NewReader(content), SaveOptions{OriginalFilename: ".trove-access-test"})
+	if err != nil {
+		return fmt.Errorf("encrypted write test failed: %w", err)
+	}
+	defer func() { _ = e.inner.Delete(ctx, result.Path) }()
+
+	r, err := e.Open(ctx, result.Path)
+	if err != nil {
+		return fmt.Errorf("encrypted read test failed: %w", err)
+	}
+	got, err := io.ReadAll(r)
+	_ = r.Close()
+	if err != nil || !bytes.Equal(got, content) {
+		return fmt.Errorf("encrypted read test failed: content mismatch (%v)", err)
+	}
+	return nil
+}
+
+// Rewrap brings the object at path onto the active master key. An object
+// wrapped with an older key is copied with its data key re-wrapped (the
+// content is not re-encrypted); a plaintext object from before encryption
+// was enabled is encrypted. It returns the path of the new object, or path
+// itself when nothing needed to change. The caller must point references at
+// the new path and then delete the old object.
+func (e *EncryptedBackend) Rewrap(ctx context.Context, path string) (string, error) {
+	info, err := e.inner.Stat(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	body, err := e.inner.Open(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close() //nolint:errcheck
+
+	header := make([]byte, encHeaderSize)
+	n, err := io.ReadFull(body, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+	opts := SaveOptions{OriginalFilename: path, ContentType: info.ContentType, Policy: PolicyFor(e, path)}
+
+	if !isEncrypted(header[:n]) {
+		result, err := e.Save(ctx, io.MultiReader(bytes.NewReader(header[:n]), body), opts)
+		if err != nil {
+			return "", err
+		}
+		return result.Path, nil
+	}
+
+	keyID := headerKeyID(header)
+	if keyID == e.keys.active {
+		return path, nil
+	}
+	master, ok := e.keys.keys[keyID]
+	if !ok {
+		return "", fmt.Errorf("%w: object is wrapped with unknown key %q", ErrDecrypt, keyID)
+	}
+	dataKey, err := unwrapDataKey(master, header)
+	if err != nil {
+		return "", err
+	}
+	newHeader, err := e.newHeader(e.keys.active, dataKey)
+	if err != nil {
+		return "", err
+	}
+	result, err := e.inner.Save(ctx, io.MultiReader(bytes.NewReader(newHeader), body), opts)
+	if err != nil {
+		return "", err
+	}
+	return result.Path, nil
+}
+
+// KeyID reports which master key wraps the object at path, or "" for a
+// plaintext object.
+func (e *EncryptedBackend) KeyID(ctx context.Context, path string) (string, error) {
+	header, err := e.readHeader(ctx, path)
+	if err != nil || !isEncrypted(header) {
+		return "", err
+	}
+	return headerKeyID(header), nil
+}
+
+// readHeader returns the first encHeaderSize bytes of an object, or fewer
+// if it is shorter.
+func (e *EncryptedBackend) readHeader(ctx context.Context, path string) ([]byte, error) {
+	r, err := e.inner.OpenRange(ctx, path, 0, int64(encHeaderSize))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close() //nolint:errcheck
+	header := make([]byte, encHeaderSize)
+	n, err := io.ReadFull(r, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	return header[:n], nil
+}
+
+func (e *EncryptedBackend) newHeader(keyID string, dataKey []byte) ([]byte, error) {
+	header := make([]byte, 0, encHeaderSize)
+	header = append(header, encMagic...)
+	id := make([]byte, encKeyIDSize)
+	id[0] = byte(len(keyID))
+	copy(id[1:], keyID)
+	header = append(header, id...)
+
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	header = append(header, nonce...)
+	return e.keys.keys[keyID].Seal(header, nonce, dataKey, header[:len(encMagic)+encKeyIDSize]), nil
+}
+
+// unwrap returns the AEAD for an encrypted object's data key.
+func (e *EncryptedBackend) unwrap(header []byte) (cipher.AEAD, error) {
+	keyID := headerKeyID(header)
+	master, ok := e.keys.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: object is wrapped with unknown key %q", ErrDecrypt, keyID)
+	}
+	dataKey, err := unwrapDataKey(master, header)
+	if err != nil {
+		return nil, err
+	}
+	return newGCM(dataKey)
+}
+
+func unwrapDataKey(master cipher.AEAD, header []byte) ([]byte, error) {
+	prefix := header[:len(encMagic)+encKeyIDSize]
+	wrapped := header[len(prefix):encHeaderSize]
+	dataKey, err := master.Open(nil, wrapped[:12], wrapped[12:], prefix)
+	if err != nil {
+		return nil, fmt.Errorf("%w: cannot unwrap data key", ErrDecrypt)
+	}
+	return dataKey, nil
+}
+
+func isEncrypted(header []byte) bool {
+	return len(header) == encHeaderSize && string(header[:len(encMagic)]) == encMagic
+}
+
+func headerKeyID(header []byte) string {
+	id := header[len(encMagic) : len(encMagic)+encKeyIDSize]
+	n := min(int(id[0]), encKeyIDSize-1)
+	return string(id[1 : 1+n])
+}
+
+// plaintextSize derives the plaintext size of an encrypted object from its
+// stored size: every chunk, including a short final one, adds a 16-byte tag.
+func plaintextSize(stored int64) int64 {
+	body := stored - int64(encHeaderSize)
+	chunks := (body + encSealedChunk - 1) / encSealedChunk
+	return max(body-chunks*16, 0)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(index uint64, final bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], index)
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// chunkSealer encrypts a stream in encChunkSize chunks. A full chunk is held
+// back until more data arrives, so Close can mark the real last chunk final.
+type chunkSealer struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	buf   []byte
+	index uint64
+}
+
+func (s *chunkSealer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if len(s.buf) == encChunkSize {
+			if err := s.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := min(len(p), encChunkSize-len(s.buf))
+		s.buf = append(s.buf, p[:n]...)
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close writes the final chunk, which is empty for empty content.
+func (s *chunkSealer) Close() error {
+	return s.seal(true)
+}
+
+func (s *chunkSealer) seal(final bool) error {
+	sealed := s.aead.Seal(nil, chunkNonce(s.index, final), s.buf, nil)
+	s.index++
+	s.buf = s.buf[:0]
+	_, err := s.w.Write(sealed)
+	return err
+}
+
+// chunkOpener decrypts chunks starting at chunk index, dropping skip bytes
+// from the first and returning at most remaining bytes (-1 for no limit).
+type chunkOpener struct {
+	r         *bufio.Reader
+	body      io.Closer
+	aead      cipher.AEAD
+	index     uint64
+	skip      int
+	remaining int64
+	sealed    []byte
+	out       []byte
+	plain     []byte
+	final     bool
+}
+
+func newChunkOpener(body io.ReadCloser, aead cipher.AEAD, index uint64, skip int, remaining int64) *chunkOpener {
+	return &chunkOpener{
+		r:         bufio.NewReaderSize(body, encSealedChunk),
+		body:      body,
+		aead:      aead,
+		index:     index,
+		skip:      skip,
+		remaining: remaining,
+		sealed:    make([]byte, encSealedChunk),
+		out:       make([]byte, 0, encChunkSize),
+	}
+}
+
+func (c *chunkOpener) Read(p []byte) (int, error) {
+	if c.remaining == 0 {
+		return 0, io.EOF
+	}
+	for len(c.plain) == 0 {
+		if c.final {
+			return 0, io.EOF
+		}
+		if err := c.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.plain)
+	if c.remaining >= 0 && int64(n) > c.remaining {
+		n = int(c.remaining)
+	}
+	c.plain = c.plain[n:]
+	if c.remaining >= 0 {
+		c.remaining -= int64(n)
+	}
+	return n, nil
+}
+
+func (c *chunkOpener) next() error {
+	n, err := io.ReadFull(c.r, c.sealed)
+	switch {
+	case errors.Is(err, io.EOF):
+		// The stream ended without a final chunk
+		return fmt.Errorf("%w: file is truncated", ErrDecrypt)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		c.final = true // A short chunk is always the last one
+	case err != nil:
+		return err
+	default:
+		if _, err := c.r.Peek(1); errors.Is(err, io.EOF) {
+			c.final = true
+		}
+	}
+
+	plain, err := c.aead.Open(c.out[:0], chunkNonce(c.index, c.final), c.sealed[:n], nil)
+	if err != nil {
+		return fmt.Errorf("%w: chunk %d failed authentication", ErrDecrypt, c.index)
+	}
+	c.index++
+	if c.skip > 0 {
+		plain = plain[min(c.skip, len(plain)):]
+		c.skip = 0
+	}
+	c.plain = plain
+	return nil
+}
+
+func (c *chunkOpener) Close() error {
+	return c.body.Close()
+}
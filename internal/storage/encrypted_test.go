@@ -0,0 +1,336 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testKey(t *testing.T) string {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func newTestEncryptedBackend(t *testing.T, inner StorageBackend, spec, active string) *EncryptedBackend {
+	t.Helper()
+	keys, err := ParseKeyring(spec, active)
+	if err != nil {
+		t.Fatalf("ParseKeyring failed: %v", err)
+	}
+	return NewEncryptedBackend(inner, keys)
+}
+
+// reader returns a helper that reads everything from an Open or OpenRange
+// result, failing the test on error.
+func reader(t *testing.T) func(io.ReadCloser, error) []byte {
+	return func(r io.ReadCloser, err error) []byte {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("open failed: %v", err)
+		}
+		defer r.Close() //nolint:errcheck
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("read failed: %v", err)
+		}
+		return data
+	}
+}
+
+func TestEncryptedBackend_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	read := reader(t)
+	inner := NewMemoryBackend()
+	backend := newTestEncryptedBackend(t, inner, "k1:"+testKey(t), "")
+
+	for _, size := range []int{0, 1, encChunkSize - 1, encChunkSize, encChunkSize + 1, 3*encChunkSize + 5} {
+		content := make([]byte, size)
+		_, _ = rand.Read(content)
+
+		result, err := backend.Save(ctx, bytes.NewReader(content), SaveOptions{OriginalFilename: "file.bin"})
+		if err != nil {
+			t.Fatalf("size %d: Save failed: %v", size, err)
+		}
+		sum := sha256.Sum256(content)
+		if result.Hash != hex.EncodeToString(sum[:]) || result.Size != int64(size) {
+			t.Errorf("size %d: got hash %s size %d, want plaintext hash and size", size, result.Hash, result.Size)
+		}
+
+		raw := read(inner.Open(ctx, result.Path))
+		if size > 16 && bytes.Contains(raw, content[:16]) {
+			t.Errorf("size %d: plaintext found in stored object", size)
+		}
+
+		if got := read(backend.Open(ctx, result.Path)); !bytes.Equal(got, content) {
+			t.Errorf("size %d: Open returned different content", size)
+		}
+		info, err := backend.Stat(ctx, result.Path)
+		if err != nil {
+			t.Fatalf("size %d: Stat failed: %v", size, err)
+		}
+		if info.Size != int64(size) {
+			t.Errorf("size %d: Stat size = %d", size, info.Size)
+		}
+	}
+}
+
+func TestEncryptedBackend_OpenRange(t *testing.T) {
+	testOpenRange(t, newTestEncryptedBackend(t, NewMemoryBackend(), "k1:"+testKey(t), ""))
+
+	disk, err := NewDiskBackend(filepath.Join(t.TempDir(), "storage"))
+	if err != nil {
+		t.Fatalf("NewDiskBackend failed: %v", err)
+	}
+	defer disk.Close() //nolint:errcheck
+	testOpenRange(t, newTestEncryptedBackend(t, disk, "k1:"+testKey(t), ""))
+}
+
+func TestEncryptedBackend_OpenRange_AcrossChunks(t *testing.T) {
+	ctx := context.Background()
+	read := reader(t)
+	backend := newTestEncryptedBackend(t, NewMemoryBackend(), "k1:"+testKey(t), "")
+
+	content := make([]byte, 3*encChunkSize+100)
+	_, _ = rand.Read(content)
+	result, err := backend.Save(ctx, bytes.NewReader(content), SaveOptions{})
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	ranges := []struct{ offset, length int64 }{
+		{0, 10},
+		{encChunkSize - 5, 10},                  // Straddles a boundary
+		{encChunkSize, encChunkSize},            // Exactly one chunk
+		{10, 2*encChunkSize + 50},               // Several chunks
+		{3 * encChunkSize, 100},                 // The short final chunk
+		{3*encChunkSize + 90, 1000},             // Clamped at the end
+		{int64(len(content)) - 1, encChunkSize}, // Last byte
+	}
+	for _, r := range ranges {
+		got := read(backend.OpenRange(ctx, result.Path, r.offset, r.length))
+		end := min(r.offset+r.length, int64(len(content)))
+		if !bytes.Equal(got, content[r.offset:end]) {
+			t.Errorf("range %d+%d: got %d bytes, want %d", r.offset, r.length, len(got), end-r.offset)
+		}
+	}
+}
+
+func TestEncryptedBackend_DetectsTampering(t *testing.T) {
+	ctx := context.Background()
+	read := reader(t)
+	inner := NewMemoryBackend()
+	backend := newTestEncryptedBackend(t, inner, "k1:"+testKey(t), "")
+
+	content := bytes.Repeat([]byte("x"), 2*encChunkSize+10)
+	result, err := backend.Save(ctx, bytes.NewReader(content), SaveOptions{})
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	raw := read(inner.Open(ctx, result.Path))
+
+	store := func(data []byte) string {
+		res, err := inner.Save(ctx, bytes.NewReader(data), SaveOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.Path
+	}
+	flipped := bytes.Clone(raw)
+	flipped[encHeaderSize+encSealedChunk+3] ^= 1
+	truncated := raw[:encHeaderSize+2*encSealedChunk] // Final chunk dropped
+	swapped := bytes.Clone(raw)
+	copy(swapped[encHeaderSize:], raw[encHeaderSize+encSealedChunk:encHeaderSize+2*encSealedChunk])
+	copy(swapped[encHeaderSize+encSealedChunk:], raw[encHeaderSize:encHeaderSize+encSealedChunk])
+
+	for name, path := range map[string]string{"flipped": store(flipped), "truncated": store(truncated), "swapped": store(swapped)} {
+		r, err := backend.Open(ctx, path)
+		if err == nil {
+			_, err = io.ReadAll(r)
+			_ = r.Close()
+		}
+		if !errors.Is(err, ErrDecrypt) {
+			t.Errorf("%s: expected ErrDecrypt, got %v", name, err)
+		}
+	}
+}
+
+func TestEncryptedBackend_KeyRotation(t *testing.T) {
+	ctx := context.Background()
+	read := reader(t)
+	inner := NewMemoryBackend()
+	oldKey, newKey := testKey(t), testKey(t)
+	before := newTestEncryptedBackend(t, inner, "old:"+oldKey, "")
+
+	content := bytes.Repeat([]byte("rotate me "), 20000)
+	result, err := before.Save(ctx, bytes.NewReader(content), SaveOptions{OriginalFilename: "doc.txt"})
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// After adding a new active key, old objects still read
+	after := newTestEncryptedBackend(t, inner, "old:"+oldKey+",new:"+newKey, "new")
+	if got := read(after.Open(ctx, result.Path)); !bytes.Equal(got, content) {
+		t.Fatal("old object unreadable after adding a key")
+	}
+	if id, _ := after.KeyID(ctx, result.Path); id != "old" {
+		t.Errorf("KeyID = %q, want old", id)
+	}
+
+	newPath, err := after.Rewrap(ctx, result.Path)
+	if err != nil {
+		t.Fatalf("Rewrap failed: %v", err)
+	}
+	if newPath == result.Path || filepath.Ext(newPath) != ".txt" {
+		t.Errorf("unexpected rewrapped path %q", newPath)
+	}
+	if id, _ := after.KeyID(ctx, newPath); id != "new" {
+		t.Errorf("KeyID after rewrap = %q, want new", id)
+	}
+	if again, err := after.Rewrap(ctx, newPath); err != nil || again != newPath {
+		t.Errorf("Rewrap of current object = %q, %v; want unchanged", again, err)
+	}
+
+	// Once rewrapped, the old key can be removed
+	rotated := newTestEncryptedBackend(t, inner, "new:"+newKey, "")
+	if got := read(rotated.Open(ctx, newPath)); !bytes.Equal(got, content) {
+		t.Error("rewrapped object unreadable with only the new key")
+	}
+	if _, err := rotated.Open(ctx, result.Path); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("expected ErrDecrypt for object wrapped with a removed key, got %v", err)
+	}
+}
+
+func TestEncryptedBackend_PlaintextObjects(t *testing.T) {
+	ctx := context.Background()
+	read := reader(t)
+	inner := NewMemoryBackend()
+	content := "stored before encryption was enabled"
+	legacy, err := inner.Save(ctx, strings.NewReader(content), SaveOptions{OriginalFilename: "old.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := newTestEncryptedBackend(t, inner, "k1:"+testKey(t), "")
+
+	if got := read(backend.Open(ctx, legacy.Path)); string(got) != content {
+		t.Errorf("Open of plaintext object = %q", got)
+	}
+	if got := read(backend.OpenRange(ctx, legacy.Path, 7, 6)); string(got) != "before" {
+		t.Errorf("OpenRange of plaintext object = %q", got)
+	}
+	if info, err := backend.Stat(ctx, legacy.Path); err != nil || info.Size != int64(len(content)) {
+		t.Errorf("Stat of plaintext object = %d, %v", info.Size, err)
+	}
+
+	newPath, err := backend.Rewrap(ctx, legacy.Path)
+	if err != nil {
+		t.Fatalf("Rewrap failed: %v", err)
+	}
+	if id, _ := backend.KeyID(ctx, newPath); id != "k1" {
+		t.Errorf("expected plaintext object to be encrypted, key ID %q", id)
+	}
+	if got := read(backend.Open(ctx, newPath)); string(got) != content {
+		t.Errorf("Open after encrypting = %q", got)
+	}
+}
+
+func TestEncryptedBackend_RequiredRefusesPlaintext(t *testing.T) {
+	ctx := context.Background()
+	read := reader(t)
+	inner := NewMemoryBackend()
+	legacy, err := inner.Save(ctx, strings.NewReader("stored before encryption was enabled"), SaveOptions{OriginalFilename: "old.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	short, err := inner.Save(ctx, strings.NewReader("tiny"), SaveOptions{OriginalFilename: "tiny.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := newTestEncryptedBackend(t, inner, "k1:"+testKey(t), "")
+	encrypted, err := backend.Save(ctx, strings.NewReader("new"), SaveOptions{OriginalFilename: "new.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.SetRequired(true)
+
+	for _, path := range []string{legacy.Path, short.Path} {
+		if _, err := backend.Open(ctx, path); !errors.Is(err, ErrNotEncrypted) {
+			t.Errorf("Open of plaintext object: expected ErrNotEncrypted, got %v", err)
+		}
+		if _, err := backend.OpenRange(ctx, path, 0, 2); !errors.Is(err, ErrNotEncrypted) {
+			t.Errorf("OpenRange of plaintext object: expected ErrNotEncrypted, got %v", err)
+		}
+		if _, err := backend.Stat(ctx, path); !errors.Is(err, ErrNotEncrypted) {
+			t.Errorf("Stat of plaintext object: expected ErrNotEncrypted, got %v", err)
+		}
+	}
+	if got := read(backend.Open(ctx, encrypted.Path)); string(got) != "new" {
+		t.Errorf("Open of encrypted object = %q", got)
+	}
+
+	// Rewrap still encrypts the objects that are refused
+	newPath, err := backend.Rewrap(ctx, legacy.Path)
+	if err != nil {
+		t.Fatalf("Rewrap failed: %v", err)
+	}
+	if got := read(backend.Open(ctx, newPath)); string(got) != "stored before encryption was enabled" {
+		t.Errorf("Open after encrypting = %q", got)
+	}
+}
+
+func TestEncryptedBackend_ValidateAccess(t *testing.T) {
+	inner := NewMemoryBackend()
+	backend := newTestEncryptedBackend(t, inner, "k1:"+testKey(t), "")
+	if err := backend.ValidateAccess(context.Background()); err != nil {
+		t.Fatalf("ValidateAccess failed: %v", err)
+	}
+	if inner.FileCount() != 0 {
+		t.Errorf("expected test object to be removed, %d files left", inner.FileCount())
+	}
+}
+
+func TestEncryptedBackend_InterfaceCompliance(t *testing.T) {
+	var _ StorageBackend = (*EncryptedBackend)(nil)
+}
+
+func TestParseKeyring(t *testing.T) {
+	k1, k2 := testKey(t), testKey(t)
+
+	kr, err := ParseKeyring(" a:"+k1+" , b:"+k2, "")
+	if err != nil {
+		t.Fatalf("ParseKeyring failed: %v", err)
+	}
+	if kr.ActiveKeyID() != "a" || strings.Join(kr.KeyIDs(), ",") != "a,b" {
+		t.Errorf("got active %q keys %v", kr.ActiveKeyID(), kr.KeyIDs())
+	}
+	if kr, err := ParseKeyring("a:"+k1+",b:"+k2, "b"); err != nil || kr.ActiveKeyID() != "b" {
+		t.Errorf("explicit active key: %v", err)
+	}
+
+	invalid := map[string][2]string{
+		"empty":          {"", ""},
+		"no separator":   {k1, ""},
+		"short key":      {"a:" + base64.StdEncoding.EncodeToString([]byte("short")), ""},
+		"bad base64":     {"a:not-base64!", ""},
+		"bad id":         {"a b:" + k1, ""},
+		"long id":        {strings.Repeat("x", 32) + ":" + k1, ""},
+		"duplicate":      {"a:" + k1 + ",a:" + k2, ""},
+		"unknown active": {"a:" + k1, "b"},
+	}
+	for name, args := range invalid {
+		if _, err := ParseKeyring(args[0], args[1]); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/agjmills/trove/internal/config"
 )
@@ -11,18 +12,116 @@ import (
 //   - "disk": Local filesystem storage (default)
 //   - "memory": In-memory storage for testing
 //   - "s3": AWS S3 or compatible storage (e.g., rustfs, MinIO)
+//
+// When STORAGE_CACHE_DIR is set reads from S3 are cached on local disk. When
+// STORAGE_MIRRORS is set the backend is mirrored to the listed
+// backends. When STORAGE_POLICIES is set, that backend is the default of a
+// PolicyBackend holding the listed policies as well. When ENCRYPTION_KEYS
+// is set the result is wrapped in an EncryptedBackend, so objects are
+// encrypted once and mirrored as stored.
 func NewBackendFromConfig(cfg *config.Config) (StorageBackend, error) {
+	backend, err := newBaseBackend(cfg)
+	if err == nil && cfg.StorageCacheDir != "" {
+		backend, err = NewCachedBackend(backend, cfg.StorageCacheDir, cfg.StorageCacheSize)
+	}
+	if err == nil && len(cfg.StorageMirrors) > 0 {
+		backend, err = newMirroredBackend(cfg, backend)
+	}
+	if err == nil && len(cfg.StoragePolicies) > 0 {
+		backend, err = newPolicyBackend(cfg, backend)
+	}
+	if err != nil || cfg.EncryptionKeys == "" {
+		return backend, err
+	}
+	keys, err := ParseKeyring(cfg.EncryptionKeys, cfg.EncryptionActiveKey)
+	if err != nil {
+		return nil, err
+	}
+	encrypted := NewEncryptedBackend(backend, keys)
+	encrypted.SetRequired(cfg.EncryptionRequired)
+	return encrypted, nil
+}
+
+func newBaseBackend(cfg *config.Config) (StorageBackend, error) {
 	switch cfg.StorageBackend {
 	case "disk", "":
 		return NewDiskBackend(cfg.StoragePath)
 	case "memory":
 		return NewMemoryBackend(), nil
 	case "s3":
-		return NewS3Backend(S3Config{
+		s3Cfg := S3Config{
 			Bucket:       cfg.S3Bucket,
 			UsePathStyle: cfg.S3UsePathStyle,
-		})
+			PartSize:     cfg.S3PartSize,
+			Concurrency:  cfg.S3Concurrency,
+		}
+		if cfg.S3PresignedDownloads {
+			s3Cfg.PresignExpiry = cfg.S3PresignExpiry
+		}
+		return NewS3Backend(s3Cfg)
 	default:
 		return nil, fmt.Errorf("unknown storage backend: %s (supported: disk, memory, s3)", cfg.StorageBackend)
 	}
 }
+
+// newMirroredBackend mirrors primary to the backends in STORAGE_MIRRORS.
+func newMirroredBackend(cfg *config.Config, primary StorageBackend) (StorageBackend, error) {
+	var secondaries []Member
+	for _, spec := range cfg.StorageMirrors {
+		backend, err := newBackendFromSpec(cfg, spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid storage mirror: %w", err)
+		}
+		secondaries = append(secondaries, Member{Name: spec, Backend: backend})
+	}
+	return NewMirroredBackend(primary, secondaries...)
+}
+
+// newPolicyBackend adds the storage policies in STORAGE_POLICIES, each
+// written "<name>=<backend>", to def.
+func newPolicyBackend(cfg *config.Config, def StorageBackend) (StorageBackend, error) {
+	var policies []Member
+	for _, entry := range cfg.StoragePolicies {
+		name, spec, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid storage policy %q: expected <name>=<backend>", entry)
+		}
+		backend, err := newBackendFromSpec(cfg, spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid storage policy %s: %w", name, err)
+		}
+		policies = append(policies, Member{Name: name, Backend: backend})
+	}
+	return NewPolicyBackend(def, policies...)
+}
+
+// newBackendFromSpec creates the backend described by spec, written
+// "disk:<path>", "s3:<bucket>" or "memory:<name>". S3 backends use the same
+// credentials, endpoint, addressing style and upload settings as the
+// primary.
+func newBackendFromSpec(cfg *config.Config, spec string) (StorageBackend, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+	if arg == "" {
+		return nil, fmt.Errorf("%q: expected disk:<path>, s3:<bucket> or memory:<name>", spec)
+	}
+	var backend StorageBackend
+	var err error
+	switch kind {
+	case "disk":
+		backend, err = NewDiskBackend(arg)
+	case "s3":
+		s3Cfg := S3Config{Bucket: arg, UsePathStyle: cfg.S3UsePathStyle, PartSize: cfg.S3PartSize, Concurrency: cfg.S3Concurrency}
+		if cfg.S3PresignedDownloads {
+			s3Cfg.PresignExpiry = cfg.S3PresignExpiry
+		}
+		backend, err = NewS3Backend(s3Cfg)
+	case "memory":
+		backend = NewMemoryBackend()
+	default:
+		return nil, fmt.Errorf("unknown backend %q in %q (supported: disk, s3, memory)", kind, spec)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize %s: %w", spec, err)
+	}
+	return backend, nil
+}
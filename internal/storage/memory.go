@@ -21,14 +21,16 @@ import (
 // Useful for integration testing without disk I/O.
 // Thread-safe for concurrent use.
 type MemoryBackend struct {
-	fs *memoryfs.FS
-	mu sync.RWMutex // Protects fs operations
+	fs      *memoryfs.FS
+	uploads map[string]map[int][]byte // Parts of multipart uploads, by upload ID
+	mu      sync.RWMutex              // Protects fs operations and uploads
 }
 
 // NewMemoryBackend creates a new in-memory storage backend.
 func NewMemoryBackend() *MemoryBackend {
 	return &MemoryBackend{
-		fs: memoryfs.New(),
+		fs:      memoryfs.New(),
+		uploads: map[string]map[int][]byte{},
 	}
 }
 
@@ -37,7 +39,11 @@ func (m *MemoryBackend) Save(ctx context.Context, r io.Reader, opts SaveOptions)
 	// Generate unique filename
 	ext := filepath.Ext(opts.OriginalFilename)
 	filename := uuid.New().String() + ext
+	return m.Put(ctx, filename, r, opts)
+}
 
+// Put stores content at path, replacing any file there.
+func (m *MemoryBackend) Put(ctx context.Context, path string, r io.Reader, opts SaveOptions) (SaveResult, error) {
 	// Stream content into buffer while computing hash (avoids reading entire payload upfront)
 	// memoryfs.WriteFile requires complete content, so we still need to buffer,
 	// but we use io.CopyBuffer with the shared copyBufferSize for consistency
@@ -53,19 +59,76 @@ func (m *MemoryBackend) Save(ctx context.Context, r io.Reader, opts SaveOptions)
 
 	// Write to memoryfs
 	m.mu.Lock()
-	err = m.fs.WriteFile(filename, buf.Bytes(), 0644)
+	err = m.fs.WriteFile(path, buf.Bytes(), 0644)
 	m.mu.Unlock()
 	if err != nil {
 		return SaveResult{}, fmt.Errorf("failed to write file: %w", err)
 	}
 
 	return SaveResult{
-		Path: filename,
+		Path: path,
 		Hash: hex.EncodeToString(hasher.Sum(nil)),
 		Size: size,
 	}, nil
 }
 
+// InitMultipart starts a multipart upload. The upload ID is the path the
+// object will have.
+func (m *MemoryBackend) InitMultipart(ctx context.Context, partSize int64, opts SaveOptions) (string, error) {
+	id := uuid.New().String() + filepath.Ext(opts.OriginalFilename)
+	m.mu.Lock()
+	m.uploads[id] = map[int][]byte{}
+	m.mu.Unlock()
+	return id, nil
+}
+
+// PutPart stores a part of a multipart upload.
+func (m *MemoryBackend) PutPart(ctx context.Context, uploadID string, number int, r io.Reader, size int64) error {
+	data, err := readPart(r, number, size)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	parts, ok := m.uploads[uploadID]
+	if !ok {
+		return ErrNotFound
+	}
+	parts[number] = data
+	return nil
+}
+
+// CompleteMultipart joins the parts of a multipart upload into a file.
+func (m *MemoryBackend) CompleteMultipart(ctx context.Context, uploadID string, parts int) (SaveResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	uploaded, ok := m.uploads[uploadID]
+	if !ok {
+		return SaveResult{}, ErrNotFound
+	}
+	var buf bytes.Buffer
+	for number := 1; number <= parts; number++ {
+		data, ok := uploaded[number]
+		if !ok {
+			return SaveResult{}, fmt.Errorf("part %d was not uploaded", number)
+		}
+		buf.Write(data)
+	}
+	if err := m.fs.WriteFile(uploadID, buf.Bytes(), 0644); err != nil {
+		return SaveResult{}, fmt.Errorf("failed to write file: %w", err)
+	}
+	delete(m.uploads, uploadID)
+	return SaveResult{Path: uploadID, Size: int64(buf.Len())}, nil
+}
+
+// AbortMultipart discards a multipart upload.
+func (m *MemoryBackend) AbortMultipart(ctx context.Context, uploadID string) error {
+	m.mu.Lock()
+	delete(m.uploads, uploadID)
+	m.mu.Unlock()
+	return nil
+}
+
 // Open returns a reader for the file at the given path.
 func (m *MemoryBackend) Open(ctx context.Context, path string) (io.ReadCloser, error) {
 	m.mu.RLock()
@@ -138,6 +201,33 @@ func (m *MemoryBackend) Stat(ctx context.Context, path string) (FileInfo, error)
 	}, nil
 }
 
+// List calls fn for every stored file.
+func (m *MemoryBackend) List(ctx context.Context, fn func(FileInfo) error) error {
+	m.mu.RLock()
+	entries, err := m.fs.ReadDir(".")
+	var infos []FileInfo
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			infos = append(infos, FileInfo{Path: entry.Name(), Size: info.Size(), ModTime: info.ModTime()})
+		}
+	}
+	m.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to list files: %w", err)
+	}
+
+	// Call fn without the lock held so it can use the backend
+	for _, info := range infos {
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // HealthCheck verifies the backend is reachable.
 // For memory backend, always returns nil (no external dependencies).
 func (m *MemoryBackend) HealthCheck(ctx context.Context) error {
@@ -155,6 +245,7 @@ func (m *MemoryBackend) ValidateAccess(ctx context.Context) error {
 func (m *MemoryBackend) Clear() {
 	m.mu.Lock()
 	m.fs = memoryfs.New()
+	m.uploads = map[string]map[int][]byte{}
 	m.mu.Unlock()
 }
 
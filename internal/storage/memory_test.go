@@ -256,6 +256,43 @@ func TestMemoryBackend_ValidateAccess(t *testing.T) {
 func TestMemoryBackend_InterfaceCompliance(t *testing.T) {
 	// This test ensures MemoryBackend implements StorageBackend interface
 	var _ StorageBackend = (*MemoryBackend)(nil)
+	var _ Lister = (*MemoryBackend)(nil)
+	var _ Putter = (*MemoryBackend)(nil)
+}
+
+func TestMemoryBackend_List(t *testing.T) {
+	backend := NewMemoryBackend()
+	ctx := context.Background()
+
+	want := map[string]int64{}
+	for _, content := range []string{"a", "bb", "ccc"} {
+		result, err := backend.Save(ctx, strings.NewReader(content), SaveOptions{OriginalFilename: "f.txt"})
+		if err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+		want[result.Path] = result.Size
+	}
+
+	// fn may use the backend while listing
+	got := map[string]int64{}
+	err := backend.List(ctx, func(info FileInfo) error {
+		got[info.Path] = info.Size
+		return backend.Delete(ctx, info.Path)
+	})
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("List returned %v, want %v", got, want)
+	}
+	for path, size := range want {
+		if got[path] != size {
+			t.Errorf("%s: size %d, want %d", path, got[path], size)
+		}
+	}
+	if backend.FileCount() != 0 {
+		t.Errorf("expected all files deleted, %d left", backend.FileCount())
+	}
 }
 
 func TestMemoryBackend_Save_MultipleConcurrent(t *testing.T) {
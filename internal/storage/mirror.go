@@ -0,0 +1,384 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// PrimaryMember is the name of a MirroredBackend's primary in ReplicaLog
+// events.
+const PrimaryMember = "primary"
+
+// Putter is implemented by backends that can store an object at a path the
+// caller chooses instead of a generated one, replacing any object there.
+// MirroredBackend needs it to keep every copy of an object at the same path.
+type Putter interface {
+	Put(ctx context.Context, path string, r io.Reader, opts SaveOptions) (SaveResult, error)
+}
+
+// Member is one backend of a MirroredBackend.
+type Member struct {
+	Name    string // Identifies the member in ReplicaLog events, e.g. "s3:backup-bucket"
+	Backend StorageBackend
+}
+
+// ReplicaLog receives a MirroredBackend's replication events so they can be
+// recorded; see package mirror. Implementations handle their own errors.
+type ReplicaLog interface {
+	// Missing records that member lacks a complete copy of path: writing it
+	// failed, or a read found it missing.
+	Missing(path, member string, err error)
+	// Replicated records that member holds a complete copy of path.
+	Replicated(path, member string)
+	// Failover records that a read of path was served by member because
+	// the members before it failed, the first with err.
+	Failover(path, member string, err error)
+	// Deleted records that path was deleted from every member.
+	Deleted(path string)
+}
+
+type nopReplicaLog struct{}
+
+func (nopReplicaLog) Missing(string, string, error)  {}
+func (nopReplicaLog) Replicated(string, string)      {}
+func (nopReplicaLog) Failover(string, string, error) {}
+func (nopReplicaLog) Deleted(string)                 {}
+
+// MirroredBackend keeps a copy of every object in a primary backend and one
+// or more secondaries, at the same path in each. Reads are served by the
+// primary and fail over to the secondaries in order.
+//
+// Save succeeds once the primary has the object; copying it to the
+// secondaries then reads it back from the primary, and a failed copy is
+// reported to the ReplicaLog rather than failing the upload. Repair copies
+// an object to whichever members lack it.
+type MirroredBackend struct {
+	members []Member // Primary first
+	log     ReplicaLog
+}
+
+// NewMirroredBackend returns a backend mirroring primary to secondaries,
+// which must implement Putter.
+func NewMirroredBackend(primary StorageBackend, secondaries ...Member) (*MirroredBackend, error) {
+	if len(secondaries) == 0 {
+		return nil, errors.New("mirrored storage needs at least one secondary")
+	}
+	members := []Member{{Name: PrimaryMember, Backend: primary}}
+	for _, s := range secondaries {
+		if _, ok := s.Backend.(Putter); !ok {
+			return nil, fmt.Errorf("storage mirror %s cannot store objects at a given path", s.Name)
+		}
+		members = append(members, s)
+	}
+	if _, ok := primary.(Putter); !ok {
+		return nil, errors.New("primary storage cannot store objects at a given path, so it cannot be repaired")
+	}
+	return &MirroredBackend{members: members, log: nopReplicaLog{}}, nil
+}
+
+// AsMirrored returns the MirroredBackend that b is or, for an
+// EncryptedBackend or a PolicyBackend, wraps as its default backend.
+func AsMirrored(b StorageBackend) (*MirroredBackend, bool) {
+	if p, ok := asPolicyBackend(b); ok {
+		b = p.def
+	}
+	m, ok := b.(*MirroredBackend)
+	return m, ok
+}
+
+// SetReplicaLog sets the log that receives replication events. Call it
+// before the backend is used.
+func (m *MirroredBackend) SetReplicaLog(log ReplicaLog) {
+	m.log = log
+}
+
+// Members returns the primary followed by the secondaries.
+func (m *MirroredBackend) Members() []Member {
+	return m.members
+}
+
+// Save stores content in the primary, then copies it to each secondary.
+func (m *MirroredBackend) Save(ctx context.Context, r io.Reader, opts SaveOptions) (SaveResult, error) {
+	result, err := m.members[0].Backend.Save(ctx, r, opts)
+	if err != nil {
+		return SaveResult{}, err
+	}
+	m.replicate(ctx, result.Path)
+	return result, nil
+}
+
+// InitMultipart starts a multipart upload in the primary.
+func (m *MirroredBackend) InitMultipart(ctx context.Context, partSize int64, opts SaveOptions) (string, error) {
+	uploader, err := asMultipart(m.members[0].Backend)
+	if err != nil {
+		return "", err
+	}
+	return uploader.InitMultipart(ctx, partSize, opts)
+}
+
+// PutPart stores a part in the primary.
+func (m *MirroredBackend) PutPart(ctx context.Context, uploadID string, number int, r io.Reader, size int64) error {
+	uploader, err := asMultipart(m.members[0].Backend)
+	if err != nil {
+		return err
+	}
+	return uploader.PutPart(ctx, uploadID, number, r, size)
+}
+
+// CompleteMultipart completes a multipart upload in the primary, then
+// copies the object to each secondary as Save does.
+func (m *MirroredBackend) CompleteMultipart(ctx context.Context, uploadID string, parts int) (SaveResult, error) {
+	uploader, err := asMultipart(m.members[0].Backend)
+	if err != nil {
+		return SaveResult{}, err
+	}
+	result, err := uploader.CompleteMultipart(ctx, uploadID, parts)
+	if err != nil {
+		return SaveResult{}, err
+	}
+	m.replicate(ctx, result.Path)
+	return result, nil
+}
+
+// AbortMultipart aborts a multipart upload in the primary.
+func (m *MirroredBackend) AbortMultipart(ctx context.Context, uploadID string) error {
+	uploader, err := asMultipart(m.members[0].Backend)
+	if err != nil {
+		return err
+	}
+	return uploader.AbortMultipart(ctx, uploadID)
+}
+
+// replicate copies a new object from the primary to each secondary.
+func (m *MirroredBackend) replicate(ctx context.Context, path string) {
+	for _, member := range m.members[1:] {
+		if err := copyObject(ctx, path, m.members[0].Backend, member.Backend); err != nil {
+			m.log.Missing(path, member.Name, err)
+		}
+	}
+}
+
+// Open opens the object in the first member that can serve it.
+func (m *MirroredBackend) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	return readMembers(m, path, func(b StorageBackend) (io.ReadCloser, error) {
+		return b.Open(ctx, path)
+	})
+}
+
+// OpenRange opens a byte range in the first member that can serve it.
+func (m *MirroredBackend) OpenRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	return readMembers(m, path, func(b StorageBackend) (io.ReadCloser, error) {
+		return b.OpenRange(ctx, path, offset, length)
+	})
+}
+
+// Stat returns the object's metadata from the first member that has it.
+func (m *MirroredBackend) Stat(ctx context.Context, path string) (FileInfo, error) {
+	return readMembers(m, path, func(b StorageBackend) (FileInfo, error) {
+		return b.Stat(ctx, path)
+	})
+}
+
+// Delete removes the object from every member, attempting all of them even
+// if one fails.
+func (m *MirroredBackend) Delete(ctx context.Context, path string) error {
+	var errs []error
+	for _, member := range m.members {
+		if err := member.Backend.Delete(ctx, path); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", member.Name, err))
+		}
+	}
+	m.log.Deleted(path)
+	return errors.Join(errs...)
+}
+
+// List calls fn once for every object in any member, with the metadata of
+// the first member that has it. Every member must support listing.
+func (m *MirroredBackend) List(ctx context.Context, fn func(FileInfo) error) error {
+	seen := make(map[string]bool)
+	for _, member := range m.members {
+		lister, ok := member.Backend.(Lister)
+		if !ok {
+			return ErrListUnsupported
+		}
+		err := lister.List(ctx, func(info FileInfo) error {
+			if seen[info.Path] {
+				return nil
+			}
+			seen[info.Path] = true
+			return fn(info)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PresignGet presigns a download from the primary, once it has checked the
+// primary holds the object; otherwise the caller should read it through
+// the mirror, which fails over.
+func (m *MirroredBackend) PresignGet(ctx context.Context, path string, opts PresignOptions) (string, error) {
+	primary := m.members[0].Backend
+	presigner, err := asPresigner(primary)
+	if err != nil {
+		return "", err
+	}
+	if _, err := primary.Stat(ctx, path); err != nil {
+		return "", err
+	}
+	return presigner.PresignGet(ctx, path, opts)
+}
+
+// MigrateLayout migrates every member, attempting all of them even if one
+// fails.
+func (m *MirroredBackend) MigrateLayout(ctx context.Context) (int, error) {
+	total := 0
+	var errs []error
+	for _, member := range m.members {
+		n, err := MigrateLayout(ctx, member.Backend)
+		total += n
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", member.Name, err))
+		}
+	}
+	return total, errors.Join(errs...)
+}
+
+// AbortStaleUploads sweeps every member, attempting all of them even if one
+// fails.
+func (m *MirroredBackend) AbortStaleUploads(ctx context.Context, cutoff time.Time) (int, error) {
+	total := 0
+	var errs []error
+	for _, member := range m.members {
+		n, err := abortStaleUploads(ctx, member.Backend, cutoff)
+		total += n
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", member.Name, err))
+		}
+	}
+	return total, errors.Join(errs...)
+}
+
+// HealthCheck checks the primary. Reads fail over and missed copies are
+// repaired, so an unreachable secondary does not make Trove unhealthy.
+func (m *MirroredBackend) HealthCheck(ctx context.Context) error {
+	return m.members[0].Backend.HealthCheck(ctx)
+}
+
+// ValidateAccess validates every member.
+func (m *MirroredBackend) ValidateAccess(ctx context.Context) error {
+	for _, member := range m.members {
+		if err := member.Backend.ValidateAccess(ctx); err != nil {
+			return fmt.Errorf("storage mirror %s: %w", member.Name, err)
+		}
+	}
+	return nil
+}
+
+// Repair copies the object at path to every member that lacks it or holds a
+// copy shorter than the longest one, and returns the names of the members
+// it copied to. Members that cannot be reached are skipped and reported in
+// the error. It returns ErrNotFound if no reachable member has the object.
+func (m *MirroredBackend) Repair(ctx context.Context, path string) ([]string, error) {
+	infos := make([]FileInfo, len(m.members))
+	present := make([]bool, len(m.members))
+	unreachable := make([]bool, len(m.members))
+	source := -1
+	var errs []error
+	for i, member := range m.members {
+		info, err := member.Backend.Stat(ctx, path)
+		switch {
+		case err == nil:
+			infos[i], present[i] = info, true
+			if source < 0 || info.Size > infos[source].Size {
+				source = i
+			}
+		case !errors.Is(err, ErrNotFound):
+			unreachable[i] = true
+			errs = append(errs, fmt.Errorf("%s: %w", member.Name, err))
+		}
+	}
+	if source < 0 {
+		if len(errs) > 0 {
+			return nil, errors.Join(errs...)
+		}
+		return nil, ErrNotFound
+	}
+
+	var copied []string
+	for i, member := range m.members {
+		if unreachable[i] {
+			continue
+		}
+		if present[i] && infos[i].Size == infos[source].Size {
+			m.log.Replicated(path, member.Name)
+			continue
+		}
+		if err := copyObject(ctx, path, m.members[source].Backend, member.Backend); err != nil {
+			m.log.Missing(path, member.Name, err)
+			errs = append(errs, fmt.Errorf("%s: %w", member.Name, err))
+			continue
+		}
+		m.log.Replicated(path, member.Name)
+		copied = append(copied, member.Name)
+	}
+	return copied, errors.Join(errs...)
+}
+
+// copyObject copies the object at path from src to the same path in dst.
+func copyObject(ctx context.Context, path string, src, dst StorageBackend) error {
+	info, err := src.Stat(ctx, path)
+	if err != nil {
+		return err
+	}
+	body, err := src.Open(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer body.Close() //nolint:errcheck
+
+	result, err := dst.(Putter).Put(ctx, path, body, SaveOptions{OriginalFilename: path, ContentType: info.ContentType})
+	if err != nil {
+		return err
+	}
+	if result.Size != info.Size {
+		return fmt.Errorf("copied %d of %d bytes", result.Size, info.Size)
+	}
+	return nil
+}
+
+// readMembers calls read on each member in turn and returns the first
+// success. Members before it that did not have the object are reported
+// missing. If every member fails, the most informative error is returned:
+// ErrNotFound only if no member had a different problem.
+func readMembers[T any](m *MirroredBackend, path string, read func(StorageBackend) (T, error)) (T, error) {
+	var firstErr, result error
+	var notFound []string
+	for i, member := range m.members {
+		v, err := read(member.Backend)
+		if err == nil {
+			if i > 0 {
+				m.log.Failover(path, member.Name, firstErr)
+				for _, name := range notFound {
+					m.log.Missing(path, name, ErrNotFound)
+				}
+			}
+			return v, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if errors.Is(err, ErrNotFound) {
+			notFound = append(notFound, member.Name)
+		}
+		if result == nil || errors.Is(result, ErrNotFound) {
+			result = err
+		}
+	}
+	var zero T
+	return zero, result
+}
@@ -0,0 +1,267 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/agjmills/trove/internal/config"
+)
+
+// recordingLog collects a MirroredBackend's replication events.
+type recordingLog struct {
+	missing    []string // "member:path"
+	replicated []string
+	failovers  []string
+	deleted    []string
+}
+
+func (l *recordingLog) Missing(path, member string, err error) {
+	l.missing = append(l.missing, member+":"+path)
+}
+func (l *recordingLog) Replicated(path, member string) {
+	l.replicated = append(l.replicated, member+":"+path)
+}
+func (l *recordingLog) Failover(path, member string, err error) {
+	l.failovers = append(l.failovers, member+":"+path)
+}
+func (l *recordingLog) Deleted(path string) { l.deleted = append(l.deleted, path) }
+
+// failingBackend fails every operation as an unreachable backend would.
+type failingBackend struct {
+	*MemoryBackend
+}
+
+var errUnreachable = errors.New("unreachable")
+
+func (failingBackend) Put(context.Context, string, io.Reader, SaveOptions) (SaveResult, error) {
+	return SaveResult{}, errUnreachable
+}
+func (failingBackend) Open(context.Context, string) (io.ReadCloser, error) {
+	return nil, errUnreachable
+}
+func (failingBackend) Stat(context.Context, string) (FileInfo, error) {
+	return FileInfo{}, errUnreachable
+}
+
+func newTestMirror(t *testing.T, secondaries ...StorageBackend) (*MirroredBackend, *MemoryBackend, *recordingLog) {
+	t.Helper()
+	primary := NewMemoryBackend()
+	var members []Member
+	for i, b := range secondaries {
+		members = append(members, Member{Name: "mirror" + string(rune('1'+i)), Backend: b})
+	}
+	m, err := NewMirroredBackend(primary, members...)
+	if err != nil {
+		t.Fatalf("NewMirroredBackend failed: %v", err)
+	}
+	log := &recordingLog{}
+	m.SetReplicaLog(log)
+	return m, primary, log
+}
+
+func readAll(t *testing.T, b StorageBackend, path string) string {
+	t.Helper()
+	r, err := b.Open(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer r.Close() //nolint:errcheck
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	return string(data)
+}
+
+func TestMirroredBackend_InterfaceCompliance(t *testing.T) {
+	var _ StorageBackend = (*MirroredBackend)(nil)
+	var _ Lister = (*MirroredBackend)(nil)
+}
+
+func TestNewMirroredBackend_RequiresPutter(t *testing.T) {
+	if _, err := NewMirroredBackend(NewMemoryBackend()); err == nil {
+		t.Error("expected an error without secondaries")
+	}
+	noPut := struct{ StorageBackend }{NewMemoryBackend()}
+	if _, err := NewMirroredBackend(NewMemoryBackend(), Member{Name: "x", Backend: noPut}); err == nil {
+		t.Error("expected an error for a secondary without Put")
+	}
+}
+
+func TestNewBackendFromConfig_Mirrors(t *testing.T) {
+	backend, err := NewBackendFromConfig(&config.Config{StorageBackend: "memory", StorageMirrors: []string{"memory:backup"}})
+	if err != nil {
+		t.Fatalf("NewBackendFromConfig failed: %v", err)
+	}
+	m, ok := AsMirrored(backend)
+	if !ok || len(m.Members()) != 2 || m.Members()[1].Name != "memory:backup" {
+		t.Errorf("expected a mirror with one secondary, got %T", backend)
+	}
+
+	for _, spec := range []string{"ftp:host", "disk"} {
+		if _, err := NewBackendFromConfig(&config.Config{StorageBackend: "memory", StorageMirrors: []string{spec}}); err == nil {
+			t.Errorf("expected an error for mirror %q", spec)
+		}
+	}
+}
+
+func TestMirroredBackend_SaveWritesEveryMember(t *testing.T) {
+	ctx := context.Background()
+	secondary := NewMemoryBackend()
+	m, primary, log := newTestMirror(t, secondary)
+
+	result, err := m.Save(ctx, strings.NewReader("mirrored"), SaveOptions{OriginalFilename: "f.txt"})
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	for _, b := range []StorageBackend{primary, secondary} {
+		if got := readAll(t, b, result.Path); got != "mirrored" {
+			t.Errorf("member has %q, want %q", got, "mirrored")
+		}
+	}
+	if len(log.missing) != 0 {
+		t.Errorf("unexpected missing copies: %v", log.missing)
+	}
+}
+
+func TestMirroredBackend_SaveSurvivesFailedSecondary(t *testing.T) {
+	m, primary, log := newTestMirror(t, failingBackend{NewMemoryBackend()})
+
+	result, err := m.Save(context.Background(), strings.NewReader("content"), SaveOptions{})
+	if err != nil {
+		t.Fatalf("Save should succeed once the primary has the object: %v", err)
+	}
+	if readAll(t, primary, result.Path) != "content" {
+		t.Error("primary is missing the object")
+	}
+	if len(log.missing) != 1 || log.missing[0] != "mirror1:"+result.Path {
+		t.Errorf("expected the failed copy to be recorded, got %v", log.missing)
+	}
+}
+
+func TestMirroredBackend_ReadFailover(t *testing.T) {
+	ctx := context.Background()
+	secondary := NewMemoryBackend()
+	m, primary, log := newTestMirror(t, secondary)
+
+	result, err := m.Save(ctx, strings.NewReader("0123456789"), SaveOptions{})
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	// Lose the primary's copy
+	if err := primary.Delete(ctx, result.Path); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readAll(t, m, result.Path); got != "0123456789" {
+		t.Errorf("Open returned %q", got)
+	}
+	r, err := m.OpenRange(ctx, result.Path, 2, 3)
+	if err != nil {
+		t.Fatalf("OpenRange failed: %v", err)
+	}
+	data, _ := io.ReadAll(r)
+	_ = r.Close()
+	if string(data) != "234" {
+		t.Errorf("OpenRange returned %q", data)
+	}
+	if info, err := m.Stat(ctx, result.Path); err != nil || info.Size != 10 {
+		t.Errorf("Stat = %+v, %v", info, err)
+	}
+
+	if len(log.failovers) != 3 || log.failovers[0] != "mirror1:"+result.Path {
+		t.Errorf("expected 3 failovers to mirror1, got %v", log.failovers)
+	}
+	if len(log.missing) == 0 || log.missing[0] != "primary:"+result.Path {
+		t.Errorf("expected the primary to be recorded missing, got %v", log.missing)
+	}
+
+	// Missing everywhere is ErrNotFound; an unreachable member wins over it
+	if _, err := m.Open(ctx, "absent"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	unreachable, _, _ := newTestMirror(t, failingBackend{NewMemoryBackend()})
+	if _, err := unreachable.Open(ctx, "absent"); !errors.Is(err, errUnreachable) {
+		t.Errorf("expected the unreachable error, got %v", err)
+	}
+}
+
+func TestMirroredBackend_DeleteAndList(t *testing.T) {
+	ctx := context.Background()
+	secondary := NewMemoryBackend()
+	m, primary, log := newTestMirror(t, secondary)
+
+	kept, _ := m.Save(ctx, strings.NewReader("kept"), SaveOptions{})
+	deleted, _ := m.Save(ctx, strings.NewReader("deleted"), SaveOptions{})
+	// An object only a secondary holds is still listed
+	onlySecondary, _ := secondary.Save(ctx, strings.NewReader("stray"), SaveOptions{})
+
+	if err := m.Delete(ctx, deleted.Path); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	for _, b := range []StorageBackend{primary, secondary} {
+		if _, err := b.Stat(ctx, deleted.Path); !errors.Is(err, ErrNotFound) {
+			t.Errorf("object not deleted from every member: %v", err)
+		}
+	}
+	if len(log.deleted) != 1 {
+		t.Errorf("expected the delete to be recorded, got %v", log.deleted)
+	}
+
+	var listed []string
+	if err := m.List(ctx, func(info FileInfo) error {
+		listed = append(listed, info.Path)
+		return nil
+	}); err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(listed) != 2 || !slices.Contains(listed, kept.Path) || !slices.Contains(listed, onlySecondary.Path) {
+		t.Errorf("List returned %v", listed)
+	}
+}
+
+func TestMirroredBackend_Repair(t *testing.T) {
+	ctx := context.Background()
+	secondary := NewMemoryBackend()
+	m, primary, _ := newTestMirror(t, secondary)
+
+	result, _ := m.Save(ctx, strings.NewReader("precious"), SaveOptions{})
+
+	// Nothing to do while every member has the object
+	copied, err := m.Repair(ctx, result.Path)
+	if err != nil || len(copied) != 0 {
+		t.Fatalf("Repair = %v, %v", copied, err)
+	}
+
+	// The primary volume was lost
+	primary.Clear()
+	copied, err = m.Repair(ctx, result.Path)
+	if err != nil || len(copied) != 1 || copied[0] != PrimaryMember {
+		t.Fatalf("Repair = %v, %v", copied, err)
+	}
+	if readAll(t, primary, result.Path) != "precious" {
+		t.Error("primary copy was not restored")
+	}
+
+	// A truncated copy is replaced
+	if _, err := secondary.Put(ctx, result.Path, strings.NewReader("prec"), SaveOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	copied, err = m.Repair(ctx, result.Path)
+	if err != nil || len(copied) != 1 || copied[0] != "mirror1" {
+		t.Fatalf("Repair = %v, %v", copied, err)
+	}
+	if readAll(t, secondary, result.Path) != "precious" {
+		t.Error("truncated copy was not replaced")
+	}
+
+	primary.Clear()
+	secondary.Clear()
+	if _, err := m.Repair(ctx, result.Path); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a lost object, got %v", err)
+	}
+}
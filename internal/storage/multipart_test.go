@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMultipartUploader_InterfaceCompliance(t *testing.T) {
+	var _ MultipartUploader = (*MemoryBackend)(nil)
+	var _ MultipartUploader = (*DiskBackend)(nil)
+	var _ MultipartUploader = (*S3Backend)(nil)
+	var _ MultipartUploader = (*CachedBackend)(nil)
+	var _ MultipartUploader = (*MirroredBackend)(nil)
+	var _ UploadSweeper = (*DiskBackend)(nil)
+}
+
+func TestMultipartUploader(t *testing.T) {
+	disk, err := NewDiskBackend(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDiskBackend failed: %v", err)
+	}
+	backends := map[string]interface {
+		StorageBackend
+		MultipartUploader
+	}{
+		"memory": NewMemoryBackend(),
+		"disk":   disk,
+	}
+
+	for name, backend := range backends {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			id, err := backend.InitMultipart(ctx, 5, SaveOptions{OriginalFilename: "notes.txt"})
+			if err != nil {
+				t.Fatalf("InitMultipart failed: %v", err)
+			}
+
+			// Out of order, with part 1 sent twice
+			for _, part := range []struct {
+				number int
+				data   string
+			}{{2, "world"}, {1, "xxxxx"}, {3, "!"}, {1, "hello"}} {
+				if err := backend.PutPart(ctx, id, part.number, strings.NewReader(part.data), int64(len(part.data))); err != nil {
+					t.Fatalf("PutPart %d failed: %v", part.number, err)
+				}
+			}
+			if err := backend.PutPart(ctx, id, 3, strings.NewReader("too long"), 1); err == nil {
+				t.Error("expected a part longer than its size to be refused")
+			}
+			if err := backend.PutPart(ctx, id, 3, strings.NewReader(""), 1); err == nil {
+				t.Error("expected a short part to be refused")
+			}
+			if _, err := backend.CompleteMultipart(ctx, id, 4); err == nil {
+				t.Error("expected completing with a missing part to fail")
+			}
+
+			result, err := backend.CompleteMultipart(ctx, id, 3)
+			if err != nil {
+				t.Fatalf("CompleteMultipart failed: %v", err)
+			}
+			if result.Size != 11 || filepath.Ext(result.Path) != ".txt" {
+				t.Errorf("unexpected result %+v", result)
+			}
+			if got := readAll(t, backend, result.Path); got != "helloworld!" {
+				t.Errorf("completed object is %q", got)
+			}
+
+			// Aborting discards the parts
+			id, _ = backend.InitMultipart(ctx, 0, SaveOptions{})
+			_ = backend.PutPart(ctx, id, 1, strings.NewReader("abc"), 3)
+			if err := backend.AbortMultipart(ctx, id); err != nil {
+				t.Fatalf("AbortMultipart failed: %v", err)
+			}
+			if err := backend.PutPart(ctx, id, 1, strings.NewReader("abc"), 3); !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected ErrNotFound for an aborted upload, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDiskBackend_MultipartHiddenAndSwept(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	disk, err := NewDiskBackend(dir)
+	if err != nil {
+		t.Fatalf("NewDiskBackend failed: %v", err)
+	}
+	stale, _ := disk.InitMultipart(ctx, 0, SaveOptions{})
+	_ = disk.PutPart(ctx, stale, 1, bytes.NewReader([]byte("abc")), 3)
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, multipartDir, stale), old, old); err != nil {
+		t.Fatal(err)
+	}
+	fresh, _ := disk.InitMultipart(ctx, 0, SaveOptions{})
+	// So is a write interrupted by a crash
+	interrupted := filepath.Join(dir, tmpDir, "interrupted")
+	if err := os.WriteFile(interrupted, []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(interrupted, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	// Parts are not objects
+	listed := 0
+	_ = disk.List(ctx, func(FileInfo) error {
+		listed++
+		return nil
+	})
+	if listed != 0 {
+		t.Errorf("List returned %d objects for pending uploads", listed)
+	}
+
+	aborted, err := disk.AbortStaleUploads(ctx, time.Now().Add(-24*time.Hour))
+	if err != nil || aborted != 2 {
+		t.Fatalf("AbortStaleUploads = %d, %v", aborted, err)
+	}
+	if _, err := os.Stat(interrupted); !os.IsNotExist(err) {
+		t.Errorf("stale temporary file should be removed: %v", err)
+	}
+	if err := disk.PutPart(ctx, fresh, 1, bytes.NewReader([]byte("abc")), 3); err != nil {
+		t.Errorf("recent upload should survive the sweep: %v", err)
+	}
+}
+
+func TestMirroredBackend_MultipartReplicates(t *testing.T) {
+	ctx := context.Background()
+	secondary := NewMemoryBackend()
+	m, primary, _ := newTestMirror(t, secondary)
+
+	id, err := m.InitMultipart(ctx, 0, SaveOptions{})
+	if err != nil {
+		t.Fatalf("InitMultipart failed: %v", err)
+	}
+	if err := m.PutPart(ctx, id, 1, strings.NewReader("mirrored"), 8); err != nil {
+		t.Fatalf("PutPart failed: %v", err)
+	}
+	result, err := m.CompleteMultipart(ctx, id, 1)
+	if err != nil {
+		t.Fatalf("CompleteMultipart failed: %v", err)
+	}
+	for _, b := range []StorageBackend{primary, secondary} {
+		if got := readAll(t, b, result.Path); got != "mirrored" {
+			t.Errorf("member has %q", got)
+		}
+	}
+
+	// Encryption does not support parts, so neither does a mirror of it
+	encrypted, _, _ := newTestMirror(t, NewMemoryBackend())
+	encrypted.members[0].Backend = newTestEncryptedBackend(t, NewMemoryBackend(), "k1:"+testKey(t), "")
+	if _, err := encrypted.InitMultipart(ctx, 0, SaveOptions{}); !errors.Is(err, ErrMultipartUnsupported) {
+		t.Errorf("expected ErrMultipartUnsupported, got %v", err)
+	}
+}
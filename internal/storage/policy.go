@@ -0,0 +1,336 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrUnknownPolicy is returned when saving under a storage policy that is
+// not configured.
+var ErrUnknownPolicy = errors.New("storage: unknown storage policy")
+
+// policyNamePattern restricts policy names so that one can never be
+// mistaken for the start of a generated path ("{uuid}.ext").
+var policyNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,49}$`)
+
+// ValidPolicyName reports whether name can name a storage policy.
+func ValidPolicyName(name string) bool {
+	return policyNamePattern.MatchString(name)
+}
+
+// PolicyOf returns the storage policy named by an object's path, or "" for
+// an object in the default backend.
+func PolicyOf(path string) string {
+	name, _, ok := strings.Cut(path, ":")
+	if !ok || !ValidPolicyName(name) {
+		return ""
+	}
+	return name
+}
+
+// PolicyBackend stores objects in a default backend or in one of several
+// named backends, the storage policies, chosen per object by
+// SaveOptions.Policy. The path of an object stored under a policy is the
+// policy name, a colon and its path in that policy's backend (for example
+// "s3-archive:{uuid}.pdf"), so every later operation on the path finds the
+// backend that holds it. Paths in the default backend are unchanged, and a
+// path naming a policy that is not configured is looked up in the default
+// backend as is.
+type PolicyBackend struct {
+	def      StorageBackend
+	policies map[string]StorageBackend
+	names    []string // Sorted
+}
+
+// NewPolicyBackend returns a backend storing objects in def unless they are
+// saved under one of policies, each named by its Member name.
+func NewPolicyBackend(def StorageBackend, policies ...Member) (*PolicyBackend, error) {
+	p := &PolicyBackend{def: def, policies: make(map[string]StorageBackend)}
+	for _, policy := range policies {
+		if !ValidPolicyName(policy.Name) {
+			return nil, fmt.Errorf("invalid storage policy name %q: use lowercase letters, digits, '-' and '_'", policy.Name)
+		}
+		if _, ok := p.policies[policy.Name]; ok {
+			return nil, fmt.Errorf("storage policy %q is defined twice", policy.Name)
+		}
+		p.policies[policy.Name] = policy.Backend
+		p.names = append(p.names, policy.Name)
+	}
+	slices.Sort(p.names)
+	return p, nil
+}
+
+// PolicyNames returns the storage policies configured for b, sorted, or
+// nil if it has none.
+func PolicyNames(b StorageBackend) []string {
+	p, ok := asPolicyBackend(b)
+	if !ok {
+		return nil
+	}
+	return slices.Clone(p.names)
+}
+
+// HasPolicy reports whether name is a storage policy configured for b. The
+// default policy "" always is.
+func HasPolicy(b StorageBackend, name string) bool {
+	return name == "" || slices.Contains(PolicyNames(b), name)
+}
+
+// PolicyFor returns the storage policy of b that holds the object at path,
+// or "" for the default backend, so a related object can be saved alongside
+// it.
+func PolicyFor(b StorageBackend, path string) string {
+	if policy := PolicyOf(path); HasPolicy(b, policy) {
+		return policy
+	}
+	return ""
+}
+
+// asPolicyBackend returns the PolicyBackend that b is or, for an
+// EncryptedBackend, wraps.
+func asPolicyBackend(b StorageBackend) (*PolicyBackend, bool) {
+	if e, ok := b.(*EncryptedBackend); ok {
+		b = e.inner
+	}
+	p, ok := b.(*PolicyBackend)
+	return p, ok
+}
+
+// route returns the backend holding the object at path and its path there.
+func (p *PolicyBackend) route(path string) (StorageBackend, string) {
+	_, backend, inner := p.routePolicy(path)
+	return backend, inner
+}
+
+// routePolicy is route, also returning the name of the policy ("" for the
+// default backend).
+func (p *PolicyBackend) routePolicy(path string) (string, StorageBackend, string) {
+	name := PolicyOf(path)
+	if backend, ok := p.policies[name]; ok {
+		return name, backend, path[len(name)+1:]
+	}
+	return "", p.def, path
+}
+
+// backend returns the backend of the named policy.
+func (p *PolicyBackend) backend(name string) (StorageBackend, error) {
+	if name == "" {
+		return p.def, nil
+	}
+	backend, ok := p.policies[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownPolicy, name)
+	}
+	return backend, nil
+}
+
+// qualify returns the path of an object at path in the named policy's
+// backend.
+func qualify(name, path string) string {
+	if name == "" {
+		return path
+	}
+	return name + ":" + path
+}
+
+// Save stores content in the backend of opts.Policy.
+func (p *PolicyBackend) Save(ctx context.Context, r io.Reader, opts SaveOptions) (SaveResult, error) {
+	backend, err := p.backend(opts.Policy)
+	if err != nil {
+		return SaveResult{}, err
+	}
+	result, err := backend.Save(ctx, r, opts)
+	if err != nil {
+		return SaveResult{}, err
+	}
+	result.Path = qualify(opts.Policy, result.Path)
+	return result, nil
+}
+
+// Put replaces the object at path in the backend holding it, which must
+// implement Putter.
+func (p *PolicyBackend) Put(ctx context.Context, path string, r io.Reader, opts SaveOptions) (SaveResult, error) {
+	backend, inner := p.route(path)
+	putter, ok := backend.(Putter)
+	if !ok {
+		return SaveResult{}, errors.New("storage backend cannot store objects at a given path")
+	}
+	result, err := putter.Put(ctx, inner, r, opts)
+	if err != nil {
+		return SaveResult{}, err
+	}
+	result.Path = path
+	return result, nil
+}
+
+// InitMultipart starts a multipart upload in the backend of opts.Policy.
+// The upload ID names the policy the same way paths do.
+func (p *PolicyBackend) InitMultipart(ctx context.Context, partSize int64, opts SaveOptions) (string, error) {
+	backend, err := p.backend(opts.Policy)
+	if err != nil {
+		return "", err
+	}
+	uploader, err := asMultipart(backend)
+	if err != nil {
+		return "", err
+	}
+	id, err := uploader.InitMultipart(ctx, partSize, opts)
+	if err != nil {
+		return "", err
+	}
+	return qualify(opts.Policy, id), nil
+}
+
+// PutPart stores a part in the backend of the upload.
+func (p *PolicyBackend) PutPart(ctx context.Context, uploadID string, number int, r io.Reader, size int64) error {
+	backend, inner := p.route(uploadID)
+	uploader, err := asMultipart(backend)
+	if err != nil {
+		return err
+	}
+	return uploader.PutPart(ctx, inner, number, r, size)
+}
+
+// CompleteMultipart completes a multipart upload in the backend of the
+// upload.
+func (p *PolicyBackend) CompleteMultipart(ctx context.Context, uploadID string, parts int) (SaveResult, error) {
+	name, backend, inner := p.routePolicy(uploadID)
+	uploader, err := asMultipart(backend)
+	if err != nil {
+		return SaveResult{}, err
+	}
+	result, err := uploader.CompleteMultipart(ctx, inner, parts)
+	if err != nil {
+		return SaveResult{}, err
+	}
+	result.Path = qualify(name, result.Path)
+	return result, nil
+}
+
+// AbortMultipart aborts a multipart upload in the backend of the upload.
+func (p *PolicyBackend) AbortMultipart(ctx context.Context, uploadID string) error {
+	backend, inner := p.route(uploadID)
+	uploader, err := asMultipart(backend)
+	if err != nil {
+		return err
+	}
+	return uploader.AbortMultipart(ctx, inner)
+}
+
+// Open opens the object in the backend holding it.
+func (p *PolicyBackend) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	backend, inner := p.route(path)
+	return backend.Open(ctx, inner)
+}
+
+// OpenRange opens a byte range of the object in the backend holding it.
+func (p *PolicyBackend) OpenRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	backend, inner := p.route(path)
+	return backend.OpenRange(ctx, inner, offset, length)
+}
+
+// Stat returns the object's metadata from the backend holding it.
+func (p *PolicyBackend) Stat(ctx context.Context, path string) (FileInfo, error) {
+	backend, inner := p.route(path)
+	info, err := backend.Stat(ctx, inner)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	info.Path = path
+	return info, nil
+}
+
+// Delete removes the object from the backend holding it.
+func (p *PolicyBackend) Delete(ctx context.Context, path string) error {
+	backend, inner := p.route(path)
+	return backend.Delete(ctx, inner)
+}
+
+// List calls fn once for every object in the default backend and every
+// policy, with the object's path as PolicyBackend names it. Every backend
+// must support listing.
+func (p *PolicyBackend) List(ctx context.Context, fn func(FileInfo) error) error {
+	for _, name := range append([]string{""}, p.names...) {
+		backend, _ := p.backend(name)
+		lister, ok := backend.(Lister)
+		if !ok {
+			return ErrListUnsupported
+		}
+		err := lister.List(ctx, func(info FileInfo) error {
+			info.Path = qualify(name, info.Path)
+			return fn(info)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PresignGet presigns a download from the backend holding the object.
+func (p *PolicyBackend) PresignGet(ctx context.Context, path string, opts PresignOptions) (string, error) {
+	backend, inner := p.route(path)
+	presigner, err := asPresigner(backend)
+	if err != nil {
+		return "", err
+	}
+	return presigner.PresignGet(ctx, inner, opts)
+}
+
+// each calls fn with the default backend and then each policy's, attempting
+// all of them even if one fails.
+func (p *PolicyBackend) each(fn func(StorageBackend) error) error {
+	var errs []error
+	if err := fn(p.def); err != nil {
+		errs = append(errs, err)
+	}
+	for _, name := range p.names {
+		if err := fn(p.policies[name]); err != nil {
+			errs = append(errs, fmt.Errorf("storage policy %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// MigrateLayout migrates the default backend and every policy's.
+func (p *PolicyBackend) MigrateLayout(ctx context.Context) (int, error) {
+	total := 0
+	err := p.each(func(b StorageBackend) error {
+		n, err := MigrateLayout(ctx, b)
+		total += n
+		return err
+	})
+	return total, err
+}
+
+// AbortStaleUploads sweeps the default backend and every policy's.
+func (p *PolicyBackend) AbortStaleUploads(ctx context.Context, cutoff time.Time) (int, error) {
+	total := 0
+	err := p.each(func(b StorageBackend) error {
+		n, err := abortStaleUploads(ctx, b, cutoff)
+		total += n
+		return err
+	})
+	return total, err
+}
+
+// HealthCheck checks the default backend and every policy's, since uploads
+// fail while any of them is unreachable.
+func (p *PolicyBackend) HealthCheck(ctx context.Context) error {
+	return p.each(func(b StorageBackend) error {
+		return b.HealthCheck(ctx)
+	})
+}
+
+// ValidateAccess validates the default backend and every policy's.
+func (p *PolicyBackend) ValidateAccess(ctx context.Context) error {
+	return p.each(func(b StorageBackend) error {
+		return b.ValidateAccess(ctx)
+	})
+}
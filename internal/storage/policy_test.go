@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestPolicyOf(t *testing.T) {
+	for path, want := range map[string]string{
+		"0f8e1f0c-5a4b-4c1d-9e2f-3a4b5c6d7e8f.pdf":            "",
+		"s3-archive:0f8e1f0c-5a4b-4c1d-9e2f-3a4b5c6d7e8f.pdf": "s3-archive",
+		"sub/nested.bin":    "",
+		"a.b:c":             "",
+		"Upper:object.bin":  "",
+		"local_ssd:obj.bin": "local_ssd",
+	} {
+		if got := PolicyOf(path); got != want {
+			t.Errorf("PolicyOf(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestPolicyBackend(t *testing.T) {
+	ctx := context.Background()
+	def, archive := NewMemoryBackend(), NewMemoryBackend()
+	p, err := NewPolicyBackend(def, Member{Name: "archive", Backend: archive})
+	if err != nil {
+		t.Fatalf("NewPolicyBackend failed: %v", err)
+	}
+	if names := PolicyNames(p); len(names) != 1 || names[0] != "archive" {
+		t.Errorf("PolicyNames = %v", names)
+	}
+
+	plain, err := p.Save(ctx, strings.NewReader("default"), SaveOptions{OriginalFilename: "a.txt"})
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	archived, err := p.Save(ctx, strings.NewReader("archived"), SaveOptions{OriginalFilename: "b.txt", Policy: "archive"})
+	if err != nil {
+		t.Fatalf("Save under policy failed: %v", err)
+	}
+	if PolicyOf(plain.Path) != "" || PolicyOf(archived.Path) != "archive" {
+		t.Fatalf("unexpected paths %q and %q", plain.Path, archived.Path)
+	}
+	if _, err := p.Save(ctx, strings.NewReader("x"), SaveOptions{Policy: "missing"}); !errors.Is(err, ErrUnknownPolicy) {
+		t.Errorf("expected ErrUnknownPolicy, got %v", err)
+	}
+
+	// Each object is in its policy's backend and read back through its path
+	inner := strings.TrimPrefix(archived.Path, "archive:")
+	if _, err := archive.Stat(ctx, inner); err != nil {
+		t.Errorf("object missing from the policy's backend: %v", err)
+	}
+	if _, err := def.Stat(ctx, inner); !errors.Is(err, ErrNotFound) {
+		t.Errorf("object should not be in the default backend: %v", err)
+	}
+	r, err := p.Open(ctx, archived.Path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	got, _ := io.ReadAll(r)
+	_ = r.Close()
+	if string(got) != "archived" {
+		t.Errorf("read %q", got)
+	}
+	if info, err := p.Stat(ctx, archived.Path); err != nil || info.Path != archived.Path {
+		t.Errorf("Stat = %+v, %v", info, err)
+	}
+
+	listed := map[string]bool{}
+	if err := p.List(ctx, func(info FileInfo) error {
+		listed[info.Path] = true
+		return nil
+	}); err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(listed) != 2 || !listed[plain.Path] || !listed[archived.Path] {
+		t.Errorf("List returned %v", listed)
+	}
+
+	// Multipart uploads go to the policy's backend too
+	id, err := p.InitMultipart(ctx, 0, SaveOptions{OriginalFilename: "c.txt", Policy: "archive"})
+	if err != nil {
+		t.Fatalf("InitMultipart failed: %v", err)
+	}
+	if err := p.PutPart(ctx, id, 1, strings.NewReader("parts"), 5); err != nil {
+		t.Fatalf("PutPart failed: %v", err)
+	}
+	joined, err := p.CompleteMultipart(ctx, id, 1)
+	if err != nil {
+		t.Fatalf("CompleteMultipart failed: %v", err)
+	}
+	if _, err := archive.Stat(ctx, strings.TrimPrefix(joined.Path, "archive:")); err != nil || PolicyOf(joined.Path) != "archive" {
+		t.Errorf("multipart object %q not stored under the policy: %v", joined.Path, err)
+	}
+
+	if err := p.Delete(ctx, archived.Path); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := archive.Stat(ctx, inner); !errors.Is(err, ErrNotFound) {
+		t.Errorf("object should be deleted: %v", err)
+	}
+}
+
+func TestNewPolicyBackend_InvalidNames(t *testing.T) {
+	for _, name := range []string{"", "Archive", "a:b", "with space"} {
+		if _, err := NewPolicyBackend(NewMemoryBackend(), Member{Name: name, Backend: NewMemoryBackend()}); err == nil {
+			t.Errorf("policy name %q should be rejected", name)
+		}
+	}
+	_, err := NewPolicyBackend(NewMemoryBackend(),
+		Member{Name: "a", Backend: NewMemoryBackend()},
+		Member{Name: "a", Backend: NewMemoryBackend()},
+	)
+	if err == nil {
+		t.Error("duplicate policy names should be rejected")
+	}
+}
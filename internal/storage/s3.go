@@ -4,17 +4,23 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"hash"
 	"io"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/google/uuid"
 )
 
@@ -27,12 +33,49 @@ import (
 type S3Config struct {
 	Bucket       string // S3 bucket name (required)
 	UsePathStyle bool   // Use path-style addressing (required for MinIO/rustfs)
+	PartSize     int64  // Size of each part of a multipart upload (default 16 MiB, minimum 5 MiB)
+	Concurrency  int    // Parts of one upload sent at once (default 4)
+
+	// Lifetime of presigned download URLs; 0 disables presigning
+	PresignExpiry time.Duration
+}
+
+const (
+	defaultPartSize    = 16 * 1024 * 1024
+	minPartSize        = 5 * 1024 * 1024 // S3's minimum for every part but the last
+	maxPartSize        = 5 * 1024 * 1024 * 1024
+	maxParts           = 10000
+	defaultConcurrency = 4
+)
+
+// s3API is the part of the S3 client S3Backend uses.
+type s3API interface {
+	s3.ListObjectsV2APIClient
+	s3.ListMultipartUploadsAPIClient
+	s3.ListPartsAPIClient
+	PutObject(context.Context, *s3.PutObjectInput, ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	GetObject(context.Context, *s3.GetObjectInput, ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	HeadObject(context.Context, *s3.HeadObjectInput, ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	DeleteObject(context.Context, *s3.DeleteObjectInput, ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+	CreateMultipartUpload(context.Context, *s3.CreateMultipartUploadInput, ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(context.Context, *s3.UploadPartInput, ...func(*s3.Options)) (*s3.UploadPartOutput, error)
+	CompleteMultipartUpload(context.Context, *s3.CompleteMultipartUploadInput, ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(context.Context, *s3.AbortMultipartUploadInput, ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
+}
+
+// s3Presigner is the part of the S3 presign client S3Backend uses.
+type s3Presigner interface {
+	PresignGetObject(context.Context, *s3.GetObjectInput, ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error)
 }
 
 // S3Backend implements StorageBackend using AWS S3 or compatible services.
 type S3Backend struct {
-	client *s3.Client
-	bucket string
+	client        s3API
+	presigner     s3Presigner // nil if presigning is disabled
+	presignExpiry time.Duration
+	bucket        string
+	partSize      int64
+	concurrency   int
 }
 
 // NewS3Backend creates a new S3 storage backend.
@@ -63,10 +106,29 @@ func NewS3Backend(cfg S3Config) (*S3Backend, error) {
 
 	client := s3.NewFromConfig(awsCfg, s3Opts...)
 
-	return &S3Backend{
-		client: client,
-		bucket: cfg.Bucket,
-	}, nil
+	b := newS3Backend(client, cfg)
+	if b.presignExpiry > 0 {
+		b.presigner = s3.NewPresignClient(client)
+	}
+	return b, nil
+}
+
+func newS3Backend(client s3API, cfg S3Config) *S3Backend {
+	b := &S3Backend{
+		client:        client,
+		presignExpiry: cfg.PresignExpiry,
+		bucket:        cfg.Bucket,
+		partSize:      cfg.PartSize,
+		concurrency:   cfg.Concurrency,
+	}
+	if b.partSize == 0 {
+		b.partSize = defaultPartSize
+	}
+	b.partSize = max(b.partSize, minPartSize)
+	if b.concurrency < 1 {
+		b.concurrency = defaultConcurrency
+	}
+	return b
 }
 
 // Save stores content in S3 and returns the generated path, hash, and size.
@@ -74,64 +136,347 @@ func (s *S3Backend) Save(ctx context.Context, r io.Reader, opts SaveOptions) (Sa
 	// Generate unique filename
 	ext := filepath.Ext(opts.OriginalFilename)
 	key := uuid.New().String() + ext
+	return s.Put(ctx, key, r, opts)
+}
+
+// Put stores content under key, replacing any object there. S3 writes are
+// atomic, so readers see either the old object or the complete new one.
+//
+// Content that fits in one part is stored with a single PutObject. Anything
+// larger is sent as a multipart upload, with up to Concurrency parts in
+// flight and buffered in memory at once. Every part carries its SHA-256 so
+// S3 rejects parts corrupted in transit, and a failed upload is aborted so
+// its parts do not linger in the bucket.
+func (s *S3Backend) Put(ctx context.Context, key string, r io.Reader, opts SaveOptions) (SaveResult, error) {
+	hasher := sha256.New()
+	r = io.TeeReader(r, hasher)
+
+	first := make([]byte, s.partSize)
+	n, err := io.ReadFull(r, first)
+	switch {
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		return s.putObject(ctx, key, first[:n], hasher, opts)
+	case err != nil:
+		return SaveResult{}, fmt.Errorf("failed to read content: %w", err)
+	}
+	return s.putMultipart(ctx, key, r, first, hasher, opts)
+}
+
+// putObject stores content read in full with a single request.
+func (s *S3Backend) putObject(ctx context.Context, key string, data []byte, hasher hash.Hash, opts SaveOptions) (SaveResult, error) {
+	sum := hasher.Sum(nil)
+	input := &s3.PutObjectInput{
+		Bucket:         aws.String(s.bucket),
+		Key:            aws.String(key),
+		Body:           bytes.NewReader(data),
+		ContentLength:  aws.Int64(int64(len(data))),
+		ChecksumSHA256: aws.String(base64.StdEncoding.EncodeToString(sum)),
+	}
+	if opts.ContentType != "" {
+		input.ContentType = aws.String(opts.ContentType)
+	}
+	if _, err := s.client.PutObject(ctx, input); err != nil {
+		return SaveResult{}, fmt.Errorf("failed to upload to S3: %w", err)
+	}
+	return SaveResult{
+		Path: key,
+		Hash: hex.EncodeToString(sum),
+		Size: int64(len(data)),
+	}, nil
+}
+
+// putMultipart stores content as a multipart upload. first holds the first
+// part, already read from r.
+func (s *S3Backend) putMultipart(ctx context.Context, key string, r io.Reader, first []byte, hasher hash.Hash, opts SaveOptions) (SaveResult, error) {
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:            aws.String(s.bucket),
+		Key:               aws.String(key),
+		ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+	}
+	if opts.ContentType != "" {
+		input.ContentType = aws.String(opts.ContentType)
+	}
+	created, err := s.client.CreateMultipartUpload(ctx, input)
+	if err != nil {
+		return SaveResult{}, fmt.Errorf("failed to start S3 multipart upload: %w", err)
+	}
+	uploadID := created.UploadId
+
+	uploadCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	// Buffers circulate between the reader and the part uploads, which
+	// bounds both memory and the number of parts in flight
+	buffers := make(chan []byte, s.concurrency)
+	for range s.concurrency - 1 {
+		buffers <- nil
+	}
 
-	// Determine content length if the reader supports seeking
-	var contentLength int64
-	if seeker, ok := r.(io.Seeker); ok {
-		// Get current position
-		current, err := seeker.Seek(0, io.SeekCurrent)
-		if err == nil {
-			// Seek to end to get size
-			end, err := seeker.Seek(0, io.SeekEnd)
-			if err == nil {
-				contentLength = end
-				// Seek back to original position
-				_, _ = seeker.Seek(current, io.SeekStart)
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		parts []types.CompletedPart
+		size  int64
+	)
+	buf, n := first, len(first)
+	for number := int32(1); n > 0; number++ {
+		if number > maxParts {
+			cancel(fmt.Errorf("content exceeds %d parts of %d bytes; raise S3_PART_SIZE", maxParts, s.partSize))
+			break
+		}
+		size += int64(n)
+		wg.Add(1)
+		go func(number int32, buf []byte, n int) {
+			defer wg.Done()
+			part, err := s.uploadPart(uploadCtx, key, uploadID, number, buf[:n])
+			buffers <- buf
+			if err != nil {
+				cancel(err)
+				return
 			}
+			mu.Lock()
+			parts = append(parts, part)
+			mu.Unlock()
+		}(number, buf, n)
+
+		select {
+		case buf = <-buffers:
+		case <-uploadCtx.Done():
+		}
+		if uploadCtx.Err() != nil {
+			break
+		}
+		if buf == nil {
+			buf = make([]byte, s.partSize)
+		}
+		n, err = io.ReadFull(r, buf)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			cancel(fmt.Errorf("failed to read content: %w", err))
+			break
 		}
 	}
+	wg.Wait()
 
-	// Create a hashing reader that computes SHA256 while streaming to S3
-	hashingReader := &hashingReader{
-		reader: r,
-		hasher: newSHA256Hasher(),
+	if err := context.Cause(uploadCtx); err != nil {
+		s.abortUpload(ctx, key, uploadID)
+		return SaveResult{}, fmt.Errorf("failed to upload to S3: %w", err)
 	}
 
-	// Upload to S3 using streaming reader
-	input := &s3.PutObjectInput{
-		Bucket: aws.String(s.bucket),
-		Key:    aws.String(key),
-		Body:   hashingReader,
+	slices.SortFunc(parts, func(a, b types.CompletedPart) int {
+		return int(aws.ToInt32(a.PartNumber) - aws.ToInt32(b.PartNumber))
+	})
+	_, err = s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(key),
+		UploadId:        uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		s.abortUpload(ctx, key, uploadID)
+		return SaveResult{}, fmt.Errorf("failed to complete S3 multipart upload: %w", err)
 	}
 
-	// Set content length if we determined it
-	if contentLength > 0 {
-		input.ContentLength = aws.Int64(contentLength)
+	return SaveResult{
+		Path: key,
+		Hash: hex.EncodeToString(hasher.Sum(nil)),
+		Size: size,
+	}, nil
+}
+
+// uploadPart uploads one part with its SHA-256 checksum.
+func (s *S3Backend) uploadPart(ctx context.Context, key string, uploadID *string, number int32, data []byte) (types.CompletedPart, error) {
+	sum := sha256.Sum256(data)
+	checksum := aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+	output, err := s.client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:         aws.String(s.bucket),
+		Key:            aws.String(key),
+		UploadId:       uploadID,
+		PartNumber:     aws.Int32(number),
+		Body:           bytes.NewReader(data),
+		ContentLength:  aws.Int64(int64(len(data))),
+		ChecksumSHA256: checksum,
+	})
+	if err != nil {
+		return types.CompletedPart{}, fmt.Errorf("part %d: %w", number, err)
 	}
+	return types.CompletedPart{
+		ETag:           output.ETag,
+		PartNumber:     aws.Int32(number),
+		ChecksumSHA256: checksum,
+	}, nil
+}
 
+// abortUpload discards a failed multipart upload's parts. It runs even if
+// ctx was cancelled; an upload it fails to abort is left for
+// AbortStaleUploads.
+func (s *S3Backend) abortUpload(ctx context.Context, key string, uploadID *string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+	_, _ = s.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: uploadID,
+	})
+}
+
+// InitMultipart starts a multipart upload for a chunked upload. Its ID is
+// the object key and the S3 upload ID, joined by a slash. S3 needs every
+// part but the last to be at least 5 MiB, so smaller parts are refused with
+// ErrMultipartUnsupported.
+func (s *S3Backend) InitMultipart(ctx context.Context, partSize int64, opts SaveOptions) (string, error) {
+	if partSize != 0 && (partSize < minPartSize || partSize > maxPartSize) {
+		return "", ErrMultipartUnsupported
+	}
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:            aws.String(s.bucket),
+		Key:               aws.String(uuid.New().String() + filepath.Ext(opts.OriginalFilename)),
+		ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+	}
 	if opts.ContentType != "" {
 		input.ContentType = aws.String(opts.ContentType)
 	}
+	created, err := s.client.CreateMultipartUpload(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("failed to start S3 multipart upload: %w", err)
+	}
+	return aws.ToString(input.Key) + "/" + aws.ToString(created.UploadId), nil
+}
 
-	output, err := s.client.PutObject(ctx, input)
+// PutPart uploads a part of a multipart upload. The part is buffered in
+// memory so its checksum can be sent ahead of it.
+func (s *S3Backend) PutPart(ctx context.Context, uploadID string, number int, r io.Reader, size int64) error {
+	key, id, err := splitUploadID(uploadID)
 	if err != nil {
-		return SaveResult{}, fmt.Errorf("failed to upload to S3: %w", err)
+		return err
+	}
+	if number < 1 || number > maxParts {
+		return fmt.Errorf("part number %d is out of range", number)
 	}
+	data, err := readPart(r, number, size)
+	if err != nil {
+		return err
+	}
+	if _, err := s.uploadPart(ctx, key, aws.String(id), int32(number), data); err != nil {
+		return fmt.Errorf("failed to upload to S3: %w", err)
+	}
+	return nil
+}
 
-	// Use the tracked bytes read as the actual size
-	size := hashingReader.bytesRead
+// CompleteMultipart completes a multipart upload from the parts S3 holds.
+func (s *S3Backend) CompleteMultipart(ctx context.Context, uploadID string, parts int) (SaveResult, error) {
+	key, id, err := splitUploadID(uploadID)
+	if err != nil {
+		return SaveResult{}, err
+	}
 
-	// Verify upload with ETag if available
-	_ = output.ETag // ETag confirms successful upload
+	var completed []types.CompletedPart
+	var size int64
+	paginator := s3.NewListPartsPaginator(s.client, &s3.ListPartsInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(id),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			if isS3NotFoundError(err) {
+				return SaveResult{}, ErrNotFound
+			}
+			return SaveResult{}, fmt.Errorf("failed to list S3 multipart upload parts: %w", err)
+		}
+		for _, part := range page.Parts {
+			if aws.ToInt32(part.PartNumber) > int32(parts) {
+				continue
+			}
+			completed = append(completed, types.CompletedPart{
+				ETag:           part.ETag,
+				PartNumber:     part.PartNumber,
+				ChecksumSHA256: part.ChecksumSHA256,
+			})
+			size += aws.ToInt64(part.Size)
+		}
+	}
+	slices.SortFunc(completed, func(a, b types.CompletedPart) int {
+		return int(aws.ToInt32(a.PartNumber) - aws.ToInt32(b.PartNumber))
+	})
+	for i, part := range completed {
+		if aws.ToInt32(part.PartNumber) != int32(i+1) {
+			return SaveResult{}, fmt.Errorf("part %d was not uploaded", i+1)
+		}
+	}
+	if len(completed) != parts {
+		return SaveResult{}, fmt.Errorf("part %d was not uploaded", len(completed)+1)
+	}
 
-	// Use the hashingReader's current hasher to get the final hash.
-	// This is important because Seek resets the hasher on retries,
-	// so we need to use the potentially reset hasher, not a stale local copy.
-	return SaveResult{
-		Path: key,
-		Hash: hex.EncodeToString(hashingReader.Hasher().Sum(nil)),
-		Size: size,
-	}, nil
+	_, err = s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(id),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		return SaveResult{}, fmt.Errorf("failed to complete S3 multipart upload: %w", err)
+	}
+	return SaveResult{Path: key, Size: size}, nil
+}
+
+// AbortMultipart aborts a multipart upload.
+func (s *S3Backend) AbortMultipart(ctx context.Context, uploadID string) error {
+	key, id, err := splitUploadID(uploadID)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(id),
+	})
+	if err != nil && !isS3NotFoundError(err) {
+		return fmt.Errorf("failed to abort S3 multipart upload: %w", err)
+	}
+	return nil
+}
+
+// splitUploadID splits an ID from InitMultipart into key and S3 upload ID.
+// Generated keys never contain a slash.
+func splitUploadID(uploadID string) (string, string, error) {
+	key, id, ok := strings.Cut(uploadID, "/")
+	if !ok || key == "" || id == "" {
+		return "", "", fmt.Errorf("invalid multipart upload ID %q", uploadID)
+	}
+	return key, id, nil
+}
+
+// AbortStaleUploads aborts multipart uploads in the bucket started before
+// cutoff, which were left behind by a process that died mid-upload, and
+// returns how many it aborted. Their parts are otherwise billed forever
+// without ever being listed as objects.
+func (s *S3Backend) AbortStaleUploads(ctx context.Context, cutoff time.Time) (int, error) {
+	aborted := 0
+	paginator := s3.NewListMultipartUploadsPaginator(s.client, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(s.bucket),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return aborted, fmt.Errorf("failed to list S3 multipart uploads: %w", err)
+		}
+		for _, upload := range page.Uploads {
+			if upload.Initiated == nil || !upload.Initiated.Before(cutoff) {
+				continue
+			}
+			_, err := s.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(s.bucket),
+				Key:      upload.Key,
+				UploadId: upload.UploadId,
+			})
+			if err != nil && !isS3NotFoundError(err) {
+				return aborted, fmt.Errorf("failed to abort S3 multipart upload of %s: %w", aws.ToString(upload.Key), err)
+			}
+			aborted++
+		}
+	}
+	return aborted, nil
 }
 
 // Open returns a reader for the object at the given key.
@@ -172,6 +517,29 @@ func (s *S3Backend) OpenRange(ctx context.Context, path string, offset, length i
 	return output.Body, nil
 }
 
+// PresignGet returns a URL valid for the configured presign expiry that
+// downloads the object with the given response headers.
+func (s *S3Backend) PresignGet(ctx context.Context, path string, opts PresignOptions) (string, error) {
+	if s.presigner == nil {
+		return "", ErrPresignUnsupported
+	}
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(path),
+	}
+	if opts.ContentType != "" {
+		input.ResponseContentType = aws.String(opts.ContentType)
+	}
+	if opts.ContentDisposition != "" {
+		input.ResponseContentDisposition = aws.String(opts.ContentDisposition)
+	}
+	req, err := s.presigner.PresignGetObject(ctx, input, s3.WithPresignExpires(s.presignExpiry))
+	if err != nil {
+		return "", fmt.Errorf("failed to presign S3 download: %w", err)
+	}
+	return req.URL, nil
+}
+
 // Delete removes an object from S3. Returns nil if object doesn't exist (idempotent).
 func (s *S3Backend) Delete(ctx context.Context, path string) error {
 	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
@@ -214,6 +582,29 @@ func (s *S3Backend) Stat(ctx context.Context, path string) (FileInfo, error) {
 	}, nil
 }
 
+// List calls fn for every object in the bucket, a page at a time.
+func (s *S3Backend) List(ctx context.Context, fn func(FileInfo) error) error {
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list objects in S3: %w", err)
+		}
+		for _, obj := range page.Contents {
+			info := FileInfo{Path: aws.ToString(obj.Key), Size: aws.ToInt64(obj.Size)}
+			if obj.LastModified != nil {
+				info.ModTime = *obj.LastModified
+			}
+			if err := fn(info); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // HealthCheck verifies S3 connectivity by listing bucket (limited to 1 object).
 func (s *S3Backend) HealthCheck(ctx context.Context) error {
 	_, err := s.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
@@ -279,68 +670,3 @@ func isS3NotFoundError(err error) bool {
 		strings.Contains(errStr, "NotFound") ||
 		strings.Contains(errStr, "404")
 }
-
-// hashingReader wraps an io.Reader and computes a hash as data is read.
-// This allows streaming uploads to S3 without buffering entire files in memory.
-// It also implements io.Seeker if the underlying reader supports it, which is
-// required for S3 upload retries.
-type hashingReader struct {
-	reader    io.Reader
-	hasher    *sha256Hasher
-	bytesRead int64
-}
-
-// sha256Hasher wraps a hash.Hash and provides access to the current hasher.
-// This is needed because Seek resets the hasher on retries, and Save needs
-// to use the current (potentially reset) hasher to compute the final sum.
-type sha256Hasher struct {
-	hash.Hash
-}
-
-func newSHA256Hasher() *sha256Hasher {
-	return &sha256Hasher{Hash: sha256.New()}
-}
-
-func (h *sha256Hasher) Reset() {
-	h.Hash = sha256.New()
-}
-
-// Hasher returns the current hasher used by the hashingReader.
-// This should be used to compute the final hash sum after reading completes,
-// as the hasher may have been reset due to seek operations (e.g., S3 retries).
-func (hr *hashingReader) Hasher() *sha256Hasher {
-	return hr.hasher
-}
-
-func (hr *hashingReader) Read(p []byte) (n int, err error) {
-	n, err = hr.reader.Read(p)
-	if n > 0 {
-		hr.bytesRead += int64(n)
-		// Write to hasher (hash.Hash.Write never returns an error)
-		hr.hasher.Write(p[:n])
-	}
-	return n, err
-}
-
-// Seek implements io.Seeker by delegating to the underlying reader if it supports seeking.
-// This is required for S3 upload retries - the SDK needs to rewind the stream on failure.
-func (hr *hashingReader) Seek(offset int64, whence int) (int64, error) {
-	seeker, ok := hr.reader.(io.Seeker)
-	if !ok {
-		return 0, fmt.Errorf("underlying reader does not support seeking")
-	}
-
-	pos, err := seeker.Seek(offset, whence)
-	if err != nil {
-		return pos, err
-	}
-
-	// If seeking back to the start, reset the hash and bytes counter
-	// since we'll be re-reading the content
-	if offset == 0 && whence == io.SeekStart {
-		hr.hasher.Reset()
-		hr.bytesRead = 0
-	}
-
-	return pos, nil
-}
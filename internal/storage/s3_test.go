@@ -0,0 +1,344 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// fakeS3 implements the object and multipart calls S3Backend makes, checking
+// checksums the way S3 does.
+type fakeS3 struct {
+	s3API
+
+	mu          sync.Mutex
+	objects     map[string][]byte
+	uploads     map[string]*fakeUpload
+	nextID      int
+	failPart    int32 // UploadPart fails for this part number
+	inFlight    int
+	maxInFlight int
+}
+
+type fakeUpload struct {
+	key       string
+	initiated time.Time
+	parts     map[int32][]byte
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: map[string][]byte{}, uploads: map[string]*fakeUpload{}}
+}
+
+func checkSHA256(data []byte, checksum *string) error {
+	sum := sha256.Sum256(data)
+	if aws.ToString(checksum) != base64.StdEncoding.EncodeToString(sum[:]) {
+		return errors.New("BadDigest")
+	}
+	return nil
+}
+
+func (f *fakeS3) PutObject(_ context.Context, in *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	data, _ := io.ReadAll(in.Body)
+	if err := checkSHA256(data, in.ChecksumSHA256); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[aws.ToString(in.Key)] = data
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) CreateMultipartUpload(_ context.Context, in *s3.CreateMultipartUploadInput, _ ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	id := fmt.Sprint(f.nextID)
+	f.uploads[id] = &fakeUpload{key: aws.ToString(in.Key), initiated: time.Now(), parts: map[int32][]byte{}}
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String(id)}, nil
+}
+
+func (f *fakeS3) UploadPart(_ context.Context, in *s3.UploadPartInput, _ ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	f.mu.Lock()
+	f.inFlight++
+	f.maxInFlight = max(f.maxInFlight, f.inFlight)
+	f.mu.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	defer func() {
+		f.mu.Lock()
+		f.inFlight--
+		f.mu.Unlock()
+	}()
+
+	if aws.ToInt32(in.PartNumber) == f.failPart {
+		return nil, errors.New("InternalError")
+	}
+	data, _ := io.ReadAll(in.Body)
+	if err := checkSHA256(data, in.ChecksumSHA256); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	upload, ok := f.uploads[aws.ToString(in.UploadId)]
+	if !ok {
+		return nil, errors.New("NoSuchUpload")
+	}
+	upload.parts[aws.ToInt32(in.PartNumber)] = data
+	return &s3.UploadPartOutput{ETag: aws.String(fmt.Sprint(aws.ToInt32(in.PartNumber)))}, nil
+}
+
+func (f *fakeS3) CompleteMultipartUpload(_ context.Context, in *s3.CompleteMultipartUploadInput, _ ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	upload, ok := f.uploads[aws.ToString(in.UploadId)]
+	if !ok {
+		return nil, errors.New("NoSuchUpload")
+	}
+	var data []byte
+	for i, part := range in.MultipartUpload.Parts {
+		if aws.ToInt32(part.PartNumber) != int32(i+1) {
+			return nil, errors.New("InvalidPartOrder")
+		}
+		content := upload.parts[int32(i+1)]
+		if err := checkSHA256(content, part.ChecksumSHA256); err != nil {
+			return nil, err
+		}
+		if i < len(in.MultipartUpload.Parts)-1 && len(content) < minPartSize {
+			return nil, errors.New("EntityTooSmall")
+		}
+		data = append(data, content...)
+	}
+	f.objects[upload.key] = data
+	delete(f.uploads, aws.ToString(in.UploadId))
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (f *fakeS3) AbortMultipartUpload(_ context.Context, in *s3.AbortMultipartUploadInput, _ ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.uploads, aws.ToString(in.UploadId))
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func (f *fakeS3) ListMultipartUploads(_ context.Context, _ *s3.ListMultipartUploadsInput, _ ...func(*s3.Options)) (*s3.ListMultipartUploadsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var uploads []types.MultipartUpload
+	for id, u := range f.uploads {
+		uploads = append(uploads, types.MultipartUpload{Key: aws.String(u.key), UploadId: aws.String(id), Initiated: aws.Time(u.initiated)})
+	}
+	return &s3.ListMultipartUploadsOutput{Uploads: uploads}, nil
+}
+
+func randomContent(t *testing.T, size int) []byte {
+	t.Helper()
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func checkSaveResult(t *testing.T, result SaveResult, data []byte) {
+	t.Helper()
+	sum := sha256.Sum256(data)
+	if result.Hash != hex.EncodeToString(sum[:]) || result.Size != int64(len(data)) {
+		t.Errorf("SaveResult = %+v, want hash %x and size %d", result, sum, len(data))
+	}
+}
+
+func TestS3Backend_PutSmallObject(t *testing.T) {
+	client := newFakeS3()
+	backend := newS3Backend(client, S3Config{Bucket: "trove"})
+
+	data := []byte("small object")
+	result, err := backend.Put(context.Background(), "small", bytes.NewReader(data), SaveOptions{})
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	checkSaveResult(t, result, data)
+	if !bytes.Equal(client.objects["small"], data) || len(client.uploads) != 0 {
+		t.Error("expected a single PutObject")
+	}
+}
+
+func TestS3Backend_PutMultipart(t *testing.T) {
+	client := newFakeS3()
+	backend := newS3Backend(client, S3Config{Bucket: "trove", PartSize: minPartSize, Concurrency: 2})
+
+	// Three full parts and a short one, read through a plain io.Reader
+	data := randomContent(t, 3*minPartSize+100)
+	result, err := backend.Put(context.Background(), "large", io.MultiReader(bytes.NewReader(data)), SaveOptions{})
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	checkSaveResult(t, result, data)
+	if !bytes.Equal(client.objects["large"], data) {
+		t.Error("stored object does not match the content")
+	}
+	if client.maxInFlight != 2 {
+		t.Errorf("expected 2 parts in flight at most, got %d", client.maxInFlight)
+	}
+
+	// Content of exactly one part is a one-part upload
+	data = randomContent(t, minPartSize)
+	result, err = backend.Put(context.Background(), "exact", bytes.NewReader(data), SaveOptions{})
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	checkSaveResult(t, result, data)
+}
+
+func TestS3Backend_PutAbortsFailedUpload(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeS3()
+	client.failPart = 2
+	backend := newS3Backend(client, S3Config{Bucket: "trove", PartSize: minPartSize, Concurrency: 3})
+
+	if _, err := backend.Put(ctx, "failed", bytes.NewReader(randomContent(t, 4*minPartSize)), SaveOptions{}); err == nil {
+		t.Fatal("expected Put to fail")
+	}
+	if len(client.uploads) != 0 || client.objects["failed"] != nil {
+		t.Errorf("failed upload was not aborted: %d uploads, object stored: %v", len(client.uploads), client.objects["failed"] != nil)
+	}
+
+	// A reader failing mid-upload aborts it too
+	client.failPart = 0
+	body := io.MultiReader(bytes.NewReader(randomContent(t, 2*minPartSize)), &failingReader{data: []byte("x"), failAfter: 0})
+	if _, err := backend.Put(ctx, "broken", body, SaveOptions{}); err == nil {
+		t.Fatal("expected Put to fail")
+	}
+	if len(client.uploads) != 0 {
+		t.Errorf("upload with a failed reader was not aborted")
+	}
+}
+
+func TestS3Backend_AbortStaleUploads(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeS3()
+	backend := newS3Backend(client, S3Config{Bucket: "trove"})
+
+	stale, _ := client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{Key: aws.String("stale")})
+	client.uploads[aws.ToString(stale.UploadId)].initiated = time.Now().Add(-48 * time.Hour)
+	_, _ = client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{Key: aws.String("in-progress")})
+
+	// Wrapping backends pass the sweep through
+	wrapped := newTestEncryptedBackend(t, backend, "k1:"+testKey(t), "")
+	aborted, err := wrapped.AbortStaleUploads(ctx, time.Now().Add(-24*time.Hour))
+	if err != nil || aborted != 1 {
+		t.Fatalf("AbortStaleUploads = %d, %v", aborted, err)
+	}
+	if len(client.uploads) != 1 {
+		t.Errorf("expected the in-progress upload to remain, have %d uploads", len(client.uploads))
+	}
+}
+
+func (f *fakeS3) ListParts(_ context.Context, in *s3.ListPartsInput, _ ...func(*s3.Options)) (*s3.ListPartsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	upload, ok := f.uploads[aws.ToString(in.UploadId)]
+	if !ok {
+		return nil, errors.New("NoSuchUpload")
+	}
+	var parts []types.Part
+	for number, data := range upload.parts {
+		sum := sha256.Sum256(data)
+		parts = append(parts, types.Part{
+			PartNumber:     aws.Int32(number),
+			ETag:           aws.String(fmt.Sprint(number)),
+			Size:           aws.Int64(int64(len(data))),
+			ChecksumSHA256: aws.String(base64.StdEncoding.EncodeToString(sum[:])),
+		})
+	}
+	return &s3.ListPartsOutput{Parts: parts}, nil
+}
+
+func TestS3Backend_Multipart(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeS3()
+	backend := newS3Backend(client, S3Config{Bucket: "trove"})
+
+	if _, err := backend.InitMultipart(ctx, 1024, SaveOptions{}); !errors.Is(err, ErrMultipartUnsupported) {
+		t.Errorf("expected parts under 5 MiB to be refused, got %v", err)
+	}
+
+	id, err := backend.InitMultipart(ctx, minPartSize, SaveOptions{OriginalFilename: "video.mp4"})
+	if err != nil {
+		t.Fatalf("InitMultipart failed: %v", err)
+	}
+	first, last := randomContent(t, minPartSize), []byte("tail")
+	if err := backend.PutPart(ctx, id, 2, bytes.NewReader(last), int64(len(last))); err != nil {
+		t.Fatalf("PutPart failed: %v", err)
+	}
+	if err := backend.PutPart(ctx, id, 1, bytes.NewReader(first), minPartSize); err != nil {
+		t.Fatalf("PutPart failed: %v", err)
+	}
+	result, err := backend.CompleteMultipart(ctx, id, 2)
+	if err != nil {
+		t.Fatalf("CompleteMultipart failed: %v", err)
+	}
+	if result.Size != int64(len(first)+len(last)) || !bytes.Equal(client.objects[result.Path], append(first, last...)) {
+		t.Errorf("completed object does not match its parts")
+	}
+}
+
+func TestS3Backend_PresignGet(t *testing.T) {
+	ctx := context.Background()
+	backend := newS3Backend(newFakeS3(), S3Config{Bucket: "trove", PresignExpiry: 5 * time.Minute})
+	if _, err := backend.PresignGet(ctx, "key", PresignOptions{}); !errors.Is(err, ErrPresignUnsupported) {
+		t.Fatalf("expected ErrPresignUnsupported without a presign client, got %v", err)
+	}
+
+	backend.presigner = s3.NewPresignClient(s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String("https://s3.example.com"),
+		UsePathStyle: true,
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}, nil
+		}),
+	}))
+	// The cache passes presigning through; encryption cannot
+	cached, err := newCachedBackend(backend, t.TempDir(), 1024, 4)
+	if err != nil {
+		t.Fatalf("newCachedBackend failed: %v", err)
+	}
+	raw, err := cached.PresignGet(ctx, "ab/key.txt", PresignOptions{
+		ContentType:        "text/plain",
+		ContentDisposition: `attachment; filename="notes.txt"`,
+	})
+	if err != nil {
+		t.Fatalf("PresignGet failed: %v", err)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatalf("invalid URL %q: %v", raw, err)
+	}
+	query := u.Query()
+	if u.Host != "s3.example.com" || u.Path != "/trove/ab/key.txt" {
+		t.Errorf("unexpected URL %q", raw)
+	}
+	if query.Get("X-Amz-Expires") != "300" ||
+		query.Get("response-content-type") != "text/plain" ||
+		query.Get("response-content-disposition") != `attachment; filename="notes.txt"` {
+		t.Errorf("unexpected query %v", query)
+	}
+
+	var encrypted StorageBackend = newTestEncryptedBackend(t, backend, "k1:"+testKey(t), "")
+	if _, ok := encrypted.(Presigner); ok {
+		t.Error("encrypted objects must not be presigned")
+	}
+}
@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"time"
 )
@@ -10,6 +11,19 @@ import (
 // Errors returned by storage backends.
 var (
 	ErrNotFound = errors.New("storage: file not found")
+
+	// ErrListUnsupported is returned by List on a backend that wraps one
+	// which cannot list its objects.
+	ErrListUnsupported = errors.New("storage: backend does not support listing")
+
+	// ErrMultipartUnsupported is returned by InitMultipart when a backend,
+	// or the backend it wraps, cannot assemble an object from parts of the
+	// requested size.
+	ErrMultipartUnsupported = errors.New("storage: backend does not support multipart uploads of this part size")
+
+	// ErrPresignUnsupported is returned by PresignGet when a backend, or
+	// the backend it wraps, does not hand out download URLs.
+	ErrPresignUnsupported = errors.New("storage: backend does not support presigned downloads")
 )
 
 // copyBufferSize is the buffer size used for file copies (8MB aligns with S3 multipart upload parts).
@@ -64,10 +78,135 @@ type StorageBackend interface {
 	ValidateAccess(ctx context.Context) error
 }
 
+// Lister is implemented by backends that can enumerate their objects. It is
+// optional: callers check for it with a type assertion and skip work that
+// needs it, such as garbage collection, when a backend does not support it.
+type Lister interface {
+	// List calls fn for every object in the backend, in no particular order.
+	// Paths are in the same form Save returns. Sizes are as stored, which
+	// for wrapping backends (e.g. encryption) may differ from what Stat
+	// reports. Listing stops at the first error fn returns.
+	List(ctx context.Context, fn func(FileInfo) error) error
+}
+
+// UploadSweeper is implemented by backends whose writes can leave hidden
+// state behind when a process dies mid-upload, such as S3 multipart
+// uploads. Wrapping backends implement it by delegating, doing nothing if
+// what they wrap does not.
+type UploadSweeper interface {
+	// AbortStaleUploads discards incomplete uploads started before cutoff
+	// and returns how many it discarded.
+	AbortStaleUploads(ctx context.Context, cutoff time.Time) (int, error)
+}
+
+// LayoutMigrator is implemented by backends whose storage layout has
+// changed between versions, to move objects written in an earlier layout.
+// Objects stay readable while they are moved. Wrapping backends implement
+// it by delegating, doing nothing if what they wrap does not.
+type LayoutMigrator interface {
+	// MigrateLayout moves objects stored in an earlier layout and returns
+	// how many it moved.
+	MigrateLayout(ctx context.Context) (int, error)
+}
+
+// MigrateLayout migrates b's layout if it is a LayoutMigrator.
+func MigrateLayout(ctx context.Context, b StorageBackend) (int, error) {
+	migrator, ok := b.(LayoutMigrator)
+	if !ok {
+		return 0, nil
+	}
+	return migrator.MigrateLayout(ctx)
+}
+
+// MultipartUploader is implemented by backends that can assemble an object
+// from parts sent separately, so a chunked upload can be streamed to storage
+// a chunk at a time instead of being assembled locally first. Parts are
+// numbered from 1, may be sent in any order, and sending a part again
+// replaces it. Uploads that are never completed or aborted are discarded by
+// AbortStaleUploads.
+type MultipartUploader interface {
+	// InitMultipart starts an upload whose parts, except the last, are
+	// partSize bytes (0 if there is only one part), and returns its ID.
+	InitMultipart(ctx context.Context, partSize int64, opts SaveOptions) (string, error)
+	// PutPart stores part number of the upload, which must be exactly size
+	// bytes.
+	PutPart(ctx context.Context, uploadID string, number int, r io.Reader, size int64) error
+	// CompleteMultipart joins parts 1 to parts into a new object. The
+	// result's Hash is empty; callers hash the content as they send it.
+	CompleteMultipart(ctx context.Context, uploadID string, parts int) (SaveResult, error)
+	// AbortMultipart discards the upload and its parts.
+	AbortMultipart(ctx context.Context, uploadID string) error
+}
+
+// asMultipart returns b as a MultipartUploader, for wrapping backends to
+// delegate to.
+func asMultipart(b StorageBackend) (MultipartUploader, error) {
+	uploader, ok := b.(MultipartUploader)
+	if !ok {
+		return nil, ErrMultipartUnsupported
+	}
+	return uploader, nil
+}
+
+// readPart reads a part that must be exactly size bytes.
+func readPart(r io.Reader, number int, size int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, size+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read part %d: %w", number, err)
+	}
+	if int64(len(data)) != size {
+		return nil, partSizeError(number, int64(len(data)), size)
+	}
+	return data, nil
+}
+
+func partSizeError(number int, got, want int64) error {
+	if got > want {
+		return fmt.Errorf("part %d is longer than %d bytes", number, want)
+	}
+	return fmt.Errorf("part %d is %d bytes, expected %d", number, got, want)
+}
+
+// PresignOptions sets the response headers the storage service sends with
+// an object downloaded from a presigned URL.
+type PresignOptions struct {
+	ContentType        string
+	ContentDisposition string
+}
+
+// Presigner is implemented by backends that can give clients a short-lived
+// URL to download an object directly, instead of through Trove. Backends
+// that support it only when configured to return ErrPresignUnsupported
+// otherwise.
+type Presigner interface {
+	// PresignGet returns a URL for a GET of the object at path.
+	PresignGet(ctx context.Context, path string, opts PresignOptions) (string, error)
+}
+
+// asPresigner returns b as a Presigner, for wrapping backends to delegate
+// to.
+func asPresigner(b StorageBackend) (Presigner, error) {
+	presigner, ok := b.(Presigner)
+	if !ok {
+		return nil, ErrPresignUnsupported
+	}
+	return presigner, nil
+}
+
+// abortStaleUploads sweeps b if it is an UploadSweeper.
+func abortStaleUploads(ctx context.Context, b StorageBackend, cutoff time.Time) (int, error) {
+	sweeper, ok := b.(UploadSweeper)
+	if !ok {
+		return 0, nil
+	}
+	return sweeper.AbortStaleUploads(ctx, cutoff)
+}
+
 // SaveOptions configures file saving.
 type SaveOptions struct {
 	OriginalFilename string // Used to extract extension for generated path
 	ContentType      string // MIME type (optional, for S3 metadata)
+	Policy           string // Storage policy to save under (PolicyBackend only; "" = default)
 }
 
 // SaveResult contains the result of a save operation.
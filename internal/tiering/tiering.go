@@ -0,0 +1,416 @@
+// Package tiering moves stored objects between a hot and a cold storage
+// policy (see storage.PolicyBackend) by how recently their files were read.
+// An object whose files have not been read for a while is demoted to the
+// cold policy; with promotion enabled, reading a file moves its object back.
+//
+// A move copies the object, verifies the copy against the recorded hash and
+// then points every file and blob at it in one transaction. Requests that
+// loaded a file just before that keep reading the old object, so it is only
+// deleted after a drain delay; if the process stops first, garbage
+// collection deletes it.
+package tiering
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/agjmills/trove/internal/database"
+	"github.com/agjmills/trove/internal/database/models"
+	"github.com/agjmills/trove/internal/logger"
+	"github.com/agjmills/trove/internal/metrics"
+	"github.com/agjmills/trove/internal/storage"
+)
+
+// defaultDrainDelay is how long an object that has been moved is kept for
+// reads that started before the move, unless Config sets it.
+const defaultDrainDelay = time.Minute
+
+// promoteInterval is how often files read since the last check are looked
+// for, when promotion is enabled.
+const promoteInterval = time.Minute
+
+// errGone is returned by move when nothing references the object any more.
+var errGone = errors.New("object is no longer referenced")
+
+// Config selects the policies objects move between and when.
+type Config struct {
+	Hot     string        // Policy objects are demoted from ("" = default backend)
+	Cold    string        // Policy objects are demoted to
+	After   time.Duration // Time without a read before an object is demoted
+	Promote bool          // Move objects back to Hot when their files are read
+	// How long a moved object is kept for reads that started before the
+	// move, such as a slow download or a presigned URL (0 = a minute)
+	DrainDelay time.Duration
+}
+
+// Tierer moves objects between the hot and cold policies of one backend.
+type Tierer struct {
+	db      *gorm.DB
+	storage storage.StorageBackend
+	cfg     Config
+	delay   time.Duration // Drain delay
+	pending []moved       // Old objects waiting out the drain delay
+}
+
+// moved is an object that has been moved, kept until the drain delay ends.
+type moved struct {
+	from, to string
+	at       time.Time
+}
+
+// Report describes a tiering run.
+type Report struct {
+	Demoted  int // Objects moved to the cold policy
+	Promoted int // Objects moved back to the hot policy
+	Bytes    int64
+	Failed   int
+}
+
+// object is a stored original and when any file referencing it was last
+// read or given it as a new version (or uploaded, if neither happened
+// since).
+type object struct {
+	StoragePath string
+	Hash        string
+	FileSize    int64
+	LastUsed    time.Time
+}
+
+// New returns a Tierer for backend, which must have both policies.
+func New(db *gorm.DB, backend storage.StorageBackend, cfg Config) (*Tierer, error) {
+	for _, policy := range []string{cfg.Hot, cfg.Cold} {
+		if !storage.HasPolicy(backend, policy) {
+			return nil, fmt.Errorf("storage policy %q is not configured", policy)
+		}
+	}
+	delay := cfg.DrainDelay
+	if delay <= 0 {
+		delay = defaultDrainDelay
+	}
+	return &Tierer{db: db, storage: backend, cfg: cfg, delay: delay}, nil
+}
+
+// objects returns the stored originals, with when each was last used.
+func (t *Tierer) objects() ([]object, error) {
+	var rows []struct {
+		StoragePath string
+		Hash        string
+		FileSize    int64
+		LastUsed    string
+		LastUpdated *string // When a new version last replaced the content, if ever
+	}
+	// Timestamps are compared after scanning, since SQLite returns the
+	// aggregate as text
+	err := t.db.Unscoped().Model(&models.File{}).
+		Select("storage_path, MAX(hash) AS hash, MAX(file_size) AS file_size, "+
+			"MAX(COALESCE(last_accessed_at, created_at)) AS last_used, MAX(content_updated_at) AS last_updated").
+		Where("upload_status = ? AND storage_path <> ''", "completed").
+		Group("storage_path").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]object, 0, len(rows))
+	for _, row := range rows {
+		lastUsed, err := parseTime(row.LastUsed)
+		if err != nil {
+			return nil, fmt.Errorf("object %s: %w", row.StoragePath, err)
+		}
+		if row.LastUpdated != nil {
+			updated, err := parseTime(*row.LastUpdated)
+			if err != nil {
+				return nil, fmt.Errorf("object %s: %w", row.StoragePath, err)
+			}
+			if updated.After(lastUsed) {
+				lastUsed = updated
+			}
+		}
+		objects = append(objects, object{StoragePath: row.StoragePath, Hash: row.Hash, FileSize: row.FileSize, LastUsed: lastUsed})
+	}
+	return objects, nil
+}
+
+// timeLayouts are the forms databases return timestamps in as text.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+}
+
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised timestamp %q", s)
+}
+
+// Run demotes hot objects whose files have not been read within the
+// configured time and, with promotion enabled, promotes cold objects whose
+// files have. The objects it moved are deleted once the drain delay has
+// passed, by a later Run or by Schedule while it waits.
+func (t *Tierer) Run(ctx context.Context) (Report, error) {
+	var report Report
+	objects, err := t.objects()
+	if err != nil {
+		return report, fmt.Errorf("failed to load stored objects: %w", err)
+	}
+
+	cutoff := time.Now().Add(-t.cfg.After)
+	for _, obj := range objects {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		policy := storage.PolicyFor(t.storage, obj.StoragePath)
+		switch {
+		case policy == t.cfg.Hot && obj.LastUsed.Before(cutoff):
+			t.tier(ctx, obj, t.cfg.Cold, "demoted", &report)
+		case policy == t.cfg.Cold && t.cfg.Promote && !obj.LastUsed.Before(cutoff):
+			t.tier(ctx, obj, t.cfg.Hot, "promoted", &report)
+		}
+	}
+
+	t.drain()
+	metrics.TieringLastRunTimestamp.Set(float64(time.Now().Unix()))
+	return report, nil
+}
+
+// Promote moves the cold objects of files read since since back to the hot
+// policy.
+func (t *Tierer) Promote(ctx context.Context, since time.Time) (Report, error) {
+	var report Report
+	var paths []string
+	if err := t.db.Model(&models.File{}).
+		Where("upload_status = ? AND last_accessed_at >= ?", "completed", since).
+		Distinct().
+		Pluck("storage_path", &paths).Error; err != nil {
+		return report, err
+	}
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		if storage.PolicyFor(t.storage, path) != t.cfg.Cold {
+			continue
+		}
+		var obj object
+		if err := t.db.Model(&models.File{}).
+			Select("storage_path, hash, file_size").
+			Where("storage_path = ? AND upload_status = ?", path, "completed").
+			Limit(1).
+			Scan(&obj).Error; err != nil {
+			report.Failed++
+			logger.Error("failed to load object to promote", "path", path, "error", err)
+			continue
+		}
+		if obj.StoragePath == "" {
+			continue // Moved since
+		}
+		t.tier(ctx, obj, t.cfg.Hot, "promoted", &report)
+	}
+	return report, nil
+}
+
+// tier moves one object to policy, recording the outcome.
+func (t *Tierer) tier(ctx context.Context, obj object, policy, direction string, report *Report) {
+	newPath, err := t.move(ctx, obj, policy)
+	if errors.Is(err, errGone) {
+		return
+	}
+	if err != nil {
+		report.Failed++
+		logger.Error("failed to move object between storage tiers", "path", obj.StoragePath, "direction", direction, "error", err)
+		return
+	}
+	if direction == "demoted" {
+		report.Demoted++
+	} else {
+		report.Promoted++
+	}
+	report.Bytes += obj.FileSize
+	metrics.TieringObjectsMoved.WithLabelValues(direction).Inc()
+	metrics.TieringBytesMoved.WithLabelValues(direction).Add(float64(obj.FileSize))
+	logger.Info("moved object between storage tiers", "from", obj.StoragePath, "to", newPath, "direction", direction)
+}
+
+// move copies an object to policy, verifies the copy and points its files
+// and blob at it, returning the new path. The old object is deleted by
+// drain.
+func (t *Tierer) move(ctx context.Context, obj object, policy string) (string, error) {
+	r, err := t.storage.Open(ctx, obj.StoragePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open object: %w", err)
+	}
+	defer r.Close() //nolint:errcheck
+
+	info, err := t.storage.Stat(ctx, obj.StoragePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat object: %w", err)
+	}
+	hasher := sha256.New()
+	result, err := t.storage.Save(ctx, io.TeeReader(r, hasher), storage.SaveOptions{
+		OriginalFilename: obj.StoragePath,
+		ContentType:      info.ContentType,
+		Policy:           policy,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to copy object: %w", err)
+	}
+
+	// Check the source against its recorded hash, then the copy against
+	// the source
+	want := hex.EncodeToString(hasher.Sum(nil))
+	if obj.Hash != "" && want != obj.Hash {
+		t.discard(result.Path)
+		return "", fmt.Errorf("object does not match its recorded hash (got %s, want %s)", want, obj.Hash)
+	}
+	got, err := hashObject(ctx, t.storage, result.Path)
+	if err != nil {
+		t.discard(result.Path)
+		return "", fmt.Errorf("failed to read back copy: %w", err)
+	}
+	if got != want {
+		t.discard(result.Path)
+		return "", fmt.Errorf("copy does not match (got %s, want %s)", got, want)
+	}
+
+	err = t.db.Transaction(func(tx *gorm.DB) error {
+		referenced, err := database.IsReferenced(tx, obj.StoragePath)
+		if err != nil {
+			return err
+		}
+		if !referenced {
+			return errGone
+		}
+		return database.ReplaceStoragePath(tx, obj.StoragePath, result.Path)
+	})
+	if err != nil {
+		t.discard(result.Path)
+		return "", err
+	}
+	t.pending = append(t.pending, moved{from: obj.StoragePath, to: result.Path, at: time.Now()})
+	return result.Path, nil
+}
+
+// drain deletes the old objects of moves older than the drain delay.
+func (t *Tierer) drain() {
+	remaining := t.pending[:0]
+	for _, m := range t.pending {
+		if time.Since(m.at) < t.delay {
+			remaining = append(remaining, m)
+			continue
+		}
+		t.deleteOld(m)
+	}
+	t.pending = remaining
+}
+
+// deleteOld deletes the object a move copied from. Files that took a
+// reference to it during the move, by deduplicating against its blob just
+// before the switch, are pointed at the copy first.
+func (t *Tierer) deleteOld(m moved) {
+	referenced := false
+	err := t.db.Transaction(func(tx *gorm.DB) error {
+		if err := database.ReplaceStoragePath(tx, m.from, m.to); err != nil {
+			return err
+		}
+		var err error
+		referenced, err = database.IsReferenced(tx, m.from)
+		return err
+	})
+	if err != nil || referenced {
+		logger.Warn("keeping object moved between storage tiers", "path", m.from, "error", err)
+		return
+	}
+	if err := t.storage.Delete(context.Background(), m.from); err != nil {
+		logger.Warn("failed to delete object moved between storage tiers", "path", m.from, "error", err)
+	}
+}
+
+// discard deletes a copy that will not be used.
+func (t *Tierer) discard(path string) {
+	if err := t.storage.Delete(context.Background(), path); err != nil {
+		logger.Warn("failed to delete unused copy", "path", path, "error", err)
+	}
+}
+
+func hashObject(ctx context.Context, backend storage.StorageBackend, path string) (string, error) {
+	r, err := backend.Open(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close() //nolint:errcheck
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// Schedule runs tiering now and then every interval until ctx is
+// cancelled, logging the results. With promotion enabled it also promotes
+// the objects of files read since the last check every minute.
+func (t *Tierer) Schedule(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var promote <-chan time.Time
+	if t.cfg.Promote {
+		promoteTicker := time.NewTicker(promoteInterval)
+		defer promoteTicker.Stop()
+		promote = promoteTicker.C
+	}
+
+	for {
+		since := time.Now()
+		report, err := t.Run(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Error("storage tiering failed", "error", err)
+		} else {
+			logger.Info("storage tiering finished",
+				"demoted", report.Demoted,
+				"promoted", report.Promoted,
+				"moved_bytes", report.Bytes,
+				"failed", report.Failed,
+			)
+		}
+
+	wait:
+		for {
+			// Old objects are deleted while waiting, once the drain delay
+			// of the oldest has passed
+			var due <-chan time.Time
+			if len(t.pending) > 0 {
+				due = time.After(time.Until(t.pending[0].at.Add(t.delay)))
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				break wait
+			case <-promote:
+				next := time.Now()
+				if _, err := t.Promote(ctx, since); err != nil && ctx.Err() == nil {
+					logger.Error("storage tier promotion failed", "error", err)
+				} else {
+					since = next
+				}
+				t.drain()
+			case <-due:
+				t.drain()
+			}
+		}
+	}
+}
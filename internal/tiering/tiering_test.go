@@ -0,0 +1,314 @@
+package tiering
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/agjmills/trove/internal/database/models"
+	"github.com/agjmills/trove/internal/storage"
+)
+
+func newTieringTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := fmt.Sprintf("file:tiering-%d?mode=memory&cache=shared", time.Now().UnixNano())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to open test db: %v", err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.File{}, &models.FileVersion{}, &models.Blob{}); err != nil {
+		t.Fatalf("failed to migrate test db: %v", err)
+	}
+	return db
+}
+
+func newTieringBackend(t *testing.T) storage.StorageBackend {
+	t.Helper()
+	backend, err := storage.NewPolicyBackend(storage.NewMemoryBackend(),
+		storage.Member{Name: "cold", Backend: storage.NewMemoryBackend()})
+	if err != nil {
+		t.Fatalf("NewPolicyBackend: %v", err)
+	}
+	return backend
+}
+
+// addFile saves content under policy and records a file for it, last read
+// at lastRead (never, if zero) and uploaded long before.
+func addFile(t *testing.T, db *gorm.DB, backend storage.StorageBackend, policy, content string, lastRead time.Time) models.File {
+	t.Helper()
+	result, err := backend.Save(context.Background(), strings.NewReader(content), storage.SaveOptions{OriginalFilename: "f.bin", Policy: policy})
+	if err != nil {
+		t.Fatalf("failed to save object: %v", err)
+	}
+	sum := sha256.Sum256([]byte(content))
+	file := models.File{
+		UserID:       1,
+		StoragePath:  result.Path,
+		LogicalPath:  "/",
+		Filename:     "f.bin",
+		FileSize:     int64(len(content)),
+		Hash:         hex.EncodeToString(sum[:]),
+		UploadStatus: "completed",
+	}
+	if !lastRead.IsZero() {
+		file.LastAccessedAt = &lastRead
+	}
+	if err := db.Create(&file).Error; err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	old := time.Now().Add(-90 * 24 * time.Hour)
+	if err := db.Model(&file).UpdateColumn("created_at", old).Error; err != nil {
+		t.Fatalf("failed to backdate file: %v", err)
+	}
+	return file
+}
+
+func read(t *testing.T, backend storage.StorageBackend, path string) string {
+	t.Helper()
+	r, err := backend.Open(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Open(%s): %v", path, err)
+	}
+	defer r.Close() //nolint:errcheck
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll(%s): %v", path, err)
+	}
+	return string(data)
+}
+
+func newTestTierer(t *testing.T, db *gorm.DB, backend storage.StorageBackend, promote bool) *Tierer {
+	t.Helper()
+	tierer, err := New(db, backend, Config{Cold: "cold", After: 30 * 24 * time.Hour, Promote: promote})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	tierer.delay = 0
+	return tierer
+}
+
+func TestTiererDemotesUnreadObjects(t *testing.T) {
+	ctx := context.Background()
+	db := newTieringTestDB(t)
+	backend := newTieringBackend(t)
+
+	unread := addFile(t, db, backend, "", "never read", time.Time{})
+	stale := addFile(t, db, backend, "", "read long ago", time.Now().Add(-60*24*time.Hour))
+	recent := addFile(t, db, backend, "", "read today", time.Now())
+
+	report, err := newTestTierer(t, db, backend, false).Run(ctx)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if report.Demoted != 2 || report.Promoted != 0 || report.Failed != 0 {
+		t.Fatalf("report = %+v, want 2 demoted", report)
+	}
+
+	for _, f := range []models.File{unread, stale} {
+		var got models.File
+		db.First(&got, f.ID)
+		if storage.PolicyOf(got.StoragePath) != "cold" {
+			t.Errorf("file %d is at %q, want it in the cold policy", f.ID, got.StoragePath)
+		}
+		if got := read(t, backend, got.StoragePath); got != map[uint]string{unread.ID: "never read", stale.ID: "read long ago"}[f.ID] {
+			t.Errorf("file %d content = %q after demotion", f.ID, got)
+		}
+		if _, err := backend.Stat(ctx, f.StoragePath); err == nil {
+			t.Errorf("old object %s of file %d was not deleted", f.StoragePath, f.ID)
+		}
+	}
+
+	var got models.File
+	db.First(&got, recent.ID)
+	if got.StoragePath != recent.StoragePath {
+		t.Errorf("recently read file moved to %q", got.StoragePath)
+	}
+}
+
+func TestTiererKeepsNewVersionsHot(t *testing.T) {
+	ctx := context.Background()
+	db := newTieringTestDB(t)
+	backend := newTieringBackend(t)
+
+	// An old file that was never read just got a new version
+	file := addFile(t, db, backend, "", "first version", time.Time{})
+	version := addFile(t, db, backend, "", "second version", time.Time{})
+	db.Unscoped().Delete(&models.File{}, version.ID)
+	now := time.Now()
+	db.Model(&file).Updates(map[string]interface{}{"storage_path": version.StoragePath, "hash": version.Hash, "content_updated_at": now})
+
+	report, err := newTestTierer(t, db, backend, false).Run(ctx)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if report.Demoted != 0 || report.Failed != 0 {
+		t.Errorf("report = %+v, want the new version left in the hot policy", report)
+	}
+	var got models.File
+	db.First(&got, file.ID)
+	if got.StoragePath != version.StoragePath {
+		t.Errorf("new version moved to %q", got.StoragePath)
+	}
+}
+
+func TestTiererPromotesReadObjects(t *testing.T) {
+	ctx := context.Background()
+	db := newTieringTestDB(t)
+	backend := newTieringBackend(t)
+
+	file := addFile(t, db, backend, "cold", "archived", time.Time{})
+	tierer := newTestTierer(t, db, backend, true)
+
+	// Not read since being demoted
+	report, err := tierer.Run(ctx)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if report.Promoted != 0 {
+		t.Fatalf("report = %+v, want nothing promoted", report)
+	}
+
+	since := time.Now()
+	db.Model(&file).UpdateColumn("last_accessed_at", time.Now())
+	report, err = tierer.Promote(ctx, since)
+	if err != nil {
+		t.Fatalf("Promote: %v", err)
+	}
+	if report.Promoted != 1 {
+		t.Fatalf("report = %+v, want 1 promoted", report)
+	}
+	tierer.drain()
+
+	var got models.File
+	db.First(&got, file.ID)
+	if storage.PolicyOf(got.StoragePath) != "" {
+		t.Errorf("file is at %q, want it in the default backend", got.StoragePath)
+	}
+	if content := read(t, backend, got.StoragePath); content != "archived" {
+		t.Errorf("content = %q after promotion", content)
+	}
+	if _, err := backend.Stat(ctx, file.StoragePath); err == nil {
+		t.Errorf("old object %s was not deleted", file.StoragePath)
+	}
+}
+
+func TestTiererKeepsObjectUntilDrained(t *testing.T) {
+	ctx := context.Background()
+	db := newTieringTestDB(t)
+	backend := newTieringBackend(t)
+
+	file := addFile(t, db, backend, "", "in use", time.Time{})
+	blob := models.Blob{Hash: file.Hash, StoragePath: file.StoragePath, Size: file.FileSize, RefCount: 1}
+	if err := db.Create(&blob).Error; err != nil {
+		t.Fatalf("failed to create blob: %v", err)
+	}
+
+	tierer := newTestTierer(t, db, backend, false)
+	tierer.delay = time.Hour
+	var report Report
+	objects, err := tierer.objects()
+	if err != nil {
+		t.Fatalf("objects: %v", err)
+	}
+	tierer.tier(ctx, objects[0], "cold", "demoted", &report)
+	if report.Demoted != 1 {
+		t.Fatalf("report = %+v, want 1 demoted", report)
+	}
+
+	// A read that loaded the file before the move can still open it
+	if content := read(t, backend, file.StoragePath); content != "in use" {
+		t.Errorf("old object content = %q", content)
+	}
+	tierer.drain()
+	if _, err := backend.Stat(ctx, file.StoragePath); err != nil {
+		t.Errorf("old object deleted before the drain delay: %v", err)
+	}
+
+	var got models.Blob
+	db.First(&got, "hash = ?", blob.Hash)
+	if storage.PolicyOf(got.StoragePath) != "cold" {
+		t.Errorf("blob is at %q, want it in the cold policy", got.StoragePath)
+	}
+
+	tierer.delay = 0
+	tierer.drain()
+	if _, err := backend.Stat(ctx, file.StoragePath); err == nil {
+		t.Error("old object was not deleted after the drain delay")
+	}
+}
+
+func TestTiererRejectsCorruptObjects(t *testing.T) {
+	ctx := context.Background()
+	db := newTieringTestDB(t)
+	backend := newTieringBackend(t)
+
+	file := addFile(t, db, backend, "", "original", time.Time{})
+	db.Model(&file).UpdateColumn("hash", strings.Repeat("0", 64))
+
+	report, err := newTestTierer(t, db, backend, false).Run(ctx)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if report.Demoted != 0 || report.Failed != 1 {
+		t.Fatalf("report = %+v, want 1 failed", report)
+	}
+
+	var got models.File
+	db.First(&got, file.ID)
+	if got.StoragePath != file.StoragePath {
+		t.Errorf("corrupt object moved to %q", got.StoragePath)
+	}
+	count := 0
+	backend.(storage.Lister).List(ctx, func(storage.FileInfo) error { //nolint:errcheck
+		count++
+		return nil
+	})
+	if count != 1 {
+		t.Errorf("%d objects stored, want the unused copy deleted", count)
+	}
+}
+
+func TestNewRejectsUnknownPolicy(t *testing.T) {
+	db := newTieringTestDB(t)
+	if _, err := New(db, newTieringBackend(t), Config{Cold: "glacier"}); err == nil {
+		t.Fatal("New accepted a policy that is not configured")
+	}
+	if _, err := New(db, storage.NewMemoryBackend(), Config{Cold: "cold"}); err == nil {
+		t.Fatal("New accepted a backend without policies")
+	}
+}
+
+func TestRunDoesNotWaitForDrainDelay(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	db := newTieringTestDB(t)
+	backend := newTieringBackend(t)
+	file := addFile(t, db, backend, "", "never read", time.Time{})
+
+	tierer, err := New(db, backend, Config{Cold: "cold", After: 30 * 24 * time.Hour, DrainDelay: 7 * 24 * time.Hour})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if tierer.delay != 7*24*time.Hour {
+		t.Fatalf("delay = %v, want the configured drain delay", tierer.delay)
+	}
+	report, err := tierer.Run(ctx)
+	if err != nil || report.Demoted != 1 {
+		t.Fatalf("Run = %+v, %v", report, err)
+	}
+	// A presigned URL handed out before the move still works
+	if content := read(t, backend, file.StoragePath); content != "never read" {
+		t.Errorf("old object content = %q", content)
+	}
+}
@@ -261,6 +261,7 @@ func (w *Worker) storeVariant(ctx context.Context, job *models.TranscodeJob, fil
 	saveResult, err := w.storage.Save(ctx, outputFile, storage.SaveOptions{
 		OriginalFilename: base + ".mp4",
 		ContentType:      "video/mp4",
+		Policy:           storage.PolicyFor(w.storage, file.StoragePath), // Keep the variant with the original
 	})
 	if err != nil {
 		return fmt.Errorf("failed to store variant: %w", err)
@@ -283,14 +284,19 @@ func (w *Worker) storeVariant(ctx context.Context, job *models.TranscodeJob, fil
 // original object (DecisionSkip) so usage isn't double-counted.
 func (w *Worker) finish(job *models.TranscodeJob, file models.File, variantPath string, variantSize int64, variantMime string) error {
 	return w.db.Transaction(func(tx *gorm.DB) error {
-		if err := tx.Model(&models.File{}).Where("id = ?", file.ID).Updates(map[string]interface{}{
+		// A new version of the file may have been uploaded meanwhile
+		res := tx.Model(&models.File{}).Where("id = ? AND hash = ?", file.ID, file.Hash).Updates(map[string]interface{}{
 			"video_variant_path": variantPath,
 			"video_variant_size": variantSize,
 			"video_variant_mime": variantMime,
 			"transcode_status":   StatusCompleted,
 			"transcode_error":    "",
-		}).Error; err != nil {
-			return fmt.Errorf("failed to update file record: %w", err)
+		})
+		if res.Error != nil {
+			return fmt.Errorf("failed to update file record: %w", res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return fmt.Errorf("file %d changed while transcoding", file.ID)
 		}
 
 		if variantPath != file.StoragePath {
@@ -17,14 +17,15 @@ class ChunkedUploadManager {
 	/**
 	 * Start a chunked upload
 	 * @param {File} file - File to upload
-	 * @param {Object} options - Upload options (folder, etc.)
+	 * @param {Object} options - Upload options (folder, relativePath for a file
+	 *   of a folder upload, etc.)
 	 * @returns {Promise<string>} uploadId
 	 */
 	async startUpload(file, options = {}) {
-		const { folder = '/', tags = [], onProgress, onComplete, onError, onCancel } = options;
+		const { folder = '/', relativePath = '', tags = [], onProgress, onComplete, onError, onCancel } = options;
 
-		// Calculate chunks
-		const totalChunks = Math.ceil(file.size / this.chunkSize);
+		// Calculate chunks (an empty file is sent as one empty chunk)
+		const totalChunks = Math.max(1, Math.ceil(file.size / this.chunkSize));
 
 		// Calculate file hash (optional but recommended for integrity)
 		let fileHash = '';
@@ -45,6 +46,7 @@ class ChunkedUploadManager {
 				chunk_size: this.chunkSize,
 				total_chunks: totalChunks,
 				logical_path: folder,
+				relative_path: relativePath || undefined,
 				mime_type: file.type || 'application/octet-stream',
 				hash: fileHash,
 				tags: tags.length > 0 ? tags : undefined,